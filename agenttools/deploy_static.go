@@ -54,6 +54,11 @@ type StaticPreviewDeployInput struct {
 	// reuse (just re-upload — the CachingDisabled policy serves it immediately).
 	ExistingDistID string
 
+	// Authorization, when set, is the Basic auth header value viewers must send;
+	// the preview's viewer-request function answers 401 without it. Empty keeps
+	// (or makes) the preview public.
+	Authorization string
+
 	S3Client         *s3.Client
 	CloudfrontClient *cloudfront.Client
 }
@@ -94,10 +99,8 @@ func DeployStaticSitePreview(in StaticPreviewDeployInput, logsWriter io.Writer)
 		return StaticPreviewDeployResult{}, fmt.Errorf("ensure preview bucket: %w", err)
 	}
 
-	// Re-upload the freshly built site (clear stale objects first).
-	if err = aws_utils.DeleteAllS3Files(in.S3Client, bucketName); err != nil {
-		return StaticPreviewDeployResult{}, fmt.Errorf("clear preview bucket: %w", err)
-	}
+	// Sync the freshly built site: unchanged files are skipped and stale
+	// objects deleted, so an iteration only uploads what the agent changed.
 	io.WriteString(logsWriter, fmt.Sprintf("Uploading preview to S3 bucket: %s\n", bucketName))
 	if err = aws_utils.UploadToS3(in.DistDirectory, in.Region, bucketName, in.S3Client, logsWriter); err != nil {
 		return StaticPreviewDeployResult{}, fmt.Errorf("upload preview: %w", err)
@@ -105,10 +108,20 @@ func DeployStaticSitePreview(in StaticPreviewDeployInput, logsWriter io.Writer)
 
 	cf := in.CloudfrontClient
 
+	var functionARN string
+	if in.Authorization != "" {
+		if functionARN, err = publishPreviewAuthFunction(cf, in.PreviewID, in.Authorization, logsWriter); err != nil {
+			return StaticPreviewDeployResult{}, fmt.Errorf("publish preview password check: %w", err)
+		}
+	}
+
 	// Reuse path: the distribution already serves this bucket. It uses the managed
 	// CachingDisabled policy, so the re-uploaded content is served immediately — no
-	// invalidation needed.
+	// invalidation needed. Only a change of protection updates the distribution.
 	if in.ExistingDistID != "" {
+		if err = updatePreviewAuthFunction(cf, in.ExistingDistID, functionARN, logsWriter); err != nil {
+			return StaticPreviewDeployResult{}, fmt.Errorf("update preview password check: %w", err)
+		}
 		io.WriteString(logsWriter, fmt.Sprintf("Redeployed preview to existing distribution: %s\n", in.ExistingDistID))
 		return StaticPreviewDeployResult{DistributionID: in.ExistingDistID}, nil
 	}
@@ -120,6 +133,7 @@ func DeployStaticSitePreview(in StaticPreviewDeployInput, logsWriter io.Writer)
 		return StaticPreviewDeployResult{}, fmt.Errorf("create OAC: %w", err)
 	}
 	behavior := aws_utils.CreateDefaultCacheBehavior(bucketLocation, aws.String(cachingDisabledPolicyID))
+	setViewerRequestFunction(behavior, functionARN)
 	s3Domain := bucketName + ".s3." + in.Region + ".amazonaws.com"
 	distConfig := buildPreviewDistributionConfig(bucketLocation, oacID, in.PreviewID, s3Domain, behavior, in.IsSPA)
 
@@ -25,6 +25,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	agentmcp "github.com/deployment-io/deployment-runner/agent_mcp"
+	"github.com/deployment-io/deployment-runner/utils/aws_utils"
 )
 
 const (
@@ -50,6 +51,9 @@ type DeployStaticSitePreviewDeps struct {
 	// it — the control-plane seam. Bound to one serviceType by the commands layer, so a
 	// web-service or database preview tool reuses this same struct with its own store.
 	Store PreviewStore
+
+	// Protected puts the preview behind a per-preview password (see preview_access.go).
+	Protected bool
 }
 
 // deployStaticSitePreviewResult is the JSON the agent receives from a tools/call.
@@ -57,6 +61,7 @@ type deployStaticSitePreviewResult struct {
 	URL            string `json:"url"`
 	Status         string `json:"status"`
 	DistributionID string `json:"distribution_id"`
+	Protected      bool   `json:"protected,omitempty"`
 	Note           string `json:"note,omitempty"`
 	LogTail        string `json:"log_tail,omitempty"`
 }
@@ -126,6 +131,21 @@ func handleDeployStaticSitePreview(ctx context.Context, deps DeployStaticSitePre
 		return "", fmt.Errorf("build cloud clients: %w", err)
 	}
 
+	// A password this runner set on the preview is kept; otherwise a new one is
+	// generated (first deploy, or a preview another runner process deployed).
+	var password, authorization string
+	if deps.Protected {
+		if existing.CloudFrontDomainName != "" {
+			authorization = previewAuthorization(existing.CloudFrontDomainName)
+		}
+		if authorization == "" {
+			if password, err = aws_utils.NewPreviewAccessPassword(); err != nil {
+				return "", err
+			}
+			authorization = aws_utils.GetBasicAuthorization(aws_utils.PreviewAccessUsername, password)
+		}
+	}
+
 	var tail bytes.Buffer
 	logs := io.MultiWriter(deps.LogsWriter, &tail)
 	res, err := DeployStaticSitePreview(StaticPreviewDeployInput{
@@ -138,6 +158,7 @@ func handleDeployStaticSitePreview(ctx context.Context, deps DeployStaticSitePre
 		S3Client:         s3Client,
 		CloudfrontClient: cfClient,
 		SkipDeployWait:   true, // return promptly; the CDN propagates async (verify_preview_reachable polls)
+		Authorization:    authorization,
 	}, logs)
 	if err != nil {
 		return "", fmt.Errorf("deploy preview: %w", err)
@@ -153,11 +174,13 @@ func handleDeployStaticSitePreview(ctx context.Context, deps DeployStaticSitePre
 	if domain == "" {
 		domain = existing.CloudFrontDomainName
 	}
-	if res.DomainName != "" {
+	rememberPreviewAuthorization(domain, authorization)
+	if res.DomainName != "" || password != "" {
 		deps.Store.SavePreview(previewID, PreviewState{
 			CloudFrontDistributionID:  res.DistributionID,
 			CloudFrontDistributionArn: res.DistributionArn,
 			CloudFrontDomainName:      res.DomainName,
+			AccessPassword:            password,
 		})
 	}
 
@@ -165,9 +188,13 @@ func handleDeployStaticSitePreview(ctx context.Context, deps DeployStaticSitePre
 		URL:            "https://" + domain,
 		Status:         "deployed",
 		DistributionID: distID,
+		Protected:      deps.Protected,
 		Note:           "CDN propagation may take a few minutes before the URL serves the latest content.",
 		LogTail:        tailString(tail.Bytes(), logTailMaxBytes),
 	}
+	if deps.Protected {
+		out.Note += " The preview is password protected; verify_preview_reachable passes the check, and the user finds the credentials on the preview."
+	}
 	b, err := json.Marshal(out)
 	if err != nil {
 		return "", err
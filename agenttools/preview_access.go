@@ -0,0 +1,166 @@
+package agenttools
+
+// preview_access.go protects a static preview with a password: a CloudFront
+// viewer-request function answers 401 unless the viewer sends the preview's
+// Basic auth credentials. The password never reaches the agent — the deploy tool
+// persists it on the preview record for the user, and remembers it here by preview
+// host so verify_preview_reachable (same runner process) can pass the check.
+//
+// The runner can't read the password back from the record, so a runner that
+// redeploys a preview it didn't deploy before generates a new one: credentials are
+// per preview and rotate whenever a different runner process takes it over.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	cloudfrontTypes "github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+	"github.com/deployment-io/deployment-runner/utils/aws_utils"
+)
+
+// previewAuthorizations maps a preview host (e.g. d123.cloudfront.net) to the
+// Authorization header value its viewer-request function expects.
+var previewAuthorizations = struct {
+	sync.Mutex
+	byHost map[string]string
+}{byHost: make(map[string]string)}
+
+// rememberPreviewAuthorization records the credentials of host; an empty
+// authorization forgets them (the preview is public again).
+func rememberPreviewAuthorization(host, authorization string) {
+	host = strings.ToLower(host)
+	previewAuthorizations.Lock()
+	defer previewAuthorizations.Unlock()
+	if authorization == "" {
+		delete(previewAuthorizations.byHost, host)
+		return
+	}
+	previewAuthorizations.byHost[host] = authorization
+}
+
+// previewAuthorization returns the credentials for host, "" when the preview is
+// public or was deployed by another runner process.
+func previewAuthorization(host string) string {
+	previewAuthorizations.Lock()
+	defer previewAuthorizations.Unlock()
+	return previewAuthorizations.byHost[strings.ToLower(host)]
+}
+
+// previewFunctionName matches deploy_aws_cloudfront_viewer_request_function's
+// viewer-request-<deploymentID>, so deleting the preview deletes the function too.
+func previewFunctionName(previewID string) string {
+	return "viewer-request-" + previewID
+}
+
+// publishPreviewAuthFunction creates or updates the preview's password check and
+// publishes it. A distribution already using the function picks up the new
+// password without a distribution update.
+func publishPreviewAuthFunction(cf *cloudfront.Client, previewID, authorization string, logsWriter io.Writer) (string, error) {
+	name := previewFunctionName(previewID)
+	code := []byte(aws_utils.GetBasicAuthViewerRequestFunctionCode(authorization))
+	config := &cloudfrontTypes.FunctionConfig{
+		Comment: aws.String("Password check for preview " + previewID),
+		Runtime: cloudfrontTypes.FunctionRuntimeCloudfrontJs10,
+	}
+	var etag *string
+	describeOutput, err := cf.DescribeFunction(context.TODO(), &cloudfront.DescribeFunctionInput{Name: aws.String(name)})
+	var noSuchFunction *cloudfrontTypes.NoSuchFunctionExists
+	switch {
+	case errors.As(err, &noSuchFunction):
+		io.WriteString(logsWriter, fmt.Sprintf("Creating preview password check: %s\n", name))
+		out, err := cf.CreateFunction(context.TODO(), &cloudfront.CreateFunctionInput{
+			Name:           aws.String(name),
+			FunctionCode:   code,
+			FunctionConfig: config,
+		})
+		if err != nil {
+			return "", err
+		}
+		etag = out.ETag
+	case err != nil:
+		return "", err
+	default:
+		io.WriteString(logsWriter, fmt.Sprintf("Updating preview password check: %s\n", name))
+		out, err := cf.UpdateFunction(context.TODO(), &cloudfront.UpdateFunctionInput{
+			Name:           aws.String(name),
+			FunctionCode:   code,
+			FunctionConfig: config,
+			IfMatch:        describeOutput.ETag,
+		})
+		if err != nil {
+			return "", err
+		}
+		etag = out.ETag
+	}
+	out, err := cf.PublishFunction(context.TODO(), &cloudfront.PublishFunctionInput{
+		Name:    aws.String(name),
+		IfMatch: etag,
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.FunctionSummary.FunctionMetadata.FunctionARN), nil
+}
+
+// setViewerRequestFunction makes functionARN the behavior's only viewer-request
+// function, removing it when functionARN is empty, and reports whether the
+// behavior changed. Functions for other events are kept.
+func setViewerRequestFunction(behavior *cloudfrontTypes.DefaultCacheBehavior, functionARN string) bool {
+	var items []cloudfrontTypes.FunctionAssociation
+	var current string
+	if behavior.FunctionAssociations != nil {
+		for _, item := range behavior.FunctionAssociations.Items {
+			if item.EventType == cloudfrontTypes.EventTypeViewerRequest {
+				current = aws.ToString(item.FunctionARN)
+				continue
+			}
+			items = append(items, item)
+		}
+	}
+	if current == functionARN {
+		return false
+	}
+	if functionARN != "" {
+		items = append(items, cloudfrontTypes.FunctionAssociation{
+			EventType:   cloudfrontTypes.EventTypeViewerRequest,
+			FunctionARN: aws.String(functionARN),
+		})
+	}
+	behavior.FunctionAssociations = &cloudfrontTypes.FunctionAssociations{
+		Quantity: aws.Int32(int32(len(items))),
+		Items:    items,
+	}
+	return true
+}
+
+// updatePreviewAuthFunction attaches (or, with an empty functionARN, detaches) the
+// password check on an existing preview distribution when it isn't already so.
+func updatePreviewAuthFunction(cf *cloudfront.Client, distributionID, functionARN string, logsWriter io.Writer) error {
+	configOutput, err := cf.GetDistributionConfig(context.TODO(), &cloudfront.GetDistributionConfigInput{
+		Id: aws.String(distributionID),
+	})
+	if err != nil {
+		return err
+	}
+	distConfig := configOutput.DistributionConfig
+	if !setViewerRequestFunction(distConfig.DefaultCacheBehavior, functionARN) {
+		return nil
+	}
+	if functionARN != "" {
+		io.WriteString(logsWriter, fmt.Sprintf("Adding password check to preview distribution: %s\n", distributionID))
+	} else {
+		io.WriteString(logsWriter, fmt.Sprintf("Removing password check from preview distribution: %s\n", distributionID))
+	}
+	_, err = cf.UpdateDistribution(context.TODO(), &cloudfront.UpdateDistributionInput{
+		Id:                 aws.String(distributionID),
+		DistributionConfig: distConfig,
+		IfMatch:            configOutput.ETag,
+	})
+	return err
+}
@@ -0,0 +1,51 @@
+package agenttools
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cloudfrontTypes "github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+)
+
+func TestSetViewerRequestFunction(t *testing.T) {
+	const responseARN = "arn:aws:cloudfront::123456789012:function/viewer-response-p1"
+	const requestARN = "arn:aws:cloudfront::123456789012:function/viewer-request-p1"
+	behavior := &cloudfrontTypes.DefaultCacheBehavior{}
+	if setViewerRequestFunction(behavior, "") {
+		t.Error("expected no change for a public preview without functions")
+	}
+	behavior.FunctionAssociations = &cloudfrontTypes.FunctionAssociations{
+		Quantity: aws.Int32(1),
+		Items:    []cloudfrontTypes.FunctionAssociation{{EventType: cloudfrontTypes.EventTypeViewerResponse, FunctionARN: aws.String(responseARN)}},
+	}
+	if !setViewerRequestFunction(behavior, requestARN) {
+		t.Fatal("expected the password check to be added")
+	}
+	if items := behavior.FunctionAssociations.Items; aws.ToInt32(behavior.FunctionAssociations.Quantity) != 2 ||
+		aws.ToString(items[0].FunctionARN) != responseARN || aws.ToString(items[1].FunctionARN) != requestARN {
+		t.Errorf("associations = %+v, want the response function kept and the check added", items)
+	}
+	if setViewerRequestFunction(behavior, requestARN) {
+		t.Error("expected no change when the check is already attached")
+	}
+	if !setViewerRequestFunction(behavior, "") {
+		t.Fatal("expected the password check to be removed")
+	}
+	if items := behavior.FunctionAssociations.Items; len(items) != 1 || aws.ToString(items[0].FunctionARN) != responseARN {
+		t.Errorf("associations = %+v, want only the response function", items)
+	}
+}
+
+func TestRememberPreviewAuthorization(t *testing.T) {
+	rememberPreviewAuthorization("D123.cloudfront.net", "Basic a")
+	if got := previewAuthorization("d123.cloudfront.net"); got != "Basic a" {
+		t.Errorf("authorization = %q, want the remembered one", got)
+	}
+	if got := previewAuthorization("d456.cloudfront.net"); got != "" {
+		t.Errorf("authorization = %q for another host, want none", got)
+	}
+	rememberPreviewAuthorization("d123.cloudfront.net", "")
+	if got := previewAuthorization("d123.cloudfront.net"); got != "" {
+		t.Errorf("authorization = %q, want it forgotten", got)
+	}
+}
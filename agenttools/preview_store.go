@@ -19,6 +19,10 @@ type PreviewState struct {
 	CloudFrontDistributionArn string
 	CloudFrontDomainName      string
 
+	// AccessPassword is a newly generated password of a protected preview, saved so
+	// the user can open it. Empty when it didn't change; it's never read back.
+	AccessPassword string
+
 	// Web service (ALB + ECS) and database (RDS) resources land here as those tools
 	// arrive — e.g. LoadBalancerDNS / TargetGroupArn / EcsServiceArn / Port, or
 	// RdsDatabaseArn / RdsEndpoint. Adding a field (not a method) keeps PreviewStore
@@ -12,6 +12,9 @@ package agenttools
 // verify_preview_reachable ONLY fetches hosts under *.cloudfront.net (every preview is a
 // CloudFront distribution). That allowlist is the whole SSRF defense; widen it
 // deliberately when custom preview domains land (Cw).
+//
+// A password protected preview is fetched with the credentials the deploy tool
+// remembered for its host (preview_access.go); they're sent only to that host.
 
 import (
 	"context"
@@ -194,7 +197,7 @@ func pollURL(ctx context.Context, target, contains string, maxWait, interval tim
 		}
 		select {
 		case <-ctx.Done():
-			return verifyPreviewReachableResult{
+			res := verifyPreviewReachableResult{
 				Live:           false,
 				StatusCode:     lastStatus,
 				Attempts:       attempts,
@@ -202,6 +205,10 @@ func pollURL(ctx context.Context, target, contains string, maxWait, interval tim
 				BodySnippet:    lastSnippet,
 				Message:        "not reachable yet — a first-time CloudFront distribution takes a couple of minutes to start serving. This is normal; call verify_preview_reachable again to keep waiting (it returns as soon as it's live).",
 			}
+			if lastStatus == http.StatusUnauthorized {
+				res.Message = "the preview is password protected and refused the credentials — a just-changed password takes a minute to propagate, so call verify_preview_reachable again; if it keeps failing, this runner doesn't hold the credentials, so redeploy with deploy_static_site_preview first."
+			}
+			return res
 		case <-time.After(interval):
 		}
 	}
@@ -213,6 +220,9 @@ func fetchOnce(ctx context.Context, client *http.Client, target string) (int, st
 		return 0, "", err
 	}
 	req.Header.Set("User-Agent", "deployment-io-verify/1.0")
+	if authorization := previewAuthorization(req.URL.Host); authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		return 0, "", err
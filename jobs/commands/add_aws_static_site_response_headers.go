@@ -38,15 +38,34 @@ func getResponseHeadersFunctionComment(parameters map[string]interface{}) (strin
 	return fmt.Sprintf("cloudfront function for response-headers-%s", deploymentID), nil
 }
 
-func getResponseHeadersFunctionCode(parameters map[string]interface{}) (string, error) {
-	responseHeadersA, err := jobs.GetParameterValue[primitive.A](parameters, parameters_enums.ResponseHeaders)
+// getResponseHeaders are the Vary header of precompressed files and the rows
+// of the ResponseHeaders parameter followed by the ones compiled from the
+// build's _headers file, which can override it.
+func getResponseHeaders(parameters map[string]interface{}) ([][]string, error) {
+	var responseHeaders [][]string
+	precompressedEncodings, err := getStaticSitePrecompressedEncodings(parameters)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if varyHeaderRow := getPrecompressedVaryHeaderRow(precompressedEncodings); varyHeaderRow != nil {
+		responseHeaders = append(responseHeaders, varyHeaderRow)
+	}
+	responseHeadersA, _ := jobs.GetParameterValue[primitive.A](parameters, parameters_enums.ResponseHeaders)
+	if len(responseHeadersA) > 0 {
+		rows, err := commandUtils.ConvertPrimitiveAToTwoDStringSlice(responseHeadersA)
+		if err != nil {
+			return nil, err
+		}
+		responseHeaders = append(responseHeaders, rows...)
 	}
-	responseHeaders, err := commandUtils.ConvertPrimitiveAToTwoDStringSlice(responseHeadersA)
+	headerRows, err := getStaticSiteHeaderRows(parameters)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return append(responseHeaders, headerRows...), nil
+}
+
+func getResponseHeadersFunctionCode(responseHeaders [][]string) (string, error) {
 	headerStatements := ""
 	for _, responseHeader := range responseHeaders {
 		if len(responseHeader) != 3 {
@@ -65,13 +84,17 @@ func getResponseHeadersFunctionCode(parameters map[string]interface{}) (string,
     var response = event.response;
     var request = event.request;
     var uri = request.uri
+    if (response.headers['content-encoding']) {
+        //a precompressed variant gets the headers of its file
+        uri = uri.replace(/\.(br|gz)$/, '')
+    }
     var responseHeaders = response.headers
     var regex
     %s
     response.headers = responseHeaders
     return response;
 }`, headerStatements)
-	return cloudfrontFunction, err
+	return cloudfrontFunction, nil
 }
 
 func (a *AddAwsStaticSiteResponseHeaders) Run(parameters map[string]interface{}, logsWriter io.Writer) (newParameters map[string]interface{}, err error) {
@@ -107,7 +130,7 @@ func (a *AddAwsStaticSiteResponseHeaders) Run(parameters map[string]interface{},
 	functionAssociations := distributionConfig.DefaultCacheBehavior.FunctionAssociations
 	items := functionAssociations.Items
 
-	responseHeadersA, err := jobs.GetParameterValue[primitive.A](parameters, parameters_enums.ResponseHeaders)
+	responseHeaders, err := getResponseHeaders(parameters)
 	if err != nil {
 		return parameters, err
 	}
@@ -118,7 +141,7 @@ func (a *AddAwsStaticSiteResponseHeaders) Run(parameters map[string]interface{},
 		//Stage: "",
 	})
 
-	if len(responseHeadersA) == 0 {
+	if len(responseHeaders) == 0 {
 		if describeFunctionOutput == nil {
 			return parameters, nil
 		}
@@ -179,7 +202,19 @@ func (a *AddAwsStaticSiteResponseHeaders) Run(parameters map[string]interface{},
 		return parameters, err
 	}
 
-	cloudfrontFunction, err := getResponseHeadersFunctionCode(parameters)
+	cloudfrontFunction, err := getResponseHeadersFunctionCode(responseHeaders)
+	if err != nil {
+		return parameters, err
+	}
+	if err = checkCloudfrontFunctionCodeSize(responseHeadersFunctionName, cloudfrontFunction); err != nil {
+		return parameters, err
+	}
+	testCases, buildPaths, err := getCloudfrontFunctionTestCases(parameters, cloudfrontEventViewerResponse)
+	if err != nil {
+		return parameters, err
+	}
+	//viewer-response functions don't rewrite the uri, precompression doesn't matter
+	err = testCloudfrontFunctionCode(responseHeadersFunctionName, cloudfrontFunction, testCases, buildPaths, nil, logsWriter)
 	if err != nil {
 		return parameters, err
 	}
@@ -225,6 +260,11 @@ func (a *AddAwsStaticSiteResponseHeaders) Run(parameters map[string]interface{},
 		etag = createFunctionOutput.ETag
 	}
 
+	err = testCloudfrontFunctionOnDevelopmentStage(parameters, cloudfrontClient, responseHeadersFunctionName, etag, testCases, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+
 	io.WriteString(logsWriter, fmt.Sprintf("Publishing cloudfront function %s\n", responseHeadersFunctionName))
 	//publish function
 	_, err = cloudfrontClient.PublishFunction(context.TODO(), &cloudfront.PublishFunctionInput{
@@ -260,6 +300,11 @@ func (a *AddAwsStaticSiteResponseHeaders) Run(parameters map[string]interface{},
 
 func invalidateCloudfrontDistribution(parameters map[string]interface{}, cloudfrontClient *cloudfront.Client,
 	cloudfrontDistributionId string, logsWriter io.Writer) error {
+	return invalidateCloudfrontPaths(parameters, cloudfrontClient, cloudfrontDistributionId, []string{"/*"}, logsWriter)
+}
+
+func invalidateCloudfrontPaths(parameters map[string]interface{}, cloudfrontClient *cloudfront.Client,
+	cloudfrontDistributionId string, paths []string, logsWriter io.Writer) error {
 	callerReference, err := getCallerReference(parameters)
 	if err != nil {
 		return err
@@ -271,10 +316,8 @@ func invalidateCloudfrontDistribution(parameters map[string]interface{}, cloudfr
 		InvalidationBatch: &cloudfront_types.InvalidationBatch{
 			CallerReference: aws.String(callerReference),
 			Paths: &cloudfront_types.Paths{
-				Quantity: aws.Int32(1),
-				Items: []string{
-					"/*",
-				},
+				Quantity: aws.Int32(int32(len(paths))),
+				Items:    paths,
 			},
 		},
 	})
@@ -26,27 +26,76 @@ func (a *AddAwsWebServiceDomain) Run(parameters map[string]interface{}, logsWrit
 		return parameters, err
 	}
 
-	var listenerPort int32 = 443
-	albListenerName, err := getAlbListenerName(parameters, listenerPort)
+	loadBalancerArn, err := jobs.GetParameterValue[string](parameters, parameters_enums.LoadBalancerArn)
 	if err != nil {
 		return parameters, err
 	}
 
-	loadBalancerArn, err := jobs.GetParameterValue[string](parameters, parameters_enums.LoadBalancerArn)
+	targetGroupArn, err := jobs.GetParameterValue[string](parameters, parameters_enums.TargetGroupArn)
 	if err != nil {
 		return parameters, err
 	}
 
-	targetGroupArn, err := jobs.GetParameterValue[string](parameters, parameters_enums.TargetGroupArn)
+	certificateArn, err := jobs.GetParameterValue[string](parameters, parameters_enums.AcmCertificateArn)
 	if err != nil {
 		return parameters, err
 	}
 
-	certificateArn, err := jobs.GetParameterValue[string](parameters, parameters_enums.AcmCertificateArn)
+	listenerArn, err := createOrUpdateHttpsListener(parameters, elbClient, loadBalancerArn, targetGroupArn, certificateArn, logsWriter)
 	if err != nil {
 		return parameters, err
 	}
 
+	err = updatePreviewListenerProtection(parameters, elbClient, listenerArn, targetGroupArn, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+
+	if isSharedAlb(parameters) {
+		_, err = createAlbListenerRuleIfNeeded(parameters, elbClient, listenerArn, targetGroupArn, logsWriter)
+		if err != nil {
+			return parameters, err
+		}
+	}
+
+	domains, err := getWebServiceDomains(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	target, err := getLoadBalancerAliasTarget(elbClient, loadBalancerArn)
+	if err != nil {
+		return parameters, err
+	}
+	upsertAliasRecords(parameters, domains, target, logsWriter)
+
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return parameters, err
+	}
+	organizationIdFromJob, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIdFromJob)
+	if err != nil {
+		return parameters, err
+	}
+	commandUtils.UpdateDeploymentsPipeline.Add(organizationIdFromJob, deployments.UpdateDeploymentDtoV1{
+		ID:                 deploymentID,
+		ListenerArnPort443: listenerArn,
+	})
+
+	return parameters, err
+
+}
+
+// createOrUpdateHttpsListener creates the https listener of the load
+// balancer with the certificate, or puts the certificate on the existing
+// one. A shared listener keeps the other services' certificates.
+func createOrUpdateHttpsListener(parameters map[string]interface{}, elbClient *elasticloadbalancingv2.Client,
+	loadBalancerArn, targetGroupArn, certificateArn string, logsWriter io.Writer) (string, error) {
+	var listenerPort int32 = 443
+	albListenerName, err := getAlbListenerName(parameters, listenerPort)
+	if err != nil {
+		return "", err
+	}
+
 	describeListenersOutput, err := elbClient.DescribeListeners(context.TODO(), &elasticloadbalancingv2.DescribeListenersInput{
 		LoadBalancerArn: aws.String(loadBalancerArn),
 	})
@@ -61,7 +110,19 @@ func (a *AddAwsWebServiceDomain) Run(parameters map[string]interface{}, logsWrit
 		}
 	}
 
-	if len(listenerArn) > 0 {
+	if len(listenerArn) > 0 && isSharedAlb(parameters) {
+		//other services' certificates stay on the shared listener
+		io.WriteString(logsWriter, fmt.Sprintf("Adding certificate %s to shared alb https listener: %s\n", certificateArn, listenerArn))
+		_, err := elbClient.AddListenerCertificates(context.TODO(), &elasticloadbalancingv2.AddListenerCertificatesInput{
+			ListenerArn: aws.String(listenerArn),
+			Certificates: []elbTypes.Certificate{{
+				CertificateArn: aws.String(certificateArn),
+			}},
+		})
+		if err != nil {
+			return "", err
+		}
+	} else if len(listenerArn) > 0 {
 		//update
 		io.WriteString(logsWriter, fmt.Sprintf("Adding certificate %s for alb https listener: %s\n", certificateArn, listenerArn))
 		_, err := elbClient.ModifyListener(context.TODO(), &elasticloadbalancingv2.ModifyListenerInput{
@@ -72,17 +133,21 @@ func (a *AddAwsWebServiceDomain) Run(parameters map[string]interface{}, logsWrit
 			SslPolicy: aws.String("ELBSecurityPolicy-TLS13-1-2-2021-06"),
 		})
 		if err != nil {
-			return parameters, err
+			return "", err
 		}
 	} else {
 		//create
 		io.WriteString(logsWriter, fmt.Sprintf("Creating new https alb listener with certificate: %s\n", certificateArn))
+		defaultActions := []elbTypes.Action{{
+			Type:           elbTypes.ActionTypeEnumForward,
+			Order:          aws.Int32(1),
+			TargetGroupArn: aws.String(targetGroupArn),
+		}}
+		if isSharedAlb(parameters) {
+			defaultActions = getSharedAlbListenerDefaultActions()
+		}
 		createListenerInput := &elasticloadbalancingv2.CreateListenerInput{
-			DefaultActions: []elbTypes.Action{{
-				Type:           elbTypes.ActionTypeEnumForward,
-				Order:          aws.Int32(1),
-				TargetGroupArn: aws.String(targetGroupArn),
-			}},
+			DefaultActions:  defaultActions,
 			LoadBalancerArn: aws.String(loadBalancerArn),
 			Certificates: []elbTypes.Certificate{{
 				CertificateArn: aws.String(certificateArn),
@@ -103,25 +168,10 @@ func (a *AddAwsWebServiceDomain) Run(parameters map[string]interface{}, logsWrit
 		}
 		createListenerOutput, err := elbClient.CreateListener(context.TODO(), createListenerInput)
 		if err != nil {
-			return parameters, err
+			return "", err
 		}
 		listenerArn = aws.ToString(createListenerOutput.Listeners[0].ListenerArn)
 		io.WriteString(logsWriter, fmt.Sprintf("New alb https listener created: %s\n", listenerArn))
 	}
-
-	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
-	if err != nil {
-		return parameters, err
-	}
-	organizationIdFromJob, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIdFromJob)
-	if err != nil {
-		return parameters, err
-	}
-	commandUtils.UpdateDeploymentsPipeline.Add(organizationIdFromJob, deployments.UpdateDeploymentDtoV1{
-		ID:                 deploymentID,
-		ListenerArnPort443: listenerArn,
-	})
-
-	return parameters, err
-
+	return listenerArn, nil
 }
@@ -5,12 +5,14 @@ import (
 	"fmt"
 	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
 	"github.com/deployment-io/deployment-runner-kit/jobs"
+	"github.com/deployment-io/deployment-runner/utils/dependency_cache"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/moby/moby/client"
 	"io"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -180,7 +182,7 @@ func pullDockerImageForBuilding(imageID string) error {
 	}
 	defer cli.Close()
 
-	reader, err := cli.ImagePull(ctx, fmt.Sprintf("docker.io/library/%s", imageID), image.PullOptions{})
+	reader, err := cli.ImagePull(ctx, getImageReference(imageID), image.PullOptions{})
 	if err != nil {
 		return err
 	}
@@ -212,7 +214,10 @@ func pullDockerImageForBuilding(imageID string) error {
 // build just works" (many install scripts chown/chmod, fetch from
 // arbitrary CDNs, etc.). Tightening those further requires per-deploy
 // allowlist work tracked separately.
-func startBuildContainer(imageId, repoDir string) (string, error) {
+//
+// cacheVolume, when set, is the build's dependency cache volume, mounted
+// at dependencyCacheDirectory.
+func startBuildContainer(imageId, repoDir, cacheVolume string) (string, error) {
 	ctx := context.Background()
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
@@ -221,16 +226,26 @@ func startBuildContainer(imageId, repoDir string) (string, error) {
 	defer cli.Close()
 
 	memoryBytes, nanoCPUs := resolveBuildLimits()
+	mounts := []mount.Mount{{
+		Type:   mount.TypeBind,
+		Source: repoDir,
+		Target: repoDir,
+	}}
+	if cacheVolume != "" {
+		mounts = append(mounts, mount.Mount{
+			Type:   mount.TypeVolume,
+			Source: cacheVolume,
+			Target: dependencyCacheDirectory,
+		})
+	}
 	resp, err := cli.ContainerCreate(ctx, &container.Config{
 		Image: imageId,
-		Cmd:   []string{"tail", "-f", "/dev/null"},
-		Tty:   false,
+		// Entrypoint rather than Cmd: builder images like hugo's set their
+		// own entrypoint, which would exit instead of idling for the execs.
+		Entrypoint: []string{"tail", "-f", "/dev/null"},
+		Tty:        false,
 	}, &container.HostConfig{
-		Mounts: []mount.Mount{{
-			Type:   mount.TypeBind,
-			Source: repoDir,
-			Target: repoDir,
-		}},
+		Mounts: mounts,
 		Resources: container.Resources{
 			Memory:    memoryBytes,
 			NanoCPUs:  nanoCPUs,
@@ -315,6 +330,46 @@ func removeBuildContainer(containerID string) error {
 	return cli.ContainerRemove(ctx, containerID, container.RemoveOptions{Force: true})
 }
 
+// getDependencyCacheKey keys the build's dependency cache on the
+// organization and builder image as well as the lockfiles, since install
+// scripts can write anything into the cache. ok is false when the site has
+// no lockfile.
+func getDependencyCacheKey(parameters map[string]interface{}, profile staticSiteBuilderProfile,
+	repoDirectoryPath string) (key dependency_cache.Key, ok bool, err error) {
+	if len(profile.PackageManager) == 0 {
+		return dependency_cache.Key{}, false, nil
+	}
+	organizationID, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIDNamespace)
+	if err != nil {
+		return dependency_cache.Key{}, false, err
+	}
+	return dependency_cache.GetKey(profile.PackageManager, organizationID+"/"+profile.Image, repoDirectoryPath,
+		profile.Lockfiles)
+}
+
+// restoreDependencyCache seeds volume from the shared dependency cache on a
+// hit. The cache is an optimization, so a failed restore only logs and
+// leaves volume empty for the job to install into.
+func restoreDependencyCache(key dependency_cache.Key, volume string, logsWriter io.Writer) (*dependency_cache.Lease, error) {
+	lease := dependency_cache.DefaultManager.Acquire(key, logsWriter)
+	if err := lease.Restore(volume, logsWriter); err != nil {
+		io.WriteString(logsWriter, fmt.Sprintf("Not using the dependency cache: %s\n", err))
+		lease.Release()
+		//a partial copy is discarded
+		removeCacheVolume(volume)
+		return &dependency_cache.Lease{}, createCacheVolume(volume)
+	}
+	return lease, nil
+}
+
+// saveDependencyCache saves volume as the shared cache when the job is the
+// one populating it. Failures only log, like restoreDependencyCache.
+func saveDependencyCache(lease *dependency_cache.Lease, volume string, logsWriter io.Writer) {
+	if err := lease.Save(volume, logsWriter); err != nil {
+		io.WriteString(logsWriter, fmt.Sprintf("Error saving the dependency cache: %s\n", err))
+	}
+}
+
 func (b *BuildStaticSite) Run(parameters map[string]interface{}, logsWriter io.Writer) (newParameters map[string]interface{}, err error) {
 	defer func() {
 		if err != nil {
@@ -326,42 +381,74 @@ func (b *BuildStaticSite) Run(parameters map[string]interface{}, logsWriter io.W
 		return parameters, err
 	}
 
-	io.WriteString(logsWriter, fmt.Sprintf("Building static site\n"))
+	builder, _ := jobs.GetParameterValue[string](parameters, parameters_enums.StaticSiteBuilder)
+	buildCommand, _ := jobs.GetParameterValue[string](parameters, parameters_enums.BuildCommand)
+	//a site that was deployed has a distribution
+	cloudfrontID, _ := jobs.GetParameterValue[string](parameters, parameters_enums.CloudfrontID)
+	profile, err := getStaticSiteBuilderProfile(builder, repoDirectoryPath, buildCommand, len(cloudfrontID) > 0)
+	if err != nil {
+		return parameters, err
+	}
 
-	//checking if package.json file exists
-	if _, err = os.Stat(repoDirectoryPath + "/package.json"); err != nil {
-		if os.IsNotExist(err) {
-			io.WriteString(logsWriter, fmt.Sprintf("package.json file doesn't exists in root directory\n"))
-			return parameters, err
-		} else {
-			return parameters, err
-		}
+	io.WriteString(logsWriter, fmt.Sprintf("Building %s static site\n", profile.Name))
+
+	publishDirectory, _ := jobs.GetParameterValue[string](parameters, parameters_enums.PublishDirectory)
+	if profile.Name == staticSiteBuilderHtml && isRootPublishDirectory(publishDirectory) {
+		publishDirectory = ""
+	}
+	if len(publishDirectory) == 0 && len(profile.OutputDirectory) > 0 {
+		publishDirectory = profile.OutputDirectory
+		io.WriteString(logsWriter, fmt.Sprintf("Publishing the %s directory\n", publishDirectory))
+		jobs.SetParameterValue(parameters, parameters_enums.PublishDirectory, publishDirectory)
 	}
 
-	buildCommand, err := jobs.GetParameterValue[string](parameters, parameters_enums.BuildCommand)
-	if err != nil {
+	if len(profile.Image) == 0 {
+		//plain HTML has nothing to build, a site in the root directory is staged without its dot files
+		if publishDirectory == htmlStagingDirectory {
+			err = stageHtmlSite(repoDirectoryPath)
+		}
 		return parameters, err
 	}
 
-	nodeVersion, err := jobs.GetParameterValue[string](parameters, parameters_enums.NodeVersion)
+	buildCommand, err = profile.getBuildCommand(buildCommand)
 	if err != nil {
 		return parameters, err
 	}
+	imageId := profile.Image
 
-	//if node version is missing install and use latest lts
-	//get node docker image id according to node version
-	imageId := "node:lts-buster"
-	if len(nodeVersion) == 0 {
-		nodeVersion = "--lts"
+	cacheKey, cached, err := getDependencyCacheKey(parameters, profile, repoDirectoryPath)
+	if err != nil {
+		io.WriteString(logsWriter, fmt.Sprintf("Not using the dependency cache: %s\n", err))
+		cached = false
+	}
+	cacheVolume := ""
+	cacheLease := &dependency_cache.Lease{}
+	if cached {
+		cacheVolume = fmt.Sprintf("build-dependency-cache-%d", time.Now().UnixNano())
+		if err = createCacheVolume(cacheVolume); err != nil {
+			return parameters, fmt.Errorf("error creating dependency cache volume: %s", err)
+		}
+		defer removeCacheVolume(cacheVolume)
+		cacheLease, err = restoreDependencyCache(cacheKey, cacheVolume, logsWriter)
+		if err != nil {
+			return parameters, fmt.Errorf("error creating dependency cache volume: %s", err)
+		}
+		defer cacheLease.Release()
 	}
 
 	envVariables, err := jobs.GetParameterValue[string](parameters, parameters_enums.EnvironmentVariables)
-	var envVariablesSlice []string
+	envVariablesSlice := append([]string{}, profile.Env...)
+	if cached {
+		envVariablesSlice = append(envVariablesSlice, profile.CacheEnv...)
+	}
 	if err == nil {
-		envVariablesSlice, err = decodeEnvironmentVariablesToSlice(envVariables)
+		var decodedEnvVariables []string
+		decodedEnvVariables, err = decodeEnvironmentVariablesToSlice(envVariables)
 		if err != nil {
 			return parameters, err
 		}
+		//the site's variables are later so they override the profile's
+		envVariablesSlice = append(envVariablesSlice, decodedEnvVariables...)
 	}
 
 	err = pullDockerImageForBuilding(imageId)
@@ -369,7 +456,7 @@ func (b *BuildStaticSite) Run(parameters map[string]interface{}, logsWriter io.W
 		return parameters, err
 	}
 
-	containerID, err := startBuildContainer(imageId, repoDirectoryPath)
+	containerID, err := startBuildContainer(imageId, repoDirectoryPath, cacheVolume)
 	if err != nil {
 		return parameters, err
 	}
@@ -380,15 +467,31 @@ func (b *BuildStaticSite) Run(parameters map[string]interface{}, logsWriter io.W
 	// exec mid-run.
 	defer func() { _ = removeBuildContainer(containerID) }()
 
-	// Wall-clock cap on the npm install + build phase. A build that
+	// Wall-clock cap on the install + build phase. A build that
 	// exceeds this is genuinely broken — surface the deadline as an
 	// error rather than tying up a runner slot indefinitely.
 	execCtx, cancelExec := context.WithTimeout(context.Background(), defaultBuildTimeout)
 	defer cancelExec()
-	err = execCommand(execCtx, containerID, repoDirectoryPath, []string{"bash", "-c", "npm install;" + buildCommand}, envVariablesSlice, logsWriter)
+	//a failed install doesn't stop the build, which reports what's missing, but isn't cached
+	err = execCommand(execCtx, containerID, repoDirectoryPath, []string{profile.Shell, "-c", profile.InstallCommand}, envVariablesSlice, logsWriter)
+	if err != nil && execCtx.Err() != nil {
+		return parameters, err
+	}
+	if err != nil {
+		io.WriteString(logsWriter, fmt.Sprintf("Installing dependencies failed: %s\n", err))
+	} else {
+		saveDependencyCache(cacheLease, cacheVolume, logsWriter)
+	}
+	err = execCommand(execCtx, containerID, repoDirectoryPath, []string{profile.Shell, "-c", buildCommand}, envVariablesSlice, logsWriter)
 	if err != nil {
 		return parameters, err
 	}
 
+	if len(publishDirectory) > 0 {
+		if _, err = os.Stat(filepath.Join(repoDirectoryPath, publishDirectory)); os.IsNotExist(err) {
+			return parameters, fmt.Errorf("the build didn't create the publish directory %s", publishDirectory)
+		}
+	}
+
 	return parameters, nil
 }
@@ -0,0 +1,581 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	cloudfront_types "github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
+	awsS3Uploads "github.com/deployment-io/deployment-runner/utils/uploads/aws-s3"
+	"github.com/dop251/goja"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Function code is run against sample events in an embedded JavaScript
+// engine before it's published, so a broken function fails the job instead
+// of the site.
+const (
+	cloudfrontEventViewerRequest  = "viewer-request"
+	cloudfrontEventViewerResponse = "viewer-response"
+	cloudfrontFunctionTestTimeout = time.Second
+	maxCloudfrontFunctionFailures = 10
+	maxBuildPathTestCases         = 20
+	defaultTestDistributionDomain = "d111111abcdef8.cloudfront.net"
+)
+
+var sampleCloudfrontFunctionUris = []string{"/", "/index.html", "/about", "/about/", "/deep/nested/path/",
+	"/missing/page", "/assets/app.3f9a1c2b.js", "/styles/site.css", "/robots.txt", "/file.name.with.dots",
+	"/a%20b/c", "/%E2%9C%93", "//double"}
+
+type cloudfrontFunctionValue struct {
+	Value      string                    `json:"value"`
+	MultiValue []cloudfrontFunctionValue `json:"multiValue,omitempty"`
+}
+
+type cloudfrontFunctionRequest struct {
+	Method      string                             `json:"method"`
+	Uri         string                             `json:"uri"`
+	Querystring map[string]cloudfrontFunctionValue `json:"querystring"`
+	Headers     map[string]cloudfrontFunctionValue `json:"headers"`
+	Cookies     map[string]cloudfrontFunctionValue `json:"cookies"`
+}
+
+type cloudfrontFunctionResponse struct {
+	StatusCode        int                                `json:"statusCode"`
+	StatusDescription string                             `json:"statusDescription,omitempty"`
+	Headers           map[string]cloudfrontFunctionValue `json:"headers"`
+	Cookies           map[string]cloudfrontFunctionValue `json:"cookies"`
+}
+
+// cloudfrontFunctionEvent is the event object CloudFront passes to a
+// function's handler.
+type cloudfrontFunctionEvent struct {
+	Version string `json:"version"`
+	Context struct {
+		DistributionDomainName string `json:"distributionDomainName"`
+		EventType              string `json:"eventType"`
+		RequestId              string `json:"requestId"`
+	} `json:"context"`
+	Viewer struct {
+		Ip string `json:"ip"`
+	} `json:"viewer"`
+	Request  cloudfrontFunctionRequest   `json:"request"`
+	Response *cloudfrontFunctionResponse `json:"response,omitempty"`
+}
+
+// cloudfrontFunctionOutput is what the handler returned: the request to
+// forward or a response, which has a status code.
+type cloudfrontFunctionOutput struct {
+	Uri        *string                            `json:"uri"`
+	StatusCode *int                               `json:"statusCode"`
+	Headers    map[string]cloudfrontFunctionValue `json:"headers"`
+	Cookies    map[string]cloudfrontFunctionValue `json:"cookies"`
+}
+
+// cloudfrontFunctionExpectation is what a user test case checks. Status 0
+// for a viewer-request function means the request goes on to the origin; a
+// null header value means the header must be absent.
+type cloudfrontFunctionExpectation struct {
+	Uri     string             `json:"uri"`
+	Status  int                `json:"status"`
+	Headers map[string]*string `json:"headers"`
+}
+
+type cloudfrontFunctionTestCase struct {
+	Name   string
+	Event  cloudfrontFunctionEvent
+	Expect *cloudfrontFunctionExpectation
+	// the request is for a file of the build, so a forwarded request has to
+	// stay on one
+	IsBuildPath bool
+}
+
+// userCloudfrontFunctionTestCase is an entry of the optional
+// CloudfrontFunctionTests parameter.
+type userCloudfrontFunctionTestCase struct {
+	Name            string                        `json:"name"`
+	EventType       string                        `json:"event_type"` // viewer-request or viewer-response
+	Method          string                        `json:"method"`
+	Uri             string                        `json:"uri"`
+	Querystring     map[string]string             `json:"querystring"`
+	Headers         map[string]string             `json:"headers"`
+	Cookies         map[string]string             `json:"cookies"`
+	Status          int                           `json:"status"` // of the origin's response
+	ResponseHeaders map[string]string             `json:"response_headers"`
+	Expect          cloudfrontFunctionExpectation `json:"expect"`
+}
+
+func toCloudfrontFunctionValues(values map[string]string, lowerCaseNames bool) map[string]cloudfrontFunctionValue {
+	functionValues := make(map[string]cloudfrontFunctionValue)
+	for name, value := range values {
+		if lowerCaseNames {
+			name = strings.ToLower(name)
+		}
+		functionValues[name] = cloudfrontFunctionValue{Value: value}
+	}
+	return functionValues
+}
+
+func newCloudfrontFunctionEvent(eventType, uri string) cloudfrontFunctionEvent {
+	event := cloudfrontFunctionEvent{Version: "1.0"}
+	event.Context.DistributionDomainName = defaultTestDistributionDomain
+	event.Context.EventType = eventType
+	event.Context.RequestId = "test"
+	event.Viewer.Ip = "198.51.100.10"
+	event.Request = cloudfrontFunctionRequest{
+		Method:      http.MethodGet,
+		Uri:         uri,
+		Querystring: map[string]cloudfrontFunctionValue{},
+		Headers:     map[string]cloudfrontFunctionValue{},
+		Cookies:     map[string]cloudfrontFunctionValue{},
+	}
+	if eventType == cloudfrontEventViewerResponse {
+		event.Response = &cloudfrontFunctionResponse{
+			StatusCode: http.StatusOK,
+			Headers:    map[string]cloudfrontFunctionValue{},
+			Cookies:    map[string]cloudfrontFunctionValue{},
+		}
+	}
+	return event
+}
+
+// sampleBuildPaths picks up to maxBuildPathTestCases of the build's paths,
+// spread evenly over the sorted paths so every part of a large site is
+// tested, not just the first directory.
+func sampleBuildPaths(buildPaths []string) []string {
+	sorted := slices.Clone(buildPaths)
+	sort.Strings(sorted)
+	if len(sorted) <= maxBuildPathTestCases {
+		return sorted
+	}
+	sampled := make([]string, 0, maxBuildPathTestCases)
+	for i := 0; i < maxBuildPathTestCases; i++ {
+		sampled = append(sampled, sorted[i*len(sorted)/maxBuildPathTestCases])
+	}
+	return sampled
+}
+
+// getGeneratedCloudfrontFunctionTestCases are events for sample paths and
+// the build's files, each as a bare request and as browsers send it over
+// http and https.
+func getGeneratedCloudfrontFunctionTestCases(eventType, host string, buildPaths []string) []cloudfrontFunctionTestCase {
+	type uriCase struct {
+		uri         string
+		isBuildPath bool
+	}
+	var uris []uriCase
+	for _, uri := range sampleCloudfrontFunctionUris {
+		uris = append(uris, uriCase{uri: uri})
+	}
+	for _, buildPath := range sampleBuildPaths(buildPaths) {
+		uris = append(uris, uriCase{uri: buildPath, isBuildPath: true})
+	}
+	var testCases []cloudfrontFunctionTestCase
+	for _, u := range uris {
+		bareEvent := newCloudfrontFunctionEvent(eventType, u.uri)
+		bareEvent.Request.Headers["host"] = cloudfrontFunctionValue{Value: host}
+
+		httpsEvent := newCloudfrontFunctionEvent(eventType, u.uri)
+		httpsEvent.Request.Headers = toCloudfrontFunctionValues(map[string]string{
+			"host":                       host,
+			"accept-encoding":            "gzip, deflate, br",
+			"cloudfront-forwarded-proto": "https",
+			"user-agent":                 "Mozilla/5.0",
+		}, false)
+		httpsEvent.Request.Cookies["session"] = cloudfrontFunctionValue{Value: "abc123"}
+		httpsEvent.Request.Querystring["utm_source"] = cloudfrontFunctionValue{Value: "test"}
+
+		httpEvent := newCloudfrontFunctionEvent(eventType, u.uri)
+		httpEvent.Request.Headers = toCloudfrontFunctionValues(map[string]string{
+			"host":                       host,
+			"accept-encoding":            "gzip",
+			"cloudfront-forwarded-proto": "http",
+		}, false)
+		httpEvent.Request.Querystring["tag"] = cloudfrontFunctionValue{Value: "a", MultiValue: []cloudfrontFunctionValue{{Value: "a"}, {Value: "b"}}}
+
+		if eventType == cloudfrontEventViewerResponse {
+			//a precompressed variant and a status other than 200
+			httpsEvent.Response.Headers["content-type"] = cloudfrontFunctionValue{Value: "text/html"}
+			httpsEvent.Response.Headers["content-encoding"] = cloudfrontFunctionValue{Value: "br"}
+			httpsEvent.Request.Uri = u.uri + ".br"
+			httpEvent.Response.StatusCode = http.StatusNotModified
+			if strings.HasPrefix(u.uri, "/missing") {
+				httpEvent.Response.StatusCode = http.StatusNotFound
+			}
+		}
+		for _, event := range []cloudfrontFunctionEvent{bareEvent, httpsEvent, httpEvent} {
+			testCases = append(testCases, cloudfrontFunctionTestCase{
+				Name:        fmt.Sprintf("%s %s", event.Request.Method, event.Request.Uri),
+				Event:       event,
+				IsBuildPath: u.isBuildPath,
+			})
+		}
+	}
+	return testCases
+}
+
+func parseUserCloudfrontFunctionTestCases(testsBytes []byte, eventType string) ([]cloudfrontFunctionTestCase, error) {
+	var userTestCases []userCloudfrontFunctionTestCase
+	if err := json.Unmarshal(testsBytes, &userTestCases); err != nil {
+		return nil, fmt.Errorf("error unmarshalling cloudfront function tests: %s", err)
+	}
+	var testCases []cloudfrontFunctionTestCase
+	for i, userTestCase := range userTestCases {
+		if userTestCase.EventType != cloudfrontEventViewerRequest && userTestCase.EventType != cloudfrontEventViewerResponse {
+			return nil, fmt.Errorf("cloudfront function test %d: event_type has to be %s or %s", i+1,
+				cloudfrontEventViewerRequest, cloudfrontEventViewerResponse)
+		}
+		if userTestCase.EventType != eventType {
+			continue
+		}
+		if !strings.HasPrefix(userTestCase.Uri, "/") {
+			return nil, fmt.Errorf("cloudfront function test %d: uri has to start with /", i+1)
+		}
+		event := newCloudfrontFunctionEvent(eventType, userTestCase.Uri)
+		if len(userTestCase.Method) > 0 {
+			event.Request.Method = strings.ToUpper(userTestCase.Method)
+		}
+		event.Request.Querystring = toCloudfrontFunctionValues(userTestCase.Querystring, false)
+		event.Request.Headers = toCloudfrontFunctionValues(userTestCase.Headers, true)
+		event.Request.Cookies = toCloudfrontFunctionValues(userTestCase.Cookies, false)
+		if event.Response != nil {
+			if userTestCase.Status != 0 {
+				event.Response.StatusCode = userTestCase.Status
+			}
+			event.Response.Headers = toCloudfrontFunctionValues(userTestCase.ResponseHeaders, true)
+		}
+		name := userTestCase.Name
+		if len(name) == 0 {
+			name = fmt.Sprintf("test %d", i+1)
+		}
+		expect := userTestCase.Expect
+		testCases = append(testCases, cloudfrontFunctionTestCase{Name: name, Event: event, Expect: &expect})
+	}
+	return testCases, nil
+}
+
+// getCloudfrontFunctionTestCases also returns the paths of the build's
+// files, none when the job has no build.
+func getCloudfrontFunctionTestCases(parameters map[string]interface{}, eventType string) ([]cloudfrontFunctionTestCase,
+	[]string, error) {
+	host := defaultTestDistributionDomain
+	domainsA, _ := jobs.GetParameterValue[primitive.A](parameters, parameters_enums.Domains)
+	if len(domainsA) > 0 {
+		domains, err := commandUtils.ConvertPrimitiveAToStringSlice(domainsA)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(domains) > 0 {
+			host = domains[0]
+		}
+	}
+	var buildPaths []string
+	if distDirectory, err := getDistDirectory(parameters); err == nil {
+		buildPaths, err = getStaticSiteFilePaths(distDirectory)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	testCases := getGeneratedCloudfrontFunctionTestCases(eventType, host, buildPaths)
+	testsJSON, err := jobs.GetParameterValue[string](parameters, parameters_enums.CloudfrontFunctionTests)
+	if err != nil || len(testsJSON) == 0 {
+		return testCases, buildPaths, nil
+	}
+	userTestCases, err := parseUserCloudfrontFunctionTestCases([]byte(testsJSON), eventType)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(testCases, userTestCases...), buildPaths, nil
+}
+
+// addPreviewAuthorizationToTestCases sends the credentials of a password
+// protected preview with every test case that doesn't set its own, and adds
+// one checking a viewer without them is asked for them.
+func addPreviewAuthorizationToTestCases(testCases []cloudfrontFunctionTestCase,
+	authorization string) []cloudfrontFunctionTestCase {
+	if len(authorization) == 0 {
+		return testCases
+	}
+	for _, testCase := range testCases {
+		if _, exists := testCase.Event.Request.Headers["authorization"]; !exists {
+			testCase.Event.Request.Headers["authorization"] = cloudfrontFunctionValue{Value: authorization}
+		}
+	}
+	event := newCloudfrontFunctionEvent(cloudfrontEventViewerRequest, "/")
+	event.Request.Headers = toCloudfrontFunctionValues(map[string]string{
+		"host":                       defaultTestDistributionDomain,
+		"cloudfront-forwarded-proto": "https",
+	}, false)
+	wwwAuthenticate := `Basic realm="Preview", charset="UTF-8"`
+	return append(testCases, cloudfrontFunctionTestCase{
+		Name:  "GET / without preview credentials",
+		Event: event,
+		Expect: &cloudfrontFunctionExpectation{
+			Status:  http.StatusUnauthorized,
+			Headers: map[string]*string{"www-authenticate": &wwwAuthenticate},
+		},
+	})
+}
+
+// runCloudfrontFunction calls the handler with the event in a fresh
+// runtime, so test cases can't leak state into each other.
+func runCloudfrontFunction(code string, event cloudfrontFunctionEvent) (*cloudfrontFunctionOutput, error) {
+	vm := goja.New()
+	timer := time.AfterFunc(cloudfrontFunctionTestTimeout, func() {
+		vm.Interrupt("the function ran for over a second")
+	})
+	defer timer.Stop()
+	console := vm.NewObject()
+	if err := console.Set("log", func(goja.FunctionCall) goja.Value { return goja.Undefined() }); err != nil {
+		return nil, err
+	}
+	if err := vm.Set("console", console); err != nil {
+		return nil, err
+	}
+	if _, err := vm.RunString(code); err != nil {
+		return nil, err
+	}
+	handler, ok := goja.AssertFunction(vm.Get("handler"))
+	if !ok {
+		return nil, fmt.Errorf("the code has no handler function")
+	}
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	jsonObject := vm.Get("JSON").ToObject(vm)
+	parse, _ := goja.AssertFunction(jsonObject.Get("parse"))
+	stringify, _ := goja.AssertFunction(jsonObject.Get("stringify"))
+	eventValue, err := parse(jsonObject, vm.ToValue(string(eventJSON)))
+	if err != nil {
+		return nil, err
+	}
+	result, err := handler(goja.Undefined(), eventValue)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil || goja.IsUndefined(result) || goja.IsNull(result) {
+		return nil, fmt.Errorf("the handler returned nothing")
+	}
+	resultJSON, err := stringify(jsonObject, result)
+	if err != nil {
+		return nil, err
+	}
+	output := &cloudfrontFunctionOutput{}
+	if err = json.Unmarshal([]byte(resultJSON.String()), output); err != nil {
+		return nil, fmt.Errorf("the handler returned an invalid object: %s", err)
+	}
+	return output, nil
+}
+
+func checkCloudfrontFunctionHeaders(headers map[string]cloudfrontFunctionValue) error {
+	for name := range headers {
+		if name != strings.ToLower(name) {
+			return fmt.Errorf("header %s isn't lower case", name)
+		}
+	}
+	return nil
+}
+
+// isBuildFileUri tells whether a request for a file of the build was
+// forwarded to one. The uri carries the key suffix of a precompressed variant
+// only when the build was precompressed. Files without an extension, like
+// /CNAME, are rewritten to a directory index and still count as requested.
+func isBuildFileUri(requestUri, uri string, buildPaths map[string]bool, precompressedSuffixes []string) bool {
+	for _, suffix := range precompressedSuffixes {
+		if strings.HasSuffix(uri, suffix) {
+			uri = strings.TrimSuffix(uri, suffix)
+			break
+		}
+	}
+	if buildPaths[uri] {
+		return true
+	}
+	return buildPaths[requestUri] && uri == strings.TrimSuffix(requestUri, "/")+"/index.html"
+}
+
+// checkCloudfrontFunctionOutput checks the output is one CloudFront accepts
+// and matches the test case's expectation. buildPaths are the paths of the
+// build's files when the job has a build, precompressedSuffixes the key
+// suffixes of its precompressed variants.
+func checkCloudfrontFunctionOutput(testCase cloudfrontFunctionTestCase, output *cloudfrontFunctionOutput,
+	buildPaths map[string]bool, precompressedSuffixes []string) error {
+	if err := checkCloudfrontFunctionHeaders(output.Headers); err != nil {
+		return err
+	}
+	status := 0
+	if output.StatusCode != nil {
+		status = *output.StatusCode
+		if status < 100 || status > 599 {
+			return fmt.Errorf("invalid status %d", status)
+		}
+		if status >= 300 && status < 400 && status != http.StatusNotModified && len(output.Headers["location"].Value) == 0 {
+			return fmt.Errorf("redirect %d has no location", status)
+		}
+	}
+	switch testCase.Event.Context.EventType {
+	case cloudfrontEventViewerRequest:
+		if output.StatusCode == nil {
+			if output.Uri == nil || !strings.HasPrefix(*output.Uri, "/") {
+				return fmt.Errorf("the request has no uri starting with /")
+			}
+			if testCase.IsBuildPath && len(buildPaths) > 0 &&
+				!isBuildFileUri(testCase.Event.Request.Uri, *output.Uri, buildPaths, precompressedSuffixes) {
+				return fmt.Errorf("rewritten to %s, which isn't a file of the build", *output.Uri)
+			}
+		}
+	case cloudfrontEventViewerResponse:
+		if output.StatusCode == nil {
+			return fmt.Errorf("the response has no status code")
+		}
+		for name := range readOnlyViewerResponseHeaders {
+			if output.Headers[name].Value != testCase.Event.Response.Headers[name].Value {
+				return fmt.Errorf("read-only header %s was changed", name)
+			}
+		}
+	}
+	if testCase.Expect == nil {
+		return nil
+	}
+	if status != testCase.Expect.Status && (testCase.Event.Response == nil || testCase.Expect.Status != 0) {
+		return fmt.Errorf("status %d, expected %d", status, testCase.Expect.Status)
+	}
+	if len(testCase.Expect.Uri) > 0 && (output.Uri == nil || *output.Uri != testCase.Expect.Uri) {
+		return fmt.Errorf("uri %s, expected %s", aws.ToString(output.Uri), testCase.Expect.Uri)
+	}
+	for name, value := range testCase.Expect.Headers {
+		header, exists := output.Headers[strings.ToLower(name)]
+		if value == nil && exists {
+			return fmt.Errorf("header %s is %s, expected none", name, header.Value)
+		}
+		if value != nil && header.Value != *value {
+			return fmt.Errorf("header %s is %q, expected %q", name, header.Value, *value)
+		}
+	}
+	return nil
+}
+
+func getCloudfrontFunctionTestFailures(failures []string) error {
+	if len(failures) == 0 {
+		return nil
+	}
+	count := len(failures)
+	if count > maxCloudfrontFunctionFailures {
+		failures = append(failures[:maxCloudfrontFunctionFailures], fmt.Sprintf("and %d more", count-maxCloudfrontFunctionFailures))
+	}
+	return fmt.Errorf("%d cloudfront function tests failed:\n%s", count, strings.Join(failures, "\n"))
+}
+
+// testCloudfrontFunctionCode runs the test cases against the code locally
+// and returns an error listing the failures. precompressedEncodings are the
+// encodings the build's files were precompressed with.
+func testCloudfrontFunctionCode(functionName, code string, testCases []cloudfrontFunctionTestCase,
+	buildPaths []string, precompressedEncodings []string, logsWriter io.Writer) error {
+	io.WriteString(logsWriter, fmt.Sprintf("Testing cloudfront function %s with %d events\n", functionName, len(testCases)))
+	if len(buildPaths) > maxBuildPathTestCases {
+		io.WriteString(logsWriter, fmt.Sprintf("Testing %d of the build's %d paths\n", maxBuildPathTestCases, len(buildPaths)))
+	}
+	buildPathSet := make(map[string]bool)
+	for _, buildPath := range buildPaths {
+		buildPathSet[buildPath] = true
+	}
+	var precompressedSuffixes []string
+	for _, encoding := range precompressedEncodings {
+		suffix, err := awsS3Uploads.GetPrecompressedKeySuffix(encoding)
+		if err != nil {
+			return err
+		}
+		precompressedSuffixes = append(precompressedSuffixes, suffix)
+	}
+	var failures []string
+	for _, testCase := range testCases {
+		output, err := runCloudfrontFunction(code, testCase.Event)
+		if err == nil {
+			err = checkCloudfrontFunctionOutput(testCase, output, buildPathSet, precompressedSuffixes)
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", testCase.Name, err))
+		}
+	}
+	return getCloudfrontFunctionTestFailures(failures)
+}
+
+// testCloudfrontFunctionOnDevelopmentStage runs the user test cases with
+// CloudFront's TestFunction against the unpublished code when the
+// TestCloudfrontFunctionsOnDevelopmentStage parameter is set. It catches
+// what the local engine can't, like syntax the CloudFront runtime doesn't
+// support and compute utilization.
+func testCloudfrontFunctionOnDevelopmentStage(parameters map[string]interface{}, cloudfrontClient *cloudfront.Client,
+	functionName string, etag *string, testCases []cloudfrontFunctionTestCase, logsWriter io.Writer) error {
+	testOnDevelopmentStage, err := jobs.GetParameterValue[bool](parameters, parameters_enums.TestCloudfrontFunctionsOnDevelopmentStage)
+	if err != nil || !testOnDevelopmentStage {
+		return nil
+	}
+	//a generated event of each kind stands in when there are no user tests
+	var stageTestCases []cloudfrontFunctionTestCase
+	for _, testCase := range testCases {
+		if testCase.Expect != nil {
+			stageTestCases = append(stageTestCases, testCase)
+		}
+	}
+	if len(stageTestCases) == 0 {
+		stageTestCases = testCases[:min(3, len(testCases))]
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Testing cloudfront function %s on the development stage with %d events\n",
+		functionName, len(stageTestCases)))
+	var failures []string
+	for _, testCase := range stageTestCases {
+		eventJSON, err := json.Marshal(testCase.Event)
+		if err != nil {
+			return err
+		}
+		testFunctionOutput, err := cloudfrontClient.TestFunction(context.TODO(), &cloudfront.TestFunctionInput{
+			EventObject: eventJSON,
+			IfMatch:     etag,
+			Name:        aws.String(functionName),
+			Stage:       cloudfront_types.FunctionStageDevelopment,
+		})
+		if err != nil {
+			return err
+		}
+		testResult := testFunctionOutput.TestResult
+		if len(aws.ToString(testResult.FunctionErrorMessage)) > 0 {
+			failures = append(failures, fmt.Sprintf("%s: %s", testCase.Name, aws.ToString(testResult.FunctionErrorMessage)))
+			continue
+		}
+		var functionOutput struct {
+			Request  *cloudfrontFunctionOutput `json:"request"`
+			Response *cloudfrontFunctionOutput `json:"response"`
+		}
+		if err = json.Unmarshal([]byte(aws.ToString(testResult.FunctionOutput)), &functionOutput); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: invalid function output: %s", testCase.Name, err))
+			continue
+		}
+		output := functionOutput.Request
+		if functionOutput.Response != nil {
+			output = functionOutput.Response
+		}
+		if output == nil {
+			failures = append(failures, fmt.Sprintf("%s: the function returned nothing", testCase.Name))
+			continue
+		}
+		if err = checkCloudfrontFunctionOutput(testCase, output, nil, nil); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", testCase.Name, err))
+			continue
+		}
+		io.WriteString(logsWriter, fmt.Sprintf("%s: compute utilization %s\n", testCase.Name,
+			aws.ToString(testResult.ComputeUtilization)))
+	}
+	return getCloudfrontFunctionTestFailures(failures)
+}
@@ -0,0 +1,256 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/deployment-io/deployment-runner/utils/aws_utils"
+)
+
+const testViewerRequestCode = `function handler(event) {
+    var request = event.request;
+    if (request.uri === '/old') {
+        return {
+            statusCode: 301,
+            statusDescription: 'Moved Permanently',
+            headers: { 'location': { value: '/new' } }
+        };
+    }
+    if (request.uri.endsWith('/')) {
+        request.uri += 'index.html';
+    }
+    return request;
+}`
+
+func testCloudfrontFunctionCase(eventType, uri string, expect *cloudfrontFunctionExpectation) cloudfrontFunctionTestCase {
+	event := newCloudfrontFunctionEvent(eventType, uri)
+	event.Request.Headers["host"] = cloudfrontFunctionValue{Value: "example.com"}
+	return cloudfrontFunctionTestCase{Name: uri, Event: event, Expect: expect}
+}
+
+func TestRunCloudfrontFunction(t *testing.T) {
+	output, err := runCloudfrontFunction(testViewerRequestCode, testCloudfrontFunctionCase(cloudfrontEventViewerRequest, "/docs/", nil).Event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.StatusCode != nil || output.Uri == nil || *output.Uri != "/docs/index.html" {
+		t.Errorf("output = %+v, want /docs/index.html forwarded", output)
+	}
+	output, err = runCloudfrontFunction(testViewerRequestCode, testCloudfrontFunctionCase(cloudfrontEventViewerRequest, "/old", nil).Event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.StatusCode == nil || *output.StatusCode != 301 || output.Headers["location"].Value != "/new" {
+		t.Errorf("output = %+v, want a redirect to /new", output)
+	}
+}
+
+func TestRunCloudfrontFunction_Errors(t *testing.T) {
+	event := testCloudfrontFunctionCase(cloudfrontEventViewerRequest, "/", nil).Event
+	cases := map[string]string{
+		"syntax":     `function handler(event) { return event.request`,
+		"exception":  `function handler(event) { return event.request.headers['cookie'].value; }`,
+		"no handler": `function main(event) { return event.request; }`,
+		"nothing":    `function handler(event) {}`,
+		"timeout":    `function handler(event) { while (true) {} }`,
+		"header":     `function handler(event) { event.request.headers['x'] = 'a'; return event.request; }`,
+	}
+	for name, code := range cases {
+		if _, err := runCloudfrontFunction(code, event); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestCheckCloudfrontFunctionOutput(t *testing.T) {
+	location := "/new"
+	cases := []struct {
+		name     string
+		code     string
+		testCase cloudfrontFunctionTestCase
+		valid    bool
+	}{
+		{"expected redirect", testViewerRequestCode, testCloudfrontFunctionCase(cloudfrontEventViewerRequest, "/old",
+			&cloudfrontFunctionExpectation{Status: 301, Headers: map[string]*string{"Location": &location}}), true},
+		{"expected rewrite", testViewerRequestCode, testCloudfrontFunctionCase(cloudfrontEventViewerRequest, "/",
+			&cloudfrontFunctionExpectation{Uri: "/index.html"}), true},
+		{"unexpected status", testViewerRequestCode, testCloudfrontFunctionCase(cloudfrontEventViewerRequest, "/old",
+			&cloudfrontFunctionExpectation{}), false},
+		{"unexpected header", testViewerRequestCode, testCloudfrontFunctionCase(cloudfrontEventViewerRequest, "/old",
+			&cloudfrontFunctionExpectation{Status: 301, Headers: map[string]*string{"location": nil}}), false},
+		{"redirect without location", `function handler(event) { return { statusCode: 302 }; }`,
+			testCloudfrontFunctionCase(cloudfrontEventViewerRequest, "/", nil), false},
+		{"upper case header", `function handler(event) { event.request.headers['X-Test'] = { value: 'a' }; return event.request; }`,
+			testCloudfrontFunctionCase(cloudfrontEventViewerRequest, "/", nil), false},
+		{"relative uri", `function handler(event) { event.request.uri = 'index.html'; return event.request; }`,
+			testCloudfrontFunctionCase(cloudfrontEventViewerRequest, "/", nil), false},
+		{"request from viewer-response", `function handler(event) { return event.request; }`,
+			testCloudfrontFunctionCase(cloudfrontEventViewerResponse, "/", nil), false},
+		{"read-only header", `function handler(event) { event.response.headers['content-length'] = { value: '1' }; return event.response; }`,
+			testCloudfrontFunctionCase(cloudfrontEventViewerResponse, "/", nil), false},
+	}
+	for _, c := range cases {
+		output, err := runCloudfrontFunction(c.code, c.testCase.Event)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		err = checkCloudfrontFunctionOutput(c.testCase, output, nil, nil)
+		if c.valid && err != nil {
+			t.Errorf("%s: %s", c.name, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+	}
+}
+
+func TestTestCloudfrontFunctionCode_BuildPaths(t *testing.T) {
+	buildPaths := []string{"/index.html", "/", "/docs/index.html", "/docs/"}
+	testCases := getGeneratedCloudfrontFunctionTestCases(cloudfrontEventViewerRequest, "example.com", buildPaths)
+	if err := testCloudfrontFunctionCode("f", testViewerRequestCode, testCases, buildPaths, nil, &strings.Builder{}); err != nil {
+		t.Error(err)
+	}
+	//a rewrite away from the build's files
+	brokenCode := strings.Replace(testViewerRequestCode, "'index.html'", "'index.htm'", 1)
+	err := testCloudfrontFunctionCode("f", brokenCode, testCases, buildPaths, nil, &strings.Builder{})
+	if err == nil || !strings.Contains(err.Error(), "isn't a file of the build") {
+		t.Errorf("err = %v, want a build path failure", err)
+	}
+}
+
+func TestTestCloudfrontFunctionCode_ResponseHeaders(t *testing.T) {
+	headers, err := parseStaticSiteHeaders("/*\n  X-Frame-Options: DENY\n/docs\n  Link: </it's.css>; rel=preload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := compileStaticSiteHeaders(headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := getResponseHeadersFunctionCode(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := getGeneratedCloudfrontFunctionTestCases(cloudfrontEventViewerResponse, "example.com", nil)
+	link := "</it's.css>; rel=preload"
+	//the precompressed variant of the directory's index.html gets its headers
+	testCase := testCloudfrontFunctionCase(cloudfrontEventViewerResponse, "/docs/index.html.br",
+		&cloudfrontFunctionExpectation{Headers: map[string]*string{"link": &link}})
+	testCase.Event.Response.Headers["content-encoding"] = cloudfrontFunctionValue{Value: "br"}
+	testCases = append(testCases, testCase)
+	if err = testCloudfrontFunctionCode("f", code, testCases, nil, nil, &strings.Builder{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseUserCloudfrontFunctionTestCases(t *testing.T) {
+	testCases, err := parseUserCloudfrontFunctionTestCases([]byte(`[
+		{"name": "old page", "event_type": "viewer-request", "uri": "/old", "headers": {"Host": "example.com"},
+		 "expect": {"status": 301, "headers": {"location": "/new"}}},
+		{"event_type": "viewer-response", "uri": "/", "status": 404}
+	]`), cloudfrontEventViewerRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(testCases) != 1 || testCases[0].Event.Request.Headers["host"].Value != "example.com" {
+		t.Fatalf("testCases = %+v", testCases)
+	}
+	if err = testCloudfrontFunctionCode("f", testViewerRequestCode, testCases, nil, nil, &strings.Builder{}); err != nil {
+		t.Error(err)
+	}
+	for _, testsJSON := range []string{`{}`, `[{"event_type": "origin-request", "uri": "/"}]`, `[{"event_type": "viewer-request", "uri": "old"}]`} {
+		if _, err = parseUserCloudfrontFunctionTestCases([]byte(testsJSON), cloudfrontEventViewerRequest); err == nil {
+			t.Errorf("%s: expected an error", testsJSON)
+		}
+	}
+}
+
+// TestAddPreviewAuthorizationToTestCases runs the generated events against a
+// password protected preview's function: they pass with the credentials and
+// the added case checks a viewer without them gets a 401.
+func TestAddPreviewAuthorizationToTestCases(t *testing.T) {
+	authorization := aws_utils.GetBasicAuthorization(aws_utils.PreviewAccessUsername, "secret")
+	code := aws_utils.GetBasicAuthViewerRequestFunctionCode(authorization)
+	buildPaths := []string{"/index.html", "/about/index.html"}
+	testCases := getGeneratedCloudfrontFunctionTestCases(cloudfrontEventViewerRequest, defaultTestDistributionDomain, buildPaths)
+	testCases = addPreviewAuthorizationToTestCases(testCases, authorization)
+	if err := testCloudfrontFunctionCode("preview", code, testCases, buildPaths, nil, io.Discard); err != nil {
+		t.Error(err)
+	}
+	//the added case fails against a function that lets everyone in
+	if err := testCloudfrontFunctionCode("preview", testViewerRequestCode, testCases[len(testCases)-1:], nil, nil, io.Discard); err == nil {
+		t.Error("expected an error for a preview without a password check")
+	}
+
+	event := newCloudfrontFunctionEvent(cloudfrontEventViewerRequest, "/about/")
+	event.Request.Headers["host"] = cloudfrontFunctionValue{Value: defaultTestDistributionDomain}
+	event.Request.Headers["authorization"] = cloudfrontFunctionValue{Value: authorization}
+	output, err := runCloudfrontFunction(code, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.StatusCode != nil || output.Uri == nil || *output.Uri != "/about/" {
+		t.Errorf("output = %+v, want the request forwarded", output)
+	}
+	event.Request.Headers["cloudfront-forwarded-proto"] = cloudfrontFunctionValue{Value: "http"}
+	output, err = runCloudfrontFunction(code, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.StatusCode == nil || *output.StatusCode != 301 ||
+		output.Headers["location"].Value != "https://"+defaultTestDistributionDomain+"/about/" {
+		t.Errorf("output = %+v, want a redirect to https", output)
+	}
+}
+
+// TestIsBuildFileUri only strips the suffix of a precompressed variant when
+// the build was precompressed and accepts extension-less files rewritten to
+// a directory index.
+func TestIsBuildFileUri(t *testing.T) {
+	buildPaths := map[string]bool{"/app.js": true, "/CNAME": true, "/docs/": true, "/docs/index.html": true}
+	cases := []struct {
+		requestUri, uri string
+		suffixes        []string
+		want            bool
+	}{
+		{"/app.js", "/app.js", nil, true},
+		{"/app.js", "/app.js.br", []string{".br", ".gz"}, true},
+		{"/app.js", "/app.js.br", nil, false},
+		{"/app.js", "/app.js.gz", []string{".br"}, false},
+		{"/CNAME", "/CNAME/index.html", nil, true},
+		{"/docs/", "/docs/index.html", nil, true},
+		{"/docs/", "/docs/index.htm", nil, false},
+		{"/app.js", "/other.js", nil, false},
+	}
+	for _, c := range cases {
+		if got := isBuildFileUri(c.requestUri, c.uri, buildPaths, c.suffixes); got != c.want {
+			t.Errorf("isBuildFileUri(%s, %s, %v) = %v, want %v", c.requestUri, c.uri, c.suffixes, got, c.want)
+		}
+	}
+}
+
+func TestSampleBuildPaths(t *testing.T) {
+	var buildPaths []string
+	for _, directory := range []string{"/a", "/b", "/c", "/d"} {
+		for i := 0; i < 30; i++ {
+			buildPaths = append(buildPaths, fmt.Sprintf("%s/%02d.html", directory, i))
+		}
+	}
+	sampled := sampleBuildPaths(buildPaths)
+	if len(sampled) != maxBuildPathTestCases {
+		t.Fatalf("sampled %d paths, want %d", len(sampled), maxBuildPathTestCases)
+	}
+	directories := map[string]bool{}
+	for _, buildPath := range sampled {
+		directories[buildPath[:2]] = true
+	}
+	if len(directories) != 4 {
+		t.Errorf("sampled paths %v don't cover every directory", sampled)
+	}
+	if got := sampleBuildPaths([]string{"/b", "/a"}); !reflect.DeepEqual(got, []string{"/a", "/b"}) {
+		t.Errorf("sampled = %v", got)
+	}
+}
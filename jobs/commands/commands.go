@@ -84,6 +84,48 @@ func Get(p commands_enums.Type) (jobs.Command, error) {
 		return &BuildInfraContext{}, nil
 	case commands_enums.MaterializeContext:
 		return &MaterializeContext{}, nil
+	case commands_enums.RunAwsEcsTask:
+		return &RunAwsEcsTask{}, nil
+	case commands_enums.ExecAwsEcsTaskSession:
+		return &ExecAwsEcsTaskSession{}, nil
+	case commands_enums.SnapshotAwsRdsDatabase:
+		return &SnapshotAwsRdsDatabase{}, nil
+	case commands_enums.ListAwsRdsSnapshots:
+		return &ListAwsRdsSnapshots{}, nil
+	case commands_enums.RestoreAwsRdsDatabase:
+		return &RestoreAwsRdsDatabase{}, nil
+	case commands_enums.CloneAwsRdsDatabase:
+		return &CloneAwsRdsDatabase{}, nil
+	case commands_enums.DeployAwsScheduledJob:
+		return &DeployAwsScheduledJob{}, nil
+	case commands_enums.DeleteAwsScheduledJob:
+		return &DeleteAwsScheduledJob{}, nil
+	case commands_enums.ListAwsScheduledJobInvocations:
+		return &ListAwsScheduledJobInvocations{}, nil
+	case commands_enums.DeployAwsNlbService:
+		return &DeployAwsNlbService{}, nil
+	case commands_enums.DeleteAwsNlbService:
+		return &DeleteAwsNlbService{}, nil
+	case commands_enums.DeployAwsElastiCache:
+		return &DeployAwsElastiCache{}, nil
+	case commands_enums.DeleteAwsElastiCache:
+		return &DeleteAwsElastiCache{}, nil
+	case commands_enums.DeployAwsManagedResource:
+		return &DeployAwsManagedResource{}, nil
+	case commands_enums.DeleteAwsManagedResource:
+		return &DeleteAwsManagedResource{}, nil
+	case commands_enums.DeployAwsLambdaFunction:
+		return &DeployAwsLambdaFunction{}, nil
+	case commands_enums.DeleteAwsLambdaFunction:
+		return &DeleteAwsLambdaFunction{}, nil
+	case commands_enums.RollbackAwsLambdaFunction:
+		return &RollbackAwsLambdaFunction{}, nil
+	case commands_enums.RollbackAwsStaticSite:
+		return &RollbackAwsStaticSite{}, nil
+	case commands_enums.InventoryAcmCertificates:
+		return &InventoryAcmCertificates{}, nil
+	case commands_enums.ImportAcmCertificate:
+		return &ImportAcmCertificate{}, nil
 	}
 	return nil, fmt.Errorf("error getting command for %s", p)
 }
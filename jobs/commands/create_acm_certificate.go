@@ -64,8 +64,12 @@ func (c *CreateAcmCertificate) Run(parameters map[string]interface{}, logsWriter
 		},
 		Tags: []acmTypes.Tag{
 			{
-				Key:   aws.String("created by"),
-				Value: aws.String("deployment.io"),
+				Key:   aws.String(certificateCreatedByTagKey),
+				Value: aws.String(certificateCreatedByTagValue),
+			},
+			{
+				Key:   aws.String(certificateIDTagKey),
+				Value: aws.String(certificateID),
 			},
 		},
 	})
@@ -0,0 +1,108 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/elasticache"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	secretsmanager_types "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/deployments"
+	"github.com/deployment-io/deployment-runner-kit/enums/deployment_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
+)
+
+// DeleteAwsElastiCache deletes the deployment's replication group, then its
+// security group and AUTH token. The cache subnet group is shared by the
+// organization and stays.
+type DeleteAwsElastiCache struct {
+}
+
+func (d *DeleteAwsElastiCache) Run(parameters map[string]interface{}, logsWriter io.Writer) (newParameters map[string]interface{}, err error) {
+	io.WriteString(logsWriter, fmt.Sprintf("Deleting ElastiCache\n"))
+
+	err = addElastiCachePolicyForDeploymentRunner(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	elastiCacheClient, err := cloud_api_clients.GetElastiCacheClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	replicationGroupId, err := getElastiCacheReplicationGroupId(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	replicationGroup, err := getElastiCacheReplicationGroup(elastiCacheClient, replicationGroupId)
+	if err != nil {
+		return parameters, err
+	}
+	if replicationGroup != nil {
+		if aws.ToString(replicationGroup.Status) != "deleting" {
+			io.WriteString(logsWriter, fmt.Sprintf("Deleting ElastiCache replication group: %s\n", replicationGroupId))
+			_, err = elastiCacheClient.DeleteReplicationGroup(context.TODO(), &elasticache.DeleteReplicationGroupInput{
+				ReplicationGroupId:   aws.String(replicationGroupId),
+				RetainPrimaryCluster: aws.Bool(false),
+			})
+			if err != nil {
+				return parameters, err
+			}
+		}
+		waiter := elasticache.NewReplicationGroupDeletedWaiter(elastiCacheClient)
+		err = waiter.Wait(context.TODO(), &elasticache.DescribeReplicationGroupsInput{
+			ReplicationGroupId: aws.String(replicationGroupId),
+		}, elastiCacheWaitDuration)
+		if err != nil {
+			return parameters, err
+		}
+	}
+
+	ec2Client, err := cloud_api_clients.GetEC2Client(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	securityGroupName, err := getElastiCacheSecurityGroupName(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	err = deleteSecurityGroupsWithName(ec2Client, securityGroupName)
+	if err != nil {
+		return parameters, err
+	}
+
+	secretsManagerClient, err := cloud_api_clients.GetSecretsManagerClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	_, err = secretsManagerClient.DeleteSecret(context.TODO(), &secretsmanager.DeleteSecretInput{
+		SecretId:                   aws.String(getElastiCacheAuthTokenSecretName(replicationGroupId)),
+		ForceDeleteWithoutRecovery: aws.Bool(true),
+	})
+	var resourceNotFoundException *secretsmanager_types.ResourceNotFoundException
+	if err != nil && !errors.As(err, &resourceNotFoundException) {
+		return parameters, err
+	}
+
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return parameters, err
+	}
+	var organizationIdFromJob string
+	organizationIdFromJob, err = jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIdFromJob)
+	if err != nil {
+		return parameters, err
+	}
+	//update deployment to deleted
+	commandUtils.UpdateDeploymentsPipeline.Add(organizationIdFromJob, deployments.UpdateDeploymentDtoV1{
+		ID:            deploymentID,
+		DeletionState: deployment_enums.DeletionDone,
+	})
+
+	return parameters, nil
+}
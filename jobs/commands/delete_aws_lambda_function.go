@@ -0,0 +1,112 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdaTypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/deployments"
+	"github.com/deployment-io/deployment-runner-kit/enums/deployment_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	"github.com/deployment-io/deployment-runner-kit/previews"
+	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
+)
+
+// DeleteAwsLambdaFunction deletes the function with all its versions, its
+// Function URL or load balancer, its role, secrets and image repository.
+type DeleteAwsLambdaFunction struct {
+}
+
+func (d *DeleteAwsLambdaFunction) Run(parameters map[string]interface{}, logsWriter io.Writer) (newParameters map[string]interface{}, err error) {
+	io.WriteString(logsWriter, fmt.Sprintf("Deleting Lambda function\n"))
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return parameters, err
+	}
+	organizationIdFromJob, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIdFromJob)
+	if err != nil {
+		return parameters, err
+	}
+	err = addLambdaPolicyForDeploymentRunner(parameters)
+	if err != nil {
+		return parameters, err
+	}
+
+	functionName, err := getLambdaFunctionName(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	lambdaClient, err := cloud_api_clients.GetLambdaClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	//versions, aliases, the URL and permissions go with the function
+	io.WriteString(logsWriter, fmt.Sprintf("Deleting Lambda function: %s\n", functionName))
+	_, err = lambdaClient.DeleteFunction(context.TODO(), &lambda.DeleteFunctionInput{
+		FunctionName: aws.String(functionName),
+	})
+	var resourceNotFoundException *lambdaTypes.ResourceNotFoundException
+	if err != nil && !errors.As(err, &resourceNotFoundException) {
+		return parameters, err
+	}
+
+	loadBalancerArn, _ := jobs.GetParameterValue[string](parameters, parameters_enums.LoadBalancerArn)
+	if len(loadBalancerArn) > 0 {
+		elbClient, err := cloud_api_clients.GetElbClient(parameters)
+		if err != nil {
+			return parameters, err
+		}
+		if isSharedAlb(parameters) {
+			err = deleteSharedAlbService(parameters, elbClient, loadBalancerArn, logsWriter)
+		} else {
+			err = deleteDedicatedAlb(parameters, elbClient, loadBalancerArn, logsWriter)
+		}
+		if err != nil {
+			return parameters, err
+		}
+	}
+
+	err = deleteSecretEnvironmentVariables(parameters, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	lambdaRoleName, err := getLambdaRoleName(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	iamClient, err := cloud_api_clients.GetIamClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	err = deleteRoleIfNeeded(iamClient, lambdaRoleName, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	//zip packages never had a repository
+	err = deleteEcrRepositoryIfNeeded(parameters, logsWriter)
+	var repositoryNotFoundException *ecrTypes.RepositoryNotFoundException
+	if err != nil && !errors.As(err, &repositoryNotFoundException) {
+		return parameters, err
+	}
+
+	if !isPreview(parameters) {
+		commandUtils.UpdateDeploymentsPipeline.Add(organizationIdFromJob, deployments.UpdateDeploymentDtoV1{
+			ID:            deploymentID,
+			DeletionState: deployment_enums.DeletionDone,
+		})
+	} else {
+		previewID := deploymentID
+		commandUtils.UpdatePreviewsPipeline.Add(organizationIdFromJob, previews.UpdatePreviewDtoV1{
+			ID:            previewID,
+			DeletionState: deployment_enums.DeletionDone,
+		})
+	}
+	return parameters, nil
+}
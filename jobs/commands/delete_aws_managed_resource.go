@@ -0,0 +1,196 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/deployments"
+	"github.com/deployment-io/deployment-runner-kit/enums/deployment_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	"github.com/deployment-io/deployment-runner-kit/previews"
+	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
+)
+
+// DeleteAwsManagedResource deletes the deployment's managed resource once
+// it's empty: no messages in the queues, no subscriptions on the topic, no
+// objects in the bucket. With RetainManagedResource the resource and its data
+// are kept and only the deployment is marked deleted.
+type DeleteAwsManagedResource struct {
+}
+
+// getSqsQueueMessageCount is the number of messages waiting, in flight or
+// delayed in the queue.
+func getSqsQueueMessageCount(sqsClient *sqs.Client, queueUrl string) (int, error) {
+	getQueueAttributesOutput, err := sqsClient.GetQueueAttributes(context.TODO(), &sqs.GetQueueAttributesInput{
+		QueueUrl: aws.String(queueUrl),
+		AttributeNames: []sqsTypes.QueueAttributeName{
+			sqsTypes.QueueAttributeNameApproximateNumberOfMessages,
+			sqsTypes.QueueAttributeNameApproximateNumberOfMessagesNotVisible,
+			sqsTypes.QueueAttributeNameApproximateNumberOfMessagesDelayed,
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, value := range getQueueAttributesOutput.Attributes {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
+func deleteSqsQueues(parameters map[string]interface{}, deploymentID string, logsWriter io.Writer) error {
+	sqsClient, err := cloud_api_clients.GetSqsClient(parameters)
+	if err != nil {
+		return err
+	}
+	var queues []*managedResource
+	for _, queueName := range []string{getSqsQueueName(deploymentID), getSqsDeadLetterQueueName(deploymentID)} {
+		queue, err := getSqsQueue(sqsClient, queueName)
+		if err != nil {
+			return err
+		}
+		if queue == nil {
+			continue
+		}
+		count, err := getSqsQueueMessageCount(sqsClient, queue.Url)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("SQS queue %s still has %d messages, drain it or retain it", queueName, count)
+		}
+		queues = append(queues, queue)
+	}
+	for _, queue := range queues {
+		io.WriteString(logsWriter, fmt.Sprintf("Deleting SQS queue: %s\n", queue.Url))
+		_, err = sqsClient.DeleteQueue(context.TODO(), &sqs.DeleteQueueInput{
+			QueueUrl: aws.String(queue.Url),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteSnsTopic(parameters map[string]interface{}, deploymentID string, logsWriter io.Writer) error {
+	snsClient, err := cloud_api_clients.GetSnsClient(parameters)
+	if err != nil {
+		return err
+	}
+	topicArn, err := getSnsTopicArn(snsClient, getSnsTopicName(deploymentID))
+	if err != nil || len(topicArn) == 0 {
+		return err
+	}
+	listSubscriptionsByTopicOutput, err := snsClient.ListSubscriptionsByTopic(context.TODO(), &sns.ListSubscriptionsByTopicInput{
+		TopicArn: aws.String(topicArn),
+	})
+	if err != nil {
+		return err
+	}
+	if len(listSubscriptionsByTopicOutput.Subscriptions) > 0 {
+		return fmt.Errorf("SNS topic %s still has subscriptions, remove them or retain it", topicArn)
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Deleting SNS topic: %s\n", topicArn))
+	_, err = snsClient.DeleteTopic(context.TODO(), &sns.DeleteTopicInput{
+		TopicArn: aws.String(topicArn),
+	})
+	return err
+}
+
+func deleteS3Bucket(parameters map[string]interface{}, deploymentID string, logsWriter io.Writer) error {
+	organizationID, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIDNamespace)
+	if err != nil {
+		return err
+	}
+	resource, err := getManagedResource(parameters, managedResourceS3, deploymentID)
+	if err != nil || resource == nil {
+		return err
+	}
+	s3Client, err := cloud_api_clients.GetS3Client(parameters)
+	if err != nil {
+		return err
+	}
+	bucketName := getManagedBucketName(organizationID, deploymentID)
+	//object versions cover unversioned buckets too
+	listObjectVersionsOutput, err := s3Client.ListObjectVersions(context.TODO(), &s3.ListObjectVersionsInput{
+		Bucket:  aws.String(bucketName),
+		MaxKeys: aws.Int32(1),
+	})
+	if err != nil {
+		return err
+	}
+	if len(listObjectVersionsOutput.Versions) > 0 || len(listObjectVersionsOutput.DeleteMarkers) > 0 {
+		return fmt.Errorf("S3 bucket %s isn't empty, empty it or retain it", bucketName)
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Deleting S3 bucket: %s\n", bucketName))
+	_, err = s3Client.DeleteBucket(context.TODO(), &s3.DeleteBucketInput{
+		Bucket: aws.String(bucketName),
+	})
+	return err
+}
+
+func (d *DeleteAwsManagedResource) Run(parameters map[string]interface{}, logsWriter io.Writer) (newParameters map[string]interface{}, err error) {
+	resourceType, err := getManagedResourceType(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return parameters, err
+	}
+	organizationIdFromJob, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIdFromJob)
+	if err != nil {
+		return parameters, err
+	}
+
+	retain, _ := jobs.GetParameterValue[bool](parameters, parameters_enums.RetainManagedResource)
+	if retain {
+		io.WriteString(logsWriter, fmt.Sprintf("Retaining %s resource\n", resourceType))
+	} else {
+		io.WriteString(logsWriter, fmt.Sprintf("Deleting %s resource\n", resourceType))
+		err = addManagedResourcesPolicyForDeploymentRunner(parameters)
+		if err != nil {
+			return parameters, err
+		}
+		switch resourceType {
+		case managedResourceSqs:
+			err = deleteSqsQueues(parameters, deploymentID, logsWriter)
+		case managedResourceSns:
+			err = deleteSnsTopic(parameters, deploymentID, logsWriter)
+		case managedResourceS3:
+			err = deleteS3Bucket(parameters, deploymentID, logsWriter)
+		}
+		if err != nil {
+			return parameters, err
+		}
+	}
+
+	if !isPreview(parameters) {
+		commandUtils.UpdateDeploymentsPipeline.Add(organizationIdFromJob, deployments.UpdateDeploymentDtoV1{
+			ID:            deploymentID,
+			DeletionState: deployment_enums.DeletionDone,
+		})
+	} else {
+		previewID := deploymentID
+		commandUtils.UpdatePreviewsPipeline.Add(organizationIdFromJob, previews.UpdatePreviewDtoV1{
+			ID:            previewID,
+			DeletionState: deployment_enums.DeletionDone,
+		})
+	}
+	return parameters, nil
+}
@@ -0,0 +1,348 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/smithy-go"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/deployments"
+	"github.com/deployment-io/deployment-runner-kit/enums/deployment_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	"github.com/deployment-io/deployment-runner-kit/previews"
+	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
+	"github.com/deployment-io/deployment-runner/utils/aws_utils"
+)
+
+type DeleteAwsNlbService struct {
+}
+
+func deleteEcsServiceAndWait(parameters map[string]interface{}, ecsClient *ecs.Client, logsWriter io.Writer) error {
+	clusterArn, err := jobs.GetParameterValue[string](parameters, parameters_enums.EcsClusterArn)
+	if err != nil {
+		return err
+	}
+	ecsServiceName, err := aws_utils.GetEcsServiceName(parameters)
+	if err != nil {
+		return err
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Deleting ECS service: %s in cluster: %s\n", ecsServiceName, clusterArn))
+	_, err = ecsClient.DeleteService(context.TODO(), &ecs.DeleteServiceInput{
+		Service: aws.String(ecsServiceName),
+		Cluster: aws.String(clusterArn),
+		Force:   aws.Bool(true),
+	})
+	if err != nil {
+		return err
+	}
+	inactiveWaiter := ecs.NewServicesInactiveWaiter(ecsClient)
+	return inactiveWaiter.Wait(context.TODO(), &ecs.DescribeServicesInput{
+		Services: []string{ecsServiceName},
+		Cluster:  aws.String(clusterArn),
+	}, 20*time.Minute)
+}
+
+// deleteTaskDefinitionFamily deregisters and deletes every revision of the
+// deployment's task definition family.
+func deleteTaskDefinitionFamily(parameters map[string]interface{}, ecsClient *ecs.Client, logsWriter io.Writer) error {
+	taskDefinitionFamilyName, err := getTaskDefinitionFamilyName(parameters)
+	if err != nil {
+		return err
+	}
+	listTaskDefinitionsOutput, err := ecsClient.ListTaskDefinitions(context.TODO(), &ecs.ListTaskDefinitionsInput{
+		FamilyPrefix: aws.String(taskDefinitionFamilyName),
+	})
+	if err != nil {
+		return err
+	}
+	taskDefinitionArns := listTaskDefinitionsOutput.TaskDefinitionArns
+	for _, taskDefinitionArn := range taskDefinitionArns {
+		io.WriteString(logsWriter, fmt.Sprintf("Deleting task definition: %s\n", taskDefinitionArn))
+		_, err = ecsClient.DeregisterTaskDefinition(context.TODO(), &ecs.DeregisterTaskDefinitionInput{TaskDefinition: aws.String(taskDefinitionArn)})
+		if err != nil {
+			return err
+		}
+		time.Sleep(time.Second)
+	}
+	var taskDefinitionArnsSet []string
+	for _, taskDefinitionArn := range taskDefinitionArns {
+		taskDefinitionArnsSet = append(taskDefinitionArnsSet, taskDefinitionArn)
+		if len(taskDefinitionArnsSet) == 8 {
+			_, err = ecsClient.DeleteTaskDefinitions(context.TODO(), &ecs.DeleteTaskDefinitionsInput{TaskDefinitions: taskDefinitionArnsSet})
+			if err != nil {
+				return err
+			}
+			taskDefinitionArnsSet = nil
+		}
+	}
+	if len(taskDefinitionArnsSet) > 0 {
+		_, err = ecsClient.DeleteTaskDefinitions(context.TODO(), &ecs.DeleteTaskDefinitionsInput{TaskDefinitions: taskDefinitionArnsSet})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteEcrRepositoryIfNeeded(parameters map[string]interface{}, logsWriter io.Writer) error {
+	deployedFromImage, _ := jobs.GetParameterValue[bool](parameters, parameters_enums.DeployedFromImage)
+	if deployedFromImage {
+		return nil
+	}
+	ecrClient, err := cloud_api_clients.GetEcrClient(parameters)
+	if err != nil {
+		return err
+	}
+	ecrRepositoryName, err := getEcrRepositoryName(parameters)
+	if err != nil {
+		return err
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Deleting ECR repository: %s\n", ecrRepositoryName))
+	_, err = ecrClient.DeleteRepository(context.TODO(), &ecr.DeleteRepositoryInput{
+		RepositoryName: aws.String(ecrRepositoryName),
+		Force:          true,
+	})
+	return err
+}
+
+func deleteNlbAndTargetGroups(parameters map[string]interface{}, elbClient *elasticloadbalancingv2.Client, logsWriter io.Writer) error {
+	nlbName, err := getNlbName(parameters)
+	if err != nil {
+		return err
+	}
+	describeLoadBalancersOutput, err := elbClient.DescribeLoadBalancers(context.TODO(), &elasticloadbalancingv2.DescribeLoadBalancersInput{
+		Names: []string{
+			nlbName,
+		},
+	})
+	var loadBalancerNotFoundException *elbTypes.LoadBalancerNotFoundException
+	if err != nil && !errors.As(err, &loadBalancerNotFoundException) {
+		return err
+	}
+	if err == nil && len(describeLoadBalancersOutput.LoadBalancers) > 0 {
+		loadBalancerArn := aws.ToString(describeLoadBalancersOutput.LoadBalancers[0].LoadBalancerArn)
+		io.WriteString(logsWriter, fmt.Sprintf("Deleting load balancer: %s\n", loadBalancerArn))
+		_, err = elbClient.DeleteLoadBalancer(context.TODO(), &elasticloadbalancingv2.DeleteLoadBalancerInput{LoadBalancerArn: aws.String(loadBalancerArn)})
+		if err != nil {
+			return err
+		}
+		loadBalancersDeletedWaiter := elasticloadbalancingv2.NewLoadBalancersDeletedWaiter(elbClient)
+		err = loadBalancersDeletedWaiter.Wait(context.TODO(), &elasticloadbalancingv2.DescribeLoadBalancersInput{
+			LoadBalancerArns: []string{
+				loadBalancerArn,
+			},
+		}, 20*time.Minute)
+		if err != nil {
+			return err
+		}
+		//sleep after nlb is deleted else AWS might give an error
+		time.Sleep(1 * time.Minute)
+	}
+
+	//target groups of listeners removed in earlier deployments are deleted as well
+	for i := 0; i < maxNlbListeners; i++ {
+		targetGroupName, err := getNlbTargetGroupName(parameters, i)
+		if err != nil {
+			return err
+		}
+		describeTargetGroupsOutput, err := elbClient.DescribeTargetGroups(context.TODO(), &elasticloadbalancingv2.DescribeTargetGroupsInput{
+			Names: []string{
+				targetGroupName,
+			},
+		})
+		var targetGroupNotFoundException *elbTypes.TargetGroupNotFoundException
+		if errors.As(err, &targetGroupNotFoundException) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, targetGroup := range describeTargetGroupsOutput.TargetGroups {
+			io.WriteString(logsWriter, fmt.Sprintf("Deleting target group: %s\n", aws.ToString(targetGroup.TargetGroupArn)))
+			_, err = elbClient.DeleteTargetGroup(context.TODO(), &elasticloadbalancingv2.DeleteTargetGroupInput{TargetGroupArn: targetGroup.TargetGroupArn})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// releaseNlbElasticIps releases the deployment's Elastic IPs. The load
+// balancer's network interfaces take a while to go away after it's deleted,
+// so addresses still in use are retried for a few minutes.
+func releaseNlbElasticIps(parameters map[string]interface{}, ec2Client *ec2.Client, logsWriter io.Writer) error {
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return err
+	}
+	describeAddressesOutput, err := ec2Client.DescribeAddresses(context.TODO(), &ec2.DescribeAddressesInput{
+		Filters: []ec2Types.Filter{
+			{
+				Name: aws.String("tag:Name"),
+				Values: []string{
+					fmt.Sprintf("eip-%s-*", deploymentID),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	for _, address := range describeAddressesOutput.Addresses {
+		io.WriteString(logsWriter, fmt.Sprintf("Releasing Elastic IP: %s\n", aws.ToString(address.PublicIp)))
+		for attempt := 0; ; attempt++ {
+			_, err = ec2Client.ReleaseAddress(context.TODO(), &ec2.ReleaseAddressInput{
+				AllocationId: address.AllocationId,
+			})
+			var apiErr smithy.APIError
+			if err != nil && errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidIPAddress.InUse" && attempt < 20 {
+				time.Sleep(15 * time.Second)
+				continue
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func revokeNlbIngressRulesFromDefaultVpcSecurityGroup(parameters map[string]interface{}, ec2Client *ec2.Client, logsWriter io.Writer) error {
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return err
+	}
+	vpcId, err := jobs.GetParameterValue[string](parameters, parameters_enums.VpcID)
+	if err != nil {
+		return err
+	}
+	defaultSecurityGroupId, err := getDefaultSecurityGroupIdForVpc(parameters, ec2Client, vpcId)
+	if err != nil {
+		return err
+	}
+	securityGroupRules, err := getNlbIngressRulesOfDeployment(ec2Client, defaultSecurityGroupId, deploymentID)
+	if err != nil {
+		return err
+	}
+	if len(securityGroupRules) == 0 {
+		return nil
+	}
+	var securityGroupRuleIds []string
+	for _, securityGroupRule := range securityGroupRules {
+		securityGroupRuleIds = append(securityGroupRuleIds, aws.ToString(securityGroupRule.SecurityGroupRuleId))
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Revoking security group rules: %v\n", securityGroupRuleIds))
+	_, err = ec2Client.RevokeSecurityGroupIngress(context.TODO(), &ec2.RevokeSecurityGroupIngressInput{
+		DryRun:               aws.Bool(false),
+		GroupId:              aws.String(defaultSecurityGroupId),
+		SecurityGroupRuleIds: securityGroupRuleIds,
+	})
+	if err != nil {
+		return err
+	}
+	return reportDefaultSecurityGroupIngressRules(parameters, ec2Client, defaultSecurityGroupId)
+}
+
+func (d *DeleteAwsNlbService) Run(parameters map[string]interface{}, logsWriter io.Writer) (newParameters map[string]interface{}, err error) {
+	io.WriteString(logsWriter, fmt.Sprintf("Deleting nlb service\n"))
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return parameters, err
+	}
+	var organizationIdFromJob string
+	organizationIdFromJob, err = jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIdFromJob)
+	if err != nil {
+		return parameters, err
+	}
+	if !isPreview(parameters) {
+		commandUtils.UpdateDeploymentsPipeline.Add(organizationIdFromJob, deployments.UpdateDeploymentDtoV1{
+			ID:            deploymentID,
+			DeletionState: deployment_enums.DeletionInProcess,
+		})
+	} else {
+		previewID := deploymentID
+		commandUtils.UpdatePreviewsPipeline.Add(organizationIdFromJob, previews.UpdatePreviewDtoV1{
+			ID:            previewID,
+			DeletionState: deployment_enums.DeletionInProcess,
+		})
+	}
+
+	ecsClient, err := cloud_api_clients.GetEcsClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	err = deleteEcsServiceAndWait(parameters, ecsClient, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	err = deleteTaskDefinitionFamily(parameters, ecsClient, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	err = deleteSecretEnvironmentVariables(parameters, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	err = deleteEfsVolumesIfNeeded(parameters, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	err = deleteEcsTaskRoleIfNeeded(parameters, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	err = deleteEcrRepositoryIfNeeded(parameters, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+
+	elbClient, err := cloud_api_clients.GetElbClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	err = deleteNlbAndTargetGroups(parameters, elbClient, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+
+	ec2Client, err := cloud_api_clients.GetEC2Client(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	err = releaseNlbElasticIps(parameters, ec2Client, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	err = revokeNlbIngressRulesFromDefaultVpcSecurityGroup(parameters, ec2Client, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+
+	if !isPreview(parameters) {
+		commandUtils.UpdateDeploymentsPipeline.Add(organizationIdFromJob, deployments.UpdateDeploymentDtoV1{
+			ID:            deploymentID,
+			DeletionState: deployment_enums.DeletionDone,
+		})
+	} else {
+		previewID := deploymentID
+		commandUtils.UpdatePreviewsPipeline.Add(organizationIdFromJob, previews.UpdatePreviewDtoV1{
+			ID:            previewID,
+			DeletionState: deployment_enums.DeletionDone,
+		})
+	}
+
+	return parameters, err
+}
@@ -115,6 +115,20 @@ func (d *DeleteAwsPrivateService) Run(parameters map[string]interface{}, logsWri
 		}
 	}
 
+	//delete secret environment variables
+	err = deleteSecretEnvironmentVariables(parameters, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	err = deleteEfsVolumesIfNeeded(parameters, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	err = deleteEcsTaskRoleIfNeeded(parameters, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+
 	deployedFromImage, _ := jobs.GetParameterValue[bool](parameters, parameters_enums.DeployedFromImage)
 	if !deployedFromImage {
 		//delete ecr repository if necessary
@@ -27,21 +27,70 @@ func (d *DeleteAwsRdsDatabase) Run(parameters map[string]interface{}, logsWriter
 		return parameters, err
 	}
 
-	rdsInstanceIdentifier, err := getRdsDBInstanceIdentifier(parameters)
+	auroraDeleted, err := deleteAwsAuroraServerless(parameters, rdsClient, logsWriter)
 	if err != nil {
 		return parameters, err
 	}
+	if !auroraDeleted {
+		var rdsInstanceIdentifier string
+		rdsInstanceIdentifier, err = getRdsDBInstanceIdentifier(parameters)
+		if err != nil {
+			return parameters, err
+		}
+		//instances restores replaced use the deployment's parameter groups too
+		err = deleteReplacedRdsInstances(parameters, rdsClient, rdsInstanceIdentifier, true, logsWriter)
+		if err != nil {
+			return parameters, err
+		}
+		err = deleteRdsInstance(parameters, rdsClient, logsWriter)
+		if err != nil {
+			return parameters, err
+		}
+	}
+
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return parameters, err
+	}
+	var organizationIdFromJob string
+	organizationIdFromJob, err = jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIdFromJob)
+	if err != nil {
+		return parameters, err
+	}
+	//update deployment to deleted
+	commandUtils.UpdateDeploymentsPipeline.Add(organizationIdFromJob, deployments.UpdateDeploymentDtoV1{
+		ID:            deploymentID,
+		DeletionState: deployment_enums.DeletionDone,
+	})
+
+	return parameters, nil
+}
+
+func deleteRdsInstance(parameters map[string]interface{}, rdsClient *rds.Client, logsWriter io.Writer) error {
+	rdsInstanceIdentifier, err := getRdsDBInstanceIdentifier(parameters)
+	if err != nil {
+		return err
+	}
 
 	describeDBInstances, err := rdsClient.DescribeDBInstances(context.TODO(), &rds.DescribeDBInstancesInput{
 		DBInstanceIdentifier: aws.String(rdsInstanceIdentifier),
 	})
 
 	if err != nil {
-		return parameters, err
+		return err
 	}
 
 	if len(describeDBInstances.DBInstances) == 0 {
-		return parameters, fmt.Errorf("RDS instance doesn't exists")
+		return fmt.Errorf("RDS instance doesn't exists")
+	}
+
+	err = snapshotRdsBeforeChange(parameters, rdsClient, rdsInstanceIdentifier, rdsSnapshotPreDelete, logsWriter)
+	if err != nil {
+		return err
+	}
+	err = deleteRdsReadReplicas(rdsClient, describeDBInstances.DBInstances[0], logsWriter)
+	if err != nil {
+		return err
 	}
 
 	if describeDBInstances.DBInstances[0].DeletionProtection != nil && *describeDBInstances.DBInstances[0].DeletionProtection {
@@ -51,7 +100,7 @@ func (d *DeleteAwsRdsDatabase) Run(parameters map[string]interface{}, logsWriter
 		})
 
 		if err != nil {
-			return parameters, err
+			return err
 		}
 	}
 
@@ -61,23 +110,13 @@ func (d *DeleteAwsRdsDatabase) Run(parameters map[string]interface{}, logsWriter
 	})
 
 	if err != nil {
-		return parameters, err
+		return err
 	}
 
-	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	err = deleteDBParameterGroups(parameters, rdsClient, rdsInstanceIdentifier, logsWriter)
 	if err != nil {
-		return parameters, err
-	}
-	var organizationIdFromJob string
-	organizationIdFromJob, err = jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIdFromJob)
-	if err != nil {
-		return parameters, err
+		return err
 	}
-	//update deployment to deleted
-	commandUtils.UpdateDeploymentsPipeline.Add(organizationIdFromJob, deployments.UpdateDeploymentDtoV1{
-		ID:            deploymentID,
-		DeletionState: deployment_enums.DeletionDone,
-	})
 
-	return parameters, nil
+	return nil
 }
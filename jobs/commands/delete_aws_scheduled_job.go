@@ -0,0 +1,147 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/scheduler"
+	schedulerTypes "github.com/aws/aws-sdk-go-v2/service/scheduler/types"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/deployments"
+	"github.com/deployment-io/deployment-runner-kit/enums/deployment_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	"github.com/deployment-io/deployment-runner-kit/previews"
+	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
+)
+
+type DeleteAwsScheduledJob struct {
+}
+
+func (d *DeleteAwsScheduledJob) Run(parameters map[string]interface{}, logsWriter io.Writer) (newParameters map[string]interface{}, err error) {
+	io.WriteString(logsWriter, fmt.Sprintf("Deleting scheduled job\n"))
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return parameters, err
+	}
+	var organizationIdFromJob string
+	organizationIdFromJob, err = jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIdFromJob)
+	if err != nil {
+		return parameters, err
+	}
+	if !isPreview(parameters) {
+		commandUtils.UpdateDeploymentsPipeline.Add(organizationIdFromJob, deployments.UpdateDeploymentDtoV1{
+			ID:            deploymentID,
+			DeletionState: deployment_enums.DeletionInProcess,
+		})
+	} else {
+		previewID := deploymentID
+		commandUtils.UpdatePreviewsPipeline.Add(organizationIdFromJob, previews.UpdatePreviewDtoV1{
+			ID:            previewID,
+			DeletionState: deployment_enums.DeletionInProcess,
+		})
+	}
+
+	//delete schedule first so no new runs start
+	scheduleName, err := getScheduleName(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	schedulerClient, err := cloud_api_clients.GetSchedulerClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Deleting schedule: %s\n", scheduleName))
+	_, err = schedulerClient.DeleteSchedule(context.TODO(), &scheduler.DeleteScheduleInput{
+		Name: aws.String(scheduleName),
+	})
+	var resourceNotFoundException *schedulerTypes.ResourceNotFoundException
+	if err != nil && !errors.As(err, &resourceNotFoundException) {
+		return parameters, err
+	}
+
+	//stop runs that are still in progress
+	clusterArn, err := jobs.GetParameterValue[string](parameters, parameters_enums.EcsClusterArn)
+	if err != nil {
+		return parameters, err
+	}
+	ecsClient, err := cloud_api_clients.GetEcsClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	taskDefinitionFamilyName, err := getTaskDefinitionFamilyName(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	listTasksOutput, err := ecsClient.ListTasks(context.TODO(), &ecs.ListTasksInput{
+		Cluster:       aws.String(clusterArn),
+		Family:        aws.String(taskDefinitionFamilyName),
+		DesiredStatus: ecsTypes.DesiredStatusRunning,
+	})
+	if err != nil {
+		return parameters, err
+	}
+	for _, taskArn := range listTasksOutput.TaskArns {
+		io.WriteString(logsWriter, fmt.Sprintf("Stopping task: %s\n", taskArn))
+		_, err = ecsClient.StopTask(context.TODO(), &ecs.StopTaskInput{
+			Task:    aws.String(taskArn),
+			Cluster: aws.String(clusterArn),
+			Reason:  aws.String("scheduled job deleted"),
+		})
+		if err != nil {
+			return parameters, err
+		}
+	}
+	if len(listTasksOutput.TaskArns) > 0 {
+		tasksStoppedWaiter := ecs.NewTasksStoppedWaiter(ecsClient)
+		err = tasksStoppedWaiter.Wait(context.TODO(), &ecs.DescribeTasksInput{
+			Tasks:   listTasksOutput.TaskArns,
+			Cluster: aws.String(clusterArn),
+		}, 10*time.Minute)
+		if err != nil {
+			return parameters, err
+		}
+	}
+
+	err = deleteTaskDefinitionFamily(parameters, ecsClient, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	err = deleteSecretEnvironmentVariables(parameters, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	err = deleteEfsVolumesIfNeeded(parameters, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	err = deleteEcsTaskRoleIfNeeded(parameters, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	err = deleteEcrRepositoryIfNeeded(parameters, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+
+	if !isPreview(parameters) {
+		commandUtils.UpdateDeploymentsPipeline.Add(organizationIdFromJob, deployments.UpdateDeploymentDtoV1{
+			ID:            deploymentID,
+			DeletionState: deployment_enums.DeletionDone,
+		})
+	} else {
+		previewID := deploymentID
+		commandUtils.UpdatePreviewsPipeline.Add(organizationIdFromJob, previews.UpdatePreviewDtoV1{
+			ID:            previewID,
+			DeletionState: deployment_enums.DeletionDone,
+		})
+	}
+
+	return parameters, err
+}
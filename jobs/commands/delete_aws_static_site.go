@@ -68,6 +68,14 @@ func (d *DeleteAwsStaticSite) Run(parameters map[string]interface{}, logsWriter
 		return parameters, fmt.Errorf("distribution doesn't exists")
 	}
 	distributionConfig := distributionConfigOutput.DistributionConfig
+	//records left pointing at a deleted distribution could be taken over
+	if distributionConfig.Aliases != nil && len(distributionConfig.Aliases.Items) > 0 {
+		target, err := getCloudfrontAliasTarget(cloudfrontClient, cloudfrontDistributionId)
+		if err != nil {
+			return parameters, err
+		}
+		deleteAliasRecords(parameters, distributionConfig.Aliases.Items, target, logsWriter)
+	}
 	distributionConfig.Enabled = aws.Bool(false)
 	//disable distribution
 	io.WriteString(logsWriter, fmt.Sprintf("Disabling cloudfront distribution: %s\n", cloudfrontDistributionId))
@@ -2,15 +2,17 @@ package commands
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
-	"github.com/aws/aws-sdk-go-v2/service/ecr"
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
 	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
 	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
 	"github.com/deployment-io/deployment-runner-kit/deployments"
 	"github.com/deployment-io/deployment-runner-kit/enums/deployment_enums"
@@ -117,25 +119,23 @@ func (d *DeleteAwsWebService) Run(parameters map[string]interface{}, logsWriter
 		}
 	}
 
-	deployedFromImage, _ := jobs.GetParameterValue[bool](parameters, parameters_enums.DeployedFromImage)
-	if !deployedFromImage {
-		//delete ecr repository if necessary
-		ecrClient, err := cloud_api_clients.GetEcrClient(parameters)
-		if err != nil {
-			return parameters, err
-		}
-		ecrRepositoryName, err := getEcrRepositoryName(parameters)
-		if err != nil {
-			return parameters, err
-		}
-		io.WriteString(logsWriter, fmt.Sprintf("Deleting ECR repository: %s\n", ecrRepositoryName))
-		_, err = ecrClient.DeleteRepository(context.TODO(), &ecr.DeleteRepositoryInput{
-			RepositoryName: aws.String(ecrRepositoryName),
-			Force:          true,
-		})
-		if err != nil {
-			return parameters, err
-		}
+	//delete secret environment variables
+	err = deleteSecretEnvironmentVariables(parameters, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	err = deleteEfsVolumesIfNeeded(parameters, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	err = deleteEcsTaskRoleIfNeeded(parameters, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+
+	err = deleteEcrRepositoryIfNeeded(parameters, logsWriter)
+	if err != nil {
+		return parameters, err
 	}
 
 	//delete listeners
@@ -145,11 +145,56 @@ func (d *DeleteAwsWebService) Run(parameters map[string]interface{}, logsWriter
 	if err != nil {
 		return parameters, err
 	}
-	io.WriteString(logsWriter, fmt.Sprintf("Deleting load balancer: %s\n", loadBalancerArn))
-	_, err = elbClient.DeleteLoadBalancer(context.TODO(), &elasticloadbalancingv2.DeleteLoadBalancerInput{LoadBalancerArn: aws.String(loadBalancerArn)})
+	//records left pointing at a deleted load balancer could be taken over
+	domains, err := getWebServiceDomains(parameters)
 	if err != nil {
 		return parameters, err
 	}
+	if len(domains) > 0 {
+		target, err := getLoadBalancerAliasTarget(elbClient, loadBalancerArn)
+		var loadBalancerNotFoundException *elbTypes.LoadBalancerNotFoundException
+		if errors.As(err, &loadBalancerNotFoundException) {
+			//the load balancer is gone but its records may not be, deletes match them by the stored dns name
+			loadBalancerDns, _ := jobs.GetParameterValue[string](parameters, parameters_enums.LoadBalancerDns)
+			if len(loadBalancerDns) > 0 {
+				deleteAliasRecords(parameters, domains, aliasTarget{DNSName: loadBalancerDns}, logsWriter)
+			} else {
+				io.WriteString(logsWriter, fmt.Sprintf("Load balancer %s doesn't exist, delete the records of %s "+
+					"pointing to it in your DNS provider\n", loadBalancerArn, strings.Join(domains, ", ")))
+			}
+		} else if err != nil {
+			return parameters, err
+		} else {
+			deleteAliasRecords(parameters, domains, target, logsWriter)
+		}
+	}
+	if isSharedAlb(parameters) {
+		err = deleteSharedAlbService(parameters, elbClient, loadBalancerArn, logsWriter)
+		if err != nil {
+			return parameters, err
+		}
+		markWebServiceDeletionDone(parameters, deploymentID, organizationIdFromJob)
+		return parameters, nil
+	}
+	err = deleteDedicatedAlb(parameters, elbClient, loadBalancerArn, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+
+	markWebServiceDeletionDone(parameters, deploymentID, organizationIdFromJob)
+
+	return parameters, err
+}
+
+// deleteDedicatedAlb deletes the deployment's own load balancer with its
+// target group and security group.
+func deleteDedicatedAlb(parameters map[string]interface{}, elbClient *elasticloadbalancingv2.Client,
+	loadBalancerArn string, logsWriter io.Writer) error {
+	io.WriteString(logsWriter, fmt.Sprintf("Deleting load balancer: %s\n", loadBalancerArn))
+	_, err := elbClient.DeleteLoadBalancer(context.TODO(), &elasticloadbalancingv2.DeleteLoadBalancerInput{LoadBalancerArn: aws.String(loadBalancerArn)})
+	if err != nil {
+		return err
+	}
 	loadBalancersDeletedWaiter := elasticloadbalancingv2.NewLoadBalancersDeletedWaiter(elbClient)
 	err = loadBalancersDeletedWaiter.Wait(context.TODO(), &elasticloadbalancingv2.DescribeLoadBalancersInput{
 		LoadBalancerArns: []string{
@@ -157,7 +202,7 @@ func (d *DeleteAwsWebService) Run(parameters map[string]interface{}, logsWriter
 		},
 	}, 20*time.Minute)
 	if err != nil {
-		return parameters, err
+		return err
 	}
 
 	//sleep after alb is deleted else AWS might give an error
@@ -166,22 +211,22 @@ func (d *DeleteAwsWebService) Run(parameters map[string]interface{}, logsWriter
 	//delete target group
 	targetGroupArn, err := jobs.GetParameterValue[string](parameters, parameters_enums.TargetGroupArn)
 	if err != nil {
-		return parameters, err
+		return err
 	}
 	io.WriteString(logsWriter, fmt.Sprintf("Deleting target group: %s\n", targetGroupArn))
 	_, err = elbClient.DeleteTargetGroup(context.TODO(), &elasticloadbalancingv2.DeleteTargetGroupInput{TargetGroupArn: aws.String(targetGroupArn)})
 	if err != nil {
-		return parameters, err
+		return err
 	}
 
 	//delete alb security group
 	ec2Client, err := cloud_api_clients.GetEC2Client(parameters)
 	if err != nil {
-		return parameters, err
+		return err
 	}
 	albSecurityGroupID, err := jobs.GetParameterValue[string](parameters, parameters_enums.AlbSecurityGroupId)
 	if err != nil {
-		return parameters, err
+		return err
 	}
 
 	io.WriteString(logsWriter, fmt.Sprintf("Deleting security group: %s\n", albSecurityGroupID))
@@ -189,10 +234,47 @@ func (d *DeleteAwsWebService) Run(parameters map[string]interface{}, logsWriter
 		DryRun:  aws.Bool(false),
 		GroupId: aws.String(albSecurityGroupID),
 	})
+	return err
+}
+
+// deleteSharedAlbService detaches the service from the shared load balancer
+// and deletes the load balancer if this was its last service.
+func deleteSharedAlbService(parameters map[string]interface{}, elbClient *elasticloadbalancingv2.Client,
+	loadBalancerArn string, logsWriter io.Writer) error {
+	unlock, err := lockSharedAlb(parameters)
 	if err != nil {
-		return parameters, err
+		return err
+	}
+	defer unlock()
+	targetGroupArn, err := jobs.GetParameterValue[string](parameters, parameters_enums.TargetGroupArn)
+	if err != nil {
+		return err
 	}
+	err = deleteSharedAlbListenerRules(elbClient, loadBalancerArn, targetGroupArn, logsWriter)
+	if err != nil {
+		return err
+	}
+	err = removeSharedAlbListenerCertificate(parameters, elbClient, loadBalancerArn, logsWriter)
+	if err != nil {
+		return err
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Deleting target group: %s\n", targetGroupArn))
+	_, err = elbClient.DeleteTargetGroup(context.TODO(), &elasticloadbalancingv2.DeleteTargetGroupInput{TargetGroupArn: aws.String(targetGroupArn)})
+	if err != nil {
+		return err
+	}
+	ec2Client, err := cloud_api_clients.GetEC2Client(parameters)
+	if err != nil {
+		return err
+	}
+	albSecurityGroupID, err := jobs.GetParameterValue[string](parameters, parameters_enums.AlbSecurityGroupId)
+	if err != nil {
+		return err
+	}
+	return deleteSharedAlbIfUnused(elbClient, ec2Client, loadBalancerArn, albSecurityGroupID, logsWriter)
+}
 
+func markWebServiceDeletionDone(parameters map[string]interface{}, deploymentID, organizationIdFromJob string) {
 	if !isPreview(parameters) {
 		//update deployment to deleted and delete domain
 		commandUtils.UpdateDeploymentsPipeline.Add(organizationIdFromJob, deployments.UpdateDeploymentDtoV1{
@@ -206,6 +288,4 @@ func (d *DeleteAwsWebService) Run(parameters map[string]interface{}, logsWriter
 			DeletionState: deployment_enums.DeletionDone,
 		})
 	}
-
-	return parameters, err
 }
@@ -0,0 +1,522 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	rdsTypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/deployments"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	"github.com/deployment-io/deployment-runner-kit/utils"
+	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
+)
+
+const (
+	rdsDatabaseTypeInstance         = "instance"
+	rdsDatabaseTypeAuroraServerless = "aurora_serverless"
+
+	defaultAuroraMinCapacity = 0.5
+	defaultAuroraMaxCapacity = 4
+	auroraServerlessClass    = "db.serverless"
+	auroraWaitDuration       = 30 * time.Minute
+)
+
+// getRdsDatabaseType is the explicit RdsDatabaseType, otherwise new previews
+// get an Aurora Serverless v2 cluster, which scales down while it sits idle,
+// and everything else, including previews that already have an instance, a
+// provisioned instance.
+func getRdsDatabaseType(parameters map[string]interface{}, rdsClient *rds.Client) (string, error) {
+	databaseType, err := jobs.GetParameterValue[string](parameters, parameters_enums.RdsDatabaseType)
+	if err != nil || len(databaseType) == 0 {
+		if !isPreview(parameters) {
+			return rdsDatabaseTypeInstance, nil
+		}
+		rdsInstanceIdentifier, err := getRdsDBInstanceIdentifier(parameters)
+		if err != nil {
+			return "", err
+		}
+		instanceExists, err := rdsInstanceExists(rdsClient, rdsInstanceIdentifier)
+		if err != nil {
+			return "", err
+		}
+		if instanceExists {
+			return rdsDatabaseTypeInstance, nil
+		}
+		return rdsDatabaseTypeAuroraServerless, nil
+	}
+	switch databaseType {
+	case rdsDatabaseTypeInstance, rdsDatabaseTypeAuroraServerless:
+		return databaseType, nil
+	}
+	return "", fmt.Errorf("unsupported rds database type %s", databaseType)
+}
+
+// getAuroraEngine maps the RDS engine to its Aurora flavour.
+func getAuroraEngine(engine string) (string, error) {
+	switch engine {
+	case "postgres":
+		return "aurora-postgresql", nil
+	case "mysql":
+		return "aurora-mysql", nil
+	}
+	return "", fmt.Errorf("%s is not available on Aurora Serverless, use a postgres or mysql engine", engine)
+}
+
+// getAuroraScalingConfiguration fills in the ACU defaults and rejects the
+// settings of provisioned instances that have no Aurora equivalent here.
+func getAuroraScalingConfiguration(settings *rdsSettings) (*rdsTypes.ServerlessV2ScalingConfiguration, error) {
+	if settings.ReadReplicas > 0 {
+		return nil, fmt.Errorf("read replicas are not supported on Aurora Serverless, use a reader instead")
+	}
+	if len(settings.Parameters) > 0 {
+		return nil, fmt.Errorf("custom parameters are not supported on Aurora Serverless")
+	}
+	if settings.BackupRetentionDays != nil && *settings.BackupRetentionDays == 0 {
+		return nil, fmt.Errorf("Aurora always keeps automated backups, backup retention must be at least 1 day")
+	}
+	minCapacity := defaultAuroraMinCapacity
+	if settings.MinCapacity != nil {
+		minCapacity = *settings.MinCapacity
+	}
+	maxCapacity := float64(defaultAuroraMaxCapacity)
+	if settings.MaxCapacity > 0 {
+		maxCapacity = settings.MaxCapacity
+	}
+	if minCapacity < 0 || maxCapacity < 1 || maxCapacity > 256 || minCapacity > maxCapacity {
+		return nil, fmt.Errorf("capacity must be between 0 and 256 ACUs with min %.1f not above max %.1f", minCapacity, maxCapacity)
+	}
+	return &rdsTypes.ServerlessV2ScalingConfiguration{
+		MinCapacity: aws.Float64(minCapacity),
+		MaxCapacity: aws.Float64(maxCapacity),
+	}, nil
+}
+
+func getAuroraClusterIdentifier(parameters map[string]interface{}) (string, error) {
+	//aurora-<deploymentID>
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("aurora-%s", deploymentID), nil
+}
+
+func getAuroraWriterIdentifier(clusterIdentifier string) string {
+	//aurora-<deploymentID>-w
+	return clusterIdentifier + "-w"
+}
+
+func getAuroraReaderIdentifier(clusterIdentifier string) string {
+	//aurora-<deploymentID>-r
+	return clusterIdentifier + "-r"
+}
+
+func getAuroraCluster(rdsClient *rds.Client, clusterIdentifier string) (*rdsTypes.DBCluster, error) {
+	describeDBClustersOutput, err := rdsClient.DescribeDBClusters(context.TODO(), &rds.DescribeDBClustersInput{
+		DBClusterIdentifier: aws.String(clusterIdentifier),
+	})
+	var dbClusterNotFoundFault *rdsTypes.DBClusterNotFoundFault
+	if errors.As(err, &dbClusterNotFoundFault) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(describeDBClustersOutput.DBClusters) == 0 {
+		return nil, nil
+	}
+	return &describeDBClustersOutput.DBClusters[0], nil
+}
+
+func getAuroraClusterMembers(cluster *rdsTypes.DBCluster) map[string]bool {
+	members := map[string]bool{}
+	for _, member := range cluster.DBClusterMembers {
+		members[aws.ToString(member.DBInstanceIdentifier)] = true
+	}
+	return members
+}
+
+// createAuroraInstanceIfNeeded adds a serverless instance to the cluster. The
+// writer is created first so it's the one Aurora makes the primary.
+func createAuroraInstanceIfNeeded(rdsClient *rds.Client, cluster *rdsTypes.DBCluster, instanceIdentifier string, promotionTier int32,
+	logsWriter io.Writer) error {
+	if getAuroraClusterMembers(cluster)[instanceIdentifier] {
+		return nil
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Creating Aurora instance: %s\n", instanceIdentifier))
+	_, err := rdsClient.CreateDBInstance(context.TODO(), &rds.CreateDBInstanceInput{
+		DBInstanceClass:         aws.String(auroraServerlessClass),
+		DBInstanceIdentifier:    aws.String(instanceIdentifier),
+		DBClusterIdentifier:     cluster.DBClusterIdentifier,
+		Engine:                  cluster.Engine,
+		AutoMinorVersionUpgrade: aws.Bool(true),
+		PromotionTier:           aws.Int32(promotionTier),
+		PubliclyAccessible:      aws.Bool(false),
+		Tags: []rdsTypes.Tag{
+			{
+				Key:   aws.String("Name"),
+				Value: aws.String(instanceIdentifier),
+			},
+			{
+				Key:   aws.String("created by"),
+				Value: aws.String("deployment.io"),
+			},
+		},
+	})
+	return err
+}
+
+// snapshotAuroraBeforeChange takes a cluster snapshot tagged with the job ID
+// before a modify or delete, unless SkipRdsSnapshot is set.
+func snapshotAuroraBeforeChange(parameters map[string]interface{}, rdsClient *rds.Client, clusterIdentifier, kind string,
+	logsWriter io.Writer) error {
+	skipRdsSnapshot, _ := jobs.GetParameterValue[bool](parameters, parameters_enums.SkipRdsSnapshot)
+	if skipRdsSnapshot {
+		return nil
+	}
+	snapshotIdentifier, err := getRdsSnapshotIdentifier(parameters, kind)
+	if err != nil {
+		return err
+	}
+	jobID, err := jobs.GetParameterValue[string](parameters, parameters_enums.JobID)
+	if err != nil {
+		return err
+	}
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return err
+	}
+	describeDBClusterSnapshotsOutput, err := rdsClient.DescribeDBClusterSnapshots(context.TODO(), &rds.DescribeDBClusterSnapshotsInput{
+		DBClusterSnapshotIdentifier: aws.String(snapshotIdentifier),
+	})
+	var dbClusterSnapshotNotFoundFault *rdsTypes.DBClusterSnapshotNotFoundFault
+	if err != nil && !errors.As(err, &dbClusterSnapshotNotFoundFault) {
+		return err
+	}
+	if err != nil || len(describeDBClusterSnapshotsOutput.DBClusterSnapshots) == 0 {
+		io.WriteString(logsWriter, fmt.Sprintf("Creating Aurora cluster snapshot: %s\n", snapshotIdentifier))
+		_, err = rdsClient.CreateDBClusterSnapshot(context.TODO(), &rds.CreateDBClusterSnapshotInput{
+			DBClusterIdentifier:         aws.String(clusterIdentifier),
+			DBClusterSnapshotIdentifier: aws.String(snapshotIdentifier),
+			Tags: []rdsTypes.Tag{
+				{
+					Key:   aws.String("Name"),
+					Value: aws.String(snapshotIdentifier),
+				},
+				{
+					Key:   aws.String("created by"),
+					Value: aws.String("deployment.io"),
+				},
+				{
+					Key:   aws.String("deployment-id"),
+					Value: aws.String(deploymentID),
+				},
+				{
+					Key:   aws.String("job-id"),
+					Value: aws.String(jobID),
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Waiting for Aurora cluster snapshot to be available: %s\n", snapshotIdentifier))
+	waiter := rds.NewDBClusterSnapshotAvailableWaiter(rdsClient)
+	return waiter.Wait(context.TODO(), &rds.DescribeDBClusterSnapshotsInput{
+		DBClusterSnapshotIdentifier: aws.String(snapshotIdentifier),
+	}, rdsSnapshotWaitDuration)
+}
+
+// isAuroraClusterModified tells whether the modify input changes any of the
+// cluster's settings.
+func isAuroraClusterModified(cluster rdsTypes.DBCluster, modifyDBClusterInput *rds.ModifyDBClusterInput) bool {
+	if modifyDBClusterInput.EngineVersion != nil {
+		return true
+	}
+	if aws.ToBool(modifyDBClusterInput.DeletionProtection) != aws.ToBool(cluster.DeletionProtection) {
+		return true
+	}
+	if modifyDBClusterInput.BackupRetentionPeriod != nil &&
+		aws.ToInt32(modifyDBClusterInput.BackupRetentionPeriod) != aws.ToInt32(cluster.BackupRetentionPeriod) {
+		return true
+	}
+	if modifyDBClusterInput.PreferredBackupWindow != nil &&
+		aws.ToString(modifyDBClusterInput.PreferredBackupWindow) != aws.ToString(cluster.PreferredBackupWindow) {
+		return true
+	}
+	if modifyDBClusterInput.PreferredMaintenanceWindow != nil &&
+		aws.ToString(modifyDBClusterInput.PreferredMaintenanceWindow) != aws.ToString(cluster.PreferredMaintenanceWindow) {
+		return true
+	}
+	if scaling := modifyDBClusterInput.ServerlessV2ScalingConfiguration; scaling != nil {
+		current := cluster.ServerlessV2ScalingConfiguration
+		if current == nil || aws.ToFloat64(scaling.MinCapacity) != aws.ToFloat64(current.MinCapacity) ||
+			aws.ToFloat64(scaling.MaxCapacity) != aws.ToFloat64(current.MaxCapacity) {
+			return true
+		}
+	}
+	return false
+}
+
+// deployAwsAuroraServerless creates or updates the deployment's Aurora
+// Serverless v2 cluster with a writer and an optional reader. The master
+// password is generated and kept in Secrets Manager by RDS, only the secret's
+// ARN is reported.
+func deployAwsAuroraServerless(parameters map[string]interface{}, rdsClient *rds.Client, engine string, settings *rdsSettings,
+	logsWriter io.Writer) error {
+	auroraEngine, err := getAuroraEngine(engine)
+	if err != nil {
+		return err
+	}
+	scalingConfiguration, err := getAuroraScalingConfiguration(settings)
+	if err != nil {
+		return err
+	}
+	clusterIdentifier, err := getAuroraClusterIdentifier(parameters)
+	if err != nil {
+		return err
+	}
+	useDeletionProtection, _ := jobs.GetParameterValue[bool](parameters, parameters_enums.UseDeletionProtection)
+
+	cluster, err := getAuroraCluster(rdsClient, clusterIdentifier)
+	if err != nil {
+		return err
+	}
+	if cluster != nil {
+		applyRdsChangesImmediately, _ := jobs.GetParameterValue[bool](parameters, parameters_enums.ApplyRdsChangesImmediately)
+		modifyDBClusterInput := &rds.ModifyDBClusterInput{
+			DBClusterIdentifier:              aws.String(clusterIdentifier),
+			ApplyImmediately:                 aws.Bool(applyRdsChangesImmediately),
+			DeletionProtection:               aws.Bool(useDeletionProtection),
+			ServerlessV2ScalingConfiguration: scalingConfiguration,
+			BackupRetentionPeriod:            settings.BackupRetentionDays,
+		}
+		if len(settings.BackupWindow) > 0 {
+			modifyDBClusterInput.PreferredBackupWindow = aws.String(settings.BackupWindow)
+		}
+		if len(settings.MaintenanceWindow) > 0 {
+			modifyDBClusterInput.PreferredMaintenanceWindow = aws.String(settings.MaintenanceWindow)
+		}
+		if len(settings.EngineVersion) > 0 && settings.EngineVersion != aws.ToString(cluster.EngineVersion) {
+			io.WriteString(logsWriter, fmt.Sprintf("Upgrading Aurora engine version from %s to %s\n",
+				aws.ToString(cluster.EngineVersion), settings.EngineVersion))
+			modifyDBClusterInput.EngineVersion = aws.String(settings.EngineVersion)
+			modifyDBClusterInput.AllowMajorVersionUpgrade = aws.Bool(settings.AllowMajorVersionUpgrade)
+		}
+		if isAuroraClusterModified(*cluster, modifyDBClusterInput) {
+			err = snapshotAuroraBeforeChange(parameters, rdsClient, clusterIdentifier, rdsSnapshotPreModify, logsWriter)
+			if err != nil {
+				return err
+			}
+			_, err = rdsClient.ModifyDBCluster(context.TODO(), modifyDBClusterInput)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		dbSubnetGroupName, err := createDBSubnetGroupIfNeeded(parameters, rdsClient, logsWriter)
+		if err != nil {
+			return err
+		}
+		masterUserName, err := utils.GenerateRandomString(7)
+		if err != nil {
+			return err
+		}
+		io.WriteString(logsWriter, fmt.Sprintf("Creating Aurora Serverless cluster: %s\n", clusterIdentifier))
+		createDBClusterInput := &rds.CreateDBClusterInput{
+			DBClusterIdentifier:              aws.String(clusterIdentifier),
+			Engine:                           aws.String(auroraEngine),
+			DBSubnetGroupName:                aws.String(dbSubnetGroupName),
+			MasterUsername:                   aws.String("p" + masterUserName),
+			ManageMasterUserPassword:         aws.Bool(true),
+			ServerlessV2ScalingConfiguration: scalingConfiguration,
+			StorageEncrypted:                 aws.Bool(true),
+			CopyTagsToSnapshot:               aws.Bool(true),
+			DeletionProtection:               aws.Bool(useDeletionProtection),
+			BackupRetentionPeriod:            settings.BackupRetentionDays,
+			Tags: []rdsTypes.Tag{
+				{
+					Key:   aws.String("Name"),
+					Value: aws.String(clusterIdentifier),
+				},
+				{
+					Key:   aws.String("created by"),
+					Value: aws.String("deployment.io"),
+				},
+			},
+		}
+		if len(settings.EngineVersion) > 0 {
+			createDBClusterInput.EngineVersion = aws.String(settings.EngineVersion)
+		}
+		if len(settings.BackupWindow) > 0 {
+			createDBClusterInput.PreferredBackupWindow = aws.String(settings.BackupWindow)
+		}
+		if len(settings.MaintenanceWindow) > 0 {
+			createDBClusterInput.PreferredMaintenanceWindow = aws.String(settings.MaintenanceWindow)
+		}
+		createDBClusterOutput, err := rdsClient.CreateDBCluster(context.TODO(), createDBClusterInput)
+		if err != nil {
+			return err
+		}
+		cluster = createDBClusterOutput.DBCluster
+	}
+
+	io.WriteString(logsWriter, fmt.Sprintf("Waiting for Aurora cluster to be available: %s\n", clusterIdentifier))
+	clusterWaiter := rds.NewDBClusterAvailableWaiter(rdsClient)
+	err = clusterWaiter.Wait(context.TODO(), &rds.DescribeDBClustersInput{
+		DBClusterIdentifier: aws.String(clusterIdentifier),
+	}, auroraWaitDuration)
+	if err != nil {
+		return err
+	}
+
+	writerIdentifier := getAuroraWriterIdentifier(clusterIdentifier)
+	readerIdentifier := getAuroraReaderIdentifier(clusterIdentifier)
+	err = createAuroraInstanceIfNeeded(rdsClient, cluster, writerIdentifier, 0, logsWriter)
+	if err != nil {
+		return err
+	}
+	instanceIdentifiers := []string{writerIdentifier}
+	if settings.Reader {
+		err = createAuroraInstanceIfNeeded(rdsClient, cluster, readerIdentifier, 1, logsWriter)
+		if err != nil {
+			return err
+		}
+		instanceIdentifiers = append(instanceIdentifiers, readerIdentifier)
+	} else if getAuroraClusterMembers(cluster)[readerIdentifier] {
+		io.WriteString(logsWriter, fmt.Sprintf("Deleting Aurora instance: %s\n", readerIdentifier))
+		_, err = rdsClient.DeleteDBInstance(context.TODO(), &rds.DeleteDBInstanceInput{
+			DBInstanceIdentifier: aws.String(readerIdentifier),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, instanceIdentifier := range instanceIdentifiers {
+		err = waitTillRdsAvailable(rdsClient, instanceIdentifier, instanceIdentifier, false, logsWriter)
+		if err != nil {
+			return err
+		}
+	}
+
+	return syncAuroraCluster(parameters, rdsClient, clusterIdentifier, settings.Reader, logsWriter)
+}
+
+// syncAuroraCluster opens the cluster port on the default VPC security group
+// and reports the writer and reader endpoints and the master secret.
+func syncAuroraCluster(parameters map[string]interface{}, rdsClient *rds.Client, clusterIdentifier string, hasReader bool,
+	logsWriter io.Writer) error {
+	cluster, err := getAuroraCluster(rdsClient, clusterIdentifier)
+	if err != nil {
+		return err
+	}
+	if cluster == nil {
+		return fmt.Errorf("Aurora cluster %s not available", clusterIdentifier)
+	}
+	port := aws.ToInt32(cluster.Port)
+	jobs.SetParameterValue[int64](parameters, parameters_enums.Port, int64(port))
+	ec2Client, err := cloud_api_clients.GetEC2Client(parameters)
+	if err != nil {
+		return err
+	}
+	err = addIngressRuleToDefaultVpcSecurityGroupForPortIfNeeded(parameters, ec2Client)
+	if err != nil {
+		return err
+	}
+
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return err
+	}
+	organizationIdFromJob, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIdFromJob)
+	if err != nil {
+		return err
+	}
+	writerEndpoint := aws.ToString(cluster.Endpoint)
+	updateDeploymentDtoV1 := deployments.UpdateDeploymentDtoV1{
+		ID:               deploymentID,
+		DnsName:          writerEndpoint,
+		Port:             port,
+		RdsDatabaseArn:   aws.ToString(cluster.DBClusterArn),
+		RdsEngineVersion: aws.ToString(cluster.EngineVersion),
+		RdsUserName:      aws.ToString(cluster.MasterUsername),
+	}
+	if cluster.MasterUserSecret != nil {
+		updateDeploymentDtoV1.RdsMasterUserSecretArn = aws.ToString(cluster.MasterUserSecret.SecretArn)
+	}
+	if hasReader {
+		updateDeploymentDtoV1.RdsReaderEndpoint = aws.ToString(cluster.ReaderEndpoint)
+	}
+	commandUtils.UpdateDeploymentsPipeline.Add(organizationIdFromJob, updateDeploymentDtoV1)
+
+	io.WriteString(logsWriter, fmt.Sprintf("Aurora cluster writer is available at: %s:%d\n", writerEndpoint, port))
+	if hasReader {
+		io.WriteString(logsWriter, fmt.Sprintf("Aurora cluster reader is available at: %s:%d\n", aws.ToString(cluster.ReaderEndpoint), port))
+	}
+	return nil
+}
+
+// deleteAwsAuroraServerless deletes the cluster's instances and then the
+// cluster. It returns false when the deployment has no Aurora cluster.
+func deleteAwsAuroraServerless(parameters map[string]interface{}, rdsClient *rds.Client, logsWriter io.Writer) (bool, error) {
+	clusterIdentifier, err := getAuroraClusterIdentifier(parameters)
+	if err != nil {
+		return false, err
+	}
+	cluster, err := getAuroraCluster(rdsClient, clusterIdentifier)
+	if err != nil {
+		return false, err
+	}
+	if cluster == nil {
+		return false, nil
+	}
+	err = snapshotAuroraBeforeChange(parameters, rdsClient, clusterIdentifier, rdsSnapshotPreDelete, logsWriter)
+	if err != nil {
+		return true, err
+	}
+	if aws.ToBool(cluster.DeletionProtection) {
+		_, err = rdsClient.ModifyDBCluster(context.TODO(), &rds.ModifyDBClusterInput{
+			DBClusterIdentifier: aws.String(clusterIdentifier),
+			DeletionProtection:  aws.Bool(false),
+			ApplyImmediately:    aws.Bool(true),
+		})
+		if err != nil {
+			return true, err
+		}
+	}
+	instanceWaiter := rds.NewDBInstanceDeletedWaiter(rdsClient)
+	for instanceIdentifier := range getAuroraClusterMembers(cluster) {
+		io.WriteString(logsWriter, fmt.Sprintf("Deleting Aurora instance: %s\n", instanceIdentifier))
+		_, err = rdsClient.DeleteDBInstance(context.TODO(), &rds.DeleteDBInstanceInput{
+			DBInstanceIdentifier: aws.String(instanceIdentifier),
+		})
+		var dbInstanceNotFoundFault *rdsTypes.DBInstanceNotFoundFault
+		if err != nil && !errors.As(err, &dbInstanceNotFoundFault) {
+			return true, err
+		}
+	}
+	for instanceIdentifier := range getAuroraClusterMembers(cluster) {
+		err = instanceWaiter.Wait(context.TODO(), &rds.DescribeDBInstancesInput{
+			DBInstanceIdentifier: aws.String(instanceIdentifier),
+		}, auroraWaitDuration)
+		if err != nil {
+			return true, err
+		}
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Deleting Aurora cluster: %s\n", clusterIdentifier))
+	_, err = rdsClient.DeleteDBCluster(context.TODO(), &rds.DeleteDBClusterInput{
+		DBClusterIdentifier: aws.String(clusterIdentifier),
+		SkipFinalSnapshot:   aws.Bool(true),
+	})
+	if err != nil {
+		return true, err
+	}
+	return true, nil
+}
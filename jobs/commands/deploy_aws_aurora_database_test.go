@@ -0,0 +1,109 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	rdsTypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+)
+
+func TestGetAuroraEngine(t *testing.T) {
+	for engine, want := range map[string]string{"postgres": "aurora-postgresql", "mysql": "aurora-mysql"} {
+		got, err := getAuroraEngine(engine)
+		if err != nil || got != want {
+			t.Errorf("getAuroraEngine(%s) = %s, %v, want %s", engine, got, err, want)
+		}
+	}
+	if _, err := getAuroraEngine("mariadb"); err == nil {
+		t.Error("expected an error for mariadb")
+	}
+}
+
+func TestGetAuroraScalingConfiguration(t *testing.T) {
+	settings, err := parseRdsSettings(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scaling, err := getAuroraScalingConfiguration(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.ToFloat64(scaling.MinCapacity) != 0.5 || aws.ToFloat64(scaling.MaxCapacity) != 4 {
+		t.Errorf("default scaling = %v-%v, want 0.5-4", aws.ToFloat64(scaling.MinCapacity), aws.ToFloat64(scaling.MaxCapacity))
+	}
+
+	settings, err = parseRdsSettings([]byte(`{"min_capacity": 0, "max_capacity": 16, "reader": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scaling, err = getAuroraScalingConfiguration(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.ToFloat64(scaling.MinCapacity) != 0 || aws.ToFloat64(scaling.MaxCapacity) != 16 {
+		t.Errorf("scaling = %v-%v, want 0-16", aws.ToFloat64(scaling.MinCapacity), aws.ToFloat64(scaling.MaxCapacity))
+	}
+}
+
+func TestGetAuroraScalingConfiguration_Invalid(t *testing.T) {
+	cases := map[string]string{
+		"min above max": `{"min_capacity": 8, "max_capacity": 4}`,
+		"max too large": `{"max_capacity": 512}`,
+		"read replicas": `{"read_replicas": 1}`,
+		"parameters":    `{"parameters": {"max_connections": "100"}}`,
+		"no backups":    `{"backup_retention_days": 0}`,
+		"negative min":  `{"min_capacity": -1}`,
+	}
+	for name, settingsJSON := range cases {
+		settings, err := parseRdsSettings([]byte(settingsJSON))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if _, err := getAuroraScalingConfiguration(settings); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// TestIsAuroraClusterModified skips the modify, and its snapshot, when the
+// settings match the cluster.
+func TestIsAuroraClusterModified(t *testing.T) {
+	cluster := rdsTypes.DBCluster{
+		DeletionProtection:         aws.Bool(true),
+		BackupRetentionPeriod:      aws.Int32(7),
+		PreferredMaintenanceWindow: aws.String("sun:04:00-sun:04:30"),
+		ServerlessV2ScalingConfiguration: &rdsTypes.ServerlessV2ScalingConfigurationInfo{
+			MinCapacity: aws.Float64(0.5),
+			MaxCapacity: aws.Float64(4),
+		},
+	}
+	unchanged := func() *rds.ModifyDBClusterInput {
+		return &rds.ModifyDBClusterInput{
+			DeletionProtection: aws.Bool(true),
+			ServerlessV2ScalingConfiguration: &rdsTypes.ServerlessV2ScalingConfiguration{
+				MinCapacity: aws.Float64(0.5),
+				MaxCapacity: aws.Float64(4),
+			},
+		}
+	}
+	if isAuroraClusterModified(cluster, unchanged()) {
+		t.Error("expected the cluster to be unchanged")
+	}
+	changes := map[string]func(*rds.ModifyDBClusterInput){
+		"engine version":      func(in *rds.ModifyDBClusterInput) { in.EngineVersion = aws.String("16.4") },
+		"deletion protection": func(in *rds.ModifyDBClusterInput) { in.DeletionProtection = aws.Bool(false) },
+		"backup retention":    func(in *rds.ModifyDBClusterInput) { in.BackupRetentionPeriod = aws.Int32(14) },
+		"maintenance window": func(in *rds.ModifyDBClusterInput) {
+			in.PreferredMaintenanceWindow = aws.String("mon:04:00-mon:04:30")
+		},
+		"max capacity": func(in *rds.ModifyDBClusterInput) { in.ServerlessV2ScalingConfiguration.MaxCapacity = aws.Float64(8) },
+	}
+	for name, change := range changes {
+		modifyDBClusterInput := unchanged()
+		change(modifyDBClusterInput)
+		if !isAuroraClusterModified(cluster, modifyDBClusterInput) {
+			t.Errorf("%s: expected the cluster to be modified", name)
+		}
+	}
+}
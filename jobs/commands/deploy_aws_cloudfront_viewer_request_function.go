@@ -13,6 +13,7 @@ import (
 	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
 	"github.com/deployment-io/deployment-runner-kit/jobs"
 	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
+	"github.com/deployment-io/deployment-runner/utils/aws_utils"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -37,11 +38,18 @@ func getViewerRequestFunctionComment(parameters map[string]interface{}) (string,
 	return fmt.Sprintf("cloudfront function for viewer-request-%s", deploymentID), nil
 }
 
-func getViewerRequestFunctionCode(parameters map[string]interface{}) (string, error) {
+// getViewerRequestFunctionCode puts the password check of a protected
+// preview, previewAuthorization, before anything else.
+func getViewerRequestFunctionCode(parameters map[string]interface{}, previewAuthorization string) (string, error) {
+	var err error
+	previewAuthStatement := ""
+	if len(previewAuthorization) > 0 {
+		previewAuthStatement = aws_utils.GetBasicAuthViewerRequestStatement(previewAuthorization)
+	}
+
 	redirectDomainsA, _ := jobs.GetParameterValue[primitive.A](parameters, parameters_enums.RedirectDomain)
 	var redirectDomains []string
 	domainRedirectStatement := ""
-	var err error
 	if len(redirectDomainsA) > 0 {
 		redirectDomains, err = commandUtils.ConvertPrimitiveAToStringSlice(redirectDomainsA)
 		if err != nil {
@@ -125,6 +133,20 @@ func getViewerRequestFunctionCode(parameters map[string]interface{}) (string, er
      }`
 	}
 
+	redirectsStatement, err := getStaticSiteRedirectsStatement(parameters)
+	if err != nil {
+		return "", err
+	}
+
+	precompressedEncodings, err := getStaticSitePrecompressedEncodings(parameters)
+	if err != nil {
+		return "", err
+	}
+	precompressedVariantStatement, err := getPrecompressedVariantStatement(precompressedEncodings)
+	if err != nil {
+		return "", err
+	}
+
 	cloudfrontFunction := fmt.Sprintf(`function buildQS(querystring) {
     var qs = Object.keys(querystring).map(function(k) {
         return k + '=' + querystring[k].value;
@@ -137,8 +159,11 @@ function handler(event) {
     %s
     %s
     %s
+    %s
+    %s
+    %s
     return request;
-}`, domainRedirectStatement, domainHttpsStatement, subdirectoryIndexStatement)
+}`, previewAuthStatement, domainRedirectStatement, domainHttpsStatement, redirectsStatement, subdirectoryIndexStatement, precompressedVariantStatement)
 	return cloudfrontFunction, nil
 }
 
@@ -285,8 +310,29 @@ func (d *DeployAwsCloudfrontViewerRequestFunction) Run(parameters map[string]int
 	if err != nil {
 		return parameters, err
 	}
+	previewAuthorization, err := getStaticPreviewAuthorization(parameters, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
 	var cloudfrontFunction string
-	cloudfrontFunction, err = getViewerRequestFunctionCode(parameters)
+	cloudfrontFunction, err = getViewerRequestFunctionCode(parameters, previewAuthorization)
+	if err != nil {
+		return parameters, err
+	}
+	if err = checkCloudfrontFunctionCodeSize(viewerRequestsFunctionName, cloudfrontFunction); err != nil {
+		return parameters, err
+	}
+	testCases, buildPaths, err := getCloudfrontFunctionTestCases(parameters, cloudfrontEventViewerRequest)
+	if err != nil {
+		return parameters, err
+	}
+	testCases = addPreviewAuthorizationToTestCases(testCases, previewAuthorization)
+	precompressedEncodings, err := getStaticSitePrecompressedEncodings(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	err = testCloudfrontFunctionCode(viewerRequestsFunctionName, cloudfrontFunction, testCases, buildPaths,
+		precompressedEncodings, logsWriter)
 	if err != nil {
 		return parameters, err
 	}
@@ -325,6 +371,10 @@ func (d *DeployAwsCloudfrontViewerRequestFunction) Run(parameters map[string]int
 		functionARN = createFunctionOutput.FunctionSummary.FunctionMetadata.FunctionARN
 		etag = createFunctionOutput.ETag
 	}
+	err = testCloudfrontFunctionOnDevelopmentStage(parameters, cloudfrontClient, viewerRequestsFunctionName, etag, testCases, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
 	io.WriteString(logsWriter, fmt.Sprintf("Publishing cloudfront function: %s\n", viewerRequestsFunctionName))
 	//publish function
 	_, err = cloudfrontClient.PublishFunction(context.TODO(), &cloudfront.PublishFunctionInput{
@@ -0,0 +1,449 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/elasticache"
+	elasticacheTypes "github.com/aws/aws-sdk-go-v2/service/elasticache/types"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	secretsmanager_types "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/deployments"
+	"github.com/deployment-io/deployment-runner-kit/enums/iam_policy_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/iam_policies"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	"github.com/deployment-io/deployment-runner-kit/utils"
+	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
+	runnerUtils "github.com/deployment-io/deployment-runner/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	elastiCachePort            = 6379
+	defaultElastiCacheEngine   = "redis"
+	defaultElastiCacheNodeType = "cache.t4g.micro"
+	maxElastiCacheReplicas     = 5
+	elastiCacheWaitDuration    = 45 * time.Minute
+	elastiCacheAuthTokenSecret = "auth-token"
+	elastiCacheAuthTokenLength = 32
+)
+
+// DeployAwsElastiCache creates or updates the deployment's Redis or Valkey
+// replication group in the private subnets, encrypted in transit and at rest
+// and protected by an AUTH token kept in Secrets Manager.
+type DeployAwsElastiCache struct {
+}
+
+func getElastiCacheReplicationGroupId(parameters map[string]interface{}) (string, error) {
+	//cache-<deploymentID>
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("cache-%s", deploymentID), nil
+}
+
+func getCacheSubnetGroupName(parameters map[string]interface{}) (string, error) {
+	//cache-subnet-group-<organizationId>
+	organizationID, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIDNamespace)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("cache-subnet-group-%s", organizationID), nil
+}
+
+func getElastiCacheSecurityGroupName(parameters map[string]interface{}) (string, error) {
+	//cachesg-<deploymentID>
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("cachesg-%s", deploymentID), nil
+}
+
+func getElastiCacheAuthTokenSecretName(replicationGroupId string) string {
+	//cache-<deploymentID>/auth-token
+	return fmt.Sprintf("%s/%s", replicationGroupId, elastiCacheAuthTokenSecret)
+}
+
+func getElastiCacheEngine(parameters map[string]interface{}) (string, error) {
+	engine, err := jobs.GetParameterValue[string](parameters, parameters_enums.ElastiCacheEngine)
+	if err != nil || len(engine) == 0 {
+		return defaultElastiCacheEngine, nil
+	}
+	return validateElastiCacheEngine(engine)
+}
+
+func validateElastiCacheEngine(engine string) (string, error) {
+	switch engine {
+	case "redis", "valkey":
+		return engine, nil
+	}
+	return "", fmt.Errorf("unsupported elasticache engine %s, use redis or valkey", engine)
+}
+
+func getElastiCacheNodeType(parameters map[string]interface{}) string {
+	nodeType, err := jobs.GetParameterValue[string](parameters, parameters_enums.ElastiCacheNodeType)
+	if err != nil || len(nodeType) == 0 {
+		return defaultElastiCacheNodeType
+	}
+	return nodeType
+}
+
+// getElastiCacheReplicas is the number of read replicas next to the primary.
+// One by default so the group can fail over, none for previews.
+func getElastiCacheReplicas(parameters map[string]interface{}) (int32, error) {
+	replicas, err := jobs.GetParameterValue[int64](parameters, parameters_enums.ElastiCacheReplicas)
+	if err != nil {
+		if isPreview(parameters) {
+			return 0, nil
+		}
+		return 1, nil
+	}
+	if replicas < 0 || replicas > maxElastiCacheReplicas {
+		return 0, fmt.Errorf("elasticache replicas must be between 0 and %d", maxElastiCacheReplicas)
+	}
+	return int32(replicas), nil
+}
+
+// getElastiCacheEndpoints returns the primary and reader endpoints of a
+// replication group with cluster mode disabled, which has a single node group.
+func getElastiCacheEndpoints(replicationGroup *elasticacheTypes.ReplicationGroup) (primary, reader *elasticacheTypes.Endpoint) {
+	if len(replicationGroup.NodeGroups) == 0 {
+		return nil, nil
+	}
+	return replicationGroup.NodeGroups[0].PrimaryEndpoint, replicationGroup.NodeGroups[0].ReaderEndpoint
+}
+
+func addElastiCachePolicyForDeploymentRunner(parameters map[string]interface{}) error {
+	runnerData := runnerUtils.RunnerData.Get()
+	organizationID, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIDNamespace)
+	if err != nil {
+		return err
+	}
+	return iam_policies.AddAwsPolicyForDeploymentRunner(iam_policy_enums.AwsElastiCacheDeployment,
+		runnerData.OsType.String(), runnerData.CpuArchEnum.String(), organizationID, runnerData.RunnerRegion, runnerData.Mode, runnerData.TargetCloud)
+}
+
+func createCacheSubnetGroupIfNeeded(parameters map[string]interface{}, elastiCacheClient *elasticache.Client, logsWriter io.Writer) (string, error) {
+	subnetGroupName, err := getCacheSubnetGroupName(parameters)
+	if err != nil {
+		return "", err
+	}
+	_, err = elastiCacheClient.DescribeCacheSubnetGroups(context.TODO(), &elasticache.DescribeCacheSubnetGroupsInput{
+		CacheSubnetGroupName: aws.String(subnetGroupName),
+	})
+	var cacheSubnetGroupNotFoundFault *elasticacheTypes.CacheSubnetGroupNotFoundFault
+	if err != nil && !errors.As(err, &cacheSubnetGroupNotFoundFault) {
+		return "", err
+	}
+	if err == nil {
+		return subnetGroupName, nil
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Creating cache subnet group for ElastiCache: %s\n", subnetGroupName))
+	privateSubnets, err := jobs.GetParameterValue[primitive.A](parameters, parameters_enums.PrivateSubnets)
+	if err != nil {
+		return "", err
+	}
+	privateSubnetsSlice, err := commandUtils.ConvertPrimitiveAToStringSlice(privateSubnets)
+	if err != nil {
+		return "", err
+	}
+	_, err = elastiCacheClient.CreateCacheSubnetGroup(context.TODO(), &elasticache.CreateCacheSubnetGroupInput{
+		CacheSubnetGroupDescription: aws.String(fmt.Sprintf("subnet group %s", subnetGroupName)),
+		CacheSubnetGroupName:        aws.String(subnetGroupName),
+		SubnetIds:                   privateSubnetsSlice,
+		Tags: []elasticacheTypes.Tag{
+			{
+				Key:   aws.String("created by"),
+				Value: aws.String("deployment.io"),
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return subnetGroupName, nil
+}
+
+// getElastiCacheAuthTokenIfNeeded returns the AUTH token from Secrets Manager,
+// generating and storing one the first time.
+func getElastiCacheAuthTokenIfNeeded(secretsManagerClient *secretsmanager.Client, secretName string) (authToken, secretArn string, err error) {
+	getSecretValueOutput, err := secretsManagerClient.GetSecretValue(context.TODO(), &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(secretName),
+	})
+	if err == nil {
+		return aws.ToString(getSecretValueOutput.SecretString), aws.ToString(getSecretValueOutput.ARN), nil
+	}
+	var resourceNotFoundException *secretsmanager_types.ResourceNotFoundException
+	var invalidRequestException *secretsmanager_types.InvalidRequestException
+	//a secret scheduled for deletion fails with an invalid request
+	if !errors.As(err, &resourceNotFoundException) && !errors.As(err, &invalidRequestException) {
+		return "", "", err
+	}
+	authToken, err = utils.GenerateRandomString(elastiCacheAuthTokenLength)
+	if err != nil {
+		return "", "", err
+	}
+	secretArn, err = upsertSecretsManagerSecret(secretsManagerClient, secretName, authToken)
+	if err != nil {
+		return "", "", err
+	}
+	return authToken, secretArn, nil
+}
+
+func getElastiCacheReplicationGroup(elastiCacheClient *elasticache.Client, replicationGroupId string) (*elasticacheTypes.ReplicationGroup, error) {
+	describeReplicationGroupsOutput, err := elastiCacheClient.DescribeReplicationGroups(context.TODO(), &elasticache.DescribeReplicationGroupsInput{
+		ReplicationGroupId: aws.String(replicationGroupId),
+	})
+	var replicationGroupNotFoundFault *elasticacheTypes.ReplicationGroupNotFoundFault
+	if errors.As(err, &replicationGroupNotFoundFault) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(describeReplicationGroupsOutput.ReplicationGroups) == 0 {
+		return nil, nil
+	}
+	return &describeReplicationGroupsOutput.ReplicationGroups[0], nil
+}
+
+func waitTillElastiCacheAvailable(elastiCacheClient *elasticache.Client, replicationGroupId string, logsWriter io.Writer) error {
+	io.WriteString(logsWriter, fmt.Sprintf("Waiting for ElastiCache to be available: %s\n", replicationGroupId))
+	waiter := elasticache.NewReplicationGroupAvailableWaiter(elastiCacheClient)
+	return waiter.Wait(context.TODO(), &elasticache.DescribeReplicationGroupsInput{
+		ReplicationGroupId: aws.String(replicationGroupId),
+	}, elastiCacheWaitDuration)
+}
+
+// updateElastiCacheIfNeeded brings an existing replication group to the
+// requested node type and replica count. Automatic failover needs at least
+// one replica, so it's turned off before going down to none and back on after
+// adding the first replica.
+func updateElastiCacheIfNeeded(elastiCacheClient *elasticache.Client, replicationGroup *elasticacheTypes.ReplicationGroup,
+	nodeType string, replicas int32, logsWriter io.Writer) error {
+	replicationGroupId := aws.ToString(replicationGroup.ReplicationGroupId)
+	if nodeType != aws.ToString(replicationGroup.CacheNodeType) {
+		io.WriteString(logsWriter, fmt.Sprintf("Changing ElastiCache node type from %s to %s\n",
+			aws.ToString(replicationGroup.CacheNodeType), nodeType))
+		_, err := elastiCacheClient.ModifyReplicationGroup(context.TODO(), &elasticache.ModifyReplicationGroupInput{
+			ReplicationGroupId: aws.String(replicationGroupId),
+			CacheNodeType:      aws.String(nodeType),
+			ApplyImmediately:   aws.Bool(true),
+		})
+		if err != nil {
+			return err
+		}
+		err = waitTillElastiCacheAvailable(elastiCacheClient, replicationGroupId, logsWriter)
+		if err != nil {
+			return err
+		}
+	}
+
+	currentReplicas := int32(len(replicationGroup.MemberClusters)) - 1
+	if replicas == currentReplicas {
+		return nil
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Changing ElastiCache replicas from %d to %d\n", currentReplicas, replicas))
+	if replicas == 0 {
+		_, err := elastiCacheClient.ModifyReplicationGroup(context.TODO(), &elasticache.ModifyReplicationGroupInput{
+			ReplicationGroupId:       aws.String(replicationGroupId),
+			AutomaticFailoverEnabled: aws.Bool(false),
+			MultiAZEnabled:           aws.Bool(false),
+			ApplyImmediately:         aws.Bool(true),
+		})
+		if err != nil {
+			return err
+		}
+		err = waitTillElastiCacheAvailable(elastiCacheClient, replicationGroupId, logsWriter)
+		if err != nil {
+			return err
+		}
+	}
+	var err error
+	if replicas > currentReplicas {
+		_, err = elastiCacheClient.IncreaseReplicaCount(context.TODO(), &elasticache.IncreaseReplicaCountInput{
+			ReplicationGroupId: aws.String(replicationGroupId),
+			NewReplicaCount:    aws.Int32(replicas),
+			ApplyImmediately:   aws.Bool(true),
+		})
+	} else {
+		_, err = elastiCacheClient.DecreaseReplicaCount(context.TODO(), &elasticache.DecreaseReplicaCountInput{
+			ReplicationGroupId: aws.String(replicationGroupId),
+			NewReplicaCount:    aws.Int32(replicas),
+			ApplyImmediately:   aws.Bool(true),
+		})
+	}
+	if err != nil {
+		return err
+	}
+	if currentReplicas == 0 {
+		err = waitTillElastiCacheAvailable(elastiCacheClient, replicationGroupId, logsWriter)
+		if err != nil {
+			return err
+		}
+		_, err = elastiCacheClient.ModifyReplicationGroup(context.TODO(), &elasticache.ModifyReplicationGroupInput{
+			ReplicationGroupId:       aws.String(replicationGroupId),
+			AutomaticFailoverEnabled: aws.Bool(true),
+			MultiAZEnabled:           aws.Bool(true),
+			ApplyImmediately:         aws.Bool(true),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DeployAwsElastiCache) Run(parameters map[string]interface{}, logsWriter io.Writer) (newParameters map[string]interface{}, err error) {
+	defer func() {
+		<-MarkDeploymentDone(parameters, err)
+	}()
+	engine, err := getElastiCacheEngine(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	nodeType := getElastiCacheNodeType(parameters)
+	replicas, err := getElastiCacheReplicas(parameters)
+	if err != nil {
+		return parameters, err
+	}
+
+	io.WriteString(logsWriter, fmt.Sprintf("Deploying ElastiCache for %s\n", engine))
+
+	err = addElastiCachePolicyForDeploymentRunner(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	elastiCacheClient, err := cloud_api_clients.GetElastiCacheClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	secretsManagerClient, err := cloud_api_clients.GetSecretsManagerClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	ec2Client, err := cloud_api_clients.GetEC2Client(parameters)
+	if err != nil {
+		return parameters, err
+	}
+
+	replicationGroupId, err := getElastiCacheReplicationGroupId(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	authToken, authTokenSecretArn, err := getElastiCacheAuthTokenIfNeeded(secretsManagerClient,
+		getElastiCacheAuthTokenSecretName(replicationGroupId))
+	if err != nil {
+		return parameters, err
+	}
+
+	replicationGroup, err := getElastiCacheReplicationGroup(elastiCacheClient, replicationGroupId)
+	if err != nil {
+		return parameters, err
+	}
+	if replicationGroup != nil {
+		err = waitTillElastiCacheAvailable(elastiCacheClient, replicationGroupId, logsWriter)
+		if err != nil {
+			return parameters, err
+		}
+		err = updateElastiCacheIfNeeded(elastiCacheClient, replicationGroup, nodeType, replicas, logsWriter)
+		if err != nil {
+			return parameters, err
+		}
+	} else {
+		subnetGroupName, err := createCacheSubnetGroupIfNeeded(parameters, elastiCacheClient, logsWriter)
+		if err != nil {
+			return parameters, err
+		}
+		securityGroupName, err := getElastiCacheSecurityGroupName(parameters)
+		if err != nil {
+			return parameters, err
+		}
+		securityGroupId, err := createSecurityGroupForPortIfNeeded(parameters, ec2Client, securityGroupName,
+			"elasticache", "cache from tasks", elastiCachePort)
+		if err != nil {
+			return parameters, err
+		}
+		io.WriteString(logsWriter, fmt.Sprintf("Creating ElastiCache replication group: %s\n", replicationGroupId))
+		_, err = elastiCacheClient.CreateReplicationGroup(context.TODO(), &elasticache.CreateReplicationGroupInput{
+			ReplicationGroupId:          aws.String(replicationGroupId),
+			ReplicationGroupDescription: aws.String(fmt.Sprintf("%s for deployment.io", engine)),
+			Engine:                      aws.String(engine),
+			CacheNodeType:               aws.String(nodeType),
+			NumCacheClusters:            aws.Int32(replicas + 1),
+			AutomaticFailoverEnabled:    aws.Bool(replicas > 0),
+			MultiAZEnabled:              aws.Bool(replicas > 0),
+			CacheSubnetGroupName:        aws.String(subnetGroupName),
+			SecurityGroupIds:            []string{securityGroupId},
+			Port:                        aws.Int32(elastiCachePort),
+			TransitEncryptionEnabled:    aws.Bool(true),
+			AtRestEncryptionEnabled:     aws.Bool(true),
+			AuthToken:                   aws.String(authToken),
+			AutoMinorVersionUpgrade:     aws.Bool(true),
+			Tags: []elasticacheTypes.Tag{
+				{
+					Key:   aws.String("Name"),
+					Value: aws.String(replicationGroupId),
+				},
+				{
+					Key:   aws.String("created by"),
+					Value: aws.String("deployment.io"),
+				},
+			},
+		})
+		if err != nil {
+			return parameters, err
+		}
+	}
+
+	err = waitTillElastiCacheAvailable(elastiCacheClient, replicationGroupId, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	replicationGroup, err = getElastiCacheReplicationGroup(elastiCacheClient, replicationGroupId)
+	if err != nil {
+		return parameters, err
+	}
+	if replicationGroup == nil {
+		return parameters, fmt.Errorf("ElastiCache replication group %s not available", replicationGroupId)
+	}
+	primaryEndpoint, readerEndpoint := getElastiCacheEndpoints(replicationGroup)
+	if primaryEndpoint == nil {
+		return parameters, fmt.Errorf("ElastiCache replication group %s has no primary endpoint", replicationGroupId)
+	}
+
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return parameters, err
+	}
+	organizationIdFromJob, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIdFromJob)
+	if err != nil {
+		return parameters, err
+	}
+	updateDeploymentDtoV1 := deployments.UpdateDeploymentDtoV1{
+		ID:                            deploymentID,
+		DnsName:                       aws.ToString(primaryEndpoint.Address),
+		Port:                          aws.ToInt32(primaryEndpoint.Port),
+		ElastiCacheAuthTokenSecretArn: authTokenSecretArn,
+	}
+	if readerEndpoint != nil && replicas > 0 {
+		updateDeploymentDtoV1.ElastiCacheReaderEndpoint = aws.ToString(readerEndpoint.Address)
+	}
+	commandUtils.UpdateDeploymentsPipeline.Add(organizationIdFromJob, updateDeploymentDtoV1)
+
+	io.WriteString(logsWriter, fmt.Sprintf("ElastiCache primary is available at: %s:%d\n", aws.ToString(primaryEndpoint.Address),
+		aws.ToInt32(primaryEndpoint.Port)))
+	if len(updateDeploymentDtoV1.ElastiCacheReaderEndpoint) > 0 {
+		io.WriteString(logsWriter, fmt.Sprintf("ElastiCache reader is available at: %s:%d\n", updateDeploymentDtoV1.ElastiCacheReaderEndpoint,
+			aws.ToInt32(readerEndpoint.Port)))
+	}
+	return parameters, nil
+}
@@ -0,0 +1,35 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	elasticacheTypes "github.com/aws/aws-sdk-go-v2/service/elasticache/types"
+)
+
+func TestValidateElastiCacheEngine(t *testing.T) {
+	for _, engine := range []string{"redis", "valkey"} {
+		if got, err := validateElastiCacheEngine(engine); err != nil || got != engine {
+			t.Errorf("validateElastiCacheEngine(%s) = %s, %v", engine, got, err)
+		}
+	}
+	if _, err := validateElastiCacheEngine("memcached"); err == nil {
+		t.Error("expected an error for memcached")
+	}
+}
+
+func TestGetElastiCacheEndpoints(t *testing.T) {
+	primary, reader := getElastiCacheEndpoints(&elasticacheTypes.ReplicationGroup{})
+	if primary != nil || reader != nil {
+		t.Errorf("endpoints of a group without node groups = %v, %v, want none", primary, reader)
+	}
+	primary, reader = getElastiCacheEndpoints(&elasticacheTypes.ReplicationGroup{
+		NodeGroups: []elasticacheTypes.NodeGroup{{
+			PrimaryEndpoint: &elasticacheTypes.Endpoint{Address: aws.String("master.cache"), Port: aws.Int32(6379)},
+			ReaderEndpoint:  &elasticacheTypes.Endpoint{Address: aws.String("replica.cache"), Port: aws.Int32(6379)},
+		}},
+	})
+	if aws.ToString(primary.Address) != "master.cache" || aws.ToString(reader.Address) != "replica.cache" {
+		t.Errorf("endpoints = %s, %s", aws.ToString(primary.Address), aws.ToString(reader.Address))
+	}
+}
@@ -0,0 +1,1132 @@
+package commands
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamTypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdaTypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/deployment-io/deployment-runner-kit/builds"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/deployments"
+	"github.com/deployment-io/deployment-runner-kit/enums/cpu_architecture_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/iam_policy_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/iam_policies"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	"github.com/deployment-io/deployment-runner-kit/previews"
+	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
+	"github.com/deployment-io/deployment-runner/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DeployAwsLambdaFunction deploys the deployment's code as a Lambda function,
+// either the image UploadDockerImageToEcr pushed or a zip of the repository.
+// Every deploy publishes a version and points the live alias at it, so a
+// rollback only has to move the alias back. The function is reached through
+// a Function URL or an ALB target group, both bound to the alias.
+type DeployAwsLambdaFunction struct {
+}
+
+const (
+	lambdaPackageTypeImage = "image"
+	lambdaPackageTypeZip   = "zip"
+
+	lambdaTriggerUrl  = "url"
+	lambdaTriggerAlb  = "alb"
+	lambdaTriggerNone = "none"
+
+	lambdaAliasName = "live"
+
+	defaultLambdaMemorySize     int32 = 512
+	defaultLambdaTimeout        int32 = 30
+	defaultLambdaVersionsToKeep int32 = 5
+
+	//direct uploads are limited to 50MB, bigger packages need an image
+	maxLambdaZipSize = 50 * 1024 * 1024
+
+	lambdaWaitDuration = 10 * time.Minute
+
+	lambdaRoleSecretsPolicyName = "env-secrets"
+)
+
+// lambdaSettings is the optional LambdaSettings parameter.
+type lambdaSettings struct {
+	PackageType    string `json:"package_type"`     // image or zip, image when empty
+	Runtime        string `json:"runtime"`          // zip, e.g. nodejs20.x or python3.12
+	Handler        string `json:"handler"`          // zip, e.g. index.handler
+	CodeDirectory  string `json:"code_directory"`   // zip, relative to the repository root
+	MemorySize     int32  `json:"memory_size"`      // MB, 512 when empty
+	Timeout        int32  `json:"timeout"`          // seconds, 30 when empty
+	Trigger        string `json:"trigger"`          // url, alb or none, url when empty
+	Vpc            bool   `json:"vpc"`              // run in the private subnets of the runner's VPC
+	VersionsToKeep int32  `json:"versions_to_keep"` // published versions kept for rollbacks, 5 when empty
+}
+
+func getLambdaSettings(parameters map[string]interface{}) (*lambdaSettings, error) {
+	settingsJSON, err := jobs.GetParameterValue[string](parameters, parameters_enums.LambdaSettings)
+	if err != nil || len(settingsJSON) == 0 {
+		return parseLambdaSettings(nil)
+	}
+	return parseLambdaSettings([]byte(settingsJSON))
+}
+
+func parseLambdaSettings(settingsBytes []byte) (*lambdaSettings, error) {
+	settings := &lambdaSettings{}
+	if len(settingsBytes) > 0 {
+		if err := json.Unmarshal(settingsBytes, settings); err != nil {
+			return nil, fmt.Errorf("error unmarshalling lambda settings: %s", err)
+		}
+	}
+	switch settings.PackageType {
+	case "":
+		settings.PackageType = lambdaPackageTypeImage
+	case lambdaPackageTypeImage:
+	case lambdaPackageTypeZip:
+		if len(settings.Runtime) == 0 || len(settings.Handler) == 0 {
+			return nil, fmt.Errorf("runtime and handler are required for zip packages")
+		}
+		if !isValidLambdaRuntime(settings.Runtime) {
+			return nil, fmt.Errorf("unsupported lambda runtime: %s", settings.Runtime)
+		}
+		if len(settings.CodeDirectory) > 0 && !filepath.IsLocal(settings.CodeDirectory) {
+			return nil, fmt.Errorf("code directory must be inside the repository: %s", settings.CodeDirectory)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported lambda package type: %s", settings.PackageType)
+	}
+	if settings.MemorySize == 0 {
+		settings.MemorySize = defaultLambdaMemorySize
+	}
+	if settings.MemorySize < 128 || settings.MemorySize > 10240 {
+		return nil, fmt.Errorf("memory size must be between 128 and 10240 MB")
+	}
+	if settings.Timeout == 0 {
+		settings.Timeout = defaultLambdaTimeout
+	}
+	if settings.Timeout < 1 || settings.Timeout > 900 {
+		return nil, fmt.Errorf("timeout must be between 1 and 900 seconds")
+	}
+	switch settings.Trigger {
+	case "":
+		settings.Trigger = lambdaTriggerUrl
+	case lambdaTriggerUrl, lambdaTriggerAlb, lambdaTriggerNone:
+	default:
+		return nil, fmt.Errorf("unsupported lambda trigger: %s", settings.Trigger)
+	}
+	if settings.VersionsToKeep == 0 {
+		settings.VersionsToKeep = defaultLambdaVersionsToKeep
+	}
+	if settings.VersionsToKeep < 1 {
+		return nil, fmt.Errorf("versions to keep must be at least 1")
+	}
+	return settings, nil
+}
+
+func isValidLambdaRuntime(runtime string) bool {
+	for _, r := range lambdaTypes.Runtime("").Values() {
+		if string(r) == runtime {
+			return true
+		}
+	}
+	return false
+}
+
+func isLambdaFunction(parameters map[string]interface{}) bool {
+	lambdaFunction, err := jobs.GetParameterValue[bool](parameters, parameters_enums.IsLambdaFunction)
+	if err != nil {
+		return false
+	}
+	return lambdaFunction
+}
+
+func getLambdaFunctionName(parameters map[string]interface{}) (string, error) {
+	//fn-<deploymentID>
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("fn-%s", deploymentID), nil
+}
+
+func getLambdaRoleName(parameters map[string]interface{}) (string, error) {
+	//lRole-<deploymentID>
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("lRole-%s", deploymentID), nil
+}
+
+func getLambdaTrustPolicy() string {
+	return `{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Effect": "Allow",
+      "Principal": {
+        "Service": "lambda.amazonaws.com"
+      },
+      "Action": "sts:AssumeRole"
+    }
+  ]
+}`
+}
+
+// lambdaRoleManagedPolicyArns let the function write its logs and create the
+// network interfaces it needs when it runs in the VPC.
+var lambdaRoleManagedPolicyArns = []string{
+	"arn:aws:iam::aws:policy/service-role/AWSLambdaBasicExecutionRole",
+	"arn:aws:iam::aws:policy/service-role/AWSLambdaVPCAccessExecutionRole",
+}
+
+func getLambdaRoleIfNeeded(iamClient *iam.Client, parameters map[string]interface{}) (string, error) {
+	lambdaRoleName, err := getLambdaRoleName(parameters)
+	if err != nil {
+		return "", err
+	}
+	getRoleOutput, err := iamClient.GetRole(context.TODO(), &iam.GetRoleInput{RoleName: aws.String(lambdaRoleName)})
+	var noSuchEntityException *iamTypes.NoSuchEntityException
+	if err != nil && !errors.As(err, &noSuchEntityException) {
+		return "", err
+	}
+	if err == nil && getRoleOutput.Role != nil {
+		return aws.ToString(getRoleOutput.Role.Arn), nil
+	}
+	createRoleOutput, err := iamClient.CreateRole(context.TODO(), &iam.CreateRoleInput{
+		AssumeRolePolicyDocument: aws.String(getLambdaTrustPolicy()),
+		RoleName:                 aws.String(lambdaRoleName),
+		Tags: []iamTypes.Tag{
+			{
+				Key:   aws.String("Name"),
+				Value: aws.String(lambdaRoleName),
+			},
+			{
+				Key:   aws.String("created by"),
+				Value: aws.String("deployment.io"),
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, policyArn := range lambdaRoleManagedPolicyArns {
+		_, err = iamClient.AttachRolePolicy(context.TODO(), &iam.AttachRolePolicyInput{
+			PolicyArn: aws.String(policyArn),
+			RoleName:  aws.String(lambdaRoleName),
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return aws.ToString(createRoleOutput.Role.Arn), nil
+}
+
+// syncLambdaRoleSecretsPolicy lets the function read exactly the secrets it
+// was given, or removes the policy once it has none.
+func syncLambdaRoleSecretsPolicy(iamClient *iam.Client, parameters map[string]interface{}, secretArns []string) error {
+	lambdaRoleName, err := getLambdaRoleName(parameters)
+	if err != nil {
+		return err
+	}
+	if len(secretArns) > 0 {
+		policy, err := json.Marshal(policyDocument{
+			Version: "2012-10-17",
+			Statement: []policyStatement{
+				{
+					Effect:   "Allow",
+					Action:   []string{"secretsmanager:GetSecretValue"},
+					Resource: secretArns,
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		_, err = iamClient.PutRolePolicy(context.TODO(), &iam.PutRolePolicyInput{
+			PolicyDocument: aws.String(string(policy)),
+			PolicyName:     aws.String(lambdaRoleSecretsPolicyName),
+			RoleName:       aws.String(lambdaRoleName),
+		})
+		return err
+	}
+	_, err = iamClient.DeleteRolePolicy(context.TODO(), &iam.DeleteRolePolicyInput{
+		PolicyName: aws.String(lambdaRoleSecretsPolicyName),
+		RoleName:   aws.String(lambdaRoleName),
+	})
+	var noSuchEntityException *iamTypes.NoSuchEntityException
+	if err != nil && !errors.As(err, &noSuchEntityException) {
+		return err
+	}
+	return nil
+}
+
+// getLambdaEnvironmentVariables merges the plain env vars with the secret
+// ones. Lambda can't inject secret values like ECS does, so each secret is
+// passed as <KEY>_SECRET_ARN for the code to read from Secrets Manager.
+func getLambdaEnvironmentVariables(envVariables map[string]string, secretArns map[string]string) (map[string]string, error) {
+	variables := map[string]string{}
+	for key, value := range envVariables {
+		variables[key] = value
+	}
+	for key, arn := range secretArns {
+		if _, ok := envVariables[key]; ok {
+			return nil, fmt.Errorf("environment variable %s is set both as plain and as secret", key)
+		}
+		secretKey := key + "_SECRET_ARN"
+		if _, ok := variables[secretKey]; ok {
+			return nil, fmt.Errorf("environment variable %s is also set for secret %s", secretKey, key)
+		}
+		variables[secretKey] = arn
+	}
+	return variables, nil
+}
+
+// syncLambdaEnvironmentVariables stores the secret env vars and returns the
+// function's environment along with the secrets' ARNs. hadSecrets tells the
+// last deploy stored secrets, which are cleaned up when there are none now.
+func syncLambdaEnvironmentVariables(parameters map[string]interface{}, hadSecrets bool, logsWriter io.Writer) (map[string]string, []string, error) {
+	envVariables := map[string]string{}
+	envVariablesString, err := jobs.GetParameterValue[string](parameters, parameters_enums.EnvironmentVariables)
+	if err == nil && len(envVariablesString) > 0 {
+		keyValuePairs, err := decodeEnvironmentVariablesToKeyValueSlice(envVariablesString)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, keyValuePair := range keyValuePairs {
+			envVariables[aws.ToString(keyValuePair.Name)] = aws.ToString(keyValuePair.Value)
+		}
+	}
+
+	store, _ := jobs.GetParameterValue[string](parameters, parameters_enums.SecretEnvironmentVariablesStore)
+	if store == secretsStoreSsm {
+		return nil, nil, fmt.Errorf("lambda functions read secret environment variables from Secrets Manager only")
+	}
+	secrets, err := storeSecretEnvironmentVariables(parameters, hadSecrets, logsWriter)
+	if err != nil {
+		return nil, nil, err
+	}
+	secretArns := map[string]string{}
+	var arns []string
+	for _, secret := range secrets {
+		secretArns[aws.ToString(secret.Name)] = aws.ToString(secret.ValueFrom)
+		arns = append(arns, aws.ToString(secret.ValueFrom))
+	}
+	sort.Strings(arns)
+
+	variables, err := getLambdaEnvironmentVariables(envVariables, secretArns)
+	if err != nil {
+		return nil, nil, err
+	}
+	return variables, arns, nil
+}
+
+// buildLambdaZip zips the directory the way Lambda expects it, with the
+// files at the root of the archive. Timestamps are fixed so unchanged code
+// gives the same archive and the same CodeSha256.
+func buildLambdaZip(directory string) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	zipWriter := zip.NewWriter(buffer)
+	modified := time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	err := filepath.WalkDir(directory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		relativePath, err := filepath.Rel(directory, path)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relativePath)
+		header.Modified = modified
+		header.Method = zip.Deflate
+		writer, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(writer, target)
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(writer, file)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = zipWriter.Close()
+	if err != nil {
+		return nil, err
+	}
+	if buffer.Len() > maxLambdaZipSize {
+		return nil, fmt.Errorf("lambda zip is %d MB, deploy it as an image instead", buffer.Len()/(1024*1024))
+	}
+	return buffer.Bytes(), nil
+}
+
+func getLambdaCodeSha256(zipFile []byte) string {
+	sum := sha256.Sum256(zipFile)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// lambdaCode is what the function runs: an image URI or a zip.
+type lambdaCode struct {
+	ImageUri string
+	ZipFile  []byte
+}
+
+func getLambdaCode(parameters map[string]interface{}, settings *lambdaSettings, logsWriter io.Writer) (*lambdaCode, error) {
+	if settings.PackageType == lambdaPackageTypeImage {
+		imageUri, err := jobs.GetParameterValue[string](parameters, parameters_enums.DockerRepositoryUriWithTag)
+		if err != nil {
+			return nil, err
+		}
+		return &lambdaCode{ImageUri: imageUri}, nil
+	}
+	repoDirectoryPath, err := jobs.GetParameterValue[string](parameters, parameters_enums.RepoDirectoryPath)
+	if err != nil {
+		return nil, err
+	}
+	codeDirectory := filepath.Join(repoDirectoryPath, settings.CodeDirectory)
+	io.WriteString(logsWriter, fmt.Sprintf("Packaging %s for Lambda\n", codeDirectory))
+	zipFile, err := buildLambdaZip(codeDirectory)
+	if err != nil {
+		return nil, err
+	}
+	return &lambdaCode{ZipFile: zipFile}, nil
+}
+
+func getLambdaVpcConfig(parameters map[string]interface{}, settings *lambdaSettings) (*lambdaTypes.VpcConfig, error) {
+	if !settings.Vpc {
+		return nil, nil
+	}
+	privateSubnets, err := jobs.GetParameterValue[primitive.A](parameters, parameters_enums.PrivateSubnets)
+	if err != nil {
+		return nil, err
+	}
+	privateSubnetsSlice, err := commandUtils.ConvertPrimitiveAToStringSlice(privateSubnets)
+	if err != nil {
+		return nil, err
+	}
+	vpcId, err := jobs.GetParameterValue[string](parameters, parameters_enums.VpcID)
+	if err != nil {
+		return nil, err
+	}
+	ec2Client, err := cloud_api_clients.GetEC2Client(parameters)
+	if err != nil {
+		return nil, err
+	}
+	//same security group as the ECS services so the function reaches databases and caches like they do
+	defaultSecurityGroupId, err := getDefaultSecurityGroupIdForVpc(parameters, ec2Client, vpcId)
+	if err != nil {
+		return nil, err
+	}
+	return &lambdaTypes.VpcConfig{
+		SubnetIds:        privateSubnetsSlice,
+		SecurityGroupIds: []string{defaultSecurityGroupId},
+	}, nil
+}
+
+func getLambdaArchitecture() lambdaTypes.Architecture {
+	//images are built on the runner so they match its architecture
+	runnerData := utils.RunnerData.Get()
+	if runnerData.CpuArchEnum == cpu_architecture_enums.ARM {
+		return lambdaTypes.ArchitectureArm64
+	}
+	return lambdaTypes.ArchitectureX8664
+}
+
+func getLambdaFunction(lambdaClient *lambda.Client, functionName string) (*lambdaTypes.FunctionConfiguration, error) {
+	getFunctionOutput, err := lambdaClient.GetFunction(context.TODO(), &lambda.GetFunctionInput{
+		FunctionName: aws.String(functionName),
+	})
+	var resourceNotFoundException *lambdaTypes.ResourceNotFoundException
+	if errors.As(err, &resourceNotFoundException) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return getFunctionOutput.Configuration, nil
+}
+
+// isLambdaRoleNotReadyError is returned for a few seconds after the function's
+// role was created, until IAM has propagated it.
+func isLambdaRoleNotReadyError(err error) bool {
+	var invalidParameterValueException *lambdaTypes.InvalidParameterValueException
+	return errors.As(err, &invalidParameterValueException) && strings.Contains(err.Error(), "cannot be assumed")
+}
+
+func createLambdaFunction(lambdaClient *lambda.Client, createFunctionInput *lambda.CreateFunctionInput) error {
+	var err error
+	for i := 0; i < 10; i++ {
+		_, err = lambdaClient.CreateFunction(context.TODO(), createFunctionInput)
+		if !isLambdaRoleNotReadyError(err) {
+			break
+		}
+		time.Sleep(6 * time.Second)
+	}
+	if err != nil {
+		return err
+	}
+	functionActiveWaiter := lambda.NewFunctionActiveV2Waiter(lambdaClient)
+	return functionActiveWaiter.Wait(context.TODO(), &lambda.GetFunctionInput{
+		FunctionName: createFunctionInput.FunctionName,
+	}, lambdaWaitDuration)
+}
+
+func waitForLambdaFunctionUpdate(lambdaClient *lambda.Client, functionName string) error {
+	functionUpdatedWaiter := lambda.NewFunctionUpdatedV2Waiter(lambdaClient)
+	return functionUpdatedWaiter.Wait(context.TODO(), &lambda.GetFunctionInput{
+		FunctionName: aws.String(functionName),
+	}, lambdaWaitDuration)
+}
+
+// updateLambdaFunction updates the configuration and then the code when it
+// changed. Lambda only takes one update at a time, so both are waited on.
+func updateLambdaFunction(lambdaClient *lambda.Client, function *lambdaTypes.FunctionConfiguration,
+	updateFunctionConfigurationInput *lambda.UpdateFunctionConfigurationInput, code *lambdaCode, logsWriter io.Writer) error {
+	functionName := aws.ToString(function.FunctionName)
+	packageType := lambdaTypes.PackageTypeImage
+	if len(code.ZipFile) > 0 {
+		packageType = lambdaTypes.PackageTypeZip
+	}
+	if function.PackageType != packageType {
+		return fmt.Errorf("function %s is a %s package, delete it to deploy a %s package", functionName,
+			strings.ToLower(string(function.PackageType)), strings.ToLower(string(packageType)))
+	}
+
+	io.WriteString(logsWriter, fmt.Sprintf("Updating Lambda function configuration: %s\n", functionName))
+	_, err := lambdaClient.UpdateFunctionConfiguration(context.TODO(), updateFunctionConfigurationInput)
+	if err != nil {
+		return err
+	}
+	err = waitForLambdaFunctionUpdate(lambdaClient, functionName)
+	if err != nil {
+		return err
+	}
+
+	updateFunctionCodeInput := &lambda.UpdateFunctionCodeInput{
+		FunctionName:  aws.String(functionName),
+		Architectures: []lambdaTypes.Architecture{getLambdaArchitecture()},
+	}
+	if len(code.ZipFile) > 0 {
+		if aws.ToString(function.CodeSha256) == getLambdaCodeSha256(code.ZipFile) {
+			return nil
+		}
+		updateFunctionCodeInput.ZipFile = code.ZipFile
+	} else {
+		updateFunctionCodeInput.ImageUri = aws.String(code.ImageUri)
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Updating Lambda function code: %s\n", functionName))
+	_, err = lambdaClient.UpdateFunctionCode(context.TODO(), updateFunctionCodeInput)
+	if err != nil {
+		return err
+	}
+	return waitForLambdaFunctionUpdate(lambdaClient, functionName)
+}
+
+// publishLambdaVersion publishes the function as it is now and points the
+// live alias at the new version. Publishing unchanged code returns the
+// latest version again.
+func publishLambdaVersion(lambdaClient *lambda.Client, functionName string, logsWriter io.Writer) (version string, aliasArn string, err error) {
+	publishVersionOutput, err := lambdaClient.PublishVersion(context.TODO(), &lambda.PublishVersionInput{
+		FunctionName: aws.String(functionName),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	version = aws.ToString(publishVersionOutput.Version)
+	io.WriteString(logsWriter, fmt.Sprintf("Published Lambda function version: %s\n", version))
+	aliasArn, err = setLambdaAliasVersion(lambdaClient, functionName, version)
+	if err != nil {
+		return "", "", err
+	}
+	return version, aliasArn, nil
+}
+
+func setLambdaAliasVersion(lambdaClient *lambda.Client, functionName, version string) (string, error) {
+	getAliasOutput, err := lambdaClient.GetAlias(context.TODO(), &lambda.GetAliasInput{
+		FunctionName: aws.String(functionName),
+		Name:         aws.String(lambdaAliasName),
+	})
+	var resourceNotFoundException *lambdaTypes.ResourceNotFoundException
+	if err != nil && !errors.As(err, &resourceNotFoundException) {
+		return "", err
+	}
+	if err != nil {
+		createAliasOutput, err := lambdaClient.CreateAlias(context.TODO(), &lambda.CreateAliasInput{
+			FunctionName:    aws.String(functionName),
+			FunctionVersion: aws.String(version),
+			Name:            aws.String(lambdaAliasName),
+		})
+		if err != nil {
+			return "", err
+		}
+		return aws.ToString(createAliasOutput.AliasArn), nil
+	}
+	if aws.ToString(getAliasOutput.FunctionVersion) == version {
+		return aws.ToString(getAliasOutput.AliasArn), nil
+	}
+	updateAliasOutput, err := lambdaClient.UpdateAlias(context.TODO(), &lambda.UpdateAliasInput{
+		FunctionName:    aws.String(functionName),
+		FunctionVersion: aws.String(version),
+		Name:            aws.String(lambdaAliasName),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(updateAliasOutput.AliasArn), nil
+}
+
+func getPublishedLambdaVersions(lambdaClient *lambda.Client, functionName string) ([]string, error) {
+	var versions []string
+	listVersionsByFunctionPaginator := lambda.NewListVersionsByFunctionPaginator(lambdaClient, &lambda.ListVersionsByFunctionInput{
+		FunctionName: aws.String(functionName),
+	})
+	for listVersionsByFunctionPaginator.HasMorePages() {
+		listVersionsByFunctionOutput, err := listVersionsByFunctionPaginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		for _, function := range listVersionsByFunctionOutput.Versions {
+			if aws.ToString(function.Version) != "$LATEST" {
+				versions = append(versions, aws.ToString(function.Version))
+			}
+		}
+	}
+	return versions, nil
+}
+
+// sortLambdaVersions sorts published versions from the newest to the oldest.
+func sortLambdaVersions(versions []string) []string {
+	sorted := append([]string{}, versions...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, _ := strconv.Atoi(sorted[i])
+		b, _ := strconv.Atoi(sorted[j])
+		return a > b
+	})
+	return sorted
+}
+
+// getLambdaVersionsToPrune keeps the newest versions and the one the alias
+// points to, which may be older after a rollback.
+func getLambdaVersionsToPrune(versions []string, aliasVersion string, keep int) []string {
+	var prune []string
+	for i, version := range sortLambdaVersions(versions) {
+		if i < keep || version == aliasVersion {
+			continue
+		}
+		prune = append(prune, version)
+	}
+	return prune
+}
+
+func pruneLambdaVersions(lambdaClient *lambda.Client, functionName, aliasVersion string, keep int, logsWriter io.Writer) error {
+	versions, err := getPublishedLambdaVersions(lambdaClient, functionName)
+	if err != nil {
+		return err
+	}
+	for _, version := range getLambdaVersionsToPrune(versions, aliasVersion, keep) {
+		io.WriteString(logsWriter, fmt.Sprintf("Deleting Lambda function version: %s\n", version))
+		_, err = lambdaClient.DeleteFunction(context.TODO(), &lambda.DeleteFunctionInput{
+			FunctionName: aws.String(functionName),
+			Qualifier:    aws.String(version),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addLambdaPermissionIfNeeded adds a resource policy statement to the alias.
+// Statements are identified by id, so an existing one is left as it is.
+func addLambdaPermissionIfNeeded(lambdaClient *lambda.Client, addPermissionInput *lambda.AddPermissionInput) error {
+	_, err := lambdaClient.AddPermission(context.TODO(), addPermissionInput)
+	var resourceConflictException *lambdaTypes.ResourceConflictException
+	if err != nil && !errors.As(err, &resourceConflictException) {
+		return err
+	}
+	return nil
+}
+
+const (
+	lambdaFunctionUrlInvokeStatementId       = "function-url-invoke-via-url"
+	lambdaFunctionUrlLegacyInvokeStatementId = "function-url-invoke"
+)
+
+// createLambdaFunctionUrlIfNeeded returns the public URL of the live alias.
+func createLambdaFunctionUrlIfNeeded(lambdaClient *lambda.Client, functionName string, logsWriter io.Writer) (string, error) {
+	getFunctionUrlConfigOutput, err := lambdaClient.GetFunctionUrlConfig(context.TODO(), &lambda.GetFunctionUrlConfigInput{
+		FunctionName: aws.String(functionName),
+		Qualifier:    aws.String(lambdaAliasName),
+	})
+	var resourceNotFoundException *lambdaTypes.ResourceNotFoundException
+	if err != nil && !errors.As(err, &resourceNotFoundException) {
+		return "", err
+	}
+	var functionUrl string
+	if err == nil {
+		functionUrl = aws.ToString(getFunctionUrlConfigOutput.FunctionUrl)
+	} else {
+		createFunctionUrlConfigOutput, err := lambdaClient.CreateFunctionUrlConfig(context.TODO(), &lambda.CreateFunctionUrlConfigInput{
+			AuthType:     lambdaTypes.FunctionUrlAuthTypeNone,
+			FunctionName: aws.String(functionName),
+			Qualifier:    aws.String(lambdaAliasName),
+		})
+		if err != nil {
+			return "", err
+		}
+		functionUrl = aws.ToString(createFunctionUrlConfigOutput.FunctionUrl)
+		io.WriteString(logsWriter, fmt.Sprintf("Created Lambda function URL: %s\n", functionUrl))
+	}
+	//a public URL needs both actions, the lambda:FunctionUrlAuthType condition
+	//on the second keeps everyone from invoking the alias directly
+	err = addLambdaPermissionIfNeeded(lambdaClient, &lambda.AddPermissionInput{
+		Action:              aws.String("lambda:InvokeFunctionUrl"),
+		FunctionName:        aws.String(functionName),
+		Principal:           aws.String("*"),
+		StatementId:         aws.String("function-url"),
+		FunctionUrlAuthType: lambdaTypes.FunctionUrlAuthTypeNone,
+		Qualifier:           aws.String(lambdaAliasName),
+	})
+	if err != nil {
+		return "", err
+	}
+	//earlier deployments added the invoke statement without the condition
+	_, err = lambdaClient.RemovePermission(context.TODO(), &lambda.RemovePermissionInput{
+		FunctionName: aws.String(functionName),
+		StatementId:  aws.String(lambdaFunctionUrlLegacyInvokeStatementId),
+		Qualifier:    aws.String(lambdaAliasName),
+	})
+	if err != nil && !errors.As(err, &resourceNotFoundException) {
+		return "", err
+	}
+	err = addLambdaPermissionIfNeeded(lambdaClient, &lambda.AddPermissionInput{
+		Action:              aws.String("lambda:InvokeFunction"),
+		FunctionName:        aws.String(functionName),
+		Principal:           aws.String("*"),
+		StatementId:         aws.String(lambdaFunctionUrlInvokeStatementId),
+		FunctionUrlAuthType: lambdaTypes.FunctionUrlAuthTypeNone,
+		Qualifier:           aws.String(lambdaAliasName),
+	})
+	if err != nil {
+		return "", err
+	}
+	return functionUrl, nil
+}
+
+// deleteLambdaFunctionUrlIfNeeded removes the URL when the function moved to
+// another trigger.
+func deleteLambdaFunctionUrlIfNeeded(lambdaClient *lambda.Client, functionName string, logsWriter io.Writer) error {
+	_, err := lambdaClient.DeleteFunctionUrlConfig(context.TODO(), &lambda.DeleteFunctionUrlConfigInput{
+		FunctionName: aws.String(functionName),
+		Qualifier:    aws.String(lambdaAliasName),
+	})
+	var resourceNotFoundException *lambdaTypes.ResourceNotFoundException
+	if errors.As(err, &resourceNotFoundException) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Deleted Lambda function URL of %s\n", functionName))
+	for _, statementId := range []string{"function-url", lambdaFunctionUrlInvokeStatementId, lambdaFunctionUrlLegacyInvokeStatementId} {
+		_, err = lambdaClient.RemovePermission(context.TODO(), &lambda.RemovePermissionInput{
+			FunctionName: aws.String(functionName),
+			StatementId:  aws.String(statementId),
+			Qualifier:    aws.String(lambdaAliasName),
+		})
+		if err != nil && !errors.As(err, &resourceNotFoundException) {
+			return err
+		}
+	}
+	return nil
+}
+
+// createLambdaTargetGroupIfNeeded creates the tg-<deploymentID> target group
+// with the live alias as its target. createAlbIfNeeded then finds it by name
+// and puts it behind the load balancer like a web service's target group.
+func createLambdaTargetGroupIfNeeded(parameters map[string]interface{}, elbClient *elasticloadbalancingv2.Client,
+	lambdaClient *lambda.Client, functionName, aliasArn string) error {
+	targetGroupName, err := getAlbTargetGroupName(parameters)
+	if err != nil {
+		return err
+	}
+	var targetGroupArn string
+	describeTargetGroupsOutput, _ := elbClient.DescribeTargetGroups(context.TODO(), &elasticloadbalancingv2.DescribeTargetGroupsInput{
+		Names: []string{
+			targetGroupName,
+		},
+	})
+	if describeTargetGroupsOutput != nil && len(describeTargetGroupsOutput.TargetGroups) > 0 {
+		targetGroupArn = aws.ToString(describeTargetGroupsOutput.TargetGroups[0].TargetGroupArn)
+	} else {
+		createTargetGroupOutput, err := elbClient.CreateTargetGroup(context.TODO(), &elasticloadbalancingv2.CreateTargetGroupInput{
+			Name:               aws.String(targetGroupName),
+			HealthCheckEnabled: aws.Bool(false),
+			Tags: []elbTypes.Tag{
+				{
+					Key:   aws.String("Name"),
+					Value: aws.String(targetGroupName),
+				},
+				{
+					Key:   aws.String("created by"),
+					Value: aws.String("deployment.io"),
+				},
+			},
+			TargetType: elbTypes.TargetTypeEnumLambda,
+		})
+		if err != nil {
+			return err
+		}
+		targetGroupArn = aws.ToString(createTargetGroupOutput.TargetGroups[0].TargetGroupArn)
+	}
+	err = addLambdaPermissionIfNeeded(lambdaClient, &lambda.AddPermissionInput{
+		Action:       aws.String("lambda:InvokeFunction"),
+		FunctionName: aws.String(functionName),
+		Principal:    aws.String("elasticloadbalancing.amazonaws.com"),
+		StatementId:  aws.String("alb"),
+		SourceArn:    aws.String(targetGroupArn),
+		Qualifier:    aws.String(lambdaAliasName),
+	})
+	if err != nil {
+		return err
+	}
+	//the alias ARN doesn't change between versions, registering it again is a no-op
+	_, err = elbClient.RegisterTargets(context.TODO(), &elasticloadbalancingv2.RegisterTargetsInput{
+		TargetGroupArn: aws.String(targetGroupArn),
+		Targets: []elbTypes.TargetDescription{
+			{
+				Id: aws.String(aliasArn),
+			},
+		},
+	})
+	return err
+}
+
+func createLambdaAlbIfNeeded(parameters map[string]interface{}, lambdaClient *lambda.Client, functionName, aliasArn string,
+	logsWriter io.Writer) error {
+	elbClient, err := cloud_api_clients.GetElbClient(parameters)
+	if err != nil {
+		return err
+	}
+	err = createLambdaTargetGroupIfNeeded(parameters, elbClient, lambdaClient, functionName, aliasArn)
+	if err != nil {
+		return err
+	}
+	if _, err = jobs.GetParameterValue[int64](parameters, parameters_enums.Port); err != nil {
+		//the load balancer invokes the function itself, its egress rule only needs some port
+		jobs.SetParameterValue(parameters, parameters_enums.Port, int64(443))
+	}
+	ec2Client, err := cloud_api_clients.GetEC2Client(parameters)
+	if err != nil {
+		return err
+	}
+	albSecurityGroupId, err := createAlbSecurityGroupIfNeeded(parameters, ec2Client)
+	if err != nil {
+		return err
+	}
+	_, _, err = createAlbIfNeeded(parameters, elbClient, albSecurityGroupId, logsWriter)
+	return err
+}
+
+// deleteLambdaAlbIfNeeded takes the function off its load balancer when it
+// moved to another trigger: the listener rule, the target group and, unless
+// other services still use it, the load balancer itself.
+func deleteLambdaAlbIfNeeded(parameters map[string]interface{}, lambdaClient *lambda.Client, functionName string,
+	logsWriter io.Writer) error {
+	elbClient, err := cloud_api_clients.GetElbClient(parameters)
+	if err != nil {
+		return err
+	}
+	targetGroupName, err := getAlbTargetGroupName(parameters)
+	if err != nil {
+		return err
+	}
+	describeTargetGroupsOutput, err := elbClient.DescribeTargetGroups(context.TODO(), &elasticloadbalancingv2.DescribeTargetGroupsInput{
+		Names: []string{
+			targetGroupName,
+		},
+	})
+	var targetGroupNotFoundException *elbTypes.TargetGroupNotFoundException
+	if err != nil && !errors.As(err, &targetGroupNotFoundException) {
+		return err
+	}
+	if err != nil || len(describeTargetGroupsOutput.TargetGroups) == 0 {
+		//never was behind a load balancer, or already taken off it
+		return nil
+	}
+	targetGroup := describeTargetGroupsOutput.TargetGroups[0]
+	jobs.SetParameterValue(parameters, parameters_enums.TargetGroupArn, aws.ToString(targetGroup.TargetGroupArn))
+	if len(targetGroup.LoadBalancerArns) == 0 {
+		io.WriteString(logsWriter, fmt.Sprintf("Deleting target group: %s\n", aws.ToString(targetGroup.TargetGroupArn)))
+		_, err = elbClient.DeleteTargetGroup(context.TODO(), &elasticloadbalancingv2.DeleteTargetGroupInput{
+			TargetGroupArn: targetGroup.TargetGroupArn,
+		})
+	} else if isSharedAlb(parameters) {
+		err = deleteSharedAlbService(parameters, elbClient, targetGroup.LoadBalancerArns[0], logsWriter)
+	} else {
+		err = deleteDedicatedAlb(parameters, elbClient, targetGroup.LoadBalancerArns[0], logsWriter)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = lambdaClient.RemovePermission(context.TODO(), &lambda.RemovePermissionInput{
+		FunctionName: aws.String(functionName),
+		StatementId:  aws.String("alb"),
+		Qualifier:    aws.String(lambdaAliasName),
+	})
+	var resourceNotFoundException *lambdaTypes.ResourceNotFoundException
+	if err != nil && !errors.As(err, &resourceNotFoundException) {
+		return err
+	}
+	return nil
+}
+
+func addLambdaPolicyForDeploymentRunner(parameters map[string]interface{}) error {
+	runnerData := utils.RunnerData.Get()
+	organizationID, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIDNamespace)
+	if err != nil {
+		return err
+	}
+	return iam_policies.AddAwsPolicyForDeploymentRunner(iam_policy_enums.AwsLambdaFunctionDeployment,
+		runnerData.OsType.String(), runnerData.CpuArchEnum.String(), organizationID, runnerData.RunnerRegion, runnerData.Mode, runnerData.TargetCloud)
+}
+
+func (d *DeployAwsLambdaFunction) Run(parameters map[string]interface{}, logsWriter io.Writer) (newParameters map[string]interface{}, err error) {
+	defer func() {
+		if err != nil {
+			<-MarkDeploymentDone(parameters, err)
+		}
+	}()
+
+	err = addLambdaPolicyForDeploymentRunner(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	settings, err := getLambdaSettings(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	functionName, err := getLambdaFunctionName(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	code, err := getLambdaCode(parameters, settings, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+
+	iamClient, err := cloud_api_clients.GetIamClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	roleArn, err := getLambdaRoleIfNeeded(iamClient, parameters)
+	if err != nil {
+		return parameters, err
+	}
+	lambdaRoleName, err := getLambdaRoleName(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	//the role's secrets policy is there exactly when secrets are stored
+	secretsPolicy, err := getRolePolicy(iamClient, lambdaRoleName, lambdaRoleSecretsPolicyName)
+	if err != nil {
+		return parameters, err
+	}
+	variables, secretArns, err := syncLambdaEnvironmentVariables(parameters, secretsPolicy != nil, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	err = syncLambdaRoleSecretsPolicy(iamClient, parameters, secretArns)
+	if err != nil {
+		return parameters, err
+	}
+	vpcConfig, err := getLambdaVpcConfig(parameters, settings)
+	if err != nil {
+		return parameters, err
+	}
+	logGroupName, err := commandUtils.GetLogGroupName(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	//same log group as the other deployment types so GetDeploymentLogsAws finds the logs
+	loggingConfig := &lambdaTypes.LoggingConfig{
+		LogFormat: lambdaTypes.LogFormatText,
+		LogGroup:  aws.String(logGroupName),
+	}
+	environment := &lambdaTypes.Environment{Variables: variables}
+
+	lambdaClient, err := cloud_api_clients.GetLambdaClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	function, err := getLambdaFunction(lambdaClient, functionName)
+	if err != nil {
+		return parameters, err
+	}
+	if function == nil {
+		io.WriteString(logsWriter, fmt.Sprintf("Creating Lambda function: %s\n", functionName))
+		createFunctionInput := &lambda.CreateFunctionInput{
+			Code:          &lambdaTypes.FunctionCode{},
+			FunctionName:  aws.String(functionName),
+			Role:          aws.String(roleArn),
+			Architectures: []lambdaTypes.Architecture{getLambdaArchitecture()},
+			Environment:   environment,
+			LoggingConfig: loggingConfig,
+			MemorySize:    aws.Int32(settings.MemorySize),
+			Tags: map[string]string{
+				"Name":       functionName,
+				"created by": "deployment.io",
+			},
+			Timeout:   aws.Int32(settings.Timeout),
+			VpcConfig: vpcConfig,
+		}
+		if len(code.ZipFile) > 0 {
+			createFunctionInput.PackageType = lambdaTypes.PackageTypeZip
+			createFunctionInput.Code.ZipFile = code.ZipFile
+			createFunctionInput.Runtime = lambdaTypes.Runtime(settings.Runtime)
+			createFunctionInput.Handler = aws.String(settings.Handler)
+		} else {
+			createFunctionInput.PackageType = lambdaTypes.PackageTypeImage
+			createFunctionInput.Code.ImageUri = aws.String(code.ImageUri)
+		}
+		err = createLambdaFunction(lambdaClient, createFunctionInput)
+		if err != nil {
+			return parameters, err
+		}
+	} else {
+		updateFunctionConfigurationInput := &lambda.UpdateFunctionConfigurationInput{
+			FunctionName:  aws.String(functionName),
+			Environment:   environment,
+			LoggingConfig: loggingConfig,
+			MemorySize:    aws.Int32(settings.MemorySize),
+			Role:          aws.String(roleArn),
+			Timeout:       aws.Int32(settings.Timeout),
+			VpcConfig:     vpcConfig,
+		}
+		if vpcConfig == nil {
+			//an empty config detaches a function that was in the VPC
+			updateFunctionConfigurationInput.VpcConfig = &lambdaTypes.VpcConfig{SubnetIds: []string{}, SecurityGroupIds: []string{}}
+		}
+		if len(code.ZipFile) > 0 {
+			updateFunctionConfigurationInput.Runtime = lambdaTypes.Runtime(settings.Runtime)
+			updateFunctionConfigurationInput.Handler = aws.String(settings.Handler)
+		}
+		err = updateLambdaFunction(lambdaClient, function, updateFunctionConfigurationInput, code, logsWriter)
+		if err != nil {
+			return parameters, err
+		}
+	}
+
+	version, aliasArn, err := publishLambdaVersion(lambdaClient, functionName, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+
+	var functionUrl string
+	if settings.Trigger == lambdaTriggerUrl {
+		functionUrl, err = createLambdaFunctionUrlIfNeeded(lambdaClient, functionName, logsWriter)
+	} else {
+		err = deleteLambdaFunctionUrlIfNeeded(lambdaClient, functionName, logsWriter)
+	}
+	if err != nil {
+		return parameters, err
+	}
+	if settings.Trigger == lambdaTriggerAlb {
+		err = createLambdaAlbIfNeeded(parameters, lambdaClient, functionName, aliasArn, logsWriter)
+	} else {
+		err = deleteLambdaAlbIfNeeded(parameters, lambdaClient, functionName, logsWriter)
+	}
+	if err != nil {
+		return parameters, err
+	}
+
+	err = pruneLambdaVersions(lambdaClient, functionName, version, int(settings.VersionsToKeep), logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return parameters, err
+	}
+	buildID, err := jobs.GetParameterValue[string](parameters, parameters_enums.BuildID)
+	if err != nil {
+		return parameters, err
+	}
+	var organizationIdFromJob string
+	organizationIdFromJob, err = jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIdFromJob)
+	if err != nil {
+		return parameters, err
+	}
+	if !isPreview(parameters) {
+		commandUtils.UpdateDeploymentsPipeline.Add(organizationIdFromJob, deployments.UpdateDeploymentDtoV1{
+			ID:                    deploymentID,
+			LambdaFunctionArn:     aliasArn,
+			LambdaFunctionUrl:     functionUrl,
+			LambdaFunctionVersion: version,
+		})
+		commandUtils.UpdateBuildsPipeline.Add(organizationIdFromJob, builds.UpdateBuildDtoV1{
+			ID:                    buildID,
+			LambdaFunctionVersion: version,
+		})
+	} else {
+		//build id is preview id
+		previewID := buildID
+		commandUtils.UpdatePreviewsPipeline.Add(organizationIdFromJob, previews.UpdatePreviewDtoV1{
+			ID:                    previewID,
+			LambdaFunctionArn:     aliasArn,
+			LambdaFunctionUrl:     functionUrl,
+			LambdaFunctionVersion: version,
+		})
+	}
+
+	//mark build done successfully
+	<-MarkDeploymentDone(parameters, nil)
+
+	return parameters, nil
+}
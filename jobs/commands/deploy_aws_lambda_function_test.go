@@ -0,0 +1,148 @@
+package commands
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseLambdaSettings_Defaults(t *testing.T) {
+	settings, err := parseLambdaSettings(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.PackageType != lambdaPackageTypeImage || settings.Trigger != lambdaTriggerUrl ||
+		settings.MemorySize != defaultLambdaMemorySize || settings.Timeout != defaultLambdaTimeout ||
+		settings.VersionsToKeep != defaultLambdaVersionsToKeep {
+		t.Errorf("settings = %+v", settings)
+	}
+}
+
+func TestParseLambdaSettings_Zip(t *testing.T) {
+	settings, err := parseLambdaSettings([]byte(`{"package_type": "zip", "runtime": "python3.12", "handler": "app.handler",
+		"code_directory": "webhook", "memory_size": 1024, "timeout": 60, "trigger": "alb", "vpc": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.CodeDirectory != "webhook" || settings.MemorySize != 1024 || settings.Trigger != lambdaTriggerAlb || !settings.Vpc {
+		t.Errorf("settings = %+v", settings)
+	}
+}
+
+func TestParseLambdaSettings_Invalid(t *testing.T) {
+	cases := map[string]string{
+		"package type":   `{"package_type": "jar"}`,
+		"no handler":     `{"package_type": "zip", "runtime": "python3.12"}`,
+		"runtime":        `{"package_type": "zip", "runtime": "cobol", "handler": "app.handler"}`,
+		"code directory": `{"package_type": "zip", "runtime": "python3.12", "handler": "app.handler", "code_directory": "../other"}`,
+		"memory":         `{"memory_size": 64}`,
+		"timeout":        `{"timeout": 901}`,
+		"trigger":        `{"trigger": "api-gateway"}`,
+		"versions":       `{"versions_to_keep": -1}`,
+	}
+	for name, settingsJSON := range cases {
+		if _, err := parseLambdaSettings([]byte(settingsJSON)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestGetLambdaEnvironmentVariables(t *testing.T) {
+	variables, err := getLambdaEnvironmentVariables(map[string]string{"MODE": "prod"},
+		map[string]string{"API_KEY": "arn:aws:secretsmanager:us-east-1:1:secret:env-d1/API_KEY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"MODE":               "prod",
+		"API_KEY_SECRET_ARN": "arn:aws:secretsmanager:us-east-1:1:secret:env-d1/API_KEY",
+	}
+	if !reflect.DeepEqual(variables, want) {
+		t.Errorf("variables = %v, want %v", variables, want)
+	}
+
+	if _, err = getLambdaEnvironmentVariables(map[string]string{"API_KEY": "x"}, map[string]string{"API_KEY": "arn"}); err == nil {
+		t.Error("expected an error for a key set as plain and as secret")
+	}
+	if _, err = getLambdaEnvironmentVariables(map[string]string{"API_KEY_SECRET_ARN": "x"}, map[string]string{"API_KEY": "arn"}); err == nil {
+		t.Error("expected an error for a plain key shadowing a secret ARN")
+	}
+}
+
+func TestBuildLambdaZip(t *testing.T) {
+	directory := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(directory, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(directory, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"index.js":      "exports.handler = async () => ({statusCode: 200})",
+		"lib/util.js":   "module.exports = {}",
+		".git/HEAD":     "ref: refs/heads/main",
+		".git/config":   "[core]",
+		"lib/README.md": "util",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(directory, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	zipFile, err := buildLambdaZip(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zipReader, err := zip.NewReader(bytes.NewReader(zipFile), int64(len(zipFile)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range zipReader.File {
+		names = append(names, file.Name)
+	}
+	want := []string{"index.js", "lib/README.md", "lib/util.js"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("names = %v, want %v", names, want)
+	}
+
+	//unchanged code has to give the same hash so no new version is published
+	zipFileAgain, err := buildLambdaZip(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getLambdaCodeSha256(zipFile) != getLambdaCodeSha256(zipFileAgain) {
+		t.Error("zipping the same directory twice gave different archives")
+	}
+}
+
+func TestGetLambdaVersionsToPrune(t *testing.T) {
+	versions := []string{"1", "2", "3", "10", "11", "12"}
+	prune := getLambdaVersionsToPrune(versions, "12", 3)
+	if want := []string{"3", "2", "1"}; !reflect.DeepEqual(prune, want) {
+		t.Errorf("prune = %v, want %v", prune, want)
+	}
+	//a rolled back alias keeps its version
+	prune = getLambdaVersionsToPrune(versions, "2", 3)
+	if want := []string{"3", "1"}; !reflect.DeepEqual(prune, want) {
+		t.Errorf("prune = %v, want %v", prune, want)
+	}
+	if prune = getLambdaVersionsToPrune(versions[:2], "2", 3); len(prune) != 0 {
+		t.Errorf("prune = %v, want none", prune)
+	}
+}
+
+func TestGetPreviousLambdaVersion(t *testing.T) {
+	versions := []string{"9", "10", "12"}
+	version, err := getPreviousLambdaVersion(versions, "12")
+	if err != nil || version != "10" {
+		t.Errorf("version = %s, %v, want 10", version, err)
+	}
+	if _, err = getPreviousLambdaVersion(versions, "9"); err == nil {
+		t.Error("expected an error without an older version")
+	}
+}
@@ -0,0 +1,351 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snsTypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/deployments"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/region_enums"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
+	"github.com/deployment-io/deployment-runner/utils/aws_utils"
+)
+
+// DeployAwsManagedResource creates or updates a resource services use next
+// to their own code: an SQS queue with a dead-letter queue, an SNS topic or an
+// application S3 bucket, as picked by ManagedResourceType. Services get access
+// to it through their LinkedResources.
+type DeployAwsManagedResource struct {
+}
+
+// managedResourceSettings is the optional ManagedResourceSettings parameter.
+// Zero values keep the AWS defaults.
+type managedResourceSettings struct {
+	VisibilityTimeoutSeconds int32 `json:"visibility_timeout_seconds"` // sqs
+	MessageRetentionSeconds  int32 `json:"message_retention_seconds"`  // sqs
+	MaxReceiveCount          int32 `json:"max_receive_count"`          // sqs, receives before a message moves to the dlq, 5 when empty
+	Versioning               *bool `json:"versioning"`                 // s3, left as it is when empty
+}
+
+const defaultSqsMaxReceiveCount = 5
+
+func getManagedResourceSettings(parameters map[string]interface{}) (*managedResourceSettings, error) {
+	settingsJSON, err := jobs.GetParameterValue[string](parameters, parameters_enums.ManagedResourceSettings)
+	if err != nil || len(settingsJSON) == 0 {
+		return parseManagedResourceSettings(nil)
+	}
+	return parseManagedResourceSettings([]byte(settingsJSON))
+}
+
+func parseManagedResourceSettings(settingsBytes []byte) (*managedResourceSettings, error) {
+	settings := &managedResourceSettings{}
+	if len(settingsBytes) > 0 {
+		if err := json.Unmarshal(settingsBytes, settings); err != nil {
+			return nil, fmt.Errorf("error unmarshalling managed resource settings: %s", err)
+		}
+	}
+	if settings.VisibilityTimeoutSeconds < 0 || settings.VisibilityTimeoutSeconds > 43200 {
+		return nil, fmt.Errorf("visibility timeout must be between 0 and 43200 seconds")
+	}
+	if settings.MessageRetentionSeconds != 0 && (settings.MessageRetentionSeconds < 60 || settings.MessageRetentionSeconds > 1209600) {
+		return nil, fmt.Errorf("message retention must be between 60 and 1209600 seconds")
+	}
+	if settings.MaxReceiveCount == 0 {
+		settings.MaxReceiveCount = defaultSqsMaxReceiveCount
+	}
+	if settings.MaxReceiveCount < 1 || settings.MaxReceiveCount > 1000 {
+		return nil, fmt.Errorf("max receive count must be between 1 and 1000")
+	}
+	return settings, nil
+}
+
+func getManagedResourceTags(parameters map[string]interface{}, name string) (map[string]string, error) {
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"Name":          name,
+		"created by":    "deployment.io",
+		"deployment-id": deploymentID,
+	}, nil
+}
+
+// getSqsQueueAttributes is the queue configuration from the settings. The
+// dead-letter queue keeps messages for the longest retention allowed.
+func getSqsQueueAttributes(settings *managedResourceSettings, deadLetterQueueArn string) (map[string]string, error) {
+	attributes := map[string]string{
+		"SqsManagedSseEnabled": "true",
+	}
+	if len(deadLetterQueueArn) == 0 {
+		attributes["MessageRetentionPeriod"] = "1209600"
+		return attributes, nil
+	}
+	if settings.VisibilityTimeoutSeconds > 0 {
+		attributes["VisibilityTimeout"] = strconv.Itoa(int(settings.VisibilityTimeoutSeconds))
+	}
+	if settings.MessageRetentionSeconds > 0 {
+		attributes["MessageRetentionPeriod"] = strconv.Itoa(int(settings.MessageRetentionSeconds))
+	}
+	redrivePolicy, err := json.Marshal(map[string]interface{}{
+		"deadLetterTargetArn": deadLetterQueueArn,
+		"maxReceiveCount":     settings.MaxReceiveCount,
+	})
+	if err != nil {
+		return nil, err
+	}
+	attributes["RedrivePolicy"] = string(redrivePolicy)
+	return attributes, nil
+}
+
+func createSqsQueueIfNeeded(parameters map[string]interface{}, sqsClient *sqs.Client, queueName string, attributes map[string]string,
+	logsWriter io.Writer) (*managedResource, error) {
+	queue, err := getSqsQueue(sqsClient, queueName)
+	if err != nil {
+		return nil, err
+	}
+	if queue != nil {
+		_, err = sqsClient.SetQueueAttributes(context.TODO(), &sqs.SetQueueAttributesInput{
+			QueueUrl:   aws.String(queue.Url),
+			Attributes: attributes,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return queue, nil
+	}
+	tags, err := getManagedResourceTags(parameters, queueName)
+	if err != nil {
+		return nil, err
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Creating SQS queue: %s\n", queueName))
+	_, err = sqsClient.CreateQueue(context.TODO(), &sqs.CreateQueueInput{
+		QueueName:  aws.String(queueName),
+		Attributes: attributes,
+		Tags:       tags,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return getSqsQueue(sqsClient, queueName)
+}
+
+func deploySqsQueue(parameters map[string]interface{}, deploymentID string, settings *managedResourceSettings,
+	logsWriter io.Writer) (*managedResource, error) {
+	sqsClient, err := cloud_api_clients.GetSqsClient(parameters)
+	if err != nil {
+		return nil, err
+	}
+	deadLetterQueueAttributes, err := getSqsQueueAttributes(settings, "")
+	if err != nil {
+		return nil, err
+	}
+	deadLetterQueue, err := createSqsQueueIfNeeded(parameters, sqsClient, getSqsDeadLetterQueueName(deploymentID),
+		deadLetterQueueAttributes, logsWriter)
+	if err != nil {
+		return nil, err
+	}
+	queueAttributes, err := getSqsQueueAttributes(settings, deadLetterQueue.Arn)
+	if err != nil {
+		return nil, err
+	}
+	queue, err := createSqsQueueIfNeeded(parameters, sqsClient, getSqsQueueName(deploymentID), queueAttributes, logsWriter)
+	if err != nil {
+		return nil, err
+	}
+	queue.DlqArn = deadLetterQueue.Arn
+	queue.DlqUrl = deadLetterQueue.Url
+	return queue, nil
+}
+
+func deploySnsTopic(parameters map[string]interface{}, deploymentID string, logsWriter io.Writer) (*managedResource, error) {
+	snsClient, err := cloud_api_clients.GetSnsClient(parameters)
+	if err != nil {
+		return nil, err
+	}
+	topicName := getSnsTopicName(deploymentID)
+	topicArn, err := getSnsTopicArn(snsClient, topicName)
+	if err != nil {
+		return nil, err
+	}
+	if len(topicArn) == 0 {
+		tags, err := getManagedResourceTags(parameters, topicName)
+		if err != nil {
+			return nil, err
+		}
+		var snsTags []snsTypes.Tag
+		for key, value := range tags {
+			snsTags = append(snsTags, snsTypes.Tag{Key: aws.String(key), Value: aws.String(value)})
+		}
+		io.WriteString(logsWriter, fmt.Sprintf("Creating SNS topic: %s\n", topicName))
+		//S3 and EventBridge can't publish to a topic encrypted with the AWS
+		//managed key, alias/aws/sns, so the topic isn't encrypted with it
+		createTopicOutput, err := snsClient.CreateTopic(context.TODO(), &sns.CreateTopicInput{
+			Name: aws.String(topicName),
+			Tags: snsTags,
+		})
+		if err != nil {
+			return nil, err
+		}
+		topicArn = aws.ToString(createTopicOutput.TopicArn)
+	} else {
+		getTopicAttributesOutput, err := snsClient.GetTopicAttributes(context.TODO(), &sns.GetTopicAttributesInput{
+			TopicArn: aws.String(topicArn),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if getTopicAttributesOutput.Attributes["KmsMasterKeyId"] == "alias/aws/sns" {
+			io.WriteString(logsWriter, fmt.Sprintf("Removing the AWS managed key from SNS topic: %s\n", topicName))
+			_, err = snsClient.SetTopicAttributes(context.TODO(), &sns.SetTopicAttributesInput{
+				TopicArn:       aws.String(topicArn),
+				AttributeName:  aws.String("KmsMasterKeyId"),
+				AttributeValue: aws.String(""),
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &managedResource{Type: managedResourceSns, Arn: topicArn}, nil
+}
+
+func deployS3Bucket(parameters map[string]interface{}, deploymentID string, settings *managedResourceSettings,
+	logsWriter io.Writer) (*managedResource, error) {
+	organizationID, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIDNamespace)
+	if err != nil {
+		return nil, err
+	}
+	region, err := jobs.GetParameterValue[int64](parameters, parameters_enums.Region)
+	if err != nil {
+		return nil, err
+	}
+	s3Client, err := cloud_api_clients.GetS3Client(parameters)
+	if err != nil {
+		return nil, err
+	}
+	bucketName := getManagedBucketName(organizationID, deploymentID)
+	_, isNewBucketCreated, err := aws_utils.CreateS3BucketIfNeeded(s3Client, bucketName, region_enums.Type(region).String())
+	if err != nil {
+		return nil, err
+	}
+	if isNewBucketCreated {
+		io.WriteString(logsWriter, fmt.Sprintf("Created S3 bucket: %s\n", bucketName))
+		_, err = s3Client.PutPublicAccessBlock(context.TODO(), &s3.PutPublicAccessBlockInput{
+			Bucket: aws.String(bucketName),
+			PublicAccessBlockConfiguration: &s3Types.PublicAccessBlockConfiguration{
+				BlockPublicAcls:       aws.Bool(true),
+				BlockPublicPolicy:     aws.Bool(true),
+				IgnorePublicAcls:      aws.Bool(true),
+				RestrictPublicBuckets: aws.Bool(true),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		tags, err := getManagedResourceTags(parameters, bucketName)
+		if err != nil {
+			return nil, err
+		}
+		var s3Tags []s3Types.Tag
+		for key, value := range tags {
+			s3Tags = append(s3Tags, s3Types.Tag{Key: aws.String(key), Value: aws.String(value)})
+		}
+		_, err = s3Client.PutBucketTagging(context.TODO(), &s3.PutBucketTaggingInput{
+			Bucket:  aws.String(bucketName),
+			Tagging: &s3Types.Tagging{TagSet: s3Tags},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	//versioning is only touched when it's set, a bucket's versioning can only
+	//be suspended once it was turned on
+	if settings.Versioning == nil {
+		return &managedResource{Type: managedResourceS3, Arn: "arn:aws:s3:::" + bucketName, Url: bucketName}, nil
+	}
+	getBucketVersioningOutput, err := s3Client.GetBucketVersioning(context.TODO(), &s3.GetBucketVersioningInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if *settings.Versioning && getBucketVersioningOutput.Status != s3Types.BucketVersioningStatusEnabled {
+		io.WriteString(logsWriter, fmt.Sprintf("Enabling versioning for S3 bucket: %s\n", bucketName))
+		_, err = s3Client.PutBucketVersioning(context.TODO(), &s3.PutBucketVersioningInput{
+			Bucket:                  aws.String(bucketName),
+			VersioningConfiguration: &s3Types.VersioningConfiguration{Status: s3Types.BucketVersioningStatusEnabled},
+		})
+	} else if !*settings.Versioning && getBucketVersioningOutput.Status == s3Types.BucketVersioningStatusEnabled {
+		io.WriteString(logsWriter, fmt.Sprintf("Suspending versioning for S3 bucket: %s\n", bucketName))
+		_, err = s3Client.PutBucketVersioning(context.TODO(), &s3.PutBucketVersioningInput{
+			Bucket:                  aws.String(bucketName),
+			VersioningConfiguration: &s3Types.VersioningConfiguration{Status: s3Types.BucketVersioningStatusSuspended},
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &managedResource{Type: managedResourceS3, Arn: "arn:aws:s3:::" + bucketName, Url: bucketName}, nil
+}
+
+func (d *DeployAwsManagedResource) Run(parameters map[string]interface{}, logsWriter io.Writer) (newParameters map[string]interface{}, err error) {
+	defer func() {
+		<-MarkDeploymentDone(parameters, err)
+	}()
+	resourceType, err := getManagedResourceType(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	settings, err := getManagedResourceSettings(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return parameters, err
+	}
+
+	io.WriteString(logsWriter, fmt.Sprintf("Deploying %s resource\n", resourceType))
+
+	err = addManagedResourcesPolicyForDeploymentRunner(parameters)
+	if err != nil {
+		return parameters, err
+	}
+
+	var resource *managedResource
+	switch resourceType {
+	case managedResourceSqs:
+		resource, err = deploySqsQueue(parameters, deploymentID, settings, logsWriter)
+	case managedResourceSns:
+		resource, err = deploySnsTopic(parameters, deploymentID, logsWriter)
+	case managedResourceS3:
+		resource, err = deployS3Bucket(parameters, deploymentID, settings, logsWriter)
+	}
+	if err != nil {
+		return parameters, err
+	}
+
+	organizationIdFromJob, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIdFromJob)
+	if err != nil {
+		return parameters, err
+	}
+	commandUtils.UpdateDeploymentsPipeline.Add(organizationIdFromJob, deployments.UpdateDeploymentDtoV1{
+		ID:                 deploymentID,
+		ManagedResourceArn: resource.Arn,
+		ManagedResourceUrl: resource.Url,
+	})
+	io.WriteString(logsWriter, fmt.Sprintf("%s resource is available: %s\n", resourceType, resource.Arn))
+	return parameters, nil
+}
@@ -0,0 +1,1145 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/deployment-io/deployment-runner-kit/builds"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/deployments"
+	"github.com/deployment-io/deployment-runner-kit/enums/iam_policy_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/region_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/vpc_enums"
+	"github.com/deployment-io/deployment-runner-kit/iam_policies"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	"github.com/deployment-io/deployment-runner-kit/previews"
+	"github.com/deployment-io/deployment-runner-kit/vpcs"
+	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
+	"github.com/deployment-io/deployment-runner/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DeployAwsNlbService runs the service behind an internet-facing Network Load
+// Balancer for raw TCP/UDP traffic or TLS terminated at the load balancer.
+// The load balancer gets an Elastic IP in every public subnet so clients can
+// allow-list static addresses.
+type DeployAwsNlbService struct {
+}
+
+// an ECS service can be attached to at most 5 target groups
+const maxNlbListeners = 5
+
+type nlbListener struct {
+	Port           int32  `json:"port"`
+	Protocol       string `json:"protocol"` // "TCP" (default) | "UDP" | "TCP_UDP" | "TLS"
+	ContainerPort  int32  `json:"container_port"`
+	CertificateArn string `json:"certificate_arn"`
+	//UDP can't be health checked, so a UDP listener needs a port or protocol
+	//the container answers health checks on
+	HealthCheckPort     string `json:"health_check_port"`     // "traffic-port" (default) or a port number
+	HealthCheckProtocol string `json:"health_check_protocol"` // "TCP" (default) | "HTTP" | "HTTPS"
+	HealthCheckPath     string `json:"health_check_path"`     // for HTTP and HTTPS, "/" by default
+	//clients allowed to reach UDP and TCP_UDP listeners, 0.0.0.0/0 by default
+	AllowedCidrs []string `json:"allowed_cidrs"`
+}
+
+// preservesClientIp reports whether the tasks see the client's address
+// rather than the load balancer's. Always the case for UDP and TCP_UDP
+// target groups with ip targets.
+func (n nlbListener) preservesClientIp() bool {
+	return n.Protocol == "UDP" || n.Protocol == "TCP_UDP"
+}
+
+// targetGroupProtocol is the protocol between the load balancer and the
+// tasks. TLS is terminated at the load balancer.
+func (n nlbListener) targetGroupProtocol() elbTypes.ProtocolEnum {
+	if n.Protocol == "TLS" {
+		return elbTypes.ProtocolEnumTcp
+	}
+	return elbTypes.ProtocolEnum(n.Protocol)
+}
+
+func (n nlbListener) healthCheckPath() *string {
+	if n.HealthCheckProtocol == "TCP" {
+		return nil
+	}
+	return aws.String(n.HealthCheckPath)
+}
+
+// key identifies the listener on the load balancer.
+func (n nlbListener) key() string {
+	return fmt.Sprintf("%s/%d", n.Protocol, n.Port)
+}
+
+// containerProtocols are the transport protocols the container port has to
+// be mapped and opened for.
+func (n nlbListener) containerProtocols() []ecsTypes.TransportProtocol {
+	switch n.Protocol {
+	case "UDP":
+		return []ecsTypes.TransportProtocol{ecsTypes.TransportProtocolUdp}
+	case "TCP_UDP":
+		return []ecsTypes.TransportProtocol{ecsTypes.TransportProtocolTcp, ecsTypes.TransportProtocolUdp}
+	}
+	return []ecsTypes.TransportProtocol{ecsTypes.TransportProtocolTcp}
+}
+
+func getNlbListeners(parameters map[string]interface{}) ([]nlbListener, error) {
+	listenersJSON, err := jobs.GetParameterValue[string](parameters, parameters_enums.NlbListeners)
+	if err != nil || len(listenersJSON) == 0 {
+		return nil, nil
+	}
+	port, err := jobs.GetParameterValue[int64](parameters, parameters_enums.Port)
+	if err != nil {
+		return nil, err
+	}
+	certificateArn, _ := jobs.GetParameterValue[string](parameters, parameters_enums.AcmCertificateArn)
+	return parseNlbListeners([]byte(listenersJSON), int32(port), certificateArn)
+}
+
+// parseNlbListeners fills in the container port and certificate defaults
+// and rejects listeners the load balancer would refuse.
+func parseNlbListeners(listenersBytes []byte, defaultContainerPort int32, defaultCertificateArn string) ([]nlbListener, error) {
+	var listeners []nlbListener
+	if err := json.Unmarshal(listenersBytes, &listeners); err != nil {
+		return nil, fmt.Errorf("error unmarshalling nlb listeners: %s", err)
+	}
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("at least one nlb listener is required")
+	}
+	if len(listeners) > maxNlbListeners {
+		return nil, fmt.Errorf("at most %d nlb listeners are supported", maxNlbListeners)
+	}
+	//TCP_UDP takes the port for both protocols
+	takenPorts := map[string]bool{}
+	for i := range listeners {
+		listener := &listeners[i]
+		if len(listener.Protocol) == 0 {
+			listener.Protocol = "TCP"
+		}
+		if listener.Port < 1 || listener.Port > 65535 {
+			return nil, fmt.Errorf("invalid nlb listener port %d", listener.Port)
+		}
+		if listener.ContainerPort == 0 {
+			listener.ContainerPort = defaultContainerPort
+		}
+		if listener.ContainerPort < 1 || listener.ContainerPort > 65535 {
+			return nil, fmt.Errorf("invalid container port %d for nlb listener %d", listener.ContainerPort, listener.Port)
+		}
+		if listener.Protocol == "UDP" && len(listener.HealthCheckPort) == 0 && len(listener.HealthCheckProtocol) == 0 {
+			return nil, fmt.Errorf("the UDP listener on port %d needs a health check port or protocol", listener.Port)
+		}
+		if len(listener.HealthCheckPort) == 0 {
+			listener.HealthCheckPort = "traffic-port"
+		}
+		if listener.HealthCheckPort != "traffic-port" {
+			healthCheckPort, err := strconv.Atoi(listener.HealthCheckPort)
+			if err != nil || healthCheckPort < 1 || healthCheckPort > 65535 {
+				return nil, fmt.Errorf("invalid health check port %s for nlb listener %d", listener.HealthCheckPort, listener.Port)
+			}
+		}
+		switch listener.HealthCheckProtocol {
+		case "":
+			listener.HealthCheckProtocol = "TCP"
+		case "TCP":
+		case "HTTP", "HTTPS":
+			if len(listener.HealthCheckPath) == 0 {
+				listener.HealthCheckPath = "/"
+			}
+		default:
+			return nil, fmt.Errorf("unsupported health check protocol %s for nlb listener %d", listener.HealthCheckProtocol, listener.Port)
+		}
+		if listener.preservesClientIp() {
+			allowedCidrs, err := parseNlbAllowedCidrs(listener.AllowedCidrs)
+			if err != nil {
+				return nil, err
+			}
+			listener.AllowedCidrs = allowedCidrs
+		} else if len(listener.AllowedCidrs) > 0 {
+			return nil, fmt.Errorf("allowed CIDRs are only supported for UDP and TCP_UDP listeners")
+		}
+		var transports []string
+		switch listener.Protocol {
+		case "TCP", "TLS":
+			transports = []string{"tcp"}
+		case "UDP":
+			transports = []string{"udp"}
+		case "TCP_UDP":
+			transports = []string{"tcp", "udp"}
+		default:
+			return nil, fmt.Errorf("unsupported nlb listener protocol %s", listener.Protocol)
+		}
+		if listener.Protocol == "TLS" {
+			if len(listener.CertificateArn) == 0 {
+				listener.CertificateArn = defaultCertificateArn
+			}
+			if len(listener.CertificateArn) == 0 {
+				return nil, fmt.Errorf("a certificate is required for the TLS listener on port %d", listener.Port)
+			}
+		}
+		for _, transport := range transports {
+			key := fmt.Sprintf("%s/%d", transport, listener.Port)
+			if takenPorts[key] {
+				return nil, fmt.Errorf("more than one nlb listener for %s", key)
+			}
+			takenPorts[key] = true
+		}
+	}
+	return listeners, nil
+}
+
+// parseNlbAllowedCidrs validates the IPv4 CIDRs clients may connect from,
+// everyone when there are none.
+func parseNlbAllowedCidrs(allowedCidrs []string) ([]string, error) {
+	if len(allowedCidrs) == 0 {
+		return []string{"0.0.0.0/0"}, nil
+	}
+	var cidrs []string
+	for _, allowedCidr := range allowedCidrs {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(allowedCidr))
+		if err != nil || ipNet.IP.To4() == nil {
+			return nil, fmt.Errorf("invalid allowed CIDR %s, an IPv4 CIDR is required", allowedCidr)
+		}
+		if !slices.Contains(cidrs, ipNet.String()) {
+			cidrs = append(cidrs, ipNet.String())
+		}
+	}
+	return cidrs, nil
+}
+
+// getNlbContainerPortMappings returns the port mappings the NLB listeners
+// need in addition to the app's tcp port.
+func getNlbContainerPortMappings(parameters map[string]interface{}, existing []ecsTypes.PortMapping) ([]ecsTypes.PortMapping, error) {
+	listeners, err := getNlbListeners(parameters)
+	if err != nil || len(listeners) == 0 {
+		return nil, err
+	}
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return nil, err
+	}
+	mapped := map[string]bool{}
+	for _, portMapping := range existing {
+		mapped[fmt.Sprintf("%s/%d", portMapping.Protocol, aws.ToInt32(portMapping.ContainerPort))] = true
+	}
+	var portMappings []ecsTypes.PortMapping
+	for _, listener := range listeners {
+		for _, protocol := range listener.containerProtocols() {
+			key := fmt.Sprintf("%s/%d", protocol, listener.ContainerPort)
+			if mapped[key] {
+				continue
+			}
+			mapped[key] = true
+			portMappings = append(portMappings, ecsTypes.PortMapping{
+				ContainerPort: aws.Int32(listener.ContainerPort),
+				//port-mapping-<deploymentID>-<port>-<protocol>
+				Name:     aws.String(fmt.Sprintf("port-mapping-%s-%d-%s", deploymentID, listener.ContainerPort, protocol)),
+				Protocol: protocol,
+			})
+		}
+	}
+	return portMappings, nil
+}
+
+func getNlbName(parameters map[string]interface{}) (string, error) {
+	//nlb-<deploymentID>
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("nlb-%s", deploymentID), nil
+}
+
+func getNlbTargetGroupName(parameters map[string]interface{}, index int) (string, error) {
+	//nt<index>-<deploymentID>
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("nt%d-%s", index, deploymentID), nil
+}
+
+func getNlbElasticIpName(parameters map[string]interface{}, subnetID string) (string, error) {
+	//eip-<deploymentID>-<subnetID>
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("eip-%s-%s", deploymentID, subnetID), nil
+}
+
+// reportDefaultSecurityGroupIngressRules syncs the default security group's
+// ingress rule ids with the server after rules were added or removed.
+func reportDefaultSecurityGroupIngressRules(parameters map[string]interface{}, ec2Client *ec2.Client, defaultSecurityGroupId string) error {
+	describeSecurityGroupRulesOutput, err := ec2Client.DescribeSecurityGroupRules(context.TODO(), &ec2.DescribeSecurityGroupRulesInput{
+		DryRun: aws.Bool(false),
+		Filters: []ec2Types.Filter{
+			{
+				Name: aws.String("group-id"),
+				Values: []string{
+					defaultSecurityGroupId,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	var ingressRules []vpcs.DefaultSecurityIngressRuleDtoV1
+	for _, securityGroupRule := range describeSecurityGroupRulesOutput.SecurityGroupRules {
+		if !aws.ToBool(securityGroupRule.IsEgress) {
+			ingressRules = append(ingressRules, vpcs.DefaultSecurityIngressRuleDtoV1{
+				ID: aws.ToString(securityGroupRule.SecurityGroupRuleId),
+			})
+		}
+	}
+	region, err := jobs.GetParameterValue[int64](parameters, parameters_enums.Region)
+	if err != nil {
+		return err
+	}
+	organizationIdFromJob, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIdFromJob)
+	if err != nil {
+		return err
+	}
+	commandUtils.UpsertVpcsPipeline.Add(organizationIdFromJob, vpcs.UpsertVpcDtoV1{
+		Type:                        vpc_enums.AwsVpc,
+		Region:                      region_enums.Type(region),
+		DefaultSecurityIngressRules: ingressRules,
+		DefaultSecurityGroupId:      defaultSecurityGroupId,
+	})
+	return nil
+}
+
+// nlbIngressRule is an ingress rule on the default VPC security group for
+// one port and protocol, with the CIDRs allowed to reach it.
+type nlbIngressRule struct {
+	name     string
+	protocol string
+	port     int32
+	cidrs    []string
+}
+
+// getNlbIngressRules returns the ingress rules the listeners need. Clients
+// of UDP and TCP_UDP listeners reach the tasks with their own address, so
+// those ports are opened to the listener's allowed CIDRs. TCP traffic and
+// health checks come from the load balancer inside the VPC. Listeners
+// sharing a container port share its rule.
+func getNlbIngressRules(deploymentID, vpcCidr string, listeners []nlbListener) []nlbIngressRule {
+	var rules []nlbIngressRule
+	addRule := func(rule nlbIngressRule) {
+		for i := range rules {
+			if rules[i].name != rule.name {
+				continue
+			}
+			for _, cidr := range rule.cidrs {
+				if !slices.Contains(rules[i].cidrs, cidr) {
+					rules[i].cidrs = append(rules[i].cidrs, cidr)
+				}
+			}
+			return
+		}
+		rules = append(rules, rule)
+	}
+	for _, listener := range listeners {
+		for _, protocol := range listener.containerProtocols() {
+			ipProtocol := string(protocol)
+			cidrs := []string{vpcCidr}
+			if listener.preservesClientIp() {
+				cidrs = listener.AllowedCidrs
+				if protocol == ecsTypes.TransportProtocolTcp && !slices.Contains(cidrs, vpcCidr) {
+					//tcp health checks on the traffic port
+					cidrs = append(slices.Clone(cidrs), vpcCidr)
+				}
+			}
+			addRule(nlbIngressRule{
+				//sgnlb-<protocol>-<port>-<deploymentID>
+				name:     fmt.Sprintf("sgnlb-%s-%d-%s", ipProtocol, listener.ContainerPort, deploymentID),
+				protocol: ipProtocol,
+				port:     listener.ContainerPort,
+				cidrs:    cidrs,
+			})
+		}
+		if listener.HealthCheckPort != "traffic-port" {
+			healthCheckPort, _ := strconv.Atoi(listener.HealthCheckPort)
+			addRule(nlbIngressRule{
+				//sgnlb-hc-<port>-<deploymentID>
+				name:     fmt.Sprintf("sgnlb-hc-%d-%s", healthCheckPort, deploymentID),
+				protocol: string(ecsTypes.TransportProtocolTcp),
+				port:     int32(healthCheckPort),
+				cidrs:    []string{vpcCidr},
+			})
+		}
+	}
+	return rules
+}
+
+// getNlbIngressRulesOfDeployment lists the rules tagged with the deployment
+// on the default VPC security group.
+func getNlbIngressRulesOfDeployment(ec2Client *ec2.Client, defaultSecurityGroupId, deploymentID string) ([]ec2Types.SecurityGroupRule, error) {
+	paginator := ec2.NewDescribeSecurityGroupRulesPaginator(ec2Client, &ec2.DescribeSecurityGroupRulesInput{
+		DryRun: aws.Bool(false),
+		Filters: []ec2Types.Filter{
+			{
+				Name: aws.String("tag:deployment-id"),
+				Values: []string{
+					deploymentID,
+				},
+			},
+			{
+				Name: aws.String("group-id"),
+				Values: []string{
+					defaultSecurityGroupId,
+				},
+			},
+		},
+	})
+	var securityGroupRules []ec2Types.SecurityGroupRule
+	for paginator.HasMorePages() {
+		describeSecurityGroupRulesOutput, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		securityGroupRules = append(securityGroupRules, describeSecurityGroupRulesOutput.SecurityGroupRules...)
+	}
+	return securityGroupRules, nil
+}
+
+// getStaleNlbIngressRuleIds compares the deployment's existing rules with the
+// rules the listeners need. It returns the ids of rules for removed ports,
+// protocols and CIDRs, and the CIDRs each needed rule already allows.
+func getStaleNlbIngressRuleIds(rules []nlbIngressRule, existingRules []ec2Types.SecurityGroupRule) ([]string, map[string]map[string]bool) {
+	wantedCidrs := map[string][]string{}
+	for _, rule := range rules {
+		wantedCidrs[rule.name] = rule.cidrs
+	}
+	var staleRuleIds []string
+	existingCidrs := map[string]map[string]bool{}
+	for _, securityGroupRule := range existingRules {
+		if aws.ToBool(securityGroupRule.IsEgress) {
+			continue
+		}
+		var ruleName string
+		for _, tag := range securityGroupRule.Tags {
+			if aws.ToString(tag.Key) == "Name" {
+				ruleName = aws.ToString(tag.Value)
+			}
+		}
+		cidr := aws.ToString(securityGroupRule.CidrIpv4)
+		cidrs, ok := wantedCidrs[ruleName]
+		if !ok || !slices.Contains(cidrs, cidr) || existingCidrs[ruleName][cidr] {
+			staleRuleIds = append(staleRuleIds, aws.ToString(securityGroupRule.SecurityGroupRuleId))
+			continue
+		}
+		if existingCidrs[ruleName] == nil {
+			existingCidrs[ruleName] = map[string]bool{}
+		}
+		existingCidrs[ruleName][cidr] = true
+	}
+	return staleRuleIds, existingCidrs
+}
+
+// addNlbIngressRulesToDefaultVpcSecurityGroupIfNeeded opens the container
+// and health check ports on the default VPC security group the tasks run
+// in, and revokes the deployment's rules for ports and CIDRs that are no
+// longer configured. Rules are tagged with the deployment so the delete
+// command can revoke them.
+func addNlbIngressRulesToDefaultVpcSecurityGroupIfNeeded(parameters map[string]interface{}, ec2Client *ec2.Client,
+	listeners []nlbListener, logsWriter io.Writer) error {
+	vpcId, err := jobs.GetParameterValue[string](parameters, parameters_enums.VpcID)
+	if err != nil {
+		return err
+	}
+	vpcCidr, err := jobs.GetParameterValue[string](parameters, parameters_enums.VpcCidr)
+	if err != nil {
+		return err
+	}
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return err
+	}
+	defaultSecurityGroupId, err := getDefaultSecurityGroupIdForVpc(parameters, ec2Client, vpcId)
+	if err != nil {
+		return err
+	}
+	existingRules, err := getNlbIngressRulesOfDeployment(ec2Client, defaultSecurityGroupId, deploymentID)
+	if err != nil {
+		return err
+	}
+
+	rules := getNlbIngressRules(deploymentID, vpcCidr, listeners)
+	staleRuleIds, existingCidrs := getStaleNlbIngressRuleIds(rules, existingRules)
+	rulesChanged := false
+	if len(staleRuleIds) > 0 {
+		io.WriteString(logsWriter, fmt.Sprintf("Revoking security group rules: %v\n", staleRuleIds))
+		_, err = ec2Client.RevokeSecurityGroupIngress(context.TODO(), &ec2.RevokeSecurityGroupIngressInput{
+			DryRun:               aws.Bool(false),
+			GroupId:              aws.String(defaultSecurityGroupId),
+			SecurityGroupRuleIds: staleRuleIds,
+		})
+		if err != nil {
+			return err
+		}
+		rulesChanged = true
+	}
+	for _, rule := range rules {
+		var ipRanges []ec2Types.IpRange
+		for _, cidr := range rule.cidrs {
+			if existingCidrs[rule.name][cidr] {
+				continue
+			}
+			description := "from internet"
+			if cidr == vpcCidr {
+				description = fmt.Sprintf("VPC cidr - %s", vpcCidr)
+			} else if cidr != "0.0.0.0/0" {
+				description = "allowed clients"
+			}
+			ipRanges = append(ipRanges, ec2Types.IpRange{
+				CidrIp:      aws.String(cidr),
+				Description: aws.String(description),
+			})
+		}
+		if len(ipRanges) == 0 {
+			continue
+		}
+		_, err = ec2Client.AuthorizeSecurityGroupIngress(context.TODO(), &ec2.AuthorizeSecurityGroupIngressInput{
+			DryRun:  aws.Bool(false),
+			GroupId: aws.String(defaultSecurityGroupId),
+			IpPermissions: []ec2Types.IpPermission{{
+				FromPort:   aws.Int32(rule.port),
+				IpProtocol: aws.String(rule.protocol),
+				IpRanges:   ipRanges,
+				ToPort:     aws.Int32(rule.port),
+			}},
+			TagSpecifications: []ec2Types.TagSpecification{{
+				ResourceType: ec2Types.ResourceTypeSecurityGroupRule,
+				Tags: []ec2Types.Tag{
+					{
+						Key:   aws.String("Name"),
+						Value: aws.String(rule.name),
+					},
+					{
+						Key:   aws.String("created by"),
+						Value: aws.String("deployment.io"),
+					},
+					{
+						Key:   aws.String("vpc-default-security-group-id"),
+						Value: aws.String(defaultSecurityGroupId),
+					},
+					{
+						Key:   aws.String("deployment-id"),
+						Value: aws.String(deploymentID),
+					},
+				},
+			}},
+		})
+		if err != nil {
+			return err
+		}
+		rulesChanged = true
+	}
+	if !rulesChanged {
+		return nil
+	}
+	return reportDefaultSecurityGroupIngressRules(parameters, ec2Client, defaultSecurityGroupId)
+}
+
+// allocateNlbElasticIpsIfNeeded returns a subnet mapping with an Elastic IP
+// for every public subnet, reusing the addresses from earlier deployments.
+func allocateNlbElasticIpsIfNeeded(parameters map[string]interface{}, ec2Client *ec2.Client, logsWriter io.Writer) ([]elbTypes.SubnetMapping, []string, error) {
+	publicSubnets, err := jobs.GetParameterValue[primitive.A](parameters, parameters_enums.PublicSubnets)
+	if err != nil {
+		return nil, nil, err
+	}
+	publicSubnetsSlice, err := commandUtils.ConvertPrimitiveAToStringSlice(publicSubnets)
+	if err != nil {
+		return nil, nil, err
+	}
+	var subnetMappings []elbTypes.SubnetMapping
+	var staticIps []string
+	for _, subnetID := range publicSubnetsSlice {
+		elasticIpName, err := getNlbElasticIpName(parameters, subnetID)
+		if err != nil {
+			return nil, nil, err
+		}
+		describeAddressesOutput, err := ec2Client.DescribeAddresses(context.TODO(), &ec2.DescribeAddressesInput{
+			Filters: []ec2Types.Filter{
+				{
+					Name: aws.String("tag:Name"),
+					Values: []string{
+						elasticIpName,
+					},
+				},
+			},
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		var allocationID, publicIp string
+		if len(describeAddressesOutput.Addresses) > 0 {
+			allocationID = aws.ToString(describeAddressesOutput.Addresses[0].AllocationId)
+			publicIp = aws.ToString(describeAddressesOutput.Addresses[0].PublicIp)
+		} else {
+			allocateAddressOutput, err := ec2Client.AllocateAddress(context.TODO(), &ec2.AllocateAddressInput{
+				Domain: ec2Types.DomainTypeVpc,
+				TagSpecifications: []ec2Types.TagSpecification{{
+					ResourceType: ec2Types.ResourceTypeElasticIp,
+					Tags: []ec2Types.Tag{
+						{
+							Key:   aws.String("Name"),
+							Value: aws.String(elasticIpName),
+						},
+						{
+							Key:   aws.String("created by"),
+							Value: aws.String("deployment.io"),
+						},
+					},
+				}},
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+			allocationID = aws.ToString(allocateAddressOutput.AllocationId)
+			publicIp = aws.ToString(allocateAddressOutput.PublicIp)
+			io.WriteString(logsWriter, fmt.Sprintf("Allocated Elastic IP %s for subnet: %s\n", publicIp, subnetID))
+		}
+		subnetMappings = append(subnetMappings, elbTypes.SubnetMapping{
+			SubnetId:     aws.String(subnetID),
+			AllocationId: aws.String(allocationID),
+		})
+		staticIps = append(staticIps, publicIp)
+	}
+	return subnetMappings, staticIps, nil
+}
+
+// nlbTargetGroup is a target group from an earlier deployment with the
+// listener it was created for, empty for target groups created before they
+// were tagged with it.
+type nlbTargetGroup struct {
+	listenerKey string
+	protocol    elbTypes.ProtocolEnum
+	port        int32
+}
+
+// matchNlbTargetGroups picks the target group index of every listener. A
+// listener keeps the target group created for it, untagged target groups go
+// to a listener with the same protocol and container port, preferably the
+// one at their index, and the rest get a free index. It also returns the
+// indexes of target groups no listener uses anymore.
+func matchNlbTargetGroups(listeners []nlbListener, existing map[int]nlbTargetGroup) ([]int, []int, error) {
+	indexes := make([]int, len(listeners))
+	used := map[int]bool{}
+	for i, listener := range listeners {
+		indexes[i] = -1
+		for index, targetGroup := range existing {
+			if targetGroup.listenerKey == listener.key() {
+				indexes[i] = index
+				used[index] = true
+				break
+			}
+		}
+	}
+	matchesUntagged := func(index int, listener nlbListener) bool {
+		targetGroup, ok := existing[index]
+		return ok && !used[index] && len(targetGroup.listenerKey) == 0 &&
+			targetGroup.protocol == listener.targetGroupProtocol() && targetGroup.port == listener.ContainerPort
+	}
+	for i, listener := range listeners {
+		if indexes[i] != -1 {
+			continue
+		}
+		if matchesUntagged(i, listener) {
+			indexes[i] = i
+			used[i] = true
+			continue
+		}
+		for index := 0; index < maxNlbListeners; index++ {
+			if matchesUntagged(index, listener) {
+				indexes[i] = index
+				used[index] = true
+				break
+			}
+		}
+	}
+	for i, listener := range listeners {
+		if indexes[i] != -1 {
+			continue
+		}
+		for index := 0; index < maxNlbListeners; index++ {
+			if _, ok := existing[index]; !ok && !used[index] {
+				indexes[i] = index
+				used[index] = true
+				break
+			}
+		}
+		if indexes[i] == -1 {
+			return nil, nil, fmt.Errorf("no free target group for nlb listener %s, the target groups of removed listeners are still in use", listener.key())
+		}
+	}
+	var staleIndexes []int
+	for index := 0; index < maxNlbListeners; index++ {
+		if _, ok := existing[index]; ok && !used[index] {
+			staleIndexes = append(staleIndexes, index)
+		}
+	}
+	return indexes, staleIndexes, nil
+}
+
+// getExistingNlbTargetGroups describes the deployment's target groups by
+// index and reads the listener each one was created for from its
+// nlb-listener tag.
+func getExistingNlbTargetGroups(parameters map[string]interface{}, elbClient *elasticloadbalancingv2.Client) (map[int]elbTypes.TargetGroup, map[int]nlbTargetGroup, error) {
+	targetGroups := map[int]elbTypes.TargetGroup{}
+	indexByArn := map[string]int{}
+	var targetGroupArns []string
+	for i := 0; i < maxNlbListeners; i++ {
+		targetGroupName, err := getNlbTargetGroupName(parameters, i)
+		if err != nil {
+			return nil, nil, err
+		}
+		describeTargetGroupsOutput, err := elbClient.DescribeTargetGroups(context.TODO(), &elasticloadbalancingv2.DescribeTargetGroupsInput{
+			Names: []string{
+				targetGroupName,
+			},
+		})
+		var targetGroupNotFoundException *elbTypes.TargetGroupNotFoundException
+		if errors.As(err, &targetGroupNotFoundException) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(describeTargetGroupsOutput.TargetGroups) == 0 {
+			continue
+		}
+		targetGroup := describeTargetGroupsOutput.TargetGroups[0]
+		targetGroups[i] = targetGroup
+		indexByArn[aws.ToString(targetGroup.TargetGroupArn)] = i
+		targetGroupArns = append(targetGroupArns, aws.ToString(targetGroup.TargetGroupArn))
+	}
+	existing := map[int]nlbTargetGroup{}
+	for i, targetGroup := range targetGroups {
+		existing[i] = nlbTargetGroup{
+			protocol: targetGroup.Protocol,
+			port:     aws.ToInt32(targetGroup.Port),
+		}
+	}
+	if len(targetGroupArns) == 0 {
+		return targetGroups, existing, nil
+	}
+	describeTagsOutput, err := elbClient.DescribeTags(context.TODO(), &elasticloadbalancingv2.DescribeTagsInput{
+		ResourceArns: targetGroupArns,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, tagDescription := range describeTagsOutput.TagDescriptions {
+		i := indexByArn[aws.ToString(tagDescription.ResourceArn)]
+		for _, tag := range tagDescription.Tags {
+			if aws.ToString(tag.Key) == "nlb-listener" {
+				targetGroup := existing[i]
+				targetGroup.listenerKey = aws.ToString(tag.Value)
+				existing[i] = targetGroup
+			}
+		}
+	}
+	return targetGroups, existing, nil
+}
+
+// syncNlbTargetGroups returns a target group for every listener, in the
+// order of the listeners, and the target groups of removed listeners.
+func syncNlbTargetGroups(parameters map[string]interface{}, elbClient *elasticloadbalancingv2.Client,
+	listeners []nlbListener) (targetGroupArns []string, staleTargetGroupArns []string, err error) {
+	targetGroups, existing, err := getExistingNlbTargetGroups(parameters, elbClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	indexes, staleIndexes, err := matchNlbTargetGroups(listeners, existing)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, listener := range listeners {
+		var targetGroupArn string
+		if targetGroup, ok := targetGroups[indexes[i]]; ok {
+			targetGroupArn, err = updateNlbTargetGroup(elbClient, targetGroup, existing[indexes[i]], listener)
+		} else {
+			targetGroupArn, err = createNlbTargetGroup(parameters, elbClient, indexes[i], listener)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		targetGroupArns = append(targetGroupArns, targetGroupArn)
+	}
+	for _, index := range staleIndexes {
+		staleTargetGroupArns = append(staleTargetGroupArns, aws.ToString(targetGroups[index].TargetGroupArn))
+	}
+	return targetGroupArns, staleTargetGroupArns, nil
+}
+
+func updateNlbTargetGroup(elbClient *elasticloadbalancingv2.Client, targetGroup elbTypes.TargetGroup,
+	existing nlbTargetGroup, listener nlbListener) (string, error) {
+	if existing.protocol != listener.targetGroupProtocol() || existing.port != listener.ContainerPort {
+		return "", fmt.Errorf("nlb listener %s changed its target from %s/%d to %s/%d, the service has to be recreated",
+			listener.key(), existing.protocol, existing.port, listener.targetGroupProtocol(), listener.ContainerPort)
+	}
+	//health check settings might have changed since the last deployment
+	_, err := elbClient.ModifyTargetGroup(context.TODO(), &elasticloadbalancingv2.ModifyTargetGroupInput{
+		TargetGroupArn:      targetGroup.TargetGroupArn,
+		HealthCheckEnabled:  aws.Bool(true),
+		HealthCheckProtocol: elbTypes.ProtocolEnum(listener.HealthCheckProtocol),
+		HealthCheckPort:     aws.String(listener.HealthCheckPort),
+		HealthCheckPath:     listener.healthCheckPath(),
+	})
+	if err != nil {
+		return "", err
+	}
+	if existing.listenerKey != listener.key() {
+		//target groups from before they were tagged with their listener
+		_, err = elbClient.AddTags(context.TODO(), &elasticloadbalancingv2.AddTagsInput{
+			ResourceArns: []string{aws.ToString(targetGroup.TargetGroupArn)},
+			Tags: []elbTypes.Tag{
+				{
+					Key:   aws.String("nlb-listener"),
+					Value: aws.String(listener.key()),
+				},
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return aws.ToString(targetGroup.TargetGroupArn), nil
+}
+
+func createNlbTargetGroup(parameters map[string]interface{}, elbClient *elasticloadbalancingv2.Client,
+	index int, listener nlbListener) (string, error) {
+	targetGroupName, err := getNlbTargetGroupName(parameters, index)
+	if err != nil {
+		return "", err
+	}
+	vpcId, err := jobs.GetParameterValue[string](parameters, parameters_enums.VpcID)
+	if err != nil {
+		return "", err
+	}
+	createTargetGroupOutput, err := elbClient.CreateTargetGroup(context.TODO(), &elasticloadbalancingv2.CreateTargetGroupInput{
+		Name:                aws.String(targetGroupName),
+		HealthCheckEnabled:  aws.Bool(true),
+		HealthCheckProtocol: elbTypes.ProtocolEnum(listener.HealthCheckProtocol),
+		HealthCheckPort:     aws.String(listener.HealthCheckPort),
+		HealthCheckPath:     listener.healthCheckPath(),
+		Port:                aws.Int32(listener.ContainerPort),
+		Protocol:            listener.targetGroupProtocol(),
+		Tags: []elbTypes.Tag{
+			{
+				Key:   aws.String("Name"),
+				Value: aws.String(targetGroupName),
+			},
+			{
+				Key:   aws.String("created by"),
+				Value: aws.String("deployment.io"),
+			},
+			{
+				Key:   aws.String("nlb-listener"),
+				Value: aws.String(listener.key()),
+			},
+		},
+		TargetType: elbTypes.TargetTypeEnumIp,
+		VpcId:      aws.String(vpcId),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(createTargetGroupOutput.TargetGroups[0].TargetGroupArn), nil
+}
+
+// deleteStaleNlbTargetGroups deletes the target groups of removed listeners
+// once the service no longer uses them. A target group that can't be
+// deleted yet is left for the next deployment or the delete command.
+func deleteStaleNlbTargetGroups(elbClient *elasticloadbalancingv2.Client, staleTargetGroupArns []string, logsWriter io.Writer) {
+	for _, targetGroupArn := range staleTargetGroupArns {
+		io.WriteString(logsWriter, fmt.Sprintf("Deleting target group: %s\n", targetGroupArn))
+		_, err := elbClient.DeleteTargetGroup(context.TODO(), &elasticloadbalancingv2.DeleteTargetGroupInput{
+			TargetGroupArn: aws.String(targetGroupArn),
+		})
+		if err != nil {
+			io.WriteString(logsWriter, fmt.Sprintf("Could not delete target group %s yet: %s\n", targetGroupArn, err))
+		}
+	}
+}
+
+func createNlbIfNeeded(parameters map[string]interface{}, elbClient *elasticloadbalancingv2.Client,
+	subnetMappings []elbTypes.SubnetMapping, logsWriter io.Writer) (loadBalancerArn string, loadBalancerDns string, err error) {
+	nlbName, err := getNlbName(parameters)
+	if err != nil {
+		return "", "", err
+	}
+	describeLoadBalancersOutput, _ := elbClient.DescribeLoadBalancers(context.TODO(), &elasticloadbalancingv2.DescribeLoadBalancersInput{
+		Names: []string{
+			nlbName,
+		},
+	})
+	if describeLoadBalancersOutput != nil && len(describeLoadBalancersOutput.LoadBalancers) > 0 {
+		return aws.ToString(describeLoadBalancersOutput.LoadBalancers[0].LoadBalancerArn),
+			aws.ToString(describeLoadBalancersOutput.LoadBalancers[0].DNSName), nil
+	}
+	createLoadBalancerOutput, err := elbClient.CreateLoadBalancer(context.TODO(), &elasticloadbalancingv2.CreateLoadBalancerInput{
+		Name:           aws.String(nlbName),
+		IpAddressType:  elbTypes.IpAddressTypeIpv4,
+		Scheme:         elbTypes.LoadBalancerSchemeEnumInternetFacing,
+		SubnetMappings: subnetMappings,
+		Tags: []elbTypes.Tag{
+			{
+				Key:   aws.String("Name"),
+				Value: aws.String(nlbName),
+			},
+			{
+				Key:   aws.String("created by"),
+				Value: aws.String("deployment.io"),
+			},
+		},
+		Type: elbTypes.LoadBalancerTypeEnumNetwork,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	loadBalancerArn = aws.ToString(createLoadBalancerOutput.LoadBalancers[0].LoadBalancerArn)
+	io.WriteString(logsWriter, fmt.Sprintf("Waiting for load balancer to be available: %s\n", loadBalancerArn))
+	newLoadBalancerAvailableWaiter := elasticloadbalancingv2.NewLoadBalancerAvailableWaiter(elbClient)
+	err = newLoadBalancerAvailableWaiter.Wait(context.TODO(), &elasticloadbalancingv2.DescribeLoadBalancersInput{
+		LoadBalancerArns: []string{loadBalancerArn},
+	}, time.Minute*10)
+	if err != nil {
+		return "", "", err
+	}
+	return loadBalancerArn, aws.ToString(createLoadBalancerOutput.LoadBalancers[0].DNSName), nil
+}
+
+// syncNlbListeners creates missing listeners, points existing ones at the
+// right target group and certificate and removes listeners that are no
+// longer configured.
+func syncNlbListeners(parameters map[string]interface{}, elbClient *elasticloadbalancingv2.Client, loadBalancerArn string,
+	listeners []nlbListener, targetGroupArns []string, logsWriter io.Writer) error {
+	describeListenersOutput, err := elbClient.DescribeListeners(context.TODO(), &elasticloadbalancingv2.DescribeListenersInput{
+		LoadBalancerArn: aws.String(loadBalancerArn),
+	})
+	if err != nil {
+		return err
+	}
+	existingListeners := map[string]elbTypes.Listener{}
+	for _, listener := range describeListenersOutput.Listeners {
+		existingListeners[fmt.Sprintf("%s/%d", listener.Protocol, aws.ToInt32(listener.Port))] = listener
+	}
+	for i, listener := range listeners {
+		defaultActions := []elbTypes.Action{{
+			Type:           elbTypes.ActionTypeEnumForward,
+			TargetGroupArn: aws.String(targetGroupArns[i]),
+		}}
+		var certificates []elbTypes.Certificate
+		var sslPolicy *string
+		if listener.Protocol == "TLS" {
+			certificates = []elbTypes.Certificate{{CertificateArn: aws.String(listener.CertificateArn)}}
+			sslPolicy = aws.String("ELBSecurityPolicy-TLS13-1-2-2021-06")
+		}
+		key := listener.key()
+		if existingListener, ok := existingListeners[key]; ok {
+			delete(existingListeners, key)
+			_, err = elbClient.ModifyListener(context.TODO(), &elasticloadbalancingv2.ModifyListenerInput{
+				ListenerArn:    existingListener.ListenerArn,
+				DefaultActions: defaultActions,
+				Certificates:   certificates,
+				SslPolicy:      sslPolicy,
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		albListenerName, err := getAlbListenerName(parameters, listener.Port)
+		if err != nil {
+			return err
+		}
+		io.WriteString(logsWriter, fmt.Sprintf("Creating %s listener on port %d\n", listener.Protocol, listener.Port))
+		_, err = elbClient.CreateListener(context.TODO(), &elasticloadbalancingv2.CreateListenerInput{
+			DefaultActions:  defaultActions,
+			LoadBalancerArn: aws.String(loadBalancerArn),
+			Certificates:    certificates,
+			SslPolicy:       sslPolicy,
+			Port:            aws.Int32(listener.Port),
+			Protocol:        elbTypes.ProtocolEnum(listener.Protocol),
+			Tags: []elbTypes.Tag{
+				{
+					Key:   aws.String("Name"),
+					Value: aws.String(albListenerName),
+				},
+				{
+					Key:   aws.String("created by"),
+					Value: aws.String("deployment.io"),
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for key, staleListener := range existingListeners {
+		io.WriteString(logsWriter, fmt.Sprintf("Deleting listener %s: %s\n", key, aws.ToString(staleListener.ListenerArn)))
+		_, err = elbClient.DeleteListener(context.TODO(), &elasticloadbalancingv2.DeleteListenerInput{
+			ListenerArn: staleListener.ListenerArn,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DeployAwsNlbService) Run(parameters map[string]interface{}, logsWriter io.Writer) (newParameters map[string]interface{}, err error) {
+	defer func() {
+		if err != nil {
+			<-MarkDeploymentDone(parameters, err)
+		}
+	}()
+
+	//check and add policy for AWS nlb service deployment
+	runnerData := utils.RunnerData.Get()
+	organizationID, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIDNamespace)
+	if err != nil {
+		return parameters, err
+	}
+	err = iam_policies.AddAwsPolicyForDeploymentRunner(iam_policy_enums.AwsNlbServiceDeployment,
+		runnerData.OsType.String(), runnerData.CpuArchEnum.String(), organizationID, runnerData.RunnerRegion, runnerData.Mode, runnerData.TargetCloud)
+	if err != nil {
+		return parameters, err
+	}
+
+	listeners, err := getNlbListeners(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	if len(listeners) == 0 {
+		return parameters, fmt.Errorf("nlb listeners are required for an nlb service")
+	}
+
+	ec2Client, err := cloud_api_clients.GetEC2Client(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	err = addNlbIngressRulesToDefaultVpcSecurityGroupIfNeeded(parameters, ec2Client, listeners, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	subnetMappings, staticIps, err := allocateNlbElasticIpsIfNeeded(parameters, ec2Client, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+
+	elbClient, err := cloud_api_clients.GetElbClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	containerName, err := getContainerName(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	targetGroupArns, staleTargetGroupArns, err := syncNlbTargetGroups(parameters, elbClient, listeners)
+	if err != nil {
+		return parameters, err
+	}
+	var loadBalancers []ecsTypes.LoadBalancer
+	for i, listener := range listeners {
+		loadBalancers = append(loadBalancers, ecsTypes.LoadBalancer{
+			ContainerName:  aws.String(containerName),
+			ContainerPort:  aws.Int32(listener.ContainerPort),
+			TargetGroupArn: aws.String(targetGroupArns[i]),
+		})
+	}
+	loadBalancerArn, loadBalancerDns, err := createNlbIfNeeded(parameters, elbClient, subnetMappings, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	err = syncNlbListeners(parameters, elbClient, loadBalancerArn, listeners, targetGroupArns, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+
+	ecsClient, err := cloud_api_clients.GetEcsClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	taskDefinitionArn, err := registerTaskDefinition(parameters, ecsClient, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	ecsClusterArn, err := jobs.GetParameterValue[string](parameters, parameters_enums.EcsClusterArn)
+	if err != nil {
+		return parameters, err
+	}
+	_, shouldUpdateService, err := createEcsServiceIfNeeded(parameters, ecsClient, ecsClusterArn, loadBalancers, taskDefinitionArn, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	if shouldUpdateService {
+		err = updateEcsService(parameters, ecsClient, ecsClusterArn, loadBalancers, taskDefinitionArn, logsWriter)
+		if err != nil {
+			return parameters, err
+		}
+	}
+	deleteStaleNlbTargetGroups(elbClient, staleTargetGroupArns, logsWriter)
+
+	io.WriteString(logsWriter, fmt.Sprintf("Network load balancer %s is reachable on %v\n", loadBalancerDns, staticIps))
+
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return parameters, err
+	}
+	buildID, err := jobs.GetParameterValue[string](parameters, parameters_enums.BuildID)
+	if err != nil {
+		return parameters, err
+	}
+	var organizationIdFromJob string
+	organizationIdFromJob, err = jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIdFromJob)
+	if err != nil {
+		return parameters, err
+	}
+	if !isPreview(parameters) {
+		commandUtils.UpdateDeploymentsPipeline.Add(organizationIdFromJob, deployments.UpdateDeploymentDtoV1{
+			ID:              deploymentID,
+			TargetGroupArn:  targetGroupArns[0],
+			LoadBalancerArn: loadBalancerArn,
+			LoadBalancerDns: loadBalancerDns,
+			StaticIps:       staticIps,
+		})
+		commandUtils.UpdateBuildsPipeline.Add(organizationIdFromJob, builds.UpdateBuildDtoV1{
+			ID:                buildID,
+			TaskDefinitionArn: taskDefinitionArn,
+		})
+	} else {
+		previewID := deploymentID
+		commandUtils.UpdatePreviewsPipeline.Add(organizationIdFromJob, previews.UpdatePreviewDtoV1{
+			ID:                previewID,
+			TargetGroupArn:    targetGroupArns[0],
+			LoadBalancerArn:   loadBalancerArn,
+			LoadBalancerDns:   loadBalancerDns,
+			StaticIps:         staticIps,
+			TaskDefinitionArn: taskDefinitionArn,
+		})
+	}
+
+	//mark build done successfully
+	<-MarkDeploymentDone(parameters, nil)
+
+	return parameters, nil
+}
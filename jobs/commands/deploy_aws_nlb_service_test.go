@@ -0,0 +1,148 @@
+package commands
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+)
+
+// TestParseNlbListeners_Defaults fills in the protocol, container port,
+// health check port and the deployment's certificate for TLS.
+func TestParseNlbListeners_Defaults(t *testing.T) {
+	listeners, err := parseNlbListeners([]byte(`[{"port": 1883}, {"port": 8883, "protocol": "TLS"},
+		{"port": 27015, "protocol": "TCP_UDP", "container_port": 27015}]`), 1883, "arn:cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 3 {
+		t.Fatalf("listeners = %+v", listeners)
+	}
+	if listeners[0].Protocol != "TCP" || listeners[0].ContainerPort != 1883 || listeners[0].HealthCheckPort != "traffic-port" {
+		t.Errorf("listener 0 = %+v", listeners[0])
+	}
+	if listeners[1].CertificateArn != "arn:cert" || listeners[1].targetGroupProtocol() != elbTypes.ProtocolEnumTcp {
+		t.Errorf("TLS listener = %+v, want the default certificate and a TCP target group", listeners[1])
+	}
+	if got := listeners[2].containerProtocols(); len(got) != 2 || got[0] != ecsTypes.TransportProtocolTcp || got[1] != ecsTypes.TransportProtocolUdp {
+		t.Errorf("TCP_UDP container protocols = %v", got)
+	}
+}
+
+func TestParseNlbListeners_Invalid(t *testing.T) {
+	for _, listenersJSON := range []string{
+		`[]`,
+		`[{"port": 0}]`,
+		`[{"port": 53, "protocol": "ICMP"}]`,
+		`[{"port": 443, "protocol": "TLS"}]`,
+		`[{"port": 53, "protocol": "UDP", "health_check_port": "8080"}, {"port": 53, "protocol": "TCP_UDP"}]`,
+		`[{"port": 53, "protocol": "UDP"}]`,
+		`[{"port": 53, "protocol": "UDP", "health_check_port": "dns"}]`,
+		`[{"port": 53, "protocol": "UDP", "health_check_protocol": "UDP"}]`,
+		`[{"port": 53, "protocol": "TCP_UDP", "allowed_cidrs": ["2001:db8::/32"]}]`,
+		`[{"port": 1883, "allowed_cidrs": ["203.0.113.0/24"]}]`,
+		`[{"port": 1}, {"port": 2}, {"port": 3}, {"port": 4}, {"port": 5}, {"port": 6}]`,
+	} {
+		if _, err := parseNlbListeners([]byte(listenersJSON), 8080, ""); err == nil {
+			t.Errorf("expected an error for %s", listenersJSON)
+		}
+	}
+}
+
+// TestGetNlbIngressRules opens ports that keep the client's address to the
+// allowed clients, the rest and health checks to the VPC.
+func TestGetNlbIngressRules(t *testing.T) {
+	listeners, err := parseNlbListeners([]byte(`[{"port": 1883}, {"port": 8883, "protocol": "TCP_UDP"},
+		{"port": 27015, "protocol": "TCP_UDP", "container_port": 27015, "allowed_cidrs": ["203.0.113.7/24"]},
+		{"port": 53, "protocol": "UDP", "container_port": 5353, "health_check_port": "8080"}]`), 1883, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := getNlbIngressRules("d1", "10.0.0.0/16", listeners)
+	want := map[string][]string{
+		"sgnlb-tcp-1883-d1":  {"10.0.0.0/16", "0.0.0.0/0"},
+		"sgnlb-udp-1883-d1":  {"0.0.0.0/0"},
+		"sgnlb-tcp-27015-d1": {"203.0.113.0/24", "10.0.0.0/16"},
+		"sgnlb-udp-27015-d1": {"203.0.113.0/24"},
+		"sgnlb-udp-5353-d1":  {"0.0.0.0/0"},
+		"sgnlb-hc-8080-d1":   {"10.0.0.0/16"},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("rules = %+v", rules)
+	}
+	for _, rule := range rules {
+		if !reflect.DeepEqual(rule.cidrs, want[rule.name]) {
+			t.Errorf("%s cidrs = %v, want %v", rule.name, rule.cidrs, want[rule.name])
+		}
+	}
+	if listeners[3].HealthCheckProtocol != "TCP" || listeners[3].healthCheckPath() != nil {
+		t.Errorf("UDP listener health check = %s %v", listeners[3].HealthCheckProtocol, listeners[3].healthCheckPath())
+	}
+}
+
+// TestGetStaleNlbIngressRuleIds revokes rules of removed ports and CIDRs
+// and duplicates, and keeps the CIDRs that are still allowed.
+func TestGetStaleNlbIngressRuleIds(t *testing.T) {
+	rules := []nlbIngressRule{
+		{name: "sgnlb-tcp-1883-d1", cidrs: []string{"10.0.0.0/16"}},
+		{name: "sgnlb-udp-5353-d1", cidrs: []string{"203.0.113.0/24"}},
+	}
+	securityGroupRule := func(id, name, cidr string) ec2Types.SecurityGroupRule {
+		return ec2Types.SecurityGroupRule{
+			SecurityGroupRuleId: aws.String(id),
+			CidrIpv4:            aws.String(cidr),
+			IsEgress:            aws.Bool(false),
+			Tags:                []ec2Types.Tag{{Key: aws.String("Name"), Value: aws.String(name)}},
+		}
+	}
+	staleRuleIds, existingCidrs := getStaleNlbIngressRuleIds(rules, []ec2Types.SecurityGroupRule{
+		securityGroupRule("sgr-1", "sgnlb-tcp-1883-d1", "10.0.0.0/16"),
+		securityGroupRule("sgr-2", "sgnlb-tcp-1883-d1", "10.0.0.0/16"),
+		securityGroupRule("sgr-3", "sgnlb-udp-5353-d1", "0.0.0.0/0"),
+		securityGroupRule("sgr-4", "sgnlb-tcp-8883-d1", "10.0.0.0/16"),
+		securityGroupRule("sgr-5", "sgnlb-hc-8080-d1", "10.0.0.0/16"),
+	})
+	if want := []string{"sgr-2", "sgr-3", "sgr-4", "sgr-5"}; !reflect.DeepEqual(staleRuleIds, want) {
+		t.Errorf("stale rules = %v, want %v", staleRuleIds, want)
+	}
+	if !existingCidrs["sgnlb-tcp-1883-d1"]["10.0.0.0/16"] || existingCidrs["sgnlb-udp-5353-d1"]["203.0.113.0/24"] {
+		t.Errorf("existing cidrs = %v", existingCidrs)
+	}
+}
+
+// TestMatchNlbTargetGroups keeps every listener on its own target group
+// when listeners are removed or reordered.
+func TestMatchNlbTargetGroups(t *testing.T) {
+	listeners, err := parseNlbListeners([]byte(`[{"port": 8883, "protocol": "TLS", "certificate_arn": "arn:cert"},
+		{"port": 53, "protocol": "UDP", "container_port": 5353, "health_check_port": "8080"}, {"port": 1884}]`), 1883, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing := map[int]nlbTargetGroup{
+		0: {listenerKey: "TCP/1883", protocol: elbTypes.ProtocolEnumTcp, port: 1883},
+		1: {listenerKey: "TLS/8883", protocol: elbTypes.ProtocolEnumTcp, port: 1883},
+		//untagged, from before target groups were tagged with their listener
+		2: {protocol: elbTypes.ProtocolEnumUdp, port: 5353},
+	}
+	indexes, staleIndexes, err := matchNlbTargetGroups(listeners, existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(indexes, want) {
+		t.Errorf("indexes = %v, want %v", indexes, want)
+	}
+	if want := []int{0}; !reflect.DeepEqual(staleIndexes, want) {
+		t.Errorf("stale indexes = %v, want %v", staleIndexes, want)
+	}
+
+	for i := 0; i < maxNlbListeners; i++ {
+		existing[i] = nlbTargetGroup{listenerKey: fmt.Sprintf("TCP/%d", 2000+i), protocol: elbTypes.ProtocolEnumTcp, port: 1883}
+	}
+	if _, _, err = matchNlbTargetGroups(listeners, existing); err == nil {
+		t.Error("expected an error without a free target group")
+	}
+}
@@ -48,7 +48,7 @@ func (d *DeployAwsPrivateService) Run(parameters map[string]interface{}, logsWri
 	if err != nil {
 		return parameters, err
 	}
-	taskDefinitionArn, err := registerTaskDefinition(parameters, ecsClient)
+	taskDefinitionArn, err := registerTaskDefinition(parameters, ecsClient, logsWriter)
 	if err != nil {
 		return parameters, err
 	}
@@ -56,12 +56,12 @@ func (d *DeployAwsPrivateService) Run(parameters map[string]interface{}, logsWri
 	if err != nil {
 		return parameters, err
 	}
-	_, shouldUpdateService, err := createEcsServiceIfNeeded(parameters, ecsClient, ecsClusterArn, "", taskDefinitionArn, logsWriter)
+	_, shouldUpdateService, err := createEcsServiceIfNeeded(parameters, ecsClient, ecsClusterArn, nil, taskDefinitionArn, logsWriter)
 	if err != nil {
 		return parameters, err
 	}
 	if shouldUpdateService {
-		err = updateEcsService(parameters, ecsClient, ecsClusterArn, taskDefinitionArn, logsWriter)
+		err = updateEcsService(parameters, ecsClient, ecsClusterArn, nil, taskDefinitionArn, logsWriter)
 		if err != nil {
 			return parameters, err
 		}
@@ -218,6 +218,23 @@ func (d *DeployAwsRdsDatabase) Run(parameters map[string]interface{}, logsWriter
 		return parameters, err
 	}
 
+	databaseType, err := getRdsDatabaseType(parameters, rdsClient)
+	if err != nil {
+		return parameters, err
+	}
+	if databaseType == rdsDatabaseTypeAuroraServerless {
+		var settings *rdsSettings
+		settings, err = getRdsSettings(parameters)
+		if err != nil {
+			return parameters, err
+		}
+		err = deployAwsAuroraServerless(parameters, rdsClient, engine.String(), settings, logsWriter)
+		if err != nil {
+			return parameters, err
+		}
+		return parameters, nil
+	}
+
 	rdsInstanceIdentifier, err := getRdsDBInstanceIdentifier(parameters)
 	if err != nil {
 		return parameters, err
@@ -242,6 +259,15 @@ func (d *DeployAwsRdsDatabase) Run(parameters map[string]interface{}, logsWriter
 
 	useDeletionProtection, _ := jobs.GetParameterValue[bool](parameters, parameters_enums.UseDeletionProtection)
 
+	settings, err := getRdsSettings(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	enablePerformanceInsights := engine.EnablePerformanceInsights()
+	if settings.PerformanceInsights != nil {
+		enablePerformanceInsights = *settings.PerformanceInsights
+	}
+
 	dbInstancesOutput, _ := rdsClient.DescribeDBInstances(context.TODO(), &rds.DescribeDBInstancesInput{
 		DBInstanceIdentifier: aws.String(rdsInstanceIdentifier),
 	})
@@ -257,16 +283,73 @@ func (d *DeployAwsRdsDatabase) Run(parameters map[string]interface{}, logsWriter
 				return parameters, err
 			}
 		}
+		currentEngineVersion := aws.ToString(dbInstancesOutput.DBInstances[0].EngineVersion)
+		targetEngineVersion := currentEngineVersion
+		majorVersionUpgrade := false
+		if len(settings.EngineVersion) > 0 && settings.EngineVersion != currentEngineVersion {
+			currentDBEngineVersion, err := getRdsEngineVersion(rdsClient, engine.String(), currentEngineVersion)
+			if err != nil {
+				return parameters, err
+			}
+			majorVersionUpgrade, err = isMajorVersionUpgrade(currentDBEngineVersion, settings.EngineVersion)
+			if err != nil {
+				return parameters, err
+			}
+			if majorVersionUpgrade && !settings.AllowMajorVersionUpgrade {
+				err = fmt.Errorf("upgrading from %s to %s is a major version upgrade, allow it to proceed", currentEngineVersion,
+					settings.EngineVersion)
+				return parameters, err
+			}
+			targetEngineVersion = settings.EngineVersion
+			io.WriteString(logsWriter, fmt.Sprintf("Upgrading RDS engine version from %s to %s\n", currentEngineVersion, targetEngineVersion))
+		}
+		if majorVersionUpgrade {
+			//always keep a way back from a major version upgrade
+			_, err = createRdsSnapshot(parameters, rdsClient, rdsInstanceIdentifier, rdsSnapshotPreUpgrade, logsWriter)
+			if err == nil {
+				err = pruneRdsSnapshots(parameters, rdsClient, rdsInstanceIdentifier, rdsSnapshotPreUpgrade, logsWriter)
+			}
+		} else {
+			err = snapshotRdsBeforeChange(parameters, rdsClient, rdsInstanceIdentifier, rdsSnapshotPreModify, logsWriter)
+			if err == nil {
+				err = pruneRdsSnapshots(parameters, rdsClient, rdsInstanceIdentifier, rdsSnapshotPreModify, logsWriter)
+			}
+		}
+		if err != nil {
+			return parameters, err
+		}
+		targetDBEngineVersion, err := getRdsEngineVersion(rdsClient, engine.String(), targetEngineVersion)
+		if err != nil {
+			return parameters, err
+		}
+		dbParameterGroupName, err := syncDBParameterGroupIfNeeded(parameters, rdsClient, settings,
+			aws.ToString(targetDBEngineVersion.DBParameterGroupFamily), logsWriter)
+		if err != nil {
+			return parameters, err
+		}
 		//modify
 		var modifyDBInstanceOutput *rds.ModifyDBInstanceOutput
 		modifyDBInstanceInput := &rds.ModifyDBInstanceInput{
-			DBInstanceIdentifier: aws.String(rdsInstanceIdentifier),
-			AllocatedStorage:     aws.Int32(int32(allocatedStorage)),
-			ApplyImmediately:     aws.Bool(applyRdsChangesImmediately),
-			DBInstanceClass:      aws.String(rdsInstance.Instance()),
-			MaxAllocatedStorage:  aws.Int32(int32(maxAllocatedStorage)),
-			DeletionProtection:   aws.Bool(useDeletionProtection),
+			DBInstanceIdentifier:      aws.String(rdsInstanceIdentifier),
+			AllocatedStorage:          aws.Int32(int32(allocatedStorage)),
+			ApplyImmediately:          aws.Bool(applyRdsChangesImmediately),
+			DBInstanceClass:           aws.String(rdsInstance.Instance()),
+			MaxAllocatedStorage:       aws.Int32(int32(maxAllocatedStorage)),
+			DeletionProtection:        aws.Bool(useDeletionProtection),
+			EnablePerformanceInsights: aws.Bool(enablePerformanceInsights),
+			BackupRetentionPeriod:     settings.BackupRetentionDays,
 		}
+		if targetEngineVersion != currentEngineVersion {
+			modifyDBInstanceInput.EngineVersion = aws.String(targetEngineVersion)
+			modifyDBInstanceInput.AllowMajorVersionUpgrade = aws.Bool(majorVersionUpgrade)
+		}
+		if len(settings.BackupWindow) > 0 {
+			modifyDBInstanceInput.PreferredBackupWindow = aws.String(settings.BackupWindow)
+		}
+		if len(settings.MaintenanceWindow) > 0 {
+			modifyDBInstanceInput.PreferredMaintenanceWindow = aws.String(settings.MaintenanceWindow)
+		}
+		modifyDBInstanceInput.DBParameterGroupName = aws.String(dbParameterGroupName)
 		if len(userPassword) > 0 {
 			modifyDBInstanceInput.MasterUserPassword = aws.String(userPassword)
 		}
@@ -288,6 +371,11 @@ func (d *DeployAwsRdsDatabase) Run(parameters map[string]interface{}, logsWriter
 				if err != nil {
 					return parameters, err
 				}
+				err = syncRdsReadReplicas(parameters, rdsClient, rdsInstanceIdentifier, settings.ReadReplicas, dbParameterGroupName,
+					logsWriter)
+				if err != nil {
+					return parameters, err
+				}
 			} else {
 				err = fmt.Errorf("RDS DB Instance %s is not available", rdsInstanceIdentifier)
 				return parameters, err
@@ -318,11 +406,21 @@ func (d *DeployAwsRdsDatabase) Run(parameters map[string]interface{}, logsWriter
 	}
 	masterUserName = "p" + masterUserName
 
-	createDBInstanceOutput, err := rdsClient.CreateDBInstance(context.TODO(), &rds.CreateDBInstanceInput{
+	dbEngineVersion, err := getRdsEngineVersion(rdsClient, engine.String(), settings.EngineVersion)
+	if err != nil {
+		return parameters, err
+	}
+	dbParameterGroupName, err := syncDBParameterGroupIfNeeded(parameters, rdsClient, settings,
+		aws.ToString(dbEngineVersion.DBParameterGroupFamily), logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+
+	createDBInstanceInput := &rds.CreateDBInstanceInput{
 		DBInstanceClass:           aws.String(rdsInstance.Instance()),
 		DBInstanceIdentifier:      aws.String(rdsInstanceIdentifier), //get from name
 		DBSubnetGroupName:         aws.String(dbSubnetGroupName),
-		EnablePerformanceInsights: aws.Bool(engine.EnablePerformanceInsights()),
+		EnablePerformanceInsights: aws.Bool(enablePerformanceInsights),
 		Engine:                    aws.String(engine.String()),
 		AllocatedStorage:          aws.Int32(int32(allocatedStorage)),
 		AutoMinorVersionUpgrade:   aws.Bool(true),
@@ -346,7 +444,6 @@ func (d *DeployAwsRdsDatabase) Run(parameters map[string]interface{}, logsWriter
 		},
 
 		//EnableCloudwatchLogsExports:        nil,
-		//EngineVersion:                      nil, // for now we keep it default
 		//Iops:                               nil,
 		//MasterUserSecretKmsKeyId:           nil,
 		//MonitoringInterval:                 nil,
@@ -356,8 +453,6 @@ func (d *DeployAwsRdsDatabase) Run(parameters map[string]interface{}, logsWriter
 		//PerformanceInsightsKMSKeyId:        nil,
 		//PerformanceInsightsRetentionPeriod: nil,
 		//Port:                       nil,
-		//PreferredBackupWindow:      nil,
-		//PreferredMaintenanceWindow: nil,
 		//ProcessorFeatures:        nil,
 		//TdeCredentialArn:      nil,
 		//TdeCredentialPassword: nil,
@@ -369,7 +464,19 @@ func (d *DeployAwsRdsDatabase) Run(parameters map[string]interface{}, logsWriter
 		//StorageThroughput:        aws.Int32(125),
 		//For allocated storage between 20-399 GiB, a baseline storage throughput of 125 MiBps is included in General Purpose SSD (gp3) storage volumes.
 		//When allocated storage is 400 GiB or greater, a baseline storage throughput of 500 MiBps is included.
-	})
+		BackupRetentionPeriod: settings.BackupRetentionDays,
+	}
+	if len(settings.EngineVersion) > 0 {
+		createDBInstanceInput.EngineVersion = aws.String(settings.EngineVersion)
+	}
+	if len(settings.BackupWindow) > 0 {
+		createDBInstanceInput.PreferredBackupWindow = aws.String(settings.BackupWindow)
+	}
+	if len(settings.MaintenanceWindow) > 0 {
+		createDBInstanceInput.PreferredMaintenanceWindow = aws.String(settings.MaintenanceWindow)
+	}
+	createDBInstanceInput.DBParameterGroupName = aws.String(dbParameterGroupName)
+	createDBInstanceOutput, err := rdsClient.CreateDBInstance(context.TODO(), createDBInstanceInput)
 
 	if err != nil {
 		return parameters, err
@@ -388,5 +495,10 @@ func (d *DeployAwsRdsDatabase) Run(parameters map[string]interface{}, logsWriter
 		return parameters, err
 	}
 
+	err = syncRdsReadReplicas(parameters, rdsClient, rdsInstanceIdentifier, settings.ReadReplicas, dbParameterGroupName, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+
 	return parameters, nil
 }
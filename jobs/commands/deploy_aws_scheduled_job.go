@@ -0,0 +1,351 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamTypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/scheduler"
+	schedulerTypes "github.com/aws/aws-sdk-go-v2/service/scheduler/types"
+	"github.com/deployment-io/deployment-runner-kit/builds"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/deployments"
+	"github.com/deployment-io/deployment-runner-kit/enums/iam_policy_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/iam_policies"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
+	"github.com/deployment-io/deployment-runner/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DeployAwsScheduledJob runs the deployment's image as a Fargate task on a
+// cron or rate schedule using EventBridge Scheduler. The schedule targets
+// the cluster from CreateEcsCluster and starts the latest task definition
+// revision, optionally with the container command overridden.
+type DeployAwsScheduledJob struct {
+}
+
+func getScheduleName(parameters map[string]interface{}) (string, error) {
+	//sj-<deploymentID>
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sj-%s", deploymentID), nil
+}
+
+func getSchedulerRoleName(parameters map[string]interface{}) (string, error) {
+	//sRole-<organizationID>-<runner region>
+	organizationID, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIDNamespace)
+	if err != nil {
+		return "", err
+	}
+	runnerData := utils.RunnerData.Get()
+	return fmt.Sprintf("sRole-%s-%s", organizationID, runnerData.RunnerRegion), nil
+}
+
+func getSchedulerTrustPolicy() string {
+	schedulerTrustPolicy := `{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Effect": "Allow",
+      "Principal": {
+        "Service": "scheduler.amazonaws.com"
+      },
+      "Action": "sts:AssumeRole"
+    }
+  ]
+}`
+	return schedulerTrustPolicy
+}
+
+// getSchedulerRolePolicy only allows starting the runner's own task
+// definitions and passing roles to ECS tasks.
+func getSchedulerRolePolicy() string {
+	return `{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Effect": "Allow",
+      "Action": [
+        "ecs:RunTask",
+        "ecs:TagResource"
+      ],
+      "Resource": [
+        "arn:aws:ecs:*:*:task-definition/td-*",
+        "arn:aws:ecs:*:*:task/*"
+      ]
+    },
+    {
+      "Effect": "Allow",
+      "Action": "iam:PassRole",
+      "Resource": "*",
+      "Condition": {
+        "StringLike": {
+          "iam:PassedToService": "ecs-tasks.amazonaws.com"
+        }
+      }
+    }
+  ]
+}`
+}
+
+func getSchedulerRoleIfNeeded(iamClient *iam.Client, parameters map[string]interface{}) (string, error) {
+	schedulerRoleName, err := getSchedulerRoleName(parameters)
+	if err != nil {
+		return "", err
+	}
+	var schedulerRoleArn string
+	getRoleOutput, err := iamClient.GetRole(context.TODO(), &iam.GetRoleInput{RoleName: aws.String(schedulerRoleName)})
+	var noSuchEntityException *iamTypes.NoSuchEntityException
+	if err != nil && !errors.As(err, &noSuchEntityException) {
+		return "", err
+	}
+	if err == nil && getRoleOutput.Role != nil {
+		schedulerRoleArn = aws.ToString(getRoleOutput.Role.Arn)
+	} else {
+		createRoleOutput, err := iamClient.CreateRole(context.TODO(), &iam.CreateRoleInput{
+			AssumeRolePolicyDocument: aws.String(getSchedulerTrustPolicy()),
+			RoleName:                 aws.String(schedulerRoleName),
+			Tags: []iamTypes.Tag{
+				{
+					Key:   aws.String("Name"),
+					Value: aws.String(schedulerRoleName),
+				},
+				{
+					Key:   aws.String("created by"),
+					Value: aws.String("deployment.io"),
+				},
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+		schedulerRoleArn = aws.ToString(createRoleOutput.Role.Arn)
+	}
+	//keep the inline policy up to date on every deploy
+	_, err = iamClient.PutRolePolicy(context.TODO(), &iam.PutRolePolicyInput{
+		PolicyDocument: aws.String(getSchedulerRolePolicy()),
+		PolicyName:     aws.String("run-ecs-tasks"),
+		RoleName:       aws.String(schedulerRoleName),
+	})
+	if err != nil {
+		return "", err
+	}
+	return schedulerRoleArn, nil
+}
+
+type ecsContainerOverride struct {
+	Name    string   `json:"name"`
+	Command []string `json:"command"`
+}
+
+type ecsTaskOverride struct {
+	ContainerOverrides []ecsContainerOverride `json:"containerOverrides"`
+}
+
+// getScheduledJobTargetInput builds the RunTask overrides EventBridge
+// Scheduler passes to ECS. Empty when the image's own command should run.
+func getScheduledJobTargetInput(parameters map[string]interface{}) (string, error) {
+	command, err := jobs.GetParameterValue[string](parameters, parameters_enums.RunTaskCommand)
+	if err != nil || len(strings.TrimSpace(command)) == 0 {
+		return "", nil
+	}
+	containerName, err := getContainerName(parameters)
+	if err != nil {
+		return "", err
+	}
+	input, err := json.Marshal(ecsTaskOverride{
+		ContainerOverrides: []ecsContainerOverride{
+			{
+				Name:    containerName,
+				Command: []string{"sh", "-c", command},
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(input), nil
+}
+
+func (d *DeployAwsScheduledJob) Run(parameters map[string]interface{}, logsWriter io.Writer) (newParameters map[string]interface{}, err error) {
+	defer func() {
+		if err != nil {
+			<-MarkDeploymentDone(parameters, err)
+		}
+	}()
+
+	//check and add policy for AWS scheduled job deployment
+	runnerData := utils.RunnerData.Get()
+	organizationID, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIDNamespace)
+	if err != nil {
+		return parameters, err
+	}
+	err = iam_policies.AddAwsPolicyForDeploymentRunner(iam_policy_enums.AwsScheduledJobDeployment,
+		runnerData.OsType.String(), runnerData.CpuArchEnum.String(), organizationID, runnerData.RunnerRegion, runnerData.Mode, runnerData.TargetCloud)
+	if err != nil {
+		return parameters, err
+	}
+
+	scheduleExpression, err := jobs.GetParameterValue[string](parameters, parameters_enums.ScheduleExpression)
+	if err != nil {
+		return parameters, err
+	}
+	scheduleTimezone, err := jobs.GetParameterValue[string](parameters, parameters_enums.ScheduleTimezone)
+	if err != nil || len(scheduleTimezone) == 0 {
+		scheduleTimezone = "UTC"
+	}
+
+	ecsClient, err := cloud_api_clients.GetEcsClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	taskDefinitionArn, err := registerTaskDefinitionWithPortMappings(parameters, ecsClient, nil, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	ecsClusterArn, err := jobs.GetParameterValue[string](parameters, parameters_enums.EcsClusterArn)
+	if err != nil {
+		return parameters, err
+	}
+	privateSubnets, err := jobs.GetParameterValue[primitive.A](parameters, parameters_enums.PrivateSubnets)
+	if err != nil {
+		return parameters, err
+	}
+	privateSubnetsSlice, err := commandUtils.ConvertPrimitiveAToStringSlice(privateSubnets)
+	if err != nil {
+		return parameters, err
+	}
+
+	iamClient, err := cloud_api_clients.GetIamClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	schedulerRoleArn, err := getSchedulerRoleIfNeeded(iamClient, parameters)
+	if err != nil {
+		return parameters, err
+	}
+
+	targetInput, err := getScheduledJobTargetInput(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	scheduleName, err := getScheduleName(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	target := &schedulerTypes.Target{
+		Arn:     aws.String(ecsClusterArn),
+		RoleArn: aws.String(schedulerRoleArn),
+		EcsParameters: &schedulerTypes.EcsParameters{
+			TaskDefinitionArn: aws.String(taskDefinitionArn),
+			LaunchType:        schedulerTypes.LaunchTypeFargate,
+			NetworkConfiguration: &schedulerTypes.NetworkConfiguration{
+				AwsvpcConfiguration: &schedulerTypes.AwsVpcConfiguration{
+					Subnets:        privateSubnetsSlice,
+					AssignPublicIp: schedulerTypes.AssignPublicIpDisabled,
+				},
+			},
+			TaskCount:            aws.Int32(1),
+			EnableECSManagedTags: aws.Bool(true),
+			Tags: []map[string]string{
+				{
+					"Name":       scheduleName,
+					"created by": "deployment.io",
+				},
+			},
+		},
+		//a failed run shows up in the invocations list, retrying would run the job twice
+		RetryPolicy: &schedulerTypes.RetryPolicy{
+			MaximumRetryAttempts: aws.Int32(0),
+		},
+	}
+	if len(targetInput) > 0 {
+		target.Input = aws.String(targetInput)
+	}
+
+	schedulerClient, err := cloud_api_clients.GetSchedulerClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	var scheduleArn string
+	_, err = schedulerClient.GetSchedule(context.TODO(), &scheduler.GetScheduleInput{
+		Name: aws.String(scheduleName),
+	})
+	var resourceNotFoundException *schedulerTypes.ResourceNotFoundException
+	if err != nil && !errors.As(err, &resourceNotFoundException) {
+		return parameters, err
+	}
+	if err != nil {
+		io.WriteString(logsWriter, fmt.Sprintf("Creating schedule %s: %s (%s)\n", scheduleName, scheduleExpression, scheduleTimezone))
+		createScheduleOutput, err := schedulerClient.CreateSchedule(context.TODO(), &scheduler.CreateScheduleInput{
+			Name:                       aws.String(scheduleName),
+			ScheduleExpression:         aws.String(scheduleExpression),
+			ScheduleExpressionTimezone: aws.String(scheduleTimezone),
+			FlexibleTimeWindow: &schedulerTypes.FlexibleTimeWindow{
+				Mode: schedulerTypes.FlexibleTimeWindowModeOff,
+			},
+			Target:      target,
+			State:       schedulerTypes.ScheduleStateEnabled,
+			Description: aws.String("created by deployment.io"),
+		})
+		if err != nil {
+			return parameters, err
+		}
+		scheduleArn = aws.ToString(createScheduleOutput.ScheduleArn)
+	} else {
+		io.WriteString(logsWriter, fmt.Sprintf("Updating schedule %s: %s (%s)\n", scheduleName, scheduleExpression, scheduleTimezone))
+		updateScheduleOutput, err := schedulerClient.UpdateSchedule(context.TODO(), &scheduler.UpdateScheduleInput{
+			Name:                       aws.String(scheduleName),
+			ScheduleExpression:         aws.String(scheduleExpression),
+			ScheduleExpressionTimezone: aws.String(scheduleTimezone),
+			FlexibleTimeWindow: &schedulerTypes.FlexibleTimeWindow{
+				Mode: schedulerTypes.FlexibleTimeWindowModeOff,
+			},
+			Target:      target,
+			State:       schedulerTypes.ScheduleStateEnabled,
+			Description: aws.String("created by deployment.io"),
+		})
+		if err != nil {
+			return parameters, err
+		}
+		scheduleArn = aws.ToString(updateScheduleOutput.ScheduleArn)
+	}
+
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return parameters, err
+	}
+	buildID, err := jobs.GetParameterValue[string](parameters, parameters_enums.BuildID)
+	if err != nil {
+		return parameters, err
+	}
+	organizationIdFromJob, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIdFromJob)
+	if err != nil {
+		return parameters, err
+	}
+	commandUtils.UpdateDeploymentsPipeline.Add(organizationIdFromJob, deployments.UpdateDeploymentDtoV1{
+		ID:          deploymentID,
+		ScheduleArn: scheduleArn,
+	})
+	commandUtils.UpdateBuildsPipeline.Add(organizationIdFromJob, builds.UpdateBuildDtoV1{
+		ID:                buildID,
+		TaskDefinitionArn: taskDefinitionArn,
+	})
+
+	io.WriteString(logsWriter, fmt.Sprintf("Scheduled job deployed: %s\n", scheduleArn))
+
+	//mark build done successfully
+	<-MarkDeploymentDone(parameters, nil)
+
+	return parameters, nil
+}
@@ -111,11 +111,12 @@ func getOriginAccessName(parameters map[string]interface{}) (string, error) {
 }
 
 func createDistributionConfigForNewCloudfront(parameters map[string]interface{}, bucketLocation, originAccessControlId *string, callerReference, comment,
-	domainName string,
+	domainName, originPath string,
 	defaultCacheBehavior *cloudfrontTypes.DefaultCacheBehavior) (*cloudfrontTypes.DistributionConfig, error) {
 	origin := cloudfrontTypes.Origin{
 		Id:                    bucketLocation,
 		DomainName:            aws.String(domainName),
+		OriginPath:            aws.String(originPath),
 		OriginAccessControlId: originAccessControlId,
 		S3OriginConfig: &cloudfrontTypes.S3OriginConfig{
 			OriginAccessIdentity: aws.String(""),
@@ -316,21 +317,51 @@ func (d *DeployAwsStaticSite) Run(parameters map[string]interface{}, logsWriter
 		}
 	}
 
-	err = aws_utils.DeleteAllS3Files(s3Client, bucketName)
+	// Create an Amazon Cloudfront service client
+	cloudfrontClient, err := cloud_api_clients.GetCloudfrontClient(parameters, cloudfrontRegion)
+	if err != nil {
+		return parameters, err
+	}
+
+	buildID, err := getStaticSiteBuildID(parameters)
 	if err != nil {
 		return parameters, err
 	}
+	var liveBuildID string
+	if !isNewBucketCreated {
+		liveBuildID, err = getLiveStaticSiteBuildID(cloudfrontClient, cloudfrontID)
+		if err != nil {
+			return parameters, err
+		}
+	}
+	buildPrefix := getStaticSiteBuildPrefix(buildID)
+	//files unchanged since the live build are copied inside S3
+	var liveBuildPrefix string
+	if len(liveBuildID) > 0 {
+		liveBuildPrefix = getStaticSiteBuildPrefix(liveBuildID)
+	}
 
-	io.WriteString(logsWriter, fmt.Sprintf("Uploading site to S3 bucket: %s\n", bucketName))
+	io.WriteString(logsWriter, fmt.Sprintf("Uploading site to S3 bucket: %s under %s\n", bucketName, buildPrefix))
 
-	err = aws_utils.UploadToS3(distDirectory, region_enums.Type(region).String(), bucketName, s3Client, logsWriter)
+	cacheSettings, err := getStaticSiteCacheSettings(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	syncResult, err := aws_utils.SyncToS3(distDirectory, region_enums.Type(region).String(), bucketName, buildPrefix, liveBuildPrefix,
+		cacheSettings.getSyncOptions(), s3Client, logsWriter)
 	if err != nil {
 		io.WriteString(logsWriter, fmt.Sprintf("Error uploading site to S3 bucket: %s\n", bucketName))
 		return parameters, err
 	}
-
-	// Create an Amazon Cloudfront service client
-	cloudfrontClient, err := cloud_api_clients.GetCloudfrontClient(parameters, cloudfrontRegion)
+	err = uploadStaticSiteRuleFiles(s3Client, bucketName, buildID, distDirectory)
+	if err != nil {
+		return parameters, err
+	}
+	err = uploadStaticSitePrecompressedEncodings(s3Client, bucketName, buildID, cacheSettings.Precompress)
+	if err != nil {
+		return parameters, err
+	}
+	err = markStaticSiteBuildComplete(s3Client, bucketName, buildID)
 	if err != nil {
 		return parameters, err
 	}
@@ -380,7 +411,7 @@ func (d *DeployAwsStaticSite) Run(parameters map[string]interface{}, logsWriter
 
 		var distributionConfig *cloudfrontTypes.DistributionConfig
 		distributionConfig, err = createDistributionConfigForNewCloudfront(parameters, bucketLocation, originAccessControlId,
-			callerReference, comment, domainName, defaultCacheBehavior)
+			callerReference, comment, domainName, getStaticSiteOriginPath(buildID), defaultCacheBehavior)
 
 		if err != nil {
 			return parameters, err
@@ -457,45 +488,43 @@ func (d *DeployAwsStaticSite) Run(parameters map[string]interface{}, logsWriter
 		jobs.SetParameterValue(parameters, parameters_enums.CloudfrontID, aws.ToString(createDistributionOutput.Distribution.Id))
 	} else {
 		//new build
-		//Invalidate cloudfront
-		var callerReference string
-		callerReference, err = getCallerReference(parameters)
+		//the upload is complete, switch the origin to it
+		err = switchStaticSiteBuild(cloudfrontClient, cloudfrontID, buildID, logsWriter)
 		if err != nil {
 			return parameters, err
 		}
-		var createInvalidationOutput *cloudfront.CreateInvalidationOutput
-		createInvalidationOutput, err = cloudfrontClient.CreateInvalidation(context.TODO(), &cloudfront.CreateInvalidationInput{
-			DistributionId: aws.String(cloudfrontID),
-			InvalidationBatch: &cloudfrontTypes.InvalidationBatch{
-				CallerReference: aws.String(callerReference),
-				Paths: &cloudfrontTypes.Paths{
-					Quantity: aws.Int32(1),
-					Items: []string{
-						"/*",
-					},
-				},
-			},
-		})
-
-		if err != nil {
-			return parameters, err
+		//Invalidate cloudfront
+		invalidationPaths := []string{"/*"}
+		if len(liveBuildID) > 0 {
+			invalidationPaths = getStaticSiteInvalidationPaths(syncResult.ChangedKeys)
+		}
+		if len(invalidationPaths) > 0 {
+			err = invalidateCloudfrontPaths(parameters, cloudfrontClient, cloudfrontID, invalidationPaths, logsWriter)
+			if err != nil {
+				return parameters, err
+			}
+		} else {
+			io.WriteString(logsWriter, fmt.Sprintf("No files changed since the last build\n"))
 		}
 
-		//Wait for invalidation to get done
-		invalidationWaiter := cloudfront.NewInvalidationCompletedWaiter(cloudfrontClient)
-
-		io.WriteString(logsWriter, fmt.Sprintf("Waiting for cloudfront distribution to be invalidated: %s\n", cloudfrontID))
-
-		err = invalidationWaiter.Wait(context.TODO(), &cloudfront.GetInvalidationInput{
-			DistributionId: aws.String(cloudfrontID),
-			Id:             createInvalidationOutput.Invalidation.Id,
-		}, 20*time.Minute)
+		jobs.SetParameterValue(parameters, parameters_enums.CloudfrontID, cloudfrontID)
+	}
 
+	if !isPreview(parameters) {
+		var deploymentID string
+		deploymentID, err = jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
 		if err != nil {
 			return parameters, err
 		}
+		commandUtils.UpdateDeploymentsPipeline.Add(organizationIdFromJob, deployments.UpdateDeploymentDtoV1{
+			ID:                deploymentID,
+			StaticSiteBuildID: buildID,
+		})
+	}
 
-		jobs.SetParameterValue(parameters, parameters_enums.CloudfrontID, cloudfrontID)
+	err = pruneStaticSiteBuilds(s3Client, bucketName, buildID, getStaticSiteBuildsToKeep(parameters), logsWriter)
+	if err != nil {
+		return parameters, err
 	}
 
 	//mark build done successfully
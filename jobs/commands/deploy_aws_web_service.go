@@ -40,6 +40,14 @@ type DeployAwsWebService struct {
 }
 
 func getAlbSecurityGroupName(parameters map[string]interface{}) (string, error) {
+	if isSharedAlb(parameters) {
+		//salbsg-<environmentID>
+		environmentID, err := getSharedAlbEnvironmentID(parameters)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("salbsg-%s", environmentID), nil
+	}
 	//security group name = albsg-<deploymentID>
 	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
 	if err != nil {
@@ -49,6 +57,14 @@ func getAlbSecurityGroupName(parameters map[string]interface{}) (string, error)
 }
 
 func getAlbSecurityGroupIngressRuleName(parameters map[string]interface{}) (string, error) {
+	if isSharedAlb(parameters) {
+		//sgin-<environmentID>
+		environmentID, err := getSharedAlbEnvironmentID(parameters)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("sgin-%s", environmentID), nil
+	}
 	//sgin-<deploymentID>
 	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
 	if err != nil {
@@ -58,6 +74,14 @@ func getAlbSecurityGroupIngressRuleName(parameters map[string]interface{}) (stri
 }
 
 func getAlbSecurityGroupEgressRuleName(parameters map[string]interface{}) (string, error) {
+	if isSharedAlb(parameters) {
+		//sgeg-<environmentID>
+		environmentID, err := getSharedAlbEnvironmentID(parameters)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("sgeg-%s", environmentID), nil
+	}
 	//sgeg-<deploymentID>
 	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
 	if err != nil {
@@ -365,6 +389,11 @@ func createAlbSecurityGroupIfNeeded(parameters map[string]interface{}, ec2Client
 		if err != nil {
 			return "", err
 		}
+		fromPort, toPort := int32(port), int32(port)
+		if isSharedAlb(parameters) {
+			//services behind a shared alb listen on different ports
+			fromPort, toPort = 0, 65535
+		}
 
 		vpcCidr, err := jobs.GetParameterValue[string](parameters, parameters_enums.VpcCidr)
 		if err != nil {
@@ -374,13 +403,13 @@ func createAlbSecurityGroupIfNeeded(parameters map[string]interface{}, ec2Client
 			GroupId: aws.String(albSecurityGroupId),
 			DryRun:  aws.Bool(false),
 			IpPermissions: []ec2Types.IpPermission{{
-				FromPort:   aws.Int32(int32(port)),
+				FromPort:   aws.Int32(fromPort),
 				IpProtocol: aws.String("tcp"),
 				IpRanges: []ec2Types.IpRange{{
 					CidrIp:      aws.String(vpcCidr),
 					Description: aws.String(fmt.Sprintf("VPC cidr - %s", vpcCidr)),
 				}},
-				ToPort: aws.Int32(int32(port)),
+				ToPort: aws.Int32(toPort),
 			}},
 			TagSpecifications: []ec2Types.TagSpecification{{
 				ResourceType: ec2Types.ResourceTypeSecurityGroupRule,
@@ -448,6 +477,14 @@ func getAlbTargetGroupName(parameters map[string]interface{}) (string, error) {
 }
 
 func getAlbName(parameters map[string]interface{}) (string, error) {
+	if isSharedAlb(parameters) {
+		//salb-<environmentID>
+		environmentID, err := getSharedAlbEnvironmentID(parameters)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("salb-%s", environmentID), nil
+	}
 	//alb-<deploymentID>
 	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
 	if err != nil {
@@ -457,6 +494,14 @@ func getAlbName(parameters map[string]interface{}) (string, error) {
 }
 
 func getAlbListenerName(parameters map[string]interface{}, port int32) (string, error) {
+	if isSharedAlb(parameters) {
+		//lstr-<port>-<environmentID>
+		environmentID, err := getSharedAlbEnvironmentID(parameters)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("lstr-%d-%s", port, environmentID), nil
+	}
 	//lstr-<port>-<deploymentID>
 	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
 	if err != nil {
@@ -465,13 +510,46 @@ func getAlbListenerName(parameters map[string]interface{}, port int32) (string,
 	return fmt.Sprintf("lstr-%d-%s", port, deploymentID), nil
 }
 
+func getAlbListenerRuleName(parameters map[string]interface{}) (string, error) {
+	//lrule-<deploymentID>
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("lrule-%s", deploymentID), nil
+}
+
 func createAlbIfNeeded(parameters map[string]interface{},
 	elbClient *elasticloadbalancingv2.Client, albSecurityGroupId string, logsWriter io.Writer) (loadBalancerArn string, targetGroupArn string, err error) {
 
+	if isSharedAlb(parameters) {
+		unlock, err := lockSharedAlb(parameters)
+		if err != nil {
+			return "", "", err
+		}
+		defer unlock()
+	}
+
 	loadBalancerArnFromParams, err := jobs.GetParameterValue[string](parameters, parameters_enums.LoadBalancerArn)
 	if err == nil && len(loadBalancerArnFromParams) > 0 {
+		err = checkAlbKindUnchanged(parameters, loadBalancerArnFromParams)
+		if err != nil {
+			return "", "", err
+		}
 		targetGroupArnFromParams, err := jobs.GetParameterValue[string](parameters, parameters_enums.TargetGroupArn)
 		if err == nil && len(targetGroupArnFromParams) > 0 {
+			if isSharedAlb(parameters) {
+				//hosts and path prefix might have changed since the last deployment
+				err = createSharedAlbListenerRulesIfNeeded(parameters, elbClient, loadBalancerArnFromParams, targetGroupArnFromParams, logsWriter)
+				if err != nil {
+					return "", "", err
+				}
+			}
+			//the preview's protection might have changed since the last deployment
+			err = updateExistingPreviewListenerProtection(parameters, elbClient, loadBalancerArnFromParams, targetGroupArnFromParams, logsWriter)
+			if err != nil {
+				return "", "", err
+			}
 			return loadBalancerArnFromParams, targetGroupArnFromParams, nil
 		}
 	}
@@ -481,6 +559,20 @@ func createAlbIfNeeded(parameters map[string]interface{},
 		return "", "", err
 	}
 
+	targetGroupSettings, err := getTargetGroupSettings(parameters)
+	if err != nil {
+		return "", "", err
+	}
+	//HTTP2 and gRPC targets can only be reached through an https listener
+	var certificateArn string
+	if targetGroupSettings.requiresHttps() {
+		certificateArn, _ = jobs.GetParameterValue[string](parameters, parameters_enums.AcmCertificateArn)
+		if len(certificateArn) == 0 {
+			return "", "", fmt.Errorf("target group protocol version %s needs https: add a certificate for the service's domain first",
+				targetGroupSettings.ProtocolVersion)
+		}
+	}
+
 	//TODO check for 400 not found error
 	describeTargetGroupsOutput, _ := elbClient.DescribeTargetGroups(context.TODO(), &elasticloadbalancingv2.DescribeTargetGroupsInput{
 		Names: []string{
@@ -508,15 +600,17 @@ func createAlbIfNeeded(parameters map[string]interface{},
 		createTargetGroupInput := &elasticloadbalancingv2.CreateTargetGroupInput{
 			Name:                       aws.String(targetGroupName),
 			HealthCheckEnabled:         aws.Bool(true),
-			HealthCheckIntervalSeconds: aws.Int32(40),
+			HealthCheckIntervalSeconds: aws.Int32(targetGroupSettings.HealthCheck.IntervalSeconds),
 			HealthCheckPath:            aws.String(healthCheckPath),
-			HealthCheckTimeoutSeconds:  aws.Int32(30),
+			HealthCheckTimeoutSeconds:  aws.Int32(targetGroupSettings.HealthCheck.TimeoutSeconds),
+			HealthCheckPort:            aws.String(targetGroupSettings.HealthCheck.Port),
 			HealthCheckProtocol:        elbTypes.ProtocolEnumHttp,
-			Matcher: &elbTypes.Matcher{
-				HttpCode: aws.String("200-400"),
-			},
-			Port:     aws.Int32(int32(port)),
-			Protocol: elbTypes.ProtocolEnumHttp,
+			HealthyThresholdCount:      aws.Int32(targetGroupSettings.HealthCheck.HealthyThreshold),
+			UnhealthyThresholdCount:    aws.Int32(targetGroupSettings.HealthCheck.UnhealthyThreshold),
+			Matcher:                    targetGroupSettings.matcher(),
+			Port:                       aws.Int32(int32(port)),
+			Protocol:                   elbTypes.ProtocolEnumHttp,
+			ProtocolVersion:            aws.String(targetGroupSettings.ProtocolVersion),
 			Tags: []elbTypes.Tag{
 				{
 					Key:   aws.String("Name"),
@@ -617,14 +711,26 @@ func createAlbIfNeeded(parameters map[string]interface{},
 	//support for certificate/https flow will be added as a command
 	var listenerArn string
 	if describeListenersOutput != nil && len(describeListenersOutput.Listeners) > 0 {
-		listenerArn = aws.ToString(describeListenersOutput.Listeners[0].ListenerArn)
-	} else {
+		for _, listener := range describeListenersOutput.Listeners {
+			if aws.ToInt32(listener.Port) == listenerPort {
+				listenerArn = aws.ToString(listener.ListenerArn)
+			}
+		}
+	}
+	if len(listenerArn) == 0 {
+		defaultActions := []elbTypes.Action{{
+			Type:           elbTypes.ActionTypeEnumForward,
+			Order:          aws.Int32(1),
+			TargetGroupArn: aws.String(targetGroupArn),
+		}}
+		if isSharedAlb(parameters) {
+			//requests that don't match any service's rule
+			defaultActions = getSharedAlbListenerDefaultActions()
+		} else if targetGroupSettings.requiresHttps() {
+			defaultActions = getHttpsRedirectActions()
+		}
 		createListenerInput := &elasticloadbalancingv2.CreateListenerInput{
-			DefaultActions: []elbTypes.Action{{
-				Type:           elbTypes.ActionTypeEnumForward,
-				Order:          aws.Int32(1),
-				TargetGroupArn: aws.String(targetGroupArn),
-			}},
+			DefaultActions:  defaultActions,
 			LoadBalancerArn: aws.String(loadBalancerArn),
 			Port:            aws.Int32(listenerPort),
 			Protocol:        elbTypes.ProtocolEnumHttp,
@@ -647,6 +753,30 @@ func createAlbIfNeeded(parameters map[string]interface{},
 		listenerArn = aws.ToString(createListenerOutput.Listeners[0].ListenerArn)
 	}
 
+	var httpsListenerArn string
+	if targetGroupSettings.requiresHttps() {
+		httpsListenerArn, err = createOrUpdateHttpsListener(parameters, elbClient, loadBalancerArn, targetGroupArn, certificateArn, logsWriter)
+		if err != nil {
+			return "", "", err
+		}
+		err = updatePreviewListenerProtection(parameters, elbClient, httpsListenerArn, targetGroupArn, logsWriter)
+		if err != nil {
+			return "", "", err
+		}
+	} else {
+		err = updatePreviewListenerProtection(parameters, elbClient, listenerArn, targetGroupArn, logsWriter)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	if isSharedAlb(parameters) {
+		err = createSharedAlbListenerRulesIfNeeded(parameters, elbClient, loadBalancerArn, targetGroupArn, logsWriter)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
 	io.WriteString(logsWriter, fmt.Sprintf("Created load balancer: %s\n", loadBalancerArn))
 
 	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
@@ -660,11 +790,12 @@ func createAlbIfNeeded(parameters map[string]interface{},
 	}
 	if !isPreview(parameters) {
 		commandUtils.UpdateDeploymentsPipeline.Add(organizationIdFromJob, deployments.UpdateDeploymentDtoV1{
-			ID:                deploymentID,
-			TargetGroupArn:    targetGroupArn,
-			ListenerArnPort80: listenerArn,
-			LoadBalancerArn:   loadBalancerArn,
-			LoadBalancerDns:   loadBalancerDns,
+			ID:                 deploymentID,
+			TargetGroupArn:     targetGroupArn,
+			ListenerArnPort80:  listenerArn,
+			ListenerArnPort443: httpsListenerArn,
+			LoadBalancerArn:    loadBalancerArn,
+			LoadBalancerDns:    loadBalancerDns,
 		})
 	} else {
 		previewID := deploymentID
@@ -749,7 +880,7 @@ func getPortMappingName(parameters map[string]interface{}) (string, error) {
 	return fmt.Sprintf("port-mapping-%s-%d", deploymentID, port), nil
 }
 
-func registerTaskDefinition(parameters map[string]interface{}, ecsClient *ecs.Client) (taskDefinitionArn string, err error) {
+func registerTaskDefinition(parameters map[string]interface{}, ecsClient *ecs.Client, logsWriter io.Writer) (taskDefinitionArn string, err error) {
 
 	taskDefinitionArnFromParams, err := jobs.GetParameterValue[string](parameters, parameters_enums.TaskDefinitionArn)
 	if err == nil && len(taskDefinitionArnFromParams) > 0 {
@@ -766,12 +897,28 @@ func registerTaskDefinition(parameters map[string]interface{}, ecsClient *ecs.Cl
 		return "", err
 	}
 
-	containerPortMapping := ecsTypes.PortMapping{
-		ContainerPort: aws.Int32(int32(port)),
-		Name:          aws.String(portMappingName),
-		Protocol:      ecsTypes.TransportProtocolTcp,
+	containerPortMappings := []ecsTypes.PortMapping{
+		{
+			ContainerPort: aws.Int32(int32(port)),
+			Name:          aws.String(portMappingName),
+			Protocol:      ecsTypes.TransportProtocolTcp,
+		},
+	}
+	//nlb services can listen on more ports and on udp
+	nlbContainerPortMappings, err := getNlbContainerPortMappings(parameters, containerPortMappings)
+	if err != nil {
+		return "", err
 	}
+	containerPortMappings = append(containerPortMappings, nlbContainerPortMappings...)
 
+	return registerTaskDefinitionWithPortMappings(parameters, ecsClient, containerPortMappings, logsWriter)
+}
+
+// registerTaskDefinitionWithPortMappings registers the task definition with
+// the given port mappings. Scheduled jobs register theirs without any since
+// they don't listen on a port.
+func registerTaskDefinitionWithPortMappings(parameters map[string]interface{}, ecsClient *ecs.Client,
+	containerPortMappings []ecsTypes.PortMapping, logsWriter io.Writer) (taskDefinitionArn string, err error) {
 	ecrRepositoryUriWithTag, err := jobs.GetParameterValue[string](parameters, parameters_enums.DockerRepositoryUriWithTag)
 	if err != nil {
 		return "", err
@@ -786,6 +933,37 @@ func registerTaskDefinition(parameters map[string]interface{}, ecsClient *ecs.Cl
 		}
 	}
 
+	secrets, err := syncSecretEnvironmentVariables(parameters, logsWriter)
+	if err != nil {
+		return "", err
+	}
+	for _, secret := range secrets {
+		for _, kv := range envVariablesKeyValuePair {
+			if aws.ToString(kv.Name) == aws.ToString(secret.Name) {
+				return "", fmt.Errorf("environment variable %s is set both as plain and as secret", aws.ToString(secret.Name))
+			}
+		}
+	}
+
+	//linked resources come in as env vars and as access on the task role
+	linkedResourcesEnvironment, linkedResourcesPolicy, err := resolveLinkedResources(parameters, logsWriter)
+	if err != nil {
+		return "", err
+	}
+	for _, linkedKv := range linkedResourcesEnvironment {
+		for _, kv := range envVariablesKeyValuePair {
+			if aws.ToString(kv.Name) == aws.ToString(linkedKv.Name) {
+				return "", fmt.Errorf("environment variable %s is also set by a linked resource", aws.ToString(kv.Name))
+			}
+		}
+		for _, secret := range secrets {
+			if aws.ToString(secret.Name) == aws.ToString(linkedKv.Name) {
+				return "", fmt.Errorf("secret %s is also set by a linked resource", aws.ToString(secret.Name))
+			}
+		}
+	}
+	envVariablesKeyValuePair = append(envVariablesKeyValuePair, linkedResourcesEnvironment...)
+
 	containerName, err := getContainerName(parameters)
 	if err != nil {
 		return "", err
@@ -809,6 +987,7 @@ func registerTaskDefinition(parameters map[string]interface{}, ecsClient *ecs.Cl
 	containerDefinition := ecsTypes.ContainerDefinition{
 		DisableNetworking: aws.Bool(false),
 		Environment:       envVariablesKeyValuePair,
+		Secrets:           secrets,
 		Essential:         aws.Bool(true),
 		Image:             aws.String(ecrRepositoryUriWithTag),
 		Interactive:       aws.Bool(false),
@@ -821,10 +1000,8 @@ func registerTaskDefinition(parameters map[string]interface{}, ecsClient *ecs.Cl
 				"awslogs-stream-prefix": logsStreamPrefix,
 			},
 		},
-		Name: aws.String(containerName),
-		PortMappings: []ecsTypes.PortMapping{
-			containerPortMapping,
-		},
+		Name:                   aws.String(containerName),
+		PortMappings:           containerPortMappings,
 		Privileged:             aws.Bool(false),
 		PseudoTerminal:         aws.Bool(false),
 		ReadonlyRootFilesystem: aws.Bool(false),
@@ -858,6 +1035,59 @@ func registerTaskDefinition(parameters map[string]interface{}, ecsClient *ecs.Cl
 	if err != nil {
 		return "", err
 	}
+
+	sidecarLogsStreamPrefix, err := commandUtils.GetSidecarLogStreamPrefix(parameters)
+	if err != nil {
+		return "", err
+	}
+
+	sidecarLogConfiguration := &ecsTypes.LogConfiguration{
+		LogDriver: ecsTypes.LogDriverAwslogs,
+		Options: map[string]string{
+			"awslogs-create-group":  "true",
+			"awslogs-group":         logGroupName,
+			"awslogs-region":        region_enums.Type(region).String(),
+			"awslogs-stream-prefix": sidecarLogsStreamPrefix,
+		},
+	}
+
+	//sidecars, init containers and shared volumes from the task containers config
+	taskContainersConfig, err := getTaskContainersConfig(parameters)
+	if err != nil {
+		return "", err
+	}
+
+	containerDefinitions, volumes, err := buildTaskContainerDefinitions(containerDefinition, taskContainersConfig,
+		sidecarLogConfiguration, cpu, memory)
+	if err != nil {
+		return "", err
+	}
+	if taskContainersConfig != nil && len(taskContainersConfig.EfsVolumes) > 0 {
+		efsVolumeConfigurations, err := createEfsVolumesIfNeeded(parameters, taskContainersConfig.EfsVolumes, logsWriter)
+		if err != nil {
+			return "", err
+		}
+		for i, volume := range volumes {
+			if efsVolumeConfiguration, ok := efsVolumeConfigurations[aws.ToString(volume.Name)]; ok {
+				volumes[i].EfsVolumeConfiguration = efsVolumeConfiguration
+			}
+		}
+	}
+
+	//the task role carries the permissions ECS Exec and the linked resources need inside the containers
+	iamClient, err := cloud_api_clients.GetIamClient(parameters)
+	if err != nil {
+		return "", err
+	}
+	taskRoleArn, err := getEcsTaskRoleIfNeeded(iamClient, parameters)
+	if err != nil {
+		return "", err
+	}
+	err = syncLinkedResourcesTaskRolePolicy(iamClient, parameters, linkedResourcesPolicy)
+	if err != nil {
+		return "", err
+	}
+
 	runnerData := utils.RunnerData.Get()
 	cpuArch := ecsTypes.CPUArchitectureX8664
 	if runnerData.CpuArchEnum == cpu_architecture_enums.ARM {
@@ -870,14 +1100,14 @@ func registerTaskDefinition(parameters map[string]interface{}, ecsClient *ecs.Cl
 	}
 
 	registerTaskDefinitionInput := &ecs.RegisterTaskDefinitionInput{
-		ContainerDefinitions: []ecsTypes.ContainerDefinition{
-			containerDefinition,
-		},
-		Family:           aws.String(taskDefinitionFamilyName),
-		Cpu:              aws.String(cpu),
-		ExecutionRoleArn: aws.String(ecsTaskExecutionRoleArn),
-		Memory:           aws.String(memory),
-		NetworkMode:      ecsTypes.NetworkModeAwsvpc,
+		ContainerDefinitions: containerDefinitions,
+		Volumes:              volumes,
+		Family:               aws.String(taskDefinitionFamilyName),
+		Cpu:                  aws.String(cpu),
+		ExecutionRoleArn:     aws.String(ecsTaskExecutionRoleArn),
+		TaskRoleArn:          aws.String(taskRoleArn),
+		Memory:               aws.String(memory),
+		NetworkMode:          ecsTypes.NetworkModeAwsvpc,
 		RuntimePlatform: &ecsTypes.RuntimePlatform{
 			CpuArchitecture:       cpuArch,
 			OperatingSystemFamily: osFamily,
@@ -1028,8 +1258,26 @@ func getInternalDnsName(parameters map[string]interface{}) (string, error) {
 	return dnsName, nil
 }
 
+// getEcsServiceLoadBalancers attaches the app container's port to the
+// service's target group.
+func getEcsServiceLoadBalancers(parameters map[string]interface{}, targetGroupArn string) ([]ecsTypes.LoadBalancer, error) {
+	containerName, err := getContainerName(parameters)
+	if err != nil {
+		return nil, err
+	}
+	port, err := jobs.GetParameterValue[int64](parameters, parameters_enums.Port)
+	if err != nil {
+		return nil, err
+	}
+	return []ecsTypes.LoadBalancer{{
+		ContainerName:  aws.String(containerName),
+		ContainerPort:  aws.Int32(int32(port)),
+		TargetGroupArn: aws.String(targetGroupArn),
+	}}, nil
+}
+
 func createEcsServiceIfNeeded(parameters map[string]interface{}, ecsClient *ecs.Client,
-	ecsClusterArn, targetGroupArn, taskDefinitionArn string, logsWriter io.Writer) (ecsServiceArn string, shouldUpdateService bool, err error) {
+	ecsClusterArn string, loadBalancers []ecsTypes.LoadBalancer, taskDefinitionArn string, logsWriter io.Writer) (ecsServiceArn string, shouldUpdateService bool, err error) {
 
 	ecsServiceArnFromParams, err := jobs.GetParameterValue[string](parameters, parameters_enums.EcsServiceArn)
 	if err == nil && len(ecsServiceArnFromParams) > 0 {
@@ -1081,11 +1329,6 @@ func createEcsServiceIfNeeded(parameters map[string]interface{}, ecsClient *ecs.
 			AwsvpcConfiguration: awsVpcConfiguration,
 		}
 
-		containerName, err := getContainerName(parameters)
-		if err != nil {
-			return "", false, err
-		}
-
 		port, err := jobs.GetParameterValue[int64](parameters, parameters_enums.Port)
 		if err != nil {
 			return "", false, err
@@ -1106,16 +1349,14 @@ func createEcsServiceIfNeeded(parameters map[string]interface{}, ecsClient *ecs.
 			return "", false, err
 		}
 
-		var loadBalancers []ecsTypes.LoadBalancer
 		var healthCheckGracePeriod *int32
-		if len(targetGroupArn) > 0 {
+		if len(loadBalancers) > 0 {
 			//only needed for public web services
-			loadBalancers = []ecsTypes.LoadBalancer{{
-				ContainerName:  aws.String(containerName),
-				ContainerPort:  aws.Int32(int32(port)),
-				TargetGroupArn: aws.String(targetGroupArn),
-			}}
-			healthCheckGracePeriod = aws.Int32(30)
+			taskContainersConfig, err := getTaskContainersConfig(parameters)
+			if err != nil {
+				return "", false, err
+			}
+			healthCheckGracePeriod = aws.Int32(getHealthCheckGracePeriodSeconds(taskContainersConfig))
 		}
 
 		createServiceInput := &ecs.CreateServiceInput{
@@ -1125,8 +1366,8 @@ func createEcsServiceIfNeeded(parameters map[string]interface{}, ecsClient *ecs.
 			Cluster:                       aws.String(ecsClusterArn),
 			DesiredCount:                  aws.Int32(1),
 			EnableECSManagedTags:          false,
-			EnableExecuteCommand:          false,
-			HealthCheckGracePeriodSeconds: healthCheckGracePeriod,     //use the startPeriod in the task definition health check parameters if this is empty - 30 seconds for now
+			EnableExecuteCommand:          true,
+			HealthCheckGracePeriodSeconds: healthCheckGracePeriod,     //startPeriod of the app container health check, 30 seconds without one
 			LaunchType:                    ecsTypes.LaunchTypeFargate, //use capacity provider for fargate spot
 			LoadBalancers:                 loadBalancers,
 			NetworkConfiguration:          networkConfiguration,
@@ -1206,7 +1447,7 @@ func createEcsServiceIfNeeded(parameters map[string]interface{}, ecsClient *ecs.
 }
 
 func updateEcsService(parameters map[string]interface{}, ecsClient *ecs.Client, ecsClusterArn string,
-	taskDefinitionArn string, logsWriter io.Writer) error {
+	loadBalancers []ecsTypes.LoadBalancer, taskDefinitionArn string, logsWriter io.Writer) error {
 	//TODO desired count is 1 for now.
 	ecsServiceName, err := aws_utils.GetEcsServiceName(parameters)
 	if err != nil {
@@ -1218,6 +1459,17 @@ func updateEcsService(parameters map[string]interface{}, ecsClient *ecs.Client,
 		DesiredCount:   aws.Int32(1),
 		TaskDefinition: aws.String(taskDefinitionArn),
 		PropagateTags:  ecsTypes.PropagateTagsTaskDefinition,
+		//services created before ECS Exec was enabled pick it up on their next deploy
+		EnableExecuteCommand: aws.Bool(true),
+	}
+	if len(loadBalancers) > 0 {
+		//listeners and the app health check start period might have changed with this deployment
+		updateServiceInput.LoadBalancers = loadBalancers
+		taskContainersConfig, err := getTaskContainersConfig(parameters)
+		if err != nil {
+			return err
+		}
+		updateServiceInput.HealthCheckGracePeriodSeconds = aws.Int32(getHealthCheckGracePeriodSeconds(taskContainersConfig))
 	}
 	_, err = ecsClient.UpdateService(context.TODO(), updateServiceInput)
 
@@ -1295,11 +1547,15 @@ func (d *DeployAwsWebService) Run(parameters map[string]interface{}, logsWriter
 	if err != nil {
 		return parameters, err
 	}
+	err = syncTargetGroupSettings(parameters, elbClient, targetGroupArn, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
 	ecsClient, err := cloud_api_clients.GetEcsClient(parameters)
 	if err != nil {
 		return parameters, err
 	}
-	taskDefinitionArn, err := registerTaskDefinition(parameters, ecsClient)
+	taskDefinitionArn, err := registerTaskDefinition(parameters, ecsClient, logsWriter)
 	if err != nil {
 		return parameters, err
 	}
@@ -1307,12 +1563,16 @@ func (d *DeployAwsWebService) Run(parameters map[string]interface{}, logsWriter
 	if err != nil {
 		return parameters, err
 	}
-	_, shouldUpdateService, err := createEcsServiceIfNeeded(parameters, ecsClient, ecsClusterArn, targetGroupArn, taskDefinitionArn, logsWriter)
+	loadBalancers, err := getEcsServiceLoadBalancers(parameters, targetGroupArn)
+	if err != nil {
+		return parameters, err
+	}
+	_, shouldUpdateService, err := createEcsServiceIfNeeded(parameters, ecsClient, ecsClusterArn, loadBalancers, taskDefinitionArn, logsWriter)
 	if err != nil {
 		return parameters, err
 	}
 	if shouldUpdateService {
-		err = updateEcsService(parameters, ecsClient, ecsClusterArn, taskDefinitionArn, logsWriter)
+		err = updateEcsService(parameters, ecsClient, ecsClusterArn, loadBalancers, taskDefinitionArn, logsWriter)
 		if err != nil {
 			return parameters, err
 		}
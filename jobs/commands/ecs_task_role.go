@@ -0,0 +1,154 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamTypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+)
+
+const ecsExecTaskRolePolicyName = "ecs-exec"
+
+func getEcsTaskRoleName(parameters map[string]interface{}) (string, error) {
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return "", err
+	}
+	//tRole-<deploymentID>
+	return fmt.Sprintf("tRole-%s", deploymentID), nil
+}
+
+// getEcsExecTaskRolePolicy lets the SSM agent ECS injects into every container
+// open the channels an ECS Exec session runs over.
+func getEcsExecTaskRolePolicy() string {
+	return `{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Effect": "Allow",
+      "Action": [
+        "ssmmessages:CreateControlChannel",
+        "ssmmessages:CreateDataChannel",
+        "ssmmessages:OpenControlChannel",
+        "ssmmessages:OpenDataChannel"
+      ],
+      "Resource": "*"
+    }
+  ]
+}`
+}
+
+// getEcsTaskRoleIfNeeded returns the deployment's own task role, the role the
+// app's code runs as. Unlike the task execution role it's per deployment so
+// permissions granted to one service don't leak to others.
+func getEcsTaskRoleIfNeeded(iamClient *iam.Client, parameters map[string]interface{}) (string, error) {
+	taskRoleName, err := getEcsTaskRoleName(parameters)
+	if err != nil {
+		return "", err
+	}
+	var taskRoleArn string
+	getRoleOutput, err := iamClient.GetRole(context.TODO(), &iam.GetRoleInput{RoleName: aws.String(taskRoleName)})
+	var noSuchEntityException *iamTypes.NoSuchEntityException
+	if err != nil && !errors.As(err, &noSuchEntityException) {
+		return "", err
+	}
+	if err == nil && getRoleOutput.Role != nil {
+		taskRoleArn = aws.ToString(getRoleOutput.Role.Arn)
+	} else {
+		createRoleOutput, err := iamClient.CreateRole(context.TODO(), &iam.CreateRoleInput{
+			AssumeRolePolicyDocument: aws.String(getEcsTaskTrustPolicyForTaskExecutionRole()),
+			RoleName:                 aws.String(taskRoleName),
+			Tags: []iamTypes.Tag{
+				{
+					Key:   aws.String("Name"),
+					Value: aws.String(taskRoleName),
+				},
+				{
+					Key:   aws.String("created by"),
+					Value: aws.String("deployment.io"),
+				},
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+		taskRoleArn = aws.ToString(createRoleOutput.Role.Arn)
+	}
+	_, err = iamClient.PutRolePolicy(context.TODO(), &iam.PutRolePolicyInput{
+		PolicyDocument: aws.String(getEcsExecTaskRolePolicy()),
+		PolicyName:     aws.String(ecsExecTaskRolePolicyName),
+		RoleName:       aws.String(taskRoleName),
+	})
+	if err != nil {
+		return "", err
+	}
+	return taskRoleArn, nil
+}
+
+// deleteEcsTaskRoleIfNeeded removes the deployment's task role with its inline
+// and attached policies.
+func deleteEcsTaskRoleIfNeeded(parameters map[string]interface{}, logsWriter io.Writer) error {
+	taskRoleName, err := getEcsTaskRoleName(parameters)
+	if err != nil {
+		return err
+	}
+	iamClient, err := cloud_api_clients.GetIamClient(parameters)
+	if err != nil {
+		return err
+	}
+	return deleteRoleIfNeeded(iamClient, taskRoleName, logsWriter)
+}
+
+// deleteRoleIfNeeded deletes a role created for a deployment together with
+// its inline and attached policies. A missing role is not an error.
+func deleteRoleIfNeeded(iamClient *iam.Client, roleName string, logsWriter io.Writer) error {
+	listRolePoliciesOutput, err := iamClient.ListRolePolicies(context.TODO(), &iam.ListRolePoliciesInput{
+		RoleName: aws.String(roleName),
+	})
+	var noSuchEntityException *iamTypes.NoSuchEntityException
+	if errors.As(err, &noSuchEntityException) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Deleting role: %s\n", roleName))
+	for _, policyName := range listRolePoliciesOutput.PolicyNames {
+		_, err = iamClient.DeleteRolePolicy(context.TODO(), &iam.DeleteRolePolicyInput{
+			PolicyName: aws.String(policyName),
+			RoleName:   aws.String(roleName),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	listAttachedRolePoliciesOutput, err := iamClient.ListAttachedRolePolicies(context.TODO(), &iam.ListAttachedRolePoliciesInput{
+		RoleName: aws.String(roleName),
+	})
+	if err != nil {
+		return err
+	}
+	for _, attachedPolicy := range listAttachedRolePoliciesOutput.AttachedPolicies {
+		_, err = iamClient.DetachRolePolicy(context.TODO(), &iam.DetachRolePolicyInput{
+			PolicyArn: attachedPolicy.PolicyArn,
+			RoleName:  aws.String(roleName),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	_, err = iamClient.DeleteRole(context.TODO(), &iam.DeleteRoleInput{
+		RoleName: aws.String(roleName),
+	})
+	if err != nil && !errors.As(err, &noSuchEntityException) {
+		return err
+	}
+	return nil
+}
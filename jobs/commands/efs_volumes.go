@@ -0,0 +1,664 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/backup"
+	backupTypes "github.com/aws/aws-sdk-go-v2/service/backup/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/efs"
+	efsTypes "github.com/aws/aws-sdk-go-v2/service/efs/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamTypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/enums/iam_policy_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/region_enums"
+	"github.com/deployment-io/deployment-runner-kit/iam_policies"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	"github.com/deployment-io/deployment-runner/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// efsPosixID is the uid and gid every access point enforces, so files keep
+// the same owner whatever user the image runs as.
+const efsPosixID int64 = 1000
+
+const (
+	efsRetentionRetain   = "retain"
+	efsRetentionSnapshot = "snapshot"
+)
+
+const efsBackupVaultName = "deployment-io"
+
+// efsVolumeSpec is a persistent volume backed by the deployment's EFS file
+// system. Each volume gets its own access point rooted at /<name>.
+type efsVolumeSpec struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"` // where the app container mounts the volume, optional
+	ReadOnly bool   `json:"read_only"`
+}
+
+// validateEfsVolumes checks the names are usable as task volumes and access
+// point client tokens, which are limited to 64 characters.
+func validateEfsVolumes(efsVolumes []efsVolumeSpec) error {
+	names := map[string]bool{}
+	for _, efsVolume := range efsVolumes {
+		if !containerNameRegex.MatchString(efsVolume.Name) || len(efsVolume.Name) > 32 {
+			return fmt.Errorf("invalid efs volume name: %q", efsVolume.Name)
+		}
+		if names[efsVolume.Name] {
+			return fmt.Errorf("duplicate efs volume: %s", efsVolume.Name)
+		}
+		names[efsVolume.Name] = true
+		if len(efsVolume.Path) > 0 && !strings.HasPrefix(efsVolume.Path, "/") {
+			return fmt.Errorf("efs volume %s path must be absolute", efsVolume.Name)
+		}
+	}
+	return nil
+}
+
+func getEfsFileSystemName(parameters map[string]interface{}) (string, error) {
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return "", err
+	}
+	//efs-<deploymentID>
+	return fmt.Sprintf("efs-%s", deploymentID), nil
+}
+
+func getEfsSecurityGroupName(parameters map[string]interface{}) (string, error) {
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return "", err
+	}
+	//efssg-<deploymentID>
+	return fmt.Sprintf("efssg-%s", deploymentID), nil
+}
+
+func getEfsAccessPointName(parameters map[string]interface{}, volumeName string) (string, error) {
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return "", err
+	}
+	//efsap-<deploymentID>-<volumeName>
+	return fmt.Sprintf("efsap-%s-%s", deploymentID, volumeName), nil
+}
+
+func getBackupRoleName(parameters map[string]interface{}) (string, error) {
+	organizationID, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIDNamespace)
+	if err != nil {
+		return "", err
+	}
+	region, err := jobs.GetParameterValue[int64](parameters, parameters_enums.Region)
+	if err != nil {
+		return "", err
+	}
+	//bRole-<organizationID>-<region>
+	return fmt.Sprintf("bRole-%s-%s", organizationID, region_enums.Type(region).String()), nil
+}
+
+func getEfsRetentionPolicy(parameters map[string]interface{}) (string, error) {
+	retentionPolicy, err := jobs.GetParameterValue[string](parameters, parameters_enums.EfsRetentionPolicy)
+	if err != nil || len(retentionPolicy) == 0 {
+		return efsRetentionRetain, nil
+	}
+	switch retentionPolicy {
+	case efsRetentionRetain, efsRetentionSnapshot:
+		return retentionPolicy, nil
+	}
+	return "", fmt.Errorf("unsupported efs retention policy %s", retentionPolicy)
+}
+
+func addEfsPolicyForDeploymentRunner(parameters map[string]interface{}) error {
+	runnerData := utils.RunnerData.Get()
+	organizationID, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIDNamespace)
+	if err != nil {
+		return err
+	}
+	return iam_policies.AddAwsPolicyForDeploymentRunner(iam_policy_enums.AwsEfsVolumes,
+		runnerData.OsType.String(), runnerData.CpuArchEnum.String(), organizationID, runnerData.RunnerRegion, runnerData.Mode, runnerData.TargetCloud)
+}
+
+func getEfsFileSystem(efsClient *efs.Client, fileSystemName string) (*efsTypes.FileSystemDescription, error) {
+	describeFileSystemsOutput, err := efsClient.DescribeFileSystems(context.TODO(), &efs.DescribeFileSystemsInput{
+		CreationToken: aws.String(fileSystemName),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(describeFileSystemsOutput.FileSystems) == 0 {
+		return nil, nil
+	}
+	return &describeFileSystemsOutput.FileSystems[0], nil
+}
+
+func waitForEfsFileSystemAvailable(efsClient *efs.Client, fileSystemId string) error {
+	for i := 0; i < 60; i++ {
+		describeFileSystemsOutput, err := efsClient.DescribeFileSystems(context.TODO(), &efs.DescribeFileSystemsInput{
+			FileSystemId: aws.String(fileSystemId),
+		})
+		if err != nil {
+			return err
+		}
+		if len(describeFileSystemsOutput.FileSystems) > 0 &&
+			describeFileSystemsOutput.FileSystems[0].LifeCycleState == efsTypes.LifeCycleStateAvailable {
+			return nil
+		}
+		time.Sleep(5 * time.Second)
+	}
+	return fmt.Errorf("timed out waiting for efs file system %s", fileSystemId)
+}
+
+func createEfsFileSystemIfNeeded(parameters map[string]interface{}, efsClient *efs.Client, logsWriter io.Writer) (string, error) {
+	fileSystemName, err := getEfsFileSystemName(parameters)
+	if err != nil {
+		return "", err
+	}
+	fileSystem, err := getEfsFileSystem(efsClient, fileSystemName)
+	if err != nil {
+		return "", err
+	}
+	var fileSystemId string
+	if fileSystem != nil {
+		fileSystemId = aws.ToString(fileSystem.FileSystemId)
+		if fileSystem.LifeCycleState == efsTypes.LifeCycleStateAvailable {
+			return fileSystemId, nil
+		}
+	} else {
+		io.WriteString(logsWriter, fmt.Sprintf("Creating EFS file system: %s\n", fileSystemName))
+		createFileSystemOutput, err := efsClient.CreateFileSystem(context.TODO(), &efs.CreateFileSystemInput{
+			CreationToken:   aws.String(fileSystemName),
+			Encrypted:       aws.Bool(true),
+			PerformanceMode: efsTypes.PerformanceModeGeneralPurpose,
+			ThroughputMode:  efsTypes.ThroughputModeElastic,
+			Tags: []efsTypes.Tag{
+				{
+					Key:   aws.String("Name"),
+					Value: aws.String(fileSystemName),
+				},
+				{
+					Key:   aws.String("created by"),
+					Value: aws.String("deployment.io"),
+				},
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+		fileSystemId = aws.ToString(createFileSystemOutput.FileSystemId)
+	}
+	err = waitForEfsFileSystemAvailable(efsClient, fileSystemId)
+	if err != nil {
+		return "", err
+	}
+	return fileSystemId, nil
+}
+
+// createEfsSecurityGroupIfNeeded creates the security group of the mount
+// targets. It allows NFS from the default VPC security group the tasks run in.
+func createEfsSecurityGroupIfNeeded(parameters map[string]interface{}, ec2Client *ec2.Client) (string, error) {
+	efsSecurityGroupName, err := getEfsSecurityGroupName(parameters)
+	if err != nil {
+		return "", err
+	}
+	return createSecurityGroupForPortIfNeeded(parameters, ec2Client, efsSecurityGroupName, "efs", "nfs from tasks", 2049)
+}
+
+// createEfsMountTargetsIfNeeded adds a mount target in every private subnet
+// the tasks can be placed in and waits for them to be usable.
+func createEfsMountTargetsIfNeeded(parameters map[string]interface{}, efsClient *efs.Client, fileSystemId, efsSecurityGroupId string,
+	logsWriter io.Writer) error {
+	privateSubnets, err := jobs.GetParameterValue[primitive.A](parameters, parameters_enums.PrivateSubnets)
+	if err != nil {
+		return err
+	}
+	describeMountTargetsOutput, err := efsClient.DescribeMountTargets(context.TODO(), &efs.DescribeMountTargetsInput{
+		FileSystemId: aws.String(fileSystemId),
+	})
+	if err != nil {
+		return err
+	}
+	subnetsWithMountTarget := map[string]bool{}
+	for _, mountTarget := range describeMountTargetsOutput.MountTargets {
+		subnetsWithMountTarget[aws.ToString(mountTarget.SubnetId)] = true
+	}
+	for _, privateSubnet := range privateSubnets {
+		subnetId, ok := privateSubnet.(string)
+		if !ok || subnetsWithMountTarget[subnetId] {
+			continue
+		}
+		io.WriteString(logsWriter, fmt.Sprintf("Creating EFS mount target in subnet: %s\n", subnetId))
+		_, err = efsClient.CreateMountTarget(context.TODO(), &efs.CreateMountTargetInput{
+			FileSystemId:   aws.String(fileSystemId),
+			SubnetId:       aws.String(subnetId),
+			SecurityGroups: []string{efsSecurityGroupId},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < 60; i++ {
+		describeMountTargetsOutput, err = efsClient.DescribeMountTargets(context.TODO(), &efs.DescribeMountTargetsInput{
+			FileSystemId: aws.String(fileSystemId),
+		})
+		if err != nil {
+			return err
+		}
+		available := true
+		for _, mountTarget := range describeMountTargetsOutput.MountTargets {
+			if mountTarget.LifeCycleState != efsTypes.LifeCycleStateAvailable {
+				available = false
+				break
+			}
+		}
+		if available {
+			return nil
+		}
+		time.Sleep(5 * time.Second)
+	}
+	return fmt.Errorf("timed out waiting for efs mount targets of %s", fileSystemId)
+}
+
+func createEfsAccessPointIfNeeded(parameters map[string]interface{}, efsClient *efs.Client, fileSystemId string,
+	accessPoints []efsTypes.AccessPointDescription, volumeName string) (string, error) {
+	accessPointName, err := getEfsAccessPointName(parameters, volumeName)
+	if err != nil {
+		return "", err
+	}
+	for _, accessPoint := range accessPoints {
+		if aws.ToString(accessPoint.Name) == accessPointName {
+			return aws.ToString(accessPoint.AccessPointId), nil
+		}
+	}
+	createAccessPointOutput, err := efsClient.CreateAccessPoint(context.TODO(), &efs.CreateAccessPointInput{
+		ClientToken:  aws.String(accessPointName),
+		FileSystemId: aws.String(fileSystemId),
+		PosixUser: &efsTypes.PosixUser{
+			Gid: aws.Int64(efsPosixID),
+			Uid: aws.Int64(efsPosixID),
+		},
+		RootDirectory: &efsTypes.RootDirectory{
+			CreationInfo: &efsTypes.CreationInfo{
+				OwnerGid:    aws.Int64(efsPosixID),
+				OwnerUid:    aws.Int64(efsPosixID),
+				Permissions: aws.String("755"),
+			},
+			Path: aws.String("/" + volumeName),
+		},
+		Tags: []efsTypes.Tag{
+			{
+				Key:   aws.String("Name"),
+				Value: aws.String(accessPointName),
+			},
+			{
+				Key:   aws.String("created by"),
+				Value: aws.String("deployment.io"),
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	accessPointId := aws.ToString(createAccessPointOutput.AccessPointId)
+	for i := 0; i < 30; i++ {
+		describeAccessPointsOutput, err := efsClient.DescribeAccessPoints(context.TODO(), &efs.DescribeAccessPointsInput{
+			AccessPointId: aws.String(accessPointId),
+		})
+		if err != nil {
+			return "", err
+		}
+		if len(describeAccessPointsOutput.AccessPoints) > 0 &&
+			describeAccessPointsOutput.AccessPoints[0].LifeCycleState == efsTypes.LifeCycleStateAvailable {
+			return accessPointId, nil
+		}
+		time.Sleep(2 * time.Second)
+	}
+	return "", fmt.Errorf("timed out waiting for efs access point %s", accessPointName)
+}
+
+// getStaleEfsAccessPointIds returns the access points of volumes that were
+// removed from the service.
+func getStaleEfsAccessPointIds(accessPoints []efsTypes.AccessPointDescription, accessPointNamePrefix string,
+	efsVolumes []efsVolumeSpec) []string {
+	volumeNames := map[string]bool{}
+	for _, efsVolume := range efsVolumes {
+		volumeNames[efsVolume.Name] = true
+	}
+	var staleAccessPointIds []string
+	for _, accessPoint := range accessPoints {
+		accessPointName := aws.ToString(accessPoint.Name)
+		if !strings.HasPrefix(accessPointName, accessPointNamePrefix) {
+			continue
+		}
+		if !volumeNames[strings.TrimPrefix(accessPointName, accessPointNamePrefix)] {
+			staleAccessPointIds = append(staleAccessPointIds, aws.ToString(accessPoint.AccessPointId))
+		}
+	}
+	return staleAccessPointIds
+}
+
+// deleteStaleEfsAccessPoints deletes the access points of removed volumes.
+// The files under the volume's directory stay on the file system and come
+// back if a volume with the same name is added again.
+func deleteStaleEfsAccessPoints(parameters map[string]interface{}, efsClient *efs.Client,
+	accessPoints []efsTypes.AccessPointDescription, efsVolumes []efsVolumeSpec, logsWriter io.Writer) error {
+	//efsap-<deploymentID>-
+	accessPointNamePrefix, err := getEfsAccessPointName(parameters, "")
+	if err != nil {
+		return err
+	}
+	for _, accessPointId := range getStaleEfsAccessPointIds(accessPoints, accessPointNamePrefix, efsVolumes) {
+		io.WriteString(logsWriter, fmt.Sprintf("Deleting EFS access point of a removed volume: %s\n", accessPointId))
+		_, err = efsClient.DeleteAccessPoint(context.TODO(), &efs.DeleteAccessPointInput{
+			AccessPointId: aws.String(accessPointId),
+		})
+		var accessPointNotFound *efsTypes.AccessPointNotFound
+		if err != nil && !errors.As(err, &accessPointNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// createEfsVolumesIfNeeded makes sure the deployment's file system, its mount
+// targets and an access point per volume exist, and returns the task volume
+// configuration for each volume name. Access points of removed volumes are
+// deleted. The file system is kept across deploys.
+func createEfsVolumesIfNeeded(parameters map[string]interface{}, efsVolumes []efsVolumeSpec,
+	logsWriter io.Writer) (map[string]*ecsTypes.EFSVolumeConfiguration, error) {
+	err := addEfsPolicyForDeploymentRunner(parameters)
+	if err != nil {
+		return nil, err
+	}
+	efsClient, err := cloud_api_clients.GetEfsClient(parameters)
+	if err != nil {
+		return nil, err
+	}
+	ec2Client, err := cloud_api_clients.GetEC2Client(parameters)
+	if err != nil {
+		return nil, err
+	}
+	fileSystemId, err := createEfsFileSystemIfNeeded(parameters, efsClient, logsWriter)
+	if err != nil {
+		return nil, err
+	}
+	efsSecurityGroupId, err := createEfsSecurityGroupIfNeeded(parameters, ec2Client)
+	if err != nil {
+		return nil, err
+	}
+	err = createEfsMountTargetsIfNeeded(parameters, efsClient, fileSystemId, efsSecurityGroupId, logsWriter)
+	if err != nil {
+		return nil, err
+	}
+	describeAccessPointsOutput, err := efsClient.DescribeAccessPoints(context.TODO(), &efs.DescribeAccessPointsInput{
+		FileSystemId: aws.String(fileSystemId),
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = deleteStaleEfsAccessPoints(parameters, efsClient, describeAccessPointsOutput.AccessPoints, efsVolumes, logsWriter)
+	if err != nil {
+		return nil, err
+	}
+	efsVolumeConfigurations := map[string]*ecsTypes.EFSVolumeConfiguration{}
+	for _, efsVolume := range efsVolumes {
+		accessPointId, err := createEfsAccessPointIfNeeded(parameters, efsClient, fileSystemId,
+			describeAccessPointsOutput.AccessPoints, efsVolume.Name)
+		if err != nil {
+			return nil, err
+		}
+		efsVolumeConfigurations[efsVolume.Name] = &ecsTypes.EFSVolumeConfiguration{
+			FileSystemId:      aws.String(fileSystemId),
+			TransitEncryption: ecsTypes.EFSTransitEncryptionEnabled,
+			AuthorizationConfig: &ecsTypes.EFSAuthorizationConfig{
+				AccessPointId: aws.String(accessPointId),
+				Iam:           ecsTypes.EFSAuthorizationConfigIAMDisabled,
+			},
+		}
+	}
+	return efsVolumeConfigurations, nil
+}
+
+func getBackupTrustPolicy() string {
+	return `{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Effect": "Allow",
+      "Principal": {
+        "Service": "backup.amazonaws.com"
+      },
+      "Action": "sts:AssumeRole"
+    }
+  ]
+}`
+}
+
+func getBackupRoleIfNeeded(iamClient *iam.Client, parameters map[string]interface{}) (string, error) {
+	backupRoleName, err := getBackupRoleName(parameters)
+	if err != nil {
+		return "", err
+	}
+	getRoleOutput, err := iamClient.GetRole(context.TODO(), &iam.GetRoleInput{RoleName: aws.String(backupRoleName)})
+	var noSuchEntityException *iamTypes.NoSuchEntityException
+	if err != nil && !errors.As(err, &noSuchEntityException) {
+		return "", err
+	}
+	if err == nil && getRoleOutput.Role != nil {
+		return aws.ToString(getRoleOutput.Role.Arn), nil
+	}
+	createRoleOutput, err := iamClient.CreateRole(context.TODO(), &iam.CreateRoleInput{
+		AssumeRolePolicyDocument: aws.String(getBackupTrustPolicy()),
+		RoleName:                 aws.String(backupRoleName),
+		Tags: []iamTypes.Tag{
+			{
+				Key:   aws.String("Name"),
+				Value: aws.String(backupRoleName),
+			},
+			{
+				Key:   aws.String("created by"),
+				Value: aws.String("deployment.io"),
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	_, err = iamClient.AttachRolePolicy(context.TODO(), &iam.AttachRolePolicyInput{
+		PolicyArn: aws.String("arn:aws:iam::aws:policy/service-role/AWSBackupServiceRolePolicyForBackup"),
+		RoleName:  aws.String(backupRoleName),
+	})
+	if err != nil {
+		return "", err
+	}
+	//new roles take a few seconds before AWS Backup can assume them
+	time.Sleep(10 * time.Second)
+	return aws.ToString(createRoleOutput.Role.Arn), nil
+}
+
+// snapshotEfsFileSystem takes an on-demand AWS Backup of the file system and
+// waits for it to complete so it is safe to delete the file system after.
+func snapshotEfsFileSystem(parameters map[string]interface{}, fileSystem *efsTypes.FileSystemDescription, logsWriter io.Writer) error {
+	iamClient, err := cloud_api_clients.GetIamClient(parameters)
+	if err != nil {
+		return err
+	}
+	backupRoleArn, err := getBackupRoleIfNeeded(iamClient, parameters)
+	if err != nil {
+		return err
+	}
+	backupClient, err := cloud_api_clients.GetBackupClient(parameters)
+	if err != nil {
+		return err
+	}
+	_, err = backupClient.CreateBackupVault(context.TODO(), &backup.CreateBackupVaultInput{
+		BackupVaultName: aws.String(efsBackupVaultName),
+		BackupVaultTags: map[string]string{
+			"created by": "deployment.io",
+		},
+	})
+	var alreadyExistsException *backupTypes.AlreadyExistsException
+	if err != nil && !errors.As(err, &alreadyExistsException) {
+		return err
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Backing up EFS file system: %s\n", aws.ToString(fileSystem.Name)))
+	startBackupJobOutput, err := backupClient.StartBackupJob(context.TODO(), &backup.StartBackupJobInput{
+		BackupVaultName:  aws.String(efsBackupVaultName),
+		IamRoleArn:       aws.String(backupRoleArn),
+		ResourceArn:      fileSystem.FileSystemArn,
+		IdempotencyToken: fileSystem.Name,
+		RecoveryPointTags: map[string]string{
+			"Name":       aws.ToString(fileSystem.Name),
+			"created by": "deployment.io",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	backupJobId := aws.ToString(startBackupJobOutput.BackupJobId)
+	for i := 0; i < 240; i++ {
+		describeBackupJobOutput, err := backupClient.DescribeBackupJob(context.TODO(), &backup.DescribeBackupJobInput{
+			BackupJobId: aws.String(backupJobId),
+		})
+		if err != nil {
+			return err
+		}
+		switch describeBackupJobOutput.State {
+		case backupTypes.BackupJobStateCompleted:
+			io.WriteString(logsWriter, fmt.Sprintf("EFS backup completed: %s\n", aws.ToString(describeBackupJobOutput.RecoveryPointArn)))
+			return nil
+		case backupTypes.BackupJobStateFailed, backupTypes.BackupJobStateAborted, backupTypes.BackupJobStateExpired:
+			return fmt.Errorf("efs backup job %s %s: %s", backupJobId, describeBackupJobOutput.State,
+				aws.ToString(describeBackupJobOutput.StatusMessage))
+		}
+		time.Sleep(15 * time.Second)
+	}
+	return fmt.Errorf("timed out waiting for efs backup job %s", backupJobId)
+}
+
+// deleteEfsFileSystem removes the access points and mount targets before the
+// file system itself, then the security group once the mount targets are gone.
+func deleteEfsFileSystem(parameters map[string]interface{}, efsClient *efs.Client, fileSystemId string, logsWriter io.Writer) error {
+	describeAccessPointsOutput, err := efsClient.DescribeAccessPoints(context.TODO(), &efs.DescribeAccessPointsInput{
+		FileSystemId: aws.String(fileSystemId),
+	})
+	if err != nil {
+		return err
+	}
+	for _, accessPoint := range describeAccessPointsOutput.AccessPoints {
+		_, err = efsClient.DeleteAccessPoint(context.TODO(), &efs.DeleteAccessPointInput{
+			AccessPointId: accessPoint.AccessPointId,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	describeMountTargetsOutput, err := efsClient.DescribeMountTargets(context.TODO(), &efs.DescribeMountTargetsInput{
+		FileSystemId: aws.String(fileSystemId),
+	})
+	if err != nil {
+		return err
+	}
+	for _, mountTarget := range describeMountTargetsOutput.MountTargets {
+		_, err = efsClient.DeleteMountTarget(context.TODO(), &efs.DeleteMountTargetInput{
+			MountTargetId: mountTarget.MountTargetId,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	mountTargetsDeleted := false
+	for i := 0; i < 60; i++ {
+		describeMountTargetsOutput, err = efsClient.DescribeMountTargets(context.TODO(), &efs.DescribeMountTargetsInput{
+			FileSystemId: aws.String(fileSystemId),
+		})
+		if err != nil {
+			return err
+		}
+		if len(describeMountTargetsOutput.MountTargets) == 0 {
+			mountTargetsDeleted = true
+			break
+		}
+		time.Sleep(5 * time.Second)
+	}
+	if !mountTargetsDeleted {
+		return fmt.Errorf("timed out waiting for efs mount targets of %s to be deleted", fileSystemId)
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Deleting EFS file system: %s\n", fileSystemId))
+	_, err = efsClient.DeleteFileSystem(context.TODO(), &efs.DeleteFileSystemInput{
+		FileSystemId: aws.String(fileSystemId),
+	})
+	if err != nil {
+		return err
+	}
+	return deleteEfsSecurityGroup(parameters)
+}
+
+func deleteEfsSecurityGroup(parameters map[string]interface{}) error {
+	efsSecurityGroupName, err := getEfsSecurityGroupName(parameters)
+	if err != nil {
+		return err
+	}
+	ec2Client, err := cloud_api_clients.GetEC2Client(parameters)
+	if err != nil {
+		return err
+	}
+	//mount target network interfaces can take a while to be released
+	return deleteSecurityGroupsWithName(ec2Client, efsSecurityGroupName)
+}
+
+// deleteEfsVolumesIfNeeded applies the EfsRetentionPolicy when a service with
+// efs volumes is deleted. "retain" (the default) keeps the file system and
+// its data, "snapshot" backs it up with AWS Backup before deleting it.
+// Services without efs volumes are left alone.
+func deleteEfsVolumesIfNeeded(parameters map[string]interface{}, logsWriter io.Writer) error {
+	taskContainersConfig, err := getTaskContainersConfig(parameters)
+	if err != nil {
+		return err
+	}
+	if taskContainersConfig == nil || len(taskContainersConfig.EfsVolumes) == 0 {
+		return nil
+	}
+	retentionPolicy, err := getEfsRetentionPolicy(parameters)
+	if err != nil {
+		return err
+	}
+	err = addEfsPolicyForDeploymentRunner(parameters)
+	if err != nil {
+		return err
+	}
+	efsClient, err := cloud_api_clients.GetEfsClient(parameters)
+	if err != nil {
+		return err
+	}
+	fileSystemName, err := getEfsFileSystemName(parameters)
+	if err != nil {
+		return err
+	}
+	fileSystem, err := getEfsFileSystem(efsClient, fileSystemName)
+	if err != nil {
+		return err
+	}
+	if fileSystem == nil {
+		return nil
+	}
+	if retentionPolicy == efsRetentionRetain {
+		io.WriteString(logsWriter, fmt.Sprintf("Retaining EFS file system: %s (%s)\n", fileSystemName,
+			aws.ToString(fileSystem.FileSystemId)))
+		return nil
+	}
+	err = snapshotEfsFileSystem(parameters, fileSystem, logsWriter)
+	if err != nil {
+		return err
+	}
+	return deleteEfsFileSystem(parameters, efsClient, aws.ToString(fileSystem.FileSystemId), logsWriter)
+}
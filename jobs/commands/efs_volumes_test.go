@@ -0,0 +1,28 @@
+package commands
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	efsTypes "github.com/aws/aws-sdk-go-v2/service/efs/types"
+)
+
+// TestGetStaleEfsAccessPointIds deletes only the deployment's access points
+// of volumes that are no longer configured.
+func TestGetStaleEfsAccessPointIds(t *testing.T) {
+	accessPoints := []efsTypes.AccessPointDescription{
+		{AccessPointId: aws.String("fsap-1"), Name: aws.String("efsap-d1-data")},
+		{AccessPointId: aws.String("fsap-2"), Name: aws.String("efsap-d1-uploads")},
+		{AccessPointId: aws.String("fsap-3"), Name: aws.String("efsap-d1-data-old")},
+		{AccessPointId: aws.String("fsap-4"), Name: aws.String("manual")},
+	}
+	got := getStaleEfsAccessPointIds(accessPoints, "efsap-d1-", []efsVolumeSpec{{Name: "data"}})
+	if want := []string{"fsap-2", "fsap-3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("stale access points = %v, want %v", got, want)
+	}
+	if got = getStaleEfsAccessPointIds(accessPoints, "efsap-d1-", []efsVolumeSpec{{Name: "data"}, {Name: "uploads"},
+		{Name: "data-old"}}); len(got) != 0 {
+		t.Errorf("stale access points = %v, want none", got)
+	}
+}
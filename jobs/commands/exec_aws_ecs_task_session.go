@@ -0,0 +1,432 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/enums/iam_policy_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/region_enums"
+	"github.com/deployment-io/deployment-runner-kit/iam_policies"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
+	"github.com/deployment-io/deployment-runner/utils"
+	"github.com/deployment-io/deployment-runner/utils/aws_utils"
+)
+
+const (
+	defaultExecSessionIdleTimeout = 20 * time.Minute
+	defaultExecSessionCommand     = "/bin/sh"
+	// execOutputSettle is how long the shell has to be quiet before its output
+	// is closed as one message and the turn handed back to the user.
+	execOutputSettle = time.Second
+	// sessionManagerPlugin opens the SSM data channel ECS Exec runs over,
+	// the same binary `aws ecs execute-command` shells out to.
+	sessionManagerPlugin = "session-manager-plugin"
+)
+
+// ExecAwsEcsTaskSession opens an interactive ECS Exec session to a running task
+// of the deployment and bridges it to the browser with the same poll/forward
+// model as RunAssistantSession: the user's input is pulled by an inputPump and
+// written to the shell's stdin, the shell's output is written as output
+// records that a messageForwarder streams to deployment-server. Every input
+// and output is also written to the job log as the session transcript. The
+// session ends when the shell exits, when the user stops the job, or after
+// ExecSessionIdleTimeoutMinutes without input or output.
+type ExecAwsEcsTaskSession struct {
+	stopSignal <-chan struct{}
+}
+
+// SetStopSignal satisfies jobs.StoppableCommand.
+func (e *ExecAwsEcsTaskSession) SetStopSignal(stop <-chan struct{}) {
+	e.stopSignal = stop
+}
+
+// getExecSessionTarget is the SSM target of an ECS Exec session:
+// ecs:<cluster name>_<task id>_<container runtime id>.
+func getExecSessionTarget(clusterArn, taskArn, runtimeID string) string {
+	clusterName := clusterArn[strings.LastIndex(clusterArn, "/")+1:]
+	taskID := taskArn[strings.LastIndex(taskArn, "/")+1:]
+	return fmt.Sprintf("ecs:%s_%s_%s", clusterName, taskID, runtimeID)
+}
+
+func getExecSessionIdleTimeout(parameters map[string]interface{}) time.Duration {
+	idleTimeoutMinutes, err := jobs.GetParameterValue[int64](parameters, parameters_enums.ExecSessionIdleTimeoutMinutes)
+	if err != nil || idleTimeoutMinutes <= 0 {
+		return defaultExecSessionIdleTimeout
+	}
+	return time.Duration(idleTimeoutMinutes) * time.Minute
+}
+
+// getExecTask returns the task to open the session to, the one in EcsTaskArn
+// or else the first running task of the deployment's service, and checks its
+// ECS Exec agent is up.
+func getExecTask(parameters map[string]interface{}, ecsClient *ecs.Client, clusterArn string) (*ecsTypes.Task, error) {
+	taskArn, err := jobs.GetParameterValue[string](parameters, parameters_enums.EcsTaskArn)
+	if err != nil || len(taskArn) == 0 {
+		ecsServiceName, err := aws_utils.GetEcsServiceName(parameters)
+		if err != nil {
+			return nil, err
+		}
+		listTasksOutput, err := ecsClient.ListTasks(context.TODO(), &ecs.ListTasksInput{
+			Cluster:       aws.String(clusterArn),
+			ServiceName:   aws.String(ecsServiceName),
+			DesiredStatus: ecsTypes.DesiredStatusRunning,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(listTasksOutput.TaskArns) == 0 {
+			return nil, fmt.Errorf("service %s has no running tasks", ecsServiceName)
+		}
+		taskArn = listTasksOutput.TaskArns[0]
+	}
+	describeTasksOutput, err := ecsClient.DescribeTasks(context.TODO(), &ecs.DescribeTasksInput{
+		Tasks:   []string{taskArn},
+		Cluster: aws.String(clusterArn),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(describeTasksOutput.Tasks) == 0 {
+		return nil, fmt.Errorf("task %s not found", taskArn)
+	}
+	task := describeTasksOutput.Tasks[0]
+	if !task.EnableExecuteCommand {
+		return nil, fmt.Errorf("ECS Exec is not enabled on task %s, redeploy the service to enable it", taskArn)
+	}
+	return &task, nil
+}
+
+// getExecContainerRuntimeID checks the container's ECS Exec agent is running
+// and returns the runtime id the session target needs.
+func getExecContainerRuntimeID(task *ecsTypes.Task, containerName string) (string, error) {
+	for _, container := range task.Containers {
+		if aws.ToString(container.Name) != containerName {
+			continue
+		}
+		for _, managedAgent := range container.ManagedAgents {
+			if managedAgent.Name == ecsTypes.ManagedAgentNameExecuteCommandAgent {
+				if aws.ToString(managedAgent.LastStatus) != "RUNNING" {
+					return "", fmt.Errorf("ECS Exec agent of container %s is %s", containerName, aws.ToString(managedAgent.LastStatus))
+				}
+				return aws.ToString(container.RuntimeId), nil
+			}
+		}
+		return "", fmt.Errorf("container %s has no ECS Exec agent", containerName)
+	}
+	return "", fmt.Errorf("container %s not found in task %s", containerName, aws.ToString(task.TaskArn))
+}
+
+func (e *ExecAwsEcsTaskSession) Run(parameters map[string]interface{}, logsWriter io.Writer) (map[string]interface{}, error) {
+	orgID, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIdFromJob)
+	if err != nil {
+		return parameters, fmt.Errorf("organization id missing: %s", err)
+	}
+	jobID, err := jobs.GetParameterValue[string](parameters, parameters_enums.JobID)
+	if err != nil {
+		return parameters, fmt.Errorf("job id missing: %s", err)
+	}
+	organizationID, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIDNamespace)
+	if err != nil {
+		return parameters, err
+	}
+	runnerData := utils.RunnerData.Get()
+	err = iam_policies.AddAwsPolicyForDeploymentRunner(iam_policy_enums.AwsEcsExec,
+		runnerData.OsType.String(), runnerData.CpuArchEnum.String(), organizationID, runnerData.RunnerRegion, runnerData.Mode, runnerData.TargetCloud)
+	if err != nil {
+		return parameters, err
+	}
+	region, err := jobs.GetParameterValue[int64](parameters, parameters_enums.Region)
+	if err != nil {
+		return parameters, err
+	}
+	clusterArn, err := jobs.GetParameterValue[string](parameters, parameters_enums.EcsClusterArn)
+	if err != nil {
+		return parameters, err
+	}
+	ecsClient, err := cloud_api_clients.GetEcsClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	task, err := getExecTask(parameters, ecsClient, clusterArn)
+	if err != nil {
+		return parameters, err
+	}
+	containerName, err := jobs.GetParameterValue[string](parameters, parameters_enums.ExecContainerName)
+	if err != nil || len(containerName) == 0 {
+		containerName, err = getContainerName(parameters)
+		if err != nil {
+			return parameters, err
+		}
+	}
+	runtimeID, err := getExecContainerRuntimeID(task, containerName)
+	if err != nil {
+		return parameters, err
+	}
+	command, err := jobs.GetParameterValue[string](parameters, parameters_enums.ExecCommand)
+	if err != nil || len(strings.TrimSpace(command)) == 0 {
+		command = defaultExecSessionCommand
+	}
+
+	taskArn := aws.ToString(task.TaskArn)
+	executeCommandOutput, err := ecsClient.ExecuteCommand(context.TODO(), &ecs.ExecuteCommandInput{
+		Cluster:     aws.String(clusterArn),
+		Command:     aws.String(command),
+		Interactive: true,
+		Container:   aws.String(containerName),
+		Task:        aws.String(taskArn),
+	})
+	if err != nil {
+		return parameters, err
+	}
+	sessionID := aws.ToString(executeCommandOutput.Session.SessionId)
+	io.WriteString(logsWriter, fmt.Sprintf("exec session %s: task=%s container=%s command=%q\n", sessionID, taskArn, containerName, command))
+	defer func() {
+		ssmClient, err := cloud_api_clients.GetSsmClient(parameters)
+		if err != nil {
+			return
+		}
+		_, _ = ssmClient.TerminateSession(context.TODO(), &ssm.TerminateSessionInput{SessionId: aws.String(sessionID)})
+	}()
+
+	sessionJSON, err := json.Marshal(executeCommandOutput.Session)
+	if err != nil {
+		return parameters, err
+	}
+	targetJSON, err := json.Marshal(map[string]string{"Target": getExecSessionTarget(clusterArn, taskArn, runtimeID)})
+	if err != nil {
+		return parameters, err
+	}
+	regionName := region_enums.Type(region).String()
+	pluginArgs := []string{string(sessionJSON), regionName, "StartSession", "", string(targetJSON),
+		fmt.Sprintf("https://ssm.%s.amazonaws.com", regionName)}
+
+	workDirHost := commandUtils.GetSessionRepositoriesBaseDir(organizationID, jobID)
+	defer os.RemoveAll(workDirHost)
+	return parameters, e.runExecSession(orgID, jobID, workDirHost, pluginArgs, getExecSessionIdleTimeout(parameters), logsWriter)
+}
+
+// runExecSession runs the session manager plugin with the bridge loops around
+// it and blocks until the shell exits, the user stops the session or it has
+// been idle for idleTimeout. A stop or an idle timeout is not a failure.
+func (e *ExecAwsEcsTaskSession) runExecSession(orgID, jobID, workDirHost string, pluginArgs []string, idleTimeout time.Duration,
+	logsWriter io.Writer) error {
+	outDir := filepath.Join(workDirHost, ".exec-output", "messages")
+	inDir := filepath.Join(workDirHost, ".exec-input", "messages")
+	for _, d := range []string{outDir, inDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return err
+		}
+	}
+
+	var lastActivity atomic.Int64
+	lastActivity.Store(time.Now().UnixNano())
+	ow := &execOutputWriter{dir: outDir, logsWriter: logsWriter, lastActivity: &lastActivity}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sessionWallClockHardCap)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, sessionManagerPlugin, pluginArgs...)
+	cmd.Stdout = ow
+	cmd.Stderr = ow
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting %s: %s", sessionManagerPlugin, err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	mf := &messageForwarder{
+		dir:   outDir,
+		orgID: orgID, jobID: jobID, logsWriter: logsWriter, seen: map[string]bool{},
+	}
+	ip := &inputPump{
+		dir:   inDir,
+		orgID: orgID, jobID: jobID, logsWriter: logsWriter, seen: map[string]bool{},
+	}
+	ef := &execInputFeeder{dir: inDir, stdin: stdin, logsWriter: logsWriter, seen: map[string]bool{}, lastActivity: &lastActivity}
+	//hand the first turn to the user, a shell without a tty may not print a prompt
+	ow.writeRecord(outputRec{Type: "turn_end"})
+
+	stopBridge := make(chan struct{})
+	var bridgeWg sync.WaitGroup
+	bridgeWg.Add(4)
+	go func() { defer bridgeWg.Done(); runSessionTicker(stopBridge, mf.tick) }()
+	go func() { defer bridgeWg.Done(); runSessionTicker(stopBridge, ip.tick) }()
+	go func() { defer bridgeWg.Done(); runSessionTicker(stopBridge, ef.tick) }()
+	go func() { defer bridgeWg.Done(); runSessionTicker(stopBridge, ow.settle) }()
+
+	idleTicker := time.NewTicker(5 * time.Second)
+	defer idleTicker.Stop()
+	var exitErr error
+	ended := ""
+	for len(ended) == 0 {
+		select {
+		case exitErr = <-exited:
+			ended = "shell exited"
+			if ctx.Err() != nil {
+				ended = "wall clock limit reached"
+			}
+		case <-e.stopSignal:
+			ended = "stopped by user"
+		case <-idleTicker.C:
+			if time.Since(time.Unix(0, lastActivity.Load())) > idleTimeout {
+				ended = fmt.Sprintf("idle for %s", idleTimeout)
+			}
+		}
+	}
+	if ended != "shell exited" {
+		_ = stdin.Close()
+		cancel()
+		<-exited
+		exitErr = nil
+	}
+	close(stopBridge)
+	bridgeWg.Wait()
+	ef.tick()
+	ow.writeRecord(outputRec{Type: "chunk", Text: fmt.Sprintf("\n[session ended: %s]\n", ended)})
+	ow.settleNow()
+	mf.tick() // final drain of any buffered output
+	io.WriteString(logsWriter, fmt.Sprintf("exec session ended: %s\n", ended))
+
+	var pluginExitErr *exec.ExitError
+	if errors.As(exitErr, &pluginExitErr) {
+		return fmt.Errorf("%s exited with code %d", sessionManagerPlugin, pluginExitErr.ExitCode())
+	}
+	return exitErr
+}
+
+// execOutputWriter receives the shell's stdout and stderr and writes each read
+// as a "chunk" output record for the messageForwarder, and to the transcript.
+// settle closes the message and the turn once the shell has been quiet for
+// execOutputSettle, so the user can type the next command.
+type execOutputWriter struct {
+	mu           sync.Mutex
+	dir          string
+	logsWriter   io.Writer
+	lastActivity *atomic.Int64
+	seq          int
+	pending      bool
+	lastWrite    time.Time
+}
+
+func (ow *execOutputWriter) Write(p []byte) (int, error) {
+	ow.lastActivity.Store(time.Now().UnixNano())
+	io.WriteString(ow.logsWriter, string(p))
+	ow.mu.Lock()
+	defer ow.mu.Unlock()
+	ow.writeRecordLocked(outputRec{Type: "chunk", Text: string(p)})
+	ow.pending = true
+	ow.lastWrite = time.Now()
+	return len(p), nil
+}
+
+func (ow *execOutputWriter) writeRecord(rec outputRec) {
+	ow.mu.Lock()
+	defer ow.mu.Unlock()
+	ow.writeRecordLocked(rec)
+	if rec.Type == "chunk" {
+		ow.pending = true
+		ow.lastWrite = time.Now()
+	}
+}
+
+// writeRecordLocked writes a record the way agentbox does, temp file and
+// rename, so the forwarder never reads a partial record.
+func (ow *execOutputWriter) writeRecordLocked(rec outputRec) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	ow.seq++
+	name := fmt.Sprintf("%010d.json", ow.seq)
+	tmp := filepath.Join(ow.dir, name+".tmp")
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return
+	}
+	_ = os.Rename(tmp, filepath.Join(ow.dir, name))
+}
+
+func (ow *execOutputWriter) settle() {
+	ow.mu.Lock()
+	defer ow.mu.Unlock()
+	if ow.pending && time.Since(ow.lastWrite) >= execOutputSettle {
+		ow.settleLocked()
+	}
+}
+
+func (ow *execOutputWriter) settleNow() {
+	ow.mu.Lock()
+	defer ow.mu.Unlock()
+	if ow.pending {
+		ow.settleLocked()
+	}
+}
+
+func (ow *execOutputWriter) settleLocked() {
+	ow.writeRecordLocked(outputRec{Type: "final"})
+	ow.writeRecordLocked(outputRec{Type: "turn_end"})
+	ow.pending = false
+}
+
+// execInputFeeder reads the user turns the inputPump wrote, in order, and
+// writes each one to the shell's stdin as a line.
+type execInputFeeder struct {
+	dir          string
+	stdin        io.Writer
+	logsWriter   io.Writer
+	seen         map[string]bool
+	lastActivity *atomic.Int64
+}
+
+func (ef *execInputFeeder) tick() {
+	entries, _ := os.ReadDir(ef.dir)
+	var names []string
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".json") && !ef.seen[e.Name()] {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		b, err := os.ReadFile(filepath.Join(ef.dir, n))
+		if err != nil {
+			return // retry from n next tick
+		}
+		ef.seen[n] = true
+		var rec struct {
+			Content string `json:"content"`
+		}
+		if json.Unmarshal(b, &rec) != nil {
+			continue
+		}
+		ef.lastActivity.Store(time.Now().UnixNano())
+		io.WriteString(ef.logsWriter, fmt.Sprintf("> %s\n", rec.Content))
+		line := rec.Content
+		if !strings.HasSuffix(line, "\n") {
+			line += "\n"
+		}
+		if _, err := io.WriteString(ef.stdin, line); err != nil {
+			io.WriteString(ef.logsWriter, fmt.Sprintf("exec session: error writing input: %s\n", err))
+		}
+	}
+}
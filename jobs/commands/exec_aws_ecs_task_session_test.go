@@ -0,0 +1,92 @@
+package commands
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func TestGetExecSessionTarget(t *testing.T) {
+	got := getExecSessionTarget("arn:aws:ecs:us-east-1:123456789012:cluster/ecs-org1",
+		"arn:aws:ecs:us-east-1:123456789012:task/ecs-org1/0123456789abcdef", "0123456789abcdef-1234")
+	want := "ecs:ecs-org1_0123456789abcdef_0123456789abcdef-1234"
+	if got != want {
+		t.Errorf("target = %s, want %s", got, want)
+	}
+}
+
+func TestGetExecContainerRuntimeID(t *testing.T) {
+	task := &ecsTypes.Task{
+		TaskArn: aws.String("task-1"),
+		Containers: []ecsTypes.Container{
+			{Name: aws.String("log-router"), RuntimeId: aws.String("r-0")},
+			{Name: aws.String("c-dep1"), RuntimeId: aws.String("r-1"), ManagedAgents: []ecsTypes.ManagedAgent{
+				{Name: ecsTypes.ManagedAgentNameExecuteCommandAgent, LastStatus: aws.String("RUNNING")},
+			}},
+			{Name: aws.String("nginx"), RuntimeId: aws.String("r-2"), ManagedAgents: []ecsTypes.ManagedAgent{
+				{Name: ecsTypes.ManagedAgentNameExecuteCommandAgent, LastStatus: aws.String("PENDING")},
+			}},
+		},
+	}
+	runtimeID, err := getExecContainerRuntimeID(task, "c-dep1")
+	if err != nil || runtimeID != "r-1" {
+		t.Errorf("runtime id = %q, %v, want r-1", runtimeID, err)
+	}
+	for _, containerName := range []string{"log-router", "nginx", "missing"} {
+		if _, err := getExecContainerRuntimeID(task, containerName); err == nil {
+			t.Errorf("expected an error for %s", containerName)
+		}
+	}
+}
+
+// TestExecOutputWriterSettle closes the message and hands the turn back only
+// once the shell has been quiet.
+func TestExecOutputWriterSettle(t *testing.T) {
+	dir := t.TempDir()
+	ow := &execOutputWriter{dir: dir, logsWriter: io.Discard, lastActivity: &atomic.Int64{}}
+	ow.Write([]byte("$ "))
+	ow.settle()
+	if got := readOutputRecordTypes(t, dir); len(got) != 1 || got[0] != "chunk" {
+		t.Fatalf("records = %v, want only the chunk before the output settles", got)
+	}
+	ow.lastWrite = time.Now().Add(-2 * execOutputSettle)
+	ow.settle()
+	ow.settle()
+	got := readOutputRecordTypes(t, dir)
+	if len(got) != 3 || got[1] != "final" || got[2] != "turn_end" {
+		t.Errorf("records = %v, want chunk final turn_end", got)
+	}
+}
+
+func readOutputRecordTypes(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	var types []string
+	for _, name := range names {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rec outputRec
+		if err := json.Unmarshal(b, &rec); err != nil {
+			t.Fatal(err)
+		}
+		types = append(types, rec.Type)
+	}
+	return types
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
 	cwTypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
 	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
 	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
 	"github.com/deployment-io/deployment-runner-kit/jobs"
@@ -48,6 +49,16 @@ func (g *GetDeploymentLogsAws) Run(parameters map[string]interface{}, logsWriter
 
 	// Log stream prefix is optional — databases and deployments without builds won't have one
 	logStreamPrefix, _ := utils.GetApplicationLogStreamPrefix(parameters)
+	// Lambda names its streams by date and version, not by build
+	var lambdaLogStreamPrefixes []string
+	if isLambdaFunction(parameters) {
+		lambdaVersion, err := getLiveLambdaVersion(parameters)
+		if err != nil {
+			io.WriteString(logsWriter, fmt.Sprintf("Could not get the live Lambda version, showing logs of every version: %s\n", err))
+		}
+		lambdaLogStreamPrefixes = getLambdaLogStreamPrefixes(lambdaVersion, startTimeMs, endTimeMs)
+		logStreamPrefix = lambdaLogStreamPrefixes[len(lambdaLogStreamPrefixes)-1]
+	}
 
 	debug, _ := jobs.GetParameterValue[bool](parameters, parameters_enums.DebugGetDeploymentLogs)
 	if debug {
@@ -56,7 +67,9 @@ func (g *GetDeploymentLogsAws) Run(parameters map[string]interface{}, logsWriter
 	}
 
 	var logs []map[string]interface{}
-	if len(searchPattern) > 0 {
+	if len(lambdaLogStreamPrefixes) > 0 {
+		logs, err = getLambdaLogs(cloudwatchLogsClient, logGroupName, lambdaLogStreamPrefixes, searchPattern, startTimeMs, endTimeMs, debug)
+	} else if len(searchPattern) > 0 {
 		logs, err = getFilteredLogs(cloudwatchLogsClient, logGroupName, logStreamPrefix, searchPattern, startTimeMs, endTimeMs, debug)
 	} else {
 		logs, err = getLogs(cloudwatchLogsClient, logGroupName, logStreamPrefix, startTimeMs, endTimeMs, debug)
@@ -86,6 +99,86 @@ func (g *GetDeploymentLogsAws) Run(parameters map[string]interface{}, logsWriter
 	return parameters, nil
 }
 
+// maxLambdaLogStreamDays caps how many days of Lambda log streams are looked
+// at, one prefix per day
+const maxLambdaLogStreamDays = 14
+
+// getLiveLambdaVersion returns the version the live alias points at.
+func getLiveLambdaVersion(parameters map[string]interface{}) (string, error) {
+	lambdaClient, err := cloud_api_clients.GetLambdaClient(parameters)
+	if err != nil {
+		return "", err
+	}
+	functionName, err := getLambdaFunctionName(parameters)
+	if err != nil {
+		return "", err
+	}
+	getAliasOutput, err := lambdaClient.GetAlias(context.TODO(), &lambda.GetAliasInput{
+		FunctionName: aws.String(functionName),
+		Name:         aws.String(lambdaAliasName),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(getAliasOutput.FunctionVersion), nil
+}
+
+// getLambdaLogStreamPrefixes returns a stream prefix per day, oldest first,
+// from the day before the start to the end. Lambda names its streams
+// YYYY/MM/DD/[<version>]<id> after the day the execution environment
+// started, and an environment can outlive that day. Without a version the
+// prefixes match every version.
+func getLambdaLogStreamPrefixes(version string, startTimeMs, endTimeMs int64) []string {
+	end := time.UnixMilli(endTimeMs).UTC()
+	if now := time.Now().UTC(); end.After(now) {
+		end = now
+	}
+	start := time.UnixMilli(startTimeMs).UTC().AddDate(0, 0, -1)
+	var prefixes []string
+	for day := end; len(prefixes) < maxLambdaLogStreamDays; day = day.AddDate(0, 0, -1) {
+		prefix := day.Format("2006/01/02/")
+		if len(version) > 0 {
+			prefix = fmt.Sprintf("%s[%s]", prefix, version)
+		}
+		prefixes = append([]string{prefix}, prefixes...)
+		if day.Format("2006/01/02") <= start.Format("2006/01/02") {
+			break
+		}
+	}
+	return prefixes
+}
+
+// getLambdaLogs reads the logs of the Lambda streams a day at a time. Without
+// a search pattern it returns the latest stream's events, with one the
+// matching events of every day, oldest first.
+func getLambdaLogs(client *cloudwatchlogs.Client, logGroupName string, logStreamPrefixes []string, searchPattern string,
+	startTimeMs, endTimeMs int64, debug bool) ([]map[string]interface{}, error) {
+	if len(searchPattern) == 0 {
+		for i := len(logStreamPrefixes) - 1; i >= 0; i-- {
+			logs, err := getLogs(client, logGroupName, logStreamPrefixes[i], startTimeMs, endTimeMs, debug)
+			if err != nil {
+				return nil, err
+			}
+			if len(logs) > 0 {
+				return logs, nil
+			}
+		}
+		return nil, nil
+	}
+	var allLogs []map[string]interface{}
+	for _, logStreamPrefix := range logStreamPrefixes {
+		logs, err := getFilteredLogs(client, logGroupName, logStreamPrefix, searchPattern, startTimeMs, endTimeMs, debug)
+		if err != nil {
+			return nil, err
+		}
+		allLogs = append(allLogs, logs...)
+		if len(allLogs) >= maxLogLines {
+			return allLogs[:maxLogLines], nil
+		}
+	}
+	return allLogs, nil
+}
+
 func getLogs(client *cloudwatchlogs.Client, logGroupName, logStreamPrefix string,
 	startTimeMs, endTimeMs int64, debug bool) ([]map[string]interface{}, error) {
 
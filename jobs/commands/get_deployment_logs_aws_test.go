@@ -0,0 +1,26 @@
+package commands
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestGetLambdaLogStreamPrefixes starts a day early for execution
+// environments started the day before and caps the number of days.
+func TestGetLambdaLogStreamPrefixes(t *testing.T) {
+	start := time.Date(2024, 3, 5, 0, 30, 0, 0, time.UTC).UnixMilli()
+	end := time.Date(2024, 3, 6, 12, 0, 0, 0, time.UTC).UnixMilli()
+	got := getLambdaLogStreamPrefixes("7", start, end)
+	if want := []string{"2024/03/04/[7]", "2024/03/05/[7]", "2024/03/06/[7]"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("prefixes = %v, want %v", got, want)
+	}
+	got = getLambdaLogStreamPrefixes("", end, end)
+	if want := []string{"2024/03/05/", "2024/03/06/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("prefixes without a version = %v, want %v", got, want)
+	}
+	got = getLambdaLogStreamPrefixes("7", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli(), end)
+	if len(got) != maxLambdaLogStreamDays || got[len(got)-1] != "2024/03/06/[7]" {
+		t.Errorf("prefixes = %v, want the last %d days", got, maxLambdaLogStreamDays)
+	}
+}
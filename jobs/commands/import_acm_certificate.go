@@ -0,0 +1,207 @@
+package commands
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	acmTypes "github.com/aws/aws-sdk-go-v2/service/acm/types"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/deployment-io/deployment-runner-kit/certificates"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/enums/iam_policy_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/iam_policies"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	"github.com/deployment-io/deployment-runner-kit/types"
+	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
+	"github.com/deployment-io/deployment-runner/utils"
+)
+
+// ImportAcmCertificate imports a customer-provided certificate, for domains
+// that can't use DNS validation, from a Secrets Manager secret holding the
+// PEM encoded certificate, its private key and chain. Importing again with
+// AcmCertificateArn replaces the certificate in place, so the load
+// balancers and distributions using it pick up the renewed one.
+type ImportAcmCertificate struct {
+}
+
+type pemCertificateBundle struct {
+	Certificate      []byte
+	PrivateKey       []byte
+	CertificateChain []byte
+	Leaf             *x509.Certificate
+}
+
+// parsePemCertificateBundle splits a PEM bundle into the certificate, its
+// private key and the chain. The blocks can be in any order; the
+// certificate is the one matching the key and the chain keeps the order of
+// the others.
+func parsePemCertificateBundle(bundle []byte) (pemCertificateBundle, error) {
+	var certificateBlocks []*pem.Block
+	var privateKey []byte
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			break
+		}
+		switch {
+		case block.Type == "CERTIFICATE":
+			certificateBlocks = append(certificateBlocks, block)
+		case block.Type == "ENCRYPTED PRIVATE KEY" || block.Headers["Proc-Type"] != "":
+			return pemCertificateBundle{}, fmt.Errorf("the private key must not be encrypted")
+		case strings.HasSuffix(block.Type, "PRIVATE KEY"):
+			if privateKey != nil {
+				return pemCertificateBundle{}, fmt.Errorf("the bundle has more than one private key")
+			}
+			privateKey = pem.EncodeToMemory(block)
+		}
+	}
+	if privateKey == nil {
+		return pemCertificateBundle{}, fmt.Errorf("the bundle has no private key")
+	}
+	if len(certificateBlocks) == 0 {
+		return pemCertificateBundle{}, fmt.Errorf("the bundle has no certificate")
+	}
+	result := pemCertificateBundle{PrivateKey: privateKey}
+	var chain []byte
+	for _, block := range certificateBlocks {
+		encoded := pem.EncodeToMemory(block)
+		if result.Leaf == nil {
+			if _, err := tls.X509KeyPair(encoded, privateKey); err == nil {
+				leaf, err := x509.ParseCertificate(block.Bytes)
+				if err != nil {
+					return pemCertificateBundle{}, err
+				}
+				result.Certificate = encoded
+				result.Leaf = leaf
+				continue
+			}
+		}
+		chain = append(chain, encoded...)
+	}
+	if result.Leaf == nil {
+		return pemCertificateBundle{}, fmt.Errorf("none of the certificates matches the private key")
+	}
+	result.CertificateChain = chain
+	return result, nil
+}
+
+// validateImportedCertificate checks what ACM doesn't: that the certificate
+// is valid now and covers domain, when there's one.
+func validateImportedCertificate(leaf *x509.Certificate, domain string, now time.Time) error {
+	if now.After(leaf.NotAfter) {
+		return fmt.Errorf("the certificate expired on %s", leaf.NotAfter.Format("2006-01-02"))
+	}
+	if now.Before(leaf.NotBefore) {
+		return fmt.Errorf("the certificate isn't valid until %s", leaf.NotBefore.Format("2006-01-02"))
+	}
+	if len(domain) > 0 {
+		if err := leaf.VerifyHostname(domain); err != nil {
+			return fmt.Errorf("the certificate isn't for %s", domain)
+		}
+	}
+	return nil
+}
+
+func (i *ImportAcmCertificate) Run(parameters map[string]interface{}, logsWriter io.Writer) (newParameters map[string]interface{}, err error) {
+	runnerData := utils.RunnerData.Get()
+	organizationID, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIDNamespace)
+	if err != nil {
+		return parameters, err
+	}
+	err = iam_policies.AddAwsPolicyForDeploymentRunner(iam_policy_enums.AwsCertificateManager,
+		runnerData.OsType.String(), runnerData.CpuArchEnum.String(), organizationID, runnerData.RunnerRegion, runnerData.Mode, runnerData.TargetCloud)
+	if err != nil {
+		return parameters, err
+	}
+	certificateSecretArn, err := jobs.GetParameterValue[string](parameters, parameters_enums.CertificateSecretArn)
+	if err != nil {
+		return parameters, err
+	}
+	certificateID, err := jobs.GetParameterValue[string](parameters, parameters_enums.CertificateID)
+	if err != nil {
+		return parameters, err
+	}
+	certificateDomain, _ := jobs.GetParameterValue[string](parameters, parameters_enums.CertificateDomain)
+	existingCertificateArn, _ := jobs.GetParameterValue[string](parameters, parameters_enums.AcmCertificateArn)
+
+	secretsManagerClient, err := cloud_api_clients.GetSecretsManagerClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Reading certificate from secret: %s\n", certificateSecretArn))
+	getSecretValueOutput, err := secretsManagerClient.GetSecretValue(context.TODO(), &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(certificateSecretArn),
+	})
+	if err != nil {
+		return parameters, err
+	}
+	bundle := getSecretValueOutput.SecretBinary
+	if getSecretValueOutput.SecretString != nil {
+		bundle = []byte(aws.ToString(getSecretValueOutput.SecretString))
+	}
+	pemBundle, err := parsePemCertificateBundle(bundle)
+	if err != nil {
+		return parameters, fmt.Errorf("error reading certificate from secret %s: %s", certificateSecretArn, err)
+	}
+	err = validateImportedCertificate(pemBundle.Leaf, certificateDomain, time.Now())
+	if err != nil {
+		return parameters, err
+	}
+
+	acmClient, err := cloud_api_clients.GetAcmClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	importCertificateInput := &acm.ImportCertificateInput{
+		Certificate:      pemBundle.Certificate,
+		PrivateKey:       pemBundle.PrivateKey,
+		CertificateChain: pemBundle.CertificateChain,
+	}
+	if len(existingCertificateArn) > 0 {
+		//a reimport keeps the tags and doesn't accept new ones
+		io.WriteString(logsWriter, fmt.Sprintf("Reimporting certificate into ACM: %s\n", existingCertificateArn))
+		importCertificateInput.CertificateArn = aws.String(existingCertificateArn)
+	} else {
+		io.WriteString(logsWriter, fmt.Sprintf("Importing certificate into ACM for %s\n", pemBundle.Leaf.Subject.CommonName))
+		importCertificateInput.Tags = []acmTypes.Tag{
+			{
+				Key:   aws.String(certificateCreatedByTagKey),
+				Value: aws.String(certificateCreatedByTagValue),
+			},
+			{
+				Key:   aws.String(certificateIDTagKey),
+				Value: aws.String(certificateID),
+			},
+		}
+	}
+	importCertificateOutput, err := acmClient.ImportCertificate(context.TODO(), importCertificateInput)
+	if err != nil {
+		return parameters, err
+	}
+	certificateArn := aws.ToString(importCertificateOutput.CertificateArn)
+	io.WriteString(logsWriter, fmt.Sprintf("Imported certificate into ACM: %s, expires on %s\n", certificateArn,
+		pemBundle.Leaf.NotAfter.Format("2006-01-02")))
+
+	organizationIdFromJob, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIdFromJob)
+	if err != nil {
+		return parameters, err
+	}
+	//an imported certificate needs no validation
+	commandUtils.UpdateCertificatesPipeline.Add(organizationIdFromJob, certificates.UpdateCertificateDtoV1{
+		ID:             certificateID,
+		CertificateArn: certificateArn,
+		Verified:       types.True,
+	})
+	jobs.SetParameterValue[string](parameters, parameters_enums.AcmCertificateArn, certificateArn)
+	return parameters, nil
+}
@@ -0,0 +1,93 @@
+package commands
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func newTestCertificate(t *testing.T, template *x509.Certificate, parent *x509.Certificate,
+	parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func encodeTestKey(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestParsePemCertificateBundle(t *testing.T) {
+	now := time.Now()
+	ca, caKey, caPem := newTestCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	_, leafKey, leafPem := newTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com", "*.example.com"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(12 * time.Hour),
+	}, ca, caKey)
+	keyPem := encodeTestKey(t, leafKey)
+
+	//chain first, as some providers order it
+	bundle, err := parsePemCertificateBundle(bytes.Join([][]byte{caPem, keyPem, leafPem}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bundle.Certificate, leafPem) || !bytes.Equal(bundle.CertificateChain, caPem) ||
+		!bytes.Equal(bundle.PrivateKey, keyPem) {
+		t.Error("expected the leaf, chain and key to be split")
+	}
+	if err = validateImportedCertificate(bundle.Leaf, "www.example.com", now); err != nil {
+		t.Error(err)
+	}
+	if err = validateImportedCertificate(bundle.Leaf, "example.org", now); err == nil {
+		t.Error("expected an error for another domain")
+	}
+	if err = validateImportedCertificate(bundle.Leaf, "", now.Add(13*time.Hour)); err == nil {
+		t.Error("expected an error for an expired certificate")
+	}
+
+	if _, err = parsePemCertificateBundle(bytes.Join([][]byte{leafPem, caPem}, nil)); err == nil {
+		t.Error("expected an error without a private key")
+	}
+	if _, err = parsePemCertificateBundle(bytes.Join([][]byte{caPem, keyPem}, nil)); err == nil {
+		t.Error("expected an error when no certificate matches the key")
+	}
+	encrypted := pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: []byte{1}})
+	if _, err = parsePemCertificateBundle(bytes.Join([][]byte{leafPem, encrypted}, nil)); err == nil {
+		t.Error("expected an error for an encrypted key")
+	}
+}
@@ -0,0 +1,244 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	acmTypes "github.com/aws/aws-sdk-go-v2/service/acm/types"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/enums/iam_policy_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/iam_policies"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	"github.com/deployment-io/deployment-runner-kit/notifications"
+	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
+	"github.com/deployment-io/deployment-runner/utils"
+)
+
+// Tags on the certificates the runner requests or imports.
+const (
+	certificateCreatedByTagKey   = "created by"
+	certificateCreatedByTagValue = "deployment.io"
+	certificateIDTagKey          = "certificate id"
+)
+
+// ACM renews an in-use certificate from 60 days before it expires, so one
+// that's this close to expiring is stuck. Problems are notified again after
+// certificateNotificationInterval while they last.
+const (
+	certificateExpiryWarning        = 30 * 24 * time.Hour
+	certificateNotificationInterval = 24 * time.Hour
+)
+
+// InventoryAcmCertificates writes the status, expiry and users of the
+// certificates the runner manages to JobOutput and notifies problems like a
+// stuck renewal. It can run on a schedule.
+type InventoryAcmCertificates struct {
+}
+
+type acmCertificate struct {
+	Arn                     string   `json:"arn"`
+	CertificateID           string   `json:"certificate_id,omitempty"`
+	Domain                  string   `json:"domain"`
+	SubjectAlternativeNames []string `json:"subject_alternative_names,omitempty"`
+	Type                    string   `json:"type"`
+	Status                  string   `json:"status"`
+	NotAfter                int64    `json:"not_after,omitempty"`
+	RenewalStatus           string   `json:"renewal_status,omitempty"`
+	InUseBy                 []string `json:"in_use_by"`
+	Problems                []string `json:"problems,omitempty"`
+}
+
+// notifiedCertificateProblems is when a problem was last notified, so a
+// scheduled inventory doesn't notify the same problem on every run.
+var notifiedCertificateProblems = struct {
+	sync.Mutex
+	at map[string]time.Time
+}{at: make(map[string]time.Time)}
+
+// getCertificateProblems describes what needs the user's attention: a
+// renewal waiting on validation records that were removed, a failed
+// renewal, or a certificate about to expire that ACM can't renew.
+func getCertificateProblems(certificate acmTypes.CertificateDetail, now time.Time) []string {
+	var problems []string
+	switch certificate.Status {
+	case acmTypes.CertificateStatusExpired, acmTypes.CertificateStatusRevoked, acmTypes.CertificateStatusFailed,
+		acmTypes.CertificateStatusValidationTimedOut:
+		return []string{fmt.Sprintf("the certificate's status is %s", certificate.Status)}
+	case acmTypes.CertificateStatusIssued:
+	default:
+		return nil
+	}
+	if renewal := certificate.RenewalSummary; renewal != nil {
+		switch renewal.RenewalStatus {
+		case acmTypes.RenewalStatusPendingValidation:
+			var domains []string
+			for _, domainValidation := range renewal.DomainValidationOptions {
+				if domainValidation.ValidationStatus != acmTypes.DomainStatusSuccess {
+					domains = append(domains, aws.ToString(domainValidation.DomainName))
+				}
+			}
+			problems = append(problems, fmt.Sprintf("renewal is waiting for the validation records of %s",
+				strings.Join(domains, ", ")))
+		case acmTypes.RenewalStatusFailed:
+			problems = append(problems, fmt.Sprintf("renewal failed: %s", renewal.RenewalStatusReason))
+		}
+	}
+	if certificate.NotAfter != nil && certificate.NotAfter.Sub(now) < certificateExpiryWarning {
+		expiresOn := certificate.NotAfter.Format("2006-01-02")
+		switch {
+		case certificate.Type == acmTypes.CertificateTypeImported:
+			problems = append(problems, fmt.Sprintf("the imported certificate expires on %s, import a renewed one", expiresOn))
+		case len(certificate.InUseBy) == 0:
+			problems = append(problems, fmt.Sprintf("the certificate expires on %s and isn't renewed as it isn't in use", expiresOn))
+		case len(problems) == 0:
+			problems = append(problems, fmt.Sprintf("the certificate expires on %s and hasn't been renewed", expiresOn))
+		}
+	}
+	return problems
+}
+
+func toAcmCertificate(certificate acmTypes.CertificateDetail, certificateID string, now time.Time) acmCertificate {
+	c := acmCertificate{
+		Arn:           aws.ToString(certificate.CertificateArn),
+		CertificateID: certificateID,
+		Domain:        aws.ToString(certificate.DomainName),
+		Type:          string(certificate.Type),
+		Status:        string(certificate.Status),
+		InUseBy:       append([]string{}, certificate.InUseBy...),
+		Problems:      getCertificateProblems(certificate, now),
+	}
+	for _, name := range certificate.SubjectAlternativeNames {
+		if name != c.Domain {
+			c.SubjectAlternativeNames = append(c.SubjectAlternativeNames, name)
+		}
+	}
+	if certificate.NotAfter != nil {
+		c.NotAfter = certificate.NotAfter.Unix()
+	}
+	if certificate.RenewalSummary != nil {
+		c.RenewalStatus = string(certificate.RenewalSummary.RenewalStatus)
+	}
+	return c
+}
+
+// getManagedCertificateID returns the certificate's ID tag; ok is false when
+// the runner didn't create the certificate.
+func getManagedCertificateID(tags []acmTypes.Tag) (certificateID string, ok bool) {
+	for _, tag := range tags {
+		switch aws.ToString(tag.Key) {
+		case certificateCreatedByTagKey:
+			ok = aws.ToString(tag.Value) == certificateCreatedByTagValue
+		case certificateIDTagKey:
+			certificateID = aws.ToString(tag.Value)
+		}
+	}
+	return certificateID, ok
+}
+
+// shouldNotifyCertificateProblem records the notification, so it returns
+// true once per certificateNotificationInterval for a problem.
+func shouldNotifyCertificateProblem(certificateArn, problem string, now time.Time) bool {
+	notifiedCertificateProblems.Lock()
+	defer notifiedCertificateProblems.Unlock()
+	key := certificateArn + "\n" + problem
+	if notifiedAt, exists := notifiedCertificateProblems.at[key]; exists && now.Sub(notifiedAt) < certificateNotificationInterval {
+		return false
+	}
+	notifiedCertificateProblems.at[key] = now
+	return true
+}
+
+func (i *InventoryAcmCertificates) Run(parameters map[string]interface{}, logsWriter io.Writer) (newParameters map[string]interface{}, err error) {
+	runnerData := utils.RunnerData.Get()
+	organizationID, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIDNamespace)
+	if err != nil {
+		return parameters, err
+	}
+	err = iam_policies.AddAwsPolicyForDeploymentRunner(iam_policy_enums.AwsCertificateManager,
+		runnerData.OsType.String(), runnerData.CpuArchEnum.String(), organizationID, runnerData.RunnerRegion, runnerData.Mode, runnerData.TargetCloud)
+	if err != nil {
+		return parameters, err
+	}
+	acmClient, err := cloud_api_clients.GetAcmClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	organizationIdFromJob, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIdFromJob)
+	if err != nil {
+		return parameters, err
+	}
+
+	now := time.Now()
+	certificates := []acmCertificate{}
+	paginator := acm.NewListCertificatesPaginator(acmClient, &acm.ListCertificatesInput{
+		//only RSA 2048 certificates are listed by default
+		Includes: &acmTypes.Filters{KeyTypes: acmTypes.KeyAlgorithm("").Values()},
+	})
+	for paginator.HasMorePages() {
+		listCertificatesOutput, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return parameters, err
+		}
+		for _, summary := range listCertificatesOutput.CertificateSummaryList {
+			listTagsOutput, err := acmClient.ListTagsForCertificate(context.TODO(), &acm.ListTagsForCertificateInput{
+				CertificateArn: summary.CertificateArn,
+			})
+			if err != nil {
+				return parameters, err
+			}
+			certificateID, ok := getManagedCertificateID(listTagsOutput.Tags)
+			if !ok {
+				continue
+			}
+			describeCertificateOutput, err := acmClient.DescribeCertificate(context.TODO(), &acm.DescribeCertificateInput{
+				CertificateArn: summary.CertificateArn,
+			})
+			if err != nil {
+				return parameters, err
+			}
+			if describeCertificateOutput.Certificate == nil {
+				continue
+			}
+			certificates = append(certificates, toAcmCertificate(*describeCertificateOutput.Certificate, certificateID, now))
+		}
+	}
+	sort.Slice(certificates, func(i, j int) bool {
+		return certificates[i].Domain < certificates[j].Domain
+	})
+
+	for _, certificate := range certificates {
+		expiry := "no expiry yet"
+		if certificate.NotAfter > 0 {
+			expiry = "expires " + time.Unix(certificate.NotAfter, 0).UTC().Format("2006-01-02")
+		}
+		io.WriteString(logsWriter, fmt.Sprintf("%s: %s, %s, used by %d resources\n", certificate.Domain, certificate.Status,
+			expiry, len(certificate.InUseBy)))
+		for _, problem := range certificate.Problems {
+			io.WriteString(logsWriter, fmt.Sprintf("  %s\n", problem))
+			if !shouldNotifyCertificateProblem(certificate.Arn, problem, now) {
+				continue
+			}
+			commandUtils.SendNotificationPipeline.Add(organizationIdFromJob, notifications.SendNotificationDtoV1{
+				Title:   fmt.Sprintf("Certificate for %s needs attention", certificate.Domain),
+				Message: fmt.Sprintf("The certificate %s for %s: %s.", certificate.Arn, certificate.Domain, problem),
+			})
+		}
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Found %d certificates managed by deployment.io\n", len(certificates)))
+
+	out, err := json.Marshal(certificates)
+	if err != nil {
+		return parameters, err
+	}
+	jobs.SetParameterValue[string](parameters, parameters_enums.JobOutput, string(out))
+	return parameters, nil
+}
@@ -0,0 +1,103 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	acmTypes "github.com/aws/aws-sdk-go-v2/service/acm/types"
+)
+
+func TestGetCertificateProblems(t *testing.T) {
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	later := now.Add(200 * 24 * time.Hour)
+	soon := now.Add(10 * 24 * time.Hour)
+	inUse := []string{"arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/app/web/1"}
+
+	cases := map[string]struct {
+		certificate acmTypes.CertificateDetail
+		want        string
+	}{
+		"healthy": {
+			certificate: acmTypes.CertificateDetail{Status: acmTypes.CertificateStatusIssued, NotAfter: &later, InUseBy: inUse},
+		},
+		"pending issue": {
+			certificate: acmTypes.CertificateDetail{Status: acmTypes.CertificateStatusPendingValidation},
+		},
+		"validation record removed": {
+			certificate: acmTypes.CertificateDetail{
+				Status:   acmTypes.CertificateStatusIssued,
+				NotAfter: &soon,
+				InUseBy:  inUse,
+				RenewalSummary: &acmTypes.RenewalSummary{
+					RenewalStatus: acmTypes.RenewalStatusPendingValidation,
+					DomainValidationOptions: []acmTypes.DomainValidation{
+						{DomainName: aws.String("example.com"), ValidationStatus: acmTypes.DomainStatusSuccess},
+						{DomainName: aws.String("www.example.com"), ValidationStatus: acmTypes.DomainStatusPendingValidation},
+					},
+				},
+			},
+			want: "renewal is waiting for the validation records of www.example.com",
+		},
+		"renewal failed": {
+			certificate: acmTypes.CertificateDetail{
+				Status:         acmTypes.CertificateStatusIssued,
+				NotAfter:       &later,
+				RenewalSummary: &acmTypes.RenewalSummary{RenewalStatus: acmTypes.RenewalStatusFailed, RenewalStatusReason: acmTypes.FailureReasonCaaError},
+			},
+			want: "renewal failed: CAA_ERROR",
+		},
+		"imported expiring": {
+			certificate: acmTypes.CertificateDetail{Status: acmTypes.CertificateStatusIssued, Type: acmTypes.CertificateTypeImported, NotAfter: &soon, InUseBy: inUse},
+			want:        "the imported certificate expires on 2026-03-11, import a renewed one",
+		},
+		"not in use expiring": {
+			certificate: acmTypes.CertificateDetail{Status: acmTypes.CertificateStatusIssued, NotAfter: &soon},
+			want:        "isn't renewed as it isn't in use",
+		},
+		"expired": {
+			certificate: acmTypes.CertificateDetail{Status: acmTypes.CertificateStatusExpired},
+			want:        "the certificate's status is EXPIRED",
+		},
+	}
+	for name, c := range cases {
+		problems := getCertificateProblems(c.certificate, now)
+		if len(c.want) == 0 {
+			if len(problems) > 0 {
+				t.Errorf("%s: problems = %v, want none", name, problems)
+			}
+			continue
+		}
+		if len(problems) != 1 || !strings.Contains(problems[0], c.want) {
+			t.Errorf("%s: problems = %v, want %q", name, problems, c.want)
+		}
+	}
+}
+
+func TestGetManagedCertificateID(t *testing.T) {
+	certificateID, ok := getManagedCertificateID([]acmTypes.Tag{
+		{Key: aws.String(certificateCreatedByTagKey), Value: aws.String(certificateCreatedByTagValue)},
+		{Key: aws.String(certificateIDTagKey), Value: aws.String("cert-1")},
+	})
+	if !ok || certificateID != "cert-1" {
+		t.Errorf("certificateID = %s, %v", certificateID, ok)
+	}
+	if _, ok = getManagedCertificateID([]acmTypes.Tag{{Key: aws.String("Name"), Value: aws.String("other")}}); ok {
+		t.Error("expected a certificate without the tag not to be managed")
+	}
+}
+
+func TestShouldNotifyCertificateProblem(t *testing.T) {
+	now := time.Now()
+	arn := "arn:aws:acm:us-east-1:123456789012:certificate/test-notify"
+	if !shouldNotifyCertificateProblem(arn, "renewal failed", now) {
+		t.Error("expected the first notification")
+	}
+	if shouldNotifyCertificateProblem(arn, "renewal failed", now.Add(time.Hour)) {
+		t.Error("expected the problem not to be notified again within the interval")
+	}
+	if !shouldNotifyCertificateProblem(arn, "renewal failed", now.Add(certificateNotificationInterval)) {
+		t.Error("expected the problem to be notified again after the interval")
+	}
+}
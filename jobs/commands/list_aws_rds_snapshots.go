@@ -0,0 +1,97 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	rdsTypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+)
+
+// ListAwsRdsSnapshots writes the manual and automated snapshots of the
+// deployment's RDS instance, newest first, to JobOutput.
+type ListAwsRdsSnapshots struct {
+}
+
+type rdsSnapshot struct {
+	Identifier       string `json:"identifier"`
+	Arn              string `json:"arn"`
+	Type             string `json:"type"` // "manual" | "automated"
+	Status           string `json:"status"`
+	CreatedAt        int64  `json:"created_at,omitempty"`
+	AllocatedStorage int32  `json:"allocated_storage"`
+	EngineVersion    string `json:"engine_version"`
+	JobID            string `json:"job_id,omitempty"`
+}
+
+func toRdsSnapshot(dbSnapshot rdsTypes.DBSnapshot) rdsSnapshot {
+	snapshot := rdsSnapshot{
+		Identifier:       aws.ToString(dbSnapshot.DBSnapshotIdentifier),
+		Arn:              aws.ToString(dbSnapshot.DBSnapshotArn),
+		Type:             aws.ToString(dbSnapshot.SnapshotType),
+		Status:           aws.ToString(dbSnapshot.Status),
+		AllocatedStorage: aws.ToInt32(dbSnapshot.AllocatedStorage),
+		EngineVersion:    aws.ToString(dbSnapshot.EngineVersion),
+	}
+	if dbSnapshot.SnapshotCreateTime != nil {
+		snapshot.CreatedAt = dbSnapshot.SnapshotCreateTime.Unix()
+	}
+	for _, tag := range dbSnapshot.TagList {
+		if aws.ToString(tag.Key) == "job-id" {
+			snapshot.JobID = aws.ToString(tag.Value)
+		}
+	}
+	return snapshot
+}
+
+// sortRdsSnapshots orders snapshots newest first, snapshots still being
+// created (no create time yet) on top.
+func sortRdsSnapshots(snapshots []rdsSnapshot) {
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		if snapshots[i].CreatedAt == 0 || snapshots[j].CreatedAt == 0 {
+			return snapshots[i].CreatedAt == 0 && snapshots[j].CreatedAt != 0
+		}
+		return snapshots[i].CreatedAt > snapshots[j].CreatedAt
+	})
+}
+
+func (l *ListAwsRdsSnapshots) Run(parameters map[string]interface{}, logsWriter io.Writer) (newParameters map[string]interface{}, err error) {
+	err = addRdsPolicyForDeploymentRunner(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	rdsClient, err := cloud_api_clients.GetRdsClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	rdsInstanceIdentifier, err := getRdsDBInstanceIdentifier(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	snapshots := []rdsSnapshot{}
+	paginator := rds.NewDescribeDBSnapshotsPaginator(rdsClient, &rds.DescribeDBSnapshotsInput{
+		DBInstanceIdentifier: aws.String(rdsInstanceIdentifier),
+	})
+	for paginator.HasMorePages() {
+		describeDBSnapshotsOutput, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return parameters, err
+		}
+		for _, dbSnapshot := range describeDBSnapshotsOutput.DBSnapshots {
+			snapshots = append(snapshots, toRdsSnapshot(dbSnapshot))
+		}
+	}
+	sortRdsSnapshots(snapshots)
+	out, err := json.Marshal(snapshots)
+	if err != nil {
+		return parameters, err
+	}
+	jobs.SetParameterValue[string](parameters, parameters_enums.JobOutput, string(out))
+	return parameters, nil
+}
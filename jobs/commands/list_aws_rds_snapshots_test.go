@@ -0,0 +1,42 @@
+package commands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	rdsTypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+)
+
+func TestToRdsSnapshot(t *testing.T) {
+	createdAt := time.Unix(1700000000, 0)
+	snapshot := toRdsSnapshot(rdsTypes.DBSnapshot{
+		DBSnapshotIdentifier: aws.String("rds-dep1-premodify-job1"),
+		SnapshotType:         aws.String("manual"),
+		Status:               aws.String("available"),
+		SnapshotCreateTime:   &createdAt,
+		TagList: []rdsTypes.Tag{
+			{Key: aws.String("created by"), Value: aws.String("deployment.io")},
+			{Key: aws.String("job-id"), Value: aws.String("job1")},
+		},
+	})
+	if snapshot.JobID != "job1" || snapshot.CreatedAt != 1700000000 || snapshot.Type != "manual" {
+		t.Errorf("snapshot = %+v", snapshot)
+	}
+}
+
+// TestSortRdsSnapshots puts snapshots still being created first, then the
+// newest.
+func TestSortRdsSnapshots(t *testing.T) {
+	snapshots := []rdsSnapshot{
+		{Identifier: "old", CreatedAt: 100},
+		{Identifier: "creating"},
+		{Identifier: "new", CreatedAt: 200},
+	}
+	sortRdsSnapshots(snapshots)
+	for i, want := range []string{"creating", "new", "old"} {
+		if snapshots[i].Identifier != want {
+			t.Errorf("snapshots[%d] = %s, want %s", i, snapshots[i].Identifier, want)
+		}
+	}
+}
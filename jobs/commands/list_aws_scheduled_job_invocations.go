@@ -0,0 +1,295 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	cwTypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
+)
+
+// Every run of a scheduled job writes its own application log stream, which
+// outlives the stopped task in ECS. The streams are the history of the runs;
+// ECS adds the status and exit code of the runs it still knows about and the
+// runs without a stream.
+const maxScheduledJobInvocations = 50
+
+// maxScheduledJobLogStreamPages caps how many pages of the log group's
+// streams, newest first, are read for the runs. The group also holds the
+// build and sidecar streams.
+const maxScheduledJobLogStreamPages = 10
+
+const applicationLogStreamPrefix = "application/"
+
+type ListAwsScheduledJobInvocations struct{}
+
+type scheduledJobInvocation struct {
+	TaskArn       string `json:"task_arn,omitempty"`
+	TaskID        string `json:"task_id"`
+	Status        string `json:"status"`
+	ExitCode      *int32 `json:"exit_code,omitempty"`
+	StartedAt     int64  `json:"started_at,omitempty"`
+	StoppedAt     int64  `json:"stopped_at,omitempty"`
+	LastLogAt     int64  `json:"last_log_at,omitempty"`
+	StoppedReason string `json:"stopped_reason,omitempty"`
+	LogStreamName string `json:"log_stream_name,omitempty"`
+}
+
+// getTaskIDFromLogStreamName returns the task ID of an awslogs stream
+// application/<buildID>/<containerName>/<taskID>, "" for other streams.
+func getTaskIDFromLogStreamName(logStreamName, containerName string) string {
+	parts := strings.Split(logStreamName, "/")
+	if len(parts) != 4 || parts[0]+"/" != applicationLogStreamPrefix || parts[2] != containerName {
+		return ""
+	}
+	return parts[3]
+}
+
+// toScheduledJobInvocations turns the job's log streams into its most
+// recent invocations, newest first. A run that ECS no longer knows about has
+// stopped.
+func toScheduledJobInvocations(logStreams []cwTypes.LogStream, containerName string) []scheduledJobInvocation {
+	var invocations []scheduledJobInvocation
+	for _, logStream := range logStreams {
+		logStreamName := aws.ToString(logStream.LogStreamName)
+		taskID := getTaskIDFromLogStreamName(logStreamName, containerName)
+		if len(taskID) == 0 {
+			continue
+		}
+		invocation := scheduledJobInvocation{
+			TaskID:        taskID,
+			Status:        string(ecsTypes.DesiredStatusStopped),
+			LogStreamName: logStreamName,
+		}
+		if logStream.FirstEventTimestamp != nil {
+			invocation.StartedAt = aws.ToInt64(logStream.FirstEventTimestamp) / 1000
+		} else {
+			invocation.StartedAt = aws.ToInt64(logStream.CreationTime) / 1000
+		}
+		if logStream.LastEventTimestamp != nil {
+			invocation.LastLogAt = aws.ToInt64(logStream.LastEventTimestamp) / 1000
+		}
+		invocations = append(invocations, invocation)
+	}
+	sortScheduledJobInvocations(invocations)
+	if len(invocations) > maxScheduledJobInvocations {
+		invocations = invocations[:maxScheduledJobInvocations]
+	}
+	return invocations
+}
+
+func sortScheduledJobInvocations(invocations []scheduledJobInvocation) {
+	sort.SliceStable(invocations, func(i, j int) bool {
+		return invocations[i].StartedAt > invocations[j].StartedAt
+	})
+}
+
+// listScheduledJobLogStreams lists the streams with the latest events first
+// until it has the most recent runs or read maxScheduledJobLogStreamPages.
+// CloudWatch can't order by time and filter by prefix at once, so the other
+// streams are skipped here.
+func listScheduledJobLogStreams(cloudwatchLogsClient *cloudwatchlogs.Client, logGroupName,
+	containerName string) ([]cwTypes.LogStream, error) {
+	var logStreams []cwTypes.LogStream
+	paginator := cloudwatchlogs.NewDescribeLogStreamsPaginator(cloudwatchLogsClient, &cloudwatchlogs.DescribeLogStreamsInput{
+		LogGroupName: aws.String(logGroupName),
+		OrderBy:      cwTypes.OrderByLastEventTime,
+		Descending:   aws.Bool(true),
+	})
+	for pages := 0; paginator.HasMorePages() && pages < maxScheduledJobLogStreamPages &&
+		len(logStreams) < maxScheduledJobInvocations; pages++ {
+		describeLogStreamsOutput, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			var resourceNotFoundException *cwTypes.ResourceNotFoundException
+			if errors.As(err, &resourceNotFoundException) {
+				//the job hasn't run yet
+				return nil, nil
+			}
+			return nil, err
+		}
+		for _, logStream := range describeLogStreamsOutput.LogStreams {
+			if len(getTaskIDFromLogStreamName(aws.ToString(logStream.LogStreamName), containerName)) > 0 {
+				logStreams = append(logStreams, logStream)
+			}
+		}
+	}
+	return logStreams, nil
+}
+
+// getTaskLogStreamName returns the log stream of a task from its own task
+// definition, which may be an older revision than the current one.
+func getTaskLogStreamName(ecsClient *ecs.Client, task ecsTypes.Task, containerName string,
+	logStreamPrefixes map[string]string) (string, error) {
+	taskDefinitionArn := aws.ToString(task.TaskDefinitionArn)
+	logStreamPrefix, ok := logStreamPrefixes[taskDefinitionArn]
+	if !ok {
+		describeTaskDefinitionOutput, err := ecsClient.DescribeTaskDefinition(context.TODO(), &ecs.DescribeTaskDefinitionInput{
+			TaskDefinition: aws.String(taskDefinitionArn),
+		})
+		if err != nil {
+			return "", err
+		}
+		for _, containerDefinition := range describeTaskDefinitionOutput.TaskDefinition.ContainerDefinitions {
+			if aws.ToString(containerDefinition.Name) == containerName && containerDefinition.LogConfiguration != nil {
+				logStreamPrefix = containerDefinition.LogConfiguration.Options["awslogs-stream-prefix"]
+			}
+		}
+		logStreamPrefixes[taskDefinitionArn] = logStreamPrefix
+	}
+	if len(logStreamPrefix) == 0 {
+		return "", nil
+	}
+	taskArn := aws.ToString(task.TaskArn)
+	return fmt.Sprintf("%s/%s/%s", logStreamPrefix, containerName, taskArn[strings.LastIndex(taskArn, "/")+1:]), nil
+}
+
+// addTaskToScheduledJobInvocation fills in what ECS knows about the run.
+func addTaskToScheduledJobInvocation(invocation *scheduledJobInvocation, task ecsTypes.Task, containerName string) {
+	invocation.TaskArn = aws.ToString(task.TaskArn)
+	invocation.Status = aws.ToString(task.LastStatus)
+	invocation.StoppedReason = aws.ToString(task.StoppedReason)
+	if task.StartedAt != nil {
+		invocation.StartedAt = task.StartedAt.Unix()
+	}
+	if task.StoppedAt != nil {
+		invocation.StoppedAt = task.StoppedAt.Unix()
+	}
+	for _, container := range task.Containers {
+		if aws.ToString(container.Name) == containerName {
+			invocation.ExitCode = container.ExitCode
+			break
+		}
+	}
+}
+
+func describeScheduledJobTasks(ecsClient *ecs.Client, clusterArn string, taskIDs []string) ([]ecsTypes.Task, error) {
+	var tasks []ecsTypes.Task
+	//DescribeTasks takes at most 100 tasks
+	for start := 0; start < len(taskIDs); start += 100 {
+		end := min(start+100, len(taskIDs))
+		describeTasksOutput, err := ecsClient.DescribeTasks(context.TODO(), &ecs.DescribeTasksInput{
+			Cluster: aws.String(clusterArn),
+			Tasks:   taskIDs[start:end],
+		})
+		if err != nil {
+			return nil, err
+		}
+		//tasks ECS no longer keeps come back as failures
+		tasks = append(tasks, describeTasksOutput.Tasks...)
+	}
+	return tasks, nil
+}
+
+func (l *ListAwsScheduledJobInvocations) Run(parameters map[string]interface{}, logsWriter io.Writer) (map[string]interface{}, error) {
+	io.WriteString(logsWriter, "Listing scheduled job invocations...\n")
+
+	clusterArn, err := jobs.GetParameterValue[string](parameters, parameters_enums.EcsClusterArn)
+	if err != nil {
+		return parameters, err
+	}
+	taskDefinitionFamilyName, err := getTaskDefinitionFamilyName(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	containerName, err := getContainerName(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	logGroupName, err := commandUtils.GetLogGroupName(parameters)
+	if err != nil {
+		return parameters, err
+	}
+
+	cloudwatchLogsClient, err := cloud_api_clients.GetCloudwatchLogsClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	logStreams, err := listScheduledJobLogStreams(cloudwatchLogsClient, logGroupName, containerName)
+	if err != nil {
+		return parameters, fmt.Errorf("failed to list log streams: %w", err)
+	}
+	invocations := toScheduledJobInvocations(logStreams, containerName)
+
+	ecsClient, err := cloud_api_clients.GetEcsClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	invocationsByTaskID := map[string]*scheduledJobInvocation{}
+	var taskIDs []string
+	for i := range invocations {
+		invocationsByTaskID[invocations[i].TaskID] = &invocations[i]
+		taskIDs = append(taskIDs, invocations[i].TaskID)
+	}
+	//runs that haven't logged yet, or never did because e.g. the image
+	//couldn't be pulled, have no stream
+	for _, desiredStatus := range []ecsTypes.DesiredStatus{ecsTypes.DesiredStatusRunning, ecsTypes.DesiredStatusStopped} {
+		listTasksPaginator := ecs.NewListTasksPaginator(ecsClient, &ecs.ListTasksInput{
+			Cluster:       aws.String(clusterArn),
+			Family:        aws.String(taskDefinitionFamilyName),
+			DesiredStatus: desiredStatus,
+		})
+		for listTasksPaginator.HasMorePages() {
+			listTasksOutput, err := listTasksPaginator.NextPage(context.TODO())
+			if err != nil {
+				return parameters, fmt.Errorf("failed to list tasks: %w", err)
+			}
+			for _, taskArn := range listTasksOutput.TaskArns {
+				taskID := taskArn[strings.LastIndex(taskArn, "/")+1:]
+				if _, ok := invocationsByTaskID[taskID]; !ok {
+					taskIDs = append(taskIDs, taskID)
+				}
+			}
+		}
+	}
+
+	tasks, err := describeScheduledJobTasks(ecsClient, clusterArn, taskIDs)
+	if err != nil {
+		return parameters, fmt.Errorf("failed to describe tasks: %w", err)
+	}
+	logStreamPrefixes := map[string]string{}
+	var taskInvocations []scheduledJobInvocation
+	for _, task := range tasks {
+		taskArn := aws.ToString(task.TaskArn)
+		if invocation, ok := invocationsByTaskID[taskArn[strings.LastIndex(taskArn, "/")+1:]]; ok {
+			addTaskToScheduledJobInvocation(invocation, task, containerName)
+			continue
+		}
+		invocation := scheduledJobInvocation{TaskID: taskArn[strings.LastIndex(taskArn, "/")+1:]}
+		addTaskToScheduledJobInvocation(&invocation, task, containerName)
+		invocation.LogStreamName, err = getTaskLogStreamName(ecsClient, task, containerName, logStreamPrefixes)
+		if err != nil {
+			return parameters, fmt.Errorf("failed to describe task definition: %w", err)
+		}
+		taskInvocations = append(taskInvocations, invocation)
+	}
+	invocations = append(taskInvocations, invocations...)
+	sortScheduledJobInvocations(invocations)
+	if len(invocations) > maxScheduledJobInvocations {
+		invocations = invocations[:maxScheduledJobInvocations]
+	}
+
+	io.WriteString(logsWriter, fmt.Sprintf("Found %d invocations\n", len(invocations)))
+
+	output := map[string]interface{}{
+		"invocations": invocations,
+		"count":       len(invocations),
+	}
+	outputJSON, err := json.Marshal(output)
+	if err != nil {
+		return parameters, fmt.Errorf("failed to encode output: %w", err)
+	}
+	_ = jobs.SetParameterValue[string](parameters, parameters_enums.JobOutput, string(outputJSON))
+	return parameters, nil
+}
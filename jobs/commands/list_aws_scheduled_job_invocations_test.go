@@ -0,0 +1,36 @@
+package commands
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cwTypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+// TestToScheduledJobInvocations keeps the newest runs across builds, not the
+// first ones listed.
+func TestToScheduledJobInvocations(t *testing.T) {
+	var logStreams []cwTypes.LogStream
+	for i := 0; i < maxScheduledJobInvocations+10; i++ {
+		logStreams = append(logStreams, cwTypes.LogStream{
+			LogStreamName:       aws.String(fmt.Sprintf("application/build-%d/app/task-%d", i%3, i)),
+			FirstEventTimestamp: aws.Int64(int64(i) * 60000),
+		})
+	}
+	logStreams = append(logStreams,
+		cwTypes.LogStream{LogStreamName: aws.String("sidecar/build-1/proxy/task-x"), CreationTime: aws.Int64(1 << 40)},
+		cwTypes.LogStream{LogStreamName: aws.String("application/build-1/other/task-y"), CreationTime: aws.Int64(1 << 40)},
+	)
+	invocations := toScheduledJobInvocations(logStreams, "app")
+	if len(invocations) != maxScheduledJobInvocations {
+		t.Fatalf("got %d invocations", len(invocations))
+	}
+	if first := invocations[0]; first.TaskID != fmt.Sprintf("task-%d", maxScheduledJobInvocations+9) ||
+		first.LogStreamName != fmt.Sprintf("application/build-%d/app/task-%d", (maxScheduledJobInvocations+9)%3, maxScheduledJobInvocations+9) {
+		t.Errorf("newest invocation = %+v", first)
+	}
+	if last := invocations[len(invocations)-1]; last.TaskID != "task-10" {
+		t.Errorf("oldest kept invocation = %+v", last)
+	}
+}
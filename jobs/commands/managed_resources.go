@@ -0,0 +1,433 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamTypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/enums/iam_policy_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/iam_policies"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	runnerUtils "github.com/deployment-io/deployment-runner/utils"
+)
+
+// managed resource types, the ManagedResourceType parameter
+const (
+	managedResourceSqs = "sqs"
+	managedResourceSns = "sns"
+	managedResourceS3  = "s3"
+)
+
+// access a linked service gets to a managed resource
+const (
+	linkedResourceRead      = "read"
+	linkedResourceWrite     = "write"
+	linkedResourceReadWrite = "readwrite"
+)
+
+const linkedResourcesTaskRolePolicyName = "linked-resources"
+
+var linkedResourceNameRegex = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]*$`)
+
+// managedResource is what a linked service needs to use a resource. For a
+// bucket the URL is the bucket name.
+type managedResource struct {
+	Type   string
+	Arn    string
+	Url    string
+	DlqArn string
+	DlqUrl string
+}
+
+// managedResourceLink is one entry of a service's LinkedResources parameter.
+// Name is the env var prefix the resource is injected with.
+type managedResourceLink struct {
+	Type         string `json:"type"`
+	DeploymentID string `json:"deployment_id"`
+	Name         string `json:"name"`
+	Access       string `json:"access"` // "read" | "write" | "readwrite" (default)
+}
+
+func getSqsQueueName(deploymentID string) string {
+	//sqs-<deploymentID>
+	return fmt.Sprintf("sqs-%s", deploymentID)
+}
+
+func getSqsDeadLetterQueueName(deploymentID string) string {
+	//sqs-<deploymentID>-dlq
+	return fmt.Sprintf("sqs-%s-dlq", deploymentID)
+}
+
+func getSnsTopicName(deploymentID string) string {
+	//sns-<deploymentID>
+	return fmt.Sprintf("sns-%s", deploymentID)
+}
+
+func getManagedBucketName(organizationID, deploymentID string) string {
+	//<organizationID>-<deploymentID>, same as getBucketName
+	return fmt.Sprintf("%s-%s", organizationID, deploymentID)
+}
+
+func getManagedResourceType(parameters map[string]interface{}) (string, error) {
+	resourceType, err := jobs.GetParameterValue[string](parameters, parameters_enums.ManagedResourceType)
+	if err != nil {
+		return "", err
+	}
+	return validateManagedResourceType(resourceType)
+}
+
+func validateManagedResourceType(resourceType string) (string, error) {
+	switch resourceType {
+	case managedResourceSqs, managedResourceSns, managedResourceS3:
+		return resourceType, nil
+	}
+	return "", fmt.Errorf("unsupported managed resource type %s, use sqs, sns or s3", resourceType)
+}
+
+func addManagedResourcesPolicyForDeploymentRunner(parameters map[string]interface{}) error {
+	runnerData := runnerUtils.RunnerData.Get()
+	organizationID, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIDNamespace)
+	if err != nil {
+		return err
+	}
+	return iam_policies.AddAwsPolicyForDeploymentRunner(iam_policy_enums.AwsManagedResources,
+		runnerData.OsType.String(), runnerData.CpuArchEnum.String(), organizationID, runnerData.RunnerRegion, runnerData.Mode, runnerData.TargetCloud)
+}
+
+func getLinkedResources(parameters map[string]interface{}) ([]managedResourceLink, error) {
+	linkedResourcesJSON, err := jobs.GetParameterValue[string](parameters, parameters_enums.LinkedResources)
+	if err != nil || len(linkedResourcesJSON) == 0 {
+		return nil, nil
+	}
+	return parseLinkedResources([]byte(linkedResourcesJSON))
+}
+
+func parseLinkedResources(linkedResourcesBytes []byte) ([]managedResourceLink, error) {
+	var links []managedResourceLink
+	if err := json.Unmarshal(linkedResourcesBytes, &links); err != nil {
+		return nil, fmt.Errorf("error unmarshalling linked resources: %s", err)
+	}
+	prefixes := map[string]bool{}
+	for i, link := range links {
+		if _, err := validateManagedResourceType(link.Type); err != nil {
+			return nil, err
+		}
+		if len(link.DeploymentID) == 0 {
+			return nil, fmt.Errorf("linked resource %s has no deployment", link.Name)
+		}
+		if !linkedResourceNameRegex.MatchString(link.Name) {
+			return nil, fmt.Errorf("invalid linked resource name %s, use letters, digits, - and _", link.Name)
+		}
+		prefix := getLinkedResourceEnvPrefix(link.Name)
+		if prefixes[prefix] {
+			return nil, fmt.Errorf("linked resource name %s is used more than once", link.Name)
+		}
+		prefixes[prefix] = true
+		switch link.Access {
+		case "":
+			links[i].Access = linkedResourceReadWrite
+		case linkedResourceRead, linkedResourceWrite, linkedResourceReadWrite:
+		default:
+			return nil, fmt.Errorf("invalid access %s for linked resource %s, use read, write or readwrite", link.Access, link.Name)
+		}
+	}
+	return links, nil
+}
+
+func getLinkedResourceEnvPrefix(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// getLinkedResourceEnvironment is what a linked service sees of a resource:
+// <NAME>_QUEUE_URL, <NAME>_QUEUE_ARN and <NAME>_DLQ_URL for a queue,
+// <NAME>_TOPIC_ARN for a topic, <NAME>_BUCKET and <NAME>_BUCKET_ARN for a bucket.
+func getLinkedResourceEnvironment(name string, resource *managedResource) []ecsTypes.KeyValuePair {
+	prefix := getLinkedResourceEnvPrefix(name)
+	env := func(suffix, value string) ecsTypes.KeyValuePair {
+		return ecsTypes.KeyValuePair{Name: aws.String(prefix + suffix), Value: aws.String(value)}
+	}
+	switch resource.Type {
+	case managedResourceSqs:
+		return []ecsTypes.KeyValuePair{
+			env("_QUEUE_URL", resource.Url),
+			env("_QUEUE_ARN", resource.Arn),
+			env("_DLQ_URL", resource.DlqUrl),
+		}
+	case managedResourceSns:
+		return []ecsTypes.KeyValuePair{
+			env("_TOPIC_ARN", resource.Arn),
+		}
+	case managedResourceS3:
+		return []ecsTypes.KeyValuePair{
+			env("_BUCKET", resource.Url),
+			env("_BUCKET_ARN", resource.Arn),
+		}
+	}
+	return nil
+}
+
+type policyStatement struct {
+	Effect   string   `json:"Effect"`
+	Action   []string `json:"Action"`
+	Resource []string `json:"Resource"`
+}
+
+type policyDocument struct {
+	Version   string            `json:"Version"`
+	Statement []policyStatement `json:"Statement"`
+}
+
+// getLinkedResourceStatements grants only what the access needs: consumers
+// of a queue receive and delete, producers send; topics are published to;
+// bucket readers get and list, writers put and delete objects.
+func getLinkedResourceStatements(access string, resource *managedResource) []policyStatement {
+	read := access == linkedResourceRead || access == linkedResourceReadWrite
+	write := access == linkedResourceWrite || access == linkedResourceReadWrite
+	var statements []policyStatement
+	switch resource.Type {
+	case managedResourceSqs:
+		if read {
+			//consumers also get to work off the dead-letter queue
+			queueArns := []string{resource.Arn}
+			if len(resource.DlqArn) > 0 {
+				queueArns = append(queueArns, resource.DlqArn)
+			}
+			statements = append(statements, policyStatement{
+				Effect: "Allow",
+				Action: []string{"sqs:ChangeMessageVisibility", "sqs:DeleteMessage", "sqs:GetQueueAttributes",
+					"sqs:GetQueueUrl", "sqs:ReceiveMessage"},
+				Resource: queueArns,
+			})
+		}
+		if write {
+			statements = append(statements, policyStatement{
+				Effect:   "Allow",
+				Action:   []string{"sqs:GetQueueAttributes", "sqs:GetQueueUrl", "sqs:SendMessage"},
+				Resource: []string{resource.Arn},
+			})
+		}
+	case managedResourceSns:
+		if read {
+			statements = append(statements, policyStatement{
+				Effect:   "Allow",
+				Action:   []string{"sns:GetTopicAttributes", "sns:ListSubscriptionsByTopic"},
+				Resource: []string{resource.Arn},
+			})
+		}
+		if write {
+			statements = append(statements, policyStatement{
+				Effect:   "Allow",
+				Action:   []string{"sns:Publish"},
+				Resource: []string{resource.Arn},
+			})
+		}
+	case managedResourceS3:
+		if read {
+			statements = append(statements, policyStatement{
+				Effect:   "Allow",
+				Action:   []string{"s3:ListBucket"},
+				Resource: []string{resource.Arn},
+			}, policyStatement{
+				Effect:   "Allow",
+				Action:   []string{"s3:GetObject"},
+				Resource: []string{resource.Arn + "/*"},
+			})
+		}
+		if write {
+			statements = append(statements, policyStatement{
+				Effect:   "Allow",
+				Action:   []string{"s3:DeleteObject", "s3:PutObject"},
+				Resource: []string{resource.Arn + "/*"},
+			})
+		}
+	}
+	return statements
+}
+
+func getLinkedResourcesPolicy(statements []policyStatement) (string, error) {
+	policy, err := json.Marshal(policyDocument{
+		Version:   "2012-10-17",
+		Statement: statements,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(policy), nil
+}
+
+func getSqsQueue(sqsClient *sqs.Client, queueName string) (*managedResource, error) {
+	getQueueUrlOutput, err := sqsClient.GetQueueUrl(context.TODO(), &sqs.GetQueueUrlInput{
+		QueueName: aws.String(queueName),
+	})
+	var queueDoesNotExist *sqsTypes.QueueDoesNotExist
+	if errors.As(err, &queueDoesNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	getQueueAttributesOutput, err := sqsClient.GetQueueAttributes(context.TODO(), &sqs.GetQueueAttributesInput{
+		QueueUrl:       getQueueUrlOutput.QueueUrl,
+		AttributeNames: []sqsTypes.QueueAttributeName{sqsTypes.QueueAttributeNameQueueArn},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &managedResource{
+		Type: managedResourceSqs,
+		Arn:  getQueueAttributesOutput.Attributes[string(sqsTypes.QueueAttributeNameQueueArn)],
+		Url:  aws.ToString(getQueueUrlOutput.QueueUrl),
+	}, nil
+}
+
+// getSnsTopicArn looks the topic up by name, ARNs carry the account ID.
+func getSnsTopicArn(snsClient *sns.Client, topicName string) (string, error) {
+	listTopicsPaginator := sns.NewListTopicsPaginator(snsClient, &sns.ListTopicsInput{})
+	for listTopicsPaginator.HasMorePages() {
+		listTopicsOutput, err := listTopicsPaginator.NextPage(context.TODO())
+		if err != nil {
+			return "", err
+		}
+		for _, topic := range listTopicsOutput.Topics {
+			if strings.HasSuffix(aws.ToString(topic.TopicArn), ":"+topicName) {
+				return aws.ToString(topic.TopicArn), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// getManagedResource finds the resource deployed by DeployAwsManagedResource
+// for deploymentID. It returns nil when the resource doesn't exist.
+func getManagedResource(parameters map[string]interface{}, resourceType, deploymentID string) (*managedResource, error) {
+	switch resourceType {
+	case managedResourceSqs:
+		sqsClient, err := cloud_api_clients.GetSqsClient(parameters)
+		if err != nil {
+			return nil, err
+		}
+		queue, err := getSqsQueue(sqsClient, getSqsQueueName(deploymentID))
+		if err != nil || queue == nil {
+			return nil, err
+		}
+		deadLetterQueue, err := getSqsQueue(sqsClient, getSqsDeadLetterQueueName(deploymentID))
+		if err != nil {
+			return nil, err
+		}
+		if deadLetterQueue != nil {
+			queue.DlqArn = deadLetterQueue.Arn
+			queue.DlqUrl = deadLetterQueue.Url
+		}
+		return queue, nil
+	case managedResourceSns:
+		snsClient, err := cloud_api_clients.GetSnsClient(parameters)
+		if err != nil {
+			return nil, err
+		}
+		topicArn, err := getSnsTopicArn(snsClient, getSnsTopicName(deploymentID))
+		if err != nil || len(topicArn) == 0 {
+			return nil, err
+		}
+		return &managedResource{Type: managedResourceSns, Arn: topicArn}, nil
+	case managedResourceS3:
+		organizationID, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIDNamespace)
+		if err != nil {
+			return nil, err
+		}
+		s3Client, err := cloud_api_clients.GetS3Client(parameters)
+		if err != nil {
+			return nil, err
+		}
+		bucketName := getManagedBucketName(organizationID, deploymentID)
+		_, err = s3Client.HeadBucket(context.TODO(), &s3.HeadBucketInput{
+			Bucket: aws.String(bucketName),
+		})
+		if err != nil {
+			var apiErr smithy.APIError
+			if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "NotFound" || apiErr.ErrorCode() == "NoSuchBucket") {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return &managedResource{Type: managedResourceS3, Arn: "arn:aws:s3:::" + bucketName, Url: bucketName}, nil
+	}
+	return nil, fmt.Errorf("unsupported managed resource type %s", resourceType)
+}
+
+// resolveLinkedResources looks up the service's linked resources and returns
+// the env vars they're injected as and the task role policy granting access.
+// The policy is empty when nothing is linked.
+func resolveLinkedResources(parameters map[string]interface{}, logsWriter io.Writer) ([]ecsTypes.KeyValuePair, string, error) {
+	links, err := getLinkedResources(parameters)
+	if err != nil || len(links) == 0 {
+		return nil, "", err
+	}
+	err = addManagedResourcesPolicyForDeploymentRunner(parameters)
+	if err != nil {
+		return nil, "", err
+	}
+	var environment []ecsTypes.KeyValuePair
+	var statements []policyStatement
+	for _, link := range links {
+		resource, err := getManagedResource(parameters, link.Type, link.DeploymentID)
+		if err != nil {
+			return nil, "", err
+		}
+		if resource == nil {
+			return nil, "", fmt.Errorf("linked %s resource %s isn't deployed yet", link.Type, link.Name)
+		}
+		io.WriteString(logsWriter, fmt.Sprintf("Linking %s resource %s with %s access\n", link.Type, link.Name, link.Access))
+		environment = append(environment, getLinkedResourceEnvironment(link.Name, resource)...)
+		statements = append(statements, getLinkedResourceStatements(link.Access, resource)...)
+	}
+	sort.SliceStable(environment, func(i, j int) bool {
+		return aws.ToString(environment[i].Name) < aws.ToString(environment[j].Name)
+	})
+	policy, err := getLinkedResourcesPolicy(statements)
+	if err != nil {
+		return nil, "", err
+	}
+	return environment, policy, nil
+}
+
+// syncLinkedResourcesTaskRolePolicy puts the linked resources policy on the
+// deployment's task role, or removes it once nothing is linked anymore.
+func syncLinkedResourcesTaskRolePolicy(iamClient *iam.Client, parameters map[string]interface{}, policy string) error {
+	taskRoleName, err := getEcsTaskRoleName(parameters)
+	if err != nil {
+		return err
+	}
+	if len(policy) > 0 {
+		_, err = iamClient.PutRolePolicy(context.TODO(), &iam.PutRolePolicyInput{
+			PolicyDocument: aws.String(policy),
+			PolicyName:     aws.String(linkedResourcesTaskRolePolicyName),
+			RoleName:       aws.String(taskRoleName),
+		})
+		return err
+	}
+	_, err = iamClient.DeleteRolePolicy(context.TODO(), &iam.DeleteRolePolicyInput{
+		PolicyName: aws.String(linkedResourcesTaskRolePolicyName),
+		RoleName:   aws.String(taskRoleName),
+	})
+	var noSuchEntityException *iamTypes.NoSuchEntityException
+	if err != nil && !errors.As(err, &noSuchEntityException) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package commands
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestParseLinkedResources(t *testing.T) {
+	links, err := parseLinkedResources([]byte(`[{"type": "sqs", "deployment_id": "d1", "name": "orders"},
+		{"type": "s3", "deployment_id": "d2", "name": "uploads", "access": "read"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 2 || links[0].Access != linkedResourceReadWrite || links[1].Access != linkedResourceRead {
+		t.Errorf("links = %+v", links)
+	}
+}
+
+func TestParseLinkedResources_Invalid(t *testing.T) {
+	cases := map[string]string{
+		"type":           `[{"type": "dynamodb", "deployment_id": "d1", "name": "orders"}]`,
+		"no deployment":  `[{"type": "sqs", "name": "orders"}]`,
+		"name":           `[{"type": "sqs", "deployment_id": "d1", "name": "1orders"}]`,
+		"access":         `[{"type": "sqs", "deployment_id": "d1", "name": "orders", "access": "admin"}]`,
+		"duplicate name": `[{"type": "sqs", "deployment_id": "d1", "name": "orders"}, {"type": "sns", "deployment_id": "d2", "name": "ORDERS"}]`,
+	}
+	for name, linksJSON := range cases {
+		if _, err := parseLinkedResources([]byte(linksJSON)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestGetLinkedResourceEnvironment(t *testing.T) {
+	env := getLinkedResourceEnvironment("order-events", &managedResource{
+		Type:   managedResourceSqs,
+		Arn:    "arn:aws:sqs:us-east-1:1:sqs-d1",
+		Url:    "https://sqs/1/sqs-d1",
+		DlqUrl: "https://sqs/1/sqs-d1-dlq",
+	})
+	got := map[string]string{}
+	for _, kv := range env {
+		got[aws.ToString(kv.Name)] = aws.ToString(kv.Value)
+	}
+	want := map[string]string{
+		"ORDER_EVENTS_QUEUE_URL": "https://sqs/1/sqs-d1",
+		"ORDER_EVENTS_QUEUE_ARN": "arn:aws:sqs:us-east-1:1:sqs-d1",
+		"ORDER_EVENTS_DLQ_URL":   "https://sqs/1/sqs-d1-dlq",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("env = %v, want %v", got, want)
+	}
+}
+
+func TestGetLinkedResourceStatements(t *testing.T) {
+	queue := &managedResource{Type: managedResourceSqs, Arn: "q", DlqArn: "dlq"}
+	statements := getLinkedResourceStatements(linkedResourceWrite, queue)
+	if len(statements) != 1 || !reflect.DeepEqual(statements[0].Resource, []string{"q"}) {
+		t.Fatalf("write statements = %+v", statements)
+	}
+	for _, action := range statements[0].Action {
+		if action == "sqs:ReceiveMessage" || action == "sqs:DeleteMessage" {
+			t.Errorf("producer got %s", action)
+		}
+	}
+	statements = getLinkedResourceStatements(linkedResourceRead, queue)
+	if len(statements) != 1 || !reflect.DeepEqual(statements[0].Resource, []string{"q", "dlq"}) {
+		t.Errorf("read statements = %+v", statements)
+	}
+
+	bucket := &managedResource{Type: managedResourceS3, Arn: "arn:aws:s3:::b", Url: "b"}
+	statements = getLinkedResourceStatements(linkedResourceReadWrite, bucket)
+	if len(statements) != 3 {
+		t.Fatalf("bucket statements = %+v", statements)
+	}
+	policy, err := getLinkedResourcesPolicy(statements)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document policyDocument
+	if err = json.Unmarshal([]byte(policy), &document); err != nil || document.Version != "2012-10-17" {
+		t.Errorf("policy = %s, %v", policy, err)
+	}
+	if !strings.Contains(policy, `"arn:aws:s3:::b/*"`) {
+		t.Errorf("policy doesn't cover the bucket's objects: %s", policy)
+	}
+}
+
+func TestParseManagedResourceSettings(t *testing.T) {
+	settings, err := parseManagedResourceSettings(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.MaxReceiveCount != defaultSqsMaxReceiveCount {
+		t.Errorf("max receive count = %d, want %d", settings.MaxReceiveCount, defaultSqsMaxReceiveCount)
+	}
+	if settings.Versioning != nil {
+		t.Errorf("versioning = %v, want it left unset", *settings.Versioning)
+	}
+	settings, err = parseManagedResourceSettings([]byte(`{"versioning": false}`))
+	if err != nil || settings.Versioning == nil || *settings.Versioning {
+		t.Errorf("versioning = %v, %v, want an explicit false", settings.Versioning, err)
+	}
+	for name, settingsJSON := range map[string]string{
+		"visibility":    `{"visibility_timeout_seconds": 50000}`,
+		"retention":     `{"message_retention_seconds": 30}`,
+		"receives":      `{"max_receive_count": 1001}`,
+		"not an object": `[]`,
+	} {
+		if _, err := parseManagedResourceSettings([]byte(settingsJSON)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestGetSqsQueueAttributes(t *testing.T) {
+	settings := &managedResourceSettings{VisibilityTimeoutSeconds: 60, MaxReceiveCount: 3}
+	attributes, err := getSqsQueueAttributes(settings, "arn:dlq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attributes["VisibilityTimeout"] != "60" || attributes["RedrivePolicy"] != `{"deadLetterTargetArn":"arn:dlq","maxReceiveCount":3}` {
+		t.Errorf("attributes = %v", attributes)
+	}
+	attributes, err = getSqsQueueAttributes(settings, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := attributes["RedrivePolicy"]; ok || attributes["MessageRetentionPeriod"] != "1209600" {
+		t.Errorf("dead-letter queue attributes = %v", attributes)
+	}
+}
@@ -0,0 +1,297 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	"github.com/deployment-io/deployment-runner-kit/previews"
+	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
+	"github.com/deployment-io/deployment-runner/utils/aws_utils"
+)
+
+// A protected preview isn't publicly reachable. A static site preview asks
+// for a password in its viewer-request function, the password is generated
+// once per preview and sent back with the preview. A web service preview's
+// listener only forwards the allowed IPs.
+
+const (
+	previewProtectionPassword    = "password"
+	previewProtectionIpAllowlist = "ip_allowlist"
+)
+
+// getPreviewProtection returns the protection of a preview, "" for a
+// deployment or a public preview.
+func getPreviewProtection(parameters map[string]interface{}) (string, error) {
+	if !isPreview(parameters) {
+		return "", nil
+	}
+	protection, err := jobs.GetParameterValue[string](parameters, parameters_enums.PreviewProtection)
+	if err != nil {
+		return "", nil
+	}
+	switch protection {
+	case "", previewProtectionPassword, previewProtectionIpAllowlist:
+		return protection, nil
+	}
+	return "", fmt.Errorf("unknown preview protection: %s", protection)
+}
+
+// getPreviewAccessAuthorization returns the Authorization header value for
+// the preview's credentials. The password is generated on the preview's
+// first protected deployment and kept for the later ones.
+func getPreviewAccessAuthorization(parameters map[string]interface{}, logsWriter io.Writer) (string, error) {
+	password, _ := jobs.GetParameterValue[string](parameters, parameters_enums.PreviewAccessPassword)
+	if len(password) == 0 {
+		previewID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+		if err != nil {
+			return "", err
+		}
+		organizationIdFromJob, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIdFromJob)
+		if err != nil {
+			return "", err
+		}
+		password, err = aws_utils.NewPreviewAccessPassword()
+		if err != nil {
+			return "", err
+		}
+		io.WriteString(logsWriter, fmt.Sprintf("Generated access credentials for preview: %s\n", previewID))
+		commandUtils.UpdatePreviewsPipeline.Add(organizationIdFromJob, previews.UpdatePreviewDtoV1{
+			ID:             previewID,
+			AccessPassword: password,
+		})
+		jobs.SetParameterValue(parameters, parameters_enums.PreviewAccessPassword, password)
+	}
+	return aws_utils.GetBasicAuthorization(aws_utils.PreviewAccessUsername, password), nil
+}
+
+// getStaticPreviewAuthorization returns what viewers of a password
+// protected static site preview have to send, "" when it's public.
+func getStaticPreviewAuthorization(parameters map[string]interface{}, logsWriter io.Writer) (string, error) {
+	protection, err := getPreviewProtection(parameters)
+	if err != nil {
+		return "", err
+	}
+	switch protection {
+	case "":
+		return "", nil
+	case previewProtectionIpAllowlist:
+		return "", fmt.Errorf("a static site preview can only be protected with a password")
+	}
+	return getPreviewAccessAuthorization(parameters, logsWriter)
+}
+
+func getPreviewAllowedCidrs(parameters map[string]interface{}) ([]string, error) {
+	allowedCidrs, err := jobs.GetParameterValue[string](parameters, parameters_enums.PreviewAllowedCidrs)
+	if err != nil {
+		return nil, nil
+	}
+	return parsePreviewAllowedCidrs(allowedCidrs)
+}
+
+// parsePreviewAllowedCidrs parses comma separated CIDRs. A single address is
+// allowed on its own.
+func parsePreviewAllowedCidrs(allowedCidrs string) ([]string, error) {
+	var cidrs []string
+	for _, cidr := range strings.Split(allowedCidrs, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if len(cidr) == 0 {
+			continue
+		}
+		if ip := net.ParseIP(cidr); ip != nil {
+			if ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed CIDR %s", cidr)
+		}
+		cidrs = append(cidrs, ipNet.String())
+	}
+	return cidrs, nil
+}
+
+// getPreviewListenerRuleConditions returns the conditions of the rules
+// forwarding to a protected web service preview: its allowed CIDRs, at most
+// maxListenerRuleConditionValues per rule.
+func getPreviewListenerRuleConditions(cidrs []string) [][]elbTypes.RuleCondition {
+	var conditions [][]elbTypes.RuleCondition
+	for start := 0; start < len(cidrs); start += maxListenerRuleConditionValues {
+		end := min(start+maxListenerRuleConditionValues, len(cidrs))
+		conditions = append(conditions, []elbTypes.RuleCondition{{
+			Field: aws.String("source-ip"),
+			SourceIpConfig: &elbTypes.SourceIpConditionConfig{
+				Values: cidrs[start:end],
+			},
+		}})
+	}
+	return conditions
+}
+
+func getPreviewListenerActions(protected bool, targetGroupArn string) []elbTypes.Action {
+	if !protected {
+		return []elbTypes.Action{{
+			Type:           elbTypes.ActionTypeEnumForward,
+			Order:          aws.Int32(1),
+			TargetGroupArn: aws.String(targetGroupArn),
+		}}
+	}
+	return []elbTypes.Action{{
+		Type:  elbTypes.ActionTypeEnumFixedResponse,
+		Order: aws.Int32(1),
+		FixedResponseConfig: &elbTypes.FixedResponseActionConfig{
+			StatusCode:  aws.String("403"),
+			ContentType: aws.String("text/plain"),
+			MessageBody: aws.String("This preview is restricted"),
+		},
+	}}
+}
+
+// updatePreviewListenerProtection protects the listener of a web service
+// preview's own load balancer, or removes the protection when it was turned
+// off. The preview's rules are the only ones on that listener.
+func updatePreviewListenerProtection(parameters map[string]interface{}, elbClient *elasticloadbalancingv2.Client,
+	listenerArn, targetGroupArn string, logsWriter io.Writer) error {
+	if !isPreview(parameters) {
+		return nil
+	}
+	protection, err := getPreviewProtection(parameters)
+	if err != nil {
+		return err
+	}
+	protected := len(protection) > 0
+	if isSharedAlb(parameters) {
+		//the other rules on a shared listener are other services'
+		if protected {
+			return fmt.Errorf("a preview on a shared load balancer can't be protected")
+		}
+		return nil
+	}
+	if protection == previewProtectionPassword {
+		return fmt.Errorf("a web service preview can only be protected with an IP allowlist")
+	}
+	rules, err := describeAllListenerRules(elbClient, listenerArn)
+	if err != nil {
+		return err
+	}
+	var existingRules []elbTypes.Rule
+	for _, rule := range rules {
+		if !aws.ToBool(rule.IsDefault) {
+			existingRules = append(existingRules, rule)
+		}
+	}
+	if !protected && len(existingRules) == 0 {
+		return nil
+	}
+	var conditions [][]elbTypes.RuleCondition
+	if protected {
+		cidrs, err := getPreviewAllowedCidrs(parameters)
+		if err != nil {
+			return err
+		}
+		if len(cidrs) == 0 {
+			return fmt.Errorf("an IP allowlist needs at least one allowed CIDR")
+		}
+		conditions = getPreviewListenerRuleConditions(cidrs)
+		io.WriteString(logsWriter, fmt.Sprintf("Restricting listener %s to %s\n", listenerArn, strings.Join(cidrs, ", ")))
+	} else {
+		io.WriteString(logsWriter, fmt.Sprintf("Removing preview protection from listener: %s\n", listenerArn))
+		//forward everything again before the allow rules go away
+		_, err = elbClient.ModifyListener(context.TODO(), &elasticloadbalancingv2.ModifyListenerInput{
+			ListenerArn:    aws.String(listenerArn),
+			DefaultActions: getPreviewListenerActions(false, targetGroupArn),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if protected {
+		//the new allow rules go in before the old ones are deleted so allowed IPs are never turned away
+		albListenerRuleName, err := getAlbListenerRuleName(parameters)
+		if err != nil {
+			return err
+		}
+		for _, ruleConditions := range conditions {
+			priority, err := nextFreeListenerRulePriority(rules, minPathListenerRulePriority, maxListenerRulePriority)
+			if err != nil {
+				return err
+			}
+			createRuleOutput, err := elbClient.CreateRule(context.TODO(), &elasticloadbalancingv2.CreateRuleInput{
+				ListenerArn: aws.String(listenerArn),
+				Priority:    aws.Int32(priority),
+				Conditions:  ruleConditions,
+				Actions:     getPreviewListenerActions(false, targetGroupArn),
+				Tags: []elbTypes.Tag{
+					{
+						Key:   aws.String("Name"),
+						Value: aws.String(albListenerRuleName),
+					},
+					{
+						Key:   aws.String("created by"),
+						Value: aws.String("deployment.io"),
+					},
+				},
+			})
+			if err != nil {
+				return err
+			}
+			rules = append(rules, createRuleOutput.Rules...)
+		}
+	}
+	for _, rule := range existingRules {
+		_, err = elbClient.DeleteRule(context.TODO(), &elasticloadbalancingv2.DeleteRuleInput{
+			RuleArn: rule.RuleArn,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if !protected {
+		return nil
+	}
+	_, err = elbClient.ModifyListener(context.TODO(), &elasticloadbalancingv2.ModifyListenerInput{
+		ListenerArn:    aws.String(listenerArn),
+		DefaultActions: getPreviewListenerActions(true, targetGroupArn),
+	})
+	return err
+}
+
+// updateExistingPreviewListenerProtection applies the protection of a
+// preview whose load balancer already exists to the listener forwarding to
+// it: the https one for targets that require https, else the http one.
+func updateExistingPreviewListenerProtection(parameters map[string]interface{}, elbClient *elasticloadbalancingv2.Client,
+	loadBalancerArn, targetGroupArn string, logsWriter io.Writer) error {
+	if !isPreview(parameters) {
+		return nil
+	}
+	targetGroupSettings, err := getTargetGroupSettings(parameters)
+	if err != nil {
+		return err
+	}
+	var listenerPort int32 = 80
+	if targetGroupSettings.requiresHttps() {
+		listenerPort = 443
+	}
+	describeListenersOutput, err := elbClient.DescribeListeners(context.TODO(), &elasticloadbalancingv2.DescribeListenersInput{
+		LoadBalancerArn: aws.String(loadBalancerArn),
+	})
+	if err != nil {
+		return err
+	}
+	for _, listener := range describeListenersOutput.Listeners {
+		if aws.ToInt32(listener.Port) == listenerPort {
+			return updatePreviewListenerProtection(parameters, elbClient, aws.ToString(listener.ListenerArn), targetGroupArn, logsWriter)
+		}
+	}
+	return fmt.Errorf("load balancer %s has no listener on port %d", loadBalancerArn, listenerPort)
+}
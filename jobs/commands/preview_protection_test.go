@@ -0,0 +1,36 @@
+package commands
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePreviewAllowedCidrs(t *testing.T) {
+	cidrs, err := parsePreviewAllowedCidrs(" 203.0.113.7, 198.51.100.0/24 ,,2001:db8::1, 192.0.2.9/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"203.0.113.7/32", "198.51.100.0/24", "2001:db8::1/128", "192.0.2.0/24"}
+	if !reflect.DeepEqual(cidrs, want) {
+		t.Errorf("cidrs = %v, want %v", cidrs, want)
+	}
+	if _, err = parsePreviewAllowedCidrs("203.0.113.7, office"); err == nil {
+		t.Error("expected an error for an invalid CIDR")
+	}
+}
+
+// TestGetPreviewListenerRuleConditions splits the CIDRs at the condition
+// value limit.
+func TestGetPreviewListenerRuleConditions(t *testing.T) {
+	cidrs := []string{"10.0.0.1/32", "10.0.0.2/32", "10.0.0.3/32", "10.0.0.4/32", "10.0.0.5/32", "10.0.0.6/32"}
+	conditions := getPreviewListenerRuleConditions(cidrs)
+	if len(conditions) != 2 {
+		t.Fatalf("conditions = %+v, want two source-ip rules", conditions)
+	}
+	if got := conditions[0][0].SourceIpConfig.Values; len(got) != maxListenerRuleConditionValues {
+		t.Errorf("first rule values = %v", got)
+	}
+	if got := conditions[1][0].SourceIpConfig.Values; !reflect.DeepEqual(got, []string{"10.0.0.6/32"}) {
+		t.Errorf("second rule values = %v", got)
+	}
+}
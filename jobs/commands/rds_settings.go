@@ -0,0 +1,470 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	rdsTypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/deployment-io/deployment-runner-kit/deployments"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
+)
+
+const (
+	rdsSnapshotPreUpgrade = "preupgrade"
+	maxRdsReadReplicas    = 5
+)
+
+var (
+	rdsBackupWindowRegex      = regexp.MustCompile(`^([01]\d|2[0-3]):[0-5]\d-([01]\d|2[0-3]):[0-5]\d$`)
+	rdsMaintenanceWindowRegex = regexp.MustCompile(`^(mon|tue|wed|thu|fri|sat|sun):([01]\d|2[0-3]):[0-5]\d-(mon|tue|wed|thu|fri|sat|sun):([01]\d|2[0-3]):[0-5]\d$`)
+	nonAlphanumericRegex      = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+)
+
+// rdsSettings is the optional RdsSettings parameter. Zero values keep what
+// the RDS defaults or the instance already has.
+type rdsSettings struct {
+	EngineVersion            string            `json:"engine_version"`
+	AllowMajorVersionUpgrade bool              `json:"allow_major_version_upgrade"`
+	BackupWindow             string            `json:"backup_window"`      // "hh24:mi-hh24:mi" UTC
+	MaintenanceWindow        string            `json:"maintenance_window"` // "ddd:hh24:mi-ddd:hh24:mi" UTC
+	BackupRetentionDays      *int32            `json:"backup_retention_days"`
+	ParameterGroupFamily     string            `json:"parameter_group_family"` // derived from the engine version when empty
+	Parameters               map[string]string `json:"parameters"`
+	PerformanceInsights      *bool             `json:"performance_insights"`
+	ReadReplicas             int               `json:"read_replicas"`
+	// Aurora Serverless v2 only
+	MinCapacity *float64 `json:"min_capacity"` // ACUs, 0.5 when empty
+	MaxCapacity float64  `json:"max_capacity"` // ACUs, 4 when empty
+	Reader      bool     `json:"reader"`
+}
+
+func getRdsSettings(parameters map[string]interface{}) (*rdsSettings, error) {
+	settingsJSON, err := jobs.GetParameterValue[string](parameters, parameters_enums.RdsSettings)
+	if err != nil || len(settingsJSON) == 0 {
+		return parseRdsSettings(nil)
+	}
+	return parseRdsSettings([]byte(settingsJSON))
+}
+
+func parseRdsSettings(settingsBytes []byte) (*rdsSettings, error) {
+	settings := &rdsSettings{}
+	if len(settingsBytes) > 0 {
+		if err := json.Unmarshal(settingsBytes, settings); err != nil {
+			return nil, fmt.Errorf("error unmarshalling rds settings: %s", err)
+		}
+	}
+	if len(settings.BackupWindow) > 0 && !rdsBackupWindowRegex.MatchString(settings.BackupWindow) {
+		return nil, fmt.Errorf("invalid backup window %s, expected hh24:mi-hh24:mi", settings.BackupWindow)
+	}
+	settings.MaintenanceWindow = strings.ToLower(settings.MaintenanceWindow)
+	if len(settings.MaintenanceWindow) > 0 && !rdsMaintenanceWindowRegex.MatchString(settings.MaintenanceWindow) {
+		return nil, fmt.Errorf("invalid maintenance window %s, expected ddd:hh24:mi-ddd:hh24:mi", settings.MaintenanceWindow)
+	}
+	if settings.BackupRetentionDays != nil && (*settings.BackupRetentionDays < 0 || *settings.BackupRetentionDays > 35) {
+		return nil, fmt.Errorf("backup retention must be between 0 and 35 days")
+	}
+	if settings.ReadReplicas < 0 || settings.ReadReplicas > maxRdsReadReplicas {
+		return nil, fmt.Errorf("read replicas must be between 0 and %d", maxRdsReadReplicas)
+	}
+	if settings.ReadReplicas > 0 && settings.BackupRetentionDays != nil && *settings.BackupRetentionDays == 0 {
+		return nil, fmt.Errorf("read replicas need automated backups, backup retention can't be 0")
+	}
+	return settings, nil
+}
+
+func getDBParameterGroupName(parameters map[string]interface{}, family string) (string, error) {
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return "", err
+	}
+	//pg-<deploymentID>-<family> so a major version upgrade gets a new group
+	return fmt.Sprintf("pg-%s-%s", deploymentID, strings.Trim(nonAlphanumericRegex.ReplaceAllString(family, "-"), "-")), nil
+}
+
+func getRdsReadReplicaIdentifier(rdsInstanceIdentifier string, index int) string {
+	//rds-<deploymentID>-r<index>
+	return fmt.Sprintf("%s-r%d", rdsInstanceIdentifier, index)
+}
+
+// getRdsEngineVersion describes the engine version, the default one when
+// engineVersion is empty.
+func getRdsEngineVersion(rdsClient *rds.Client, engine, engineVersion string) (*rdsTypes.DBEngineVersion, error) {
+	describeDBEngineVersionsInput := &rds.DescribeDBEngineVersionsInput{
+		Engine: aws.String(engine),
+	}
+	if len(engineVersion) > 0 {
+		describeDBEngineVersionsInput.EngineVersion = aws.String(engineVersion)
+	} else {
+		describeDBEngineVersionsInput.DefaultOnly = aws.Bool(true)
+	}
+	describeDBEngineVersionsOutput, err := rdsClient.DescribeDBEngineVersions(context.TODO(), describeDBEngineVersionsInput)
+	if err != nil {
+		return nil, err
+	}
+	if len(describeDBEngineVersionsOutput.DBEngineVersions) == 0 {
+		return nil, fmt.Errorf("unsupported %s engine version %s", engine, engineVersion)
+	}
+	return &describeDBEngineVersionsOutput.DBEngineVersions[0], nil
+}
+
+// isMajorVersionUpgrade checks the target is a valid upgrade of the current
+// version and reports if it's a major one.
+func isMajorVersionUpgrade(current *rdsTypes.DBEngineVersion, targetVersion string) (bool, error) {
+	for _, upgradeTarget := range current.ValidUpgradeTarget {
+		if aws.ToString(upgradeTarget.EngineVersion) == targetVersion {
+			return aws.ToBool(upgradeTarget.IsMajorVersionUpgrade), nil
+		}
+	}
+	return false, fmt.Errorf("%s can't be upgraded to %s", aws.ToString(current.EngineVersion), targetVersion)
+}
+
+// getApplyMethod applies dynamic parameters right away and static ones at
+// the next reboot, RDS rejects immediate for static parameters.
+func getApplyMethod(applyType string) rdsTypes.ApplyMethod {
+	if applyType == "dynamic" {
+		return rdsTypes.ApplyMethodImmediate
+	}
+	return rdsTypes.ApplyMethodPendingReboot
+}
+
+func getDefaultDBParameterGroupName(family string) string {
+	//default.<family>, e.g. default.postgres16
+	return fmt.Sprintf("default.%s", family)
+}
+
+// getDBParametersToReset returns the parameters set on the group that are no
+// longer among the custom parameters, sorted by name.
+func getDBParametersToReset(dbParameters []rdsTypes.Parameter, customParameters map[string]string) []rdsTypes.Parameter {
+	var parametersToReset []rdsTypes.Parameter
+	for _, dbParameter := range dbParameters {
+		name := aws.ToString(dbParameter.ParameterName)
+		if aws.ToString(dbParameter.Source) != "user" {
+			continue
+		}
+		if _, ok := customParameters[name]; ok {
+			continue
+		}
+		parametersToReset = append(parametersToReset, rdsTypes.Parameter{
+			ApplyMethod:   getApplyMethod(aws.ToString(dbParameter.ApplyType)),
+			ParameterName: aws.String(name),
+		})
+	}
+	sort.Slice(parametersToReset, func(i, j int) bool {
+		return aws.ToString(parametersToReset[i].ParameterName) < aws.ToString(parametersToReset[j].ParameterName)
+	})
+	return parametersToReset
+}
+
+// syncDBParameterGroupIfNeeded creates the deployment's parameter group for
+// the engine family, sets the custom parameters on it and resets the ones
+// that were removed. It returns the group the instance should use: the
+// engine's default group when there are no custom parameters.
+func syncDBParameterGroupIfNeeded(parameters map[string]interface{}, rdsClient *rds.Client, settings *rdsSettings, family string,
+	logsWriter io.Writer) (string, error) {
+	if len(settings.ParameterGroupFamily) > 0 {
+		family = settings.ParameterGroupFamily
+	}
+	if len(settings.Parameters) == 0 {
+		//the deployment's group is left for deleteDBParameterGroups
+		return getDefaultDBParameterGroupName(family), nil
+	}
+	parameterGroupName, err := getDBParameterGroupName(parameters, family)
+	if err != nil {
+		return "", err
+	}
+	_, err = rdsClient.DescribeDBParameterGroups(context.TODO(), &rds.DescribeDBParameterGroupsInput{
+		DBParameterGroupName: aws.String(parameterGroupName),
+	})
+	var dbParameterGroupNotFoundFault *rdsTypes.DBParameterGroupNotFoundFault
+	if err != nil && !errors.As(err, &dbParameterGroupNotFoundFault) {
+		return "", err
+	}
+	if err != nil {
+		io.WriteString(logsWriter, fmt.Sprintf("Creating DB parameter group: %s\n", parameterGroupName))
+		_, err = rdsClient.CreateDBParameterGroup(context.TODO(), &rds.CreateDBParameterGroupInput{
+			DBParameterGroupFamily: aws.String(family),
+			DBParameterGroupName:   aws.String(parameterGroupName),
+			Description:            aws.String(fmt.Sprintf("parameter group %s", parameterGroupName)),
+			Tags: []rdsTypes.Tag{
+				{
+					Key:   aws.String("Name"),
+					Value: aws.String(parameterGroupName),
+				},
+				{
+					Key:   aws.String("created by"),
+					Value: aws.String("deployment.io"),
+				},
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	applyTypes := map[string]string{}
+	var dbParameters []rdsTypes.Parameter
+	paginator := rds.NewDescribeDBParametersPaginator(rdsClient, &rds.DescribeDBParametersInput{
+		DBParameterGroupName: aws.String(parameterGroupName),
+	})
+	for paginator.HasMorePages() {
+		describeDBParametersOutput, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return "", err
+		}
+		for _, parameter := range describeDBParametersOutput.Parameters {
+			applyTypes[aws.ToString(parameter.ParameterName)] = aws.ToString(parameter.ApplyType)
+		}
+		dbParameters = append(dbParameters, describeDBParametersOutput.Parameters...)
+	}
+	names := make([]string, 0, len(settings.Parameters))
+	for name := range settings.Parameters {
+		if _, ok := applyTypes[name]; !ok {
+			return "", fmt.Errorf("unknown %s parameter %s", family, name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	//ModifyDBParameterGroup takes at most 20 parameters a call
+	for start := 0; start < len(names); start += 20 {
+		end := min(start+20, len(names))
+		var dbParameters []rdsTypes.Parameter
+		for _, name := range names[start:end] {
+			dbParameters = append(dbParameters, rdsTypes.Parameter{
+				ApplyMethod:    getApplyMethod(applyTypes[name]),
+				ParameterName:  aws.String(name),
+				ParameterValue: aws.String(settings.Parameters[name]),
+			})
+		}
+		_, err = rdsClient.ModifyDBParameterGroup(context.TODO(), &rds.ModifyDBParameterGroupInput{
+			DBParameterGroupName: aws.String(parameterGroupName),
+			Parameters:           dbParameters,
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	//parameters removed from the settings go back to the family's defaults
+	parametersToReset := getDBParametersToReset(dbParameters, settings.Parameters)
+	for start := 0; start < len(parametersToReset); start += 20 {
+		end := min(start+20, len(parametersToReset))
+		for _, parameter := range parametersToReset[start:end] {
+			io.WriteString(logsWriter, fmt.Sprintf("Resetting DB parameter: %s\n", aws.ToString(parameter.ParameterName)))
+		}
+		_, err = rdsClient.ResetDBParameterGroup(context.TODO(), &rds.ResetDBParameterGroupInput{
+			DBParameterGroupName: aws.String(parameterGroupName),
+			Parameters:           parametersToReset[start:end],
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return parameterGroupName, nil
+}
+
+func usesDBParameterGroup(dbInstance rdsTypes.DBInstance, dbParameterGroupName string) bool {
+	for _, dbParameterGroup := range dbInstance.DBParameterGroups {
+		if aws.ToString(dbParameterGroup.DBParameterGroupName) == dbParameterGroupName {
+			return true
+		}
+	}
+	return false
+}
+
+// syncRdsReadReplicas creates replicas up to the configured count and deletes
+// the ones above it, puts them on the primary's parameter group, then reports
+// the replica endpoints.
+func syncRdsReadReplicas(parameters map[string]interface{}, rdsClient *rds.Client, rdsInstanceIdentifier string, readReplicas int,
+	dbParameterGroupName string, logsWriter io.Writer) error {
+	describeDBInstancesOutput, err := rdsClient.DescribeDBInstances(context.TODO(), &rds.DescribeDBInstancesInput{
+		DBInstanceIdentifier: aws.String(rdsInstanceIdentifier),
+	})
+	if err != nil {
+		return err
+	}
+	if len(describeDBInstancesOutput.DBInstances) == 0 {
+		return fmt.Errorf("DB instance not available")
+	}
+	primary := describeDBInstancesOutput.DBInstances[0]
+	existing := map[string]bool{}
+	for _, replicaIdentifier := range primary.ReadReplicaDBInstanceIdentifiers {
+		existing[replicaIdentifier] = true
+	}
+
+	var replicaIdentifiers []string
+	for i := 0; i < maxRdsReadReplicas; i++ {
+		replicaIdentifier := getRdsReadReplicaIdentifier(rdsInstanceIdentifier, i)
+		if i >= readReplicas {
+			if existing[replicaIdentifier] {
+				err = deleteRdsReadReplica(rdsClient, replicaIdentifier, logsWriter)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		replicaIdentifiers = append(replicaIdentifiers, replicaIdentifier)
+		if existing[replicaIdentifier] {
+			continue
+		}
+		io.WriteString(logsWriter, fmt.Sprintf("Creating RDS read replica: %s\n", replicaIdentifier))
+		_, err = rdsClient.CreateDBInstanceReadReplica(context.TODO(), &rds.CreateDBInstanceReadReplicaInput{
+			DBInstanceIdentifier:       aws.String(replicaIdentifier),
+			SourceDBInstanceIdentifier: aws.String(rdsInstanceIdentifier),
+			DBInstanceClass:            primary.DBInstanceClass,
+			DBParameterGroupName:       aws.String(dbParameterGroupName),
+			AutoMinorVersionUpgrade:    aws.Bool(true),
+			CopyTagsToSnapshot:         aws.Bool(true),
+			PubliclyAccessible:         aws.Bool(false),
+			Tags: []rdsTypes.Tag{
+				{
+					Key:   aws.String("Name"),
+					Value: aws.String(replicaIdentifier),
+				},
+				{
+					Key:   aws.String("created by"),
+					Value: aws.String("deployment.io"),
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	readReplicaEndpoints := []string{}
+	for _, replicaIdentifier := range replicaIdentifiers {
+		err = waitTillRdsAvailable(rdsClient, replicaIdentifier, replicaIdentifier, false, logsWriter)
+		if err != nil {
+			return err
+		}
+		describeDBInstancesOutput, err = rdsClient.DescribeDBInstances(context.TODO(), &rds.DescribeDBInstancesInput{
+			DBInstanceIdentifier: aws.String(replicaIdentifier),
+		})
+		if err != nil {
+			return err
+		}
+		if len(describeDBInstancesOutput.DBInstances) > 0 &&
+			!usesDBParameterGroup(describeDBInstancesOutput.DBInstances[0], dbParameterGroupName) {
+			io.WriteString(logsWriter, fmt.Sprintf("Setting DB parameter group of RDS read replica %s to %s\n", replicaIdentifier,
+				dbParameterGroupName))
+			_, err = rdsClient.ModifyDBInstance(context.TODO(), &rds.ModifyDBInstanceInput{
+				DBInstanceIdentifier: aws.String(replicaIdentifier),
+				DBParameterGroupName: aws.String(dbParameterGroupName),
+				ApplyImmediately:     aws.Bool(true),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if len(describeDBInstancesOutput.DBInstances) > 0 && describeDBInstancesOutput.DBInstances[0].Endpoint != nil {
+			endpoint := describeDBInstancesOutput.DBInstances[0].Endpoint
+			readReplicaEndpoints = append(readReplicaEndpoints, fmt.Sprintf("%s:%d", aws.ToString(endpoint.Address), aws.ToInt32(endpoint.Port)))
+		}
+	}
+	if len(readReplicaEndpoints) > 0 {
+		io.WriteString(logsWriter, fmt.Sprintf("RDS read replicas are available at: %s\n", strings.Join(readReplicaEndpoints, ", ")))
+	}
+
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return err
+	}
+	organizationIdFromJob, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIdFromJob)
+	if err != nil {
+		return err
+	}
+	commandUtils.UpdateDeploymentsPipeline.Add(organizationIdFromJob, deployments.UpdateDeploymentDtoV1{
+		ID:                      deploymentID,
+		RdsReadReplicaEndpoints: readReplicaEndpoints,
+	})
+	return nil
+}
+
+func deleteRdsReadReplica(rdsClient *rds.Client, replicaIdentifier string, logsWriter io.Writer) error {
+	io.WriteString(logsWriter, fmt.Sprintf("Deleting RDS read replica: %s\n", replicaIdentifier))
+	_, err := rdsClient.DeleteDBInstance(context.TODO(), &rds.DeleteDBInstanceInput{
+		DBInstanceIdentifier: aws.String(replicaIdentifier),
+		SkipFinalSnapshot:    aws.Bool(true),
+	})
+	var dbInstanceNotFoundFault *rdsTypes.DBInstanceNotFoundFault
+	if err != nil && !errors.As(err, &dbInstanceNotFoundFault) {
+		return err
+	}
+	return nil
+}
+
+// deleteRdsReadReplicas removes every replica of the instance and waits for
+// them to be gone, deleting the primary first would promote them instead.
+func deleteRdsReadReplicas(rdsClient *rds.Client, primary rdsTypes.DBInstance, logsWriter io.Writer) error {
+	for _, replicaIdentifier := range primary.ReadReplicaDBInstanceIdentifiers {
+		err := deleteRdsReadReplica(rdsClient, replicaIdentifier, logsWriter)
+		if err != nil {
+			return err
+		}
+	}
+	waiter := rds.NewDBInstanceDeletedWaiter(rdsClient)
+	for _, replicaIdentifier := range primary.ReadReplicaDBInstanceIdentifiers {
+		err := waiter.Wait(context.TODO(), &rds.DescribeDBInstancesInput{
+			DBInstanceIdentifier: aws.String(replicaIdentifier),
+		}, rdsSnapshotWaitDuration)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteDBParameterGroups deletes the deployment's parameter groups once the
+// instance using them is gone.
+func deleteDBParameterGroups(parameters map[string]interface{}, rdsClient *rds.Client, rdsInstanceIdentifier string,
+	logsWriter io.Writer) error {
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return err
+	}
+	prefix := fmt.Sprintf("pg-%s-", deploymentID)
+	var parameterGroupNames []string
+	paginator := rds.NewDescribeDBParameterGroupsPaginator(rdsClient, &rds.DescribeDBParameterGroupsInput{})
+	for paginator.HasMorePages() {
+		describeDBParameterGroupsOutput, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return err
+		}
+		for _, parameterGroup := range describeDBParameterGroupsOutput.DBParameterGroups {
+			if strings.HasPrefix(aws.ToString(parameterGroup.DBParameterGroupName), prefix) {
+				parameterGroupNames = append(parameterGroupNames, aws.ToString(parameterGroup.DBParameterGroupName))
+			}
+		}
+	}
+	if len(parameterGroupNames) == 0 {
+		return nil
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Waiting for RDS database to be deleted: %s\n", rdsInstanceIdentifier))
+	waiter := rds.NewDBInstanceDeletedWaiter(rdsClient)
+	err = waiter.Wait(context.TODO(), &rds.DescribeDBInstancesInput{
+		DBInstanceIdentifier: aws.String(rdsInstanceIdentifier),
+	}, rdsSnapshotWaitDuration)
+	if err != nil {
+		return err
+	}
+	for _, parameterGroupName := range parameterGroupNames {
+		io.WriteString(logsWriter, fmt.Sprintf("Deleting DB parameter group: %s\n", parameterGroupName))
+		_, err = rdsClient.DeleteDBParameterGroup(context.TODO(), &rds.DeleteDBParameterGroupInput{
+			DBParameterGroupName: aws.String(parameterGroupName),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	rdsTypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+)
+
+func TestParseRdsSettings(t *testing.T) {
+	settings, err := parseRdsSettings([]byte(`{"backup_window": "03:00-03:30", "maintenance_window": "Sun:04:00-Sun:04:30",
+		"backup_retention_days": 7, "read_replicas": 2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.MaintenanceWindow != "sun:04:00-sun:04:30" {
+		t.Errorf("maintenance window = %s, want it lower cased", settings.MaintenanceWindow)
+	}
+	if aws.ToInt32(settings.BackupRetentionDays) != 7 || settings.ReadReplicas != 2 {
+		t.Errorf("settings = %+v", settings)
+	}
+}
+
+func TestParseRdsSettings_Invalid(t *testing.T) {
+	cases := map[string]string{
+		"backup window":           `{"backup_window": "3:00-3:30"}`,
+		"maintenance window":      `{"maintenance_window": "sunday:04:00-sunday:04:30"}`,
+		"retention":               `{"backup_retention_days": 36}`,
+		"too many replicas":       `{"read_replicas": 6}`,
+		"replicas without backup": `{"read_replicas": 1, "backup_retention_days": 0}`,
+	}
+	for name, settingsJSON := range cases {
+		if _, err := parseRdsSettings([]byte(settingsJSON)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestIsMajorVersionUpgrade(t *testing.T) {
+	current := &rdsTypes.DBEngineVersion{
+		EngineVersion: aws.String("15.5"),
+		ValidUpgradeTarget: []rdsTypes.UpgradeTarget{
+			{EngineVersion: aws.String("15.6"), IsMajorVersionUpgrade: aws.Bool(false)},
+			{EngineVersion: aws.String("16.2"), IsMajorVersionUpgrade: aws.Bool(true)},
+		},
+	}
+	if major, err := isMajorVersionUpgrade(current, "15.6"); err != nil || major {
+		t.Errorf("15.6: major = %v, err = %v", major, err)
+	}
+	if major, err := isMajorVersionUpgrade(current, "16.2"); err != nil || !major {
+		t.Errorf("16.2: major = %v, err = %v", major, err)
+	}
+	if _, err := isMajorVersionUpgrade(current, "14.1"); err == nil {
+		t.Error("expected an error for a downgrade")
+	}
+}
+
+// TestGetDBParametersToReset resets only what was set on the group and is no
+// longer configured.
+func TestGetDBParametersToReset(t *testing.T) {
+	dbParameters := []rdsTypes.Parameter{
+		{ParameterName: aws.String("work_mem"), Source: aws.String("user"), ApplyType: aws.String("dynamic")},
+		{ParameterName: aws.String("shared_buffers"), Source: aws.String("user"), ApplyType: aws.String("static")},
+		{ParameterName: aws.String("log_min_duration_statement"), Source: aws.String("user"), ApplyType: aws.String("dynamic")},
+		{ParameterName: aws.String("max_connections"), Source: aws.String("system"), ApplyType: aws.String("static")},
+	}
+	got := getDBParametersToReset(dbParameters, map[string]string{"work_mem": "8192"})
+	if len(got) != 2 {
+		t.Fatalf("parameters to reset = %d, want 2", len(got))
+	}
+	if aws.ToString(got[0].ParameterName) != "log_min_duration_statement" || got[0].ApplyMethod != rdsTypes.ApplyMethodImmediate {
+		t.Errorf("got[0] = %s %s", aws.ToString(got[0].ParameterName), got[0].ApplyMethod)
+	}
+	if aws.ToString(got[1].ParameterName) != "shared_buffers" || got[1].ApplyMethod != rdsTypes.ApplyMethodPendingReboot {
+		t.Errorf("got[1] = %s %s", aws.ToString(got[1].ParameterName), got[1].ApplyMethod)
+	}
+}
+
+func TestUsesDBParameterGroup(t *testing.T) {
+	dbInstance := rdsTypes.DBInstance{
+		DBParameterGroups: []rdsTypes.DBParameterGroupStatus{{DBParameterGroupName: aws.String("pg-dep1-postgres16")}},
+	}
+	if !usesDBParameterGroup(dbInstance, "pg-dep1-postgres16") {
+		t.Error("expected the instance to use pg-dep1-postgres16")
+	}
+	if usesDBParameterGroup(dbInstance, getDefaultDBParameterGroupName("postgres16")) {
+		t.Error("expected the instance not to use default.postgres16")
+	}
+}
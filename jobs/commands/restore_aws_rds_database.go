@@ -0,0 +1,459 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	rdsTypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/enums/deployment_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	"github.com/deployment-io/deployment-runner-kit/utils"
+)
+
+// RestoreAwsRdsDatabase creates the deployment's RDS instance from another
+// database's data: from the snapshot in RdsSnapshotIdentifier, or from the
+// source deployment's database (RdsSourceDeploymentID) as it was at
+// RdsRestoreTime. The restored instance gets a new master password. When the
+// deployment already has an instance, the data is restored next to it and
+// the restored instance takes over its identifier, and so its endpoint.
+type RestoreAwsRdsDatabase struct {
+}
+
+// CloneAwsRdsDatabase creates the deployment's RDS instance, typically for a
+// preview or staging environment, as a copy of the source deployment's
+// database at its latest restorable time, in the same DB subnet group.
+type CloneAwsRdsDatabase struct {
+}
+
+func getRdsSourceDBInstanceIdentifier(parameters map[string]interface{}) (string, error) {
+	sourceDeploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.RdsSourceDeploymentID)
+	if err != nil {
+		return "", err
+	}
+	if len(sourceDeploymentID) == 0 {
+		return "", fmt.Errorf("source deployment is required")
+	}
+	//rds-<deploymentID> of the source deployment
+	return fmt.Sprintf("rds-%s", sourceDeploymentID), nil
+}
+
+// getRdsRestoreTime parses RdsRestoreTime, an RFC 3339 timestamp. An empty
+// value means the latest restorable time.
+func getRdsRestoreTime(parameters map[string]interface{}) (*time.Time, error) {
+	restoreTime, err := jobs.GetParameterValue[string](parameters, parameters_enums.RdsRestoreTime)
+	if err != nil || len(restoreTime) == 0 {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, restoreTime)
+	if err != nil {
+		return nil, fmt.Errorf("invalid restore time %s: %s", restoreTime, err)
+	}
+	if t.After(time.Now()) {
+		return nil, fmt.Errorf("restore time %s is in the future", restoreTime)
+	}
+	return &t, nil
+}
+
+// rdsRestoreTarget is what every restore shares: the new instance's
+// identifier, subnet group, class and tags. replaces is the deployment's
+// existing instance the restored one takes over from, restored is set when an
+// earlier run of the job already started the restore.
+type rdsRestoreTarget struct {
+	identifier         string
+	replaces           string
+	restored           bool
+	subnetGroupName    string
+	instanceClass      *string
+	multiAZ            bool
+	deletionProtection bool
+	tags               []rdsTypes.Tag
+}
+
+func getRdsRestoreTarget(parameters map[string]interface{}, rdsClient *rds.Client, logsWriter io.Writer) (*rdsRestoreTarget, error) {
+	rdsInstanceIdentifier, err := getRdsDBInstanceIdentifier(parameters)
+	if err != nil {
+		return nil, err
+	}
+	instanceExists, err := rdsInstanceExists(rdsClient, rdsInstanceIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	restoreInstanceIdentifier := getRdsRestoreInstanceIdentifier(rdsInstanceIdentifier)
+	restoreInstanceExists, err := rdsInstanceExists(rdsClient, restoreInstanceIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	dbSubnetGroupName, err := createDBSubnetGroupIfNeeded(parameters, rdsClient, logsWriter)
+	if err != nil {
+		return nil, err
+	}
+	target := &rdsRestoreTarget{
+		identifier:      rdsInstanceIdentifier,
+		subnetGroupName: dbSubnetGroupName,
+		tags: []rdsTypes.Tag{
+			{
+				Key:   aws.String("created by"),
+				Value: aws.String("deployment.io"),
+			},
+		},
+	}
+	if instanceExists || restoreInstanceExists {
+		//the existing instance, which may be the source of the restore, stays
+		//up until the restored one is ready to take over
+		target.identifier = restoreInstanceIdentifier
+		target.replaces = rdsInstanceIdentifier
+		target.restored = restoreInstanceExists
+	}
+	//keep the source's instance class unless the deployment has its own
+	if rdsInstanceType, err := jobs.GetParameterValue[int64](parameters, parameters_enums.CpuMemoryRDSInstance); err == nil {
+		target.instanceClass = aws.String(deployment_enums.CpuMemoryRDSInstance(rdsInstanceType).Instance())
+	}
+	target.multiAZ, _ = jobs.GetParameterValue[bool](parameters, parameters_enums.UseMultiAz)
+	target.deletionProtection, _ = jobs.GetParameterValue[bool](parameters, parameters_enums.UseDeletionProtection)
+	return target, nil
+}
+
+func getRdsRestoreInstanceIdentifier(rdsInstanceIdentifier string) string {
+	//rds-<deploymentID>-restore
+	return fmt.Sprintf("%s-restore", rdsInstanceIdentifier)
+}
+
+// getRdsReplacedInstanceIdentifier is the identifier the deployment's instance
+// is kept under once a restored instance has taken over from it.
+func getRdsReplacedInstanceIdentifier(rdsInstanceIdentifier string, replacedAt time.Time) string {
+	//rds-<deploymentID>-old-<yyyymmddhhmm>
+	return fmt.Sprintf("%s-old-%s", rdsInstanceIdentifier, replacedAt.UTC().Format("200601021504"))
+}
+
+func rdsInstanceExists(rdsClient *rds.Client, rdsInstanceIdentifier string) (bool, error) {
+	describeDBInstancesOutput, err := rdsClient.DescribeDBInstances(context.TODO(), &rds.DescribeDBInstancesInput{
+		DBInstanceIdentifier: aws.String(rdsInstanceIdentifier),
+	})
+	var dbInstanceNotFoundFault *rdsTypes.DBInstanceNotFoundFault
+	if err != nil {
+		if errors.As(err, &dbInstanceNotFoundFault) {
+			return false, nil
+		}
+		return false, err
+	}
+	return len(describeDBInstancesOutput.DBInstances) > 0, nil
+}
+
+// renameRdsInstance gives an instance a new identifier and waits for it under
+// that identifier. The endpoint follows the identifier.
+func renameRdsInstance(rdsClient *rds.Client, rdsInstanceIdentifier, newRdsInstanceIdentifier string, multiAZ bool,
+	logsWriter io.Writer) error {
+	io.WriteString(logsWriter, fmt.Sprintf("Renaming RDS database %s to %s\n", rdsInstanceIdentifier, newRdsInstanceIdentifier))
+	_, err := rdsClient.ModifyDBInstance(context.TODO(), &rds.ModifyDBInstanceInput{
+		DBInstanceIdentifier:    aws.String(rdsInstanceIdentifier),
+		NewDBInstanceIdentifier: aws.String(newRdsInstanceIdentifier),
+		ApplyImmediately:        aws.Bool(true),
+	})
+	if err != nil {
+		return err
+	}
+	//the rename takes a moment before the instance shows up under its new identifier
+	time.Sleep(30 * time.Second)
+	return waitTillRdsAvailable(rdsClient, newRdsInstanceIdentifier, newRdsInstanceIdentifier, multiAZ, logsWriter)
+}
+
+// swapRestoredRds moves the deployment's instance, and its read replicas, out
+// of the way and gives its identifier and endpoint to the restored instance.
+// The replaced instance is deleted by deleteReplacedRdsInstances once the
+// restored one has taken over.
+func swapRestoredRds(rdsClient *rds.Client, target *rdsRestoreTarget, logsWriter io.Writer) error {
+	describeDBInstancesOutput, err := rdsClient.DescribeDBInstances(context.TODO(), &rds.DescribeDBInstancesInput{
+		DBInstanceIdentifier: aws.String(target.replaces),
+	})
+	var dbInstanceNotFoundFault *rdsTypes.DBInstanceNotFoundFault
+	if err != nil && !errors.As(err, &dbInstanceNotFoundFault) {
+		return err
+	}
+	//a rerun after the replaced instance was already moved finds nothing here
+	if err == nil && len(describeDBInstancesOutput.DBInstances) > 0 {
+		replaced := describeDBInstancesOutput.DBInstances[0]
+		replacedInstanceIdentifier := getRdsReplacedInstanceIdentifier(target.replaces, time.Now())
+		err = renameRdsInstance(rdsClient, target.replaces, replacedInstanceIdentifier, aws.ToBool(replaced.MultiAZ), logsWriter)
+		if err != nil {
+			return err
+		}
+		//the read replicas keep following the replaced instance, they move with
+		//it so the restored instance can have its own
+		for _, replicaIdentifier := range replaced.ReadReplicaDBInstanceIdentifiers {
+			if !strings.HasPrefix(replicaIdentifier, target.replaces) {
+				continue
+			}
+			err = renameRdsInstance(rdsClient, replicaIdentifier,
+				replacedInstanceIdentifier+strings.TrimPrefix(replicaIdentifier, target.replaces), false, logsWriter)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = renameRdsInstance(rdsClient, target.identifier, target.replaces, target.multiAZ, logsWriter)
+	if err != nil {
+		return err
+	}
+	target.identifier = target.replaces
+	target.replaces = ""
+	return nil
+}
+
+func restoreRdsFromSnapshot(rdsClient *rds.Client, target *rdsRestoreTarget, snapshotIdentifier string, logsWriter io.Writer) error {
+	io.WriteString(logsWriter, fmt.Sprintf("Restoring RDS database %s from snapshot %s\n", target.identifier, snapshotIdentifier))
+	_, err := rdsClient.RestoreDBInstanceFromDBSnapshot(context.TODO(), &rds.RestoreDBInstanceFromDBSnapshotInput{
+		DBInstanceIdentifier: aws.String(target.identifier),
+		DBSnapshotIdentifier: aws.String(snapshotIdentifier),
+		DBInstanceClass:      target.instanceClass,
+		DBSubnetGroupName:    aws.String(target.subnetGroupName),
+		CopyTagsToSnapshot:   aws.Bool(true),
+		DeletionProtection:   aws.Bool(target.deletionProtection),
+		MultiAZ:              aws.Bool(target.multiAZ),
+		PubliclyAccessible:   aws.Bool(false),
+		Tags:                 target.tags,
+	})
+	return err
+}
+
+func restoreRdsToPointInTime(rdsClient *rds.Client, target *rdsRestoreTarget, sourceIdentifier string, restoreTime *time.Time,
+	logsWriter io.Writer) error {
+	restoreDBInstanceToPointInTimeInput := &rds.RestoreDBInstanceToPointInTimeInput{
+		TargetDBInstanceIdentifier: aws.String(target.identifier),
+		SourceDBInstanceIdentifier: aws.String(sourceIdentifier),
+		DBInstanceClass:            target.instanceClass,
+		DBSubnetGroupName:          aws.String(target.subnetGroupName),
+		CopyTagsToSnapshot:         aws.Bool(true),
+		DeletionProtection:         aws.Bool(target.deletionProtection),
+		MultiAZ:                    aws.Bool(target.multiAZ),
+		PubliclyAccessible:         aws.Bool(false),
+		Tags:                       target.tags,
+	}
+	if restoreTime != nil {
+		io.WriteString(logsWriter, fmt.Sprintf("Restoring RDS database %s from %s as of %s\n", target.identifier, sourceIdentifier,
+			restoreTime.Format(time.RFC3339)))
+		restoreDBInstanceToPointInTimeInput.RestoreTime = restoreTime
+	} else {
+		io.WriteString(logsWriter, fmt.Sprintf("Restoring RDS database %s from %s as of its latest restorable time\n",
+			target.identifier, sourceIdentifier))
+		restoreDBInstanceToPointInTimeInput.UseLatestRestorableTime = aws.Bool(true)
+	}
+	_, err := rdsClient.RestoreDBInstanceToPointInTime(context.TODO(), restoreDBInstanceToPointInTimeInput)
+	return err
+}
+
+// finishRdsRestore waits for the restored instance, gives it its own master
+// password so the source's credentials aren't shared, swaps it in for the
+// deployment's existing instance, if any, and syncs the endpoint and
+// credentials to the deployment.
+func finishRdsRestore(parameters map[string]interface{}, rdsClient *rds.Client, target *rdsRestoreTarget, logsWriter io.Writer) error {
+	err := waitTillRdsAvailable(rdsClient, target.identifier, target.identifier, target.multiAZ, logsWriter)
+	if err != nil {
+		return err
+	}
+	masterUserPassword, err := utils.GenerateRandomString(15)
+	if err != nil {
+		return err
+	}
+	modifyDBInstanceOutput, err := rdsClient.ModifyDBInstance(context.TODO(), &rds.ModifyDBInstanceInput{
+		DBInstanceIdentifier: aws.String(target.identifier),
+		ApplyImmediately:     aws.Bool(true),
+		MasterUserPassword:   aws.String(masterUserPassword),
+	})
+	if err != nil {
+		return err
+	}
+	//the password change puts the instance in resetting-master-credentials for a moment
+	time.Sleep(30 * time.Second)
+	err = waitTillRdsAvailable(rdsClient, target.identifier, target.identifier, target.multiAZ, logsWriter)
+	if err != nil {
+		return err
+	}
+	masterUserName := aws.ToString(modifyDBInstanceOutput.DBInstance.MasterUsername)
+	if len(target.replaces) > 0 {
+		err = swapRestoredRds(rdsClient, target, logsWriter)
+		if err != nil {
+			return err
+		}
+	}
+	err = syncRds(parameters, rdsClient, target.identifier, masterUserPassword, masterUserName, logsWriter, false)
+	if err != nil {
+		return err
+	}
+	return deleteReplacedRdsInstances(parameters, rdsClient, target.identifier, false, logsWriter)
+}
+
+// getRdsReplacedPrimaryInstances returns the instances restores moved out of
+// the deployment's way, without their read replicas, which go with them.
+func getRdsReplacedPrimaryInstances(dbInstances []rdsTypes.DBInstance, rdsInstanceIdentifier string) []rdsTypes.DBInstance {
+	//rds-<deploymentID>-old-
+	prefix := fmt.Sprintf("%s-old-", rdsInstanceIdentifier)
+	var replaced []rdsTypes.DBInstance
+	for _, dbInstance := range dbInstances {
+		if !strings.HasPrefix(aws.ToString(dbInstance.DBInstanceIdentifier), prefix) ||
+			len(aws.ToString(dbInstance.ReadReplicaSourceDBInstanceIdentifier)) > 0 {
+			continue
+		}
+		replaced = append(replaced, dbInstance)
+	}
+	return replaced
+}
+
+// deleteReplacedRdsInstances deletes the instances, and their read replicas,
+// that restores moved out of the deployment's way. Each gets a final snapshot
+// unless SkipRdsSnapshot is set, so a restore can still be undone. With
+// waitForDeletion it returns once they are gone, for the delete command
+// that removes the parameter groups they use.
+func deleteReplacedRdsInstances(parameters map[string]interface{}, rdsClient *rds.Client, rdsInstanceIdentifier string,
+	waitForDeletion bool, logsWriter io.Writer) error {
+	var dbInstances []rdsTypes.DBInstance
+	paginator := rds.NewDescribeDBInstancesPaginator(rdsClient, &rds.DescribeDBInstancesInput{})
+	for paginator.HasMorePages() {
+		describeDBInstancesOutput, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return err
+		}
+		dbInstances = append(dbInstances, describeDBInstancesOutput.DBInstances...)
+	}
+	skipRdsSnapshot, _ := jobs.GetParameterValue[bool](parameters, parameters_enums.SkipRdsSnapshot)
+	var deletedInstanceIdentifiers []string
+	for _, replaced := range getRdsReplacedPrimaryInstances(dbInstances, rdsInstanceIdentifier) {
+		replacedInstanceIdentifier := aws.ToString(replaced.DBInstanceIdentifier)
+		deletedInstanceIdentifiers = append(deletedInstanceIdentifiers, replacedInstanceIdentifier)
+		if aws.ToString(replaced.DBInstanceStatus) == "deleting" {
+			continue
+		}
+		err := deleteRdsReadReplicas(rdsClient, replaced, logsWriter)
+		if err != nil {
+			return err
+		}
+		if aws.ToBool(replaced.DeletionProtection) {
+			_, err = rdsClient.ModifyDBInstance(context.TODO(), &rds.ModifyDBInstanceInput{
+				DBInstanceIdentifier: aws.String(replacedInstanceIdentifier),
+				DeletionProtection:   aws.Bool(false),
+				ApplyImmediately:     aws.Bool(true),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		deleteDBInstanceInput := &rds.DeleteDBInstanceInput{
+			DBInstanceIdentifier: aws.String(replacedInstanceIdentifier),
+			SkipFinalSnapshot:    aws.Bool(skipRdsSnapshot),
+		}
+		if !skipRdsSnapshot {
+			//rds-<deploymentID>-old-<yyyymmddhhmm>-final
+			deleteDBInstanceInput.FinalDBSnapshotIdentifier = aws.String(fmt.Sprintf("%s-final", replacedInstanceIdentifier))
+		}
+		io.WriteString(logsWriter, fmt.Sprintf("Deleting the replaced RDS database: %s\n", replacedInstanceIdentifier))
+		_, err = rdsClient.DeleteDBInstance(context.TODO(), deleteDBInstanceInput)
+		var dbInstanceNotFoundFault *rdsTypes.DBInstanceNotFoundFault
+		if err != nil && !errors.As(err, &dbInstanceNotFoundFault) {
+			return err
+		}
+	}
+	if !waitForDeletion {
+		return nil
+	}
+	waiter := rds.NewDBInstanceDeletedWaiter(rdsClient)
+	for _, deletedInstanceIdentifier := range deletedInstanceIdentifiers {
+		err := waiter.Wait(context.TODO(), &rds.DescribeDBInstancesInput{
+			DBInstanceIdentifier: aws.String(deletedInstanceIdentifier),
+		}, rdsSnapshotWaitDuration)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RestoreAwsRdsDatabase) Run(parameters map[string]interface{}, logsWriter io.Writer) (newParameters map[string]interface{}, err error) {
+	defer func() {
+		<-MarkDeploymentDone(parameters, err)
+	}()
+	err = addRdsPolicyForDeploymentRunner(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	rdsClient, err := cloud_api_clients.GetRdsClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	target, err := getRdsRestoreTarget(parameters, rdsClient, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	snapshotIdentifier, _ := jobs.GetParameterValue[string](parameters, parameters_enums.RdsSnapshotIdentifier)
+	if target.restored {
+		io.WriteString(logsWriter, fmt.Sprintf("Restore into %s already started\n", target.identifier))
+	} else if len(snapshotIdentifier) > 0 {
+		err = restoreRdsFromSnapshot(rdsClient, target, snapshotIdentifier, logsWriter)
+	} else {
+		var sourceIdentifier string
+		sourceIdentifier, err = getRdsSourceDBInstanceIdentifier(parameters)
+		if err != nil {
+			return parameters, err
+		}
+		var restoreTime *time.Time
+		restoreTime, err = getRdsRestoreTime(parameters)
+		if err != nil {
+			return parameters, err
+		}
+		err = restoreRdsToPointInTime(rdsClient, target, sourceIdentifier, restoreTime, logsWriter)
+	}
+	if err != nil {
+		return parameters, err
+	}
+	err = finishRdsRestore(parameters, rdsClient, target, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	return parameters, nil
+}
+
+func (c *CloneAwsRdsDatabase) Run(parameters map[string]interface{}, logsWriter io.Writer) (newParameters map[string]interface{}, err error) {
+	defer func() {
+		<-MarkDeploymentDone(parameters, err)
+	}()
+	err = addRdsPolicyForDeploymentRunner(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	rdsClient, err := cloud_api_clients.GetRdsClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	sourceIdentifier, err := getRdsSourceDBInstanceIdentifier(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	target, err := getRdsRestoreTarget(parameters, rdsClient, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	//a clone is a throwaway copy, it never blocks its own deletion
+	target.multiAZ = false
+	target.deletionProtection = false
+	target.tags = append(target.tags, rdsTypes.Tag{
+		Key:   aws.String("cloned from"),
+		Value: aws.String(sourceIdentifier),
+	})
+	if !target.restored {
+		err = restoreRdsToPointInTime(rdsClient, target, sourceIdentifier, nil, logsWriter)
+		if err != nil {
+			return parameters, err
+		}
+	}
+	err = finishRdsRestore(parameters, rdsClient, target, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	return parameters, nil
+}
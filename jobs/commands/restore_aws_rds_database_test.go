@@ -0,0 +1,41 @@
+package commands
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	rdsTypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+)
+
+func TestGetRdsRestoreInstanceIdentifiers(t *testing.T) {
+	if got := getRdsRestoreInstanceIdentifier("rds-dep1"); got != "rds-dep1-restore" {
+		t.Errorf("getRdsRestoreInstanceIdentifier() = %s", got)
+	}
+	replacedAt := time.Date(2024, 3, 5, 7, 9, 0, 0, time.UTC)
+	if got := getRdsReplacedInstanceIdentifier("rds-dep1", replacedAt); got != "rds-dep1-old-202403050709" {
+		t.Errorf("getRdsReplacedInstanceIdentifier() = %s", got)
+	}
+}
+
+// TestGetRdsReplacedPrimaryInstances finds the instances restores replaced
+// but not their read replicas or other deployments' instances.
+func TestGetRdsReplacedPrimaryInstances(t *testing.T) {
+	dbInstances := []rdsTypes.DBInstance{
+		{DBInstanceIdentifier: aws.String("rds-dep1")},
+		{DBInstanceIdentifier: aws.String("rds-dep1-r1"), ReadReplicaSourceDBInstanceIdentifier: aws.String("rds-dep1")},
+		{DBInstanceIdentifier: aws.String("rds-dep1-old-202403050709")},
+		{DBInstanceIdentifier: aws.String("rds-dep1-old-202403050709-r1"),
+			ReadReplicaSourceDBInstanceIdentifier: aws.String("rds-dep1-old-202403050709")},
+		{DBInstanceIdentifier: aws.String("rds-dep1-old-202402010000"), DBInstanceStatus: aws.String("deleting")},
+		{DBInstanceIdentifier: aws.String("rds-dep12-old-202403050709")},
+	}
+	var got []string
+	for _, dbInstance := range getRdsReplacedPrimaryInstances(dbInstances, "rds-dep1") {
+		got = append(got, aws.ToString(dbInstance.DBInstanceIdentifier))
+	}
+	if want := []string{"rds-dep1-old-202403050709", "rds-dep1-old-202402010000"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replaced instances = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,111 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/deployments"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	"github.com/deployment-io/deployment-runner-kit/previews"
+	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
+)
+
+// RollbackAwsLambdaFunction points the live alias back at an earlier
+// published version, LambdaFunctionVersion or else the one before the
+// current. The Function URL and ALB target follow the alias.
+type RollbackAwsLambdaFunction struct {
+}
+
+// getPreviousLambdaVersion is the newest published version older than the
+// current one.
+func getPreviousLambdaVersion(versions []string, currentVersion string) (string, error) {
+	current, err := strconv.Atoi(currentVersion)
+	if err != nil {
+		return "", fmt.Errorf("invalid lambda version: %s", currentVersion)
+	}
+	for _, version := range sortLambdaVersions(versions) {
+		v, err := strconv.Atoi(version)
+		if err == nil && v < current {
+			return version, nil
+		}
+	}
+	return "", fmt.Errorf("no version older than %s to roll back to", currentVersion)
+}
+
+func (r *RollbackAwsLambdaFunction) Run(parameters map[string]interface{}, logsWriter io.Writer) (newParameters map[string]interface{}, err error) {
+	err = addLambdaPolicyForDeploymentRunner(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	functionName, err := getLambdaFunctionName(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	lambdaClient, err := cloud_api_clients.GetLambdaClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	versions, err := getPublishedLambdaVersions(lambdaClient, functionName)
+	if err != nil {
+		return parameters, err
+	}
+
+	version, _ := jobs.GetParameterValue[string](parameters, parameters_enums.LambdaFunctionVersion)
+	if len(version) == 0 {
+		getAliasOutput, err := lambdaClient.GetAlias(context.TODO(), &lambda.GetAliasInput{
+			FunctionName: aws.String(functionName),
+			Name:         aws.String(lambdaAliasName),
+		})
+		if err != nil {
+			return parameters, err
+		}
+		version, err = getPreviousLambdaVersion(versions, aws.ToString(getAliasOutput.FunctionVersion))
+		if err != nil {
+			return parameters, err
+		}
+	} else {
+		found := false
+		for _, v := range versions {
+			found = found || v == version
+		}
+		if !found {
+			return parameters, fmt.Errorf("version %s of %s was pruned or never published", version, functionName)
+		}
+	}
+
+	io.WriteString(logsWriter, fmt.Sprintf("Rolling back Lambda function %s to version %s\n", functionName, version))
+	aliasArn, err := setLambdaAliasVersion(lambdaClient, functionName, version)
+	if err != nil {
+		return parameters, err
+	}
+
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return parameters, err
+	}
+	organizationIdFromJob, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIdFromJob)
+	if err != nil {
+		return parameters, err
+	}
+	if !isPreview(parameters) {
+		commandUtils.UpdateDeploymentsPipeline.Add(organizationIdFromJob, deployments.UpdateDeploymentDtoV1{
+			ID:                    deploymentID,
+			LambdaFunctionArn:     aliasArn,
+			LambdaFunctionVersion: version,
+		})
+	} else {
+		previewID := deploymentID
+		commandUtils.UpdatePreviewsPipeline.Add(organizationIdFromJob, previews.UpdatePreviewDtoV1{
+			ID:                    previewID,
+			LambdaFunctionArn:     aliasArn,
+			LambdaFunctionVersion: version,
+		})
+	}
+	return parameters, nil
+}
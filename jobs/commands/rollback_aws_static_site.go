@@ -0,0 +1,113 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/deployments"
+	"github.com/deployment-io/deployment-runner-kit/enums/iam_policy_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/iam_policies"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
+	"github.com/deployment-io/deployment-runner/utils"
+)
+
+// RollbackAwsStaticSite points the distribution back at a retained build,
+// StaticSiteBuildID or else the one uploaded before the live build, and
+// compiles that build's _redirects and _headers into the distribution's
+// functions again. Nothing is uploaded, so it takes as long as the
+// distribution updates.
+type RollbackAwsStaticSite struct {
+}
+
+func (r *RollbackAwsStaticSite) Run(parameters map[string]interface{}, logsWriter io.Writer) (newParameters map[string]interface{}, err error) {
+	runnerData := utils.RunnerData.Get()
+	organizationID, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIDNamespace)
+	if err != nil {
+		return parameters, err
+	}
+	err = iam_policies.AddAwsPolicyForDeploymentRunner(iam_policy_enums.AwsStaticSiteDeployment,
+		runnerData.OsType.String(), runnerData.CpuArchEnum.String(), organizationID, runnerData.RunnerRegion, runnerData.Mode, runnerData.TargetCloud)
+	if err != nil {
+		return parameters, err
+	}
+
+	cloudfrontID, err := jobs.GetParameterValue[string](parameters, parameters_enums.CloudfrontID)
+	if err != nil {
+		return parameters, err
+	}
+	bucketName, err := getBucketName(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	s3Client, err := cloud_api_clients.GetS3Client(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	cloudfrontClient, err := cloud_api_clients.GetCloudfrontClient(parameters, cloudfrontRegion)
+	if err != nil {
+		return parameters, err
+	}
+
+	buildID, _ := jobs.GetParameterValue[string](parameters, parameters_enums.StaticSiteBuildID)
+	if len(buildID) == 0 {
+		liveBuildID, err := getLiveStaticSiteBuildID(cloudfrontClient, cloudfrontID)
+		if err != nil {
+			return parameters, err
+		}
+		if len(liveBuildID) == 0 {
+			return parameters, fmt.Errorf("static site is served from the bucket root, redeploy it before rolling back")
+		}
+		builds, err := listStaticSiteBuilds(s3Client, bucketName)
+		if err != nil {
+			return parameters, err
+		}
+		buildID, err = getPreviousStaticSiteBuild(builds, liveBuildID)
+		if err != nil {
+			return parameters, err
+		}
+	} else {
+		_, found, err := getStaticSiteBuildUploadedAt(s3Client, bucketName, buildID)
+		if err != nil {
+			return parameters, err
+		}
+		if !found {
+			return parameters, fmt.Errorf("build %s was pruned or never finished uploading", buildID)
+		}
+	}
+
+	io.WriteString(logsWriter, fmt.Sprintf("Rolling back static site to build: %s\n", buildID))
+	err = switchStaticSiteBuild(cloudfrontClient, cloudfrontID, buildID, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	//without a build the functions read the rules of the live build, now the one rolled back to
+	_, err = (&DeployAwsCloudfrontViewerRequestFunction{}).Run(parameters, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	_, err = (&AddAwsStaticSiteResponseHeaders{}).Run(parameters, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	err = invalidateCloudfrontDistribution(parameters, cloudfrontClient, cloudfrontID, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return parameters, err
+	}
+	organizationIdFromJob, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIdFromJob)
+	if err != nil {
+		return parameters, err
+	}
+	commandUtils.UpdateDeploymentsPipeline.Add(organizationIdFromJob, deployments.UpdateDeploymentDtoV1{
+		ID:                deploymentID,
+		StaticSiteBuildID: buildID,
+	})
+	return parameters, nil
+}
@@ -0,0 +1,436 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	acmTypes "github.com/aws/aws-sdk-go-v2/service/acm/types"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	route53Types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/enums/iam_policy_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/iam_policies"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
+	"github.com/deployment-io/deployment-runner/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The runner manages the DNS records of a domain when its public hosted zone
+// is in the account, or in another account through Route53RoleArn. Domains
+// without a hosted zone are left for the user to configure.
+const (
+	// cloudfrontHostedZoneID is the hosted zone of every CloudFront
+	// distribution in alias records.
+	cloudfrontHostedZoneID      = "Z2FDTNDATAQYW2"
+	route53RoleSessionName      = "deployment-runner"
+	route53StsRegion            = "us-east-1"
+	acmValidationRecordTTL      = int64(300)
+	acmValidationRecordsRetries = 24
+)
+
+// aliasTarget is what alias records of a deployment's domains point to.
+type aliasTarget struct {
+	DNSName      string
+	HostedZoneID string
+	IPv6         bool
+}
+
+// getRoute53Client returns the client for the domains' hosted zones. Zones in
+// another account are managed by assuming Route53RoleArn there, with the
+// organization's ID as the external ID.
+func getRoute53Client(parameters map[string]interface{}) (*route53.Client, error) {
+	runnerData := utils.RunnerData.Get()
+	organizationID, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIDNamespace)
+	if err != nil {
+		return nil, err
+	}
+	err = iam_policies.AddAwsPolicyForDeploymentRunner(iam_policy_enums.AwsRoute53,
+		runnerData.OsType.String(), runnerData.CpuArchEnum.String(), organizationID, runnerData.RunnerRegion, runnerData.Mode, runnerData.TargetCloud)
+	if err != nil {
+		return nil, err
+	}
+	route53Client, err := cloud_api_clients.GetRoute53Client(parameters)
+	if err != nil {
+		return nil, err
+	}
+	roleArn, _ := jobs.GetParameterValue[string](parameters, parameters_enums.Route53RoleArn)
+	if len(roleArn) == 0 {
+		return route53Client, nil
+	}
+	options := route53Client.Options()
+	stsClient := sts.New(sts.Options{
+		Region:      route53StsRegion,
+		Credentials: options.Credentials,
+		HTTPClient:  options.HTTPClient,
+	})
+	provider := stscreds.NewAssumeRoleProvider(stsClient, roleArn, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = route53RoleSessionName
+		o.ExternalID = aws.String(organizationID)
+	})
+	return route53.New(options, func(o *route53.Options) {
+		o.Credentials = aws.NewCredentialsCache(provider)
+	}), nil
+}
+
+// normalizeDnsName is name as the records of a zone are compared: lower
+// case, without the trailing dot and with Route 53's escaped wildcard.
+func normalizeDnsName(name string) string {
+	name = strings.ReplaceAll(strings.ToLower(name), `\052`, "*")
+	return strings.TrimSuffix(name, ".")
+}
+
+// getHostedZoneCandidates is domain and its parent domains, longest first,
+// leaving out the top-level domain.
+func getHostedZoneCandidates(domain string) []string {
+	name := strings.TrimPrefix(normalizeDnsName(domain), "*.")
+	var candidates []string
+	for strings.Contains(name, ".") {
+		candidates = append(candidates, name)
+		name = name[strings.Index(name, ".")+1:]
+	}
+	return candidates
+}
+
+// findHostedZone finds the public hosted zone closest to domain. found is
+// false when there's none.
+func findHostedZone(route53Client *route53.Client, domain string) (zoneID string, found bool, err error) {
+	for _, candidate := range getHostedZoneCandidates(domain) {
+		listHostedZonesOutput, err := route53Client.ListHostedZonesByName(context.TODO(), &route53.ListHostedZonesByNameInput{
+			DNSName:  aws.String(candidate),
+			MaxItems: aws.Int32(10),
+		})
+		if err != nil {
+			return "", false, err
+		}
+		for _, zone := range listHostedZonesOutput.HostedZones {
+			if normalizeDnsName(aws.ToString(zone.Name)) != candidate || (zone.Config != nil && zone.Config.PrivateZone) {
+				continue
+			}
+			return strings.TrimPrefix(aws.ToString(zone.Id), "/hostedzone/"), true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// getRecordSets returns the records of the zone named domain.
+func getRecordSets(route53Client *route53.Client, zoneID, domain string) ([]route53Types.ResourceRecordSet, error) {
+	listResourceRecordSetsOutput, err := route53Client.ListResourceRecordSets(context.TODO(), &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(zoneID),
+		StartRecordName: aws.String(domain),
+		MaxItems:        aws.Int32(20),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var recordSets []route53Types.ResourceRecordSet
+	for _, recordSet := range listResourceRecordSetsOutput.ResourceRecordSets {
+		if normalizeDnsName(aws.ToString(recordSet.Name)) == normalizeDnsName(domain) {
+			recordSets = append(recordSets, recordSet)
+		}
+	}
+	return recordSets, nil
+}
+
+func (t aliasTarget) getRecordTypes() []route53Types.RRType {
+	if t.IPv6 {
+		return []route53Types.RRType{route53Types.RRTypeA, route53Types.RRTypeAaaa}
+	}
+	return []route53Types.RRType{route53Types.RRTypeA}
+}
+
+// isAliasTo compares DNS names without the dualstack prefix the console adds
+// for load balancers.
+func isAliasTo(recordSet route53Types.ResourceRecordSet, target aliasTarget) bool {
+	if recordSet.AliasTarget == nil {
+		return false
+	}
+	dnsName := func(name string) string {
+		return strings.TrimPrefix(normalizeDnsName(name), "dualstack.")
+	}
+	return dnsName(aws.ToString(recordSet.AliasTarget.DNSName)) == dnsName(target.DNSName)
+}
+
+// getAliasRecordChanges are the changes pointing domain at target, or
+// removing its records pointing at target on a delete. Records of another
+// target or a CNAME at domain are left alone and returned as conflicts.
+func getAliasRecordChanges(domain string, existing []route53Types.ResourceRecordSet, target aliasTarget,
+	action route53Types.ChangeAction) (changes []route53Types.Change, conflicts []string) {
+	existingByType := make(map[route53Types.RRType]route53Types.ResourceRecordSet)
+	for _, recordSet := range existing {
+		existingByType[recordSet.Type] = recordSet
+	}
+	if action == route53Types.ChangeActionDelete {
+		for _, recordType := range []route53Types.RRType{route53Types.RRTypeA, route53Types.RRTypeAaaa} {
+			recordSet, exists := existingByType[recordType]
+			if exists && isAliasTo(recordSet, target) {
+				changes = append(changes, route53Types.Change{Action: action, ResourceRecordSet: &recordSet})
+			}
+		}
+		return changes, nil
+	}
+	if _, exists := existingByType[route53Types.RRTypeCname]; exists {
+		return nil, []string{fmt.Sprintf("%s has a CNAME record", domain)}
+	}
+	for _, recordType := range target.getRecordTypes() {
+		recordSet, exists := existingByType[recordType]
+		if exists && isAliasTo(recordSet, target) {
+			continue
+		}
+		if exists {
+			conflicts = append(conflicts, fmt.Sprintf("%s has an %s record pointing elsewhere", domain, recordType))
+			continue
+		}
+		changes = append(changes, route53Types.Change{
+			Action: action,
+			ResourceRecordSet: &route53Types.ResourceRecordSet{
+				Name: aws.String(domain),
+				Type: recordType,
+				AliasTarget: &route53Types.AliasTarget{
+					DNSName:              aws.String(target.DNSName),
+					HostedZoneId:         aws.String(target.HostedZoneID),
+					EvaluateTargetHealth: false,
+				},
+			},
+		})
+	}
+	return changes, conflicts
+}
+
+// changeAliasRecords upserts or deletes the alias records of domains. Route 53
+// errors don't fail the job, the records to change are logged for the
+// domain's DNS provider instead.
+func changeAliasRecords(parameters map[string]interface{}, domains []string, target aliasTarget,
+	action route53Types.ChangeAction, logsWriter io.Writer) {
+	if len(domains) == 0 {
+		return
+	}
+	route53Client, err := getRoute53Client(parameters)
+	if err != nil {
+		io.WriteString(logsWriter, fmt.Sprintf("Couldn't access Route 53: %s\n", err))
+		for _, domain := range domains {
+			logManualAliasRecord(domain, target, action, logsWriter)
+		}
+		return
+	}
+	for _, domain := range domains {
+		err = changeDomainAliasRecords(route53Client, domain, target, action, logsWriter)
+		if err != nil {
+			io.WriteString(logsWriter, fmt.Sprintf("Couldn't change DNS records of %s in Route 53: %s\n", domain, err))
+			logManualAliasRecord(domain, target, action, logsWriter)
+		}
+	}
+}
+
+func changeDomainAliasRecords(route53Client *route53.Client, domain string, target aliasTarget,
+	action route53Types.ChangeAction, logsWriter io.Writer) error {
+	zoneID, found, err := findHostedZone(route53Client, domain)
+	if err != nil {
+		return err
+	}
+	if !found {
+		if action != route53Types.ChangeActionDelete {
+			io.WriteString(logsWriter, fmt.Sprintf("No Route 53 hosted zone found for %s\n", domain))
+			logManualAliasRecord(domain, target, action, logsWriter)
+		}
+		return nil
+	}
+	recordSets, err := getRecordSets(route53Client, zoneID, domain)
+	if err != nil {
+		return err
+	}
+	changes, conflicts := getAliasRecordChanges(domain, recordSets, target, action)
+	for _, conflict := range conflicts {
+		io.WriteString(logsWriter, fmt.Sprintf("Not changing DNS records of %s: %s\n", domain, conflict))
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	_, err = route53Client.ChangeResourceRecordSets(context.TODO(), &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(zoneID),
+		ChangeBatch: &route53Types.ChangeBatch{
+			Changes: changes,
+			Comment: aws.String("created by deployment.io"),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if action == route53Types.ChangeActionDelete {
+		io.WriteString(logsWriter, fmt.Sprintf("Deleted alias records of %s in hosted zone %s\n", domain, zoneID))
+	} else {
+		io.WriteString(logsWriter, fmt.Sprintf("Pointed %s to %s in hosted zone %s\n", domain, target.DNSName, zoneID))
+	}
+	return nil
+}
+
+func logManualAliasRecord(domain string, target aliasTarget, action route53Types.ChangeAction, logsWriter io.Writer) {
+	if action == route53Types.ChangeActionDelete {
+		io.WriteString(logsWriter, fmt.Sprintf("Delete the records of %s pointing to %s in your DNS provider\n",
+			domain, target.DNSName))
+		return
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Point %s to %s in your DNS provider\n", domain, target.DNSName))
+}
+
+func upsertAliasRecords(parameters map[string]interface{}, domains []string, target aliasTarget, logsWriter io.Writer) {
+	changeAliasRecords(parameters, domains, target, route53Types.ChangeActionUpsert, logsWriter)
+}
+
+func deleteAliasRecords(parameters map[string]interface{}, domains []string, target aliasTarget, logsWriter io.Writer) {
+	changeAliasRecords(parameters, domains, target, route53Types.ChangeActionDelete, logsWriter)
+}
+
+func getLoadBalancerAliasTarget(elbClient *elasticloadbalancingv2.Client, loadBalancerArn string) (aliasTarget, error) {
+	describeLoadBalancersOutput, err := elbClient.DescribeLoadBalancers(context.TODO(), &elasticloadbalancingv2.DescribeLoadBalancersInput{
+		LoadBalancerArns: []string{loadBalancerArn},
+	})
+	if err != nil {
+		return aliasTarget{}, err
+	}
+	if len(describeLoadBalancersOutput.LoadBalancers) == 0 {
+		return aliasTarget{}, fmt.Errorf("load balancer %s doesn't exist", loadBalancerArn)
+	}
+	loadBalancer := describeLoadBalancersOutput.LoadBalancers[0]
+	return aliasTarget{
+		DNSName:      aws.ToString(loadBalancer.DNSName),
+		HostedZoneID: aws.ToString(loadBalancer.CanonicalHostedZoneId),
+		IPv6:         loadBalancer.IpAddressType == elbTypes.IpAddressTypeDualstack,
+	}, nil
+}
+
+func getCloudfrontAliasTarget(cloudfrontClient *cloudfront.Client, cloudfrontDistributionId string) (aliasTarget, error) {
+	getDistributionOutput, err := cloudfrontClient.GetDistribution(context.TODO(), &cloudfront.GetDistributionInput{
+		Id: aws.String(cloudfrontDistributionId),
+	})
+	if err != nil {
+		return aliasTarget{}, err
+	}
+	distribution := getDistributionOutput.Distribution
+	return aliasTarget{
+		DNSName:      aws.ToString(distribution.DomainName),
+		HostedZoneID: cloudfrontHostedZoneID,
+		IPv6:         distribution.DistributionConfig != nil && aws.ToBool(distribution.DistributionConfig.IsIPV6Enabled),
+	}, nil
+}
+
+// getWebServiceDomains are the domains of a web service: its Domains, or on
+// a shared load balancer the host headers routing to it.
+func getWebServiceDomains(parameters map[string]interface{}) ([]string, error) {
+	domainsA, _ := jobs.GetParameterValue[primitive.A](parameters, parameters_enums.Domains)
+	if len(domainsA) > 0 {
+		return commandUtils.ConvertPrimitiveAToStringSlice(domainsA)
+	}
+	return getAlbHostHeaders(parameters), nil
+}
+
+// getAcmValidationRecords are the certificate's validation records, one per
+// name since a domain and its wildcard share it. ready is false until ACM
+// has generated the records of all domains.
+func getAcmValidationRecords(domainValidations []acmTypes.DomainValidation) (records map[string]acmTypes.DomainValidation, ready bool) {
+	records = make(map[string]acmTypes.DomainValidation)
+	for _, domainValidation := range domainValidations {
+		resourceRecord := domainValidation.ResourceRecord
+		if resourceRecord == nil || len(aws.ToString(resourceRecord.Name)) == 0 || len(aws.ToString(resourceRecord.Value)) == 0 {
+			return nil, false
+		}
+		records[normalizeDnsName(aws.ToString(resourceRecord.Name))] = domainValidation
+	}
+	return records, len(records) > 0
+}
+
+// upsertAcmValidationRecords adds the certificate's DNS validation records
+// to the hosted zones of its domains. Route 53 errors don't fail the job, the
+// records to add are logged for the domain's DNS provider instead.
+func upsertAcmValidationRecords(parameters map[string]interface{}, acmClient *acm.Client, certificateArn string,
+	logsWriter io.Writer) error {
+	var records map[string]acmTypes.DomainValidation
+	for retryCount := 0; ; retryCount++ {
+		describeCertificateOutput, err := acmClient.DescribeCertificate(context.TODO(), &acm.DescribeCertificateInput{
+			CertificateArn: aws.String(certificateArn),
+		})
+		if err != nil {
+			return err
+		}
+		var ready bool
+		if describeCertificateOutput.Certificate != nil {
+			records, ready = getAcmValidationRecords(describeCertificateOutput.Certificate.DomainValidationOptions)
+		}
+		if ready {
+			break
+		}
+		if retryCount == acmValidationRecordsRetries {
+			return fmt.Errorf("ACM didn't generate the validation records of certificate %s", certificateArn)
+		}
+		time.Sleep(5 * time.Second)
+	}
+	route53Client, err := getRoute53Client(parameters)
+	if err != nil {
+		io.WriteString(logsWriter, fmt.Sprintf("Couldn't access Route 53: %s\n", err))
+		for _, domainValidation := range records {
+			logManualAcmValidationRecord(domainValidation, logsWriter)
+		}
+		return nil
+	}
+	for _, domainValidation := range records {
+		err = upsertAcmValidationRecord(route53Client, domainValidation, logsWriter)
+		if err != nil {
+			io.WriteString(logsWriter, fmt.Sprintf("Couldn't add validation record for %s in Route 53: %s\n",
+				aws.ToString(domainValidation.DomainName), err))
+			logManualAcmValidationRecord(domainValidation, logsWriter)
+		}
+	}
+	return nil
+}
+
+func upsertAcmValidationRecord(route53Client *route53.Client, domainValidation acmTypes.DomainValidation, logsWriter io.Writer) error {
+	zoneID, found, err := findHostedZone(route53Client, aws.ToString(domainValidation.DomainName))
+	if err != nil {
+		return err
+	}
+	if !found {
+		io.WriteString(logsWriter, fmt.Sprintf("No Route 53 hosted zone found for %s\n", aws.ToString(domainValidation.DomainName)))
+		logManualAcmValidationRecord(domainValidation, logsWriter)
+		return nil
+	}
+	_, err = route53Client.ChangeResourceRecordSets(context.TODO(), &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(zoneID),
+		ChangeBatch: &route53Types.ChangeBatch{
+			Changes: []route53Types.Change{{
+				Action: route53Types.ChangeActionUpsert,
+				ResourceRecordSet: &route53Types.ResourceRecordSet{
+					Name: domainValidation.ResourceRecord.Name,
+					Type: route53Types.RRTypeCname,
+					TTL:  aws.Int64(acmValidationRecordTTL),
+					ResourceRecords: []route53Types.ResourceRecord{{
+						Value: domainValidation.ResourceRecord.Value,
+					}},
+				},
+			}},
+			Comment: aws.String("created by deployment.io"),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Added validation record for %s in hosted zone %s\n",
+		aws.ToString(domainValidation.DomainName), zoneID))
+	return nil
+}
+
+func logManualAcmValidationRecord(domainValidation acmTypes.DomainValidation, logsWriter io.Writer) {
+	io.WriteString(logsWriter, fmt.Sprintf("Add the CNAME record %s with value %s in your DNS provider to validate %s\n",
+		aws.ToString(domainValidation.ResourceRecord.Name), aws.ToString(domainValidation.ResourceRecord.Value),
+		aws.ToString(domainValidation.DomainName)))
+}
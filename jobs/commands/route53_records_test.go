@@ -0,0 +1,91 @@
+package commands
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	acmTypes "github.com/aws/aws-sdk-go-v2/service/acm/types"
+	route53Types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+func TestGetHostedZoneCandidates(t *testing.T) {
+	candidates := getHostedZoneCandidates("*.App.Example.co.uk.")
+	if want := []string{"app.example.co.uk", "example.co.uk", "co.uk"}; !reflect.DeepEqual(candidates, want) {
+		t.Errorf("candidates = %v, want %v", candidates, want)
+	}
+	if candidates = getHostedZoneCandidates("localhost"); len(candidates) != 0 {
+		t.Errorf("candidates = %v, want none", candidates)
+	}
+	if name := normalizeDnsName(`\052.example.com.`); name != "*.example.com" {
+		t.Errorf("name = %s", name)
+	}
+}
+
+func TestGetAliasRecordChanges(t *testing.T) {
+	target := aliasTarget{DNSName: "my-alb-1.us-east-1.elb.amazonaws.com", HostedZoneID: "Z35SXDOTRQ7X7K", IPv6: true}
+	alias := func(recordType route53Types.RRType, dnsName string) route53Types.ResourceRecordSet {
+		return route53Types.ResourceRecordSet{
+			Name:        aws.String("app.example.com."),
+			Type:        recordType,
+			AliasTarget: &route53Types.AliasTarget{DNSName: aws.String(dnsName)},
+		}
+	}
+
+	changes, conflicts := getAliasRecordChanges("app.example.com", nil, target, route53Types.ChangeActionUpsert)
+	if len(changes) != 2 || len(conflicts) != 0 {
+		t.Fatalf("changes = %v, conflicts = %v, want A and AAAA", changes, conflicts)
+	}
+	if record := changes[1].ResourceRecordSet; record.Type != route53Types.RRTypeAaaa ||
+		aws.ToString(record.AliasTarget.HostedZoneId) != target.HostedZoneID {
+		t.Errorf("record = %+v", record)
+	}
+
+	existing := []route53Types.ResourceRecordSet{
+		alias(route53Types.RRTypeA, "dualstack.my-alb-1.us-east-1.elb.amazonaws.com."),
+		alias(route53Types.RRTypeAaaa, "d111111abcdef8.cloudfront.net."),
+	}
+	changes, conflicts = getAliasRecordChanges("app.example.com", existing, target, route53Types.ChangeActionUpsert)
+	if len(changes) != 0 || len(conflicts) != 1 {
+		t.Errorf("changes = %v, conflicts = %v, want the AAAA conflict", changes, conflicts)
+	}
+	changes, _ = getAliasRecordChanges("app.example.com", existing, target, route53Types.ChangeActionDelete)
+	if len(changes) != 1 || changes[0].ResourceRecordSet.Type != route53Types.RRTypeA {
+		t.Errorf("changes = %v, want only the A record deleted", changes)
+	}
+
+	cname := []route53Types.ResourceRecordSet{{Name: aws.String("app.example.com."), Type: route53Types.RRTypeCname}}
+	changes, conflicts = getAliasRecordChanges("app.example.com", cname, target, route53Types.ChangeActionUpsert)
+	if len(changes) != 0 || len(conflicts) != 1 {
+		t.Errorf("changes = %v, conflicts = %v, want the CNAME conflict", changes, conflicts)
+	}
+}
+
+func TestGetAcmValidationRecords(t *testing.T) {
+	validation := func(domain, name string) acmTypes.DomainValidation {
+		return acmTypes.DomainValidation{
+			DomainName:     aws.String(domain),
+			ResourceRecord: &acmTypes.ResourceRecord{Name: aws.String(name), Value: aws.String("_x.acm-validations.aws.")},
+		}
+	}
+	records, ready := getAcmValidationRecords([]acmTypes.DomainValidation{
+		validation("*.example.com", "_abc.example.com."),
+		validation("example.com", "_abc.example.com."),
+	})
+	if !ready || len(records) != 1 {
+		t.Errorf("records = %v, %v, want the shared record once", records, ready)
+	}
+	if _, ready = getAcmValidationRecords([]acmTypes.DomainValidation{
+		validation("example.com", "_abc.example.com."),
+		{DomainName: aws.String("www.example.com")},
+	}); ready {
+		t.Error("expected records to be pending")
+	}
+}
+
+func TestGetRemovedDomains(t *testing.T) {
+	removed := getRemovedDomains([]string{"a.example.com", "B.example.com", "c.example.com"}, []string{"b.example.com", "c.example.com"})
+	if want := []string{"a.example.com"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed = %v, want %v", removed, want)
+	}
+}
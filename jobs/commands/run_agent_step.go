@@ -34,6 +34,7 @@ import (
 	runnerclient "github.com/deployment-io/deployment-runner/client"
 	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
 	"github.com/deployment-io/deployment-runner/utils"
+	"github.com/deployment-io/deployment-runner/utils/dependency_cache"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/mount"
@@ -244,6 +245,16 @@ func (rs *RunAgentStep) Run(parameters map[string]interface{}, logsWriter io.Wri
 		return parameters, fmt.Errorf("error creating cache volume: %s", err)
 	}
 	defer removeCacheVolume(cacheVolume)
+	// The volume is seeded from the runner's shared dependency cache when
+	// another Step vendored the same lockfiles, and saved back on a miss.
+	cacheLease := &dependency_cache.Lease{}
+	if cacheKey, cached := getAgentboxDependencyCacheKey(ctx, imageRef, workDirHost, logsWriter); cached {
+		cacheLease, err = restoreDependencyCache(cacheKey, cacheVolume, logsWriter)
+		if err != nil {
+			return parameters, fmt.Errorf("error creating cache volume: %s", err)
+		}
+		defer cacheLease.Release()
+	}
 	vendorSpec, err := buildVendorSpec(imageRef, workDirHost, cacheVolume, ctx)
 	if err != nil {
 		return parameters, err
@@ -251,6 +262,7 @@ func (rs *RunAgentStep) Run(parameters map[string]interface{}, logsWriter io.Wri
 	if err := rs.spawnVendorAndWait(vendorSpec, logsWriter); err != nil {
 		return parameters, fmt.Errorf("error vendoring dependencies: %s", err)
 	}
+	saveDependencyCache(cacheLease, cacheVolume, logsWriter)
 	envVars, err := buildAgentSpawnEnvVars(parameters, logsWriter)
 	if err != nil {
 		return parameters, err
@@ -1137,6 +1149,7 @@ func (s taskPreviewStore) SavePreview(previewID string, r agenttools.PreviewStat
 		CloudfrontDistributionID:         r.CloudFrontDistributionID,
 		CloudfrontDistributionArn:        r.CloudFrontDistributionArn,
 		CloudfrontDistributionDomainName: r.CloudFrontDomainName,
+		PreviewAccessPassword:            r.AccessPassword,
 		Status:                           build_enums.Success,
 	})
 }
@@ -1148,6 +1161,7 @@ func buildStaticSitePreviewDeps(ctx commandUtils.TaskJobContext, parameters map[
 	}
 	orgID := ctx.OrganizationID
 	taskID := ctx.TaskID
+	previewProtection, _ := jobs.GetParameterValue[string](parameters, parameters_enums.PreviewProtection)
 	return &agenttools.DeployStaticSitePreviewDeps{
 		OrgID:       orgID,
 		Region:      runnerRegion,
@@ -1172,6 +1186,9 @@ func buildStaticSitePreviewDeps(ctx commandUtils.TaskJobContext, parameters map[
 			taskID:      taskID,
 			serviceType: task_previews.ServiceTypeStaticSite,
 		},
+		// A static preview can only be protected with a password, so it's used for any
+		// protection the project asks for rather than leaving the preview public.
+		Protected: len(previewProtection) > 0,
 	}
 }
 
@@ -1259,6 +1276,29 @@ func cacheVolumeName(ctx commandUtils.TaskJobContext) string {
 	return fmt.Sprintf("agentbox-cache-%s-%d", ctx.TaskID, ctx.StepIndex)
 }
 
+// agentboxLockfiles are the lockfiles of the languages agentbox vendors.
+var agentboxLockfiles = []string{"go.sum", "package-lock.json", "yarn.lock", "pnpm-lock.yaml", "bun.lockb", "bun.lock",
+	"requirements.txt", "poetry.lock", "uv.lock", "Gemfile.lock", "Cargo.lock"}
+
+// getAgentboxDependencyCacheKey keys the vendor cache on every lockfile in
+// the Step's repositories. The organization is part of the key since the
+// vendor phase fetches private dependencies with the Step's git token.
+// Errors only log: the Step vendors without the shared cache.
+func getAgentboxDependencyCacheKey(ctx commandUtils.TaskJobContext, imageRef, workDirHost string,
+	logsWriter io.Writer) (dependency_cache.Key, bool) {
+	lockfiles, err := dependency_cache.FindLockfiles(workDirHost, agentboxLockfiles)
+	if err == nil {
+		var key dependency_cache.Key
+		var ok bool
+		key, ok, err = dependency_cache.GetKey("agentbox", ctx.OrganizationID+"/"+imageRef, workDirHost, lockfiles)
+		if err == nil {
+			return key, ok
+		}
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Not using the dependency cache: %s\n", err))
+	return dependency_cache.Key{}, false
+}
+
 func createCacheVolume(name string) error {
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
@@ -0,0 +1,332 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/enums/iam_policy_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/iam_policies"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	"github.com/deployment-io/deployment-runner-kit/types"
+	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
+	"github.com/deployment-io/deployment-runner/utils"
+	"github.com/deployment-io/deployment-runner/utils/aws_utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultRunTaskTimeout = 30 * time.Minute
+	runTaskPollInterval   = 5 * time.Second
+)
+
+// RunAwsEcsTask runs a one-off Fargate task (migrations, seeds, maintenance
+// scripts) from the service's task definition with the command overridden.
+// It registers the task definition itself and leaves TaskDefinitionArn in
+// the parameters, so when it's placed before DeployAwsWebService or
+// DeployAwsPrivateService in a job the service is rolled out with the exact
+// revision the task ran with. The task's CloudWatch logs are streamed into
+// the job log and a non-zero exit code fails the job.
+type RunAwsEcsTask struct {
+	// stopSignal is set by the runner outer loop via SetStopSignal. When it
+	// closes the running task is stopped with StopTask.
+	stopSignal <-chan struct{}
+}
+
+// SetStopSignal satisfies jobs.StoppableCommand.
+func (r *RunAwsEcsTask) SetStopSignal(stop <-chan struct{}) {
+	r.stopSignal = stop
+}
+
+// getRunTaskNetworkConfiguration reuses the network configuration of the
+// deployment's ECS service when it exists so the task sees the same subnets
+// and security groups. Before the first deploy it falls back to the private
+// subnets and the VPC's default security group, which is what the service
+// will be created with.
+func getRunTaskNetworkConfiguration(parameters map[string]interface{}, ecsClient *ecs.Client, ecsClusterArn string) (*ecsTypes.NetworkConfiguration, error) {
+	ecsServiceName, err := aws_utils.GetEcsServiceName(parameters)
+	if err != nil {
+		return nil, err
+	}
+	describeServicesOutput, err := ecsClient.DescribeServices(context.TODO(), &ecs.DescribeServicesInput{
+		Services: []string{ecsServiceName},
+		Cluster:  aws.String(ecsClusterArn),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range describeServicesOutput.Services {
+		if aws.ToString(service.Status) == "ACTIVE" && service.NetworkConfiguration != nil {
+			return service.NetworkConfiguration, nil
+		}
+	}
+	privateSubnets, err := jobs.GetParameterValue[primitive.A](parameters, parameters_enums.PrivateSubnets)
+	if err != nil {
+		return nil, err
+	}
+	privateSubnetsSlice, err := commandUtils.ConvertPrimitiveAToStringSlice(privateSubnets)
+	if err != nil {
+		return nil, err
+	}
+	return &ecsTypes.NetworkConfiguration{
+		AwsvpcConfiguration: &ecsTypes.AwsVpcConfiguration{
+			Subnets: privateSubnetsSlice,
+		},
+	}, nil
+}
+
+// getRunTaskSettings reads the command to run and how long it may take,
+// RunTaskTimeoutMinutes or the default when it isn't set.
+func getRunTaskSettings(parameters map[string]interface{}) (string, time.Duration, error) {
+	command, err := jobs.GetParameterValue[string](parameters, parameters_enums.RunTaskCommand)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(strings.TrimSpace(command)) == 0 {
+		return "", 0, fmt.Errorf("command to run is empty")
+	}
+	timeout := defaultRunTaskTimeout
+	timeoutMinutes, err := jobs.GetParameterValue[int64](parameters, parameters_enums.RunTaskTimeoutMinutes)
+	if err == nil && timeoutMinutes > 0 {
+		timeout = time.Duration(timeoutMinutes) * time.Minute
+	}
+	return command, timeout, nil
+}
+
+// getRunTaskOverrides runs the command through the shell in the service's
+// container, so it can use pipes and the container's environment.
+func getRunTaskOverrides(containerName, command string) *ecsTypes.TaskOverride {
+	return &ecsTypes.TaskOverride{
+		ContainerOverrides: []ecsTypes.ContainerOverride{
+			{
+				Name:    aws.String(containerName),
+				Command: []string{"sh", "-c", command},
+			},
+		},
+	}
+}
+
+// getRunTaskLogStreamName is the awslogs stream of the task's container,
+// <prefix>/<container name>/<task id>.
+func getRunTaskLogStreamName(logsStreamPrefix, containerName, taskArn string) string {
+	taskID := taskArn[strings.LastIndex(taskArn, "/")+1:]
+	return fmt.Sprintf("%s/%s/%s", logsStreamPrefix, containerName, taskID)
+}
+
+// getStoppedRunTaskError is nil when the command in the task's container
+// exited with code 0.
+func getStoppedRunTaskError(task ecsTypes.Task, containerName string) error {
+	for _, taskContainer := range task.Containers {
+		if aws.ToString(taskContainer.Name) != containerName {
+			continue
+		}
+		if taskContainer.ExitCode == nil {
+			return fmt.Errorf("task stopped before the command finished: %s %s",
+				aws.ToString(task.StoppedReason), aws.ToString(taskContainer.Reason))
+		}
+		exitCode := aws.ToInt32(taskContainer.ExitCode)
+		if exitCode != 0 {
+			return fmt.Errorf("command exited with code %d", exitCode)
+		}
+		return nil
+	}
+	return fmt.Errorf("container %s not found in stopped task: %s", containerName, aws.ToString(task.StoppedReason))
+}
+
+// taskLogStreamer tails the awslogs stream of a one-off task's container into
+// the job log. The stream only shows up once the container has started, so
+// missing streams are not an error.
+type taskLogStreamer struct {
+	client        *cloudwatchlogs.Client
+	logGroupName  string
+	logStreamName string
+	nextToken     *string
+}
+
+func (t *taskLogStreamer) flush(logsWriter io.Writer) {
+	for {
+		getLogEventsOutput, err := t.client.GetLogEvents(context.TODO(), &cloudwatchlogs.GetLogEventsInput{
+			LogGroupName:  aws.String(t.logGroupName),
+			LogStreamName: aws.String(t.logStreamName),
+			NextToken:     t.nextToken,
+			StartFromHead: aws.Bool(true),
+		})
+		if err != nil {
+			return
+		}
+		for _, event := range getLogEventsOutput.Events {
+			io.WriteString(logsWriter, strings.TrimRight(aws.ToString(event.Message), "\n")+"\n")
+		}
+		if getLogEventsOutput.NextForwardToken == nil || aws.ToString(getLogEventsOutput.NextForwardToken) == aws.ToString(t.nextToken) {
+			return
+		}
+		t.nextToken = getLogEventsOutput.NextForwardToken
+		if len(getLogEventsOutput.Events) == 0 {
+			return
+		}
+	}
+}
+
+func (r *RunAwsEcsTask) Run(parameters map[string]interface{}, logsWriter io.Writer) (newParameters map[string]interface{}, err error) {
+	defer func() {
+		if err != nil {
+			<-MarkDeploymentDone(parameters, err)
+		}
+	}()
+
+	runnerData := utils.RunnerData.Get()
+	organizationID, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIDNamespace)
+	if err != nil {
+		return parameters, err
+	}
+	err = iam_policies.AddAwsPolicyForDeploymentRunner(iam_policy_enums.AwsWebServiceDeployment,
+		runnerData.OsType.String(), runnerData.CpuArchEnum.String(), organizationID, runnerData.RunnerRegion, runnerData.Mode, runnerData.TargetCloud)
+	if err != nil {
+		return parameters, err
+	}
+	err = iam_policies.AddAwsPolicyForDeploymentRunner(iam_policy_enums.AwsLogs,
+		runnerData.OsType.String(), runnerData.CpuArchEnum.String(), organizationID, runnerData.RunnerRegion, runnerData.Mode, runnerData.TargetCloud)
+	if err != nil {
+		return parameters, err
+	}
+
+	command, timeout, err := getRunTaskSettings(parameters)
+	if err != nil {
+		return parameters, err
+	}
+
+	ecsClient, err := cloud_api_clients.GetEcsClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	taskDefinitionArn, err := registerTaskDefinition(parameters, ecsClient, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	//the service deploy that follows picks up the same revision
+	jobs.SetParameterValue(parameters, parameters_enums.TaskDefinitionArn, taskDefinitionArn)
+
+	ecsClusterArn, err := jobs.GetParameterValue[string](parameters, parameters_enums.EcsClusterArn)
+	if err != nil {
+		return parameters, err
+	}
+	networkConfiguration, err := getRunTaskNetworkConfiguration(parameters, ecsClient, ecsClusterArn)
+	if err != nil {
+		return parameters, err
+	}
+	containerName, err := getContainerName(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return parameters, err
+	}
+
+	io.WriteString(logsWriter, fmt.Sprintf("Running one-off task from %s: %s\n", taskDefinitionArn, command))
+	runTaskOutput, err := ecsClient.RunTask(context.TODO(), &ecs.RunTaskInput{
+		TaskDefinition:       aws.String(taskDefinitionArn),
+		Cluster:              aws.String(ecsClusterArn),
+		Count:                aws.Int32(1),
+		LaunchType:           ecsTypes.LaunchTypeFargate,
+		NetworkConfiguration: networkConfiguration,
+		Overrides:            getRunTaskOverrides(containerName, command),
+		StartedBy:            aws.String(fmt.Sprintf("run-%s", deploymentID)),
+		Tags: []ecsTypes.Tag{
+			{
+				Key:   aws.String("Name"),
+				Value: aws.String(fmt.Sprintf("run-%s", deploymentID)),
+			},
+			{
+				Key:   aws.String("created by"),
+				Value: aws.String("deployment.io"),
+			},
+		},
+	})
+	if err != nil {
+		return parameters, err
+	}
+	if len(runTaskOutput.Failures) > 0 {
+		return parameters, fmt.Errorf("error running task: %s", aws.ToString(runTaskOutput.Failures[0].Reason))
+	}
+	if len(runTaskOutput.Tasks) == 0 {
+		return parameters, fmt.Errorf("error running task: no task was started")
+	}
+	taskArn := aws.ToString(runTaskOutput.Tasks[0].TaskArn)
+	io.WriteString(logsWriter, fmt.Sprintf("Started task: %s\n", taskArn))
+
+	cloudwatchLogsClient, err := cloud_api_clients.GetCloudwatchLogsClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	logGroupName, err := commandUtils.GetLogGroupName(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	logsStreamPrefix, err := commandUtils.GetApplicationLogStreamPrefix(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	logStreamer := &taskLogStreamer{
+		client:        cloudwatchLogsClient,
+		logGroupName:  logGroupName,
+		logStreamName: getRunTaskLogStreamName(logsStreamPrefix, containerName, taskArn),
+	}
+
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(runTaskPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stopSignal:
+			io.WriteString(logsWriter, fmt.Sprintf("Stopping task: %s\n", taskArn))
+			_, _ = ecsClient.StopTask(context.TODO(), &ecs.StopTaskInput{
+				Task:    aws.String(taskArn),
+				Cluster: aws.String(ecsClusterArn),
+				Reason:  aws.String("Stopped by user"),
+			})
+			logStreamer.flush(logsWriter)
+			return parameters, types.ErrJobStoppedByUser
+		case <-deadline:
+			_, _ = ecsClient.StopTask(context.TODO(), &ecs.StopTaskInput{
+				Task:    aws.String(taskArn),
+				Cluster: aws.String(ecsClusterArn),
+				Reason:  aws.String("Timed out"),
+			})
+			logStreamer.flush(logsWriter)
+			return parameters, fmt.Errorf("task %s did not finish in %s and was stopped", taskArn, timeout)
+		case <-ticker.C:
+		}
+
+		logStreamer.flush(logsWriter)
+		describeTasksOutput, err := ecsClient.DescribeTasks(context.TODO(), &ecs.DescribeTasksInput{
+			Tasks:   []string{taskArn},
+			Cluster: aws.String(ecsClusterArn),
+		})
+		if err != nil {
+			return parameters, err
+		}
+		if len(describeTasksOutput.Tasks) == 0 || aws.ToString(describeTasksOutput.Tasks[0].LastStatus) != "STOPPED" {
+			continue
+		}
+
+		//awslogs delivers the last lines shortly after the task stops
+		time.Sleep(runTaskPollInterval)
+		logStreamer.flush(logsWriter)
+
+		err = getStoppedRunTaskError(describeTasksOutput.Tasks[0], containerName)
+		if err != nil {
+			return parameters, err
+		}
+		io.WriteString(logsWriter, fmt.Sprintf("Task finished successfully: %s\n", taskArn))
+		return parameters, nil
+	}
+}
@@ -0,0 +1,96 @@
+package commands
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+)
+
+func TestGetRunTaskSettings(t *testing.T) {
+	cases := []struct {
+		name           string
+		command        string
+		timeoutMinutes int64
+		wantTimeout    time.Duration
+		wantErr        bool
+	}{
+		{name: "default timeout", command: "./manage.py migrate", wantTimeout: defaultRunTaskTimeout},
+		{name: "timeout", command: "npm run seed", timeoutMinutes: 90, wantTimeout: 90 * time.Minute},
+		{name: "negative timeout", command: "npm run seed", timeoutMinutes: -5, wantTimeout: defaultRunTaskTimeout},
+		{name: "blank command", command: " \n\t", wantErr: true},
+	}
+	for _, c := range cases {
+		parameters := map[string]interface{}{}
+		jobs.SetParameterValue[string](parameters, parameters_enums.RunTaskCommand, c.command)
+		if c.timeoutMinutes != 0 {
+			jobs.SetParameterValue[int64](parameters, parameters_enums.RunTaskTimeoutMinutes, c.timeoutMinutes)
+		}
+		command, timeout, err := getRunTaskSettings(parameters)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", c.name)
+			}
+			continue
+		}
+		if err != nil || command != c.command || timeout != c.wantTimeout {
+			t.Errorf("%s: settings = %q, %s, %v, want %q, %s", c.name, command, timeout, err, c.command, c.wantTimeout)
+		}
+	}
+	if _, _, err := getRunTaskSettings(map[string]interface{}{}); err == nil {
+		t.Error("expected an error without a command")
+	}
+}
+
+func TestGetRunTaskOverrides(t *testing.T) {
+	cases := map[string][]string{
+		"./manage.py migrate":              {"sh", "-c", "./manage.py migrate"},
+		"npm run seed && npm run reindex":  {"sh", "-c", "npm run seed && npm run reindex"},
+		"echo 'quoted; not split' | wc -c": {"sh", "-c", "echo 'quoted; not split' | wc -c"},
+	}
+	for command, want := range cases {
+		overrides := getRunTaskOverrides("c-dep1", command)
+		if len(overrides.ContainerOverrides) != 1 {
+			t.Fatalf("%s: overrides = %+v", command, overrides)
+		}
+		containerOverride := overrides.ContainerOverrides[0]
+		if aws.ToString(containerOverride.Name) != "c-dep1" || !reflect.DeepEqual(containerOverride.Command, want) {
+			t.Errorf("%s: override = %s %v, want c-dep1 %v", command, aws.ToString(containerOverride.Name),
+				containerOverride.Command, want)
+		}
+	}
+}
+
+func TestGetRunTaskLogStreamName(t *testing.T) {
+	got := getRunTaskLogStreamName("application/build-1", "c-dep1",
+		"arn:aws:ecs:us-east-1:123456789012:task/ecs-org1/0123456789abcdef")
+	if want := "application/build-1/c-dep1/0123456789abcdef"; got != want {
+		t.Errorf("log stream = %s, want %s", got, want)
+	}
+}
+
+func TestGetStoppedRunTaskError(t *testing.T) {
+	cases := []struct {
+		name       string
+		containers []ecsTypes.Container
+		wantErr    bool
+	}{
+		{name: "success", containers: []ecsTypes.Container{
+			{Name: aws.String("log-router"), ExitCode: aws.Int32(1)},
+			{Name: aws.String("c-dep1"), ExitCode: aws.Int32(0)},
+		}},
+		{name: "non-zero exit", containers: []ecsTypes.Container{{Name: aws.String("c-dep1"), ExitCode: aws.Int32(2)}}, wantErr: true},
+		{name: "no exit code", containers: []ecsTypes.Container{{Name: aws.String("c-dep1"), Reason: aws.String("OOM")}}, wantErr: true},
+		{name: "missing container", containers: []ecsTypes.Container{{Name: aws.String("nginx"), ExitCode: aws.Int32(0)}}, wantErr: true},
+	}
+	for _, c := range cases {
+		task := ecsTypes.Task{Containers: c.containers, StoppedReason: aws.String("Essential container in task exited")}
+		if err := getStoppedRunTaskError(task, "c-dep1"); (err != nil) != c.wantErr {
+			t.Errorf("%s: err = %v, want error %v", c.name, err, c.wantErr)
+		}
+	}
+}
@@ -0,0 +1,532 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamTypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	secretsmanager_types "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmTypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/enums/iam_policy_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/region_enums"
+	"github.com/deployment-io/deployment-runner-kit/iam_policies"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	"github.com/deployment-io/deployment-runner/utils"
+)
+
+// secretsStoreSsm selects SSM Parameter Store SecureString parameters for
+// secret environment variables. Anything else uses Secrets Manager.
+const secretsStoreSsm = "ssm"
+
+// executionRoleSecretsPolicyPrefix names the inline policy on the task
+// execution role that lets ECS read a deployment's secrets when it starts a
+// task. Each deployment has its own, as the role is shared.
+const executionRoleSecretsPolicyPrefix = "deployment-io-secrets-"
+
+func getSecretEnvironmentVariablesPrefix(parameters map[string]interface{}) (string, error) {
+	//env-<deploymentID>
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("env-%s", deploymentID), nil
+}
+
+func getSecretEnvironmentVariableName(prefix, key string, store string) string {
+	if store == secretsStoreSsm {
+		///env-<deploymentID>/<key>
+		return fmt.Sprintf("/%s/%s", prefix, key)
+	}
+	//env-<deploymentID>/<key>
+	return fmt.Sprintf("%s/%s", prefix, key)
+}
+
+func getExecutionRoleSecretsPolicyName(prefix string) string {
+	//deployment-io-secrets-env-<deploymentID>
+	return executionRoleSecretsPolicyPrefix + prefix
+}
+
+// getExecutionRoleSecretsPolicy allows reading only the deployment's own
+// secrets in its account and region.
+func getExecutionRoleSecretsPolicy(region, accountID, prefix string) string {
+	return fmt.Sprintf(`{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Effect": "Allow",
+      "Action": "secretsmanager:GetSecretValue",
+      "Resource": "arn:aws:secretsmanager:%[1]s:%[2]s:secret:%[3]s/*"
+    },
+    {
+      "Effect": "Allow",
+      "Action": "ssm:GetParameters",
+      "Resource": "arn:aws:ssm:%[1]s:%[2]s:parameter/%[3]s/*"
+    }
+  ]
+}`, region, accountID, prefix)
+}
+
+// isSamePolicyDocument compares a policy document returned by IAM, which is
+// URL encoded, with document, ignoring formatting.
+func isSamePolicyDocument(encodedDocument, document string) bool {
+	decodedDocument, err := url.QueryUnescape(encodedDocument)
+	if err != nil {
+		return false
+	}
+	var current, wanted interface{}
+	if json.Unmarshal([]byte(decodedDocument), &current) != nil || json.Unmarshal([]byte(document), &wanted) != nil {
+		return false
+	}
+	return reflect.DeepEqual(current, wanted)
+}
+
+// getRoleNameAndAccountID splits arn:aws:iam::<account>:role/<path/><role name>.
+func getRoleNameAndAccountID(roleArn string) (roleName, accountID string, err error) {
+	parts := strings.SplitN(roleArn, ":", 6)
+	if len(parts) != 6 || !strings.HasPrefix(parts[5], "role/") {
+		return "", "", fmt.Errorf("invalid role arn: %s", roleArn)
+	}
+	return roleArn[strings.LastIndex(roleArn, "/")+1:], parts[4], nil
+}
+
+func addSecretsStorePolicyForDeploymentRunner(parameters map[string]interface{}, store string) error {
+	runnerData := utils.RunnerData.Get()
+	organizationID, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIDNamespace)
+	if err != nil {
+		return err
+	}
+	policyType := iam_policy_enums.AwsSecretsManager
+	if store == secretsStoreSsm {
+		policyType = iam_policy_enums.AwsSsmParameterStore
+	}
+	return iam_policies.AddAwsPolicyForDeploymentRunner(policyType,
+		runnerData.OsType.String(), runnerData.CpuArchEnum.String(), organizationID, runnerData.RunnerRegion, runnerData.Mode, runnerData.TargetCloud)
+}
+
+// getRolePolicy returns a nil output when the role has no such inline policy.
+func getRolePolicy(iamClient *iam.Client, roleName, policyName string) (*iam.GetRolePolicyOutput, error) {
+	getRolePolicyOutput, err := iamClient.GetRolePolicy(context.TODO(), &iam.GetRolePolicyInput{
+		PolicyName: aws.String(policyName),
+		RoleName:   aws.String(roleName),
+	})
+	var noSuchEntityException *iamTypes.NoSuchEntityException
+	if errors.As(err, &noSuchEntityException) {
+		return nil, nil
+	}
+	return getRolePolicyOutput, err
+}
+
+// syncSecretEnvironmentVariables stores the deployment's secret env vars in
+// Secrets Manager or SSM Parameter Store and returns the container Secrets
+// that reference them, so the values never show up in the task definition.
+// Secrets for keys that were removed since the last deploy are deleted. The
+// execution role's policy for them is there exactly when secrets are stored,
+// so a service that never had any doesn't touch the stores.
+func syncSecretEnvironmentVariables(parameters map[string]interface{}, logsWriter io.Writer) ([]ecsTypes.Secret, error) {
+	var iamClient *iam.Client
+	var getRolePolicyOutput *iam.GetRolePolicyOutput
+	var roleName, accountID, policyName string
+	ecsTaskExecutionRoleArn, _ := jobs.GetParameterValue[string](parameters, parameters_enums.EcsTaskExecutionRoleArn)
+	prefix, err := getSecretEnvironmentVariablesPrefix(parameters)
+	if err != nil {
+		return nil, err
+	}
+	if len(ecsTaskExecutionRoleArn) > 0 {
+		iamClient, err = cloud_api_clients.GetIamClient(parameters)
+		if err != nil {
+			return nil, err
+		}
+		roleName, accountID, err = getRoleNameAndAccountID(ecsTaskExecutionRoleArn)
+		if err != nil {
+			return nil, err
+		}
+		policyName = getExecutionRoleSecretsPolicyName(prefix)
+		getRolePolicyOutput, err = getRolePolicy(iamClient, roleName, policyName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	secrets, err := storeSecretEnvironmentVariables(parameters, getRolePolicyOutput != nil, logsWriter)
+	if err != nil {
+		return nil, err
+	}
+	if len(secrets) == 0 {
+		if getRolePolicyOutput == nil {
+			return nil, nil
+		}
+		io.WriteString(logsWriter, fmt.Sprintf("Removing the secret environment variables policy from role %s\n", roleName))
+		_, err = iamClient.DeleteRolePolicy(context.TODO(), &iam.DeleteRolePolicyInput{
+			PolicyName: aws.String(policyName),
+			RoleName:   aws.String(roleName),
+		})
+		return nil, err
+	}
+	if len(ecsTaskExecutionRoleArn) == 0 {
+		return nil, fmt.Errorf("secret environment variables need the ecs task execution role")
+	}
+
+	region, err := jobs.GetParameterValue[int64](parameters, parameters_enums.Region)
+	if err != nil {
+		return nil, err
+	}
+	policy := getExecutionRoleSecretsPolicy(region_enums.Type(region).String(), accountID, prefix)
+	if getRolePolicyOutput != nil && isSamePolicyDocument(aws.ToString(getRolePolicyOutput.PolicyDocument), policy) {
+		return secrets, nil
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Allowing role %s to read the secret environment variables\n", roleName))
+	_, err = iamClient.PutRolePolicy(context.TODO(), &iam.PutRolePolicyInput{
+		PolicyDocument: aws.String(policy),
+		PolicyName:     aws.String(policyName),
+		RoleName:       aws.String(roleName),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return secrets, nil
+}
+
+// storeSecretEnvironmentVariables upserts the secret env vars in the
+// configured store and deletes the ones that were removed. The returned
+// Secrets map each key to the ARN of its secret or parameter. Without secrets
+// it only cleans up when hadSecrets tells the last deploy stored some.
+func storeSecretEnvironmentVariables(parameters map[string]interface{}, hadSecrets bool, logsWriter io.Writer) ([]ecsTypes.Secret, error) {
+	secretEnvVariables, err := jobs.GetParameterValue[string](parameters, parameters_enums.SecretEnvironmentVariables)
+	var secretKeyValuePairs []ecsTypes.KeyValuePair
+	if err == nil && len(secretEnvVariables) > 0 {
+		secretKeyValuePairs, err = decodeEnvironmentVariablesToKeyValueSlice(secretEnvVariables)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(secretKeyValuePairs) == 0 && !hadSecrets {
+		//nothing to store or clean up, so the runner needs no access to the stores
+		return nil, nil
+	}
+
+	store, _ := jobs.GetParameterValue[string](parameters, parameters_enums.SecretEnvironmentVariablesStore)
+	prefix, err := getSecretEnvironmentVariablesPrefix(parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	err = addSecretsStorePolicyForDeploymentRunner(parameters, store)
+	if err != nil {
+		return nil, err
+	}
+
+	var secrets []ecsTypes.Secret
+	currentNames := map[string]bool{}
+	if store == secretsStoreSsm {
+		ssmClient, err := cloud_api_clients.GetSsmClient(parameters)
+		if err != nil {
+			return nil, err
+		}
+		for _, keyValuePair := range secretKeyValuePairs {
+			key := aws.ToString(keyValuePair.Name)
+			name := getSecretEnvironmentVariableName(prefix, key, store)
+			arn, err := upsertSsmSecureStringParameter(ssmClient, name, aws.ToString(keyValuePair.Value))
+			if err != nil {
+				return nil, err
+			}
+			currentNames[name] = true
+			secrets = append(secrets, ecsTypes.Secret{Name: aws.String(key), ValueFrom: aws.String(arn)})
+		}
+		err = deleteSsmSecretEnvironmentVariables(ssmClient, prefix, currentNames, logsWriter)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		secretsManagerClient, err := cloud_api_clients.GetSecretsManagerClient(parameters)
+		if err != nil {
+			return nil, err
+		}
+		for _, keyValuePair := range secretKeyValuePairs {
+			key := aws.ToString(keyValuePair.Name)
+			name := getSecretEnvironmentVariableName(prefix, key, store)
+			arn, err := upsertSecretsManagerSecret(secretsManagerClient, name, aws.ToString(keyValuePair.Value))
+			if err != nil {
+				return nil, err
+			}
+			currentNames[name] = true
+			secrets = append(secrets, ecsTypes.Secret{Name: aws.String(key), ValueFrom: aws.String(arn)})
+		}
+		//keep a recovery window so that a rollback to an older task definition can restore them
+		err = deleteSecretsManagerSecretEnvironmentVariables(secretsManagerClient, prefix, currentNames, false, logsWriter)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//secrets left behind in the other store when the store was switched
+	err = deleteStoredSecretEnvironmentVariables(parameters, getOtherSecretsStore(store), false, logsWriter)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(secrets) == 0 {
+		return nil, nil
+	}
+
+	io.WriteString(logsWriter, fmt.Sprintf("Stored %d secret environment variables\n", len(secrets)))
+
+	return secrets, nil
+}
+
+func upsertSecretsManagerSecret(secretsManagerClient *secretsmanager.Client, name, value string) (string, error) {
+	describeSecretOutput, err := secretsManagerClient.DescribeSecret(context.TODO(), &secretsmanager.DescribeSecretInput{
+		SecretId: aws.String(name),
+	})
+	var resourceNotFoundException *secretsmanager_types.ResourceNotFoundException
+	if err != nil && !errors.As(err, &resourceNotFoundException) {
+		return "", err
+	}
+	if err != nil {
+		createSecretOutput, err := secretsManagerClient.CreateSecret(context.TODO(), &secretsmanager.CreateSecretInput{
+			Name:         aws.String(name),
+			SecretString: aws.String(value),
+			Tags: []secretsmanager_types.Tag{
+				{
+					Key:   aws.String("Name"),
+					Value: aws.String(name),
+				},
+				{
+					Key:   aws.String("created by"),
+					Value: aws.String("deployment.io"),
+				},
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+		return aws.ToString(createSecretOutput.ARN), nil
+	}
+
+	if describeSecretOutput.DeletedDate != nil {
+		//the key was removed and added back within the recovery window
+		_, err = secretsManagerClient.RestoreSecret(context.TODO(), &secretsmanager.RestoreSecretInput{
+			SecretId: aws.String(name),
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	getSecretValueOutput, err := secretsManagerClient.GetSecretValue(context.TODO(), &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(name),
+	})
+	if err != nil || aws.ToString(getSecretValueOutput.SecretString) != value {
+		_, err = secretsManagerClient.PutSecretValue(context.TODO(), &secretsmanager.PutSecretValueInput{
+			SecretId:     aws.String(name),
+			SecretString: aws.String(value),
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return aws.ToString(describeSecretOutput.ARN), nil
+}
+
+func upsertSsmSecureStringParameter(ssmClient *ssm.Client, name, value string) (string, error) {
+	getParameterOutput, err := ssmClient.GetParameter(context.TODO(), &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	var parameterNotFound *ssmTypes.ParameterNotFound
+	if err != nil && !errors.As(err, &parameterNotFound) {
+		return "", err
+	}
+	if err == nil && aws.ToString(getParameterOutput.Parameter.Value) == value {
+		return aws.ToString(getParameterOutput.Parameter.ARN), nil
+	}
+	putParameterInput := &ssm.PutParameterInput{
+		Name:  aws.String(name),
+		Value: aws.String(value),
+		Type:  ssmTypes.ParameterTypeSecureString,
+	}
+	if err == nil {
+		//tags can't be passed together with overwrite
+		putParameterInput.Overwrite = aws.Bool(true)
+	} else {
+		putParameterInput.Tags = []ssmTypes.Tag{
+			{
+				Key:   aws.String("Name"),
+				Value: aws.String(name),
+			},
+			{
+				Key:   aws.String("created by"),
+				Value: aws.String("deployment.io"),
+			},
+		}
+	}
+	_, err = ssmClient.PutParameter(context.TODO(), putParameterInput)
+	if err != nil {
+		return "", err
+	}
+	getParameterOutput, err = ssmClient.GetParameter(context.TODO(), &ssm.GetParameterInput{
+		Name: aws.String(name),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(getParameterOutput.Parameter.ARN), nil
+}
+
+// deleteSecretsManagerSecretEnvironmentVariables deletes every env-<deploymentID>/
+// secret that isn't in keep. forceDelete skips the recovery window, which is
+// what deleting the service wants so the names can be reused right away.
+func deleteSecretsManagerSecretEnvironmentVariables(secretsManagerClient *secretsmanager.Client, prefix string,
+	keep map[string]bool, forceDelete bool, logsWriter io.Writer) error {
+	listSecretsPaginator := secretsmanager.NewListSecretsPaginator(secretsManagerClient, &secretsmanager.ListSecretsInput{
+		Filters: []secretsmanager_types.Filter{
+			{
+				Key:    secretsmanager_types.FilterNameStringTypeName,
+				Values: []string{prefix + "/"},
+			},
+		},
+	})
+	for listSecretsPaginator.HasMorePages() {
+		listSecretsOutput, err := listSecretsPaginator.NextPage(context.TODO())
+		if err != nil {
+			return err
+		}
+		for _, secret := range listSecretsOutput.SecretList {
+			name := aws.ToString(secret.Name)
+			//the name filter matches prefixes of words, so check the exact prefix
+			if !strings.HasPrefix(name, prefix+"/") || keep[name] {
+				continue
+			}
+			io.WriteString(logsWriter, fmt.Sprintf("Deleting secret environment variable: %s\n", name))
+			deleteSecretInput := &secretsmanager.DeleteSecretInput{
+				SecretId: secret.ARN,
+			}
+			if forceDelete {
+				deleteSecretInput.ForceDeleteWithoutRecovery = aws.Bool(true)
+			} else {
+				deleteSecretInput.RecoveryWindowInDays = aws.Int64(7)
+			}
+			_, err = secretsManagerClient.DeleteSecret(context.TODO(), deleteSecretInput)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func deleteSsmSecretEnvironmentVariables(ssmClient *ssm.Client, prefix string, keep map[string]bool, logsWriter io.Writer) error {
+	getParametersByPathPaginator := ssm.NewGetParametersByPathPaginator(ssmClient, &ssm.GetParametersByPathInput{
+		Path:      aws.String("/" + prefix),
+		Recursive: aws.Bool(true),
+	})
+	var staleNames []string
+	for getParametersByPathPaginator.HasMorePages() {
+		getParametersByPathOutput, err := getParametersByPathPaginator.NextPage(context.TODO())
+		if err != nil {
+			return err
+		}
+		for _, parameter := range getParametersByPathOutput.Parameters {
+			if !keep[aws.ToString(parameter.Name)] {
+				staleNames = append(staleNames, aws.ToString(parameter.Name))
+			}
+		}
+	}
+	//DeleteParameters takes at most 10 names
+	for start := 0; start < len(staleNames); start += 10 {
+		end := min(start+10, len(staleNames))
+		io.WriteString(logsWriter, fmt.Sprintf("Deleting secret environment variables: %s\n", strings.Join(staleNames[start:end], ", ")))
+		_, err := ssmClient.DeleteParameters(context.TODO(), &ssm.DeleteParametersInput{
+			Names: staleNames[start:end],
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getOtherSecretsStore is the store that isn't used for the secrets.
+func getOtherSecretsStore(store string) string {
+	if store == secretsStoreSsm {
+		return ""
+	}
+	return secretsStoreSsm
+}
+
+// deleteStoredSecretEnvironmentVariables removes all secret env vars of a
+// deployment from store. forceDelete skips the Secrets Manager recovery
+// window.
+func deleteStoredSecretEnvironmentVariables(parameters map[string]interface{}, store string, forceDelete bool,
+	logsWriter io.Writer) error {
+	prefix, err := getSecretEnvironmentVariablesPrefix(parameters)
+	if err != nil {
+		return err
+	}
+	err = addSecretsStorePolicyForDeploymentRunner(parameters, store)
+	if err != nil {
+		return err
+	}
+	if store == secretsStoreSsm {
+		ssmClient, err := cloud_api_clients.GetSsmClient(parameters)
+		if err != nil {
+			return err
+		}
+		return deleteSsmSecretEnvironmentVariables(ssmClient, prefix, nil, logsWriter)
+	}
+	secretsManagerClient, err := cloud_api_clients.GetSecretsManagerClient(parameters)
+	if err != nil {
+		return err
+	}
+	return deleteSecretsManagerSecretEnvironmentVariables(secretsManagerClient, prefix, nil, forceDelete, logsWriter)
+}
+
+// deleteSecretEnvironmentVariables removes all secret env vars of a deployment
+// from both stores and the execution role's policy for them. Called when the
+// service is deleted.
+func deleteSecretEnvironmentVariables(parameters map[string]interface{}, logsWriter io.Writer) error {
+	for _, store := range []string{"", secretsStoreSsm} {
+		err := deleteStoredSecretEnvironmentVariables(parameters, store, true, logsWriter)
+		if err != nil {
+			return err
+		}
+	}
+	ecsTaskExecutionRoleArn, err := jobs.GetParameterValue[string](parameters, parameters_enums.EcsTaskExecutionRoleArn)
+	if err != nil || len(ecsTaskExecutionRoleArn) == 0 {
+		return nil
+	}
+	roleName, _, err := getRoleNameAndAccountID(ecsTaskExecutionRoleArn)
+	if err != nil {
+		return err
+	}
+	prefix, err := getSecretEnvironmentVariablesPrefix(parameters)
+	if err != nil {
+		return err
+	}
+	iamClient, err := cloud_api_clients.GetIamClient(parameters)
+	if err != nil {
+		return err
+	}
+	_, err = iamClient.DeleteRolePolicy(context.TODO(), &iam.DeleteRolePolicyInput{
+		PolicyName: aws.String(getExecutionRoleSecretsPolicyName(prefix)),
+		RoleName:   aws.String(roleName),
+	})
+	var noSuchEntityException *iamTypes.NoSuchEntityException
+	if err != nil && !errors.As(err, &noSuchEntityException) {
+		return err
+	}
+	return nil
+}
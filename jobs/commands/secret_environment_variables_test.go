@@ -0,0 +1,43 @@
+package commands
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestGetRoleNameAndAccountID(t *testing.T) {
+	roleName, accountID, err := getRoleNameAndAccountID("arn:aws:iam::123456789012:role/service-role/ecsTaskExecutionRole")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roleName != "ecsTaskExecutionRole" || accountID != "123456789012" {
+		t.Errorf("got %s, %s", roleName, accountID)
+	}
+	if _, _, err = getRoleNameAndAccountID("arn:aws:iam::123456789012:user/deployer"); err == nil {
+		t.Error("expected an error for a user arn")
+	}
+}
+
+// TestGetExecutionRoleSecretsPolicy scopes the policy to the deployment's
+// prefix, account and region and ignores formatting when comparing it with
+// the one on the role.
+func TestGetExecutionRoleSecretsPolicy(t *testing.T) {
+	policy := getExecutionRoleSecretsPolicy("us-east-1", "123456789012", "env-abc")
+	for _, resource := range []string{
+		"arn:aws:secretsmanager:us-east-1:123456789012:secret:env-abc/*",
+		"arn:aws:ssm:us-east-1:123456789012:parameter/env-abc/*",
+	} {
+		if !strings.Contains(policy, `"`+resource+`"`) {
+			t.Errorf("policy doesn't allow %s:\n%s", resource, policy)
+		}
+	}
+	compacted := strings.Join(strings.Fields(policy), "")
+	if !isSamePolicyDocument(url.QueryEscape(compacted), policy) {
+		t.Error("expected the encoded policy on the role to match")
+	}
+	other := getExecutionRoleSecretsPolicy("us-east-1", "123456789012", "env-def")
+	if isSamePolicyDocument(url.QueryEscape(other), policy) {
+		t.Error("expected another deployment's policy not to match")
+	}
+}
@@ -0,0 +1,124 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+)
+
+// createSecurityGroupForPortIfNeeded creates a security group tagged with
+// securityGroupName in the deployment's VPC that allows TCP on port from the
+// default VPC security group the tasks run in.
+func createSecurityGroupForPortIfNeeded(parameters map[string]interface{}, ec2Client *ec2.Client, securityGroupName,
+	resource, ruleDescription string, port int32) (string, error) {
+	describeSecurityGroupsOutput, err := ec2Client.DescribeSecurityGroups(context.TODO(), &ec2.DescribeSecurityGroupsInput{
+		DryRun: aws.Bool(false),
+		Filters: []ec2Types.Filter{
+			{
+				Name: aws.String("tag:Name"),
+				Values: []string{
+					securityGroupName,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(describeSecurityGroupsOutput.SecurityGroups) > 0 {
+		return aws.ToString(describeSecurityGroupsOutput.SecurityGroups[0].GroupId), nil
+	}
+
+	vpcId, err := jobs.GetParameterValue[string](parameters, parameters_enums.VpcID)
+	if err != nil {
+		return "", err
+	}
+	defaultSecurityGroupId, err := getDefaultSecurityGroupIdForVpc(parameters, ec2Client, vpcId)
+	if err != nil {
+		return "", err
+	}
+	createSecurityGroupOutput, err := ec2Client.CreateSecurityGroup(context.TODO(), &ec2.CreateSecurityGroupInput{
+		Description: aws.String(fmt.Sprintf("security group %s for %s", securityGroupName, resource)),
+		GroupName:   aws.String(securityGroupName),
+		DryRun:      aws.Bool(false),
+		TagSpecifications: []ec2Types.TagSpecification{{
+			ResourceType: ec2Types.ResourceTypeSecurityGroup,
+			Tags: []ec2Types.Tag{
+				{
+					Key:   aws.String("Name"),
+					Value: aws.String(securityGroupName),
+				},
+				{
+					Key:   aws.String("created by"),
+					Value: aws.String("deployment.io"),
+				},
+			},
+		}},
+		VpcId: aws.String(vpcId),
+	})
+	if err != nil {
+		return "", err
+	}
+	securityGroupId := aws.ToString(createSecurityGroupOutput.GroupId)
+	_, err = ec2Client.AuthorizeSecurityGroupIngress(context.TODO(), &ec2.AuthorizeSecurityGroupIngressInput{
+		DryRun:  aws.Bool(false),
+		GroupId: aws.String(securityGroupId),
+		IpPermissions: []ec2Types.IpPermission{{
+			FromPort:   aws.Int32(port),
+			IpProtocol: aws.String("tcp"),
+			ToPort:     aws.Int32(port),
+			UserIdGroupPairs: []ec2Types.UserIdGroupPair{{
+				Description: aws.String(ruleDescription),
+				GroupId:     aws.String(defaultSecurityGroupId),
+			}},
+		}},
+	})
+	if err != nil {
+		return "", err
+	}
+	return securityGroupId, nil
+}
+
+// deleteSecurityGroupsWithName deletes the security groups tagged with
+// securityGroupName. Network interfaces of the resources that used them can
+// take a while to be released, so DependencyViolation is retried for a while.
+func deleteSecurityGroupsWithName(ec2Client *ec2.Client, securityGroupName string) error {
+	describeSecurityGroupsOutput, err := ec2Client.DescribeSecurityGroups(context.TODO(), &ec2.DescribeSecurityGroupsInput{
+		DryRun: aws.Bool(false),
+		Filters: []ec2Types.Filter{
+			{
+				Name: aws.String("tag:Name"),
+				Values: []string{
+					securityGroupName,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	for _, securityGroup := range describeSecurityGroupsOutput.SecurityGroups {
+		for i := 0; ; i++ {
+			_, err = ec2Client.DeleteSecurityGroup(context.TODO(), &ec2.DeleteSecurityGroupInput{
+				GroupId: securityGroup.GroupId,
+			})
+			var apiErr smithy.APIError
+			if err == nil || i >= 12 || !errors.As(err, &apiErr) || apiErr.ErrorCode() != "DependencyViolation" {
+				break
+			}
+			time.Sleep(10 * time.Second)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,638 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	commandUtils "github.com/deployment-io/deployment-runner/jobs/commands/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// A shared ALB is created once per environment and every web service that
+// opts in gets a listener rule forwarding its host headers and/or path
+// prefix to its own target group. Rules are found again by the target group
+// they forward to, so no rule ARNs need to be stored.
+
+const (
+	//path rules are evaluated before host-only rules so /api on a host wins over the host itself
+	minPathListenerRulePriority     int32 = 1
+	minHostOnlyListenerRulePriority int32 = 25001
+	maxListenerRulePriority         int32 = 50000
+	//path rules get a band per prefix length with longer prefixes first, so a
+	//rule for /api/v2 wins over one for /api. Rules with hosts come first
+	//within a band.
+	pathListenerRulePriorityBandSize int32 = 100
+	maxOrderedPathPrefixLength             = 249
+	//an ALB rule can hold at most 5 condition values
+	maxListenerRuleConditionValues = 5
+)
+
+// sharedAlbLocks serializes the jobs of an environment that attach services
+// to its shared load balancer or delete it once unused.
+var sharedAlbLocks sync.Map
+
+// lockSharedAlb waits for the environment's other shared load balancer jobs
+// on this runner and returns the unlock function.
+func lockSharedAlb(parameters map[string]interface{}) (func(), error) {
+	environmentID, err := getSharedAlbEnvironmentID(parameters)
+	if err != nil {
+		return nil, err
+	}
+	lock, _ := sharedAlbLocks.LoadOrStore(environmentID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	return lock.(*sync.Mutex).Unlock, nil
+}
+
+// getLoadBalancerNameFromArn returns <name> of
+// arn:aws:elasticloadbalancing:<region>:<account>:loadbalancer/app/<name>/<id>.
+func getLoadBalancerNameFromArn(loadBalancerArn string) string {
+	parts := strings.Split(loadBalancerArn, "/")
+	if len(parts) != 4 {
+		return ""
+	}
+	return parts[2]
+}
+
+// checkAlbKindUnchanged rejects turning SharedAlb on or off for a deployed
+// service. Its target group can only be on one load balancer, so it would
+// have to go down while it moves.
+func checkAlbKindUnchanged(parameters map[string]interface{}, loadBalancerArn string) error {
+	albName, err := getAlbName(parameters)
+	if err != nil {
+		return err
+	}
+	currentAlbName := getLoadBalancerNameFromArn(loadBalancerArn)
+	if currentAlbName == albName {
+		return nil
+	}
+	if isSharedAlb(parameters) {
+		return fmt.Errorf("the service is served by its own load balancer %s: delete and deploy it again to move it to the shared load balancer",
+			currentAlbName)
+	}
+	return fmt.Errorf("the service is served by the shared load balancer %s: delete and deploy it again to give it its own load balancer",
+		currentAlbName)
+}
+
+func isSharedAlb(parameters map[string]interface{}) bool {
+	sharedAlb, err := jobs.GetParameterValue[bool](parameters, parameters_enums.SharedAlb)
+	if err != nil {
+		return false
+	}
+	return sharedAlb
+}
+
+func getSharedAlbEnvironmentID(parameters map[string]interface{}) (string, error) {
+	environmentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.EnvironmentID)
+	if err != nil {
+		return "", err
+	}
+	if len(environmentID) == 0 {
+		return "", fmt.Errorf("environment is required for a shared load balancer")
+	}
+	return environmentID, nil
+}
+
+func getAlbHostHeaders(parameters map[string]interface{}) []string {
+	hostHeaders, err := jobs.GetParameterValue[string](parameters, parameters_enums.AlbHostHeaders)
+	if err != nil {
+		return nil
+	}
+	var hosts []string
+	for _, host := range strings.Split(hostHeaders, ",") {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if len(host) > 0 {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// getAlbRuleHosts returns the host headers and custom domains the service
+// is reached on.
+func getAlbRuleHosts(parameters map[string]interface{}) ([]string, error) {
+	hosts := getAlbHostHeaders(parameters)
+	domainsA, _ := jobs.GetParameterValue[primitive.A](parameters, parameters_enums.Domains)
+	if len(domainsA) == 0 {
+		return hosts, nil
+	}
+	domains, err := commandUtils.ConvertPrimitiveAToStringSlice(domainsA)
+	if err != nil {
+		return nil, err
+	}
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if len(domain) > 0 && !slices.Contains(hosts, domain) {
+			hosts = append(hosts, domain)
+		}
+	}
+	return hosts, nil
+}
+
+func getAlbPathPrefix(parameters map[string]interface{}) string {
+	pathPrefix, err := jobs.GetParameterValue[string](parameters, parameters_enums.AlbPathPrefix)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(pathPrefix)
+}
+
+// getListenerRuleConditions turns host headers and a path prefix into ALB
+// rule conditions. A prefix of /api matches /api and everything below it.
+func getListenerRuleConditions(hosts []string, pathPrefix string) ([]elbTypes.RuleCondition, error) {
+	var conditions []elbTypes.RuleCondition
+	var valueCount int
+	if len(hosts) > 0 {
+		conditions = append(conditions, elbTypes.RuleCondition{
+			Field: aws.String("host-header"),
+			HostHeaderConfig: &elbTypes.HostHeaderConditionConfig{
+				Values: hosts,
+			},
+		})
+		valueCount += len(hosts)
+	}
+	pathPrefix = strings.TrimRight(pathPrefix, "/*")
+	if len(pathPrefix) > 0 {
+		if !strings.HasPrefix(pathPrefix, "/") {
+			return nil, fmt.Errorf("path prefix %s must start with /", pathPrefix)
+		}
+		paths := []string{pathPrefix, pathPrefix + "/*"}
+		conditions = append(conditions, elbTypes.RuleCondition{
+			Field: aws.String("path-pattern"),
+			PathPatternConfig: &elbTypes.PathPatternConditionConfig{
+				Values: paths,
+			},
+		})
+		valueCount += len(paths)
+	}
+	if len(conditions) == 0 {
+		return nil, fmt.Errorf("a host header or path prefix is required to route to a service on a shared load balancer")
+	}
+	if valueCount > maxListenerRuleConditionValues {
+		return nil, fmt.Errorf("too many host headers and domains for a shared load balancer rule: at most %d values are allowed including the path prefix",
+			maxListenerRuleConditionValues)
+	}
+	return conditions, nil
+}
+
+// getListenerRulePriorityRange returns the priorities a rule for the hosts
+// and path prefix may take.
+func getListenerRulePriorityRange(hasHosts bool, pathPrefix string) (int32, int32) {
+	pathPrefix = strings.TrimRight(pathPrefix, "/*")
+	if len(pathPrefix) == 0 {
+		return minHostOnlyListenerRulePriority, maxListenerRulePriority
+	}
+	length := min(len(pathPrefix), maxOrderedPathPrefixLength)
+	bandStart := minPathListenerRulePriority + int32(maxOrderedPathPrefixLength-length)*pathListenerRulePriorityBandSize
+	if hasHosts {
+		return bandStart, bandStart + pathListenerRulePriorityBandSize/2 - 1
+	}
+	return bandStart + pathListenerRulePriorityBandSize/2, bandStart + pathListenerRulePriorityBandSize - 1
+}
+
+func getListenerRulePriority(rule elbTypes.Rule) (int32, bool) {
+	var priority int32
+	if _, err := fmt.Sscanf(aws.ToString(rule.Priority), "%d", &priority); err != nil {
+		return 0, false
+	}
+	return priority, true
+}
+
+// nextFreeListenerRulePriority returns the lowest priority between
+// minPriority and maxPriority that is not taken by an existing rule.
+func nextFreeListenerRulePriority(rules []elbTypes.Rule, minPriority, maxPriority int32) (int32, error) {
+	var taken []int
+	for _, rule := range rules {
+		if aws.ToBool(rule.IsDefault) {
+			continue
+		}
+		priority, ok := getListenerRulePriority(rule)
+		if !ok {
+			continue
+		}
+		taken = append(taken, int(priority))
+	}
+	sort.Ints(taken)
+	next := int(minPriority)
+	for _, priority := range taken {
+		if priority < next {
+			continue
+		}
+		if priority > next {
+			break
+		}
+		next++
+	}
+	if next > int(maxPriority) {
+		return 0, fmt.Errorf("no free listener rule priority between %d and %d", minPriority, maxPriority)
+	}
+	return int32(next), nil
+}
+
+func getListenerRuleForTargetGroup(rules []elbTypes.Rule, targetGroupArn string) *elbTypes.Rule {
+	for i, rule := range rules {
+		if aws.ToBool(rule.IsDefault) {
+			continue
+		}
+		for _, action := range rule.Actions {
+			if aws.ToString(action.TargetGroupArn) == targetGroupArn {
+				return &rules[i]
+			}
+			if action.ForwardConfig == nil {
+				continue
+			}
+			for _, targetGroup := range action.ForwardConfig.TargetGroups {
+				if aws.ToString(targetGroup.TargetGroupArn) == targetGroupArn {
+					return &rules[i]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func describeAllListenerRules(elbClient *elasticloadbalancingv2.Client, listenerArn string) ([]elbTypes.Rule, error) {
+	var rules []elbTypes.Rule
+	var marker *string
+	for {
+		describeRulesOutput, err := elbClient.DescribeRules(context.TODO(), &elasticloadbalancingv2.DescribeRulesInput{
+			ListenerArn: aws.String(listenerArn),
+			Marker:      marker,
+		})
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, describeRulesOutput.Rules...)
+		if describeRulesOutput.NextMarker == nil {
+			break
+		}
+		marker = describeRulesOutput.NextMarker
+	}
+	return rules, nil
+}
+
+func getSharedAlbListenerDefaultActions() []elbTypes.Action {
+	return []elbTypes.Action{{
+		Type:  elbTypes.ActionTypeEnumFixedResponse,
+		Order: aws.Int32(1),
+		FixedResponseConfig: &elbTypes.FixedResponseActionConfig{
+			StatusCode:  aws.String("404"),
+			ContentType: aws.String("text/plain"),
+			MessageBody: aws.String("Not Found"),
+		},
+	}}
+}
+
+// createAlbListenerRuleIfNeeded creates or updates the rule forwarding the
+// service's hosts and path prefix to its target group on the listener.
+func createAlbListenerRuleIfNeeded(parameters map[string]interface{}, elbClient *elasticloadbalancingv2.Client,
+	listenerArn, targetGroupArn string, logsWriter io.Writer) (string, error) {
+	pathPrefix := getAlbPathPrefix(parameters)
+	hosts, err := getAlbRuleHosts(parameters)
+	if err != nil {
+		return "", err
+	}
+	conditions, err := getListenerRuleConditions(hosts, pathPrefix)
+	if err != nil {
+		return "", err
+	}
+	minPriority, maxPriority := getListenerRulePriorityRange(len(hosts) > 0, pathPrefix)
+	albListenerRuleName, err := getAlbListenerRuleName(parameters)
+	if err != nil {
+		return "", err
+	}
+	//another deployment might take the same priority in between, retry with a fresh view of the rules
+	for attempt := 0; attempt < 5; attempt++ {
+		rules, err := describeAllListenerRules(elbClient, listenerArn)
+		if err != nil {
+			return "", err
+		}
+		existingRule := getListenerRuleForTargetGroup(rules, targetGroupArn)
+		if existingRule != nil {
+			_, err = elbClient.ModifyRule(context.TODO(), &elasticloadbalancingv2.ModifyRuleInput{
+				RuleArn:    existingRule.RuleArn,
+				Conditions: conditions,
+			})
+			if err != nil {
+				return "", err
+			}
+			//the path prefix or hosts changed, move the rule to its band
+			priority, ok := getListenerRulePriority(*existingRule)
+			if ok && priority >= minPriority && priority <= maxPriority {
+				return aws.ToString(existingRule.RuleArn), nil
+			}
+			priority, err = nextFreeListenerRulePriority(rules, minPriority, maxPriority)
+			if err != nil {
+				return "", err
+			}
+			io.WriteString(logsWriter, fmt.Sprintf("Moving listener rule %s to priority %d\n", aws.ToString(existingRule.RuleArn), priority))
+			_, err = elbClient.SetRulePriorities(context.TODO(), &elasticloadbalancingv2.SetRulePrioritiesInput{
+				RulePriorities: []elbTypes.RulePriorityPair{{
+					RuleArn:  existingRule.RuleArn,
+					Priority: aws.Int32(priority),
+				}},
+			})
+			var priorityInUseException *elbTypes.PriorityInUseException
+			if errors.As(err, &priorityInUseException) {
+				time.Sleep(time.Second)
+				continue
+			}
+			if err != nil {
+				return "", err
+			}
+			return aws.ToString(existingRule.RuleArn), nil
+		}
+		priority, err := nextFreeListenerRulePriority(rules, minPriority, maxPriority)
+		if err != nil {
+			return "", err
+		}
+		io.WriteString(logsWriter, fmt.Sprintf("Creating listener rule with priority %d on listener: %s\n", priority, listenerArn))
+		createRuleOutput, err := elbClient.CreateRule(context.TODO(), &elasticloadbalancingv2.CreateRuleInput{
+			ListenerArn: aws.String(listenerArn),
+			Priority:    aws.Int32(priority),
+			Conditions:  conditions,
+			Actions: []elbTypes.Action{{
+				Type:           elbTypes.ActionTypeEnumForward,
+				Order:          aws.Int32(1),
+				TargetGroupArn: aws.String(targetGroupArn),
+			}},
+			Tags: []elbTypes.Tag{
+				{
+					Key:   aws.String("Name"),
+					Value: aws.String(albListenerRuleName),
+				},
+				{
+					Key:   aws.String("created by"),
+					Value: aws.String("deployment.io"),
+				},
+			},
+		})
+		var priorityInUseException *elbTypes.PriorityInUseException
+		if errors.As(err, &priorityInUseException) {
+			time.Sleep(time.Second)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return aws.ToString(createRuleOutput.Rules[0].RuleArn), nil
+	}
+	return "", fmt.Errorf("couldn't allocate a listener rule priority on listener: %s", listenerArn)
+}
+
+// createSharedAlbListenerRulesIfNeeded routes the service on every listener
+// of the shared load balancer, e.g. both http and https. HTTP2 and gRPC
+// services are only routed on https.
+func createSharedAlbListenerRulesIfNeeded(parameters map[string]interface{}, elbClient *elasticloadbalancingv2.Client,
+	loadBalancerArn, targetGroupArn string, logsWriter io.Writer) error {
+	describeListenersOutput, err := elbClient.DescribeListeners(context.TODO(), &elasticloadbalancingv2.DescribeListenersInput{
+		LoadBalancerArn: aws.String(loadBalancerArn),
+	})
+	if err != nil {
+		return err
+	}
+	targetGroupSettings, err := getTargetGroupSettings(parameters)
+	if err != nil {
+		return err
+	}
+	for _, listener := range describeListenersOutput.Listeners {
+		if targetGroupSettings.requiresHttps() && listener.Protocol != elbTypes.ProtocolEnumHttps {
+			//the load balancer refuses to forward http to HTTP2 and gRPC targets
+			continue
+		}
+		_, err = createAlbListenerRuleIfNeeded(parameters, elbClient, aws.ToString(listener.ListenerArn), targetGroupArn, logsWriter)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteSharedAlbListenerRules removes the rules forwarding to the target
+// group from every listener of the shared load balancer.
+func deleteSharedAlbListenerRules(elbClient *elasticloadbalancingv2.Client, loadBalancerArn, targetGroupArn string, logsWriter io.Writer) error {
+	describeListenersOutput, err := elbClient.DescribeListeners(context.TODO(), &elasticloadbalancingv2.DescribeListenersInput{
+		LoadBalancerArn: aws.String(loadBalancerArn),
+	})
+	if err != nil {
+		return err
+	}
+	for _, listener := range describeListenersOutput.Listeners {
+		rules, err := describeAllListenerRules(elbClient, aws.ToString(listener.ListenerArn))
+		if err != nil {
+			return err
+		}
+		rule := getListenerRuleForTargetGroup(rules, targetGroupArn)
+		if rule == nil {
+			continue
+		}
+		io.WriteString(logsWriter, fmt.Sprintf("Deleting listener rule: %s\n", aws.ToString(rule.RuleArn)))
+		_, err = elbClient.DeleteRule(context.TODO(), &elasticloadbalancingv2.DeleteRuleInput{
+			RuleArn: rule.RuleArn,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// certificateCoversHost reports whether a certificate for names is valid for
+// host. A wildcard covers one label.
+func certificateCoversHost(names []string, host string) bool {
+	host = strings.ToLower(host)
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if name == host {
+			return true
+		}
+		if strings.HasPrefix(name, "*.") && strings.Count(host, ".") == strings.Count(name, ".") &&
+			strings.HasSuffix(host, name[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func getListenerRuleHosts(rules []elbTypes.Rule) []string {
+	var hosts []string
+	for _, rule := range rules {
+		for _, condition := range rule.Conditions {
+			if condition.HostHeaderConfig != nil {
+				hosts = append(hosts, condition.HostHeaderConfig.Values...)
+			}
+		}
+	}
+	return hosts
+}
+
+// removeSharedAlbListenerCertificate removes the certificate the service
+// added to the shared https listener, unless it is the listener's default or
+// still covers a host of another service's rule. Called after the service's
+// rules are deleted.
+func removeSharedAlbListenerCertificate(parameters map[string]interface{}, elbClient *elasticloadbalancingv2.Client,
+	loadBalancerArn string, logsWriter io.Writer) error {
+	certificateArn, err := jobs.GetParameterValue[string](parameters, parameters_enums.AcmCertificateArn)
+	if err != nil || len(certificateArn) == 0 {
+		return nil
+	}
+	describeListenersOutput, err := elbClient.DescribeListeners(context.TODO(), &elasticloadbalancingv2.DescribeListenersInput{
+		LoadBalancerArn: aws.String(loadBalancerArn),
+	})
+	if err != nil {
+		return err
+	}
+	for _, listener := range describeListenersOutput.Listeners {
+		if listener.Protocol != elbTypes.ProtocolEnumHttps {
+			continue
+		}
+		listenerArn := aws.ToString(listener.ListenerArn)
+		var listenerCertificate *elbTypes.Certificate
+		var marker *string
+		for listenerCertificate == nil {
+			describeListenerCertificatesOutput, err := elbClient.DescribeListenerCertificates(context.TODO(),
+				&elasticloadbalancingv2.DescribeListenerCertificatesInput{
+					ListenerArn: aws.String(listenerArn),
+					Marker:      marker,
+				})
+			if err != nil {
+				return err
+			}
+			for i, certificate := range describeListenerCertificatesOutput.Certificates {
+				if aws.ToString(certificate.CertificateArn) == certificateArn {
+					listenerCertificate = &describeListenerCertificatesOutput.Certificates[i]
+					break
+				}
+			}
+			if describeListenerCertificatesOutput.NextMarker == nil {
+				break
+			}
+			marker = describeListenerCertificatesOutput.NextMarker
+		}
+		if listenerCertificate == nil || aws.ToBool(listenerCertificate.IsDefault) {
+			continue
+		}
+		rules, err := describeAllListenerRules(elbClient, listenerArn)
+		if err != nil {
+			return err
+		}
+		acmClient, err := cloud_api_clients.GetAcmClient(parameters)
+		if err != nil {
+			return err
+		}
+		describeCertificateOutput, err := acmClient.DescribeCertificate(context.TODO(), &acm.DescribeCertificateInput{
+			CertificateArn: aws.String(certificateArn),
+		})
+		if err != nil {
+			return err
+		}
+		names := describeCertificateOutput.Certificate.SubjectAlternativeNames
+		stillUsed := false
+		for _, host := range getListenerRuleHosts(rules) {
+			if certificateCoversHost(names, host) {
+				stillUsed = true
+				break
+			}
+		}
+		if stillUsed {
+			io.WriteString(logsWriter, fmt.Sprintf("Keeping certificate %s used by other services on listener: %s\n", certificateArn, listenerArn))
+			continue
+		}
+		io.WriteString(logsWriter, fmt.Sprintf("Removing certificate %s from listener: %s\n", certificateArn, listenerArn))
+		_, err = elbClient.RemoveListenerCertificates(context.TODO(), &elasticloadbalancingv2.RemoveListenerCertificatesInput{
+			ListenerArn:  aws.String(listenerArn),
+			Certificates: []elbTypes.Certificate{{CertificateArn: aws.String(certificateArn)}},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasSharedAlbListenerRules is true when a listener of the load balancer has
+// a rule besides its default one.
+func hasSharedAlbListenerRules(elbClient *elasticloadbalancingv2.Client, loadBalancerArn string) (bool, error) {
+	describeListenersOutput, err := elbClient.DescribeListeners(context.TODO(), &elasticloadbalancingv2.DescribeListenersInput{
+		LoadBalancerArn: aws.String(loadBalancerArn),
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, listener := range describeListenersOutput.Listeners {
+		rules, err := describeAllListenerRules(elbClient, aws.ToString(listener.ListenerArn))
+		if err != nil {
+			return false, err
+		}
+		for _, rule := range rules {
+			if !aws.ToBool(rule.IsDefault) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// deleteSharedAlbIfUnused deletes the shared load balancer and its security
+// group once no target group is attached to it anymore.
+func deleteSharedAlbIfUnused(elbClient *elasticloadbalancingv2.Client, ec2Client *ec2.Client,
+	loadBalancerArn, albSecurityGroupID string, logsWriter io.Writer) error {
+	describeTargetGroupsOutput, err := elbClient.DescribeTargetGroups(context.TODO(), &elasticloadbalancingv2.DescribeTargetGroupsInput{
+		LoadBalancerArn: aws.String(loadBalancerArn),
+	})
+	if err != nil {
+		return err
+	}
+	if len(describeTargetGroupsOutput.TargetGroups) > 0 {
+		io.WriteString(logsWriter, fmt.Sprintf("Shared load balancer %s is still used by %d services\n",
+			loadBalancerArn, len(describeTargetGroupsOutput.TargetGroups)))
+		return nil
+	}
+	//a service on another runner may have added its rule since
+	inUse, err := hasSharedAlbListenerRules(elbClient, loadBalancerArn)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		io.WriteString(logsWriter, fmt.Sprintf("Shared load balancer %s got a new service, keeping it\n", loadBalancerArn))
+		return nil
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Deleting shared load balancer: %s\n", loadBalancerArn))
+	_, err = elbClient.DeleteLoadBalancer(context.TODO(), &elasticloadbalancingv2.DeleteLoadBalancerInput{LoadBalancerArn: aws.String(loadBalancerArn)})
+	if err != nil {
+		return err
+	}
+	loadBalancersDeletedWaiter := elasticloadbalancingv2.NewLoadBalancersDeletedWaiter(elbClient)
+	err = loadBalancersDeletedWaiter.Wait(context.TODO(), &elasticloadbalancingv2.DescribeLoadBalancersInput{
+		LoadBalancerArns: []string{
+			loadBalancerArn,
+		},
+	}, 20*time.Minute)
+	if err != nil {
+		return err
+	}
+
+	//sleep after alb is deleted else AWS might give an error
+	time.Sleep(1 * time.Minute)
+
+	io.WriteString(logsWriter, fmt.Sprintf("Deleting security group: %s\n", albSecurityGroupID))
+	_, err = ec2Client.DeleteSecurityGroup(context.TODO(), &ec2.DeleteSecurityGroupInput{
+		DryRun:  aws.Bool(false),
+		GroupId: aws.String(albSecurityGroupID),
+	})
+	return err
+}
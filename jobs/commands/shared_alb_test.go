@@ -0,0 +1,122 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+)
+
+// TestGetListenerRuleConditions_HostAndPath matches the prefix itself and
+// everything below it, next to the host headers.
+func TestGetListenerRuleConditions_HostAndPath(t *testing.T) {
+	conditions, err := getListenerRuleConditions([]string{"api.example.com"}, "/v1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conditions) != 2 {
+		t.Fatalf("conditions = %+v, want host and path", conditions)
+	}
+	if got := conditions[0].HostHeaderConfig.Values; len(got) != 1 || got[0] != "api.example.com" {
+		t.Errorf("host values = %v", got)
+	}
+	if got := conditions[1].PathPatternConfig.Values; len(got) != 2 || got[0] != "/v1" || got[1] != "/v1/*" {
+		t.Errorf("path values = %v, want [/v1 /v1/*]", got)
+	}
+}
+
+func TestGetListenerRuleConditions_Invalid(t *testing.T) {
+	if _, err := getListenerRuleConditions(nil, ""); err == nil {
+		t.Error("expected an error without host or path")
+	}
+	if _, err := getListenerRuleConditions(nil, "api"); err == nil {
+		t.Error("expected an error for a relative path prefix")
+	}
+	hosts := []string{"a.example.com", "b.example.com", "c.example.com", "d.example.com"}
+	if _, err := getListenerRuleConditions(hosts, "/api"); err == nil {
+		t.Error("expected an error above the condition value limit")
+	}
+}
+
+// TestNextFreeListenerRulePriority fills gaps first and keeps path rules
+// ahead of host-only rules.
+func TestNextFreeListenerRulePriority(t *testing.T) {
+	rules := []elbTypes.Rule{
+		{Priority: aws.String("default"), IsDefault: aws.Bool(true)},
+		{Priority: aws.String("1")},
+		{Priority: aws.String("2")},
+		{Priority: aws.String("4")},
+		{Priority: aws.String("25001")},
+	}
+	priority, err := nextFreeListenerRulePriority(rules, 1, minHostOnlyListenerRulePriority-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if priority != 3 {
+		t.Errorf("path rule priority = %d, want 3", priority)
+	}
+	priority, err = nextFreeListenerRulePriority(rules, minHostOnlyListenerRulePriority, maxListenerRulePriority)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if priority != 25002 {
+		t.Errorf("host-only rule priority = %d, want 25002", priority)
+	}
+}
+
+// TestGetListenerRulePriorityRange puts longer path prefixes first, rules
+// with hosts ahead of path-only ones and host-only rules last.
+func TestGetListenerRulePriorityRange(t *testing.T) {
+	apiMin, apiMax := getListenerRulePriorityRange(false, "/api")
+	v2Min, v2Max := getListenerRulePriorityRange(false, "/api/v2/*")
+	if v2Max >= apiMin {
+		t.Errorf("/api/v2 range [%d, %d] should come before /api range [%d, %d]", v2Min, v2Max, apiMin, apiMax)
+	}
+	hostMin, hostMax := getListenerRulePriorityRange(true, "/api/")
+	if hostMax >= apiMin || hostMin <= v2Max {
+		t.Errorf("/api with hosts range [%d, %d] should sit between /api/v2 and /api", hostMin, hostMax)
+	}
+	shortMin, shortMax := getListenerRulePriorityRange(false, "/a")
+	hostOnlyMin, hostOnlyMax := getListenerRulePriorityRange(true, "")
+	if shortMax >= hostOnlyMin || hostOnlyMin != minHostOnlyListenerRulePriority || hostOnlyMax != maxListenerRulePriority {
+		t.Errorf("/a range [%d, %d], host-only range [%d, %d]", shortMin, shortMax, hostOnlyMin, hostOnlyMax)
+	}
+	longMin, _ := getListenerRulePriorityRange(true, "/"+strings.Repeat("a", 300))
+	if longMin != minPathListenerRulePriority {
+		t.Errorf("long prefix range starts at %d", longMin)
+	}
+}
+
+func TestCertificateCoversHost(t *testing.T) {
+	names := []string{"example.com", "*.example.com"}
+	for host, want := range map[string]bool{
+		"example.com":        true,
+		"WWW.example.com":    true,
+		"a.b.example.com":    false,
+		"example.org":        false,
+		"badexample.com":     false,
+		"www.badexample.com": false,
+	} {
+		if got := certificateCoversHost(names, host); got != want {
+			t.Errorf("certificateCoversHost(%s) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestGetListenerRuleForTargetGroup(t *testing.T) {
+	rules := []elbTypes.Rule{
+		{RuleArn: aws.String("default"), IsDefault: aws.Bool(true), Actions: []elbTypes.Action{{TargetGroupArn: aws.String("tg-1")}}},
+		{RuleArn: aws.String("rule-2"), Actions: []elbTypes.Action{{TargetGroupArn: aws.String("tg-2")}}},
+		{RuleArn: aws.String("rule-1"), Actions: []elbTypes.Action{{ForwardConfig: &elbTypes.ForwardActionConfig{
+			TargetGroups: []elbTypes.TargetGroupTuple{{TargetGroupArn: aws.String("tg-1")}},
+		}}}},
+	}
+	rule := getListenerRuleForTargetGroup(rules, "tg-1")
+	if rule == nil || aws.ToString(rule.RuleArn) != "rule-1" {
+		t.Errorf("rule = %+v, want rule-1", rule)
+	}
+	if getListenerRuleForTargetGroup(rules, "tg-3") != nil {
+		t.Error("expected no rule for an unknown target group")
+	}
+}
@@ -0,0 +1,224 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	rdsTypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/enums/iam_policy_enums"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/iam_policies"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	runnerUtils "github.com/deployment-io/deployment-runner/utils"
+)
+
+// kinds of manual snapshots the runner takes, part of the snapshot identifier
+const (
+	rdsSnapshotManual    = "manual"
+	rdsSnapshotPreModify = "premodify"
+	rdsSnapshotPreDelete = "predelete"
+)
+
+const rdsSnapshotWaitDuration = 60 * time.Minute
+
+// how many of the snapshots taken before a modify or upgrade are kept, the
+// older ones are deleted
+const rdsSnapshotsKept = 3
+
+// SnapshotAwsRdsDatabase takes a manual snapshot of the deployment's RDS
+// instance tagged with the job ID and writes it to JobOutput.
+type SnapshotAwsRdsDatabase struct {
+}
+
+func getRdsSnapshotIdentifier(parameters map[string]interface{}, kind string) (string, error) {
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return "", err
+	}
+	jobID, err := jobs.GetParameterValue[string](parameters, parameters_enums.JobID)
+	if err != nil {
+		return "", err
+	}
+	//rds-<deploymentID>-<kind>-<jobID>
+	return fmt.Sprintf("rds-%s-%s-%s", deploymentID, kind, jobID), nil
+}
+
+// createRdsSnapshot snapshots the instance and waits for the snapshot to be
+// available. Running the same job again reuses the snapshot it already took.
+func createRdsSnapshot(parameters map[string]interface{}, rdsClient *rds.Client, rdsInstanceIdentifier, kind string,
+	logsWriter io.Writer) (*rdsTypes.DBSnapshot, error) {
+	snapshotIdentifier, err := getRdsSnapshotIdentifier(parameters, kind)
+	if err != nil {
+		return nil, err
+	}
+	jobID, err := jobs.GetParameterValue[string](parameters, parameters_enums.JobID)
+	if err != nil {
+		return nil, err
+	}
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	describeDBSnapshotsOutput, err := rdsClient.DescribeDBSnapshots(context.TODO(), &rds.DescribeDBSnapshotsInput{
+		DBSnapshotIdentifier: aws.String(snapshotIdentifier),
+	})
+	var dbSnapshotNotFoundFault *rdsTypes.DBSnapshotNotFoundFault
+	if err != nil && !errors.As(err, &dbSnapshotNotFoundFault) {
+		return nil, err
+	}
+	if err != nil || len(describeDBSnapshotsOutput.DBSnapshots) == 0 {
+		io.WriteString(logsWriter, fmt.Sprintf("Creating RDS snapshot: %s\n", snapshotIdentifier))
+		_, err = rdsClient.CreateDBSnapshot(context.TODO(), &rds.CreateDBSnapshotInput{
+			DBInstanceIdentifier: aws.String(rdsInstanceIdentifier),
+			DBSnapshotIdentifier: aws.String(snapshotIdentifier),
+			Tags: []rdsTypes.Tag{
+				{
+					Key:   aws.String("Name"),
+					Value: aws.String(snapshotIdentifier),
+				},
+				{
+					Key:   aws.String("created by"),
+					Value: aws.String("deployment.io"),
+				},
+				{
+					Key:   aws.String("deployment-id"),
+					Value: aws.String(deploymentID),
+				},
+				{
+					Key:   aws.String("job-id"),
+					Value: aws.String(jobID),
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	io.WriteString(logsWriter, fmt.Sprintf("Waiting for RDS snapshot to be available: %s\n", snapshotIdentifier))
+	waiter := rds.NewDBSnapshotAvailableWaiter(rdsClient)
+	describeDBSnapshotsOutput, err = waiter.WaitForOutput(context.TODO(), &rds.DescribeDBSnapshotsInput{
+		DBSnapshotIdentifier: aws.String(snapshotIdentifier),
+	}, rdsSnapshotWaitDuration)
+	if err != nil {
+		return nil, err
+	}
+	if len(describeDBSnapshotsOutput.DBSnapshots) == 0 {
+		return nil, fmt.Errorf("RDS snapshot %s not found", snapshotIdentifier)
+	}
+	return &describeDBSnapshotsOutput.DBSnapshots[0], nil
+}
+
+// snapshotRdsBeforeChange is the automatic snapshot before a modify or delete.
+// SkipRdsSnapshot turns it off, e.g. for throwaway preview databases.
+func snapshotRdsBeforeChange(parameters map[string]interface{}, rdsClient *rds.Client, rdsInstanceIdentifier, kind string,
+	logsWriter io.Writer) error {
+	skipRdsSnapshot, _ := jobs.GetParameterValue[bool](parameters, parameters_enums.SkipRdsSnapshot)
+	if skipRdsSnapshot {
+		return nil
+	}
+	_, err := createRdsSnapshot(parameters, rdsClient, rdsInstanceIdentifier, kind, logsWriter)
+	return err
+}
+
+// getRdsSnapshotsToPrune returns the identifiers of the snapshots with the
+// prefix beyond the newest keep ones. Snapshots still being created count
+// towards keep but are never pruned.
+func getRdsSnapshotsToPrune(dbSnapshots []rdsTypes.DBSnapshot, prefix string, keep int) []string {
+	var snapshots []rdsSnapshot
+	for _, dbSnapshot := range dbSnapshots {
+		if strings.HasPrefix(aws.ToString(dbSnapshot.DBSnapshotIdentifier), prefix) {
+			snapshots = append(snapshots, toRdsSnapshot(dbSnapshot))
+		}
+	}
+	sortRdsSnapshots(snapshots)
+	var snapshotIdentifiers []string
+	for i := keep; i < len(snapshots); i++ {
+		if snapshots[i].Status == "available" {
+			snapshotIdentifiers = append(snapshotIdentifiers, snapshots[i].Identifier)
+		}
+	}
+	return snapshotIdentifiers
+}
+
+// pruneRdsSnapshots deletes the runner's snapshots of a kind beyond the newest
+// rdsSnapshotsKept. Manual and pre-delete snapshots are never pruned.
+func pruneRdsSnapshots(parameters map[string]interface{}, rdsClient *rds.Client, rdsInstanceIdentifier, kind string,
+	logsWriter io.Writer) error {
+	if kind == rdsSnapshotManual || kind == rdsSnapshotPreDelete {
+		return nil
+	}
+	deploymentID, err := jobs.GetParameterValue[string](parameters, parameters_enums.DeploymentID)
+	if err != nil {
+		return err
+	}
+	var dbSnapshots []rdsTypes.DBSnapshot
+	paginator := rds.NewDescribeDBSnapshotsPaginator(rdsClient, &rds.DescribeDBSnapshotsInput{
+		DBInstanceIdentifier: aws.String(rdsInstanceIdentifier),
+		SnapshotType:         aws.String("manual"),
+	})
+	for paginator.HasMorePages() {
+		describeDBSnapshotsOutput, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return err
+		}
+		dbSnapshots = append(dbSnapshots, describeDBSnapshotsOutput.DBSnapshots...)
+	}
+	//rds-<deploymentID>-<kind>-
+	prefix := fmt.Sprintf("rds-%s-%s-", deploymentID, kind)
+	for _, snapshotIdentifier := range getRdsSnapshotsToPrune(dbSnapshots, prefix, rdsSnapshotsKept) {
+		io.WriteString(logsWriter, fmt.Sprintf("Deleting old RDS snapshot: %s\n", snapshotIdentifier))
+		_, err = rdsClient.DeleteDBSnapshot(context.TODO(), &rds.DeleteDBSnapshotInput{
+			DBSnapshotIdentifier: aws.String(snapshotIdentifier),
+		})
+		var dbSnapshotNotFoundFault *rdsTypes.DBSnapshotNotFoundFault
+		if err != nil && !errors.As(err, &dbSnapshotNotFoundFault) {
+			return err
+		}
+	}
+	return nil
+}
+
+func addRdsPolicyForDeploymentRunner(parameters map[string]interface{}) error {
+	runnerData := runnerUtils.RunnerData.Get()
+	organizationID, err := jobs.GetParameterValue[string](parameters, parameters_enums.OrganizationIDNamespace)
+	if err != nil {
+		return err
+	}
+	return iam_policies.AddAwsPolicyForDeploymentRunner(iam_policy_enums.AwsRdsDeployment,
+		runnerData.OsType.String(), runnerData.CpuArchEnum.String(), organizationID, runnerData.RunnerRegion, runnerData.Mode, runnerData.TargetCloud)
+}
+
+func (s *SnapshotAwsRdsDatabase) Run(parameters map[string]interface{}, logsWriter io.Writer) (newParameters map[string]interface{}, err error) {
+	err = addRdsPolicyForDeploymentRunner(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	rdsClient, err := cloud_api_clients.GetRdsClient(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	rdsInstanceIdentifier, err := getRdsDBInstanceIdentifier(parameters)
+	if err != nil {
+		return parameters, err
+	}
+	dbSnapshot, err := createRdsSnapshot(parameters, rdsClient, rdsInstanceIdentifier, rdsSnapshotManual, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+	out, err := json.Marshal(toRdsSnapshot(*dbSnapshot))
+	if err != nil {
+		return parameters, err
+	}
+	jobs.SetParameterValue[string](parameters, parameters_enums.JobOutput, string(out))
+	return parameters, nil
+}
@@ -0,0 +1,42 @@
+package commands
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	rdsTypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+)
+
+// TestGetRdsSnapshotsToPrune keeps the newest snapshots of the kind and
+// leaves other kinds and snapshots being created alone.
+func TestGetRdsSnapshotsToPrune(t *testing.T) {
+	dbSnapshot := func(identifier, status string, createdAt int64) rdsTypes.DBSnapshot {
+		s := rdsTypes.DBSnapshot{
+			DBSnapshotIdentifier: aws.String(identifier),
+			Status:               aws.String(status),
+		}
+		if createdAt > 0 {
+			s.SnapshotCreateTime = aws.Time(time.Unix(createdAt, 0))
+		}
+		return s
+	}
+	dbSnapshots := []rdsTypes.DBSnapshot{
+		dbSnapshot("rds-dep1-premodify-job1", "available", 100),
+		dbSnapshot("rds-dep1-premodify-job2", "available", 200),
+		dbSnapshot("rds-dep1-premodify-job3", "available", 300),
+		dbSnapshot("rds-dep1-premodify-job4", "creating", 0),
+		dbSnapshot("rds-dep1-manual-job0", "available", 50),
+		dbSnapshot("rds-dep1-predelete-job0", "available", 60),
+	}
+	got := getRdsSnapshotsToPrune(dbSnapshots, "rds-dep1-premodify-", 2)
+	slices.Sort(got)
+	want := []string{"rds-dep1-premodify-job1", "rds-dep1-premodify-job2"}
+	if !slices.Equal(got, want) {
+		t.Errorf("getRdsSnapshotsToPrune() = %v, want %v", got, want)
+	}
+	if got := getRdsSnapshotsToPrune(dbSnapshots, "rds-dep1-premodify-", 5); len(got) != 0 {
+		t.Errorf("getRdsSnapshotsToPrune() = %v, want none", got)
+	}
+}
@@ -0,0 +1,270 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Builders for the StaticSiteBuilder parameter. Auto, the default, detects
+// the builder from the files in the repository's root directory and the
+// build command.
+const (
+	staticSiteBuilderAuto   = "auto"
+	staticSiteBuilderNode   = "node"
+	staticSiteBuilderBun    = "bun"
+	staticSiteBuilderHugo   = "hugo"
+	staticSiteBuilderJekyll = "jekyll"
+	staticSiteBuilderMkDocs = "mkdocs"
+	staticSiteBuilderHtml   = "html"
+)
+
+// htmlStagingDirectory is where a plain HTML site in the repository's root
+// directory is copied to, so the repository's dot files aren't published.
+const htmlStagingDirectory = "_static_site"
+
+// staticSiteBuilderProfile is how a static site generator is run. Images
+// are pinned so a site doesn't break on an upstream release; a profile
+// without an image has no build.
+type staticSiteBuilderProfile struct {
+	Name                string
+	Image               string
+	Shell               string
+	InstallCommand      string
+	DefaultBuildCommand string // empty when the BuildCommand parameter is required
+	OutputDirectory     string // the PublishDirectory default, empty when there's none
+	Env                 []string
+	// The dependency cache is keyed by the package manager and lockfiles,
+	// and mounted at dependencyCacheDirectory with CacheEnv pointing the
+	// package manager at it.
+	PackageManager string
+	Lockfiles      []string
+	CacheEnv       []string
+}
+
+// dependencyCacheDirectory is where the build container mounts its
+// dependency cache volume.
+const dependencyCacheDirectory = "/dependency-cache"
+
+var staticSiteBuilderProfiles = map[string]staticSiteBuilderProfile{
+	staticSiteBuilderNode: {
+		Name:           staticSiteBuilderNode,
+		Image:          "node:22.11.0-bookworm",
+		Shell:          "bash",
+		InstallCommand: "npm install",
+		PackageManager: "npm",
+		Lockfiles:      []string{"package-lock.json", "npm-shrinkwrap.json"},
+		CacheEnv:       []string{"npm_config_cache=" + dependencyCacheDirectory},
+	},
+	staticSiteBuilderBun: {
+		Name: staticSiteBuilderBun,
+		//1.1.39 is the first to read the text bun.lock
+		Image:               "oven/bun:1.1.42",
+		Shell:               "sh",
+		InstallCommand:      "bun install",
+		DefaultBuildCommand: "bun run build",
+		OutputDirectory:     "dist",
+		PackageManager:      "bun",
+		Lockfiles:           []string{"bun.lockb", "bun.lock"},
+		CacheEnv:            []string{"BUN_INSTALL_CACHE_DIR=" + dependencyCacheDirectory},
+	},
+	staticSiteBuilderHugo: {
+		Name:  staticSiteBuilderHugo,
+		Image: "hugomods/hugo:exts-0.139.0",
+		Shell: "sh",
+		//themes using Tailwind or PostCSS have their own package.json
+		InstallCommand:      "if [ -f package.json ]; then npm install; fi",
+		DefaultBuildCommand: "hugo --minify",
+		OutputDirectory:     "public",
+		Env:                 []string{"HUGO_ENVIRONMENT=production"},
+		PackageManager:      "npm",
+		Lockfiles:           []string{"package-lock.json"},
+		CacheEnv:            []string{"npm_config_cache=" + dependencyCacheDirectory},
+	},
+	staticSiteBuilderJekyll: {
+		Name:                staticSiteBuilderJekyll,
+		Image:               "ruby:3.3.6-bookworm",
+		Shell:               "sh",
+		InstallCommand:      "bundle install",
+		DefaultBuildCommand: "bundle exec jekyll build",
+		OutputDirectory:     "_site",
+		Env:                 []string{"JEKYLL_ENV=production"},
+		PackageManager:      "bundler",
+		Lockfiles:           []string{"Gemfile.lock"},
+		//gems are installed into the cache, so a hit skips the native extension builds
+		CacheEnv: []string{"BUNDLE_PATH=" + dependencyCacheDirectory + "/bundle"},
+	},
+	staticSiteBuilderMkDocs: {
+		Name:  staticSiteBuilderMkDocs,
+		Image: "python:3.12.7-slim-bookworm",
+		Shell: "sh",
+		InstallCommand: "if [ -f requirements.txt ]; then pip install -r requirements.txt; " +
+			"else pip install mkdocs==1.6.1 mkdocs-material==9.5.44; fi",
+		DefaultBuildCommand: "mkdocs build",
+		OutputDirectory:     "site",
+		PackageManager:      "pip",
+		Lockfiles:           []string{"requirements.txt"},
+		CacheEnv:            []string{"PIP_CACHE_DIR=" + dependencyCacheDirectory},
+	},
+	staticSiteBuilderHtml: {
+		Name:            staticSiteBuilderHtml,
+		OutputDirectory: htmlStagingDirectory,
+	},
+}
+
+func anyFileExists(directory string, names ...string) bool {
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(directory, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// usesBun is true when the build command runs bun or bunx.
+func usesBun(buildCommand string) bool {
+	words := strings.FieldsFunc(buildCommand, func(r rune) bool {
+		return strings.ContainsRune(" \t\n;&|()", r)
+	})
+	for _, word := range words {
+		if word == "bun" || word == "bunx" {
+			return true
+		}
+	}
+	return false
+}
+
+// detectStaticSiteBuilder picks the builder from the files in the root
+// directory. For a new site generators are checked before package.json since
+// their sites often have one for CSS tooling. An existing site with a
+// package.json was built with node, so it stays on node, or moves to bun only
+// when its build command runs bun; other builders have to be chosen in the
+// settings.
+func detectStaticSiteBuilder(repoDirectory, buildCommand string, isExistingSite bool) (string, error) {
+	hasPackageJson := anyFileExists(repoDirectory, "package.json")
+	switch {
+	case isExistingSite && hasPackageJson:
+		if anyFileExists(repoDirectory, "bun.lockb", "bun.lock") && usesBun(buildCommand) {
+			return staticSiteBuilderBun, nil
+		}
+		return staticSiteBuilderNode, nil
+	case anyFileExists(repoDirectory, "mkdocs.yml", "mkdocs.yaml"):
+		return staticSiteBuilderMkDocs, nil
+	case anyFileExists(repoDirectory, "hugo.toml", "hugo.yaml", "hugo.json"),
+		anyFileExists(repoDirectory, "config.toml") && anyFileExists(repoDirectory, "content", "layouts", "themes"):
+		return staticSiteBuilderHugo, nil
+	case anyFileExists(repoDirectory, "Gemfile") && anyFileExists(repoDirectory, "_config.yml", "_config.yaml"):
+		return staticSiteBuilderJekyll, nil
+	case hasPackageJson && anyFileExists(repoDirectory, "bun.lockb", "bun.lock"):
+		return staticSiteBuilderBun, nil
+	case hasPackageJson:
+		return staticSiteBuilderNode, nil
+	case anyFileExists(repoDirectory, "index.html"):
+		return staticSiteBuilderHtml, nil
+	}
+	return "", fmt.Errorf("couldn't detect how to build the site: the root directory has no package.json, hugo.toml, " +
+		"Gemfile with _config.yml, mkdocs.yml or index.html; choose a builder in the settings")
+}
+
+func getStaticSiteBuilderProfile(builder, repoDirectory, buildCommand string, isExistingSite bool) (staticSiteBuilderProfile, error) {
+	if len(builder) == 0 || builder == staticSiteBuilderAuto {
+		var err error
+		builder, err = detectStaticSiteBuilder(repoDirectory, buildCommand, isExistingSite)
+		if err != nil {
+			return staticSiteBuilderProfile{}, err
+		}
+	}
+	profile, ok := staticSiteBuilderProfiles[builder]
+	if !ok {
+		return staticSiteBuilderProfile{}, fmt.Errorf("unsupported static site builder %s", builder)
+	}
+	return profile, nil
+}
+
+// getBuildCommand is buildCommand, or the profile's default when it's empty.
+// It runs after the install command.
+func (p staticSiteBuilderProfile) getBuildCommand(buildCommand string) (string, error) {
+	if len(buildCommand) == 0 {
+		buildCommand = p.DefaultBuildCommand
+	}
+	if len(buildCommand) == 0 {
+		return "", fmt.Errorf("a build command is required for %s sites", p.Name)
+	}
+	return buildCommand, nil
+}
+
+// isRootPublishDirectory is true for a PublishDirectory naming the
+// repository's root directory, like "." or "./".
+func isRootPublishDirectory(publishDirectory string) bool {
+	return path.Clean("/"+publishDirectory) == "/"
+}
+
+// getImageReference is the registry reference of the profile's image, an
+// official image or one published by a Docker Hub user.
+func getImageReference(imageID string) string {
+	if strings.Contains(imageID, "/") {
+		return fmt.Sprintf("docker.io/%s", imageID)
+	}
+	return fmt.Sprintf("docker.io/library/%s", imageID)
+}
+
+// stageHtmlSite copies a plain HTML site into the staging directory,
+// leaving out dot files like .git and .env, and symlinks, which could point
+// outside the repository.
+func stageHtmlSite(repoDirectory string) error {
+	stagingDirectory := filepath.Join(repoDirectory, htmlStagingDirectory)
+	if err := os.RemoveAll(stagingDirectory); err != nil {
+		return err
+	}
+	return filepath.WalkDir(repoDirectory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == repoDirectory {
+			return nil
+		}
+		name := d.Name()
+		if strings.HasPrefix(name, ".") || path == stagingDirectory || name == "node_modules" {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		relativePath, err := filepath.Rel(repoDirectory, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(stagingDirectory, relativePath)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		return copyFile(path, target)
+	})
+}
+
+func copyFile(source, target string) error {
+	sourceFile, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+	if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	targetFile, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(targetFile, sourceFile); err != nil {
+		targetFile.Close()
+		return err
+	}
+	return targetFile.Close()
+}
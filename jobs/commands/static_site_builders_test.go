@@ -0,0 +1,183 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFiles(t *testing.T, directory string, names ...string) {
+	for _, name := range names {
+		path := filepath.Join(directory, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDetectStaticSiteBuilder(t *testing.T) {
+	cases := map[string][]string{
+		staticSiteBuilderMkDocs: {"mkdocs.yml", "requirements.txt"},
+		staticSiteBuilderHugo:   {"hugo.toml", "package.json"},
+		staticSiteBuilderJekyll: {"Gemfile", "_config.yml", "index.html"},
+		staticSiteBuilderBun:    {"package.json", "bun.lockb"},
+		staticSiteBuilderNode:   {"package.json", "package-lock.json", "config.toml"},
+		staticSiteBuilderHtml:   {"index.html", "css/site.css"},
+	}
+	for want, files := range cases {
+		directory := t.TempDir()
+		writeTestFiles(t, directory, files...)
+		if builder, err := detectStaticSiteBuilder(directory, "", false); err != nil || builder != want {
+			t.Errorf("%v: builder = %s, %v, want %s", files, builder, err, want)
+		}
+	}
+	//config.toml is only hugo's with a hugo site's directories
+	directory := t.TempDir()
+	writeTestFiles(t, directory, "config.toml", "content/_index.md")
+	if builder, err := detectStaticSiteBuilder(directory, "", false); err != nil || builder != staticSiteBuilderHugo {
+		t.Errorf("builder = %s, %v, want hugo", builder, err)
+	}
+	//an existing site only moves to bun when its build command runs it
+	directory = t.TempDir()
+	writeTestFiles(t, directory, "package.json", "package-lock.json", "bun.lock")
+	if builder, err := detectStaticSiteBuilder(directory, "npm run build", true); err != nil || builder != staticSiteBuilderNode {
+		t.Errorf("builder = %s, %v, want node", builder, err)
+	}
+	if builder, err := detectStaticSiteBuilder(directory, "bun run build && cp _redirects dist", true); err != nil || builder != staticSiteBuilderBun {
+		t.Errorf("builder = %s, %v, want bun", builder, err)
+	}
+	//an existing site with a package.json stays on node for every generator
+	for _, files := range [][]string{{"mkdocs.yml"}, {"hugo.toml"}, {"Gemfile", "_config.yml"}} {
+		directory = t.TempDir()
+		writeTestFiles(t, directory, append(files, "package.json", "bun.lockb")...)
+		if builder, err := detectStaticSiteBuilder(directory, "npm run build", true); err != nil || builder != staticSiteBuilderNode {
+			t.Errorf("%v: builder = %s, %v, want node", files, builder, err)
+		}
+	}
+	//without a package.json an existing site is detected like a new one
+	directory = t.TempDir()
+	writeTestFiles(t, directory, "hugo.toml")
+	if builder, err := detectStaticSiteBuilder(directory, "", true); err != nil || builder != staticSiteBuilderHugo {
+		t.Errorf("builder = %s, %v, want hugo", builder, err)
+	}
+	if _, err := detectStaticSiteBuilder(t.TempDir(), "", false); err == nil {
+		t.Error("expected an error for an empty repository")
+	}
+}
+
+func TestGetStaticSiteBuilderProfile(t *testing.T) {
+	directory := t.TempDir()
+	writeTestFiles(t, directory, "package.json")
+	profile, err := getStaticSiteBuilderProfile("", directory, "", false)
+	if err != nil || profile.Name != staticSiteBuilderNode {
+		t.Errorf("profile = %s, %v, want node", profile.Name, err)
+	}
+	//an explicit choice wins over detection
+	profile, err = getStaticSiteBuilderProfile(staticSiteBuilderJekyll, directory, "", false)
+	if err != nil || profile.Name != staticSiteBuilderJekyll {
+		t.Errorf("profile = %s, %v, want jekyll", profile.Name, err)
+	}
+	if _, err = getStaticSiteBuilderProfile("gatsby", directory, "", false); err == nil {
+		t.Error("expected an error for an unsupported builder")
+	}
+	for name, profile := range staticSiteBuilderProfiles {
+		if name != profile.Name {
+			t.Errorf("%s: name = %s", name, profile.Name)
+		}
+		if len(profile.Image) > 0 && (len(profile.Shell) == 0 || len(profile.InstallCommand) == 0) {
+			t.Errorf("%s: a build needs a shell and an install command", name)
+		}
+		if _, tag, _ := strings.Cut(profile.Image, ":"); len(profile.Image) > 0 && !strings.ContainsAny(tag, "0123456789") {
+			t.Errorf("%s: image %s isn't pinned to a version", name, profile.Image)
+		}
+		if len(profile.PackageManager) > 0 && (len(profile.Lockfiles) == 0 || len(profile.CacheEnv) == 0) {
+			t.Errorf("%s: a dependency cache needs lockfiles and the cache environment", name)
+		}
+	}
+}
+
+func TestGetBuildCommand(t *testing.T) {
+	node := staticSiteBuilderProfiles[staticSiteBuilderNode]
+	if _, err := node.getBuildCommand(""); err == nil {
+		t.Error("expected an error without a build command")
+	}
+	if command, err := node.getBuildCommand("npm run build"); err != nil || command != "npm run build" {
+		t.Errorf("command = %s, %v", command, err)
+	}
+	hugo := staticSiteBuilderProfiles[staticSiteBuilderHugo]
+	if command, err := hugo.getBuildCommand(""); err != nil || command != "hugo --minify" {
+		t.Errorf("command = %s, %v", command, err)
+	}
+}
+
+func TestUsesBun(t *testing.T) {
+	cases := map[string]bool{
+		"bun run build":             true,
+		"npm ci && bunx vite build": true,
+		"(cd site; bun run build)":  true,
+		"npm run build":             false,
+		"npm run bundle":            false,
+		"yarn build --outDir=bun":   false,
+		"":                          false,
+	}
+	for buildCommand, want := range cases {
+		if got := usesBun(buildCommand); got != want {
+			t.Errorf("%q: usesBun = %v, want %v", buildCommand, got, want)
+		}
+	}
+}
+
+func TestIsRootPublishDirectory(t *testing.T) {
+	cases := map[string]bool{
+		"":       true,
+		".":      true,
+		"./":     true,
+		"/":      true,
+		"public": false,
+		"./dist": false,
+	}
+	for publishDirectory, want := range cases {
+		if got := isRootPublishDirectory(publishDirectory); got != want {
+			t.Errorf("%q: isRootPublishDirectory = %v, want %v", publishDirectory, got, want)
+		}
+	}
+}
+
+func TestGetImageReference(t *testing.T) {
+	if reference := getImageReference("node:22.11.0-bookworm"); reference != "docker.io/library/node:22.11.0-bookworm" {
+		t.Errorf("reference = %s", reference)
+	}
+	if reference := getImageReference("hugomods/hugo:exts-0.139.0"); reference != "docker.io/hugomods/hugo:exts-0.139.0" {
+		t.Errorf("reference = %s", reference)
+	}
+}
+
+func TestStageHtmlSite(t *testing.T) {
+	directory := t.TempDir()
+	writeTestFiles(t, directory, "index.html", "css/site.css", ".env", ".git/config", "node_modules/a/index.js",
+		htmlStagingDirectory+"/stale.html")
+	if err := os.Symlink("/etc/hostname", filepath.Join(directory, "hostname")); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageHtmlSite(directory); err != nil {
+		t.Fatal(err)
+	}
+	var staged []string
+	err := filepath.WalkDir(filepath.Join(directory, htmlStagingDirectory), func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			relativePath, _ := filepath.Rel(filepath.Join(directory, htmlStagingDirectory), path)
+			staged = append(staged, relativePath)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(staged) != 2 || staged[0] != "css/site.css" || staged[1] != "index.html" {
+		t.Errorf("staged = %v, want css/site.css and index.html", staged)
+	}
+}
@@ -0,0 +1,408 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	"github.com/deployment-io/deployment-runner/utils/aws_utils"
+)
+
+// Every static site build is uploaded once under its own builds/<buildID>/
+// prefix and never modified. The distribution's S3 origin path points at the
+// live build, so a deploy or a rollback is a single origin path switch. What
+// belongs to a build but isn't served, like its rule files, the encodings its
+// files were precompressed with and the marker written once its upload
+// completed, is kept under build-metadata/<buildID>/.
+const (
+	staticSiteBuildsPrefix        = "builds/"
+	staticSiteBuildMetadataPrefix = "build-metadata/"
+	staticSiteBuildCompleteName   = "complete"
+	staticSitePrecompressName     = "precompress"
+	defaultStaticSiteBuildsToKeep = 5
+	//CloudFront bills paths past the first 1000 a month and a wildcard
+	//counts as one, so past this many paths everything is invalidated
+	maxStaticSiteInvalidationPaths = 100
+)
+
+type staticSiteBuild struct {
+	ID         string
+	UploadedAt time.Time
+}
+
+func getStaticSiteBuildPrefix(buildID string) string {
+	return staticSiteBuildsPrefix + buildID + "/"
+}
+
+func getStaticSiteBuildMetadataPrefix(buildID string) string {
+	return staticSiteBuildMetadataPrefix + buildID + "/"
+}
+
+func getStaticSiteOriginPath(buildID string) string {
+	return "/" + strings.TrimSuffix(getStaticSiteBuildPrefix(buildID), "/")
+}
+
+// getStaticSiteBuildIDFromOriginPath returns an empty build id for sites
+// still served from the bucket root.
+func getStaticSiteBuildIDFromOriginPath(originPath string) string {
+	buildID := strings.TrimPrefix(originPath, "/"+staticSiteBuildsPrefix)
+	if buildID == originPath || strings.Contains(buildID, "/") {
+		return ""
+	}
+	return buildID
+}
+
+// getStaticSiteInvalidationPaths maps changed object keys to the paths
+// viewers request them by. A directory's index.html is also served as the
+// directory itself.
+func getStaticSiteInvalidationPaths(changedKeys []string) []string {
+	pathSet := make(map[string]bool)
+	for _, key := range changedKeys {
+		segments := strings.Split(key, "/")
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+		path := "/" + strings.Join(segments, "/")
+		pathSet[path] = true
+		if segments[len(segments)-1] == "index.html" {
+			directoryPath := strings.TrimSuffix(path, "index.html")
+			pathSet[directoryPath] = true
+			if directoryPath != "/" {
+				pathSet[strings.TrimSuffix(directoryPath, "/")] = true
+			}
+		}
+	}
+	if len(pathSet) > maxStaticSiteInvalidationPaths {
+		return []string{"/*"}
+	}
+	var paths []string
+	for path := range pathSet {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// getStaticSiteBuildID is the build id for a deployment. A preview redeploys
+// under the same id, so it gets a timestamp to keep its prefixes immutable.
+func getStaticSiteBuildID(parameters map[string]interface{}) (string, error) {
+	buildID, err := jobs.GetParameterValue[string](parameters, parameters_enums.BuildID)
+	if err != nil {
+		return "", err
+	}
+	if isPreview(parameters) {
+		return fmt.Sprintf("%s-%d", buildID, time.Now().Unix()), nil
+	}
+	return buildID, nil
+}
+
+func getStaticSiteBuildsToKeep(parameters map[string]interface{}) int {
+	buildsToKeep, err := jobs.GetParameterValue[int64](parameters, parameters_enums.StaticSiteBuildsToKeep)
+	if err != nil || buildsToKeep < 1 {
+		return defaultStaticSiteBuildsToKeep
+	}
+	return int(buildsToKeep)
+}
+
+// getStaticSiteBuildsToPrune keeps the newest buildsToKeep builds and the live
+// one, which can be older after a rollback.
+func getStaticSiteBuildsToPrune(builds []staticSiteBuild, liveBuildID string, buildsToKeep int) []string {
+	sorted := make([]staticSiteBuild, len(builds))
+	copy(sorted, builds)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].UploadedAt.After(sorted[j].UploadedAt)
+	})
+	var prune []string
+	for i, build := range sorted {
+		if i < buildsToKeep || build.ID == liveBuildID {
+			continue
+		}
+		prune = append(prune, build.ID)
+	}
+	return prune
+}
+
+// getPreviousStaticSiteBuild is the newest complete build uploaded before the
+// live one.
+func getPreviousStaticSiteBuild(builds []staticSiteBuild, liveBuildID string) (string, error) {
+	var live *staticSiteBuild
+	for i := range builds {
+		if builds[i].ID == liveBuildID {
+			live = &builds[i]
+		}
+	}
+	if live == nil {
+		return "", fmt.Errorf("live build %s is not retained in the bucket", liveBuildID)
+	}
+	var previous *staticSiteBuild
+	for i := range builds {
+		build := &builds[i]
+		if build.ID == liveBuildID || build.UploadedAt.IsZero() || !build.UploadedAt.Before(live.UploadedAt) {
+			continue
+		}
+		if previous == nil || build.UploadedAt.After(previous.UploadedAt) {
+			previous = build
+		}
+	}
+	if previous == nil {
+		return "", fmt.Errorf("no build older than %s to roll back to", liveBuildID)
+	}
+	return previous.ID, nil
+}
+
+// markStaticSiteBuildComplete writes the build's completion marker, after
+// every file of the build was uploaded.
+func markStaticSiteBuildComplete(s3Client *s3.Client, bucketName, buildID string) error {
+	_, err := s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(getStaticSiteBuildMetadataPrefix(buildID) + staticSiteBuildCompleteName),
+		Body:        strings.NewReader(buildID),
+		ContentType: aws.String("text/plain"),
+	})
+	return err
+}
+
+// uploadStaticSiteRuleFiles keeps the build's _redirects and _headers with
+// its metadata.
+func uploadStaticSiteRuleFiles(s3Client *s3.Client, bucketName, buildID, distDirectory string) error {
+	for _, name := range []string{staticSiteRedirectsFile, staticSiteHeadersFile} {
+		content, err := os.ReadFile(filepath.Join(distDirectory, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
+			Bucket:      aws.String(bucketName),
+			Key:         aws.String(getStaticSiteBuildMetadataPrefix(buildID) + name),
+			Body:        bytes.NewReader(content),
+			ContentType: aws.String("text/plain"),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uploadStaticSitePrecompressedEncodings records the encodings the build's
+// files were precompressed with, one per line, so the viewer-request function
+// of a rollback matches the files of the build rolled back to.
+func uploadStaticSitePrecompressedEncodings(s3Client *s3.Client, bucketName, buildID string, encodings []string) error {
+	_, err := s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(getStaticSiteBuildMetadataPrefix(buildID) + staticSitePrecompressName),
+		Body:        strings.NewReader(strings.Join(encodings, "\n")),
+		ContentType: aws.String("text/plain"),
+	})
+	return err
+}
+
+// getStaticSiteBuildPrecompressedEncodings returns false for builds uploaded
+// before the encodings were recorded.
+func getStaticSiteBuildPrecompressedEncodings(s3Client *s3.Client, bucketName, buildID string) ([]string, bool, error) {
+	getObjectOutput, err := s3Client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(getStaticSiteBuildMetadataPrefix(buildID) + staticSitePrecompressName),
+	})
+	if err != nil {
+		var noSuchKey *s3Types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	defer getObjectOutput.Body.Close()
+	content, err := io.ReadAll(getObjectOutput.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	return strings.Fields(string(content)), true, nil
+}
+
+// getStaticSiteBuildRuleFile returns an empty string when the build has no
+// such rule file.
+func getStaticSiteBuildRuleFile(s3Client *s3.Client, bucketName, buildID, name string) (string, error) {
+	getObjectOutput, err := s3Client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(getStaticSiteBuildMetadataPrefix(buildID) + name),
+	})
+	if err != nil {
+		var noSuchKey *s3Types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer getObjectOutput.Body.Close()
+	content, err := io.ReadAll(getObjectOutput.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// getStaticSiteBuildUploadedAt is the time the build's completion marker was
+// written. A build without one never finished uploading.
+func getStaticSiteBuildUploadedAt(s3Client *s3.Client, bucketName, buildID string) (time.Time, bool, error) {
+	headObjectOutput, err := s3Client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(getStaticSiteBuildMetadataPrefix(buildID) + staticSiteBuildCompleteName),
+	})
+	if err != nil {
+		var notFound *s3Types.NotFound
+		if errors.As(err, &notFound) {
+			return time.Time{}, false, nil
+		}
+		return time.Time{}, false, err
+	}
+	return aws.ToTime(headObjectOutput.LastModified), true, nil
+}
+
+func listStaticSiteBuilds(s3Client *s3.Client, bucketName string) ([]staticSiteBuild, error) {
+	listObjectsPaginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucketName),
+		Prefix:    aws.String(staticSiteBuildsPrefix),
+		Delimiter: aws.String("/"),
+	})
+	var builds []staticSiteBuild
+	for listObjectsPaginator.HasMorePages() {
+		page, err := listObjectsPaginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		for _, commonPrefix := range page.CommonPrefixes {
+			buildID := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(commonPrefix.Prefix), staticSiteBuildsPrefix), "/")
+			//incomplete uploads have a zero time and are pruned first
+			uploadedAt, _, err := getStaticSiteBuildUploadedAt(s3Client, bucketName, buildID)
+			if err != nil {
+				return nil, err
+			}
+			builds = append(builds, staticSiteBuild{ID: buildID, UploadedAt: uploadedAt})
+		}
+	}
+	return builds, nil
+}
+
+// deleteStaticSiteRootFiles deletes what was uploaded to the bucket root
+// before the site was served from builds/.
+func deleteStaticSiteRootFiles(s3Client *s3.Client, bucketName string, logsWriter io.Writer) error {
+	listObjectsPaginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucketName),
+		Delimiter: aws.String("/"),
+	})
+	var prefixes []string
+	for listObjectsPaginator.HasMorePages() {
+		page, err := listObjectsPaginator.NextPage(context.TODO())
+		if err != nil {
+			return err
+		}
+		for _, object := range page.Contents {
+			prefixes = append(prefixes, aws.ToString(object.Key))
+		}
+		for _, commonPrefix := range page.CommonPrefixes {
+			if prefix := aws.ToString(commonPrefix.Prefix); prefix != staticSiteBuildsPrefix && prefix != staticSiteBuildMetadataPrefix {
+				prefixes = append(prefixes, prefix)
+			}
+		}
+	}
+	if len(prefixes) == 0 {
+		return nil
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Deleting files uploaded outside %s in S3 bucket: %s\n", staticSiteBuildsPrefix, bucketName))
+	for _, prefix := range prefixes {
+		err := aws_utils.DeleteS3FilesWithPrefix(s3Client, bucketName, prefix)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func pruneStaticSiteBuilds(s3Client *s3.Client, bucketName, liveBuildID string, buildsToKeep int, logsWriter io.Writer) error {
+	builds, err := listStaticSiteBuilds(s3Client, bucketName)
+	if err != nil {
+		return err
+	}
+	for _, buildID := range getStaticSiteBuildsToPrune(builds, liveBuildID, buildsToKeep) {
+		io.WriteString(logsWriter, fmt.Sprintf("Deleting old build: %s from S3 bucket: %s\n", buildID, bucketName))
+		//the marker goes first so a build that is only partly deleted is incomplete
+		err = aws_utils.DeleteS3FilesWithPrefix(s3Client, bucketName, getStaticSiteBuildMetadataPrefix(buildID))
+		if err != nil {
+			return err
+		}
+		err = aws_utils.DeleteS3FilesWithPrefix(s3Client, bucketName, getStaticSiteBuildPrefix(buildID))
+		if err != nil {
+			return err
+		}
+	}
+	return deleteStaticSiteRootFiles(s3Client, bucketName, logsWriter)
+}
+
+// getLiveStaticSiteBuildID reads the build the distribution's S3 origin
+// points at.
+func getLiveStaticSiteBuildID(cloudfrontClient *cloudfront.Client, cloudfrontID string) (string, error) {
+	distributionConfigOutput, err := cloudfrontClient.GetDistributionConfig(context.TODO(), &cloudfront.GetDistributionConfigInput{
+		Id: aws.String(cloudfrontID),
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, origin := range distributionConfigOutput.DistributionConfig.Origins.Items {
+		if origin.S3OriginConfig != nil {
+			return getStaticSiteBuildIDFromOriginPath(aws.ToString(origin.OriginPath)), nil
+		}
+	}
+	return "", fmt.Errorf("cloudfront distribution %s has no S3 origin", cloudfrontID)
+}
+
+// switchStaticSiteBuild points the distribution's S3 origin at a build and
+// waits for the change to reach every edge location.
+func switchStaticSiteBuild(cloudfrontClient *cloudfront.Client, cloudfrontID, buildID string, logsWriter io.Writer) error {
+	//get fresh distribution config right before update to avoid stale ETag (412 PreconditionFailed)
+	distributionConfigOutput, err := cloudfrontClient.GetDistributionConfig(context.TODO(), &cloudfront.GetDistributionConfigInput{
+		Id: aws.String(cloudfrontID),
+	})
+	if err != nil {
+		return err
+	}
+	distributionConfig := distributionConfigOutput.DistributionConfig
+	originPath := getStaticSiteOriginPath(buildID)
+	updated := false
+	for i, origin := range distributionConfig.Origins.Items {
+		if origin.S3OriginConfig != nil && aws.ToString(origin.OriginPath) != originPath {
+			distributionConfig.Origins.Items[i].OriginPath = aws.String(originPath)
+			updated = true
+		}
+	}
+	if !updated {
+		return nil
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Switching cloudfront distribution %s to build: %s\n", cloudfrontID, buildID))
+	_, err = cloudfrontClient.UpdateDistribution(context.TODO(), &cloudfront.UpdateDistributionInput{
+		DistributionConfig: distributionConfig,
+		Id:                 aws.String(cloudfrontID),
+		IfMatch:            distributionConfigOutput.ETag,
+	})
+	if err != nil {
+		return err
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Waiting for cloudfront distribution to be deployed: %s\n", cloudfrontID))
+	distributionDeployedWaiter := cloudfront.NewDistributionDeployedWaiter(cloudfrontClient)
+	return distributionDeployedWaiter.Wait(context.TODO(), &cloudfront.GetDistributionInput{Id: aws.String(cloudfrontID)}, 20*time.Minute)
+}
@@ -0,0 +1,91 @@
+package commands
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStaticSiteOriginPath(t *testing.T) {
+	originPath := getStaticSiteOriginPath("b1")
+	if originPath != "/builds/b1" {
+		t.Errorf("originPath = %s", originPath)
+	}
+	if buildID := getStaticSiteBuildIDFromOriginPath(originPath); buildID != "b1" {
+		t.Errorf("buildID = %s, want b1", buildID)
+	}
+	//the completion marker is never served
+	if metadataPrefix := getStaticSiteBuildMetadataPrefix("b1"); strings.HasPrefix("/"+metadataPrefix, originPath) {
+		t.Errorf("metadata prefix %s is under the origin path %s", metadataPrefix, originPath)
+	}
+	for _, legacy := range []string{"", "/", "/site", "/builds/b1/nested"} {
+		if buildID := getStaticSiteBuildIDFromOriginPath(legacy); buildID != "" {
+			t.Errorf("%q: buildID = %s, want none", legacy, buildID)
+		}
+	}
+}
+
+func testStaticSiteBuilds() []staticSiteBuild {
+	now := time.Now()
+	return []staticSiteBuild{
+		{ID: "b2", UploadedAt: now.Add(-3 * time.Hour)},
+		{ID: "b4", UploadedAt: now.Add(-1 * time.Hour)},
+		{ID: "b1", UploadedAt: now.Add(-4 * time.Hour)},
+		{ID: "b3", UploadedAt: now.Add(-2 * time.Hour)},
+		{ID: "partial"},
+	}
+}
+
+func TestGetStaticSiteBuildsToPrune(t *testing.T) {
+	builds := testStaticSiteBuilds()
+	prune := getStaticSiteBuildsToPrune(builds, "b4", 2)
+	if want := []string{"b2", "b1", "partial"}; !reflect.DeepEqual(prune, want) {
+		t.Errorf("prune = %v, want %v", prune, want)
+	}
+	//a rolled back site keeps its live build
+	prune = getStaticSiteBuildsToPrune(builds, "b1", 2)
+	if want := []string{"b2", "partial"}; !reflect.DeepEqual(prune, want) {
+		t.Errorf("prune = %v, want %v", prune, want)
+	}
+	if prune = getStaticSiteBuildsToPrune(builds, "b4", 5); len(prune) != 0 {
+		t.Errorf("prune = %v, want none", prune)
+	}
+}
+
+func TestGetPreviousStaticSiteBuild(t *testing.T) {
+	builds := testStaticSiteBuilds()
+	buildID, err := getPreviousStaticSiteBuild(builds, "b4")
+	if err != nil || buildID != "b3" {
+		t.Errorf("buildID = %s, %v, want b3", buildID, err)
+	}
+	buildID, err = getPreviousStaticSiteBuild(builds, "b2")
+	if err != nil || buildID != "b1" {
+		t.Errorf("buildID = %s, %v, want b1", buildID, err)
+	}
+	if _, err = getPreviousStaticSiteBuild(builds, "b1"); err == nil {
+		t.Error("expected an error without an older complete build")
+	}
+	if _, err = getPreviousStaticSiteBuild(builds, "pruned"); err == nil {
+		t.Error("expected an error for a live build missing from the bucket")
+	}
+}
+
+func TestGetStaticSiteInvalidationPaths(t *testing.T) {
+	paths := getStaticSiteInvalidationPaths([]string{"index.html", "docs/index.html", "assets/my logo.png"})
+	want := []string{"/", "/assets/my%20logo.png", "/docs", "/docs/", "/docs/index.html", "/index.html"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("paths = %v, want %v", paths, want)
+	}
+	if paths = getStaticSiteInvalidationPaths(nil); len(paths) != 0 {
+		t.Errorf("paths = %v, want none", paths)
+	}
+	var changedKeys []string
+	for i := 0; i <= maxStaticSiteInvalidationPaths; i++ {
+		changedKeys = append(changedKeys, fmt.Sprintf("page-%d.html", i))
+	}
+	if paths = getStaticSiteInvalidationPaths(changedKeys); !reflect.DeepEqual(paths, []string{"/*"}) {
+		t.Errorf("paths = %v, want /*", paths)
+	}
+}
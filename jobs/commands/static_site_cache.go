@@ -0,0 +1,223 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+	awsS3Uploads "github.com/deployment-io/deployment-runner/utils/uploads/aws-s3"
+)
+
+const (
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	htmlCacheControl      = "no-cache"
+)
+
+// hashedAssetRegexp matches file names a bundler fingerprinted with a
+// content hash: 8 characters of base32 or base64url, like index-BxYz12Ab.css
+// or app-5ZQ3XJ7K.js, or 8 to 32 hex digits, like main.3f9a1c2b.js.
+var hashedAssetRegexp = regexp.MustCompile(`[.-]([A-Za-z0-9_-]{8}|[0-9a-f]{16}|[0-9a-f]{20}|[0-9a-f]{32})\.[A-Za-z0-9]+$`)
+
+// staticSiteCacheSettings is the optional StaticSiteCacheSettings parameter.
+// Cache-Control rules are tried in order before the defaults: hashed assets
+// are immutable, HTML is revalidated and everything else gets no header.
+type staticSiteCacheSettings struct {
+	CacheControl []staticSiteCacheControlRule `json:"cache_control"`
+	Precompress  []string                     `json:"precompress"` // "br" and/or "gzip"
+}
+
+type staticSiteCacheControlRule struct {
+	Pattern string `json:"pattern"` // glob, without a slash it matches the file name in any directory
+	Value   string `json:"value"`   // empty for no Cache-Control header
+	pattern *regexp.Regexp
+}
+
+func getStaticSiteCacheSettings(parameters map[string]interface{}) (*staticSiteCacheSettings, error) {
+	settingsJSON, err := jobs.GetParameterValue[string](parameters, parameters_enums.StaticSiteCacheSettings)
+	if err != nil || len(settingsJSON) == 0 {
+		return parseStaticSiteCacheSettings(nil)
+	}
+	return parseStaticSiteCacheSettings([]byte(settingsJSON))
+}
+
+func parseStaticSiteCacheSettings(settingsBytes []byte) (*staticSiteCacheSettings, error) {
+	settings := &staticSiteCacheSettings{}
+	if len(settingsBytes) > 0 {
+		if err := json.Unmarshal(settingsBytes, settings); err != nil {
+			return nil, fmt.Errorf("error unmarshalling static site cache settings: %s", err)
+		}
+	}
+	for i := range settings.CacheControl {
+		rule := &settings.CacheControl[i]
+		pattern, err := compileStaticSiteGlob(rule.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		rule.pattern = pattern
+		if strings.ContainsAny(rule.Value, "\r\n") {
+			return nil, fmt.Errorf("invalid Cache-Control value for %s", rule.Pattern)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, encoding := range settings.Precompress {
+		if _, err := awsS3Uploads.GetPrecompressedKeySuffix(encoding); err != nil {
+			return nil, fmt.Errorf("unsupported precompression encoding %s, use br or gzip", encoding)
+		}
+		if seen[encoding] {
+			return nil, fmt.Errorf("precompression encoding %s is listed twice", encoding)
+		}
+		seen[encoding] = true
+	}
+	return settings, nil
+}
+
+// compileStaticSiteGlob supports * and ? within a path segment and ** across
+// segments.
+func compileStaticSiteGlob(glob string) (*regexp.Regexp, error) {
+	glob = strings.TrimPrefix(glob, "/")
+	if len(glob) == 0 {
+		return nil, fmt.Errorf("empty Cache-Control pattern")
+	}
+	if !strings.Contains(glob, "/") {
+		glob = "**/" + glob
+	}
+	var expression strings.Builder
+	expression.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			expression.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			expression.WriteString(".*")
+			i++
+		case c == '*':
+			expression.WriteString("[^/]*")
+		case c == '?':
+			expression.WriteString("[^/]")
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expression.WriteString("$")
+	return regexp.Compile(expression.String())
+}
+
+func isHtmlFile(key string) bool {
+	extension := strings.ToLower(path.Ext(key))
+	return extension == ".html" || extension == ".htm"
+}
+
+// isHashedAsset needs both digits and letters in the hash, so words and
+// dates like logo-20240101.png aren't taken for one.
+func isHashedAsset(key string) bool {
+	match := hashedAssetRegexp.FindStringSubmatch(path.Base(key))
+	return match != nil && strings.ContainsAny(match[1], "0123456789") &&
+		strings.ContainsAny(strings.ToLower(match[1]), "abcdefghijklmnopqrstuvwxyz")
+}
+
+// getCacheControl is the Cache-Control header for a key relative to the
+// build prefix.
+func (s *staticSiteCacheSettings) getCacheControl(key string) string {
+	for _, rule := range s.CacheControl {
+		if rule.pattern.MatchString(key) {
+			return rule.Value
+		}
+	}
+	if isHtmlFile(key) {
+		return htmlCacheControl
+	}
+	if isHashedAsset(key) {
+		return immutableCacheControl
+	}
+	return ""
+}
+
+func (s *staticSiteCacheSettings) getSyncOptions() awsS3Uploads.SyncOptions {
+	return awsS3Uploads.SyncOptions{
+		CacheControl: s.getCacheControl,
+		Precompress:  s.Precompress,
+		Exclude:      isStaticSiteRuleFile,
+	}
+}
+
+// getStaticSitePrecompressedEncodings returns the encodings the served files
+// were precompressed with: the settings for the job's build, or what the
+// live build recorded when the job has no build, e.g. on rollback. Builds
+// from before the encodings were recorded fall back to the settings.
+func getStaticSitePrecompressedEncodings(parameters map[string]interface{}) ([]string, error) {
+	cacheSettings, err := getStaticSiteCacheSettings(parameters)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = getDistDirectory(parameters); err == nil {
+		return cacheSettings.Precompress, nil
+	}
+	s3Client, bucketName, liveBuildID, err := getLiveStaticSiteBuild(parameters)
+	if err != nil {
+		return nil, err
+	}
+	if len(liveBuildID) == 0 {
+		return cacheSettings.Precompress, nil
+	}
+	encodings, found, err := getStaticSiteBuildPrecompressedEncodings(s3Client, bucketName, liveBuildID)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return cacheSettings.Precompress, nil
+	}
+	return encodings, nil
+}
+
+// getPrecompressedVariantStatement is the viewer-request code serving a
+// precompressed variant to viewers that accept its encoding. Brotli is
+// preferred as it's smaller.
+func getPrecompressedVariantStatement(encodings []string) (string, error) {
+	if len(encodings) == 0 {
+		return "", nil
+	}
+	var conditions []string
+	for _, encoding := range []string{awsS3Uploads.EncodingBrotli, awsS3Uploads.EncodingGzip} {
+		for _, enabled := range encodings {
+			if enabled != encoding {
+				continue
+			}
+			suffix, err := awsS3Uploads.GetPrecompressedKeySuffix(encoding)
+			if err != nil {
+				return "", err
+			}
+			conditions = append(conditions, fmt.Sprintf(`if (acceptEncoding.indexOf('%s') !== -1) {
+            request.uri += '%s';
+        }`, encoding, suffix))
+		}
+	}
+	return fmt.Sprintf(`var acceptEncoding = request.headers['accept-encoding'] ? request.headers['accept-encoding'].value : '';
+    if (/%s/i.test(request.uri)) {
+        %s
+    }`, getPrecompressibleExpression(), strings.Join(conditions, " else ")), nil
+}
+
+// getPrecompressibleExpression matches the paths of files that get
+// precompressed variants.
+func getPrecompressibleExpression() string {
+	var extensions []string
+	for _, extension := range awsS3Uploads.PrecompressibleExtensions {
+		extensions = append(extensions, regexp.QuoteMeta(strings.TrimPrefix(extension, ".")))
+	}
+	return fmt.Sprintf(`\.(%s)$`, strings.Join(extensions, "|"))
+}
+
+// getPrecompressedVaryHeaderRow is the ResponseHeaders row telling caches
+// past CloudFront that files with precompressed variants differ by
+// Accept-Encoding, nil when nothing is precompressed.
+func getPrecompressedVaryHeaderRow(encodings []string) []string {
+	if len(encodings) == 0 {
+		return nil
+	}
+	return []string{escapeSingleQuotedJs(getPrecompressibleExpression()), "vary", "Accept-Encoding"}
+}
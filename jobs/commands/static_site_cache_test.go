@@ -0,0 +1,97 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetCacheControl_Defaults(t *testing.T) {
+	settings, err := parseStaticSiteCacheSettings(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		"index.html":                           htmlCacheControl,
+		"docs/guide/index.HTML":                htmlCacheControl,
+		"assets/index-BxYz12Ab.js":             immutableCacheControl,
+		"static/js/main.3f9a1c2b.js":           immutableCacheControl,
+		"_next/static/chunks/a1b2c3d4e5f6.css": "",
+		"js/jquery-3.6.0.min.js":               "",
+		"images/logo.png":                      "",
+		"images/logo-20240101.png":             "",
+		"assets/app-5ZQ3XJ7K.js":               immutableCacheControl,
+		"assets/index-Component.js":            "",
+	}
+	for key, want := range cases {
+		if cacheControl := settings.getCacheControl(key); cacheControl != want {
+			t.Errorf("%s: Cache-Control = %q, want %q", key, cacheControl, want)
+		}
+	}
+}
+
+func TestGetCacheControl_Rules(t *testing.T) {
+	settings, err := parseStaticSiteCacheSettings([]byte(`{"cache_control": [
+		{"pattern": "*.pdf", "value": "public, max-age=3600"},
+		{"pattern": "/fonts/**", "value": "public, max-age=31536000, immutable"},
+		{"pattern": "embed/?.html", "value": ""}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		"report.pdf":              "public, max-age=3600",
+		"docs/2024/report.pdf":    "public, max-age=3600",
+		"fonts/inter/inter.woff2": immutableCacheControl,
+		"embed/a.html":            "",
+		"embed/ab.html":           htmlCacheControl,
+		"other/embed/a.html":      htmlCacheControl,
+	}
+	for key, want := range cases {
+		if cacheControl := settings.getCacheControl(key); cacheControl != want {
+			t.Errorf("%s: Cache-Control = %q, want %q", key, cacheControl, want)
+		}
+	}
+}
+
+func TestParseStaticSiteCacheSettings_Invalid(t *testing.T) {
+	cases := map[string]string{
+		"empty pattern": `{"cache_control": [{"pattern": "", "value": "no-store"}]}`,
+		"header value":  `{"cache_control": [{"pattern": "*.js", "value": "no-store\r\nX-Evil: 1"}]}`,
+		"encoding":      `{"precompress": ["zstd"]}`,
+		"duplicate":     `{"precompress": ["br", "br"]}`,
+	}
+	for name, settingsJSON := range cases {
+		if _, err := parseStaticSiteCacheSettings([]byte(settingsJSON)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestGetPrecompressedVariantStatement(t *testing.T) {
+	statement, err := getPrecompressedVariantStatement(nil)
+	if err != nil || statement != "" {
+		t.Errorf("statement = %q, %v, want none", statement, err)
+	}
+	//brotli is tried first whatever the configured order
+	statement, err = getPrecompressedVariantStatement([]string{"gzip", "br"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	brotli, gzip := strings.Index(statement, "'.br'"), strings.Index(statement, "'.gz'")
+	if brotli == -1 || gzip == -1 || brotli > gzip {
+		t.Errorf("statement = %s", statement)
+	}
+	if !strings.Contains(statement, "|js|") {
+		t.Errorf("statement doesn't match js files: %s", statement)
+	}
+}
+
+func TestGetPrecompressedVaryHeaderRow(t *testing.T) {
+	if row := getPrecompressedVaryHeaderRow(nil); row != nil {
+		t.Errorf("row = %v, want none", row)
+	}
+	row := getPrecompressedVaryHeaderRow([]string{"br"})
+	if len(row) != 3 || row[1] != "vary" || row[2] != "Accept-Encoding" || !strings.Contains(row[0], "|js|") {
+		t.Errorf("row = %v", row)
+	}
+}
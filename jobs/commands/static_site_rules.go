@@ -0,0 +1,406 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/deployment-io/deployment-runner-kit/cloud_api_clients"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+)
+
+// _redirects and _headers follow Netlify's format so sites moving over keep
+// working. They're compiled into the viewer-request and viewer-response
+// functions, which CloudFront limits to 10KB of code each. They aren't
+// served but kept with the build's metadata, so a job without a build, like
+// a settings change or a rollback, compiles the live build's rules.
+const (
+	staticSiteRedirectsFile        = "_redirects"
+	staticSiteHeadersFile          = "_headers"
+	maxCloudfrontFunctionCodeSize  = 10 * 1024
+	staticSiteRuleSplatPlaceholder = "splat"
+)
+
+var redirectStatusDescriptions = map[int]string{
+	301: "Moved Permanently",
+	302: "Found",
+	303: "See Other",
+	307: "Temporary Redirect",
+	308: "Permanent Redirect",
+}
+
+// headers a viewer-response function isn't allowed to set
+var readOnlyViewerResponseHeaders = map[string]bool{
+	"content-encoding":  true,
+	"content-length":    true,
+	"transfer-encoding": true,
+	"warning":           true,
+	"via":               true,
+}
+
+var headerNameRegexp = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+var placeholderRegexp = regexp.MustCompile(`:([A-Za-z_][A-Za-z0-9_]*)`)
+
+type staticSiteRedirect struct {
+	From   string
+	To     string
+	Status int
+	Force  bool
+	Line   int
+}
+
+type staticSiteHeader struct {
+	Path  string
+	Name  string
+	Value string
+	Line  int
+}
+
+func parseStaticSiteRedirects(content string) ([]staticSiteRedirect, error) {
+	var redirects []staticSiteRedirect
+	for i, line := range strings.Split(content, "\n") {
+		lineNumber := i + 1
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s line %d: a rule needs a from and a to path", staticSiteRedirectsFile, lineNumber)
+		}
+		redirect := staticSiteRedirect{From: fields[0], To: fields[1], Status: 301, Line: lineNumber}
+		if strings.Contains(fields[1], "=") && !strings.Contains(fields[1], "/") {
+			return nil, fmt.Errorf("%s line %d: query parameter matching isn't supported", staticSiteRedirectsFile, lineNumber)
+		}
+		if len(fields) > 2 {
+			status := fields[2]
+			if strings.HasSuffix(status, "!") {
+				redirect.Force = true
+				status = strings.TrimSuffix(status, "!")
+			}
+			code, err := strconv.Atoi(status)
+			if err != nil {
+				return nil, fmt.Errorf("%s line %d: invalid status %s", staticSiteRedirectsFile, lineNumber, fields[2])
+			}
+			redirect.Status = code
+		}
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("%s line %d: conditions like %s aren't supported", staticSiteRedirectsFile, lineNumber, fields[3])
+		}
+		if !strings.HasPrefix(redirect.From, "/") {
+			return nil, fmt.Errorf("%s line %d: only paths starting with / can be redirected, use the redirect domain setting for domains",
+				staticSiteRedirectsFile, lineNumber)
+		}
+		_, isRedirect := redirectStatusDescriptions[redirect.Status]
+		switch {
+		case isRedirect:
+		case redirect.Status == 200:
+			if !strings.HasPrefix(redirect.To, "/") {
+				return nil, fmt.Errorf("%s line %d: proxying to another site isn't supported, a 200 rule has to rewrite to a path",
+					staticSiteRedirectsFile, lineNumber)
+			}
+		default:
+			return nil, fmt.Errorf("%s line %d: status %d isn't supported, use 200 to rewrite or a 3xx to redirect",
+				staticSiteRedirectsFile, lineNumber, redirect.Status)
+		}
+		redirects = append(redirects, redirect)
+	}
+	return redirects, nil
+}
+
+func parseStaticSiteHeaders(content string) ([]staticSiteHeader, error) {
+	var headers []staticSiteHeader
+	path := ""
+	for i, line := range strings.Split(content, "\n") {
+		lineNumber := i + 1
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			if !strings.HasPrefix(trimmed, "/") {
+				return nil, fmt.Errorf("%s line %d: a path has to start with /", staticSiteHeadersFile, lineNumber)
+			}
+			path = trimmed
+			continue
+		}
+		if len(path) == 0 {
+			return nil, fmt.Errorf("%s line %d: header before any path", staticSiteHeadersFile, lineNumber)
+		}
+		name, value, found := strings.Cut(trimmed, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !found || !headerNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("%s line %d: expected Name: value", staticSiteHeadersFile, lineNumber)
+		}
+		if readOnlyViewerResponseHeaders[strings.ToLower(name)] {
+			return nil, fmt.Errorf("%s line %d: CloudFront doesn't let functions set %s", staticSiteHeadersFile, lineNumber, name)
+		}
+		headers = append(headers, staticSiteHeader{Path: path, Name: strings.ToLower(name), Value: value, Line: lineNumber})
+	}
+	return headers, nil
+}
+
+// getStaticSitePathRegexp turns a rule path into a regular expression valid
+// in both Go and JavaScript. :name matches a path segment and a trailing *
+// the rest of the path; names are in the order of their groups.
+func getStaticSitePathRegexp(rulePath string) (string, []string, error) {
+	var expression strings.Builder
+	var names []string
+	expression.WriteString("^")
+	segments := strings.Split(strings.TrimSuffix(rulePath, "/"), "/")
+	for i, segment := range segments {
+		if segment == "*" && i == len(segments)-1 {
+			//the splat matches the directory itself as well
+			expression.WriteString("(?:/(.*))?")
+			names = append(names, staticSiteRuleSplatPlaceholder)
+			break
+		}
+		if i > 0 {
+			expression.WriteString("/")
+		}
+		switch {
+		case strings.Contains(segment, "*"):
+			return "", nil, fmt.Errorf("%s: * is only supported as the last path segment", rulePath)
+		case strings.HasPrefix(segment, ":") && len(segment) > 1:
+			expression.WriteString("([^/]+)")
+			names = append(names, segment[1:])
+		default:
+			expression.WriteString(regexp.QuoteMeta(segment))
+		}
+	}
+	if len(names) == 0 || names[len(names)-1] != staticSiteRuleSplatPlaceholder {
+		//a directory is requested with and without its trailing slash
+		expression.WriteString("/?")
+	}
+	expression.WriteString("$")
+	return expression.String(), names, nil
+}
+
+// getStaticSiteRedirectTarget splits the target into literal parts and the
+// numbers of the groups to fill in.
+func getStaticSiteRedirectTarget(to string, names []string) []interface{} {
+	var parts []interface{}
+	last := 0
+	for _, match := range placeholderRegexp.FindAllStringSubmatchIndex(to, -1) {
+		name := to[match[2]:match[3]]
+		group := -1
+		for i, n := range names {
+			if n == name {
+				group = i + 1
+			}
+		}
+		if group == -1 {
+			//not a placeholder of the from path, like the port of a URL
+			continue
+		}
+		if match[0] > last {
+			parts = append(parts, to[last:match[0]])
+		}
+		parts = append(parts, group)
+		last = match[1]
+	}
+	if last < len(to) {
+		parts = append(parts, to[last:])
+	}
+	return parts
+}
+
+// getStaticSiteFilePaths are the paths each file of the build is requested
+// by, a directory's index.html also by the directory.
+func getStaticSiteFilePaths(distDirectory string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(distDirectory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		urlPath := "/" + filepath.ToSlash(strings.TrimPrefix(path, distDirectory+"/"))
+		paths = append(paths, urlPath)
+		if strings.HasSuffix(urlPath, "/index.html") {
+			paths = append(paths, strings.TrimSuffix(urlPath, "index.html"))
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// compileStaticSiteRedirects is the viewer-request code applying the rules
+// in order, the first match wins. Netlify skips a rule that isn't forced
+// with ! when a file exists at the path; the function can't see the
+// bucket, so such a rule must not match any file of the build.
+func compileStaticSiteRedirects(redirects []staticSiteRedirect, filePaths []string) (string, error) {
+	if len(redirects) == 0 {
+		return "", nil
+	}
+	var rules []interface{}
+	for _, redirect := range redirects {
+		expression, names, err := getStaticSitePathRegexp(redirect.From)
+		if err != nil {
+			return "", fmt.Errorf("%s line %d: %s", staticSiteRedirectsFile, redirect.Line, err)
+		}
+		if !redirect.Force {
+			pathRegexp := regexp.MustCompile(expression)
+			for _, filePath := range filePaths {
+				if pathRegexp.MatchString(filePath) {
+					hint := "add ! to the status to always apply it"
+					if redirect.Status == 200 && strings.HasSuffix(redirect.From, "*") {
+						hint = "use the single page app setting for a fallback to index.html"
+					}
+					return "", fmt.Errorf("%s line %d: %s matches the file %s, which Netlify would serve instead; %s",
+						staticSiteRedirectsFile, redirect.Line, redirect.From, filePath, hint)
+				}
+			}
+		}
+		rules = append(rules, []interface{}{expression, getStaticSiteRedirectTarget(redirect.To, names), redirect.Status})
+	}
+	rulesJSON, err := json.Marshal(rules)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`var redirects = %s;
+    var statusDescriptions = {301: 'Moved Permanently', 302: 'Found', 303: 'See Other', 307: 'Temporary Redirect', 308: 'Permanent Redirect'};
+    for (var i = 0; i < redirects.length; i++) {
+        var match = request.uri.match(new RegExp(redirects[i][0]));
+        if (!match) {
+            continue;
+        }
+        var to = redirects[i][1].map(function(part) {
+            return typeof part === 'number' ? (match[part] || '') : part;
+        }).join('');
+        if (redirects[i][2] === 200) {
+            request.uri = to;
+            break;
+        }
+        return {
+            statusCode: redirects[i][2],
+            statusDescription: statusDescriptions[redirects[i][2]],
+            headers: {
+                'location': { value: to + buildQS(request.querystring) }
+            }
+        };
+    }`, rulesJSON), nil
+}
+
+// escapeSingleQuotedJs escapes a string for a '...' JavaScript literal.
+func escapeSingleQuotedJs(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+}
+
+// compileStaticSiteHeaders turns the rules into the path regex, name and
+// value rows of the ResponseHeaders parameter, escaped for the function's
+// string literals. A directory path also matches its index.html, which is
+// what the viewer-request function rewrites it to.
+func compileStaticSiteHeaders(headers []staticSiteHeader) ([][]string, error) {
+	var rows [][]string
+	for _, header := range headers {
+		expression, _, err := getStaticSitePathRegexp(header.Path)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %s", staticSiteHeadersFile, header.Line, err)
+		}
+		if strings.HasSuffix(expression, "/?$") && filepath.Ext(header.Path) == "" {
+			expression = strings.TrimSuffix(expression, "/?$") + "(/|/index\\.html)?$"
+		}
+		rows = append(rows, []string{escapeSingleQuotedJs(expression), header.Name, escapeSingleQuotedJs(header.Value)})
+	}
+	return rows, nil
+}
+
+// readStaticSiteRuleFile reads a rule file from the build, or from the live
+// build's metadata when the job has no build. It returns an empty string
+// when the build has no such file.
+func readStaticSiteRuleFile(parameters map[string]interface{}, name string) (string, error) {
+	distDirectory, err := getDistDirectory(parameters)
+	if err != nil {
+		return readLiveStaticSiteRuleFile(parameters, name)
+	}
+	content, err := os.ReadFile(filepath.Join(distDirectory, name))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return string(content), err
+}
+
+// getLiveStaticSiteBuild returns the live build and where its metadata is
+// kept. The build id is empty for a site served from the bucket root, which
+// has no build metadata.
+func getLiveStaticSiteBuild(parameters map[string]interface{}) (s3Client *s3.Client, bucketName string, liveBuildID string, err error) {
+	cloudfrontID, err := jobs.GetParameterValue[string](parameters, parameters_enums.CloudfrontID)
+	if err != nil {
+		return nil, "", "", err
+	}
+	cloudfrontClient, err := cloud_api_clients.GetCloudfrontClient(parameters, cloudfrontRegion)
+	if err != nil {
+		return nil, "", "", err
+	}
+	liveBuildID, err = getLiveStaticSiteBuildID(cloudfrontClient, cloudfrontID)
+	if err != nil || len(liveBuildID) == 0 {
+		return nil, "", "", err
+	}
+	bucketName, err = getBucketName(parameters)
+	if err != nil {
+		return nil, "", "", err
+	}
+	s3Client, err = cloud_api_clients.GetS3Client(parameters)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return s3Client, bucketName, liveBuildID, nil
+}
+
+func readLiveStaticSiteRuleFile(parameters map[string]interface{}, name string) (string, error) {
+	s3Client, bucketName, liveBuildID, err := getLiveStaticSiteBuild(parameters)
+	if err != nil || len(liveBuildID) == 0 {
+		return "", err
+	}
+	return getStaticSiteBuildRuleFile(s3Client, bucketName, liveBuildID, name)
+}
+
+func getStaticSiteRedirectsStatement(parameters map[string]interface{}) (string, error) {
+	content, err := readStaticSiteRuleFile(parameters, staticSiteRedirectsFile)
+	if err != nil || len(content) == 0 {
+		return "", err
+	}
+	redirects, err := parseStaticSiteRedirects(content)
+	if err != nil {
+		return "", err
+	}
+	//without a build the rules were checked against its files when it was deployed
+	var filePaths []string
+	if distDirectory, err := getDistDirectory(parameters); err == nil {
+		filePaths, err = getStaticSiteFilePaths(distDirectory)
+		if err != nil {
+			return "", err
+		}
+	}
+	return compileStaticSiteRedirects(redirects, filePaths)
+}
+
+func getStaticSiteHeaderRows(parameters map[string]interface{}) ([][]string, error) {
+	content, err := readStaticSiteRuleFile(parameters, staticSiteHeadersFile)
+	if err != nil || len(content) == 0 {
+		return nil, err
+	}
+	headers, err := parseStaticSiteHeaders(content)
+	if err != nil {
+		return nil, err
+	}
+	return compileStaticSiteHeaders(headers)
+}
+
+func isStaticSiteRuleFile(key string) bool {
+	return key == staticSiteRedirectsFile || key == staticSiteHeadersFile
+}
+
+func checkCloudfrontFunctionCodeSize(functionName, code string) error {
+	if len(code) > maxCloudfrontFunctionCodeSize {
+		return fmt.Errorf("cloudfront function %s is %d bytes, over the %d byte limit; remove or combine rules in %s/%s",
+			functionName, len(code), maxCloudfrontFunctionCodeSize, staticSiteRedirectsFile, staticSiteHeadersFile)
+	}
+	return nil
+}
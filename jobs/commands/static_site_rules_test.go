@@ -0,0 +1,161 @@
+package commands
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseStaticSiteRedirects(t *testing.T) {
+	redirects, err := parseStaticSiteRedirects(`# moved pages
+/old          /new
+/blog/:year/:slug   /posts/:year/:slug   302
+
+/app/*        /app/index.html     200!
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []staticSiteRedirect{
+		{From: "/old", To: "/new", Status: 301, Line: 2},
+		{From: "/blog/:year/:slug", To: "/posts/:year/:slug", Status: 302, Line: 3},
+		{From: "/app/*", To: "/app/index.html", Status: 200, Force: true, Line: 5},
+	}
+	if !reflect.DeepEqual(redirects, want) {
+		t.Errorf("redirects = %+v, want %+v", redirects, want)
+	}
+}
+
+func TestParseStaticSiteRedirects_Unsupported(t *testing.T) {
+	cases := map[string]string{
+		"query":     "/store id=:id /product/:id 301",
+		"condition": "/ /fr 302 Language=fr",
+		"domain":    "https://old.example.com/* https://new.example.com/:splat 301",
+		"proxy":     "/api/* https://api.example.com/:splat 200",
+		"status":    "/gone /404.html 404",
+		"no target": "/old",
+		"splat":     "/a/*/b /c 301",
+	}
+	for name, content := range cases {
+		redirects, err := parseStaticSiteRedirects(content)
+		if err == nil {
+			_, err = compileStaticSiteRedirects(redirects, nil)
+		}
+		if err == nil || !strings.Contains(err.Error(), "line 1") {
+			t.Errorf("%s: err = %v, want an error for line 1", name, err)
+		}
+	}
+}
+
+func TestGetStaticSitePathRegexp(t *testing.T) {
+	cases := []struct {
+		path    string
+		matches map[string][]string
+		misses  []string
+	}{
+		{"/docs", map[string][]string{"/docs": {}, "/docs/": {}}, []string{"/docs/a", "/docsx"}},
+		{"/blog/:year/:slug", map[string][]string{"/blog/2024/hello": {"2024", "hello"}}, []string{"/blog/2024"}},
+		{"/app/*", map[string][]string{"/app": {""}, "/app/": {""}, "/app/a/b": {"a/b"}}, []string{"/apps"}},
+		{"/*", map[string][]string{"/": {""}, "/a.b": {"a.b"}}, nil},
+		{"/a.b+c", map[string][]string{"/a.b+c": {}}, []string{"/axb+c"}},
+	}
+	for _, c := range cases {
+		expression, _, err := getStaticSitePathRegexp(c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pathRegexp := regexp.MustCompile(expression)
+		for uri, groups := range c.matches {
+			match := pathRegexp.FindStringSubmatch(uri)
+			if match == nil || !reflect.DeepEqual(append([]string{}, match[1:]...), append([]string{}, groups...)) {
+				t.Errorf("%s: %s matched %q, want groups %q", c.path, uri, match, groups)
+			}
+		}
+		for _, uri := range c.misses {
+			if pathRegexp.MatchString(uri) {
+				t.Errorf("%s: %s matched", c.path, uri)
+			}
+		}
+	}
+}
+
+func TestGetStaticSiteRedirectTarget(t *testing.T) {
+	parts := getStaticSiteRedirectTarget("https://example.com:443/posts/:slug/:splat", []string{"slug", "splat"})
+	want := []interface{}{"https://example.com:443/posts/", 1, "/", 2}
+	if !reflect.DeepEqual(parts, want) {
+		t.Errorf("parts = %v, want %v", parts, want)
+	}
+}
+
+func TestCompileStaticSiteRedirects_ShadowedByFile(t *testing.T) {
+	filePaths := []string{"/index.html", "/", "/about/index.html", "/about/"}
+	redirects, err := parseStaticSiteRedirects("/about /team 301\n/* /index.html 200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = compileStaticSiteRedirects(redirects[:1], filePaths)
+	if err == nil || !strings.Contains(err.Error(), "add !") {
+		t.Errorf("err = %v, want a shadowing error", err)
+	}
+	_, err = compileStaticSiteRedirects(redirects[1:], filePaths)
+	if err == nil || !strings.Contains(err.Error(), "single page app") {
+		t.Errorf("err = %v, want the single page app hint", err)
+	}
+	redirects[0].Force = true
+	statement, err := compileStaticSiteRedirects(redirects[:1], filePaths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(statement, `[["^/about/?$",["/team"],301]]`) {
+		t.Errorf("statement = %s", statement)
+	}
+}
+
+func TestParseStaticSiteHeaders(t *testing.T) {
+	headers, err := parseStaticSiteHeaders(`/*
+  X-Frame-Options: DENY
+/docs
+  # comment
+  Link: </style.css>; rel=preload; as=style
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := compileStaticSiteHeaders(headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{`^(?:/(.*))?$`, "x-frame-options", "DENY"},
+		{`^/docs(/|/index\\.html)?$`, "link", "</style.css>; rel=preload; as=style"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+	for name, content := range map[string]string{
+		"before path":  "  X-Frame-Options: DENY",
+		"no value":     "/*\n  X-Frame-Options",
+		"read only":    "/*\n  Content-Length: 1",
+		"invalid path": "docs\n  X-Frame-Options: DENY",
+	} {
+		if _, err = parseStaticSiteHeaders(content); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestEscapeSingleQuotedJs(t *testing.T) {
+	if escaped := escapeSingleQuotedJs(`it's \d`); escaped != `it\'s \\d` {
+		t.Errorf("escaped = %s", escaped)
+	}
+}
+
+func TestCheckCloudfrontFunctionCodeSize(t *testing.T) {
+	if err := checkCloudfrontFunctionCodeSize("f", strings.Repeat("a", maxCloudfrontFunctionCodeSize)); err != nil {
+		t.Error(err)
+	}
+	if err := checkCloudfrontFunctionCodeSize("f", strings.Repeat("a", maxCloudfrontFunctionCodeSize+1)); err == nil {
+		t.Error("expected an error over the limit")
+	}
+}
@@ -0,0 +1,256 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+)
+
+// defaultHealthCheckGracePeriodSeconds is used when the app container has no
+// health check start period to derive the grace period from.
+const defaultHealthCheckGracePeriodSeconds int32 = 30
+
+// targetGroupSettings is the optional TargetGroupSettings parameter. Zero
+// values keep the defaults the runner has always used. The load balancer only
+// speaks HTTP2 and gRPC to targets over https, so those need a certificate
+// and the http listener redirects to https.
+type targetGroupSettings struct {
+	HealthCheck                *targetGroupHealthCheck `json:"health_check"`
+	ProtocolVersion            string                  `json:"protocol_version"` // "HTTP1" (default) | "HTTP2" | "GRPC"
+	Stickiness                 *targetGroupStickiness  `json:"stickiness"`
+	DeregistrationDelaySeconds *int32                  `json:"deregistration_delay_seconds"`
+	SlowStartSeconds           *int32                  `json:"slow_start_seconds"`
+}
+
+type targetGroupHealthCheck struct {
+	HealthyThreshold   int32  `json:"healthy_threshold"`
+	UnhealthyThreshold int32  `json:"unhealthy_threshold"`
+	IntervalSeconds    int32  `json:"interval"`
+	TimeoutSeconds     int32  `json:"timeout"`
+	Port               string `json:"port"`    // "traffic-port" (default) or a port number
+	Matcher            string `json:"matcher"` // http codes like "200-399", or grpc codes like "0-99" for GRPC
+}
+
+type targetGroupStickiness struct {
+	Enabled         bool  `json:"enabled"`
+	DurationSeconds int32 `json:"duration_seconds"`
+}
+
+func getTargetGroupSettings(parameters map[string]interface{}) (*targetGroupSettings, error) {
+	settingsJSON, err := jobs.GetParameterValue[string](parameters, parameters_enums.TargetGroupSettings)
+	if err != nil || len(settingsJSON) == 0 {
+		return parseTargetGroupSettings(nil)
+	}
+	return parseTargetGroupSettings([]byte(settingsJSON))
+}
+
+// parseTargetGroupSettings fills in the defaults and validates the ranges
+// the load balancer accepts so a bad value fails before anything is created.
+func parseTargetGroupSettings(settingsBytes []byte) (*targetGroupSettings, error) {
+	settings := &targetGroupSettings{}
+	if len(settingsBytes) > 0 {
+		if err := json.Unmarshal(settingsBytes, settings); err != nil {
+			return nil, fmt.Errorf("error unmarshalling target group settings: %s", err)
+		}
+	}
+	switch settings.ProtocolVersion {
+	case "":
+		settings.ProtocolVersion = "HTTP1"
+	case "HTTP1", "HTTP2", "GRPC":
+	default:
+		return nil, fmt.Errorf("unsupported target group protocol version %s", settings.ProtocolVersion)
+	}
+	if settings.HealthCheck == nil {
+		settings.HealthCheck = &targetGroupHealthCheck{}
+	}
+	healthCheck := settings.HealthCheck
+	if healthCheck.IntervalSeconds == 0 {
+		healthCheck.IntervalSeconds = 40
+	}
+	if healthCheck.TimeoutSeconds == 0 {
+		//the timeout has to stay below a shorter interval
+		healthCheck.TimeoutSeconds = min(30, healthCheck.IntervalSeconds-1)
+	}
+	if healthCheck.HealthyThreshold == 0 {
+		healthCheck.HealthyThreshold = 5
+	}
+	if healthCheck.UnhealthyThreshold == 0 {
+		healthCheck.UnhealthyThreshold = 2
+	}
+	if len(healthCheck.Port) == 0 {
+		healthCheck.Port = "traffic-port"
+	}
+	if len(healthCheck.Matcher) == 0 {
+		healthCheck.Matcher = "200-400"
+		if settings.ProtocolVersion == "GRPC" {
+			healthCheck.Matcher = "0"
+		}
+	}
+	if healthCheck.IntervalSeconds < 5 || healthCheck.IntervalSeconds > 300 {
+		return nil, fmt.Errorf("health check interval must be between 5 and 300 seconds")
+	}
+	if healthCheck.TimeoutSeconds < 2 || healthCheck.TimeoutSeconds > 120 {
+		return nil, fmt.Errorf("health check timeout must be between 2 and 120 seconds")
+	}
+	if healthCheck.TimeoutSeconds >= healthCheck.IntervalSeconds {
+		return nil, fmt.Errorf("health check timeout must be less than the interval")
+	}
+	if healthCheck.HealthyThreshold < 2 || healthCheck.HealthyThreshold > 10 ||
+		healthCheck.UnhealthyThreshold < 2 || healthCheck.UnhealthyThreshold > 10 {
+		return nil, fmt.Errorf("health check thresholds must be between 2 and 10")
+	}
+	if healthCheck.Port != "traffic-port" {
+		port, err := strconv.Atoi(healthCheck.Port)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid health check port %s", healthCheck.Port)
+		}
+	}
+	if settings.DeregistrationDelaySeconds != nil &&
+		(*settings.DeregistrationDelaySeconds < 0 || *settings.DeregistrationDelaySeconds > 3600) {
+		return nil, fmt.Errorf("deregistration delay must be between 0 and 3600 seconds")
+	}
+	//0 turns slow start off
+	if settings.SlowStartSeconds != nil && *settings.SlowStartSeconds != 0 &&
+		(*settings.SlowStartSeconds < 30 || *settings.SlowStartSeconds > 900) {
+		return nil, fmt.Errorf("slow start must be 0 or between 30 and 900 seconds")
+	}
+	if settings.Stickiness != nil && settings.Stickiness.Enabled {
+		if settings.Stickiness.DurationSeconds == 0 {
+			settings.Stickiness.DurationSeconds = 86400
+		}
+		if settings.Stickiness.DurationSeconds < 1 || settings.Stickiness.DurationSeconds > 604800 {
+			return nil, fmt.Errorf("stickiness duration must be between 1 and 604800 seconds")
+		}
+	}
+	return settings, nil
+}
+
+// requiresHttps reports whether the targets can only be reached through an
+// https listener.
+func (t *targetGroupSettings) requiresHttps() bool {
+	return t.ProtocolVersion != "HTTP1"
+}
+
+// getHttpsRedirectActions redirects http requests to the https listener.
+func getHttpsRedirectActions() []elbTypes.Action {
+	return []elbTypes.Action{{
+		Type:  elbTypes.ActionTypeEnumRedirect,
+		Order: aws.Int32(1),
+		RedirectConfig: &elbTypes.RedirectActionConfig{
+			Protocol:   aws.String("HTTPS"),
+			Port:       aws.String("443"),
+			StatusCode: elbTypes.RedirectActionStatusCodeEnumHttp301,
+		},
+	}}
+}
+
+func (t *targetGroupSettings) matcher() *elbTypes.Matcher {
+	if t.ProtocolVersion == "GRPC" {
+		return &elbTypes.Matcher{GrpcCode: aws.String(t.HealthCheck.Matcher)}
+	}
+	return &elbTypes.Matcher{HttpCode: aws.String(t.HealthCheck.Matcher)}
+}
+
+// attributes returns the target group attributes to set. Only attributes the
+// settings mention are returned so console changes to others are kept.
+func (t *targetGroupSettings) attributes() []elbTypes.TargetGroupAttribute {
+	var attributes []elbTypes.TargetGroupAttribute
+	if t.Stickiness != nil {
+		attributes = append(attributes, elbTypes.TargetGroupAttribute{
+			Key:   aws.String("stickiness.enabled"),
+			Value: aws.String(strconv.FormatBool(t.Stickiness.Enabled)),
+		})
+		if t.Stickiness.Enabled {
+			attributes = append(attributes, elbTypes.TargetGroupAttribute{
+				Key:   aws.String("stickiness.type"),
+				Value: aws.String("lb_cookie"),
+			}, elbTypes.TargetGroupAttribute{
+				Key:   aws.String("stickiness.lb_cookie.duration_seconds"),
+				Value: aws.String(strconv.Itoa(int(t.Stickiness.DurationSeconds))),
+			})
+		}
+	}
+	if t.DeregistrationDelaySeconds != nil {
+		attributes = append(attributes, elbTypes.TargetGroupAttribute{
+			Key:   aws.String("deregistration_delay.timeout_seconds"),
+			Value: aws.String(strconv.Itoa(int(*t.DeregistrationDelaySeconds))),
+		})
+	}
+	if t.SlowStartSeconds != nil {
+		attributes = append(attributes, elbTypes.TargetGroupAttribute{
+			Key:   aws.String("slow_start.duration_seconds"),
+			Value: aws.String(strconv.Itoa(int(*t.SlowStartSeconds))),
+		})
+	}
+	return attributes
+}
+
+// syncTargetGroupSettings applies the health check and attributes to an
+// existing target group. The protocol version can only be set at creation.
+func syncTargetGroupSettings(parameters map[string]interface{}, elbClient *elasticloadbalancingv2.Client,
+	targetGroupArn string, logsWriter io.Writer) error {
+	settings, err := getTargetGroupSettings(parameters)
+	if err != nil {
+		return err
+	}
+	describeTargetGroupsOutput, err := elbClient.DescribeTargetGroups(context.TODO(), &elasticloadbalancingv2.DescribeTargetGroupsInput{
+		TargetGroupArns: []string{targetGroupArn},
+	})
+	if err != nil {
+		return err
+	}
+	if len(describeTargetGroupsOutput.TargetGroups) > 0 {
+		protocolVersion := aws.ToString(describeTargetGroupsOutput.TargetGroups[0].ProtocolVersion)
+		if len(protocolVersion) > 0 && protocolVersion != settings.ProtocolVersion {
+			io.WriteString(logsWriter, fmt.Sprintf("Target group protocol version is %s and can't be changed to %s without recreating the service\n",
+				protocolVersion, settings.ProtocolVersion))
+		}
+	}
+	healthCheckPath, err := jobs.GetParameterValue[string](parameters, parameters_enums.HealthCheckPath)
+	if err != nil {
+		return err
+	}
+	_, err = elbClient.ModifyTargetGroup(context.TODO(), &elasticloadbalancingv2.ModifyTargetGroupInput{
+		TargetGroupArn:             aws.String(targetGroupArn),
+		HealthCheckEnabled:         aws.Bool(true),
+		HealthCheckPath:            aws.String(healthCheckPath),
+		HealthCheckIntervalSeconds: aws.Int32(settings.HealthCheck.IntervalSeconds),
+		HealthCheckTimeoutSeconds:  aws.Int32(settings.HealthCheck.TimeoutSeconds),
+		HealthCheckPort:            aws.String(settings.HealthCheck.Port),
+		HealthyThresholdCount:      aws.Int32(settings.HealthCheck.HealthyThreshold),
+		UnhealthyThresholdCount:    aws.Int32(settings.HealthCheck.UnhealthyThreshold),
+		Matcher:                    settings.matcher(),
+	})
+	if err != nil {
+		return err
+	}
+	attributes := settings.attributes()
+	if len(attributes) == 0 {
+		return nil
+	}
+	_, err = elbClient.ModifyTargetGroupAttributes(context.TODO(), &elasticloadbalancingv2.ModifyTargetGroupAttributesInput{
+		TargetGroupArn: aws.String(targetGroupArn),
+		Attributes:     attributes,
+	})
+	return err
+}
+
+// getHealthCheckGracePeriodSeconds gives new tasks as long as the app
+// container's own health check start period before the load balancer health
+// check can mark them unhealthy. A start period without a health check
+// command isn't sent to ECS, so it doesn't count.
+func getHealthCheckGracePeriodSeconds(config *taskContainersConfig) int32 {
+	if config != nil && config.App != nil && toEcsHealthCheck(config.App.HealthCheck) != nil &&
+		config.App.HealthCheck.StartPeriod > 0 {
+		return config.App.HealthCheck.StartPeriod
+	}
+	return defaultHealthCheckGracePeriodSeconds
+}
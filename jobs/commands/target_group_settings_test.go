@@ -0,0 +1,120 @@
+package commands
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// TestParseTargetGroupSettings_Defaults keeps the previously hard-coded
+// health check when nothing is configured.
+func TestParseTargetGroupSettings_Defaults(t *testing.T) {
+	settings, err := parseTargetGroupSettings(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.ProtocolVersion != "HTTP1" || settings.HealthCheck.IntervalSeconds != 40 ||
+		settings.HealthCheck.TimeoutSeconds != 30 || settings.HealthCheck.Port != "traffic-port" {
+		t.Errorf("settings = %+v, health check = %+v", settings, settings.HealthCheck)
+	}
+	if got := aws.ToString(settings.matcher().HttpCode); got != "200-400" {
+		t.Errorf("matcher = %s, want 200-400", got)
+	}
+	if attributes := settings.attributes(); len(attributes) != 0 {
+		t.Errorf("attributes = %+v, want none", attributes)
+	}
+	if settings.requiresHttps() {
+		t.Error("HTTP1 shouldn't require https")
+	}
+}
+
+// TestParseTargetGroupSettings_SlowStartOff sends a slow start of 0 so a
+// previously set one is turned off.
+func TestParseTargetGroupSettings_SlowStartOff(t *testing.T) {
+	settings, err := parseTargetGroupSettings([]byte(`{"slow_start_seconds": 0}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	attributes := settings.attributes()
+	if len(attributes) != 1 || aws.ToString(attributes[0].Key) != "slow_start.duration_seconds" ||
+		aws.ToString(attributes[0].Value) != "0" {
+		t.Errorf("attributes = %+v, want slow start 0", attributes)
+	}
+}
+
+func TestParseTargetGroupSettings_GrpcAndStickiness(t *testing.T) {
+	settings, err := parseTargetGroupSettings([]byte(`{"protocol_version": "GRPC",
+		"stickiness": {"enabled": true}, "deregistration_delay_seconds": 0, "slow_start_seconds": 60}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := aws.ToString(settings.matcher().GrpcCode); got != "0" {
+		t.Errorf("grpc matcher = %s, want 0", got)
+	}
+	if !settings.requiresHttps() {
+		t.Error("GRPC should require https")
+	}
+	values := map[string]string{}
+	for _, attribute := range settings.attributes() {
+		values[aws.ToString(attribute.Key)] = aws.ToString(attribute.Value)
+	}
+	want := map[string]string{
+		"stickiness.enabled":                    "true",
+		"stickiness.type":                       "lb_cookie",
+		"stickiness.lb_cookie.duration_seconds": "86400",
+		"deregistration_delay.timeout_seconds":  "0",
+		"slow_start.duration_seconds":           "60",
+	}
+	for key, value := range want {
+		if values[key] != value {
+			t.Errorf("%s = %q, want %q", key, values[key], value)
+		}
+	}
+}
+
+// TestParseTargetGroupSettings_ShortInterval derives the default timeout
+// from a shorter interval.
+func TestParseTargetGroupSettings_ShortInterval(t *testing.T) {
+	for interval, wantTimeout := range map[int32]int32{5: 4, 10: 9, 31: 30, 60: 30} {
+		settings, err := parseTargetGroupSettings([]byte(fmt.Sprintf(`{"health_check": {"interval": %d}}`, interval)))
+		if err != nil {
+			t.Errorf("interval %d: %s", interval, err)
+			continue
+		}
+		if settings.HealthCheck.TimeoutSeconds != wantTimeout {
+			t.Errorf("interval %d: timeout = %d, want %d", interval, settings.HealthCheck.TimeoutSeconds, wantTimeout)
+		}
+	}
+}
+
+func TestParseTargetGroupSettings_Invalid(t *testing.T) {
+	for _, settingsJSON := range []string{
+		`{"protocol_version": "HTTP3"}`,
+		`{"health_check": {"interval": 10, "timeout": 10}}`,
+		`{"health_check": {"healthy_threshold": 1}}`,
+		`{"health_check": {"port": "http"}}`,
+		`{"slow_start_seconds": 10}`,
+		`{"deregistration_delay_seconds": 4000}`,
+	} {
+		if _, err := parseTargetGroupSettings([]byte(settingsJSON)); err == nil {
+			t.Errorf("expected an error for %s", settingsJSON)
+		}
+	}
+}
+
+func TestGetHealthCheckGracePeriodSeconds(t *testing.T) {
+	if got := getHealthCheckGracePeriodSeconds(nil); got != 30 {
+		t.Errorf("grace period without config = %d, want 30", got)
+	}
+	config := &taskContainersConfig{App: &appContainerSpec{HealthCheck: &containerHealthCheck{
+		Command: []string{"CMD-SHELL", "curl -f http://localhost/ || exit 1"}, StartPeriod: 120}}}
+	if got := getHealthCheckGracePeriodSeconds(config); got != 120 {
+		t.Errorf("grace period = %d, want 120", got)
+	}
+	//no health check runs without a command
+	config = &taskContainersConfig{App: &appContainerSpec{HealthCheck: &containerHealthCheck{StartPeriod: 120}}}
+	if got := getHealthCheckGracePeriodSeconds(config); got != 30 {
+		t.Errorf("grace period without a command = %d, want 30", got)
+	}
+}
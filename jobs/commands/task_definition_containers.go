@@ -0,0 +1,471 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/deployment-io/deployment-runner-kit/enums/parameters_enums"
+	"github.com/deployment-io/deployment-runner-kit/jobs"
+)
+
+// taskContainersConfigFile is the repo-relative path of the optional file that
+// describes the extra containers of a service's task definition. The
+// TaskContainers parameter, when set, takes precedence over the file.
+const taskContainersConfigFile = ".deployment/containers.json"
+
+// appContainerAlias lets DependsOn entries refer to the service's own container
+// without knowing its generated c-<deploymentID> name.
+const appContainerAlias = "app"
+
+const (
+	containerTypeSidecar   = "sidecar"
+	containerTypeInit      = "init"
+	containerTypeLogRouter = "log_router"
+)
+
+var containerNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,255}$`)
+
+// taskContainersConfig is the structured description of everything in a task
+// definition besides the single image container registerTaskDefinition has
+// always produced: sidecars (log routers, collectors, proxies, agents), init
+// containers that must finish before the app starts, shared task volumes, and
+// app-container overrides such as a health check or its own CPU/memory share.
+type taskContainersConfig struct {
+	App        *appContainerSpec `json:"app"`
+	Containers []containerSpec   `json:"containers"`
+	Volumes    []string          `json:"volumes"`
+	EfsVolumes []efsVolumeSpec   `json:"efs_volumes"`
+}
+
+type appContainerSpec struct {
+	Cpu               int32                      `json:"cpu"`
+	Memory            int32                      `json:"memory"`
+	MemoryReservation int32                      `json:"memory_reservation"`
+	HealthCheck       *containerHealthCheck      `json:"health_check"`
+	DependsOn         []containerDependency      `json:"depends_on"`
+	MountPoints       []containerMountPoint      `json:"mount_points"`
+	LogConfiguration  *containerLogConfiguration `json:"log_configuration"`
+}
+
+type containerSpec struct {
+	Name              string                     `json:"name"`
+	Image             string                     `json:"image"`
+	Type              string                     `json:"type"` // "sidecar" (default) | "init" | "log_router"
+	Essential         *bool                      `json:"essential"`
+	Command           []string                   `json:"command"`
+	EntryPoint        []string                   `json:"entry_point"`
+	Environment       map[string]string          `json:"environment"`
+	Cpu               int32                      `json:"cpu"`
+	Memory            int32                      `json:"memory"`
+	MemoryReservation int32                      `json:"memory_reservation"`
+	PortMappings      []containerPortMapping     `json:"port_mappings"`
+	HealthCheck       *containerHealthCheck      `json:"health_check"`
+	DependsOn         []containerDependency      `json:"depends_on"`
+	MountPoints       []containerMountPoint      `json:"mount_points"`
+	LogConfiguration  *containerLogConfiguration `json:"log_configuration"`
+	Firelens          *containerFirelens         `json:"firelens"`
+}
+
+type containerPortMapping struct {
+	ContainerPort int32  `json:"container_port"`
+	Protocol      string `json:"protocol"` // "tcp" (default) | "udp"
+}
+
+type containerHealthCheck struct {
+	Command     []string `json:"command"`
+	Interval    int32    `json:"interval"`
+	Timeout     int32    `json:"timeout"`
+	Retries     int32    `json:"retries"`
+	StartPeriod int32    `json:"start_period"`
+}
+
+type containerDependency struct {
+	Container string `json:"container"`
+	Condition string `json:"condition"` // START | COMPLETE | SUCCESS | HEALTHY
+}
+
+type containerMountPoint struct {
+	Volume   string `json:"volume"`
+	Path     string `json:"path"`
+	ReadOnly bool   `json:"read_only"`
+}
+
+// containerLogConfiguration only lets a container opt into FireLens; every
+// other container keeps the awslogs configuration the runner generates.
+type containerLogConfiguration struct {
+	Driver  string            `json:"driver"` // "awslogs" (default) | "awsfirelens"
+	Options map[string]string `json:"options"`
+}
+
+type containerFirelens struct {
+	Type    string            `json:"type"` // "fluentbit" (default) | "fluentd"
+	Options map[string]string `json:"options"`
+}
+
+// getTaskContainersConfig returns the multi-container configuration for the
+// deployment, or nil when neither the TaskContainers parameter nor the repo
+// config file is present, in which case the task definition keeps its single
+// container.
+func getTaskContainersConfig(parameters map[string]interface{}) (*taskContainersConfig, error) {
+	configJSON, err := jobs.GetParameterValue[string](parameters, parameters_enums.TaskContainers)
+	if err == nil && len(configJSON) > 0 {
+		return parseTaskContainersConfig([]byte(configJSON))
+	}
+	repoDirectoryPath, err := jobs.GetParameterValue[string](parameters, parameters_enums.RepoDirectoryPath)
+	if err != nil || len(repoDirectoryPath) == 0 {
+		//deployed from an image, nothing to read
+		return nil, nil
+	}
+	configBytes, err := os.ReadFile(filepath.Join(repoDirectoryPath, taskContainersConfigFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return parseTaskContainersConfig(configBytes)
+}
+
+func parseTaskContainersConfig(configBytes []byte) (*taskContainersConfig, error) {
+	config := &taskContainersConfig{}
+	if err := json.Unmarshal(configBytes, config); err != nil {
+		return nil, fmt.Errorf("error unmarshalling task containers config: %s", err)
+	}
+	return config, nil
+}
+
+// buildTaskContainerDefinitions turns the app container and the containers
+// config into the task definition's container definitions and volumes. Init
+// containers are made non-essential and the app container waits for each of
+// them to exit successfully. Containers without their own log configuration
+// log through sidecarLogConfiguration so they stay out of the application log
+// streams. The summed per-container CPU and memory must fit in the task size.
+func buildTaskContainerDefinitions(appContainer ecsTypes.ContainerDefinition, config *taskContainersConfig,
+	sidecarLogConfiguration *ecsTypes.LogConfiguration, taskCpu, taskMemory string) ([]ecsTypes.ContainerDefinition, []ecsTypes.Volume, error) {
+	if config == nil {
+		return []ecsTypes.ContainerDefinition{appContainer}, nil, nil
+	}
+
+	appContainerName := aws.ToString(appContainer.Name)
+	volumeNames := map[string]bool{}
+	var volumes []ecsTypes.Volume
+	for _, volumeName := range config.Volumes {
+		if !containerNameRegex.MatchString(volumeName) {
+			return nil, nil, fmt.Errorf("invalid volume name: %q", volumeName)
+		}
+		if volumeNames[volumeName] {
+			return nil, nil, fmt.Errorf("duplicate volume: %s", volumeName)
+		}
+		volumeNames[volumeName] = true
+		volumes = append(volumes, ecsTypes.Volume{Name: aws.String(volumeName)})
+	}
+	//efs volumes get their file system configuration in registerTaskDefinition
+	if err := validateEfsVolumes(config.EfsVolumes); err != nil {
+		return nil, nil, err
+	}
+	for _, efsVolume := range config.EfsVolumes {
+		if volumeNames[efsVolume.Name] {
+			return nil, nil, fmt.Errorf("duplicate volume: %s", efsVolume.Name)
+		}
+		volumeNames[efsVolume.Name] = true
+		volumes = append(volumes, ecsTypes.Volume{Name: aws.String(efsVolume.Name)})
+	}
+
+	//first pass: names, so that depends_on can reference containers declared later
+	healthChecked := map[string]bool{}
+	if config.App != nil && config.App.HealthCheck != nil {
+		healthChecked[appContainerName] = true
+	}
+	containerNames := map[string]bool{appContainerName: true, appContainerAlias: true}
+	hasLogRouter := false
+	for _, container := range config.Containers {
+		if !containerNameRegex.MatchString(container.Name) {
+			return nil, nil, fmt.Errorf("invalid container name: %q", container.Name)
+		}
+		if containerNames[container.Name] {
+			return nil, nil, fmt.Errorf("duplicate or reserved container name: %s", container.Name)
+		}
+		containerNames[container.Name] = true
+		if container.HealthCheck != nil {
+			healthChecked[container.Name] = true
+		}
+		if container.Type == containerTypeLogRouter {
+			if hasLogRouter {
+				return nil, nil, fmt.Errorf("only one log_router container is allowed")
+			}
+			hasLogRouter = true
+		}
+	}
+
+	var totalCpu, totalMemory int32
+	var initContainerNames []string
+	var sidecars []ecsTypes.ContainerDefinition
+	for _, container := range config.Containers {
+		if len(container.Image) == 0 {
+			return nil, nil, fmt.Errorf("image is required for container %s", container.Name)
+		}
+		containerDefinition := ecsTypes.ContainerDefinition{
+			Name:              aws.String(container.Name),
+			Image:             aws.String(container.Image),
+			Command:           container.Command,
+			EntryPoint:        container.EntryPoint,
+			Environment:       mapToKeyValueSlice(container.Environment),
+			Essential:         aws.Bool(true),
+			LogConfiguration:  sidecarLogConfiguration,
+			Privileged:        aws.Bool(false),
+			PseudoTerminal:    aws.Bool(false),
+			Interactive:       aws.Bool(false),
+			DisableNetworking: aws.Bool(false),
+		}
+		switch container.Type {
+		case "", containerTypeSidecar:
+		case containerTypeInit:
+			//an init container has to exit, so it can never be essential
+			containerDefinition.Essential = aws.Bool(false)
+			initContainerNames = append(initContainerNames, container.Name)
+		case containerTypeLogRouter:
+			firelensConfiguration := &ecsTypes.FirelensConfiguration{Type: ecsTypes.FirelensConfigurationTypeFluentbit}
+			if container.Firelens != nil {
+				switch container.Firelens.Type {
+				case "", string(ecsTypes.FirelensConfigurationTypeFluentbit):
+				case string(ecsTypes.FirelensConfigurationTypeFluentd):
+					firelensConfiguration.Type = ecsTypes.FirelensConfigurationTypeFluentd
+				default:
+					return nil, nil, fmt.Errorf("unsupported firelens type for container %s: %s", container.Name, container.Firelens.Type)
+				}
+				firelensConfiguration.Options = container.Firelens.Options
+			}
+			containerDefinition.FirelensConfiguration = firelensConfiguration
+		default:
+			return nil, nil, fmt.Errorf("unsupported container type for container %s: %s", container.Name, container.Type)
+		}
+		if container.Essential != nil && container.Type != containerTypeInit {
+			containerDefinition.Essential = container.Essential
+		}
+		if container.Cpu > 0 {
+			containerDefinition.Cpu = container.Cpu
+		}
+		if container.Memory > 0 {
+			containerDefinition.Memory = aws.Int32(container.Memory)
+		}
+		if container.MemoryReservation > 0 {
+			containerDefinition.MemoryReservation = aws.Int32(container.MemoryReservation)
+		}
+		for _, portMapping := range container.PortMappings {
+			protocol := ecsTypes.TransportProtocolTcp
+			if portMapping.Protocol == string(ecsTypes.TransportProtocolUdp) {
+				protocol = ecsTypes.TransportProtocolUdp
+			}
+			containerDefinition.PortMappings = append(containerDefinition.PortMappings, ecsTypes.PortMapping{
+				ContainerPort: aws.Int32(portMapping.ContainerPort),
+				Protocol:      protocol,
+			})
+		}
+		var err error
+		containerDefinition.HealthCheck = toEcsHealthCheck(container.HealthCheck)
+		containerDefinition.DependsOn, err = toEcsContainerDependencies(container.DependsOn, containerNames, healthChecked, appContainerName)
+		if err != nil {
+			return nil, nil, fmt.Errorf("container %s: %s", container.Name, err)
+		}
+		containerDefinition.MountPoints, err = toEcsMountPoints(container.MountPoints, volumeNames)
+		if err != nil {
+			return nil, nil, fmt.Errorf("container %s: %s", container.Name, err)
+		}
+		if container.LogConfiguration != nil {
+			containerDefinition.LogConfiguration, err = toEcsLogConfiguration(container.LogConfiguration, sidecarLogConfiguration, hasLogRouter)
+			if err != nil {
+				return nil, nil, fmt.Errorf("container %s: %s", container.Name, err)
+			}
+		}
+		totalCpu += container.Cpu
+		totalMemory += containerMemory(container.Memory, container.MemoryReservation)
+		sidecars = append(sidecars, containerDefinition)
+	}
+
+	if config.App != nil {
+		if config.App.Cpu > 0 {
+			appContainer.Cpu = config.App.Cpu
+		}
+		if config.App.Memory > 0 {
+			appContainer.Memory = aws.Int32(config.App.Memory)
+		}
+		if config.App.MemoryReservation > 0 {
+			appContainer.MemoryReservation = aws.Int32(config.App.MemoryReservation)
+		}
+		var err error
+		appContainer.HealthCheck = toEcsHealthCheck(config.App.HealthCheck)
+		appContainer.DependsOn, err = toEcsContainerDependencies(config.App.DependsOn, containerNames, healthChecked, appContainerName)
+		if err != nil {
+			return nil, nil, fmt.Errorf("app container: %s", err)
+		}
+		appContainer.MountPoints, err = toEcsMountPoints(config.App.MountPoints, volumeNames)
+		if err != nil {
+			return nil, nil, fmt.Errorf("app container: %s", err)
+		}
+		if config.App.LogConfiguration != nil {
+			appContainer.LogConfiguration, err = toEcsLogConfiguration(config.App.LogConfiguration, appContainer.LogConfiguration, hasLogRouter)
+			if err != nil {
+				return nil, nil, fmt.Errorf("app container: %s", err)
+			}
+		}
+		totalCpu += config.App.Cpu
+		totalMemory += containerMemory(config.App.Memory, config.App.MemoryReservation)
+	}
+	//efs volumes with a path are mounted into the app container
+	for _, efsVolume := range config.EfsVolumes {
+		if len(efsVolume.Path) == 0 {
+			continue
+		}
+		appContainer.MountPoints = append(appContainer.MountPoints, ecsTypes.MountPoint{
+			ContainerPath: aws.String(efsVolume.Path),
+			ReadOnly:      aws.Bool(efsVolume.ReadOnly),
+			SourceVolume:  aws.String(efsVolume.Name),
+		})
+	}
+	//the app container starts only after every init container has succeeded
+	for _, initContainerName := range initContainerNames {
+		if !dependsOnContainer(appContainer.DependsOn, initContainerName) {
+			appContainer.DependsOn = append(appContainer.DependsOn, ecsTypes.ContainerDependency{
+				Condition:     ecsTypes.ContainerConditionSuccess,
+				ContainerName: aws.String(initContainerName),
+			})
+		}
+	}
+
+	if cpu, err := strconv.ParseInt(taskCpu, 10, 32); err == nil && int64(totalCpu) > cpu {
+		return nil, nil, fmt.Errorf("containers request %d cpu units but the task only has %s", totalCpu, taskCpu)
+	}
+	if memory, err := strconv.ParseInt(taskMemory, 10, 32); err == nil && int64(totalMemory) > memory {
+		return nil, nil, fmt.Errorf("containers request %d MiB of memory but the task only has %s", totalMemory, taskMemory)
+	}
+
+	return append([]ecsTypes.ContainerDefinition{appContainer}, sidecars...), volumes, nil
+}
+
+func containerMemory(memory, memoryReservation int32) int32 {
+	if memory > 0 {
+		return memory
+	}
+	return memoryReservation
+}
+
+func mapToKeyValueSlice(environment map[string]string) []ecsTypes.KeyValuePair {
+	names := make([]string, 0, len(environment))
+	for name := range environment {
+		names = append(names, name)
+	}
+	//sorted so that identical configs register identical task definitions
+	sort.Strings(names)
+	var keyValuePairs []ecsTypes.KeyValuePair
+	for _, name := range names {
+		keyValuePairs = append(keyValuePairs, ecsTypes.KeyValuePair{
+			Name:  aws.String(name),
+			Value: aws.String(environment[name]),
+		})
+	}
+	return keyValuePairs
+}
+
+func toEcsHealthCheck(healthCheck *containerHealthCheck) *ecsTypes.HealthCheck {
+	if healthCheck == nil || len(healthCheck.Command) == 0 {
+		return nil
+	}
+	ecsHealthCheck := &ecsTypes.HealthCheck{Command: healthCheck.Command}
+	if healthCheck.Interval > 0 {
+		ecsHealthCheck.Interval = aws.Int32(healthCheck.Interval)
+	}
+	if healthCheck.Timeout > 0 {
+		ecsHealthCheck.Timeout = aws.Int32(healthCheck.Timeout)
+	}
+	if healthCheck.Retries > 0 {
+		ecsHealthCheck.Retries = aws.Int32(healthCheck.Retries)
+	}
+	if healthCheck.StartPeriod > 0 {
+		ecsHealthCheck.StartPeriod = aws.Int32(healthCheck.StartPeriod)
+	}
+	return ecsHealthCheck
+}
+
+func toEcsContainerDependencies(dependsOn []containerDependency, containerNames, healthChecked map[string]bool,
+	appContainerName string) ([]ecsTypes.ContainerDependency, error) {
+	var dependencies []ecsTypes.ContainerDependency
+	for _, dependency := range dependsOn {
+		containerName := dependency.Container
+		if containerName == appContainerAlias {
+			containerName = appContainerName
+		}
+		if !containerNames[containerName] {
+			return nil, fmt.Errorf("depends on unknown container: %s", dependency.Container)
+		}
+		var condition ecsTypes.ContainerCondition
+		switch ecsTypes.ContainerCondition(dependency.Condition) {
+		case ecsTypes.ContainerConditionStart, ecsTypes.ContainerConditionComplete, ecsTypes.ContainerConditionSuccess:
+			condition = ecsTypes.ContainerCondition(dependency.Condition)
+		case ecsTypes.ContainerConditionHealthy:
+			if !healthChecked[containerName] {
+				return nil, fmt.Errorf("HEALTHY condition on %s which has no health check", dependency.Container)
+			}
+			condition = ecsTypes.ContainerConditionHealthy
+		default:
+			return nil, fmt.Errorf("unsupported depends on condition: %s", dependency.Condition)
+		}
+		dependencies = append(dependencies, ecsTypes.ContainerDependency{
+			Condition:     condition,
+			ContainerName: aws.String(containerName),
+		})
+	}
+	return dependencies, nil
+}
+
+func toEcsMountPoints(mountPoints []containerMountPoint, volumeNames map[string]bool) ([]ecsTypes.MountPoint, error) {
+	var ecsMountPoints []ecsTypes.MountPoint
+	for _, mountPoint := range mountPoints {
+		if !volumeNames[mountPoint.Volume] {
+			return nil, fmt.Errorf("mounts undeclared volume: %s", mountPoint.Volume)
+		}
+		if len(mountPoint.Path) == 0 {
+			return nil, fmt.Errorf("mount path is required for volume %s", mountPoint.Volume)
+		}
+		ecsMountPoints = append(ecsMountPoints, ecsTypes.MountPoint{
+			ContainerPath: aws.String(mountPoint.Path),
+			ReadOnly:      aws.Bool(mountPoint.ReadOnly),
+			SourceVolume:  aws.String(mountPoint.Volume),
+		})
+	}
+	return ecsMountPoints, nil
+}
+
+func toEcsLogConfiguration(logConfiguration *containerLogConfiguration, defaultLogConfiguration *ecsTypes.LogConfiguration,
+	hasLogRouter bool) (*ecsTypes.LogConfiguration, error) {
+	switch ecsTypes.LogDriver(logConfiguration.Driver) {
+	case "", ecsTypes.LogDriverAwslogs:
+		return defaultLogConfiguration, nil
+	case ecsTypes.LogDriverAwsfirelens:
+		if !hasLogRouter {
+			return nil, fmt.Errorf("awsfirelens log driver needs a log_router container")
+		}
+		return &ecsTypes.LogConfiguration{
+			LogDriver: ecsTypes.LogDriverAwsfirelens,
+			Options:   logConfiguration.Options,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported log driver: %s", logConfiguration.Driver)
+	}
+}
+
+func dependsOnContainer(dependencies []ecsTypes.ContainerDependency, containerName string) bool {
+	for _, dependency := range dependencies {
+		if aws.ToString(dependency.ContainerName) == containerName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func testAppContainer() ecsTypes.ContainerDefinition {
+	return ecsTypes.ContainerDefinition{
+		Name:      aws.String("c-dep1"),
+		Image:     aws.String("app:latest"),
+		Essential: aws.Bool(true),
+	}
+}
+
+// TestBuildTaskContainerDefinitions_NoConfig keeps the single app container
+// when the deployment has no containers config.
+func TestBuildTaskContainerDefinitions_NoConfig(t *testing.T) {
+	definitions, volumes, err := buildTaskContainerDefinitions(testAppContainer(), nil, nil, "512", "1024")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(definitions) != 1 || aws.ToString(definitions[0].Name) != "c-dep1" {
+		t.Errorf("definitions = %+v, want only the app container", definitions)
+	}
+	if len(volumes) != 0 {
+		t.Errorf("volumes = %+v, want none", volumes)
+	}
+}
+
+// TestBuildTaskContainerDefinitions_InitAndSidecar checks that init containers
+// are non-essential and gate the app container, and that sidecars, volumes and
+// the "app" alias are wired through.
+func TestBuildTaskContainerDefinitions_InitAndSidecar(t *testing.T) {
+	config, err := parseTaskContainersConfig([]byte(`{
+		"app": {"cpu": 256, "memory": 512, "health_check": {"command": ["CMD-SHELL", "curl -f localhost/ || exit 1"], "start_period": 60},
+			"mount_points": [{"volume": "shared", "path": "/shared"}]},
+		"volumes": ["shared"],
+		"containers": [
+			{"name": "migrate", "image": "app:latest", "type": "init", "command": ["rake", "db:migrate"]},
+			{"name": "nginx", "image": "nginx:stable", "cpu": 128, "memory": 256,
+				"depends_on": [{"container": "app", "condition": "HEALTHY"}],
+				"mount_points": [{"volume": "shared", "path": "/usr/share/nginx/html", "read_only": true}]}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sidecarLogs := &ecsTypes.LogConfiguration{LogDriver: ecsTypes.LogDriverAwslogs}
+	definitions, volumes, err := buildTaskContainerDefinitions(testAppContainer(), config, sidecarLogs, "512", "1024")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(definitions) != 3 || len(volumes) != 1 {
+		t.Fatalf("got %d definitions and %d volumes, want 3 and 1", len(definitions), len(volumes))
+	}
+	app, migrate, nginx := definitions[0], definitions[1], definitions[2]
+	if aws.ToBool(migrate.Essential) {
+		t.Errorf("init container must not be essential")
+	}
+	if len(app.DependsOn) != 1 || aws.ToString(app.DependsOn[0].ContainerName) != "migrate" ||
+		app.DependsOn[0].Condition != ecsTypes.ContainerConditionSuccess {
+		t.Errorf("app DependsOn = %+v, want migrate SUCCESS", app.DependsOn)
+	}
+	if app.HealthCheck == nil || aws.ToInt32(app.HealthCheck.StartPeriod) != 60 {
+		t.Errorf("app HealthCheck = %+v, want start period 60", app.HealthCheck)
+	}
+	if len(nginx.DependsOn) != 1 || aws.ToString(nginx.DependsOn[0].ContainerName) != "c-dep1" {
+		t.Errorf("nginx DependsOn = %+v, want the app alias resolved to c-dep1", nginx.DependsOn)
+	}
+	if nginx.LogConfiguration != sidecarLogs {
+		t.Errorf("sidecar should log through the sidecar log configuration")
+	}
+}
+
+func TestBuildTaskContainerDefinitions_Errors(t *testing.T) {
+	cases := map[string]string{
+		"duplicate name":        `{"containers": [{"name": "a", "image": "x"}, {"name": "a", "image": "x"}]}`,
+		"reserved name":         `{"containers": [{"name": "app", "image": "x"}]}`,
+		"missing image":         `{"containers": [{"name": "a"}]}`,
+		"unknown dependency":    `{"containers": [{"name": "a", "image": "x", "depends_on": [{"container": "b", "condition": "START"}]}]}`,
+		"healthy without hc":    `{"containers": [{"name": "a", "image": "x"}, {"name": "b", "image": "x", "depends_on": [{"container": "a", "condition": "HEALTHY"}]}]}`,
+		"undeclared volume":     `{"containers": [{"name": "a", "image": "x", "mount_points": [{"volume": "v", "path": "/v"}]}]}`,
+		"firelens no router":    `{"app": {"log_configuration": {"driver": "awsfirelens"}}}`,
+		"cpu over task size":    `{"containers": [{"name": "a", "image": "x", "cpu": 1024}]}`,
+		"memory over task size": `{"containers": [{"name": "a", "image": "x", "memory": 2048}]}`,
+		"duplicate efs volume":  `{"volumes": ["data"], "efs_volumes": [{"name": "data", "path": "/data"}]}`,
+		"relative efs path":     `{"efs_volumes": [{"name": "data", "path": "data"}]}`,
+	}
+	for name, configJSON := range cases {
+		t.Run(name, func(t *testing.T) {
+			config, err := parseTaskContainersConfig([]byte(configJSON))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _, err = buildTaskContainerDefinitions(testAppContainer(), config, nil, "512", "1024")
+			if err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+// TestBuildTaskContainerDefinitions_FirelensRouter routes the app's logs
+// through a fluent-bit log router.
+func TestBuildTaskContainerDefinitions_FirelensRouter(t *testing.T) {
+	config, err := parseTaskContainersConfig([]byte(`{
+		"app": {"log_configuration": {"driver": "awsfirelens", "options": {"Name": "datadog"}}},
+		"containers": [{"name": "log-router", "image": "amazon/aws-for-fluent-bit:stable", "type": "log_router"}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	definitions, _, err := buildTaskContainerDefinitions(testAppContainer(), config, nil, "512", "1024")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if definitions[0].LogConfiguration == nil || definitions[0].LogConfiguration.LogDriver != ecsTypes.LogDriverAwsfirelens {
+		t.Errorf("app log configuration = %+v, want awsfirelens", definitions[0].LogConfiguration)
+	}
+	router := definitions[1].FirelensConfiguration
+	if router == nil || router.Type != ecsTypes.FirelensConfigurationTypeFluentbit {
+		t.Errorf("router firelens configuration = %+v, want fluentbit", router)
+	}
+}
+
+// TestBuildTaskContainerDefinitions_EfsVolumes mounts efs volumes with a path
+// into the app container and lets sidecars mount them too.
+func TestBuildTaskContainerDefinitions_EfsVolumes(t *testing.T) {
+	config, err := parseTaskContainersConfig([]byte(`{
+		"efs_volumes": [{"name": "uploads", "path": "/var/uploads"}, {"name": "cache"}],
+		"containers": [{"name": "backup", "image": "x", "mount_points": [{"volume": "uploads", "path": "/uploads", "read_only": true}]}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	definitions, volumes, err := buildTaskContainerDefinitions(testAppContainer(), config, nil, "512", "1024")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 2 || aws.ToString(volumes[0].Name) != "uploads" || aws.ToString(volumes[1].Name) != "cache" {
+		t.Fatalf("volumes = %+v, want uploads and cache", volumes)
+	}
+	app := definitions[0]
+	if len(app.MountPoints) != 1 || aws.ToString(app.MountPoints[0].ContainerPath) != "/var/uploads" ||
+		aws.ToString(app.MountPoints[0].SourceVolume) != "uploads" {
+		t.Errorf("app MountPoints = %+v, want uploads at /var/uploads", app.MountPoints)
+	}
+	if len(definitions[1].MountPoints) != 1 {
+		t.Errorf("backup MountPoints = %+v, want uploads", definitions[1].MountPoints)
+	}
+}
@@ -68,10 +68,20 @@ func (u *UpdateAwsStaticSiteDomains) Run(parameters map[string]interface{}, logs
 		return parameters, err
 	}
 
+	var previousDomains []string
+	if distributionConfigOutput.DistributionConfig.Aliases != nil {
+		previousDomains = distributionConfigOutput.DistributionConfig.Aliases.Items
+	}
+	target, err := getCloudfrontAliasTarget(cloudfrontClient, cloudfrontDistributionId)
+	if err != nil {
+		return parameters, err
+	}
+
 	var domains []string
 	if len(domainsA) == 0 {
 		//delete domains from deployment
 		io.WriteString(logsWriter, fmt.Sprintf("Deleting domains for static site\n"))
+		deleteAliasRecords(parameters, previousDomains, target, logsWriter)
 		err = deleteDomainsFromCloudfront(cloudfrontClient, distributionConfigOutput, cloudfrontDistributionId, logsWriter)
 		if err != nil {
 			return parameters, err
@@ -121,6 +131,9 @@ func (u *UpdateAwsStaticSiteDomains) Run(parameters map[string]interface{}, logs
 		return parameters, err
 	}
 
+	upsertAliasRecords(parameters, domains, target, logsWriter)
+	deleteAliasRecords(parameters, getRemovedDomains(previousDomains, domains), target, logsWriter)
+
 	err = invalidateCloudfrontDistribution(parameters, cloudfrontClient, cloudfrontDistributionId, logsWriter)
 	if err != nil {
 		return parameters, err
@@ -128,3 +141,18 @@ func (u *UpdateAwsStaticSiteDomains) Run(parameters map[string]interface{}, logs
 
 	return parameters, err
 }
+
+// getRemovedDomains are the previous domains not in domains.
+func getRemovedDomains(previousDomains, domains []string) []string {
+	current := make(map[string]bool)
+	for _, domain := range domains {
+		current[normalizeDnsName(domain)] = true
+	}
+	var removed []string
+	for _, domain := range previousDomains {
+		if !current[normalizeDnsName(domain)] {
+			removed = append(removed, domain)
+		}
+	}
+	return removed
+}
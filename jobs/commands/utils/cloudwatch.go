@@ -40,3 +40,13 @@ func GetApplicationLogStreamPrefix(parameters map[string]interface{}) (string, e
 
 	return fmt.Sprintf("%s/%s", "application", buildIDString), err
 }
+
+func GetSidecarLogStreamPrefix(parameters map[string]interface{}) (string, error) {
+	//sidecar/<buildId>
+	buildIDString, err := jobTypes.GetParameterValue[string](parameters, parameters_enums.BuildID)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/%s", "sidecar", buildIDString), err
+}
@@ -76,6 +76,11 @@ func (v *VerifyAcmCertificate) Run(parameters map[string]interface{}, logsWriter
 		}
 	}
 
+	err = upsertAcmValidationRecords(parameters, acmClient, certificateArn, logsWriter)
+	if err != nil {
+		return parameters, err
+	}
+
 	io.WriteString(logsWriter, fmt.Sprintf("Waiting for certificate to be validated.....Please wait.\n"))
 	newCertificateValidatedWaiter := acm.NewCertificateValidatedWaiter(acmClient)
 	err = newCertificateValidatedWaiter.Wait(context.TODO(), &acm.DescribeCertificateInput{CertificateArn: aws.String(certificateArn)},
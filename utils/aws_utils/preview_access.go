@@ -0,0 +1,75 @@
+package aws_utils
+
+// preview_access.go holds the Basic auth primitives protecting previews. Shared
+// by the preview deploy commands and the in-process preview deploy, so both
+// generate credentials and check them at the edge the same way.
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+)
+
+// PreviewAccessUsername is the user name of every preview; the password is
+// generated per preview.
+const PreviewAccessUsername = "preview"
+
+const previewAccessPasswordBytes = 18
+
+// NewPreviewAccessPassword returns a random, url safe password.
+func NewPreviewAccessPassword() (string, error) {
+	b := make([]byte, previewAccessPasswordBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating preview password: %s", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// GetBasicAuthorization returns the Authorization header value for the
+// credentials.
+func GetBasicAuthorization(username, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+// GetBasicAuthViewerRequestStatement is a CloudFront viewer-request function
+// statement answering 401 unless the viewer sends authorization. Plain http
+// is redirected to https first, so the browser never sends the credentials
+// in the clear. It uses the buildQS helper of the function.
+func GetBasicAuthViewerRequestStatement(authorization string) string {
+	return fmt.Sprintf(`if (request.headers["cloudfront-forwarded-proto"] && request.headers["cloudfront-forwarded-proto"].value == "http" && request.headers["host"]) {
+        return {
+            statusCode: 301,
+            statusDescription: 'Moved Permanently',
+            headers: {
+                'location': { value: 'https://' + request.headers["host"].value + request.uri + buildQS(request.querystring) }
+            }
+        };
+    }
+    if (!request.headers["authorization"] || request.headers["authorization"].value !== '%s') {
+        return {
+            statusCode: 401,
+            statusDescription: 'Unauthorized',
+            headers: {
+                'www-authenticate': { value: 'Basic realm="Preview", charset="UTF-8"' },
+                'cache-control': { value: 'no-store' }
+            }
+        };
+    }`, authorization)
+}
+
+// GetBasicAuthViewerRequestFunctionCode is a viewer-request function doing
+// only the Basic auth check, for previews that need no other rewrites.
+func GetBasicAuthViewerRequestFunctionCode(authorization string) string {
+	return fmt.Sprintf(`function buildQS(querystring) {
+    var qs = Object.keys(querystring).map(function(k) {
+        return k + '=' + querystring[k].value;
+    }).join('&');
+    return qs ? '?' + qs : '';
+}
+
+function handler(event) {
+    var request = event.request;
+    %s
+    return request;
+}`, GetBasicAuthViewerRequestStatement(authorization))
+}
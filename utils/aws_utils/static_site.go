@@ -24,7 +24,9 @@ import (
 )
 
 // CreateCachePolicy creates a CloudFront cache policy (forwards the
-// CloudFront-Forwarded-Proto header; long min TTL) and returns its id.
+// CloudFront-Forwarded-Proto header; long min TTL) and returns its id. The
+// normalized Accept-Encoding is part of the cache key, so a compressed
+// response is never served to a viewer that can't decode it.
 func CreateCachePolicy(cachePolicyName string, cloudFrontClient *cloudfront.Client) (*string, error) {
 	//can be used to forward any other cloudfront specific headers
 	cachePolicyConfig := &cloudfrontTypes.CachePolicyConfig{
@@ -86,17 +88,36 @@ func CreateOriginAccessControl(name string, cloudFrontClient *cloudfront.Client)
 
 // UploadToS3 uploads a local directory tree to an S3 bucket.
 func UploadToS3(directory, s3Region, s3Bucket string, s3Client *s3.Client, logsWriter io.Writer) error {
+	return UploadToS3WithPrefix(directory, s3Region, s3Bucket, "", s3Client, logsWriter)
+}
+
+// UploadToS3WithPrefix uploads a local directory tree under a key prefix
+// (e.g. builds/<buildID>/) of an S3 bucket. It returns an error if any file
+// failed to upload.
+func UploadToS3WithPrefix(directory, s3Region, s3Bucket, keyPrefix string, s3Client *s3.Client, logsWriter io.Writer) error {
 	uploader, err := awsS3Uploads.NewUploader(s3Region, s3Bucket, s3Client)
 	if err != nil {
 		return err
 	}
-	err = uploader.UploadDirectory(directory, logsWriter)
+	err = uploader.UploadDirectoryWithPrefix(directory, keyPrefix, logsWriter)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// SyncToS3 makes a key prefix of an S3 bucket mirror a local directory
+// tree, copying files unchanged since baseKeyPrefix (empty for none) inside
+// S3 instead of uploading them, and deleting objects not in the directory.
+func SyncToS3(directory, s3Region, s3Bucket, keyPrefix, baseKeyPrefix string, options awsS3Uploads.SyncOptions, s3Client *s3.Client,
+	logsWriter io.Writer) (awsS3Uploads.SyncResult, error) {
+	uploader, err := awsS3Uploads.NewUploader(s3Region, s3Bucket, s3Client)
+	if err != nil {
+		return awsS3Uploads.SyncResult{}, err
+	}
+	return uploader.SyncDirectory(directory, keyPrefix, baseKeyPrefix, options, logsWriter)
+}
+
 func bucketExists(s3Client *s3.Client, s3Bucket string) bool {
 	_, err := s3Client.HeadBucket(context.TODO(), &s3.HeadBucketInput{
 		Bucket: aws.String(s3Bucket),
@@ -154,6 +175,8 @@ func CreateDefaultCacheBehavior(bucketLocation, cachePolicyId *string) *cloudfro
 	}
 
 	defaultCacheBehavior := &cloudfrontTypes.DefaultCacheBehavior{
+		//objects without a precompressed variant are compressed at the edge
+		Compress:             aws.Bool(true),
 		TargetOriginId:       bucketLocation,
 		ViewerProtocolPolicy: cloudfrontTypes.ViewerProtocolPolicyAllowAll,
 		AllowedMethods:       allowedMethods,
@@ -162,10 +185,13 @@ func CreateDefaultCacheBehavior(bucketLocation, cachePolicyId *string) *cloudfro
 	return defaultCacheBehavior
 }
 
-func listAllS3Objects(s3Client *s3.Client, bucketName string) ([]s3Types.Object, error) {
+func listAllS3Objects(s3Client *s3.Client, bucketName, prefix string) ([]s3Types.Object, error) {
 	params := &s3.ListObjectsV2Input{
 		Bucket: aws.String(bucketName),
 	}
+	if len(prefix) > 0 {
+		params.Prefix = aws.String(prefix)
+	}
 
 	listObjectsPaginator := s3.NewListObjectsV2Paginator(s3Client, params)
 
@@ -186,7 +212,13 @@ func listAllS3Objects(s3Client *s3.Client, bucketName string) ([]s3Types.Object,
 
 // DeleteAllS3Files empties an S3 bucket (batched deletes, 9000 at a time).
 func DeleteAllS3Files(s3Client *s3.Client, bucketName string) error {
-	allS3Objects, err := listAllS3Objects(s3Client, bucketName)
+	return DeleteS3FilesWithPrefix(s3Client, bucketName, "")
+}
+
+// DeleteS3FilesWithPrefix deletes every object under a key prefix of a
+// bucket; an empty prefix empties the bucket.
+func DeleteS3FilesWithPrefix(s3Client *s3.Client, bucketName, prefix string) error {
+	allS3Objects, err := listAllS3Objects(s3Client, bucketName, prefix)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,380 @@
+package dependency_cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The dependency cache keeps package manager caches in Docker named volumes
+// shared by every job on the runner, keyed by the package manager and a hash
+// of the lockfiles. Shared volumes are written once and then only read:
+// a job gets its own volume seeded with a copy of the shared one, and the
+// first job to miss a key saves its volume back once dependencies are
+// installed. Volumes are evicted least recently used first to stay under a
+// disk budget.
+//
+// Usage is tracked in memory, so a single runner is assumed per Docker host.
+const (
+	volumeLabel              = "io.deployment.dependency-cache"
+	packageManagerLabel      = "io.deployment.dependency-cache.package-manager"
+	volumeNamePrefix         = "dependency-cache-"
+	defaultBudgetBytes       = 20 * 1024 * 1024 * 1024 // 20 GB
+	budgetBytesEnvVar        = "DEPENDENCY_CACHE_BUDGET_BYTES"
+	keyHashLength            = 16
+	maxLockfileSearchDepth   = 3
+	volumeOperationTimeout   = 15 * time.Minute
+	completeMarker           = ".dependency-cache-complete"
+	incompleteCopyExitStatus = 3
+)
+
+var errIncompleteVolume = errors.New("the cached volume is incomplete")
+
+// Key identifies a cache: the same package manager and toolchain installing
+// the same lockfiles produce the same cache.
+type Key struct {
+	PackageManager string
+	Hash           string
+}
+
+func (k Key) volumeName() string {
+	return fmt.Sprintf("%s%s-%s", volumeNamePrefix, k.PackageManager, k.Hash)
+}
+
+// GetKey hashes the toolchain and the lockfiles that exist; ok is false
+// when there are none, as there's nothing to key the cache on. Lockfile
+// paths are relative to directory.
+func GetKey(packageManager, toolchain, directory string, lockfiles []string) (key Key, ok bool, err error) {
+	hash := sha256.New()
+	io.WriteString(hash, packageManager+"\n"+toolchain+"\n")
+	sort.Strings(lockfiles)
+	for _, lockfile := range lockfiles {
+		content, err := os.ReadFile(filepath.Join(directory, lockfile))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return Key{}, false, err
+		}
+		ok = true
+		io.WriteString(hash, lockfile+"\n"+strconv.Itoa(len(content))+"\n")
+		hash.Write(content)
+	}
+	if !ok {
+		return Key{}, false, nil
+	}
+	return Key{PackageManager: packageManager, Hash: hex.EncodeToString(hash.Sum(nil))[:keyHashLength]}, true, nil
+}
+
+// FindLockfiles returns the paths, relative to directory, of files named
+// like a lockfile within a few levels, for directories holding several
+// repositories. Dependency and VCS directories are skipped.
+func FindLockfiles(directory string, names []string) ([]string, error) {
+	isLockfile := make(map[string]bool)
+	for _, name := range names {
+		isLockfile[name] = true
+	}
+	var lockfiles []string
+	err := filepath.WalkDir(directory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(directory, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != directory && (strings.HasPrefix(d.Name(), ".") || d.Name() == "node_modules" || d.Name() == "vendor" ||
+				strings.Count(relativePath, string(filepath.Separator)) >= maxLockfileSearchDepth-1) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isLockfile[d.Name()] {
+			lockfiles = append(lockfiles, relativePath)
+		}
+		return nil
+	})
+	return lockfiles, err
+}
+
+type cachedVolume struct {
+	Name           string
+	PackageManager string
+	SizeBytes      int64
+	LastUsedAt     time.Time
+	inUse          int
+}
+
+// volumeStore is the Docker side of the cache, swapped out in tests.
+type volumeStore interface {
+	listCachedVolumes(ctx context.Context) ([]cachedVolume, error)
+	createVolume(ctx context.Context, name string, labels map[string]string) error
+	removeVolume(ctx context.Context, name string) error
+	// copyVolume copies one volume's content into another; with
+	// requireComplete it fails with errIncompleteVolume unless the source
+	// has the complete marker, and otherwise it adds the marker.
+	copyVolume(ctx context.Context, from, to string, requireComplete bool) error
+	getVolumeSize(ctx context.Context, name string) (int64, error)
+}
+
+type Manager struct {
+	sync.Mutex
+	store       volumeStore
+	budgetBytes int64
+	loaded      bool
+	volumes     map[string]*cachedVolume
+	populating  map[string]bool
+}
+
+// DefaultManager is the runner-wide cache.
+var DefaultManager = NewManager(dockerVolumeStore{}, resolveBudgetBytes())
+
+func NewManager(store volumeStore, budgetBytes int64) *Manager {
+	return &Manager{
+		store:       store,
+		budgetBytes: budgetBytes,
+		volumes:     make(map[string]*cachedVolume),
+		populating:  make(map[string]bool),
+	}
+}
+
+// resolveBudgetBytes reads the disk budget override; 0 turns the cache
+// off.
+func resolveBudgetBytes() int64 {
+	if v := os.Getenv(budgetBytesEnvVar); v != "" {
+		if parsed, err := strconv.ParseInt(v, 10, 64); err == nil && parsed >= 0 {
+			return parsed
+		}
+	}
+	return defaultBudgetBytes
+}
+
+// load picks up the volumes of earlier runs of the runner, as if they were
+// last used when created.
+func (m *Manager) load(ctx context.Context) error {
+	if m.loaded {
+		return nil
+	}
+	volumes, err := m.store.listCachedVolumes(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range volumes {
+		if _, exists := m.volumes[volumes[i].Name]; !exists {
+			m.volumes[volumes[i].Name] = &volumes[i]
+		}
+	}
+	m.loaded = true
+	return nil
+}
+
+func (m *Manager) getUsedBytes() int64 {
+	var usedBytes int64
+	for _, v := range m.volumes {
+		usedBytes += v.SizeBytes
+	}
+	return usedBytes
+}
+
+// Lease is a job's use of a cache key. Its methods are no-ops when the
+// cache is off or the job has nothing to key it on.
+type Lease struct {
+	manager  *Manager
+	key      Key
+	hit      bool
+	populate bool
+	released bool
+}
+
+// Acquire looks the key up. A hit can be restored into the job's volume; on
+// a miss the job populates the cache unless another job already is.
+func (m *Manager) Acquire(key Key, logsWriter io.Writer) *Lease {
+	if m.budgetBytes == 0 {
+		return &Lease{}
+	}
+	m.Lock()
+	defer m.Unlock()
+	lease := &Lease{manager: m, key: key}
+	if err := m.load(context.Background()); err != nil {
+		io.WriteString(logsWriter, fmt.Sprintf("Not using the dependency cache: %s\n", err))
+		return &Lease{}
+	}
+	name := key.volumeName()
+	if v, exists := m.volumes[name]; exists {
+		v.inUse++
+		v.LastUsedAt = time.Now()
+		lease.hit = true
+		io.WriteString(logsWriter, fmt.Sprintf("Dependency cache hit for %s %s (%s)\n", key.PackageManager, key.Hash,
+			formatBytes(v.SizeBytes)))
+	} else if !m.populating[name] {
+		m.populating[name] = true
+		lease.populate = true
+		io.WriteString(logsWriter, fmt.Sprintf("Dependency cache miss for %s %s\n", key.PackageManager, key.Hash))
+	} else {
+		io.WriteString(logsWriter, fmt.Sprintf("Dependency cache for %s %s is being saved by another job\n",
+			key.PackageManager, key.Hash))
+	}
+	return lease
+}
+
+// Restore copies the cached dependencies into the job's volume on a hit.
+// A volume left incomplete by an interrupted save is removed, so the next
+// job repopulates it.
+func (l *Lease) Restore(jobVolume string, logsWriter io.Writer) error {
+	if !l.hit {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), volumeOperationTimeout)
+	defer cancel()
+	name := l.key.volumeName()
+	err := l.manager.store.copyVolume(ctx, name, jobVolume, true)
+	if errors.Is(err, errIncompleteVolume) {
+		io.WriteString(logsWriter, "Dependency cache volume is incomplete, installing without it\n")
+		l.manager.Lock()
+		defer l.manager.Unlock()
+		if v, exists := l.manager.volumes[name]; exists {
+			v.inUse--
+			delete(l.manager.volumes, name)
+		}
+		l.hit = false
+		_ = l.manager.store.removeVolume(ctx, name)
+		return nil
+	}
+	return err
+}
+
+// Save stores the job's volume as the key's cache when the job populates it,
+// then evicts volumes over the budget.
+func (l *Lease) Save(jobVolume string, logsWriter io.Writer) error {
+	if !l.populate {
+		return nil
+	}
+	m := l.manager
+	ctx, cancel := context.WithTimeout(context.Background(), volumeOperationTimeout)
+	defer cancel()
+	name := l.key.volumeName()
+	//a leftover of an interrupted save
+	_ = m.store.removeVolume(ctx, name)
+	err := m.store.createVolume(ctx, name, map[string]string{
+		volumeLabel:         "true",
+		packageManagerLabel: l.key.PackageManager,
+	})
+	if err != nil {
+		return err
+	}
+	if err = m.store.copyVolume(ctx, jobVolume, name, false); err != nil {
+		_ = m.store.removeVolume(ctx, name)
+		return err
+	}
+	sizeBytes, err := m.store.getVolumeSize(ctx, name)
+	if err != nil {
+		//an unmeasured volume can't be kept under the budget
+		_ = m.store.removeVolume(ctx, name)
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	delete(m.populating, name)
+	l.populate = false
+	//held until released, so it isn't evicted right away
+	m.volumes[name] = &cachedVolume{
+		Name:           name,
+		PackageManager: l.key.PackageManager,
+		SizeBytes:      sizeBytes,
+		LastUsedAt:     time.Now(),
+		inUse:          1,
+	}
+	l.hit = true
+	io.WriteString(logsWriter, fmt.Sprintf("Saved dependency cache for %s %s (%s)\n", l.key.PackageManager,
+		l.key.Hash, formatBytes(sizeBytes)))
+	m.evict(ctx, logsWriter)
+	return nil
+}
+
+// Release ends the job's use of the key.
+func (l *Lease) Release() {
+	if l.manager == nil || l.released {
+		return
+	}
+	l.released = true
+	m := l.manager
+	m.Lock()
+	defer m.Unlock()
+	name := l.key.volumeName()
+	if l.populate {
+		delete(m.populating, name)
+	}
+	if v, exists := m.volumes[name]; exists && l.hit {
+		v.inUse--
+	}
+}
+
+// getVolumesToEvict are the least recently used volumes not in use whose
+// removal brings usage under the budget.
+func getVolumesToEvict(volumes []*cachedVolume, budgetBytes int64) []*cachedVolume {
+	var usedBytes int64
+	var candidates []*cachedVolume
+	for _, v := range volumes {
+		usedBytes += v.SizeBytes
+		if v.inUse == 0 {
+			candidates = append(candidates, v)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].LastUsedAt.Before(candidates[j].LastUsedAt)
+	})
+	var evict []*cachedVolume
+	for _, v := range candidates {
+		if usedBytes <= budgetBytes {
+			break
+		}
+		evict = append(evict, v)
+		usedBytes -= v.SizeBytes
+	}
+	return evict
+}
+
+// evict is called with the manager locked.
+func (m *Manager) evict(ctx context.Context, logsWriter io.Writer) {
+	var volumes []*cachedVolume
+	for _, v := range m.volumes {
+		volumes = append(volumes, v)
+	}
+	for _, v := range getVolumesToEvict(volumes, m.budgetBytes) {
+		if err := m.store.removeVolume(ctx, v.Name); err != nil {
+			io.WriteString(logsWriter, fmt.Sprintf("Error evicting dependency cache volume %s: %s\n", v.Name, err))
+			continue
+		}
+		delete(m.volumes, v.Name)
+		io.WriteString(logsWriter, fmt.Sprintf("Evicted dependency cache volume %s (%s, last used %s)\n", v.Name,
+			formatBytes(v.SizeBytes), v.LastUsedAt.Format(time.RFC3339)))
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Dependency cache uses %s of %s in %d volumes\n",
+		formatBytes(m.getUsedBytes()), formatBytes(m.budgetBytes), len(m.volumes)))
+}
+
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exponent := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exponent])
+}
@@ -0,0 +1,219 @@
+package dependency_cache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeVolumeStore struct {
+	sync.Mutex
+	sizes      map[string]int64
+	incomplete map[string]bool
+	copies     []string
+}
+
+func newFakeVolumeStore() *fakeVolumeStore {
+	return &fakeVolumeStore{sizes: make(map[string]int64), incomplete: make(map[string]bool)}
+}
+
+func (f *fakeVolumeStore) listCachedVolumes(ctx context.Context) ([]cachedVolume, error) {
+	f.Lock()
+	defer f.Unlock()
+	var volumes []cachedVolume
+	for name, size := range f.sizes {
+		volumes = append(volumes, cachedVolume{Name: name, SizeBytes: size})
+	}
+	return volumes, nil
+}
+
+func (f *fakeVolumeStore) createVolume(ctx context.Context, name string, labels map[string]string) error {
+	f.Lock()
+	defer f.Unlock()
+	f.sizes[name] = 0
+	return nil
+}
+
+func (f *fakeVolumeStore) removeVolume(ctx context.Context, name string) error {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.sizes, name)
+	return nil
+}
+
+func (f *fakeVolumeStore) copyVolume(ctx context.Context, from, to string, requireComplete bool) error {
+	f.Lock()
+	defer f.Unlock()
+	if requireComplete && f.incomplete[from] {
+		return errIncompleteVolume
+	}
+	f.copies = append(f.copies, from+">"+to)
+	return nil
+}
+
+func (f *fakeVolumeStore) getVolumeSize(ctx context.Context, name string) (int64, error) {
+	return 100, nil
+}
+
+func writeLockfiles(t *testing.T, directory string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(directory, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGetKey(t *testing.T) {
+	directory := t.TempDir()
+	lockfiles := []string{"package-lock.json", "npm-shrinkwrap.json"}
+	if _, ok, err := GetKey("npm", "node:20", directory, lockfiles); ok || err != nil {
+		t.Errorf("ok = %v, %v, want no key without lockfiles", ok, err)
+	}
+	writeLockfiles(t, directory, map[string]string{"package-lock.json": "v1"})
+	key, ok, err := GetKey("npm", "node:20", directory, lockfiles)
+	if err != nil || !ok || key.PackageManager != "npm" || len(key.Hash) != keyHashLength {
+		t.Fatalf("key = %+v, %v, %v", key, ok, err)
+	}
+	if !strings.HasPrefix(key.volumeName(), volumeNamePrefix+"npm-") {
+		t.Errorf("volume name = %s", key.volumeName())
+	}
+	otherToolchain, _, _ := GetKey("npm", "node:22", directory, lockfiles)
+	writeLockfiles(t, directory, map[string]string{"package-lock.json": "v2"})
+	otherLockfile, _, _ := GetKey("npm", "node:20", directory, lockfiles)
+	if otherToolchain == key || otherLockfile == key {
+		t.Error("expected the toolchain and lockfile content in the key")
+	}
+}
+
+func TestFindLockfiles(t *testing.T) {
+	directory := t.TempDir()
+	writeLockfiles(t, directory, map[string]string{
+		"api/go.sum":                           "",
+		"web/package-lock.json":                "",
+		"web/node_modules/x/package-lock.json": "",
+		".git/go.sum":                          "",
+		"a/b/c/go.sum":                         "",
+	})
+	lockfiles, err := FindLockfiles(directory, []string{"go.sum", "package-lock.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(lockfiles)
+	if want := []string{"api/go.sum", "web/package-lock.json"}; !reflect.DeepEqual(lockfiles, want) {
+		t.Errorf("lockfiles = %v, want %v", lockfiles, want)
+	}
+}
+
+func TestManager_MissSaveHit(t *testing.T) {
+	store := newFakeVolumeStore()
+	manager := NewManager(store, 1000)
+	key := Key{PackageManager: "npm", Hash: "abc"}
+	var logs strings.Builder
+
+	first := manager.Acquire(key, &logs)
+	if first.hit || !first.populate {
+		t.Fatalf("first lease = %+v, want a populating miss", first)
+	}
+	//a concurrent job with the same lockfile neither restores nor saves
+	second := manager.Acquire(key, &logs)
+	if second.hit || second.populate {
+		t.Errorf("second lease = %+v, want neither", second)
+	}
+	if err := first.Save("job-1", &logs); err != nil {
+		t.Fatal(err)
+	}
+	first.Release()
+	second.Release()
+
+	third := manager.Acquire(key, &logs)
+	if !third.hit {
+		t.Fatalf("third lease = %+v, want a hit", third)
+	}
+	if err := third.Restore("job-3", &logs); err != nil {
+		t.Fatal(err)
+	}
+	third.Release()
+	want := []string{"job-1>" + key.volumeName(), key.volumeName() + ">job-3"}
+	if !reflect.DeepEqual(store.copies, want) {
+		t.Errorf("copies = %v, want %v", store.copies, want)
+	}
+	if v := manager.volumes[key.volumeName()]; v.inUse != 0 {
+		t.Errorf("inUse = %d after release", v.inUse)
+	}
+	if !strings.Contains(logs.String(), "Dependency cache uses 100 B of 1000 B in 1 volumes") {
+		t.Errorf("logs = %s", logs.String())
+	}
+}
+
+func TestManager_IncompleteVolume(t *testing.T) {
+	store := newFakeVolumeStore()
+	key := Key{PackageManager: "pip", Hash: "def"}
+	store.sizes[key.volumeName()] = 50
+	store.incomplete[key.volumeName()] = true
+	manager := NewManager(store, 1000)
+
+	lease := manager.Acquire(key, &strings.Builder{})
+	if !lease.hit {
+		t.Fatal("expected a volume of an earlier run to be a hit")
+	}
+	if err := lease.Restore("job", &strings.Builder{}); err != nil {
+		t.Fatal(err)
+	}
+	lease.Release()
+	if _, exists := store.sizes[key.volumeName()]; exists {
+		t.Error("expected the incomplete volume to be removed")
+	}
+	if lease = manager.Acquire(key, &strings.Builder{}); !lease.populate {
+		t.Error("expected the next job to repopulate")
+	}
+}
+
+func TestManager_Disabled(t *testing.T) {
+	lease := NewManager(newFakeVolumeStore(), 0).Acquire(Key{PackageManager: "npm", Hash: "abc"}, &strings.Builder{})
+	if lease.hit || lease.populate {
+		t.Errorf("lease = %+v, want a no-op", lease)
+	}
+	if err := lease.Save("job", &strings.Builder{}); err != nil {
+		t.Error(err)
+	}
+	lease.Release()
+}
+
+func TestGetVolumesToEvict(t *testing.T) {
+	now := time.Now()
+	volumes := []*cachedVolume{
+		{Name: "recent", SizeBytes: 40, LastUsedAt: now},
+		{Name: "oldest-in-use", SizeBytes: 40, LastUsedAt: now.Add(-3 * time.Hour), inUse: 1},
+		{Name: "old", SizeBytes: 40, LastUsedAt: now.Add(-2 * time.Hour)},
+		{Name: "older", SizeBytes: 40, LastUsedAt: now.Add(-1 * time.Hour)},
+	}
+	var names []string
+	for _, v := range getVolumesToEvict(volumes, 90) {
+		names = append(names, v.Name)
+	}
+	if want := []string{"old", "older"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("evict = %v, want %v", names, want)
+	}
+	if evict := getVolumesToEvict(volumes, 160); len(evict) != 0 {
+		t.Errorf("evict = %v, want none under the budget", evict)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	cases := map[int64]string{512: "512 B", 1536: "1.5 KB", 20 * 1024 * 1024 * 1024: "20.0 GB"}
+	for bytes, want := range cases {
+		if formatted := formatBytes(bytes); formatted != want {
+			t.Errorf("%d: %s, want %s", bytes, formatted, want)
+		}
+	}
+}
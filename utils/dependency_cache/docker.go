@@ -0,0 +1,172 @@
+package dependency_cache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/moby/moby/client"
+)
+
+// copyImage runs the copies between volumes. It's small and has the
+// shell and cp the copy script needs.
+const (
+	copyImage              = "busybox:1.36.1"
+	copyContainerPidsLimit = int64(64)
+)
+
+type dockerVolumeStore struct {
+}
+
+func (d dockerVolumeStore) listCachedVolumes(ctx context.Context) ([]cachedVolume, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+	listResponse, err := cli.VolumeList(ctx, volume.ListOptions{Filters: filters.NewArgs(filters.Arg("label", volumeLabel))})
+	if err != nil {
+		return nil, err
+	}
+	sizes, err := getVolumeSizes(ctx, cli)
+	if err != nil {
+		return nil, err
+	}
+	var volumes []cachedVolume
+	for _, v := range listResponse.Volumes {
+		createdAt, _ := time.Parse(time.RFC3339, v.CreatedAt)
+		volumes = append(volumes, cachedVolume{
+			Name:           v.Name,
+			PackageManager: v.Labels[packageManagerLabel],
+			SizeBytes:      sizes[v.Name],
+			LastUsedAt:     createdAt,
+		})
+	}
+	return volumes, nil
+}
+
+// getVolumeSizes uses the disk usage API, which measures every volume.
+func getVolumeSizes(ctx context.Context, cli *client.Client) (map[string]int64, error) {
+	diskUsage, err := cli.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.VolumeObject}})
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]int64)
+	for _, v := range diskUsage.Volumes {
+		if v.UsageData != nil && v.UsageData.Size > 0 {
+			sizes[v.Name] = v.UsageData.Size
+		}
+	}
+	return sizes, nil
+}
+
+func (d dockerVolumeStore) createVolume(ctx context.Context, name string, labels map[string]string) error {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+	_, err = cli.VolumeCreate(ctx, volume.CreateOptions{Name: name, Labels: labels})
+	return err
+}
+
+// removeVolume doesn't force, so a volume a container still mounts stays.
+func (d dockerVolumeStore) removeVolume(ctx context.Context, name string) error {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+	return cli.VolumeRemove(ctx, name, false)
+}
+
+func (d dockerVolumeStore) getVolumeSize(ctx context.Context, name string) (int64, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return 0, err
+	}
+	defer cli.Close()
+	sizes, err := getVolumeSizes(ctx, cli)
+	if err != nil {
+		return 0, err
+	}
+	return sizes[name], nil
+}
+
+func getCopyScript(requireComplete bool) string {
+	if requireComplete {
+		return fmt.Sprintf("[ -f /from/%s ] || exit %d; cp -a /from/. /to/ && rm -f /to/%s",
+			completeMarker, incompleteCopyExitStatus, completeMarker)
+	}
+	//the marker is written last, so a volume without it was interrupted
+	return fmt.Sprintf("cp -a /from/. /to/ && touch /to/%s", completeMarker)
+}
+
+// copyVolume runs the copy in a throwaway container without network,
+// mounting the source read-only.
+func (d dockerVolumeStore) copyVolume(ctx context.Context, from, to string, requireComplete bool) error {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+	if err = pullCopyImage(ctx, cli); err != nil {
+		return err
+	}
+	pidsLimit := copyContainerPidsLimit
+	resp, err := cli.ContainerCreate(ctx, &container.Config{
+		Image: copyImage,
+		Cmd:   []string{"sh", "-c", getCopyScript(requireComplete)},
+	}, &container.HostConfig{
+		Mounts: []mount.Mount{
+			{Type: mount.TypeVolume, Source: from, Target: "/from", ReadOnly: true},
+			{Type: mount.TypeVolume, Source: to, Target: "/to"},
+		},
+		NetworkMode: "none",
+		Resources: container.Resources{
+			PidsLimit: &pidsLimit,
+		},
+	}, nil, nil, "")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = cli.ContainerRemove(context.Background(), resp.ID, container.RemoveOptions{Force: true})
+	}()
+	if err = cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return err
+	}
+	waitCh, errCh := cli.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+	select {
+	case err = <-errCh:
+		return err
+	case waitResponse := <-waitCh:
+		switch waitResponse.StatusCode {
+		case 0:
+			return nil
+		case incompleteCopyExitStatus:
+			return errIncompleteVolume
+		}
+		return fmt.Errorf("copying volume %s to %s exited with code %d", from, to, waitResponse.StatusCode)
+	}
+}
+
+func pullCopyImage(ctx context.Context, cli *client.Client) error {
+	if _, _, err := cli.ImageInspectWithRaw(ctx, copyImage); err == nil {
+		return nil
+	}
+	reader, err := cli.ImagePull(ctx, fmt.Sprintf("docker.io/library/%s", copyImage), image.PullOptions{})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	_, err = io.Copy(io.Discard, reader)
+	return err
+}
@@ -0,0 +1,69 @@
+package aws_s3
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	EncodingBrotli = "br"
+	EncodingGzip   = "gzip"
+)
+
+// PrecompressibleExtensions are the text formats uploaded with compressed
+// variants when precompression is on. Every such file gets its variants
+// whatever its size, since the viewer-request function rewrites to them by
+// extension alone.
+var PrecompressibleExtensions = []string{".html", ".htm", ".css", ".js", ".mjs", ".json", ".map", ".svg", ".xml",
+	".txt", ".wasm", ".webmanifest", ".ico"}
+
+// GetPrecompressedKeySuffix is what's appended to a key for its variant in
+// an encoding, e.g. app.js.br.
+func GetPrecompressedKeySuffix(encoding string) (string, error) {
+	switch encoding {
+	case EncodingBrotli:
+		return ".br", nil
+	case EncodingGzip:
+		return ".gz", nil
+	}
+	return "", fmt.Errorf("unsupported encoding %s", encoding)
+}
+
+func isPrecompressible(key string) bool {
+	extension := strings.ToLower(filepath.Ext(key))
+	for _, precompressibleExtension := range PrecompressibleExtensions {
+		if extension == precompressibleExtension {
+			return true
+		}
+	}
+	return false
+}
+
+// compressTo streams r compressed into w. It's deterministic, so unchanged
+// files keep their variant's MD5 and aren't uploaded again.
+func compressTo(w io.Writer, r io.Reader, encoding string) error {
+	var writer io.WriteCloser
+	switch encoding {
+	case EncodingBrotli:
+		writer = brotli.NewWriterLevel(w, brotli.BestCompression)
+	case EncodingGzip:
+		//the header has no name or modification time
+		gzipWriter, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+		if err != nil {
+			return err
+		}
+		writer = gzipWriter
+	default:
+		return fmt.Errorf("unsupported encoding %s", encoding)
+	}
+	if _, err := io.Copy(writer, r); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
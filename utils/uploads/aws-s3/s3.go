@@ -4,11 +4,7 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"io"
-	"io/fs"
 	"os"
-	"path/filepath"
-	"strings"
-	"time"
 )
 
 type Uploader struct {
@@ -40,58 +36,17 @@ func isPathDirectory(directoryPath string) (bool, error) {
 	return true, nil
 }
 
-// we can assume that this function is called only for directory
-func (u *Uploader) uploadDirectory(directoryPath string, logsWriter io.Writer) error {
-	fileUploadDoneSignals := make([]<-chan uploadFileDoneDTO, 0)
-	abortUploadSignal := make(chan interface{})
-	root := directoryPath
-	//10 concurrent uploads sleep for 10 seconds after 10 requests for now
-	//TODO fix later
-	i := 0
-	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if !d.IsDir() {
-			//	upload if it's not directory
-			outputS3ObjectKey := strings.TrimPrefix(path, directoryPath+"/")
-			fileUploadDoneSignal := u.UploadFile(path, outputS3ObjectKey, abortUploadSignal)
-			fileUploadDoneSignals = append(fileUploadDoneSignals, fileUploadDoneSignal)
-			i++
-			if i%10 == 0 {
-				time.Sleep(10 * time.Second)
-			}
-		}
-		return err
-	})
-	if err != nil {
-		return err
-	}
-	for _, fileUploadDoneSignal := range fileUploadDoneSignals {
-		done := <-fileUploadDoneSignal
-		if done.done {
-			io.WriteString(logsWriter, fmt.Sprintf("Successfully uploaded file: %s\n", done.objectKey))
-		} else {
-			io.WriteString(logsWriter, fmt.Sprintf("Error uploading file: %s\n", done.objectKey))
-		}
-		if done.err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
 var DirectoryErr = fmt.Errorf("path is not a directory path")
 
 func (u *Uploader) UploadDirectory(directoryPath string, logsWriter io.Writer) error {
-	isDirectory, err := isPathDirectory(directoryPath)
-	if err != nil {
-		return err
-	}
-	if !isDirectory {
-		return DirectoryErr
-	}
-	return u.uploadDirectory(directoryPath, logsWriter)
+	return u.UploadDirectoryWithPrefix(directoryPath, "", logsWriter)
+}
+
+// UploadDirectoryWithPrefix syncs the directory to keyPrefix, which should
+// end with a slash (or be empty for the bucket root).
+func (u *Uploader) UploadDirectoryWithPrefix(directoryPath, keyPrefix string, logsWriter io.Writer) error {
+	_, err := u.SyncDirectory(directoryPath, keyPrefix, "", SyncOptions{}, logsWriter)
+	return err
 }
 
 func (u *Uploader) UploadFile(inputFilePath, outputS3ObjectKey string, abortUploadSignal chan interface{}) <-chan uploadFileDoneDTO {
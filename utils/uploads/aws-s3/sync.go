@@ -0,0 +1,613 @@
+package aws_s3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	syncWorkers           = 16
+	maxThrottlingRetries  = 8
+	baseThrottlingBackoff = 200 * time.Millisecond
+	maxThrottlingBackoff  = 20 * time.Second
+	//single PUTs are limited to 5GB and only they get an MD5 ETag
+	maxSyncFileSize = 5 * 1024 * 1024 * 1024
+	maxDeleteBatch  = 1000
+)
+
+type localFile struct {
+	path            string
+	key             string //relative to the key prefix
+	size            int64
+	md5             string
+	contentType     string
+	cacheControl    string
+	contentEncoding string
+	sourcePath      string //file a precompressed variant is compressed from
+}
+
+type remoteObject struct {
+	etag string
+	size int64
+}
+
+type syncAction int
+
+const (
+	syncUnchanged syncAction = iota
+	syncCopy
+	syncUpload
+)
+
+type syncOperation struct {
+	action syncAction
+	file   localFile
+}
+
+type syncPlan struct {
+	operations  []syncOperation
+	deleteKeys  []string
+	changedKeys []string
+}
+
+// SyncOptions are the headers objects are served with. They're set on
+// uploads and copies; an object already in place with the same content
+// keeps its headers.
+type SyncOptions struct {
+	// CacheControl returns the Cache-Control header for a key relative to the
+	// prefix, or empty for none.
+	CacheControl func(key string) string
+	// Precompress lists the encodings (EncodingBrotli, EncodingGzip) every
+	// precompressible file also gets a variant in.
+	Precompress []string
+	// Exclude returns true for keys that aren't uploaded, like configuration
+	// files the build leaves for the runner.
+	Exclude func(key string) bool
+}
+
+// SyncResult tells what a sync did. ChangedKeys are relative to the key
+// prefix and compared with the base prefix when there is one.
+type SyncResult struct {
+	Uploaded    int
+	Copied      int
+	Unchanged   int
+	Deleted     int
+	ChangedKeys []string
+}
+
+func isSameObject(file localFile, object remoteObject, found bool) bool {
+	return found && object.size == file.size && object.etag == file.md5
+}
+
+// planSync decides per file whether it's already in place, can be copied
+// from the base prefix inside S3, or has to be uploaded. Objects not in the
+// directory are deleted.
+func planSync(files []localFile, target, base map[string]remoteObject) syncPlan {
+	compareWith := target
+	if base != nil {
+		compareWith = base
+	}
+	var plan syncPlan
+	local := make(map[string]bool, len(files))
+	for _, file := range files {
+		local[file.key] = true
+		targetObject, inTarget := target[file.key]
+		baseObject, inBase := base[file.key]
+		switch {
+		case isSameObject(file, targetObject, inTarget):
+			plan.operations = append(plan.operations, syncOperation{action: syncUnchanged, file: file})
+		case isSameObject(file, baseObject, inBase):
+			plan.operations = append(plan.operations, syncOperation{action: syncCopy, file: file})
+		default:
+			plan.operations = append(plan.operations, syncOperation{action: syncUpload, file: file})
+		}
+		compareObject, found := compareWith[file.key]
+		if !isSameObject(file, compareObject, found) {
+			plan.changedKeys = append(plan.changedKeys, file.key)
+		}
+	}
+	for key := range target {
+		if !local[key] {
+			plan.deleteKeys = append(plan.deleteKeys, key)
+		}
+	}
+	for key := range compareWith {
+		if !local[key] {
+			plan.changedKeys = append(plan.changedKeys, key)
+		}
+	}
+	sort.Strings(plan.deleteKeys)
+	sort.Strings(plan.changedKeys)
+	return plan
+}
+
+func isThrottlingError(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "SlowDown", "ServiceUnavailable", "RequestLimitExceeded", "Throttling", "ThrottlingException",
+		"TooManyRequestsException", "RequestTimeout", "InternalError":
+		return true
+	}
+	return false
+}
+
+// getThrottlingBackoff doubles the wait per attempt up to the maximum, with
+// jitter so the workers don't retry in lockstep.
+func getThrottlingBackoff(attempt int) time.Duration {
+	backoff := maxThrottlingBackoff
+	if attempt < 16 {
+		backoff = baseThrottlingBackoff << attempt
+		if backoff > maxThrottlingBackoff {
+			backoff = maxThrottlingBackoff
+		}
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+func withThrottlingBackoff(operation func() error) error {
+	var err error
+	for attempt := 0; attempt < maxThrottlingRetries; attempt++ {
+		err = operation()
+		if err == nil || !isThrottlingError(err) {
+			return err
+		}
+		time.Sleep(getThrottlingBackoff(attempt))
+	}
+	return err
+}
+
+func getFileMd5(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := md5.New()
+	if _, err = io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// getPrecompressedVariants are a file's variants in each encoding, still to
+// be compressed. A variant keeps the file's Content-Type and Cache-Control.
+func getPrecompressedVariants(file localFile, encodings []string) ([]localFile, error) {
+	var variants []localFile
+	for _, encoding := range encodings {
+		suffix, err := GetPrecompressedKeySuffix(encoding)
+		if err != nil {
+			return nil, err
+		}
+		variant := file
+		variant.key = file.key + suffix
+		variant.path = ""
+		variant.size = 0
+		variant.md5 = ""
+		variant.contentEncoding = encoding
+		variant.sourcePath = file.path
+		variants = append(variants, variant)
+	}
+	return variants, nil
+}
+
+// compressVariant streams the variant's source file compressed into a file
+// in compressDirectory, which is then uploaded in its place.
+func compressVariant(variant *localFile, compressDirectory string) error {
+	source, err := os.Open(variant.sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	compressed, err := os.CreateTemp(compressDirectory, "variant-*")
+	if err != nil {
+		return err
+	}
+	defer compressed.Close()
+	hash := md5.New()
+	counter := &countingWriter{}
+	err = compressTo(io.MultiWriter(compressed, hash, counter), source, variant.contentEncoding)
+	if err != nil {
+		return err
+	}
+	if counter.n > maxSyncFileSize {
+		return fmt.Errorf("%s compressed is larger than 5GB", variant.sourcePath)
+	}
+	variant.path = compressed.Name()
+	variant.size = counter.n
+	variant.md5 = hex.EncodeToString(hash.Sum(nil))
+	return compressed.Close()
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// compressVariants compresses the variants through a bounded pool of
+// workers and stops handing out work after the first error.
+func compressVariants(variants []*localFile, compressDirectory string) error {
+	variantsStream := make(chan *localFile)
+	abort := make(chan interface{})
+	var abortOnce sync.Once
+	var firstErr error
+	var wg sync.WaitGroup
+	for i := 0; i < syncWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for variant := range variantsStream {
+				if err := compressVariant(variant, compressDirectory); err != nil {
+					abortOnce.Do(func() {
+						firstErr = err
+						close(abort)
+					})
+				}
+			}
+		}()
+	}
+feed:
+	for _, variant := range variants {
+		select {
+		case <-abort:
+			break feed
+		case variantsStream <- variant:
+		}
+	}
+	close(variantsStream)
+	wg.Wait()
+	return firstErr
+}
+
+// listLocalFiles lists the directory's files along with their precompressed
+// variants, which are compressed into compressDirectory.
+func listLocalFiles(directoryPath, compressDirectory string, options SyncOptions) ([]localFile, error) {
+	filesByKey := make(map[string]localFile)
+	variantKeys := make(map[string]bool)
+	err := filepath.WalkDir(directoryPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		key := filepath.ToSlash(strings.TrimPrefix(path, directoryPath+"/"))
+		if options.Exclude != nil && options.Exclude(key) {
+			return nil
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.Size() > maxSyncFileSize {
+			return fmt.Errorf("%s is larger than 5GB", path)
+		}
+		md5Hex, err := getFileMd5(path)
+		if err != nil {
+			return err
+		}
+		contentType, err := detectContentType(path)
+		if err != nil {
+			return err
+		}
+		file := localFile{
+			path:        path,
+			key:         key,
+			size:        info.Size(),
+			md5:         md5Hex,
+			contentType: contentType,
+		}
+		if options.CacheControl != nil {
+			file.cacheControl = options.CacheControl(file.key)
+		}
+		if !variantKeys[file.key] {
+			filesByKey[file.key] = file
+		}
+		if !isPrecompressible(path) || len(options.Precompress) == 0 {
+			return nil
+		}
+		variants, err := getPrecompressedVariants(file, options.Precompress)
+		if err != nil {
+			return err
+		}
+		//a variant the build already emitted is replaced by ours
+		for _, variant := range variants {
+			filesByKey[variant.key] = variant
+			variantKeys[variant.key] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var files []localFile
+	for _, file := range filesByKey {
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].key < files[j].key
+	})
+	var variants []*localFile
+	for i := range files {
+		if len(files[i].sourcePath) > 0 {
+			variants = append(variants, &files[i])
+		}
+	}
+	err = compressVariants(variants, compressDirectory)
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (u *Uploader) listRemoteObjects(keyPrefix string) (map[string]remoteObject, error) {
+	listObjectsPaginator := s3.NewListObjectsV2Paginator(u.s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(u.s3Bucket),
+		Prefix: aws.String(keyPrefix),
+	})
+	objects := make(map[string]remoteObject)
+	for listObjectsPaginator.HasMorePages() {
+		var page *s3.ListObjectsV2Output
+		err := withThrottlingBackoff(func() error {
+			var err error
+			page, err = listObjectsPaginator.NextPage(context.TODO())
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			objects[strings.TrimPrefix(aws.ToString(object.Key), keyPrefix)] = remoteObject{
+				etag: strings.Trim(aws.ToString(object.ETag), `"`),
+				size: aws.ToInt64(object.Size),
+			}
+		}
+	}
+	return objects, nil
+}
+
+func detectContentType(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	buffer := make([]byte, 512)
+	n, err := io.ReadFull(f, buffer)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return getContentTypeMetaTagForFile(path, http.DetectContentType(buffer[:n])), nil
+}
+
+func getOptionalHeader(value string) *string {
+	if len(value) == 0 {
+		return nil
+	}
+	return aws.String(value)
+}
+
+func (u *Uploader) putFile(file localFile, key string) error {
+	md5Bytes, err := hex.DecodeString(file.md5)
+	if err != nil {
+		return err
+	}
+	return withThrottlingBackoff(func() error {
+		f, err := os.Open(file.path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = u.s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
+			Bucket:          aws.String(u.s3Bucket),
+			Key:             aws.String(key),
+			Body:            f,
+			ContentLength:   aws.Int64(file.size),
+			ContentMD5:      aws.String(base64.StdEncoding.EncodeToString(md5Bytes)),
+			ContentType:     aws.String(file.contentType),
+			CacheControl:    getOptionalHeader(file.cacheControl),
+			ContentEncoding: getOptionalHeader(file.contentEncoding),
+		})
+		return err
+	})
+}
+
+// getCopySource is the URL-encoded bucket/key CopyObject expects.
+func getCopySource(bucket, key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return bucket + "/" + strings.Join(segments, "/")
+}
+
+// copyObject replaces the headers so a changed Cache-Control rule applies
+// to files that didn't change.
+func (u *Uploader) copyObject(file localFile, sourceKey, key string) error {
+	return withThrottlingBackoff(func() error {
+		_, err := u.s3Client.CopyObject(context.TODO(), &s3.CopyObjectInput{
+			Bucket:            aws.String(u.s3Bucket),
+			Key:               aws.String(key),
+			CopySource:        aws.String(getCopySource(u.s3Bucket, sourceKey)),
+			MetadataDirective: types.MetadataDirectiveReplace,
+			ContentType:       aws.String(file.contentType),
+			CacheControl:      getOptionalHeader(file.cacheControl),
+			ContentEncoding:   getOptionalHeader(file.contentEncoding),
+		})
+		return err
+	})
+}
+
+func (u *Uploader) deleteObjects(keys []string) error {
+	for start := 0; start < len(keys); start += maxDeleteBatch {
+		end := start + maxDeleteBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		var objectIds []types.ObjectIdentifier
+		for _, key := range keys[start:end] {
+			objectIds = append(objectIds, types.ObjectIdentifier{Key: aws.String(key)})
+		}
+		err := withThrottlingBackoff(func() error {
+			deleteObjectsOutput, err := u.s3Client.DeleteObjects(context.TODO(), &s3.DeleteObjectsInput{
+				Bucket: aws.String(u.s3Bucket),
+				Delete: &types.Delete{Objects: objectIds, Quiet: aws.Bool(true)},
+			})
+			if err != nil {
+				return err
+			}
+			if len(deleteObjectsOutput.Errors) > 0 {
+				deleteError := deleteObjectsOutput.Errors[0]
+				return fmt.Errorf("error deleting %s from bucket %s: %s", aws.ToString(deleteError.Key), u.s3Bucket,
+					aws.ToString(deleteError.Message))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runSyncOperations uploads and copies through a bounded pool of workers and
+// stops handing out work after the first error.
+func (u *Uploader) runSyncOperations(operations []syncOperation, keyPrefix, baseKeyPrefix string, logsWriter io.Writer) error {
+	operationsStream := make(chan syncOperation)
+	abort := make(chan interface{})
+	var abortOnce sync.Once
+	var firstErr error
+	var logsMutex sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < syncWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for operation := range operationsStream {
+				var err error
+				if operation.action == syncCopy {
+					err = u.copyObject(operation.file, baseKeyPrefix+operation.file.key, keyPrefix+operation.file.key)
+				} else {
+					err = u.putFile(operation.file, keyPrefix+operation.file.key)
+				}
+				logsMutex.Lock()
+				if err != nil {
+					io.WriteString(logsWriter, fmt.Sprintf("Error uploading file: %s\n", operation.file.key))
+				} else if operation.action == syncUpload {
+					io.WriteString(logsWriter, fmt.Sprintf("Successfully uploaded file: %s\n", operation.file.key))
+				}
+				logsMutex.Unlock()
+				if err != nil {
+					abortOnce.Do(func() {
+						firstErr = err
+						close(abort)
+					})
+				}
+			}
+		}()
+	}
+feed:
+	for _, operation := range operations {
+		if operation.action == syncUnchanged {
+			continue
+		}
+		select {
+		case <-abort:
+			break feed
+		case operationsStream <- operation:
+		}
+	}
+	close(operationsStream)
+	wg.Wait()
+	return firstErr
+}
+
+// SyncDirectory makes keyPrefix mirror the directory. Files whose MD5 and
+// size match an object under keyPrefix are skipped, those matching an object
+// under baseKeyPrefix (empty for none) are copied inside S3, and the rest are
+// uploaded. Objects under keyPrefix that aren't in the directory are deleted.
+func (u *Uploader) SyncDirectory(directoryPath, keyPrefix, baseKeyPrefix string, options SyncOptions,
+	logsWriter io.Writer) (SyncResult, error) {
+	isDirectory, err := isPathDirectory(directoryPath)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	if !isDirectory {
+		return SyncResult{}, DirectoryErr
+	}
+	compressDirectory, err := os.MkdirTemp("", "precompressed-")
+	if err != nil {
+		return SyncResult{}, err
+	}
+	defer os.RemoveAll(compressDirectory)
+	files, err := listLocalFiles(directoryPath, compressDirectory, options)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	target, err := u.listRemoteObjects(keyPrefix)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	var base map[string]remoteObject
+	if len(baseKeyPrefix) > 0 && baseKeyPrefix != keyPrefix {
+		base, err = u.listRemoteObjects(baseKeyPrefix)
+		if err != nil {
+			return SyncResult{}, err
+		}
+	}
+
+	plan := planSync(files, target, base)
+	result := SyncResult{ChangedKeys: plan.changedKeys, Deleted: len(plan.deleteKeys)}
+	for _, operation := range plan.operations {
+		switch operation.action {
+		case syncUnchanged:
+			result.Unchanged++
+		case syncCopy:
+			result.Copied++
+		case syncUpload:
+			result.Uploaded++
+		}
+	}
+	io.WriteString(logsWriter, fmt.Sprintf("Syncing %d files: %d to upload, %d to copy, %d unchanged, %d to delete\n",
+		len(files), result.Uploaded, result.Copied, result.Unchanged, result.Deleted))
+
+	err = u.runSyncOperations(plan.operations, keyPrefix, baseKeyPrefix, logsWriter)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	var deleteKeys []string
+	for _, key := range plan.deleteKeys {
+		deleteKeys = append(deleteKeys, keyPrefix+key)
+	}
+	err = u.deleteObjects(deleteKeys)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	return result, nil
+}
@@ -0,0 +1,212 @@
+package aws_s3
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aws/smithy-go"
+)
+
+func TestPlanSync(t *testing.T) {
+	files := []localFile{
+		{key: "index.html", size: 10, md5: "new-index"},
+		{key: "app.js", size: 20, md5: "app"},
+		{key: "logo.png", size: 30, md5: "logo"},
+		{key: "new.css", size: 40, md5: "css"},
+	}
+	target := map[string]remoteObject{
+		"logo.png":  {etag: "logo", size: 30},
+		"stale.txt": {etag: "stale", size: 1},
+	}
+	base := map[string]remoteObject{
+		"index.html":   {etag: "old-index", size: 10},
+		"app.js":       {etag: "app", size: 20},
+		"logo.png":     {etag: "logo", size: 30},
+		"removed.html": {etag: "removed", size: 5},
+	}
+	plan := planSync(files, target, base)
+
+	actions := make(map[string]syncAction)
+	for _, operation := range plan.operations {
+		actions[operation.file.key] = operation.action
+	}
+	wantActions := map[string]syncAction{
+		"index.html": syncUpload,
+		"app.js":     syncCopy,
+		"logo.png":   syncUnchanged,
+		"new.css":    syncUpload,
+	}
+	if !reflect.DeepEqual(actions, wantActions) {
+		t.Errorf("actions = %v, want %v", actions, wantActions)
+	}
+	if want := []string{"stale.txt"}; !reflect.DeepEqual(plan.deleteKeys, want) {
+		t.Errorf("deleteKeys = %v, want %v", plan.deleteKeys, want)
+	}
+	if want := []string{"index.html", "new.css", "removed.html"}; !reflect.DeepEqual(plan.changedKeys, want) {
+		t.Errorf("changedKeys = %v, want %v", plan.changedKeys, want)
+	}
+}
+
+func TestPlanSync_WithoutBase(t *testing.T) {
+	files := []localFile{
+		{key: "index.html", size: 10, md5: "index"},
+		{key: "app.js", size: 21, md5: "app"},
+	}
+	target := map[string]remoteObject{
+		"index.html": {etag: "index", size: 10},
+		"app.js":     {etag: "app", size: 20},
+		"old.js":     {etag: "old", size: 1},
+	}
+	plan := planSync(files, target, nil)
+	if want := []string{"app.js", "old.js"}; !reflect.DeepEqual(plan.changedKeys, want) {
+		t.Errorf("changedKeys = %v, want %v", plan.changedKeys, want)
+	}
+	if want := []string{"old.js"}; !reflect.DeepEqual(plan.deleteKeys, want) {
+		t.Errorf("deleteKeys = %v, want %v", plan.deleteKeys, want)
+	}
+}
+
+func TestIsThrottlingError(t *testing.T) {
+	if !isThrottlingError(&smithy.GenericAPIError{Code: "SlowDown"}) {
+		t.Error("SlowDown should be retried")
+	}
+	if isThrottlingError(&smithy.GenericAPIError{Code: "AccessDenied"}) {
+		t.Error("AccessDenied should not be retried")
+	}
+	if isThrottlingError(errors.New("disk full")) {
+		t.Error("a non API error should not be retried")
+	}
+}
+
+func TestGetThrottlingBackoff(t *testing.T) {
+	for attempt := 0; attempt < 40; attempt++ {
+		backoff := getThrottlingBackoff(attempt)
+		if backoff <= 0 || backoff > maxThrottlingBackoff {
+			t.Errorf("attempt %d: backoff = %s", attempt, backoff)
+		}
+	}
+	if backoff := getThrottlingBackoff(0); backoff > baseThrottlingBackoff {
+		t.Errorf("first backoff = %s, want at most %s", backoff, baseThrottlingBackoff)
+	}
+	if backoff := getThrottlingBackoff(30); backoff < maxThrottlingBackoff/2 {
+		t.Errorf("late backoff = %s, want at least %s", backoff, maxThrottlingBackoff/2)
+	}
+}
+
+func TestGetCopySource(t *testing.T) {
+	if copySource := getCopySource("bucket", "builds/b1/my file+1.html"); copySource != "bucket/builds/b1/my%20file+1.html" {
+		t.Errorf("copySource = %s", copySource)
+	}
+}
+
+func TestListLocalFiles(t *testing.T) {
+	directory := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(directory, "css"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(directory, "index.html"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(directory, "css", "empty.css"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	files, err := listLocalFiles(directory, t.TempDir(), SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	md5s := make(map[string]string)
+	for _, file := range files {
+		md5s[file.key] = file.md5
+	}
+	want := map[string]string{
+		"index.html":    "5d41402abc4b2a76b9719d911017c592",
+		"css/empty.css": "d41d8cd98f00b204e9800998ecf8427e",
+	}
+	if !reflect.DeepEqual(md5s, want) {
+		t.Errorf("md5s = %v, want %v", md5s, want)
+	}
+}
+
+func TestListLocalFiles_Exclude(t *testing.T) {
+	directory := t.TempDir()
+	for _, name := range []string{"index.html", "_redirects"} {
+		if err := os.WriteFile(filepath.Join(directory, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := listLocalFiles(directory, t.TempDir(), SyncOptions{Exclude: func(key string) bool {
+		return key == "_redirects"
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].key != "index.html" {
+		t.Errorf("files = %v, want only index.html", files)
+	}
+}
+
+func TestListLocalFiles_Precompress(t *testing.T) {
+	directory := t.TempDir()
+	content := []byte("<html><body>hello hello hello hello</body></html>")
+	if err := os.WriteFile(filepath.Join(directory, "index.html"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(directory, "index.html.gz"), []byte("built by the bundler"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(directory, "logo.png"), []byte("\x89PNG"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	options := SyncOptions{
+		CacheControl: func(key string) string {
+			if key == "index.html" {
+				return "no-cache"
+			}
+			return ""
+		},
+		Precompress: []string{EncodingBrotli, EncodingGzip},
+	}
+	files, err := listLocalFiles(directory, t.TempDir(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byKey := make(map[string]localFile)
+	var keys []string
+	for _, file := range files {
+		byKey[file.key] = file
+		keys = append(keys, file.key)
+	}
+	if want := []string{"index.html", "index.html.br", "index.html.gz", "logo.png"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("keys = %v, want %v", keys, want)
+	}
+	for _, key := range []string{"index.html.br", "index.html.gz"} {
+		variant := byKey[key]
+		if variant.contentType != "text/html" || variant.cacheControl != "no-cache" || variant.contentEncoding == "" {
+			t.Errorf("%s = %+v", key, variant)
+		}
+		info, err := os.Stat(variant.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if variant.size != info.Size() || variant.sourcePath != filepath.Join(directory, "index.html") {
+			t.Errorf("%s: size %d for %d bytes compressed from %s", key, variant.size, info.Size(), variant.sourcePath)
+		}
+	}
+	if byKey["logo.png"].contentEncoding != "" || byKey["logo.png"].cacheControl != "" {
+		t.Errorf("logo.png = %+v", byKey["logo.png"])
+	}
+
+	//unchanged files have to keep their MD5 so they aren't uploaded again
+	again, err := listLocalFiles(directory, t.TempDir(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range files {
+		if files[i].md5 != again[i].md5 {
+			t.Errorf("%s: md5 changed between runs", files[i].key)
+		}
+	}
+}